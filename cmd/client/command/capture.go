@@ -0,0 +1,358 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/megaease/easegress/pkg/object/httpserver/capture"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/util/har"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const redactedValue = "[REDACTED]"
+
+// CaptureCmd defines capture command.
+func CaptureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Capture and replay the traffic of HTTPServers",
+	}
+
+	cmd.AddCommand(startCaptureCmd())
+	cmd.AddCommand(listCapturesCmd())
+	cmd.AddCommand(getCaptureCmd())
+	cmd.AddCommand(stopCaptureCmd())
+	cmd.AddCommand(deleteCaptureCmd())
+	cmd.AddCommand(replayCaptureCmd())
+
+	return cmd
+}
+
+func httpServerAndIDArgs(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("requires HTTPServer name and capture id")
+	}
+	return nil
+}
+
+// parseKeyValues parses values in format key=value.
+func parseKeyValues(values []string) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, kv := range values {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid key value pair %s, should be key=value", kv)
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+func startCaptureCmd() *cobra.Command {
+	var specFile string
+	var headers []string
+	spec := &capture.Spec{}
+
+	cmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start to capture the traffic of an HTTPServer in the member",
+		Example: `egctl capture start <httpserver_name> --backend <pipeline_name> --header X-Debug=true --duration 10m
+egctl capture start <httpserver_name> -f <capture_spec.yaml>`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one HTTPServer name")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			var body []byte
+			if specFile != "" {
+				var err error
+				body, err = os.ReadFile(specFile)
+				if err != nil {
+					ExitWithErrorf("%s failed: %v", cmd.Short, err)
+				}
+			} else {
+				exacts, err := parseKeyValues(headers)
+				if err != nil {
+					ExitWithErrorf("%s failed: %v", cmd.Short, err)
+				}
+				if len(exacts) > 0 {
+					spec.Headers = make(map[string]*stringtool.StringMatcher, len(exacts))
+					for k, v := range exacts {
+						spec.Headers[k] = &stringtool.StringMatcher{Exact: v}
+					}
+				}
+				body = codectool.MustMarshalJSON(spec)
+			}
+
+			handleRequest(http.MethodPost, makeURL(capturesURL, args[0]), body, cmd)
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the capture, other flags are ignored if it is specified.")
+	cmd.Flags().StringSliceVar(&spec.Backends, "backend", nil, "Only capture requests routed to the pipelines.")
+	cmd.Flags().StringVar(&spec.PathPrefix, "path-prefix", "", "Only capture requests with the path prefix.")
+	cmd.Flags().StringSliceVar(&spec.Methods, "method", nil, "Only capture requests with the methods.")
+	cmd.Flags().StringArrayVar(&headers, "header", nil, "Only capture requests with the header, in format key=value.")
+	cmd.Flags().StringVar(&spec.Duration, "duration", "", "Stop capturing after the duration, default to 5m.")
+	cmd.Flags().IntVar(&spec.MaxCount, "max-count", 0, "Stop capturing after the number of requests, default to 100.")
+	cmd.Flags().Int64Var(&spec.MaxBodySize, "max-body-size", 0, "Truncate larger bodies to the size, default to 64KB.")
+	cmd.Flags().StringSliceVar(&spec.RedactHeaders, "redact-header", nil, "Redact the headers in addition to the default ones.")
+
+	return cmd
+}
+
+func listCapturesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List the captures of an HTTPServer in the member",
+		Example: "egctl capture list <httpserver_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one HTTPServer name")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(capturesURL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func getCaptureCmd() *cobra.Command {
+	var outputFile string
+	cmd := &cobra.Command{
+		Use:     "get",
+		Short:   "Download a capture in HAR format",
+		Example: "egctl capture get <httpserver_name> <capture_id> --file <capture.har>",
+		Args:    httpServerAndIDArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			body := sendRequest(http.MethodGet, makeURL(captureURL, args[0], args[1]), nil, cmd)
+			if outputFile == "" {
+				printBody(body)
+				return
+			}
+
+			if err := os.WriteFile(outputFile, body, 0o644); err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "file", "f", "", "The file to save the capture, it is printed if not specified.")
+
+	return cmd
+}
+
+func stopCaptureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "stop",
+		Short:   "Stop a capture, the captured traffic is kept",
+		Example: "egctl capture stop <httpserver_name> <capture_id>",
+		Args:    httpServerAndIDArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodPut, makeURL(captureStopURL, args[0], args[1]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func deleteCaptureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete",
+		Short:   "Stop and delete a capture",
+		Example: "egctl capture delete <httpserver_name> <capture_id>",
+		Args:    httpServerAndIDArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodDelete, makeURL(captureURL, args[0], args[1]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+type replayer struct {
+	target     string
+	httpServer string
+	pipeline   string
+	headers    map[string]string
+	client     *http.Client
+	cmd        *cobra.Command
+}
+
+func replayCaptureCmd() *cobra.Command {
+	var headers []string
+	var timeout time.Duration
+	opts := &har.CompareOptions{}
+	r := &replayer{}
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Replay a capture and report the responses mismatching the captured ones",
+		Example: `egctl capture replay <capture.har> --target http://<member_address>:10080
+egctl capture replay <capture.har> --httpserver <httpserver_name> --pipeline <pipeline_name>`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one HAR file")
+			}
+			if (r.target == "") == (r.pipeline == "") {
+				return errors.New("requires one of --target and --pipeline")
+			}
+			if r.pipeline != "" && r.httpServer == "" {
+				return errors.New("--httpserver is required to replay against a pipeline")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			h, err := har.Load(data)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+
+			r.headers, err = parseKeyValues(headers)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			r.cmd = cmd
+			r.client = &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: CommandlineGlobalFlags.InsecureSkipVerify},
+				},
+				// The captured responses are the ones before redirecting.
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+
+			failed := 0
+			for i, entry := range h.Log.Entries {
+				if entry.Request == nil || entry.Response == nil {
+					continue
+				}
+
+				title := fmt.Sprintf(" #%d %s %s", i, entry.Request.Method, entry.Request.URL)
+				actual, err := r.replay(entry.Request)
+				var mismatches []string
+				if err != nil {
+					mismatches = []string{err.Error()}
+				} else {
+					mismatches = har.Compare(entry.Response, actual, opts)
+				}
+
+				if len(mismatches) == 0 {
+					color.New(color.FgGreen).Print("MATCH")
+					fmt.Println(title)
+					continue
+				}
+
+				failed++
+				color.New(color.FgRed).Print("MISMATCH")
+				fmt.Println(title)
+				for _, m := range mismatches {
+					fmt.Printf("    %s\n", m)
+				}
+			}
+
+			if failed > 0 {
+				ExitWithErrorf("%d of %d requests mismatched", failed, len(h.Log.Entries))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&r.target, "target", "", "The address to send the requests to, e.g. http://127.0.0.1:10080.")
+	cmd.Flags().StringVar(&r.httpServer, "httpserver", "", "The HTTPServer in the member to replay the requests.")
+	cmd.Flags().StringVar(&r.pipeline, "pipeline", "", "The pipeline to handle the requests, the member is the one of --server.")
+	cmd.Flags().StringArrayVar(&headers, "header", nil, "Set the header of the requests in format key=value, e.g. to replace redacted ones.")
+	cmd.Flags().StringSliceVar(&opts.Headers, "compare-header", nil, "The response headers to compare, headers are not compared by default.")
+	cmd.Flags().BoolVar(&opts.IgnoreBody, "ignore-body", false, "Don't compare the response bodies.")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "The timeout of each request sent to the target.")
+
+	return cmd
+}
+
+// prepare removes the redacted headers, and sets the ones from command line.
+func (r *replayer) prepare(req *har.Request) *har.Request {
+	overridden := make(map[string]struct{}, len(r.headers))
+	for k := range r.headers {
+		overridden[http.CanonicalHeaderKey(k)] = struct{}{}
+	}
+
+	clone := *req
+	clone.Headers = nil
+	for _, nv := range req.Headers {
+		if _, ok := overridden[http.CanonicalHeaderKey(nv.Name)]; ok || nv.Value == redactedValue {
+			continue
+		}
+		clone.Headers = append(clone.Headers, nv)
+	}
+	for k, v := range r.headers {
+		clone.Headers = append(clone.Headers, &har.NameValue{Name: k, Value: v})
+	}
+	return &clone
+}
+
+func (r *replayer) replay(req *har.Request) (*har.Response, error) {
+	req = r.prepare(req)
+
+	if r.pipeline != "" {
+		body := sendRequest(http.MethodPost, makeURL(replayURL, r.httpServer, r.pipeline), codectool.MustMarshalJSON(req), r.cmd)
+		resp := &har.Response{}
+		if err := codectool.UnmarshalJSON(body, resp); err != nil {
+			return nil, fmt.Errorf("unmarshal response failed: %v", err)
+		}
+		return resp, nil
+	}
+
+	stdr, err := req.StdRequest(r.target)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(stdr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return har.NewResponse(resp, nil)
+}
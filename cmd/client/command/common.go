@@ -19,6 +19,7 @@
 package command
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
 	"fmt"
@@ -64,9 +65,19 @@ const (
 	statusObjectURL  = apiURL + "/status/objects/%s"
 	statusObjectsURL = apiURL + "/status/objects"
 
+	watchObjectsURL = apiURL + "/watch/objects"
+
+	capturesURL    = apiURL + "/httpservers/%s/captures"
+	captureURL     = apiURL + "/httpservers/%s/captures/%s"
+	captureStopURL = apiURL + "/httpservers/%s/captures/%s/stop"
+	replayURL      = apiURL + "/httpservers/%s/replay/%s"
+
 	wasmCodeURL = apiURL + "/wasm/code"
 	wasmDataURL = apiURL + "/wasm/data/%s/%s"
 
+	schedulesURL = apiURL + "/schedules"
+	scheduleURL  = apiURL + "/schedules/%s"
+
 	customDataKindURL     = apiURL + "/customdatakinds"
 	customDataKindItemURL = apiURL + "/customdatakinds/%s"
 	customDataURL         = apiURL + "/customdata/%s"
@@ -133,6 +144,15 @@ func successfulStatusCode(code int) bool {
 }
 
 func handleRequest(httpMethod string, url string, yamlBody []byte, cmd *cobra.Command) {
+	body := sendRequest(httpMethod, url, yamlBody, cmd)
+	if len(body) != 0 {
+		printBody(body)
+	}
+}
+
+// sendRequest sends the request and returns the response body, it exits
+// if the request failed.
+func sendRequest(httpMethod string, url string, yamlBody []byte, cmd *cobra.Command) []byte {
 	var jsonBody []byte
 	if yamlBody != nil {
 		var err error
@@ -166,9 +186,7 @@ func handleRequest(httpMethod string, url string, yamlBody []byte, cmd *cobra.Co
 		ExitWithErrorf("%d: %s", apiErr.Code, msg)
 	}
 
-	if len(body) != 0 {
-		printBody(body)
-	}
+	return body
 }
 
 func doRequest(httpMethod string, url string, jsonBody []byte, client *http.Client, cmd *cobra.Command) (*http.Response, []byte) {
@@ -189,6 +207,50 @@ func doRequest(httpMethod string, url string, jsonBody []byte, client *http.Clie
 	return resp, body
 }
 
+// handleStreamRequest sends a GET request and calls handler for
+// every line of the response body until the stream ends.
+func handleStreamRequest(url string, cmd *cobra.Command, handler func(line []byte)) {
+	p := HTTPProtocol
+	if CommandlineGlobalFlags.ForceTLS {
+		p = HTTPSProtocol
+	}
+	tr := http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: CommandlineGlobalFlags.InsecureSkipVerify},
+	}
+	client := &http.Client{Transport: &tr}
+
+	resp, err := client.Get(p + url)
+	if err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+	defer resp.Body.Close()
+
+	if !successfulStatusCode(resp.StatusCode) {
+		body, _ := io.ReadAll(resp.Body)
+		msg := string(body)
+		apiErr := &APIErr{}
+		err := codectool.Unmarshal(body, apiErr)
+		if err == nil {
+			msg = apiErr.Message
+		}
+		ExitWithErrorf("%d: %s", resp.StatusCode, msg)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		handler(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		ExitWithErrorf("%s failed: %v", cmd.Short, err)
+	}
+}
+
 func printBody(body []byte) {
 	var output []byte
 	switch CommandlineGlobalFlags.OutputFormat {
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/spf13/cobra"
 )
@@ -40,6 +41,7 @@ func ObjectCmd() *cobra.Command {
 	cmd.AddCommand(updateObjectCmd())
 	cmd.AddCommand(deleteObjectCmd())
 	cmd.AddCommand(statusObjectCmd())
+	cmd.AddCommand(watchObjectsCmd())
 
 	return cmd
 }
@@ -222,3 +224,56 @@ func listStatusObjectsCmd() *cobra.Command {
 
 	return cmd
 }
+
+func watchObjectsCmd() *cobra.Command {
+	var kind, version, statusInterval string
+	cmd := &cobra.Command{
+		Use:     "watch",
+		Short:   "Watch changes of objects and their status",
+		Example: "egctl object watch [<object_name>] [--kind <kind>] [--version <config_version>]",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("requires at most one object name to be watched")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if len(args) == 1 {
+				query.Set("name", args[0])
+			}
+			if kind != "" {
+				query.Set("kind", kind)
+			}
+			if version != "" {
+				query.Set("version", version)
+			}
+			if statusInterval != "" {
+				query.Set("statusInterval", statusInterval)
+			}
+
+			u := makeURL(watchObjectsURL)
+			if len(query) != 0 {
+				u += "?" + query.Encode()
+			}
+
+			handleStreamRequest(u, cmd, func(line []byte) {
+				if CommandlineGlobalFlags.OutputFormat == "yaml" {
+					fmt.Println("---")
+				}
+				printBody(line)
+				if CommandlineGlobalFlags.OutputFormat == "json" {
+					fmt.Println()
+				}
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&kind, "kind", "k", "", "Only watch objects of the kind.")
+	cmd.Flags().StringVarP(&version, "version", "", "", "Resume watching from the config version, a RESET event is sent if the config has changed since then.")
+	cmd.Flags().StringVarP(&statusInterval, "status-interval", "", "", "The interval to check status changes, 0 to disable.")
+
+	return cmd
+}
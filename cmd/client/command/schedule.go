@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ScheduleCmd defines schedule command.
+func ScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "View and change schedules which apply object specs in time windows",
+	}
+
+	cmd.AddCommand(createScheduleCmd())
+	cmd.AddCommand(updateScheduleCmd())
+	cmd.AddCommand(deleteScheduleCmd())
+	cmd.AddCommand(getScheduleCmd())
+	cmd.AddCommand(listSchedulesCmd())
+
+	return cmd
+}
+
+func createScheduleCmd() *cobra.Command {
+	var specFile string
+	cmd := &cobra.Command{
+		Use:     "create",
+		Short:   "Create a schedule from a yaml file or stdin",
+		Example: "egctl schedule create -f <schedule.yaml>",
+		Run: func(cmd *cobra.Command, args []string) {
+			visitor := buildYAMLVisitor(specFile, cmd)
+			visitor.Visit(func(yamlDoc []byte) error {
+				handleRequest(http.MethodPost, makeURL(schedulesURL), yamlDoc, cmd)
+				return nil
+			})
+			visitor.Close()
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the schedule.")
+
+	return cmd
+}
+
+func updateScheduleCmd() *cobra.Command {
+	var specFile string
+	cmd := &cobra.Command{
+		Use:     "update",
+		Short:   "Update a schedule from a yaml file or stdin",
+		Example: "egctl schedule update -f <schedule.yaml>",
+		Run: func(cmd *cobra.Command, args []string) {
+			visitor := buildYAMLVisitor(specFile, cmd)
+			visitor.Visit(func(yamlDoc []byte) error {
+				s := struct {
+					Name string `json:"name"`
+				}{}
+				if err := yaml.Unmarshal(yamlDoc, &s); err != nil {
+					ExitWithErrorf("error parsing %s: %v", yamlDoc, err)
+				}
+				if s.Name == "" {
+					ExitWithErrorf("name is empty: %s", yamlDoc)
+				}
+				handleRequest(http.MethodPut, makeURL(scheduleURL, s.Name), yamlDoc, cmd)
+				return nil
+			})
+			visitor.Close()
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the schedule.")
+
+	return cmd
+}
+
+func deleteScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "delete",
+		Short:   "Delete a schedule, the object is reverted if the schedule is active",
+		Example: "egctl schedule delete <schedule_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one schedule name to be deleted")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodDelete, makeURL(scheduleURL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func getScheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "get",
+		Short:   "Get a schedule and its status",
+		Example: "egctl schedule get <schedule_name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("requires one schedule name to be retrieved")
+			}
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(scheduleURL, args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func listSchedulesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List all schedules and their status",
+		Example: "egctl schedule list",
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(schedulesURL), nil, cmd)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/pipeline/pipelinetest"
+
+	// Filters used by the pipelines under test.
+	_ "github.com/megaease/easegress/pkg/registry/filters"
+)
+
+// TestCmd defines test command.
+func TestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Test objects offline",
+	}
+
+	cmd.AddCommand(testPipelineCmd())
+
+	return cmd
+}
+
+func testPipelineCmd() *cobra.Command {
+	var specFile, fixtureFile string
+	cmd := &cobra.Command{
+		Use:     "pipeline",
+		Short:   "Test pipelines offline with the cases in a fixture file",
+		Example: "egctl test pipeline -f <pipeline_spec.yaml> --fixture <fixture.yaml>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if fixtureFile == "" {
+				return errors.New("--fixture is required")
+			}
+
+			return nil
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.InitNop()
+
+			var specs []string
+			visitor := buildSpecVisitor(specFile, cmd)
+			visitor.Visit(func(s *spec) error {
+				specs = append(specs, s.doc)
+				return nil
+			})
+			visitor.Close()
+
+			h, err := pipelinetest.New(specs...)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+
+			data, err := os.ReadFile(fixtureFile)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+			fixture, err := pipelinetest.LoadFixture(data)
+			if err != nil {
+				ExitWithErrorf("%s failed: %v", cmd.Short, err)
+			}
+
+			failed := 0
+			for _, cr := range h.RunFixture(fixture) {
+				if cr.Passed() {
+					color.New(color.FgGreen).Print("PASS")
+					fmt.Printf(" %s: %s\n", cr.Name, cr.Path())
+					continue
+				}
+
+				failed++
+				color.New(color.FgRed).Print("FAIL")
+				fmt.Printf(" %s: %s\n", cr.Name, cr.Path())
+				for _, f := range cr.Failures {
+					fmt.Printf("    %s\n", f)
+				}
+			}
+
+			if failed > 0 {
+				ExitWithErrorf("%d of %d cases failed", failed, len(fixture.Cases))
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&specFile, "file", "f", "", "A yaml file specifying the pipelines.")
+	cmd.Flags().StringVarP(&fixtureFile, "fixture", "", "", "A yaml file specifying the test cases.")
+
+	return cmd
+}
@@ -71,6 +71,25 @@ var exampleUsage = `  # List APIs.
 
   # Get object status
   egctl object status get <object_name>
+
+  # Watch changes of objects and their status
+  egctl object watch [<object_name>] [--kind <kind>]
+
+  # Test pipelines offline with the cases in a fixture file
+  egctl test pipeline -f <pipeline_spec.yaml> --fixture <fixture.yaml>
+
+  # Capture the traffic of an HTTPServer and download it in HAR format
+  egctl capture start <httpserver_name> --header X-Debug=true --duration 10m
+  egctl capture get <httpserver_name> <capture_id> --file <capture.har>
+
+  # Replay a capture against a pipeline and report mismatches
+  egctl capture replay <capture.har> --httpserver <httpserver_name> --pipeline <pipeline_name>
+
+  # Create a schedule to apply an object spec in time windows
+  egctl schedule create -f <schedule.yaml>
+
+  # List schedules and their status
+  egctl schedule list
 `
 
 func main() {
@@ -114,6 +133,9 @@ func main() {
 		command.CustomDataKindCmd(),
 		command.CustomDataCmd(),
 		command.ProfileCmd(),
+		command.TestCmd(),
+		command.CaptureCmd(),
+		command.ScheduleCmd(),
 		completionCmd,
 	)
 
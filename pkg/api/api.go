@@ -42,8 +42,6 @@ const (
 	// APIPrefixV2 is the prefix of v2 api.
 	APIPrefixV2 = "/apis/v2"
 
-	lockKey = "/config/lock"
-
 	// ConfigVersionKey is the key of header for config version.
 	ConfigVersionKey = "X-Config-Version"
 )
@@ -101,10 +99,12 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.listAPIEntries()...)
 	group.Entries = append(group.Entries, s.memberAPIEntries()...)
 	group.Entries = append(group.Entries, s.objectAPIEntries()...)
+	group.Entries = append(group.Entries, s.watchAPIEntries()...)
 	group.Entries = append(group.Entries, s.metadataAPIEntries()...)
 	group.Entries = append(group.Entries, s.healthAPIEntries()...)
 	group.Entries = append(group.Entries, s.aboutAPIEntries()...)
 	group.Entries = append(group.Entries, s.customDataAPIEntries()...)
+	group.Entries = append(group.Entries, s.scheduleAPIEntries()...)
 	group.Entries = append(group.Entries, s.profileAPIEntries()...)
 	group.Entries = append(group.Entries, s.prometheusMetricsAPIEntries()...)
 
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/megaease/easegress/pkg/cluster/schedule"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/v"
+)
+
+const (
+	// SchedulePrefix is the URL prefix of APIs for schedules.
+	SchedulePrefix = "/schedules"
+)
+
+type (
+	// ScheduleInfo is a schedule with its status.
+	ScheduleInfo struct {
+		*schedule.Schedule
+		Status *ScheduleStatus `json:"status"`
+	}
+
+	// ScheduleStatus is the status of a schedule.
+	ScheduleStatus struct {
+		Phase              string `json:"phase"`
+		WindowStart        string `json:"windowStart,omitempty"`
+		WindowEnd          string `json:"windowEnd,omitempty"`
+		NextStart          string `json:"nextStart,omitempty"`
+		NextEnd            string `json:"nextEnd,omitempty"`
+		LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+		Error              string `json:"error,omitempty"`
+		Conflict           string `json:"conflict,omitempty"`
+	}
+)
+
+func (s *Server) scheduleAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    SchedulePrefix,
+			Method:  http.MethodGet,
+			Handler: s.listSchedules,
+		},
+		{
+			Path:    SchedulePrefix,
+			Method:  http.MethodPost,
+			Handler: s.createSchedule,
+		},
+		{
+			Path:    SchedulePrefix + "/{name}",
+			Method:  http.MethodGet,
+			Handler: s.getSchedule,
+		},
+		{
+			Path:    SchedulePrefix + "/{name}",
+			Method:  http.MethodPut,
+			Handler: s.updateSchedule,
+		},
+		{
+			Path:    SchedulePrefix + "/{name}",
+			Method:  http.MethodDelete,
+			Handler: s.deleteSchedule,
+		},
+	}
+}
+
+func newScheduleInfo(sched *schedule.Schedule, state *schedule.State, now time.Time) *ScheduleInfo {
+	status := &ScheduleStatus{Phase: schedule.PhasePending}
+	if state != nil {
+		status.Phase = state.Phase
+		status.LastTransitionTime = state.LastTransitionTime
+		status.Error, status.Conflict = state.Error, state.Conflict
+		status.WindowStart, status.WindowEnd = state.WindowStart, state.WindowEnd
+	}
+
+	if status.Phase == schedule.PhasePending {
+		start, end, ok := sched.Window(now)
+		if ok {
+			status.NextStart = start.Format(time.RFC3339)
+			if !end.IsZero() {
+				status.NextEnd = end.Format(time.RFC3339)
+			}
+		}
+	}
+
+	return &ScheduleInfo{Schedule: sched, Status: status}
+}
+
+func (s *Server) readSchedule(r *http.Request) (*schedule.Schedule, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+
+	sched := &schedule.Schedule{}
+	if err = codectool.Unmarshal(body, sched); err != nil {
+		return nil, fmt.Errorf("unmarshal schedule failed: %v", err)
+	}
+	if vr := v.Validate(sched); !vr.Valid() {
+		return nil, fmt.Errorf("validate failed: \n%s", vr.Error())
+	}
+
+	// Validate the spec as an object.
+	if _, err = s.super.NewSpec(string(codectool.MustMarshalJSON(sched.Spec))); err != nil {
+		return nil, fmt.Errorf("invalid spec: %v", err)
+	}
+
+	name := chi.URLParam(r, "name")
+	if name != "" && name != sched.Name {
+		return nil, fmt.Errorf("inconsistent name in url and schedule")
+	}
+
+	return sched, nil
+}
+
+func (s *Server) listSchedules(w http.ResponseWriter, r *http.Request) {
+	schedules, err := s.schedules.ListSchedules()
+	if err != nil {
+		ClusterPanic(err)
+	}
+	states, err := s.schedules.ListStates()
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	now := time.Now()
+	result := make([]*ScheduleInfo, 0, len(schedules))
+	for name, sched := range schedules {
+		result = append(result, newScheduleInfo(sched, states[name], now))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	WriteBody(w, r, result)
+}
+
+func (s *Server) getSchedule(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	sched, err := s.schedules.GetSchedule(name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if sched == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	state, err := s.schedules.GetState(name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	WriteBody(w, r, newScheduleInfo(sched, state, time.Now()))
+}
+
+func (s *Server) createSchedule(w http.ResponseWriter, r *http.Request) {
+	sched, err := s.readSchedule(r)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	existed, err := s.schedules.GetSchedule(sched.Name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if existed != nil {
+		HandleAPIError(w, r, http.StatusConflict, fmt.Errorf("conflict name: %s", sched.Name))
+		return
+	}
+
+	if err = s.schedules.PutSchedule(sched); err != nil {
+		ClusterPanic(err)
+	}
+
+	location := fmt.Sprintf("%s/%s", r.URL.Path, sched.Name)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
+	sched, err := s.readSchedule(r)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	existed, err := s.schedules.GetSchedule(sched.Name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if existed == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	if err = s.schedules.PutSchedule(sched); err != nil {
+		ClusterPanic(err)
+	}
+}
+
+func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	s.Lock()
+	defer s.Unlock()
+
+	existed, err := s.schedules.GetSchedule(name)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if existed == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+
+	// The object is reverted by the leader if the schedule is active.
+	if err = s.schedules.DeleteSchedule(name); err != nil {
+		ClusterPanic(err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/cluster/schedule"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/option"
 	pprof "github.com/megaease/easegress/pkg/profile"
@@ -34,16 +35,21 @@ import (
 type (
 	// Server is the api server.
 	Server struct {
-		opt     *option.Options
-		server  http.Server
-		router  *dynamicMux
-		cluster cluster.Cluster
-		super   *supervisor.Supervisor
-		cds     *customdata.Store
-		profile pprof.Profile
+		opt       *option.Options
+		server    http.Server
+		router    *dynamicMux
+		cluster   cluster.Cluster
+		super     *supervisor.Supervisor
+		cds       *customdata.Store
+		schedules *schedule.Store
+		profile   pprof.Profile
 
 		mutex      cluster.Mutex
 		mutexMutex sync.Mutex
+
+		// done is closed when the server is shutting down,
+		// to terminate the long-lived streams.
+		done chan struct{}
 	}
 
 	// Group is the API group
@@ -67,18 +73,21 @@ func MustNewServer(opt *option.Options, cls cluster.Cluster, super *supervisor.S
 		cluster: cls,
 		super:   super,
 		profile: profile,
+		done:    make(chan struct{}),
 	}
 	s.router = newDynamicMux(s)
 	s.server = http.Server{Addr: opt.APIAddr, Handler: s.router}
+	s.server.RegisterOnShutdown(func() { close(s.done) })
 
 	_, err := s.getMutex()
 	if err != nil {
-		logger.Errorf("get cluster mutex %s failed: %v", lockKey, err)
+		logger.Errorf("get cluster mutex %s failed: %v", cls.Layout().ConfigLock(), err)
 	}
 
 	kindPrefix := cls.Layout().CustomDataKindPrefix()
 	dataPrefix := cls.Layout().CustomDataPrefix()
 	s.cds = customdata.NewStore(cls, kindPrefix, dataPrefix)
+	s.schedules = schedule.NewStore(cls)
 
 	s.registerAPIs()
 
@@ -123,7 +132,7 @@ func (s *Server) getMutex() (cluster.Mutex, error) {
 		return s.mutex, nil
 	}
 
-	mutex, err := s.cluster.Mutex(lockKey)
+	mutex, err := s.cluster.Mutex(s.cluster.Layout().ConfigLock())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,453 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// WatchObjectPrefix is the prefix of the object watch stream.
+	WatchObjectPrefix = "/watch/objects"
+
+	defaultWatchStatusInterval = 5 * time.Second
+	minWatchStatusInterval     = 1 * time.Second
+)
+
+const (
+	// WatchEventCreate means the object was created.
+	WatchEventCreate WatchEventType = "CREATE"
+	// WatchEventUpdate means the object was updated.
+	WatchEventUpdate WatchEventType = "UPDATE"
+	// WatchEventDelete means the object was deleted.
+	WatchEventDelete WatchEventType = "DELETE"
+	// WatchEventSync means all objects before it have been sent,
+	// the client could save the version to resume the stream.
+	WatchEventSync WatchEventType = "SYNC"
+	// WatchEventReset means the config has been changed since the version
+	// the stream resumes from, the client should drop the objects it knows,
+	// because all the existing objects are sent again after it.
+	WatchEventReset WatchEventType = "RESET"
+	// WatchEventStatus means the status of the object in a member changed,
+	// an empty status means the status was removed.
+	WatchEventStatus WatchEventType = "STATUS"
+)
+
+type (
+	// WatchEventType is the type of watch event.
+	WatchEventType string
+
+	// WatchEvent is the event sent in the object watch stream.
+	WatchEvent struct {
+		Type    WatchEventType         `json:"type"`
+		Version int64                  `json:"version"`
+		Name    string                 `json:"name,omitempty"`
+		Kind    string                 `json:"kind,omitempty"`
+		Spec    map[string]interface{} `json:"spec,omitempty"`
+		Member  string                 `json:"member,omitempty"`
+		Status  map[string]interface{} `json:"status,omitempty"`
+	}
+
+	watchFilter struct {
+		kind string
+		name string
+	}
+
+	watchStream struct {
+		w       http.ResponseWriter
+		flusher http.Flusher
+		sse     bool
+	}
+)
+
+func (s *Server) watchAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    WatchObjectPrefix,
+			Method:  "GET",
+			Handler: s.watchObjects,
+		},
+	}
+}
+
+func (f *watchFilter) match(name, kind string) bool {
+	if f.name != "" && f.name != name {
+		return false
+	}
+	if f.kind != "" && f.kind != kind {
+		return false
+	}
+	return true
+}
+
+func (ws *watchStream) send(event *WatchEvent) error {
+	buff, err := codectool.MarshalJSON(event)
+	if err != nil {
+		return fmt.Errorf("marshal %#v to json failed: %v", event, err)
+	}
+
+	if ws.sse {
+		_, err = fmt.Fprintf(ws.w, "event: %s\ndata: %s\n\n", event.Type, buff)
+	} else {
+		_, err = fmt.Fprintf(ws.w, "%s\n", buff)
+	}
+	if err != nil {
+		return err
+	}
+
+	ws.flusher.Flush()
+	return nil
+}
+
+// parseStatusKey parses the key trimmed from the status object prefix,
+// whose format is namespace/objectName/memberName.
+func parseStatusKey(key string) (namespace, name, member string, ok bool) {
+	first := strings.Index(key, "/")
+	last := strings.LastIndex(key, "/")
+	if first < 0 || first == last {
+		return "", "", "", false
+	}
+
+	return key[:first], key[first+1 : last], key[last+1:], true
+}
+
+func newSpecEvent(eventType WatchEventType, version int64, spec *supervisor.Spec) (*WatchEvent, error) {
+	m := map[string]interface{}{}
+	err := codectool.Unmarshal([]byte(spec.JSONConfig()), &m)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s to json failed: %v", spec.JSONConfig(), err)
+	}
+
+	return &WatchEvent{
+		Type:    eventType,
+		Version: version,
+		Name:    spec.Name(),
+		Kind:    spec.Kind(),
+		Spec:    m,
+	}, nil
+}
+
+func (s *Server) getVersion() (int64, error) {
+	value, err := s.cluster.Get(s.cluster.Layout().ConfigVersion())
+	if err != nil {
+		return 0, err
+	}
+
+	if value == nil {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(*value, 10, 64)
+}
+
+// watchObjects streams the changes of objects and their status.
+//
+// Query parameters:
+//   - kind: only watch objects of the kind.
+//   - name: only watch the object of the name.
+//   - version: resume from the config version, the initial objects are
+//     skipped if the config hasn't been changed since then, otherwise they
+//     are sent after a RESET event.
+//   - statusInterval: the interval to check status changes, 0 to disable.
+//
+// The stream is Server-Sent Events if the client accepts text/event-stream,
+// otherwise it is newline delimited JSON.
+func (s *Server) watchObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &watchFilter{
+		kind: query.Get("kind"),
+		name: query.Get("name"),
+	}
+
+	resumeVersion := int64(-1)
+	if v := query.Get("version"); v != "" {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid version %s: %v", v, err))
+			return
+		}
+		resumeVersion = version
+	}
+
+	statusInterval := defaultWatchStatusInterval
+	if v := query.Get("statusInterval"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid statusInterval %s: %v", v, err))
+			return
+		}
+		if interval != 0 && interval < minWatchStatusInterval {
+			interval = minWatchStatusInterval
+		}
+		statusInterval = interval
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	// NOTE: Create the watcher before listing objects,
+	// so that no change could be lost between them.
+	watcher, err := s.cluster.Watcher()
+	if err != nil {
+		ClusterPanic(err)
+	}
+	defer watcher.Close()
+
+	prefix := s.cluster.Layout().ConfigObjectPrefix()
+	objectChan, err := watcher.WatchPrefix(prefix)
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	version := s._getVersion()
+	specs := make(map[string]*supervisor.Spec)
+	for _, spec := range s._listObjects() {
+		specs[spec.Name()] = spec
+	}
+
+	ws := &watchStream{
+		w:       w,
+		flusher: flusher,
+		sse:     strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	if ws.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if sendInitialEvents(ws, filter, specs, version, resumeVersion) != nil {
+		return
+	}
+
+	var tickerChan <-chan time.Time
+	if statusInterval > 0 {
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		tickerChan = ticker.C
+	}
+	lastStatus := make(map[string]string)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case kvs, ok := <-objectChan:
+			if !ok {
+				logger.Errorf("watch objects: watcher of %s closed", prefix)
+				return
+			}
+			if s.sendObjectEvents(ws, filter, specs, prefix, kvs) != nil {
+				return
+			}
+		case <-tickerChan:
+			if s.sendStatusEvents(ws, filter, specs, lastStatus) != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendInitialEvents sends the existing objects and the SYNC event. The
+// objects are skipped if the stream resumes from the current version, and
+// a RESET event is sent before them if it resumes from an old one, so that
+// the client drops the objects deleted since then.
+func sendInitialEvents(ws *watchStream, filter *watchFilter,
+	specs map[string]*supervisor.Spec, version, resumeVersion int64,
+) error {
+	if resumeVersion != version {
+		if resumeVersion >= 0 {
+			if err := ws.send(&WatchEvent{Type: WatchEventReset, Version: version}); err != nil {
+				return err
+			}
+		}
+
+		for _, spec := range specs {
+			if !filter.match(spec.Name(), spec.Kind()) {
+				continue
+			}
+			event, err := newSpecEvent(WatchEventCreate, version, spec)
+			if err != nil {
+				logger.Errorf("watch objects: %v", err)
+				continue
+			}
+			if err = ws.send(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ws.send(&WatchEvent{Type: WatchEventSync, Version: version})
+}
+
+func (s *Server) sendObjectEvents(ws *watchStream, filter *watchFilter,
+	specs map[string]*supervisor.Spec, prefix string, kvs map[string]*string,
+) error {
+	// NOTE: The version may fall behind the objects because it is increased
+	// after the objects are put, which only causes the resumed stream to
+	// send the objects again.
+	version, err := s.getVersion()
+	if err != nil {
+		logger.Errorf("watch objects: get config version failed: %v", err)
+	}
+
+	for key, value := range kvs {
+		name := strings.TrimPrefix(key, prefix)
+
+		var event *WatchEvent
+		if value == nil {
+			prev, exists := specs[name]
+			if !exists {
+				continue
+			}
+			delete(specs, name)
+			event = &WatchEvent{
+				Type:    WatchEventDelete,
+				Version: version,
+				Name:    name,
+				Kind:    prev.Kind(),
+			}
+		} else {
+			spec, err := s.super.NewSpec(*value)
+			if err != nil {
+				logger.Errorf("watch objects: bad spec(err: %v) from json: %s", err, *value)
+				continue
+			}
+
+			eventType := WatchEventCreate
+			if _, exists := specs[name]; exists {
+				eventType = WatchEventUpdate
+			}
+			specs[name] = spec
+
+			event, err = newSpecEvent(eventType, version, spec)
+			if err != nil {
+				logger.Errorf("watch objects: %v", err)
+				continue
+			}
+		}
+
+		if !filter.match(event.Name, event.Kind) {
+			continue
+		}
+		if err := ws.send(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) sendStatusEvents(ws *watchStream, filter *watchFilter,
+	specs map[string]*supervisor.Spec, lastStatus map[string]string,
+) error {
+	prefix := s.cluster.Layout().StatusObjectsPrefix()
+	kvs, err := s.cluster.GetPrefix(prefix)
+	if err != nil {
+		logger.Errorf("watch objects: get status failed: %v", err)
+		return nil
+	}
+
+	version, err := s.getVersion()
+	if err != nil {
+		logger.Errorf("watch objects: get config version failed: %v", err)
+	}
+
+	current := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		current[strings.TrimPrefix(k, prefix)] = v
+	}
+
+	statusEvent := func(key string) *WatchEvent {
+		_, name, member, ok := parseStatusKey(key)
+		if !ok {
+			return nil
+		}
+
+		// NOTE: The name of system controller is its own kind.
+		kind := name
+		if spec, exists := specs[name]; exists {
+			kind = spec.Kind()
+		}
+		if !filter.match(name, kind) {
+			return nil
+		}
+
+		return &WatchEvent{
+			Type:    WatchEventStatus,
+			Version: version,
+			Name:    name,
+			Kind:    kind,
+			Member:  member,
+		}
+	}
+
+	for key := range lastStatus {
+		if _, exists := current[key]; exists {
+			continue
+		}
+		delete(lastStatus, key)
+
+		event := statusEvent(key)
+		if event == nil {
+			continue
+		}
+		if err := ws.send(event); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range current {
+		if lastStatus[key] == value {
+			continue
+		}
+		lastStatus[key] = value
+
+		event := statusEvent(key)
+		if event == nil {
+			continue
+		}
+
+		event.Status = map[string]interface{}{}
+		err := codectool.Unmarshal([]byte(value), &event.Status)
+		if err != nil {
+			logger.Errorf("watch objects: unmarshal %s to json failed: %v", value, err)
+			continue
+		}
+		if err := ws.send(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	mockWatchObject struct{}
+
+	mockWatchObjectSpec struct {
+		Weight int `json:"weight,omitempty"`
+	}
+)
+
+func (m *mockWatchObject) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+func (m *mockWatchObject) Kind() string {
+	return "MockWatchObject"
+}
+
+func (m *mockWatchObject) DefaultSpec() interface{} {
+	return &mockWatchObjectSpec{}
+}
+
+func (m *mockWatchObject) Init(superSpec *supervisor.Spec) {}
+
+func (m *mockWatchObject) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {}
+
+func (m *mockWatchObject) Status() *supervisor.Status {
+	return &supervisor.Status{}
+}
+
+func (m *mockWatchObject) Close() {}
+
+func init() {
+	logger.InitNop()
+	supervisor.Register(&mockWatchObject{})
+}
+
+// newWatchTestServer creates a server with an in-memory cluster.
+func newWatchTestServer(kvs map[string]string) *Server {
+	var mutex sync.Mutex
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedLayout = func() *cluster.Layout { return &cluster.Layout{} }
+	cls.MockedGet = func(key string) (*string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if v, ok := kvs[key]; ok {
+			return &v, nil
+		}
+		return nil, nil
+	}
+	cls.MockedGetPrefix = func(prefix string) (map[string]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := map[string]string{}
+		for k, v := range kvs {
+			if strings.HasPrefix(k, prefix) {
+				result[k] = v
+			}
+		}
+		return result, nil
+	}
+
+	return &Server{
+		cluster: cls,
+		super:   supervisor.NewMock(nil, cls, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil),
+	}
+}
+
+func newWatchTestSpecs(t *testing.T, s *Server, names ...string) map[string]*supervisor.Spec {
+	specs := map[string]*supervisor.Spec{}
+	for _, name := range names {
+		spec, err := s.super.NewSpec(`{"name": "` + name + `", "kind": "MockWatchObject", "weight": 1}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs[name] = spec
+	}
+	return specs
+}
+
+func newWatchTestStream() (*watchStream, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	return &watchStream{w: w, flusher: w}, w
+}
+
+// readWatchEvents reads and clears the events sent to the recorder.
+func readWatchEvents(t *testing.T, w *httptest.ResponseRecorder) []*WatchEvent {
+	var events []*WatchEvent
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		event := &WatchEvent{}
+		if err := codectool.Unmarshal([]byte(line), event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	w.Body.Reset()
+	return events
+}
+
+func TestParseStatusKey(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		key       string
+		namespace string
+		name      string
+		member    string
+		ok        bool
+	}{
+		{"default/demo/eg-1", "default", "demo", "eg-1", true},
+		{"eg-traffic-ns/demo/eg-1", "eg-traffic-ns", "demo", "eg-1", true},
+		{"default/a/b/eg-1", "default", "a/b", "eg-1", true},
+		{"default//eg-1", "default", "", "eg-1", true},
+		{"default/demo", "", "", "", false},
+		{"demo", "", "", "", false},
+		{"", "", "", "", false},
+	}
+	for _, tt := range tests {
+		namespace, name, member, ok := parseStatusKey(tt.key)
+		assert.Equal(tt.ok, ok, tt.key)
+		assert.Equal(tt.namespace, namespace, tt.key)
+		assert.Equal(tt.name, name, tt.key)
+		assert.Equal(tt.member, member, tt.key)
+	}
+}
+
+func TestWatchFilterMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		filter watchFilter
+		name   string
+		kind   string
+		want   bool
+	}{
+		{watchFilter{}, "demo", "Pipeline", true},
+		{watchFilter{name: "demo"}, "demo", "Pipeline", true},
+		{watchFilter{name: "demo"}, "other", "Pipeline", false},
+		{watchFilter{kind: "Pipeline"}, "demo", "Pipeline", true},
+		{watchFilter{kind: "Pipeline"}, "demo", "HTTPServer", false},
+		{watchFilter{name: "demo", kind: "Pipeline"}, "demo", "Pipeline", true},
+		{watchFilter{name: "demo", kind: "Pipeline"}, "demo", "HTTPServer", false},
+		{watchFilter{name: "demo", kind: "Pipeline"}, "other", "Pipeline", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, tt.filter.match(tt.name, tt.kind), "%+v %s %s", tt.filter, tt.name, tt.kind)
+	}
+}
+
+func TestSendInitialEvents(t *testing.T) {
+	assert := assert.New(t)
+	s := newWatchTestServer(map[string]string{})
+	specs := newWatchTestSpecs(t, s, "a", "b")
+
+	tests := []struct {
+		resumeVersion int64
+		filter        watchFilter
+		types         []WatchEventType
+		names         []string
+	}{
+		// a new stream sends all objects
+		{-1, watchFilter{}, []WatchEventType{WatchEventCreate, WatchEventCreate, WatchEventSync}, []string{"a", "b", ""}},
+		{-1, watchFilter{name: "a"}, []WatchEventType{WatchEventCreate, WatchEventSync}, []string{"a", ""}},
+		// nothing changed since the version
+		{5, watchFilter{}, []WatchEventType{WatchEventSync}, []string{""}},
+		// the objects are reset if the config has changed since the version
+		{3, watchFilter{}, []WatchEventType{WatchEventReset, WatchEventCreate, WatchEventCreate, WatchEventSync}, []string{"", "a", "b", ""}},
+		{3, watchFilter{kind: "Pipeline"}, []WatchEventType{WatchEventReset, WatchEventSync}, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		ws, w := newWatchTestStream()
+		assert.Nil(sendInitialEvents(ws, &tt.filter, specs, 5, tt.resumeVersion))
+
+		events := readWatchEvents(t, w)
+		var types []WatchEventType
+		var names []string
+		for _, event := range events {
+			assert.Equal(int64(5), event.Version)
+			types = append(types, event.Type)
+			names = append(names, event.Name)
+		}
+		assert.Equal(tt.types, types, "%+v", tt)
+		assert.ElementsMatch(tt.names, names, "%+v", tt)
+	}
+}
+
+func TestSendStatusEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{
+		"/config/version": "7",
+	}
+	s := newWatchTestServer(kvs)
+	specs := newWatchTestSpecs(t, s, "demo")
+	prefix := s.cluster.Layout().StatusObjectsPrefix()
+
+	type status struct {
+		name   string
+		member string
+		weight interface{}
+	}
+	tests := []struct {
+		kvs    map[string]string
+		filter watchFilter
+		want   []status
+	}{
+		{
+			kvs: map[string]string{
+				"default/demo/eg-1":         `{"weight": 1}`,
+				"default/demo/eg-2":         `{"weight": 2}`,
+				"default/StatusSync/eg-1":   `{"weight": 3}`,
+				"default/invalid":           `{"weight": 4}`,
+				"default/filtered-out/eg-1": `{"weight": 5}`,
+			},
+			filter: watchFilter{kind: "MockWatchObject"},
+			want:   []status{{"demo", "eg-1", 1.0}, {"demo", "eg-2", 2.0}},
+		},
+		{
+			// unchanged status is not sent again
+			kvs: map[string]string{
+				"default/demo/eg-1": `{"weight": 1}`,
+				"default/demo/eg-2": `{"weight": 2}`,
+			},
+			filter: watchFilter{kind: "MockWatchObject"},
+			want:   nil,
+		},
+		{
+			// changed status is sent, removed status is sent as empty
+			kvs: map[string]string{
+				"default/demo/eg-1": `{"weight": 10}`,
+			},
+			filter: watchFilter{kind: "MockWatchObject"},
+			want:   []status{{"demo", "eg-1", 10.0}, {"demo", "eg-2", nil}},
+		},
+		{
+			// system controllers are matched by their names as kinds
+			kvs: map[string]string{
+				"default/demo/eg-1":       `{"weight": 10}`,
+				"default/StatusSync/eg-1": `{"weight": 3}`,
+			},
+			filter: watchFilter{kind: "StatusSync"},
+			want:   []status{{"StatusSync", "eg-1", 3.0}},
+		},
+	}
+
+	lastStatus := map[string]string{}
+	for i, tt := range tests {
+		for k := range kvs {
+			if strings.HasPrefix(k, prefix) {
+				delete(kvs, k)
+			}
+		}
+		for k, v := range tt.kvs {
+			kvs[prefix+k] = v
+		}
+
+		ws, w := newWatchTestStream()
+		assert.Nil(s.sendStatusEvents(ws, &tt.filter, specs, lastStatus))
+
+		var got []status
+		for _, event := range readWatchEvents(t, w) {
+			assert.Equal(WatchEventStatus, event.Type)
+			assert.Equal(int64(7), event.Version)
+			got = append(got, status{event.Name, event.Member, event.Status["weight"]})
+		}
+		assert.ElementsMatch(tt.want, got, "case %d", i)
+	}
+}
@@ -131,6 +131,11 @@ type cluster struct {
 	leaseMutex   sync.RWMutex
 	sessionMutex sync.RWMutex
 
+	// mutexes are shared in the member, because the mutexes created by
+	// the same session don't exclude each other.
+	mutexes      map[string]*mutex
+	mutexesMutex sync.Mutex
+
 	done chan struct{}
 }
 
@@ -491,6 +491,9 @@ func TestMutexAndOP(t *testing.T) {
 	if err != nil {
 		t.Errorf("cluster mutex failed: %v", err)
 	}
+	if m2, _ := c.Mutex("akey"); m2 != m {
+		t.Errorf("mutexes of the same name should be shared")
+	}
 
 	m.Lock()
 	defer m.Unlock()
@@ -30,6 +30,9 @@ const (
 	// The users should avoid creating namespaces started with this prefix.
 	NamespaceSystemPrefix  = "eg-"
 	NamespacetrafficPrefix = "eg-traffic-"
+	// NamespaceFederationPrefix is the prefix of the namespaces holding
+	// the statuses of the regions reported by the federation primary.
+	NamespaceFederationPrefix = "eg-federation-"
 
 	leaseFormat          = "/leases/%s" //+memberName
 	statusMemberPrefix   = "/status/members/"
@@ -39,10 +42,15 @@ const (
 	configObjectPrefix   = "/config/objects/"
 	configObjectFormat   = "/config/objects/%s" // +objectName
 	configVersion        = "/config/version"
+	configLock           = "/config/lock"
 	wasmCodeEvent        = "/wasm/code"
 	wasmDataPrefixFormat = "/wasm/data/%s/%s/" // + pipelineName + filterName
 	customDataKindPrefix = "/custom-data-kinds/"
 	customDataPrefix     = "/custom-data/"
+	schedulePrefix       = "/schedules/"
+	scheduleFormat       = "/schedules/%s" // +scheduleName
+	scheduleStatePrefix  = "/schedule-states/"
+	scheduleStateFormat  = "/schedule-states/%s" // +scheduleName
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(primary or secondary ) will be rejected if it is configured a different cluster name
@@ -66,6 +74,11 @@ func TrafficNamespace(name string) string {
 	return NamespacetrafficPrefix + name
 }
 
+// FederationNamespace returns the federation namespace of the region.
+func FederationNamespace(region string) string {
+	return NamespaceFederationPrefix + region
+}
+
 func (c *cluster) initLayout() {
 	c.layout = &Layout{
 		memberName: c.opt.Name,
@@ -137,6 +150,11 @@ func (l *Layout) ConfigVersion() string {
 	return configVersion
 }
 
+// ConfigLock returns the key of the mutex locking config operations.
+func (l *Layout) ConfigLock() string {
+	return configLock
+}
+
 // WasmCodeEvent returns the key of wasm code event
 func (l *Layout) WasmCodeEvent() string {
 	return wasmCodeEvent
@@ -156,3 +174,23 @@ func (l *Layout) CustomDataPrefix() string {
 func (l *Layout) CustomDataKindPrefix() string {
 	return customDataKindPrefix
 }
+
+// SchedulePrefix returns the prefix of schedules.
+func (l *Layout) SchedulePrefix() string {
+	return schedulePrefix
+}
+
+// ScheduleKey returns the key of the schedule.
+func (l *Layout) ScheduleKey(name string) string {
+	return fmt.Sprintf(scheduleFormat, name)
+}
+
+// ScheduleStatePrefix returns the prefix of schedule states.
+func (l *Layout) ScheduleStatePrefix() string {
+	return scheduleStatePrefix
+}
+
+// ScheduleStateKey returns the key of the schedule state.
+func (l *Layout) ScheduleStateKey(name string) string {
+	return fmt.Sprintf(scheduleStateFormat, name)
+}
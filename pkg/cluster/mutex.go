@@ -35,6 +35,7 @@ type mutex struct {
 	// concurrency.Mutex is a session level mutex, so sync.Mutex is
 	// required to make it goroutine safe
 	lock    sync.Mutex
+	session *concurrency.Session
 	m       *concurrency.Mutex
 	timeout time.Duration
 }
@@ -71,8 +72,21 @@ func (c *cluster) Mutex(name string) (Mutex, error) {
 		return nil, err
 	}
 
-	return &mutex{
+	c.mutexesMutex.Lock()
+	defer c.mutexesMutex.Unlock()
+
+	if m, ok := c.mutexes[name]; ok && m.session == session {
+		return m, nil
+	}
+	if c.mutexes == nil {
+		c.mutexes = make(map[string]*mutex)
+	}
+
+	m := &mutex{
+		session: session,
 		m:       concurrency.NewMutex(session, name),
 		timeout: c.requestTimeout,
-	}, nil
+	}
+	c.mutexes[name] = m
+	return m, nil
 }
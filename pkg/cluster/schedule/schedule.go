@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schedule provides schedules which apply an object spec in a time
+// window and revert it at the end of the window.
+//
+// The schedules and their states are stored in the cluster, and they are
+// executed by the ScheduleController of the leader.
+package schedule
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// PhasePending means the schedule is waiting for its next window.
+	PhasePending = "Pending"
+	// PhaseActive means the spec of the schedule is applied.
+	PhaseActive = "Active"
+	// PhaseCompleted means the schedule has no more windows.
+	PhaseCompleted = "Completed"
+)
+
+type (
+	// Schedule applies the spec at the start of a window, and reverts the
+	// object to the original one at the end of the window.
+	//
+	// A one-off window starts at Start, and ends at End or after Duration.
+	// The object is not reverted if neither End nor Duration is specified.
+	//
+	// A recurring window starts at every time matched by Cron, and lasts
+	// for Duration. Start and End limit the period the windows start in.
+	Schedule struct {
+		Name        string                 `json:"name" jsonschema:"required,format=urlname"`
+		Description string                 `json:"description,omitempty" jsonschema:"omitempty"`
+		Spec        map[string]interface{} `json:"spec" jsonschema:"required"`
+		Start       string                 `json:"start,omitempty" jsonschema:"omitempty"`
+		End         string                 `json:"end,omitempty" jsonschema:"omitempty"`
+		Cron        string                 `json:"cron,omitempty" jsonschema:"omitempty"`
+		Duration    string                 `json:"duration,omitempty" jsonschema:"omitempty"`
+		Timezone    string                 `json:"timezone,omitempty" jsonschema:"omitempty"`
+	}
+
+	// State is the state of a schedule, it's maintained by the leader.
+	State struct {
+		Phase string `json:"phase"`
+		// Generation is the hash of the schedule when the state changed
+		// last time, a completed schedule restarts if it is updated.
+		Generation string `json:"generation,omitempty"`
+		// Object, WindowStart and WindowEnd are the object and the window
+		// of an active schedule.
+		Object      string `json:"object,omitempty"`
+		WindowStart string `json:"windowStart,omitempty"`
+		WindowEnd   string `json:"windowEnd,omitempty"`
+		// Applied is the spec applied to the object.
+		Applied string `json:"applied,omitempty"`
+		// Original is the spec of the object before the schedule is
+		// activated, empty means the object didn't exist.
+		Original string `json:"original,omitempty"`
+		// Conflict is set when the object was changed by others after the
+		// spec was applied, so it was not reverted at the end of the window.
+		Conflict           string `json:"conflict,omitempty"`
+		LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+		Error              string `json:"error,omitempty"`
+	}
+)
+
+// Object returns the name of the object the schedule applies to.
+func (s *Schedule) Object() string {
+	name, _ := s.Spec["name"].(string)
+	return name
+}
+
+// Validate validates the schedule.
+func (s *Schedule) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.Object() == "" {
+		return fmt.Errorf("spec.name is required")
+	}
+	if kind, _ := s.Spec["kind"].(string); kind == "" {
+		return fmt.Errorf("spec.kind is required")
+	}
+	if s.Cron == "" && s.Start == "" {
+		return fmt.Errorf("either start or cron is required")
+	}
+	if s.Cron != "" && s.Duration == "" {
+		return fmt.Errorf("duration is required for cron")
+	}
+	if s.Cron == "" && s.End != "" && s.Duration != "" {
+		return fmt.Errorf("end and duration are exclusive")
+	}
+
+	start, end, err := s.period()
+	if err != nil {
+		return err
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return fmt.Errorf("start must be before end")
+	}
+	if _, err = s.duration(); err != nil {
+		return err
+	}
+	if _, err = s.cron(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Generation returns the hash of the schedule.
+func (s *Schedule) Generation() string {
+	sum := sha256.Sum256(codectool.MustMarshalJSON(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %s: %v", name, value, err)
+	}
+	return t, nil
+}
+
+func (s *Schedule) period() (start, end time.Time, err error) {
+	if start, err = parseTime("start", s.Start); err != nil {
+		return
+	}
+	end, err = parseTime("end", s.End)
+	return
+}
+
+func (s *Schedule) duration() (time.Duration, error) {
+	if s.Duration == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %s: %v", s.Duration, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
+
+func (s *Schedule) cron() (cron.Schedule, error) {
+	if s.Cron == "" {
+		return nil, nil
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %v", s.Timezone, err)
+		}
+	}
+
+	expr := s.Cron
+	if s.Timezone != "" {
+		expr = "CRON_TZ=" + s.Timezone + " " + expr
+	}
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron %s: %v", s.Cron, err)
+	}
+	return sched, nil
+}
+
+// Window returns the window containing now, or the next window if now is
+// not in any window. The end is zero if the window never ends, and ok is
+// false if there are no more windows.
+func (s *Schedule) Window(now time.Time) (start, end time.Time, ok bool) {
+	periodStart, periodEnd, err := s.period()
+	if err != nil {
+		return
+	}
+	d, err := s.duration()
+	if err != nil {
+		return
+	}
+	sched, err := s.cron()
+	if err != nil {
+		return
+	}
+
+	if sched == nil {
+		start, end = periodStart, periodEnd
+		if d > 0 {
+			end = start.Add(d)
+		}
+		if !end.IsZero() && !now.Before(end) {
+			return time.Time{}, time.Time{}, false
+		}
+		return start, end, true
+	}
+
+	// Next returns the first time after the given time, so the window
+	// starting at now-d is not included, which has ended at now.
+	from := now.Add(-d)
+	if !periodStart.IsZero() && from.Before(periodStart) {
+		from = periodStart.Add(-time.Second)
+	}
+	start = sched.Next(from)
+	if start.IsZero() || (!periodEnd.IsZero() && !start.Before(periodEnd)) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(d), true
+}
+
+// InWindow returns whether now is in the window.
+func InWindow(now, start, end time.Time) bool {
+	return !now.Before(start) && (end.IsZero() || now.Before(end))
+}
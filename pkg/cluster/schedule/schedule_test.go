@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/v"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParse(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := map[string]interface{}{"name": "pipeline-demo", "kind": "Pipeline"}
+
+	s := &Schedule{Spec: spec, Start: "2022-11-25T02:00:00Z"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: map[string]interface{}{"kind": "Pipeline"}, Start: "2022-11-25T02:00:00Z"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Cron: "0 2 * * *"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Start: "2022-11-25 02:00:00"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Start: "2022-11-25T02:00:00Z", End: "2022-11-25T01:00:00Z"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Start: "2022-11-25T02:00:00Z", End: "2022-11-25T04:00:00Z", Duration: "2h"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Cron: "0 2 * *", Duration: "2h"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Cron: "0 2 * * *", Duration: "2h", Timezone: "Mars/Olympus"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Cron: "0 2 * * *", Duration: "-2h"}
+	assert.NotNil(s.Validate())
+
+	s = &Schedule{Name: "s", Spec: spec, Start: "2022-11-25T02:00:00Z", Duration: "2h"}
+	assert.Nil(s.Validate())
+	assert.Equal("pipeline-demo", s.Object())
+
+	s = &Schedule{Name: "s", Spec: spec, Cron: "0 2 * * 6", Duration: "2h", Timezone: "Asia/Shanghai"}
+	assert.Nil(s.Validate())
+
+	// the name is validated like object names
+	assert.True(v.Validate(s).Valid())
+	s.Name = "a/b"
+	assert.False(v.Validate(s).Valid())
+	s.Name = "a b"
+	assert.False(v.Validate(s).Valid())
+	s.Name = "s"
+	s.Duration = "-2h"
+	assert.False(v.Validate(s).Valid())
+}
+
+func TestOneOffWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Schedule{Start: "2022-11-25T02:00:00Z", End: "2022-11-25T04:00:00Z"}
+
+	start, end, ok := s.Window(mustParse("2022-11-25T01:00:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-25T02:00:00Z"), start)
+	assert.Equal(mustParse("2022-11-25T04:00:00Z"), end)
+	assert.False(InWindow(mustParse("2022-11-25T01:00:00Z"), start, end))
+	assert.True(InWindow(mustParse("2022-11-25T02:00:00Z"), start, end))
+
+	_, _, ok = s.Window(mustParse("2022-11-25T04:00:00Z"))
+	assert.False(ok)
+
+	s = &Schedule{Start: "2022-11-25T02:00:00Z", Duration: "30m"}
+	_, end, ok = s.Window(mustParse("2022-11-25T02:10:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-25T02:30:00Z"), end)
+
+	// A window without end never ends.
+	s = &Schedule{Start: "2022-11-25T02:00:00Z"}
+	start, end, ok = s.Window(mustParse("2030-01-01T00:00:00Z"))
+	assert.True(ok)
+	assert.True(end.IsZero())
+	assert.True(InWindow(mustParse("2030-01-01T00:00:00Z"), start, end))
+}
+
+func TestCronWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Schedule{Cron: "0 2 * * *", Duration: "2h"}
+
+	// Before the window of today.
+	start, end, ok := s.Window(mustParse("2022-11-25T01:00:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-25T02:00:00Z"), start)
+	assert.Equal(mustParse("2022-11-25T04:00:00Z"), end)
+
+	// In the window of today.
+	start, _, ok = s.Window(mustParse("2022-11-25T03:59:59Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-25T02:00:00Z"), start)
+
+	// After the window of today.
+	start, _, ok = s.Window(mustParse("2022-11-25T04:00:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-26T02:00:00Z"), start)
+
+	// The period limits the windows.
+	s = &Schedule{Cron: "0 2 * * *", Duration: "2h", Start: "2022-11-25T03:00:00Z", End: "2022-11-27T00:00:00Z"}
+	start, _, ok = s.Window(mustParse("2022-11-25T03:30:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-26T02:00:00Z"), start)
+	_, _, ok = s.Window(mustParse("2022-11-26T05:00:00Z"))
+	assert.False(ok)
+
+	// Timezone.
+	s = &Schedule{Cron: "0 2 * * *", Duration: "1h", Timezone: "Asia/Shanghai"}
+	start, _, ok = s.Window(mustParse("2022-11-25T00:00:00Z"))
+	assert.True(ok)
+	assert.Equal(mustParse("2022-11-25T18:00:00Z").Unix(), start.Unix())
+}
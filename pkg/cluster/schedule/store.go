@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedule
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+// Store defines the storage for schedules and their states.
+type Store struct {
+	cluster cluster.Cluster
+}
+
+// NewStore creates a new schedule store.
+func NewStore(cls cluster.Cluster) *Store {
+	return &Store{cluster: cls}
+}
+
+// GetSchedule gets the schedule by its name, it returns nil if not found.
+func (s *Store) GetSchedule(name string) (*Schedule, error) {
+	value, err := s.cluster.Get(s.cluster.Layout().ScheduleKey(name))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	schedule := &Schedule{}
+	if err = codectool.UnmarshalJSON([]byte(*value), schedule); err != nil {
+		return nil, fmt.Errorf("unmarshal schedule %s failed: %v", name, err)
+	}
+	return schedule, nil
+}
+
+// ListSchedules lists all schedules, the key is the schedule name.
+func (s *Store) ListSchedules() (map[string]*Schedule, error) {
+	prefix := s.cluster.Layout().SchedulePrefix()
+	kvs, err := s.cluster.GetPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make(map[string]*Schedule, len(kvs))
+	for k, v := range kvs {
+		schedule := &Schedule{}
+		if err = codectool.UnmarshalJSON([]byte(v), schedule); err != nil {
+			return nil, fmt.Errorf("unmarshal schedule %s failed: %v", k, err)
+		}
+		schedules[strings.TrimPrefix(k, prefix)] = schedule
+	}
+	return schedules, nil
+}
+
+// PutSchedule creates or updates the schedule.
+func (s *Store) PutSchedule(schedule *Schedule) error {
+	buff, err := codectool.MarshalJSON(schedule)
+	if err != nil {
+		return err
+	}
+	return s.cluster.Put(s.cluster.Layout().ScheduleKey(schedule.Name), string(buff))
+}
+
+// DeleteSchedule deletes the schedule, its state is kept until the object
+// is reverted by the leader.
+func (s *Store) DeleteSchedule(name string) error {
+	return s.cluster.Delete(s.cluster.Layout().ScheduleKey(name))
+}
+
+// GetState gets the state of the schedule, it returns nil if not found.
+func (s *Store) GetState(name string) (*State, error) {
+	value, err := s.cluster.Get(s.cluster.Layout().ScheduleStateKey(name))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	state := &State{}
+	if err = codectool.UnmarshalJSON([]byte(*value), state); err != nil {
+		return nil, fmt.Errorf("unmarshal state of schedule %s failed: %v", name, err)
+	}
+	return state, nil
+}
+
+// ListStates lists the states of all schedules, the key is the schedule name.
+func (s *Store) ListStates() (map[string]*State, error) {
+	prefix := s.cluster.Layout().ScheduleStatePrefix()
+	kvs, err := s.cluster.GetPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]*State, len(kvs))
+	for k, v := range kvs {
+		state := &State{}
+		if err = codectool.UnmarshalJSON([]byte(v), state); err != nil {
+			return nil, fmt.Errorf("unmarshal schedule state %s failed: %v", k, err)
+		}
+		states[strings.TrimPrefix(k, prefix)] = state
+	}
+	return states, nil
+}
+
+// PutState updates the state of the schedule.
+func (s *Store) PutState(name string, state *State) error {
+	buff, err := codectool.MarshalJSON(state)
+	if err != nil {
+		return err
+	}
+	return s.cluster.Put(s.cluster.Layout().ScheduleStateKey(name), string(buff))
+}
+
+// DeleteState deletes the state of the schedule.
+func (s *Store) DeleteState(name string) error {
+	return s.cluster.Delete(s.cluster.Layout().ScheduleStateKey(name))
+}
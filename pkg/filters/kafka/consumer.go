@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	stdcontext "context"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/filters/topicmapper"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/mqttproxy"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/kafkaconsumer"
+)
+
+var (
+	newConsumerGroup = sarama.NewConsumerGroup
+	publishMessage   = mqttproxy.PublishMessage
+)
+
+// consumer consumes Kafka topics in a consumer group and publishes the
+// messages to MQTTProxy. The offset of a message is marked after it's
+// published, so messages are delivered at least once.
+type consumer struct {
+	spec    *Consumer
+	reverse topicmapper.ReverseMapFunc
+	cancel  stdcontext.CancelFunc
+	done    chan struct{}
+}
+
+func newConsumer(name string, backend []string, spec *Consumer) *consumer {
+	config := sarama.NewConfig()
+	config.ClientID = name
+	config.Version = sarama.V1_0_0_0
+	config.Consumer.Return.Errors = true
+	if spec.InitialOffset == "oldest" {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+
+	c := &consumer{
+		spec: spec,
+		done: make(chan struct{}),
+	}
+	if tm := spec.TopicMapper; tm != nil {
+		c.reverse = topicmapper.NewReverseMapFunc(&topicmapper.Spec{
+			MatchIndex: tm.MatchIndex,
+			Route:      tm.Route,
+			Policies:   tm.Policies,
+		}, tm.Prefix)
+	}
+
+	kc := &kafkaconsumer.Consumer{
+		Name:          name,
+		Brokers:       backend,
+		GroupID:       spec.GroupID,
+		Topics:        spec.Topics,
+		Config:        config,
+		NewGroup:      newConsumerGroup,
+		HandleMessage: c.handleMessage,
+	}
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	c.cancel = cancel
+	go func() {
+		defer close(c.done)
+		kc.Run(ctx)
+	}()
+	return c
+}
+
+func (c *consumer) close() {
+	c.cancel()
+	<-c.done
+}
+
+// convert returns the MQTT topic and properties of msg.
+func (c *consumer) convert(msg *sarama.ConsumerMessage) (string, *mqttprot.Properties, error) {
+	var props *mqttprot.Properties
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		headers[string(h.Key)] = string(h.Value)
+		if props == nil {
+			props = &mqttprot.Properties{}
+		}
+		props.AddUserProperty(string(h.Key), string(h.Value))
+	}
+
+	if c.reverse == nil {
+		return msg.Topic, props, nil
+	}
+	topic, err := c.reverse(msg.Topic, headers)
+	return topic, props, err
+}
+
+// handleMessage publishes msg to MQTTProxy, and retries until it succeeds
+// or ctx is done. It returns false if ctx is done.
+func (c *consumer) handleMessage(ctx stdcontext.Context, msg *sarama.ConsumerMessage) bool {
+	topic, props, err := c.convert(msg)
+	if err != nil {
+		logger.Errorf("map kafka topic %s to MQTT topic failed, message skipped: %v", msg.Topic, err)
+		return true
+	}
+
+	for {
+		err := publishMessage(c.spec.MQTTProxy, topic, msg.Value, c.spec.QoS, false, props)
+		if err == nil {
+			return true
+		}
+		logger.Errorf("publish kafka message to MQTT topic %s failed: %v", topic, err)
+		if !kafkaconsumer.Wait(ctx) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	stdcontext "context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/megaease/easegress/pkg/filters/topicmapper"
+	"github.com/megaease/easegress/pkg/object/mqttproxy"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockConsumerGroup struct {
+	sarama.ConsumerGroup
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+	closed   chan struct{}
+}
+
+func (m *mockConsumerGroup) Consume(ctx stdcontext.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	session := &mockSession{ctx: ctx}
+	claim := &mockClaim{messages: m.messages}
+	return handler.ConsumeClaim(session, claim)
+}
+
+func (m *mockConsumerGroup) Errors() <-chan error {
+	return m.errors
+}
+
+func (m *mockConsumerGroup) Close() error {
+	close(m.errors)
+	close(m.closed)
+	return nil
+}
+
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	ctx stdcontext.Context
+}
+
+func (m *mockSession) Context() stdcontext.Context {
+	return m.ctx
+}
+
+func (m *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {}
+
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (m *mockClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return m.messages
+}
+
+type published struct {
+	proxy string
+	topic string
+	props *mqttprot.Properties
+	qos   byte
+}
+
+func TestConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	group := &mockConsumerGroup{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+	defer func() {
+		newConsumerGroup = sarama.NewConsumerGroup
+		publishMessage = mqttproxy.PublishMessage
+		newAsyncProducer = sarama.NewAsyncProducer
+	}()
+	newConsumerGroup = func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		assert.Equal("group", groupID)
+		assert.Equal(sarama.OffsetOldest, config.Consumer.Offsets.Initial)
+		return group, nil
+	}
+
+	var mutex sync.Mutex
+	fail := true
+	ch := make(chan *published, 10)
+	publishMessage = func(name, topic string, payload []byte, qos byte, retain bool, props *mqttprot.Properties) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// the first publish fails and is retried
+		if fail {
+			fail = false
+			return fmt.Errorf("mqtt proxy not found")
+		}
+		ch <- &published{proxy: name, topic: topic, props: props, qos: qos}
+		return nil
+	}
+
+	newAsyncProducer = func(addrs []string, conf *sarama.Config) (sarama.AsyncProducer, error) {
+		return newMockAsyncProducer(), nil
+	}
+	spec := &Spec{
+		Backend: []string{"localhost:1234"},
+		Consumer: &Consumer{
+			MQTTProxy:     "mqttproxy",
+			GroupID:       "group",
+			Topics:        []string{"to_cloud"},
+			QoS:           1,
+			InitialOffset: "oldest",
+			TopicMapper: &TopicMapper{
+				MatchIndex: 0,
+				Route:      []*topicmapper.PolicyRe{{Name: "d2s", MatchExpr: "d2s"}},
+				Policies: []*topicmapper.Policy{{
+					Name:       "d2s",
+					TopicIndex: 3,
+					Route:      []topicmapper.TopicRe{{Topic: "to_cloud", Exprs: []string{"cmd"}}},
+					Headers:    map[int]string{0: "d2s", 1: "tenant", 2: "things_id", 3: "event"},
+				}},
+				Prefix: "/",
+			},
+		},
+	}
+	kafka := &Kafka{spec: spec}
+	kafka.Init()
+
+	group.errors <- fmt.Errorf("consumer group error")
+
+	header := func(k, v string) *sarama.RecordHeader {
+		return &sarama.RecordHeader{Key: []byte(k), Value: []byte(v)}
+	}
+	group.messages <- &sarama.ConsumerMessage{
+		Topic: "to_cloud",
+		Value: []byte("payload"),
+		Headers: []*sarama.RecordHeader{
+			header("d2s", "d2s"), header("tenant", "abc"), header("things_id", "123"), header("event", "cmd"),
+		},
+	}
+	select {
+	case p := <-ch:
+		assert.Equal("mqttproxy", p.proxy)
+		assert.Equal("/d2s/abc/123/cmd", p.topic)
+		assert.Equal(byte(1), p.qos)
+		value, _ := p.props.GetUserProperty("tenant")
+		assert.Equal("abc", value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not published")
+	}
+
+	// message which can't be mapped is skipped
+	group.messages <- &sarama.ConsumerMessage{Topic: "to_cloud", Value: []byte("skipped")}
+	group.messages <- &sarama.ConsumerMessage{
+		Topic: "to_cloud",
+		Value: []byte("payload"),
+		Headers: []*sarama.RecordHeader{
+			header("d2s", "d2s"), header("tenant", "xyz"), header("things_id", "456"), header("event", "cmd"),
+		},
+	}
+	select {
+	case p := <-ch:
+		assert.Equal("/d2s/xyz/456/cmd", p.topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not published")
+	}
+
+	kafka.Close()
+	<-group.closed
+}
+
+func TestConsumerConvert(t *testing.T) {
+	assert := assert.New(t)
+	c := &consumer{spec: &Consumer{}}
+
+	topic, props, err := c.convert(&sarama.ConsumerMessage{Topic: "a/b"})
+	assert.Nil(err)
+	assert.Equal("a/b", topic)
+	assert.Nil(props)
+
+	topic, props, err = c.convert(&sarama.ConsumerMessage{
+		Topic:   "a/b",
+		Headers: []*sarama.RecordHeader{{Key: []byte("k"), Value: []byte("v")}, nil},
+	})
+	assert.Nil(err)
+	assert.Equal("a/b", topic)
+	value, ok := props.GetUserProperty("k")
+	assert.True(ok)
+	assert.Equal("v", value)
+}
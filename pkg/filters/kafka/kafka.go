@@ -20,6 +20,8 @@ package kafka
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/megaease/easegress/pkg/context"
@@ -33,12 +35,23 @@ const (
 	Kind = "KafkaMQTT"
 
 	resultGetDataFailed = "getDataFailed"
+	resultProduceFailed = "produceFailed"
+
+	acksNone   = "none"
+	acksLeader = "leader"
+	acksAll    = "all"
+
+	partitionKeyClientID    = "clientID"
+	partitionKeyTopicLevels = "topicLevels"
+
+	defaultBufferSize = 1000
+	defaultTimeout    = 10 * time.Second
 )
 
 var kind = &filters.Kind{
 	Name:        Kind,
 	Description: "Kafka is a kafka proxy for MQTT requests",
-	Results:     []string{resultGetDataFailed},
+	Results:     []string{resultGetDataFailed, resultProduceFailed},
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
 	},
@@ -56,8 +69,16 @@ type (
 	Kafka struct {
 		spec     *Spec
 		producer sarama.AsyncProducer
+		consumer *consumer
 		done     chan struct{}
 
+		// waitAck is true if Handle waits for the acknowledgement of Kafka,
+		// buffer limits the number of messages waiting for it.
+		waitAck bool
+		buffer  chan struct{}
+		timeout time.Duration
+
+		partitionKey *PartitionKey
 		defaultTopic string
 		topicKey     string
 		headerKey    string
@@ -92,6 +113,7 @@ func (k *Kafka) setKV() {
 	if k.spec.Topic != nil {
 		k.defaultTopic = k.spec.Topic.Default
 	}
+	k.partitionKey = k.spec.PartitionKey
 }
 
 var newAsyncProducer = sarama.NewAsyncProducer
@@ -100,6 +122,33 @@ func (k *Kafka) setProducer() {
 	config := sarama.NewConfig()
 	config.ClientID = k.spec.Name()
 	config.Version = sarama.V1_0_0_0
+	switch k.spec.Acks {
+	case acksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	case acksLeader:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+		k.waitAck = true
+	case acksAll:
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		k.waitAck = true
+	}
+	config.Producer.Return.Successes = k.waitAck
+
+	bufferSize := k.spec.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	k.buffer = make(chan struct{}, bufferSize)
+	k.timeout = defaultTimeout
+	if k.spec.Timeout != "" {
+		timeout, err := time.ParseDuration(k.spec.Timeout)
+		if err != nil {
+			logger.Errorf("invalid timeout %s of kafka filter %s, use default %v", k.spec.Timeout, k.spec.Name(), defaultTimeout)
+		} else {
+			k.timeout = timeout
+		}
+	}
+
 	producer, err := newAsyncProducer(k.spec.Backend, config)
 	if err != nil {
 		panic(fmt.Errorf("start sarama producer with address %v failed: %v", k.spec.Backend, err))
@@ -120,16 +169,37 @@ func (k *Kafka) setProducer() {
 					return
 				}
 				logger.SpanErrorf(nil, "sarama producer failed: %v", err)
+				k.acknowledge(err.Msg, err.Err)
+			case msg, ok := <-producer.Successes():
+				if !ok {
+					return
+				}
+				k.acknowledge(msg, nil)
 			}
 		}
 	}()
 }
 
+// acknowledge notifies Handle waiting for msg the result, and releases
+// the buffer used by msg.
+func (k *Kafka) acknowledge(msg *sarama.ProducerMessage, err error) {
+	if msg == nil {
+		return
+	}
+	if ch, ok := msg.Metadata.(chan error); ok {
+		ch <- err
+		<-k.buffer
+	}
+}
+
 // Init init Kafka
 func (k *Kafka) Init() {
 	k.done = make(chan struct{})
 	k.setKV()
 	k.setProducer()
+	if k.spec.Consumer != nil {
+		k.consumer = newConsumer(k.spec.Name(), k.spec.Backend, k.spec.Consumer)
+	}
 }
 
 // Inherit init Kafka based on previous generation
@@ -140,6 +210,9 @@ func (k *Kafka) Inherit(previousGeneration filters.Filter) {
 // Close close Kafka
 func (k *Kafka) Close() {
 	close(k.done)
+	if k.consumer != nil {
+		k.consumer.close()
+	}
 }
 
 // Status return status of Kafka
@@ -198,12 +271,92 @@ func (k *Kafka) Handle(ctx *context.Context) string {
 	for k, v := range headers {
 		kafkaHeaders = append(kafkaHeaders, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
 	}
+	// MQTT 5 user properties are sent as headers, headers in kv map take precedence.
+	for _, up := range req.Properties().GetUserProperties() {
+		if _, ok := headers[up.Key]; ok {
+			continue
+		}
+		kafkaHeaders = append(kafkaHeaders, sarama.RecordHeader{Key: []byte(up.Key), Value: []byte(up.Value)})
+	}
 
 	msg := &sarama.ProducerMessage{
 		Topic:   topic,
 		Headers: kafkaHeaders,
 		Value:   sarama.ByteEncoder(payload),
 	}
-	k.producer.Input() <- msg
+	if key := k.messageKey(req); key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	if !k.waitAck {
+		k.producer.Input() <- msg
+		return ""
+	}
+	if err := k.produce(msg); err != nil {
+		logger.SpanErrorf(nil, "client %s produce message to kafka topic %s failed: %v", req.Client().ClientID(), topic, err)
+		// drop the packet so that no PUBACK is sent to the client, and
+		// the client could publish it again.
+		if resp, ok := ctx.GetOutputResponse().(*mqttprot.Response); ok {
+			resp.SetDrop()
+		}
+		return resultProduceFailed
+	}
+	return ""
+}
+
+// produce sends msg to Kafka and waits for the acknowledgement. It blocks
+// when the buffer is full, which delays the PUBACK to the MQTT client.
+func (k *Kafka) produce(msg *sarama.ProducerMessage) error {
+	timer := time.NewTimer(k.timeout)
+	defer timer.Stop()
+
+	select {
+	case k.buffer <- struct{}{}:
+	case <-timer.C:
+		return fmt.Errorf("wait for buffer timeout")
+	}
+
+	ch := make(chan error, 1)
+	msg.Metadata = ch
+	select {
+	case k.producer.Input() <- msg:
+	case <-timer.C:
+		<-k.buffer
+		return fmt.Errorf("send message timeout")
+	}
+
+	// the buffer is released by acknowledge after timeout
+	select {
+	case err := <-ch:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("wait for acknowledgement timeout")
+	}
+}
+
+// messageKey returns the key of the Kafka message of req.
+func (k *Kafka) messageKey(req *mqttprot.Request) string {
+	pk := k.partitionKey
+	if pk == nil {
+		return ""
+	}
+	switch pk.From {
+	case partitionKeyClientID:
+		return req.Client().ClientID()
+	case partitionKeyTopicLevels:
+		if req.PacketType() != mqttprot.PublishType {
+			return ""
+		}
+		levels := strings.Split(req.PublishPacket().TopicName, "/")
+		if levels[0] == "" {
+			levels = levels[1:]
+		}
+		keys := make([]string, 0, len(pk.Levels))
+		for _, i := range pk.Levels {
+			if i >= 0 && i < len(levels) {
+				keys = append(keys, levels[i])
+			}
+		}
+		return strings.Join(keys, "/")
+	}
 	return ""
 }
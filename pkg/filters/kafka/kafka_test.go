@@ -38,9 +38,10 @@ func init() {
 }
 
 type mockAsyncProducer struct {
-	ch      chan *sarama.ProducerMessage
-	errorCh chan *sarama.ProducerError
-	closed  int32
+	ch        chan *sarama.ProducerMessage
+	errorCh   chan *sarama.ProducerError
+	successCh chan *sarama.ProducerMessage
+	closed    int32
 }
 
 func (m *mockAsyncProducer) IsTransactional() bool {
@@ -72,7 +73,7 @@ func (m *mockAsyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID
 }
 
 func (m *mockAsyncProducer) AsyncClose()                               {}
-func (m *mockAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (m *mockAsyncProducer) Successes() <-chan *sarama.ProducerMessage { return m.successCh }
 func (m *mockAsyncProducer) Errors() <-chan *sarama.ProducerError      { return m.errorCh }
 
 func (m *mockAsyncProducer) Input() chan<- *sarama.ProducerMessage {
@@ -87,8 +88,9 @@ var _ sarama.AsyncProducer = (*mockAsyncProducer)(nil)
 
 func newMockAsyncProducer() sarama.AsyncProducer {
 	return &mockAsyncProducer{
-		ch:      make(chan *sarama.ProducerMessage, 100),
-		errorCh: make(chan *sarama.ProducerError),
+		ch:        make(chan *sarama.ProducerMessage, 100),
+		errorCh:   make(chan *sarama.ProducerError),
+		successCh: make(chan *sarama.ProducerMessage),
 	}
 }
 
@@ -110,6 +112,7 @@ func newContext(cid string, topic string, payload []byte) *context.Context {
 	req := mqttprot.NewRequest(packet, client)
 
 	ctx.SetInputRequest(req)
+	ctx.SetOutputResponse(mqttprot.NewResponse())
 	return ctx
 }
 
@@ -209,3 +212,113 @@ func TestKafka2(t *testing.T) {
 	}
 	assert.Equal(int32(1), atomic.LoadInt32(&p.closed))
 }
+
+func TestKafkaWithUserProperties(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		Backend: []string{"localhost:1234"},
+		KVMap: &KVMap{
+			HeaderKey: "headers",
+		},
+	}
+
+	kafka := Kafka{
+		spec:     spec,
+		producer: newMockAsyncProducer(),
+		done:     make(chan struct{}),
+	}
+	kafka.setKV()
+	defer kafka.Close()
+
+	mqttCtx := newContext("test", "a/b/c", []byte("text"))
+	mqttCtx.SetData("headers", map[string]string{"device": "kv"})
+	props := &mqttprot.Properties{}
+	props.AddUserProperty("device", "mqtt")
+	props.AddUserProperty("region", "eu")
+	mqttCtx.GetInputRequest().(*mqttprot.Request).SetProperties(props)
+
+	kafka.Handle(mqttCtx)
+	msg := <-kafka.producer.(*mockAsyncProducer).ch
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(map[string]string{"device": "kv", "region": "eu"}, headers)
+}
+
+func newAckKafka(t *testing.T, spec *Spec) (*Kafka, *mockAsyncProducer) {
+	newAsyncProducer = func(addrs []string, conf *sarama.Config) (sarama.AsyncProducer, error) {
+		assert.True(t, conf.Producer.Return.Successes)
+		assert.Equal(t, sarama.WaitForAll, conf.Producer.RequiredAcks)
+		return newMockAsyncProducer(), nil
+	}
+	spec.Backend = []string{"localhost:1234"}
+	spec.Acks = "all"
+	kafka := &Kafka{spec: spec}
+	kafka.Init()
+	return kafka, kafka.producer.(*mockAsyncProducer)
+}
+
+func TestKafkaAcks(t *testing.T) {
+	assert := assert.New(t)
+	defer func() {
+		newAsyncProducer = sarama.NewAsyncProducer
+	}()
+	kafka, p := newAckKafka(t, &Spec{Timeout: "200ms", BufferSize: 1})
+	defer kafka.Close()
+
+	// acknowledged message
+	result := make(chan string)
+	go func() {
+		result <- kafka.Handle(newContext("test", "a/b", []byte("ok")))
+	}()
+	msg := <-p.ch
+	p.successCh <- msg
+	assert.Equal("", <-result)
+
+	// failed message is dropped
+	ctx := newContext("test", "a/b", []byte("failed"))
+	go func() {
+		result <- kafka.Handle(ctx)
+	}()
+	msg = <-p.ch
+	p.errorCh <- &sarama.ProducerError{Msg: msg, Err: fmt.Errorf("kafka failed")}
+	assert.Equal(resultProduceFailed, <-result)
+	assert.True(ctx.GetOutputResponse().(*mqttprot.Response).Drop())
+
+	// not acknowledged message times out and holds the only buffer
+	assert.Equal(resultProduceFailed, kafka.Handle(newContext("test", "a/b", []byte("timeout"))))
+	msg = <-p.ch
+	assert.Equal(resultProduceFailed, kafka.Handle(newContext("test", "a/b", []byte("full"))))
+	assert.Equal(0, len(p.ch))
+
+	// the buffer is released by the late acknowledgement
+	p.successCh <- msg
+	go func() {
+		result <- kafka.Handle(newContext("test", "a/b", []byte("ok")))
+	}()
+	p.successCh <- <-p.ch
+	assert.Equal("", <-result)
+}
+
+func TestKafkaPartitionKey(t *testing.T) {
+	assert := assert.New(t)
+	kafka := Kafka{
+		spec:     &Spec{PartitionKey: &PartitionKey{From: "clientID"}},
+		producer: newMockAsyncProducer(),
+		done:     make(chan struct{}),
+	}
+	kafka.setKV()
+	defer kafka.Close()
+
+	kafka.Handle(newContext("device-1", "/d2s/tenant/phone/123/log", []byte("text")))
+	msg := <-kafka.producer.(*mockAsyncProducer).ch
+	key, _ := msg.Key.Encode()
+	assert.Equal("device-1", string(key))
+
+	kafka.partitionKey = &PartitionKey{From: "topicLevels", Levels: []int{1, 3, 10}}
+	kafka.Handle(newContext("device-1", "/d2s/tenant/phone/123/log", []byte("text")))
+	msg = <-kafka.producer.(*mockAsyncProducer).ch
+	key, _ = msg.Key.Encode()
+	assert.Equal("tenant/123", string(key))
+}
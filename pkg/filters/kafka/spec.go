@@ -17,7 +17,10 @@
 
 package kafka
 
-import "github.com/megaease/easegress/pkg/filters"
+import (
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/topicmapper"
+)
 
 type (
 	// Spec is spec of Kafka
@@ -27,6 +30,21 @@ type (
 		Backend []string `json:"backend" jsonschema:"required,uniqueItems=true"`
 		Topic   *Topic   `json:"topic" jsonschema:"required"`
 		KVMap   *KVMap   `json:"mqtt" jsonschema:"required"`
+
+		// Acks is the acknowledgement required from Kafka, none, leader or
+		// all. The filter waits for the acknowledgement of leader or all,
+		// so the PUBACK is delayed until Kafka accepts the message, and the
+		// message is dropped without PUBACK if Kafka fails. The filter
+		// doesn't wait if it's empty.
+		Acks string `json:"acks" jsonschema:"omitempty,enum=,enum=none,enum=leader,enum=all"`
+		// BufferSize is the maximum number of messages waiting for the
+		// acknowledgement, default is 1000.
+		BufferSize int `json:"bufferSize" jsonschema:"omitempty,minimum=1"`
+		// Timeout is the timeout to wait for the buffer and the
+		// acknowledgement, default is 10s.
+		Timeout      string        `json:"timeout" jsonschema:"omitempty,format=duration"`
+		PartitionKey *PartitionKey `json:"partitionKey" jsonschema:"omitempty"`
+		Consumer     *Consumer     `json:"consumer" jsonschema:"omitempty"`
 	}
 
 	// Topic defined ways to get Kafka topic
@@ -40,4 +58,39 @@ type (
 		HeaderKey  string `json:"headerKey" jsonschema:"required"`
 		PayloadKey string `json:"payloadKey" jsonschema:"required"`
 	}
+
+	// PartitionKey defines the key of Kafka messages, messages with the
+	// same key go to the same partition.
+	PartitionKey struct {
+		// From is clientID or topicLevels.
+		From string `json:"from" jsonschema:"required,enum=clientID,enum=topicLevels"`
+		// Levels is the indexes of MQTT topic levels joined by / as the
+		// key when From is topicLevels.
+		Levels []int `json:"levels" jsonschema:"omitempty"`
+	}
+
+	// Consumer consumes Kafka topics and publishes the messages to an
+	// MQTTProxy, Kafka headers are sent as MQTT 5 user properties.
+	Consumer struct {
+		MQTTProxy string   `json:"mqttProxy" jsonschema:"required"`
+		GroupID   string   `json:"groupID" jsonschema:"required"`
+		Topics    []string `json:"topics" jsonschema:"required,minItems=1"`
+		QoS       byte     `json:"qos" jsonschema:"omitempty,maximum=2"`
+		// InitialOffset is newest or oldest, default is newest.
+		InitialOffset string `json:"initialOffset" jsonschema:"omitempty,enum=,enum=newest,enum=oldest"`
+		// TopicMapper maps Kafka topics and headers back to MQTT topics
+		// with the rules of the TopicMapper filter, the MQTT topic is the
+		// Kafka topic if it's nil.
+		TopicMapper *TopicMapper `json:"topicMapper" jsonschema:"omitempty"`
+	}
+
+	// TopicMapper is the topic mapping rules of the TopicMapper filter.
+	TopicMapper struct {
+		MatchIndex int                     `json:"matchIndex" jsonschema:"required"`
+		Route      []*topicmapper.PolicyRe `json:"route" jsonschema:"required"`
+		Policies   []*topicmapper.Policy   `json:"policies" jsonschema:"required"`
+		// Prefix is the levels before the first header of MQTT topics,
+		// like / or nothing.
+		Prefix string `json:"prefix" jsonschema:"omitempty"`
+	}
 )
@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package mqttacl implements topic level access control for MQTT clients.
+package mqttacl
+
+import (
+	stdcontext "context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// Kind is the kind of MQTTACL
+	Kind = "MQTTACL"
+
+	resultTopicDenied = "topicDenied"
+
+	actionPublish   = "publish"
+	actionSubscribe = "subscribe"
+	actionAll       = "all"
+
+	permissionAllow = "allow"
+	permissionDeny  = "deny"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MQTTACL checks the publish and subscribe permission of MQTT clients on topics",
+	Results:     []string{resultTopicDenied},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MQTTACL{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// MQTTACL is used to check whether MQTT clients can publish or
+	// subscribe topics. Denied publish packets are dropped, and denied
+	// topics of subscribe packets get failure codes in SUBACK.
+	MQTTACL struct {
+		spec   *Spec
+		cancel stdcontext.CancelFunc
+
+		mutex       sync.RWMutex
+		customRules []*Rule
+	}
+
+	// Spec describes the MQTTACL.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		// DefaultPermission is used when no rule matches, default is deny.
+		DefaultPermission string  `json:"defaultPermission" jsonschema:"omitempty,enum=,enum=allow,enum=deny"`
+		Rules             []*Rule `json:"rules" jsonschema:"omitempty"`
+		// CustomDataKind is the kind of custom data which keeps rules in
+		// the same format as Rules. They are checked after Rules in the
+		// order of their names, and the changes take effect without
+		// updating the filter.
+		CustomDataKind string `json:"customDataKind" jsonschema:"omitempty"`
+	}
+
+	// Rule is an ACL rule. Username and ClientID select the clients the
+	// rule applies to, empty means all clients. Topics are topic filters
+	// with wildcards, %u and %c in them are replaced by the username and
+	// client ID.
+	Rule struct {
+		Username   string   `json:"username" jsonschema:"omitempty"`
+		ClientID   string   `json:"clientID" jsonschema:"omitempty"`
+		Action     string   `json:"action" jsonschema:"omitempty,enum=,enum=publish,enum=subscribe,enum=all"`
+		Permission string   `json:"permission" jsonschema:"required,enum=allow,enum=deny"`
+		Topics     []string `json:"topics" jsonschema:"required"`
+	}
+)
+
+var _ filters.Filter = (*MQTTACL)(nil)
+
+// Name returns the name of the MQTTACL filter instance.
+func (acl *MQTTACL) Name() string {
+	return acl.spec.Name()
+}
+
+// Kind return kind of MQTTACL
+func (acl *MQTTACL) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MQTTACL
+func (acl *MQTTACL) Spec() filters.Spec {
+	return acl.spec
+}
+
+// Init init MQTTACL
+func (acl *MQTTACL) Init() {
+	if acl.spec.CustomDataKind != "" {
+		acl.watchCustomData()
+	}
+}
+
+// Inherit init MQTTACL based on previous generation
+func (acl *MQTTACL) Inherit(previousGeneration filters.Filter) {
+	acl.Init()
+}
+
+func (acl *MQTTACL) watchCustomData() {
+	super := acl.spec.Super()
+	if super == nil || super.Cluster() == nil {
+		logger.Errorf("MQTT filter %v: cluster is not available for custom data", acl.spec.Name())
+		return
+	}
+	cls := super.Cluster()
+	store := customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	acl.cancel = cancel
+	go func() {
+		err := store.Watch(ctx, acl.spec.CustomDataKind, acl.updateCustomData)
+		if err != nil {
+			logger.Errorf("MQTT filter %v watch custom data %s failed: %v", acl.spec.Name(), acl.spec.CustomDataKind, err)
+		}
+	}()
+}
+
+// updateCustomData replaces the rules from custom data.
+func (acl *MQTTACL) updateCustomData(data []customdata.Data) {
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].GetString("name") < data[j].GetString("name")
+	})
+	rules := make([]*Rule, 0, len(data))
+	for _, d := range data {
+		rule := &Rule{}
+		err := codectool.UnmarshalJSON(codectool.MustMarshalJSON(d), rule)
+		if err != nil || len(rule.Topics) == 0 {
+			logger.Errorf("MQTT filter %v: invalid rule %v in custom data", acl.spec.Name(), d)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	acl.mutex.Lock()
+	acl.customRules = rules
+	acl.mutex.Unlock()
+}
+
+// Close close MQTTACL
+func (acl *MQTTACL) Close() {
+	if acl.cancel != nil {
+		acl.cancel()
+	}
+}
+
+// Status return status of MQTTACL
+func (acl *MQTTACL) Status() interface{} {
+	return nil
+}
+
+// match returns the permission of the rule for the client to do the
+// action on topic, and false if the rule doesn't apply.
+func (r *Rule) match(client mqttprot.Client, action string, topic string) (bool, bool) {
+	if r.Username != "" && r.Username != client.UserName() {
+		return false, false
+	}
+	if r.ClientID != "" && r.ClientID != client.ClientID() {
+		return false, false
+	}
+	if r.Action != "" && r.Action != actionAll && r.Action != action {
+		return false, false
+	}
+	deny := r.Permission == permissionDeny
+	for _, t := range r.Topics {
+		filter, ok := substitute(t, client)
+		if !ok {
+			// a deny rule which can't be substituted denies all
+			// topics, rather than widening the access.
+			if deny {
+				return false, true
+			}
+			continue
+		}
+		// A deny rule applies to a subscription if they have any topic
+		// in common, otherwise the subscription would receive messages
+		// of denied topics. An allow rule must cover the subscription.
+		if deny && action == actionSubscribe {
+			if overlaps(filter, topic) {
+				return false, true
+			}
+		} else if covers(filter, topic) {
+			return !deny, true
+		}
+	}
+	return false, false
+}
+
+// substitute replaces %u and %c in topic filter t by the username and
+// client ID of client, it returns false if they are empty or contain
+// wildcards or topic separators, which would change the topic filter.
+func substitute(t string, client mqttprot.Client) (string, bool) {
+	if strings.Contains(t, "%u") {
+		if !validPlaceholderValue(client.UserName()) {
+			return "", false
+		}
+		t = strings.ReplaceAll(t, "%u", client.UserName())
+	}
+	if strings.Contains(t, "%c") {
+		if !validPlaceholderValue(client.ClientID()) {
+			return "", false
+		}
+		t = strings.ReplaceAll(t, "%c", client.ClientID())
+	}
+	return t, true
+}
+
+func validPlaceholderValue(v string) bool {
+	return v != "" && !strings.ContainsAny(v, "+#/")
+}
+
+func (acl *MQTTACL) allow(client mqttprot.Client, action string, topic string) bool {
+	for _, r := range acl.spec.Rules {
+		if allow, ok := r.match(client, action, topic); ok {
+			return allow
+		}
+	}
+
+	acl.mutex.RLock()
+	rules := acl.customRules
+	acl.mutex.RUnlock()
+	for _, r := range rules {
+		if allow, ok := r.match(client, action, topic); ok {
+			return allow
+		}
+	}
+
+	return acl.spec.DefaultPermission == permissionAllow
+}
+
+// Handle handles context.
+func (acl *MQTTACL) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*mqttprot.Request)
+	resp := ctx.GetOutputResponse().(*mqttprot.Response)
+
+	switch req.PacketType() {
+	case mqttprot.PublishType:
+		topic := req.PublishPacket().TopicName
+		if !acl.allow(req.Client(), actionPublish, topic) {
+			logger.Debugf("client %s publish %s denied", req.Client().ClientID(), topic)
+			resp.SetDrop()
+			return resultTopicDenied
+		}
+	case mqttprot.SubscribeType:
+		topics := req.SubscribePacket().Topics
+		denied := 0
+		for i, topic := range topics {
+			if !acl.allow(req.Client(), actionSubscribe, stripShare(topic)) {
+				logger.Debugf("client %s subscribe %s denied", req.Client().ClientID(), topic)
+				resp.DenyTopic(i)
+				denied++
+			}
+		}
+		if denied > 0 && denied == len(topics) {
+			return resultTopicDenied
+		}
+	}
+	return ""
+}
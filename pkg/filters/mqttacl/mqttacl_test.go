@@ -0,0 +1,313 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttacl
+
+import (
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newContext(client *mqttprot.MockClient, packet packets.ControlPacket) *context.Context {
+	ctx := context.New(nil)
+	ctx.SetInputRequest(mqttprot.NewRequest(packet, client))
+	ctx.SetOutputResponse(mqttprot.NewResponse())
+	return ctx
+}
+
+func newPublishContext(cid, username, topic string) *context.Context {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.TopicName = topic
+	return newContext(&mqttprot.MockClient{MockClientID: cid, MockUserName: username}, packet)
+}
+
+func newSubscribeContext(cid, username string, topics ...string) *context.Context {
+	packet := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	packet.Topics = topics
+	packet.Qoss = make([]byte, len(topics))
+	return newContext(&mqttprot.MockClient{MockClientID: cid, MockUserName: username}, packet)
+}
+
+func TestCovers(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		filter string
+		sub    string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+", "a/+", true},
+		{"a/+", "a/#", false},
+		{"a/b", "a/+", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a/+/c", true},
+		{"a/#", "a/#", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, covers(tt.filter, tt.sub), "%s covers %s", tt.filter, tt.sub)
+	}
+
+	assert.Equal("a/b", stripShare("$share/group/a/b"))
+	assert.Equal("$share/group", stripShare("$share/group"))
+	assert.Equal("a/b", stripShare("a/b"))
+}
+
+func TestOverlaps(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/+", true},
+		{"a/b", "+/b", true},
+		{"a/b", "+/c", false},
+		{"a/b", "#", true},
+		{"a/b", "a/#", true},
+		{"a/b", "a/b/c", false},
+		{"a/b", "a/b/#", true},
+		{"a", "a/#", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/+", true},
+		{"a/+/c", "a/b/d", false},
+		{"$SYS/broker", "#", false},
+		{"$SYS/broker", "+/broker", false},
+		{"$SYS/broker", "$SYS/#", true},
+		{"#", "+/b", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(tt.want, overlaps(tt.a, tt.b), "%s overlaps %s", tt.a, tt.b)
+		assert.Equal(tt.want, overlaps(tt.b, tt.a), "%s overlaps %s", tt.b, tt.a)
+	}
+}
+
+func TestACL(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		Rules: []*Rule{
+			{Username: "admin", Permission: "allow", Topics: []string{"#"}},
+			{Action: "publish", Permission: "deny", Topics: []string{"devices/%c/config"}},
+			{Permission: "allow", Topics: []string{"devices/%c/#", "users/%u/#"}},
+			{Action: "subscribe", Permission: "allow", Topics: []string{"broadcast/+"}},
+		},
+	}
+	acl := kind.CreateInstance(spec).(*MQTTACL)
+	acl.Init()
+	defer acl.Close()
+	assert.Equal(Kind, acl.Kind().Name)
+	assert.Nil(acl.Status())
+
+	publishTests := []struct {
+		cid      string
+		username string
+		topic    string
+		allow    bool
+	}{
+		{"d1", "", "devices/d1/state", true},
+		{"d1", "", "devices/d2/state", false},
+		{"d1", "", "devices/d1/config", false},
+		{"d1", "alice", "users/alice/inbox", true},
+		{"d1", "", "users//inbox", false},
+		{"d1", "", "broadcast/news", false},
+		{"d1", "admin", "devices/d2/config", true},
+	}
+	for _, tt := range publishTests {
+		ctx := newPublishContext(tt.cid, tt.username, tt.topic)
+		result := acl.Handle(ctx)
+		resp := ctx.GetOutputResponse().(*mqttprot.Response)
+		assert.Equal(!tt.allow, resp.Drop(), "%+v", tt)
+		if tt.allow {
+			assert.Equal("", result)
+		} else {
+			assert.Equal(resultTopicDenied, result)
+		}
+	}
+
+	ctx := newSubscribeContext("d1", "", "devices/d1/#", "devices/+/state", "broadcast/news", "$share/g/devices/d1/state")
+	assert.Equal("", acl.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*mqttprot.Response)
+	assert.False(resp.Drop())
+	assert.False(resp.TopicDenied(0))
+	assert.True(resp.TopicDenied(1))
+	assert.False(resp.TopicDenied(2))
+	assert.False(resp.TopicDenied(3))
+
+	ctx = newSubscribeContext("d1", "", "devices/d2/#")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+	assert.True(ctx.GetOutputResponse().(*mqttprot.Response).TopicDenied(0))
+
+	// other packets are not checked
+	ctx = newContext(&mqttprot.MockClient{MockClientID: "d1"}, packets.NewControlPacket(packets.Unsubscribe))
+	assert.Equal("", acl.Handle(ctx))
+}
+
+func TestACLSubstituteWildcards(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		Rules: []*Rule{
+			{Permission: "allow", Topics: []string{"%c/#", "users/%u/#"}},
+		},
+	}
+	acl := kind.CreateInstance(spec).(*MQTTACL)
+	acl.Init()
+	defer acl.Close()
+
+	// client IDs and usernames with wildcards or separators never match,
+	// otherwise they widen the topic filters of the rules.
+	tests := []struct {
+		cid      string
+		username string
+		topic    string
+		allow    bool
+	}{
+		{"d1", "", "d1/state", true},
+		{"#", "", "d1/state", false},
+		{"+", "", "d1/state", false},
+		{"d1/state", "", "d1/state/x", false},
+		{"d1", "alice", "users/alice/inbox", true},
+		{"d1", "#", "users/bob/inbox", false},
+		{"d1", "+", "users/bob/inbox", false},
+		{"d1", "bob/inbox", "users/bob/inbox/x", false},
+	}
+	for _, tt := range tests {
+		ctx := newPublishContext(tt.cid, tt.username, tt.topic)
+		acl.Handle(ctx)
+		assert.Equal(!tt.allow, ctx.GetOutputResponse().(*mqttprot.Response).Drop(), "%+v", tt)
+	}
+
+	ctx := newSubscribeContext("#", "", "#")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+}
+
+func TestACLDenyWildcardSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		Rules: []*Rule{
+			{Permission: "deny", Topics: []string{"a/b", "users/%u/private"}},
+			{Permission: "allow", Topics: []string{"#"}},
+		},
+	}
+	acl := kind.CreateInstance(spec).(*MQTTACL)
+	acl.Init()
+	defer acl.Close()
+
+	// subscriptions which may receive messages of denied topics are
+	// denied, even if they're wider than the deny rules.
+	tests := []struct {
+		username string
+		topic    string
+		allow    bool
+	}{
+		{"bob", "a/b", false},
+		{"bob", "a/+", false},
+		{"bob", "+/b", false},
+		{"bob", "#", false},
+		{"bob", "a/#", false},
+		{"bob", "$share/g/a/+", false},
+		{"bob", "a/c", true},
+		{"bob", "a/b/c", true},
+		{"bob", "a/+/c", true},
+		{"bob", "users/bob/private", false},
+		{"bob", "users/+/private", false},
+		{"bob", "users/alice/private", true},
+		// the deny rule can't be substituted, so it denies everything
+		{"", "a/c", false},
+		{"a/#", "a/c", false},
+	}
+	for _, tt := range tests {
+		ctx := newSubscribeContext("c1", tt.username, tt.topic)
+		acl.Handle(ctx)
+		assert.Equal(!tt.allow, ctx.GetOutputResponse().(*mqttprot.Response).TopicDenied(0), "%+v", tt)
+	}
+
+	// publish is checked against the topic name
+	ctx := newPublishContext("c1", "bob", "a/c")
+	assert.Equal("", acl.Handle(ctx))
+	ctx = newPublishContext("c1", "bob", "a/b")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+	ctx = newPublishContext("c1", "", "a/c")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+}
+
+func TestACLDefaultPermission(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		DefaultPermission: "allow",
+		Rules:             []*Rule{{Permission: "deny", Topics: []string{"secret/#"}}},
+	}
+	acl := kind.CreateInstance(spec).(*MQTTACL)
+	acl.Init()
+
+	ctx := newPublishContext("c1", "", "public/a")
+	assert.Equal("", acl.Handle(ctx))
+	ctx = newPublishContext("c1", "", "secret/a")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+}
+
+func TestACLCustomData(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{
+		Rules:          []*Rule{{ClientID: "banned", Permission: "deny", Topics: []string{"#"}}},
+		CustomDataKind: "mqttacl",
+	}
+	acl := kind.CreateInstance(spec).(*MQTTACL)
+	acl.Init()
+	defer acl.Close()
+
+	ctx := newPublishContext("c1", "", "a/b")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+
+	acl.updateCustomData([]customdata.Data{
+		{"name": "2", "permission": "allow", "topics": []interface{}{"a/#"}},
+		{"name": "1", "permission": "deny", "topics": []interface{}{"a/private"}},
+		{"name": "0", "permission": "allow"},
+	})
+	assert.Len(acl.customRules, 2)
+
+	ctx = newPublishContext("c1", "", "a/b")
+	assert.Equal("", acl.Handle(ctx))
+	ctx = newPublishContext("c1", "", "a/private")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+	ctx = newPublishContext("banned", "", "a/b")
+	assert.Equal(resultTopicDenied, acl.Handle(ctx))
+
+	inherited := kind.CreateInstance(spec).(*MQTTACL)
+	inherited.Inherit(acl)
+	defer inherited.Close()
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttacl
+
+import "strings"
+
+const sharePrefix = "$share/"
+
+// stripShare returns the topic filter of a shared subscription
+// $share/{group}/{filter}, or topic itself if it's not shared.
+func stripShare(topic string) string {
+	if !strings.HasPrefix(topic, sharePrefix) {
+		return topic
+	}
+	rest := topic[len(sharePrefix):]
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return topic
+	}
+	return rest[i+1:]
+}
+
+// covers returns true if every topic matched by topic filter sub (or the
+// topic name of a publish packet) is also matched by topic filter filter.
+func covers(filter, sub string) bool {
+	fl := strings.Split(filter, "/")
+	sl := strings.Split(sub, "/")
+
+	// wildcards at the first level don't match topics starting with $
+	if strings.HasPrefix(sub, "$") && (fl[0] == "+" || fl[0] == "#") {
+		return false
+	}
+
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(sl) {
+			return false
+		}
+		s := sl[i]
+		switch f {
+		case "+":
+			if s == "#" {
+				return false
+			}
+		default:
+			if f != s {
+				return false
+			}
+		}
+	}
+	return len(fl) == len(sl)
+}
+
+// overlaps returns true if there is any topic matched by both topic
+// filter a and topic filter b.
+func overlaps(a, b string) bool {
+	al := strings.Split(a, "/")
+	bl := strings.Split(b, "/")
+
+	// wildcards at the first level don't match topics starting with $
+	if strings.HasPrefix(a, "$") && (bl[0] == "+" || bl[0] == "#") {
+		return false
+	}
+	if strings.HasPrefix(b, "$") && (al[0] == "+" || al[0] == "#") {
+		return false
+	}
+
+	for i := 0; ; i++ {
+		switch {
+		case i == len(al) && i == len(bl):
+			return true
+		case i == len(al):
+			// a/# matches a too
+			return i == len(bl)-1 && bl[i] == "#"
+		case i == len(bl):
+			return i == len(al)-1 && al[i] == "#"
+		case al[i] == "#" || bl[i] == "#":
+			return true
+		case al[i] != "+" && bl[i] != "+" && al[i] != bl[i]:
+			return false
+		}
+	}
+}
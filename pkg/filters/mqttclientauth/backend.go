@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttclientauth
+
+import (
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+)
+
+const (
+	identityUsername = "username"
+	identityClientID = "clientID"
+)
+
+type (
+	// CertificateSpec authenticates clients by the common name of the
+	// verified client certificate. The MQTTProxy must be configured with
+	// clientCA to request client certificates.
+	CertificateSpec struct {
+		// Identity is what the common name must be equal to, username or
+		// clientID, default is username.
+		Identity string `json:"identity" jsonschema:"omitempty,enum=,enum=username,enum=clientID"`
+	}
+
+	// passwordAuth checks the salted SHA256 password of username, the
+	// credentials come from spec and custom data.
+	passwordAuth struct {
+		salt   string
+		static map[string]string
+
+		mutex  sync.RWMutex
+		custom map[string]string
+	}
+
+	certificateAuth struct {
+		identity string
+	}
+)
+
+func newPasswordAuth(salt string, auth []*Auth) *passwordAuth {
+	pa := &passwordAuth{
+		salt:   salt,
+		static: make(map[string]string, len(auth)),
+	}
+	for _, a := range auth {
+		pa.static[a.Username] = a.SaltedSha256Pass
+	}
+	return pa
+}
+
+// updateCustomData replaces the credentials from custom data.
+func (pa *passwordAuth) updateCustomData(data []customdata.Data) {
+	custom := make(map[string]string, len(data))
+	for _, d := range data {
+		username, _ := d["username"].(string)
+		pass, _ := d["saltedSha256Pass"].(string)
+		if username != "" && pass != "" {
+			custom[username] = pass
+		}
+	}
+	pa.mutex.Lock()
+	pa.custom = custom
+	pa.mutex.Unlock()
+}
+
+func (pa *passwordAuth) lookup(username string) (string, bool) {
+	if pass, ok := pa.static[username]; ok {
+		return pass, true
+	}
+	pa.mutex.RLock()
+	defer pa.mutex.RUnlock()
+	pass, ok := pa.custom[username]
+	return pass, ok
+}
+
+func (pa *passwordAuth) authenticate(_ mqttprot.Client, connect *packets.ConnectPacket) bool {
+	saltedSha256Pass, ok := pa.lookup(connect.Username)
+	if !ok {
+		return false
+	}
+	return saltedSha256Pass == sha256Sum(append(connect.Password, []byte(pa.salt)...))
+}
+
+func newCertificateAuth(spec *CertificateSpec) *certificateAuth {
+	identity := spec.Identity
+	if identity == "" {
+		identity = identityUsername
+	}
+	return &certificateAuth{identity: identity}
+}
+
+func (ca *certificateAuth) authenticate(client mqttprot.Client, connect *packets.ConnectPacket) bool {
+	tc, ok := client.(mqttprot.TLSClient)
+	if !ok {
+		return false
+	}
+	certs := tc.PeerCertificates()
+	if len(certs) == 0 {
+		return false
+	}
+
+	identity := connect.Username
+	if ca.identity == identityClientID {
+		identity = connect.ClientIdentifier
+	}
+	return identity != "" && certs[0].Subject.CommonName == identity
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttclientauth
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const defaultHTTPTimeout = 5 * time.Second
+
+type (
+	// HTTPSpec authenticates clients by an HTTP callout. The connect
+	// information is POSTed to URL in JSON, and the client is accepted
+	// if the status code is 2xx.
+	HTTPSpec struct {
+		URL string `json:"url" jsonschema:"required,format=uri"`
+		// Timeout is the timeout of the callout, default is 5s.
+		Timeout string            `json:"timeout" jsonschema:"omitempty,format=duration"`
+		Headers map[string]string `json:"headers" jsonschema:"omitempty"`
+	}
+
+	// httpAuthRequest is the request body of the HTTP callout.
+	httpAuthRequest struct {
+		ClientID      string `json:"clientID"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		CertificateCN string `json:"certificateCN,omitempty"`
+	}
+
+	httpAuth struct {
+		spec   *HTTPSpec
+		client *http.Client
+	}
+)
+
+func newHTTPAuth(spec *HTTPSpec) *httpAuth {
+	timeout := defaultHTTPTimeout
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			logger.Errorf("invalid timeout %s of http authentication, use default %v", spec.Timeout, defaultHTTPTimeout)
+		} else {
+			timeout = d
+		}
+	}
+	return &httpAuth{
+		spec:   spec,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (ha *httpAuth) authenticate(client mqttprot.Client, connect *packets.ConnectPacket) bool {
+	body := &httpAuthRequest{
+		ClientID: connect.ClientIdentifier,
+		Username: connect.Username,
+		Password: string(connect.Password),
+	}
+	if tc, ok := client.(mqttprot.TLSClient); ok {
+		if certs := tc.PeerCertificates(); len(certs) > 0 {
+			body.CertificateCN = certs[0].Subject.CommonName
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ha.spec.URL, bytes.NewReader(codectool.MustMarshalJSON(body)))
+	if err != nil {
+		logger.Errorf("create http authentication request failed: %v", err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range ha.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := ha.client.Do(req)
+	if err != nil {
+		logger.Errorf("http authentication of client %s failed: %v", connect.ClientIdentifier, err)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
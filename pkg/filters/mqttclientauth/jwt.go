@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttclientauth
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+)
+
+type (
+	// JWTSpec authenticates clients which use a JWT as the password.
+	JWTSpec struct {
+		Algorithm string `json:"algorithm" jsonschema:"required,enum=HS256,enum=HS384,enum=HS512,enum=RS256,enum=RS384,enum=RS512,enum=ES256,enum=ES384,enum=ES512,enum=EdDSA"`
+		// PublicKey is in hex encoding
+		PublicKey string `json:"publicKey" jsonschema:"omitempty,pattern=^$|^[A-Fa-f0-9]+$"`
+		// Secret is in hex encoding
+		Secret string `json:"secret" jsonschema:"omitempty,pattern=^$|^[A-Fa-f0-9]+$"`
+		// UsernameClaim is the claim which must be equal to the username,
+		// default is sub.
+		UsernameClaim string `json:"usernameClaim" jsonschema:"omitempty"`
+	}
+
+	jwtAuth struct {
+		spec *JWTSpec
+		key  interface{}
+	}
+)
+
+func newJWTAuth(spec *JWTSpec) (*jwtAuth, error) {
+	var key interface{}
+	if spec.PublicKey != "" {
+		publicKeyBytes, err := hex.DecodeString(spec.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		p, _ := pem.Decode(publicKeyBytes)
+		if p == nil {
+			return nil, fmt.Errorf("invalid PEM public key")
+		}
+		key, err = x509.ParsePKIXPublicKey(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		secret, err := hex.DecodeString(spec.Secret)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("neither public key nor secret is specified")
+		}
+		key = secret
+	}
+	return &jwtAuth{spec: spec, key: key}, nil
+}
+
+func (ja *jwtAuth) authenticate(_ mqttprot.Client, connect *packets.ConnectPacket) bool {
+	if connect.Username == "" || len(connect.Password) == 0 {
+		return false
+	}
+
+	claims := jwt.MapClaims{}
+	// jwt.ParseWithClaims does everything including parsing and verification
+	token, err := jwt.ParseWithClaims(string(connect.Password), claims, func(token *jwt.Token) (interface{}, error) {
+		if alg := token.Method.Alg(); alg != ja.spec.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		return ja.key, nil
+	})
+	if err != nil || !token.Valid {
+		logger.Debugf("client %s jwt verification failed: %v", connect.ClientIdentifier, err)
+		return false
+	}
+
+	claim := ja.spec.UsernameClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	username, _ := claims[claim].(string)
+	return username == connect.Username
+}
@@ -19,10 +19,12 @@
 package mqttclientauth
 
 import (
+	stdcontext "context"
 	"crypto/sha256"
 	"encoding/hex"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/cluster/customdata"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
 	"github.com/megaease/easegress/pkg/logger"
@@ -38,7 +40,7 @@ const (
 
 var kind = &filters.Kind{
 	Name:        Kind,
-	Description: "Authentication can check MQTT client's username and password",
+	Description: "Authentication can check MQTT client's username and password, certificate, JWT or by HTTP callout",
 	Results:     []string{resultAuthFail},
 	DefaultSpec: func() filters.Spec {
 		return &Spec{}
@@ -55,19 +57,27 @@ func init() {
 type (
 	// MQTTClientAuth is used to check authentication for MQTT client
 	MQTTClientAuth struct {
-		spec    *Spec
-		authMap map[string]string
-		salt    string
+		spec           *Spec
+		authenticators []authenticator
+		cancel         stdcontext.CancelFunc
 	}
 
 	// Spec is spec for MQTTClientAuth.
 	// For security of password, passwords in json file should be salted SHA256 checksum.
 	// password = sha256sum(connect.password + salt)
+	// The client is authenticated if any of the configured backends accepts it.
 	Spec struct {
 		filters.BaseSpec `json:",inline"`
 
 		Salt string  `json:"salt" jsonschema:"omitempty"`
-		Auth []*Auth `json:"auth" jsonschema:"required"`
+		Auth []*Auth `json:"auth" jsonschema:"omitempty"`
+		// CustomDataKind is the kind of custom data which keeps credentials
+		// in the same format as Auth, the changes of the custom data take
+		// effect without updating the filter.
+		CustomDataKind string           `json:"customDataKind" jsonschema:"omitempty"`
+		Certificate    *CertificateSpec `json:"certificate" jsonschema:"omitempty"`
+		JWT            *JWTSpec         `json:"jwt" jsonschema:"omitempty"`
+		HTTP           *HTTPSpec        `json:"http" jsonschema:"omitempty"`
 	}
 
 	// Auth describes username and password for MQTTProxy
@@ -75,6 +85,11 @@ type (
 		Username         string `json:"username" jsonschema:"required"`
 		SaltedSha256Pass string `json:"saltedSha256Pass" jsonschema:"required"`
 	}
+
+	// authenticator is a backend to authenticate MQTT clients.
+	authenticator interface {
+		authenticate(client mqttprot.Client, connect *packets.ConnectPacket) bool
+	}
 )
 
 var _ filters.Filter = (*MQTTClientAuth)(nil)
@@ -96,16 +111,55 @@ func (a *MQTTClientAuth) Spec() filters.Spec {
 
 // Init init MQTTClientAuth
 func (a *MQTTClientAuth) Init() {
-	a.salt = a.spec.Salt
-	a.authMap = make(map[string]string)
+	spec := a.spec
+	a.authenticators = nil
+
+	if len(spec.Auth) > 0 || spec.CustomDataKind != "" {
+		pa := newPasswordAuth(spec.Salt, spec.Auth)
+		if spec.CustomDataKind != "" {
+			a.watchCustomData(pa)
+		}
+		a.authenticators = append(a.authenticators, pa)
+	}
+	if spec.Certificate != nil {
+		a.authenticators = append(a.authenticators, newCertificateAuth(spec.Certificate))
+	}
+	if spec.JWT != nil {
+		ja, err := newJWTAuth(spec.JWT)
+		if err != nil {
+			logger.Errorf("invalid jwt spec of MQTT filter %v: %v", spec.Name(), err)
+		} else {
+			a.authenticators = append(a.authenticators, ja)
+		}
+	}
+	if spec.HTTP != nil {
+		a.authenticators = append(a.authenticators, newHTTPAuth(spec.HTTP))
+	}
 
-	for _, auth := range a.spec.Auth {
-		a.authMap[auth.Username] = auth.SaltedSha256Pass
+	if len(a.authenticators) == 0 {
+		logger.Errorf("empty valid authentication for MQTT filter %v", spec.Name())
 	}
+}
 
-	if len(a.authMap) == 0 {
-		logger.Errorf("empty valid authentication for MQTT filter %v", a.spec.Name())
+// watchCustomData watches the custom data of CustomDataKind and updates
+// the credentials of pa on changes.
+func (a *MQTTClientAuth) watchCustomData(pa *passwordAuth) {
+	super := a.spec.Super()
+	if super == nil || super.Cluster() == nil {
+		logger.Errorf("MQTT filter %v: cluster is not available for custom data", a.spec.Name())
+		return
 	}
+	cls := super.Cluster()
+	store := customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	a.cancel = cancel
+	go func() {
+		err := store.Watch(ctx, a.spec.CustomDataKind, pa.updateCustomData)
+		if err != nil {
+			logger.Errorf("MQTT filter %v watch custom data %s failed: %v", a.spec.Name(), a.spec.CustomDataKind, err)
+		}
+	}()
 }
 
 // Inherit init MQTTClientAuth based on previous generation
@@ -115,6 +169,9 @@ func (a *MQTTClientAuth) Inherit(previousGeneration filters.Filter) {
 
 // Close close MQTTClientAuth
 func (a *MQTTClientAuth) Close() {
+	if a.cancel != nil {
+		a.cancel()
+	}
 }
 
 // Status return status of MQTTClientAuth
@@ -127,18 +184,16 @@ func sha256Sum(data []byte) string {
 	return hex.EncodeToString(sha256Bytes[:])
 }
 
-func (a *MQTTClientAuth) checkAuth(connect *packets.ConnectPacket) string {
+func (a *MQTTClientAuth) checkAuth(client mqttprot.Client, connect *packets.ConnectPacket) string {
 	if connect.ClientIdentifier == "" {
 		return resultAuthFail
 	}
-	saltedSha256Pass, ok := a.authMap[connect.Username]
-	if !ok {
-		return resultAuthFail
-	}
-	if saltedSha256Pass != sha256Sum(append(connect.Password, []byte(a.salt)...)) {
-		return resultAuthFail
+	for _, auth := range a.authenticators {
+		if auth.authenticate(client, connect) {
+			return ""
+		}
 	}
-	return ""
+	return resultAuthFail
 }
 
 // Handle handles context.
@@ -148,7 +203,7 @@ func (a *MQTTClientAuth) Handle(ctx *context.Context) string {
 	if req.PacketType() != mqttprot.ConnectType {
 		return ""
 	}
-	result := a.checkAuth(req.ConnectPacket())
+	result := a.checkAuth(req.Client(), req.ConnectPacket())
 	if result != "" {
 		resp.SetDisconnect()
 		return resultAuthFail
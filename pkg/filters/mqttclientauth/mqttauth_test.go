@@ -18,14 +18,23 @@
 package mqttclientauth
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/megaease/easegress/pkg/cluster/customdata"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,11 +43,18 @@ func init() {
 }
 
 func newContext(cid, username, password string) *context.Context {
+	return newContextWithCert(cid, username, password, "")
+}
+
+func newContextWithCert(cid, username, password, cn string) *context.Context {
 	ctx := context.New(nil)
 
 	client := &mqttprot.MockClient{
 		MockClientID: cid,
 	}
+	if cn != "" {
+		client.MockCerts = []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}
+	}
 	packet := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
 	packet.ClientIdentifier = cid
 	packet.Username = username
@@ -109,3 +125,138 @@ func TestAuthFile(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func checkDisconnect(t *testing.T, auth *MQTTClientAuth, ctx *context.Context, disconnect bool) {
+	t.Helper()
+	auth.Handle(ctx)
+	resp := ctx.GetOutputResponse().(*mqttprot.Response)
+	assert.Equal(t, disconnect, resp.Disconnect())
+}
+
+func TestAuthCustomData(t *testing.T) {
+	salt := "salt"
+	spec := &Spec{
+		Salt:           salt,
+		Auth:           []*Auth{{Username: "test", SaltedSha256Pass: sha256Sum([]byte("test" + salt))}},
+		CustomDataKind: "mqttusers",
+	}
+	auth := kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+	defer auth.Close()
+
+	checkDisconnect(t, auth, newContext("client1", "test", "test"), false)
+	checkDisconnect(t, auth, newContext("client2", "dynamic", "dynamic"), true)
+
+	pa := auth.authenticators[0].(*passwordAuth)
+	pa.updateCustomData([]customdata.Data{
+		{"name": "dynamic", "username": "dynamic", "saltedSha256Pass": sha256Sum([]byte("dynamic" + salt))},
+		{"name": "invalid", "username": "invalid"},
+	})
+	checkDisconnect(t, auth, newContext("client1", "test", "test"), false)
+	checkDisconnect(t, auth, newContext("client2", "dynamic", "dynamic"), false)
+	checkDisconnect(t, auth, newContext("client3", "invalid", ""), true)
+
+	pa.updateCustomData(nil)
+	checkDisconnect(t, auth, newContext("client2", "dynamic", "dynamic"), true)
+}
+
+func TestAuthCertificate(t *testing.T) {
+	spec := &Spec{Certificate: &CertificateSpec{}}
+	auth := kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+
+	checkDisconnect(t, auth, newContextWithCert("client1", "alice", "", "alice"), false)
+	checkDisconnect(t, auth, newContextWithCert("client1", "bob", "", "alice"), true)
+	checkDisconnect(t, auth, newContextWithCert("client1", "", "", "alice"), true)
+	checkDisconnect(t, auth, newContext("client1", "alice", ""), true)
+
+	spec = &Spec{Certificate: &CertificateSpec{Identity: "clientID"}}
+	auth = kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+	checkDisconnect(t, auth, newContextWithCert("device1", "", "", "device1"), false)
+	checkDisconnect(t, auth, newContextWithCert("device2", "", "", "device1"), true)
+}
+
+func TestAuthJWT(t *testing.T) {
+	secret := []byte("jwt-secret")
+	spec := &Spec{JWT: &JWTSpec{
+		Algorithm: "HS256",
+		Secret:    hex.EncodeToString(secret),
+	}}
+	auth := kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+
+	sign := func(claims jwt.MapClaims, key []byte) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		assert.Nil(t, err)
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	checkDisconnect(t, auth, newContext("client1", "alice", sign(jwt.MapClaims{"sub": "alice", "exp": exp}, secret)), false)
+	checkDisconnect(t, auth, newContext("client1", "bob", sign(jwt.MapClaims{"sub": "alice", "exp": exp}, secret)), true)
+	checkDisconnect(t, auth, newContext("client1", "alice", sign(jwt.MapClaims{"sub": "alice", "exp": exp}, []byte("wrong"))), true)
+	checkDisconnect(t, auth, newContext("client1", "alice", sign(jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}, secret)), true)
+	checkDisconnect(t, auth, newContext("client1", "alice", "not a token"), true)
+
+	spec.JWT.UsernameClaim = "user"
+	auth.Init()
+	checkDisconnect(t, auth, newContext("client1", "alice", sign(jwt.MapClaims{"user": "alice"}, secret)), false)
+
+	_, err := newJWTAuth(&JWTSpec{Algorithm: "HS256"})
+	assert.NotNil(t, err)
+	_, err = newJWTAuth(&JWTSpec{Algorithm: "RS256", PublicKey: "abcd"})
+	assert.NotNil(t, err)
+}
+
+func TestAuthHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &httpAuthRequest{}
+		codectool.MustDecodeJSON(r.Body, req)
+		if r.Header.Get("X-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Username == "alice" && req.Password == "pass" || req.CertificateCN == req.ClientID {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	spec := &Spec{HTTP: &HTTPSpec{
+		URL:     server.URL,
+		Timeout: "1s",
+		Headers: map[string]string{"X-Token": "token"},
+	}}
+	auth := kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+
+	checkDisconnect(t, auth, newContext("client1", "alice", "pass"), false)
+	checkDisconnect(t, auth, newContext("client1", "alice", "wrong"), true)
+	checkDisconnect(t, auth, newContextWithCert("device1", "", "", "device1"), false)
+
+	spec.HTTP.Headers = nil
+	auth.Init()
+	checkDisconnect(t, auth, newContext("client1", "alice", "pass"), true)
+
+	spec.HTTP.URL = "http://127.0.0.1:1"
+	auth.Init()
+	checkDisconnect(t, auth, newContext("client1", "alice", "pass"), true)
+}
+
+func TestAuthMultipleBackends(t *testing.T) {
+	salt := "salt"
+	spec := &Spec{
+		Salt:        salt,
+		Auth:        []*Auth{{Username: "test", SaltedSha256Pass: sha256Sum([]byte("test" + salt))}},
+		Certificate: &CertificateSpec{},
+	}
+	auth := kind.CreateInstance(spec).(*MQTTClientAuth)
+	auth.Init()
+
+	checkDisconnect(t, auth, newContext("client1", "test", "test"), false)
+	checkDisconnect(t, auth, newContextWithCert("client2", "alice", "", "alice"), false)
+	checkDisconnect(t, auth, newContextWithCert("client3", "alice", "test", "bob"), true)
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package mqttwebsocket implements a filter to serve MQTT over WebSocket
+// connections of HTTPServer routes with MQTTProxy.
+package mqttwebsocket
+
+import (
+	"net/http"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/mqttproxy"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpstat"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+)
+
+const (
+	// Kind is the kind of MQTTWebSocket.
+	Kind = "MQTTWebSocket"
+
+	resultInternalError = "internalError"
+	resultClientError   = "clientError"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MQTTWebSocket hands over MQTT over WebSocket connections to MQTTProxy",
+	Results:     []string{resultInternalError, resultClientError},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MQTTWebSocket{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// MQTTWebSocket is the filter to serve MQTT over WebSocket connections
+	// with MQTTProxy, the connections share sessions, rules, rate limits
+	// and pipelines with TCP connections of the MQTTProxy.
+	MQTTWebSocket struct {
+		spec *Spec
+	}
+
+	// Spec is spec for MQTTWebSocket.
+	Spec struct {
+		filters.BaseSpec `json:",inline"`
+
+		MQTTProxy string `json:"mqttProxy" jsonschema:"required"`
+	}
+)
+
+var _ filters.Filter = (*MQTTWebSocket)(nil)
+
+// Name returns the name of the MQTTWebSocket filter instance.
+func (m *MQTTWebSocket) Name() string {
+	return m.spec.Name()
+}
+
+// Kind returns the kind of MQTTWebSocket.
+func (m *MQTTWebSocket) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MQTTWebSocket.
+func (m *MQTTWebSocket) Spec() filters.Spec {
+	return m.spec
+}
+
+// Init initializes MQTTWebSocket.
+func (m *MQTTWebSocket) Init() {
+}
+
+// Inherit inherits previous generation of MQTTWebSocket.
+func (m *MQTTWebSocket) Inherit(previousGeneration filters.Filter) {
+	m.Init()
+}
+
+// Close closes MQTTWebSocket.
+func (m *MQTTWebSocket) Close() {
+}
+
+// Status returns status of MQTTWebSocket.
+func (m *MQTTWebSocket) Status() interface{} {
+	return nil
+}
+
+func buildFailureResponse(ctx *context.Context, statusCode int) {
+	resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+	if resp == nil {
+		resp, _ = httpprot.NewResponse(nil)
+	}
+	resp.SetStatusCode(statusCode)
+	ctx.SetOutputResponse(resp)
+}
+
+// Handle hands over the connection to MQTTProxy, it returns after the
+// MQTT connection is closed.
+func (m *MQTTWebSocket) Handle(ctx *context.Context) (result string) {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	stdw, _ := ctx.GetData("HTTP_RESPONSE_WRITER").(http.ResponseWriter)
+	if stdw == nil {
+		logger.Errorf("%s: cannot get response writer from context", m.Name())
+		buildFailureResponse(ctx, http.StatusInternalServerError)
+		return resultInternalError
+	}
+
+	metric := &httpstat.Metric{}
+	startTime := fasttime.Now()
+
+	// ServeHTTP of websocket panics if the response writer doesn't support
+	// hijacking the connection.
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("%s: upgrade to websocket failed: %v", m.Name(), err)
+			buildFailureResponse(ctx, http.StatusBadRequest)
+			result = resultClientError
+		}
+	}()
+
+	found, err := mqttproxy.ServeWebSocket(m.spec.MQTTProxy, stdw, req.Std(), req.RealIP())
+	if !found {
+		logger.Errorf("%s: MQTTProxy %s not found", m.Name(), m.spec.MQTTProxy)
+		buildFailureResponse(ctx, http.StatusServiceUnavailable)
+		return resultInternalError
+	}
+
+	// the connection is hijacked, so the response is not sent by HTTPServer,
+	// and the failure response of handshake has been sent by websocket.
+	metric.StatusCode = http.StatusSwitchingProtocols
+	if err != nil {
+		logger.Debugf("%s: %v", m.Name(), err)
+		metric.StatusCode = http.StatusBadRequest
+		result = resultClientError
+	}
+	metric.Duration = fasttime.Since(startTime)
+	ctx.SetData("HTTP_METRIC", metric)
+	return result
+}
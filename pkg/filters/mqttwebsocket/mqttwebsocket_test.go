@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttwebsocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func TestMQTTWebSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlStr := `
+name: ws
+kind: MQTTWebSocket
+mqttProxy: not-exist
+`
+	spec := &Spec{}
+	assert.Nil(codectool.UnmarshalYAML([]byte(yamlStr), spec))
+	m := kind.CreateInstance(spec)
+	m.Init()
+	defer m.Close()
+	assert.Equal(kind, m.Kind())
+	assert.Nil(m.Status())
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/mqtt", nil)
+	req, _ := httpprot.NewRequest(stdr)
+
+	// no response writer
+	ctx := context.New(nil)
+	ctx.SetInputRequest(req)
+	assert.Equal(resultInternalError, m.Handle(ctx))
+	assert.Equal(http.StatusInternalServerError, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+
+	// MQTTProxy not found
+	ctx = context.New(nil)
+	ctx.SetInputRequest(req)
+	ctx.SetData("HTTP_RESPONSE_WRITER", httptest.NewRecorder())
+	assert.Equal(resultInternalError, m.Handle(ctx))
+	assert.Equal(http.StatusServiceUnavailable, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+}
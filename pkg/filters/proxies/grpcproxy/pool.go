@@ -278,6 +278,7 @@ func (sp *ServerPool) doHandle(ctx stdcontext.Context, spCtx *serverPoolContext)
 	}
 	send2ProviderCtx, cancelContext := stdcontext.WithCancel(metadata.NewOutgoingContext(ctx, spCtx.req.RawHeader().GetMD()))
 	defer cancelContext()
+	defer svr.Track(cancelContext)()
 	dialCtx, cancel := stdcontext.WithCancel(stdcontext.Background())
 	if sp.spec.ConnectTimeout != "" {
 		dialCtx, cancel = stdcontext.WithTimeout(dialCtx, sp.connectTimeout)
@@ -195,7 +195,9 @@ type ServerPoolSpec struct {
 
 // ServerPoolStatus is the status of Pool.
 type ServerPoolStatus struct {
-	Stat *httpstat.Status `json:"stat"`
+	Stat     *httpstat.Status        `json:"stat"`
+	Servers  []*proxies.ServerStatus `json:"servers,omitempty"`
+	Locality *proxies.LocalityStatus `json:"locality,omitempty"`
 }
 
 // NewServerPool creates a new server pool according to spec.
@@ -236,7 +238,11 @@ func (sp *ServerPool) CreateLoadBalancer(spec *LoadBalanceSpec, servers []*Serve
 }
 
 func (sp *ServerPool) status() *ServerPoolStatus {
-	s := &ServerPoolStatus{Stat: sp.httpStat.Status()}
+	s := &ServerPoolStatus{
+		Stat:     sp.httpStat.Status(),
+		Servers:  sp.ServerStatuses(),
+		Locality: sp.LocalityStatus(),
+	}
 	return s
 }
 
@@ -424,6 +430,18 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 		return serverPoolError{http.StatusServiceUnavailable, resultInternalError}
 	}
 
+	// track the request, so that it could be canceled when the server
+	// is drained. A stream response is untracked after its body is closed.
+	stdctx, cancel := stdcontext.WithCancel(stdctx)
+	untrack := svr.Track(cancel)
+	stream := false
+	defer func() {
+		if !stream {
+			untrack()
+			cancel()
+		}
+	}()
+
 	// prepare the request to send.
 	statResult := &gohttpstat.Result{}
 	stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
@@ -457,6 +475,20 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 		return serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
+	if spCtx.resp.IsStream() {
+		stream = true
+		done := func() {
+			untrack()
+			cancel()
+		}
+		spCtx.respCallbackBody.OnAfter(func(total int, p []byte, err error) {
+			if err != nil {
+				done()
+			}
+		})
+		spCtx.respCallbackBody.OnClose(done)
+	}
+
 	sp.LoadBalancer().ReturnServer(svr, spCtx.req, spCtx.resp)
 
 	spCtx.LazyAddTag(func() string {
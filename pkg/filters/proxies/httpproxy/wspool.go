@@ -184,6 +184,14 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 			return
 		}
 
+		// the connections are closed if the server is drained and they
+		// are not closed within the timeout.
+		untrack := svr.Track(func() {
+			svrConn.Close()
+			clntConn.Close()
+		})
+		defer untrack()
+
 		var wg sync.WaitGroup
 		wg.Add(2)
 
@@ -236,6 +244,8 @@ func (sp *WebSocketServerPool) handle(ctx *context.Context) (result string) {
 
 func (sp *WebSocketServerPool) status() *ServerPoolStatus {
 	return &ServerPoolStatus{
-		Stat: sp.httpStat.Status(),
+		Stat:     sp.httpStat.Status(),
+		Servers:  sp.ServerStatuses(),
+		Locality: sp.LocalityStatus(),
 	}
 }
@@ -59,6 +59,9 @@ type LoadBalanceSpec struct {
 	ForwardKey    string             `json:"forwardKey" jsonschema:"omitempty"`
 	StickySession *StickySessionSpec `json:"stickySession" jsonschema:"omitempty"`
 	HealthCheck   *HealthCheckSpec   `json:"healthCheck" jsonschema:"omitempty"`
+	SlowStart     *SlowStartSpec     `json:"slowStart" jsonschema:"omitempty"`
+	Draining      *DrainingSpec      `json:"draining" jsonschema:"omitempty"`
+	Locality      *LocalitySpec      `json:"locality" jsonschema:"omitempty"`
 }
 
 // LoadBalancePolicy is the interface of a load balance policy.
@@ -71,6 +74,8 @@ type GeneralLoadBalancer struct {
 	spec           *LoadBalanceSpec
 	servers        []*Server
 	healthyServers atomic.Pointer[ServerGroup]
+	warmServers    atomic.Pointer[warmServerGroup]
+	locality       atomic.Pointer[localityGroup]
 
 	done chan struct{}
 
@@ -85,10 +90,28 @@ func NewGeneralLoadBalancer(spec *LoadBalanceSpec, servers []*Server) *GeneralLo
 		spec:    spec,
 		servers: servers,
 	}
-	lb.healthyServers.Store(newServerGroup(servers))
+
+	// servers may be unhealthy if their states are inherited from the
+	// previous load balancer.
+	healthy := make([]*Server, 0, len(servers))
+	for _, svr := range servers {
+		if svr.Healthy() {
+			healthy = append(healthy, svr)
+		}
+	}
+	lb.storeHealthyServers(healthy)
 	return lb
 }
 
+// storeHealthyServers stores the healthy servers, and the servers to
+// route requests to if locality is enabled.
+func (glb *GeneralLoadBalancer) storeHealthyServers(servers []*Server) {
+	glb.healthyServers.Store(newServerGroup(servers))
+	if glb.spec.Locality != nil {
+		glb.locality.Store(newLocalityGroup(glb.spec.Locality, glb.servers, servers))
+	}
+}
+
 // Init initializes the load balancer.
 func (glb *GeneralLoadBalancer) Init(
 	fnNewSessionSticker func(*StickySessionSpec) SessionSticker,
@@ -172,6 +195,10 @@ func (glb *GeneralLoadBalancer) checkServers() {
 				logger.Warnf("server:%v becomes healthy.", svr.ID())
 				svr.Unhealth = false
 				changed = true
+				if svr.state != nil {
+					svr.state.undrain()
+					svr.state.startSlowStart(glb.spec.SlowStart, time.Now())
+				}
 			}
 		} else {
 			if svr.HealthCounter > 0 {
@@ -182,9 +209,16 @@ func (glb *GeneralLoadBalancer) checkServers() {
 				logger.Warnf("server:%v becomes unhealthy.", svr.ID())
 				svr.Unhealth = true
 				changed = true
+				if svr.state != nil && glb.spec.Draining != nil {
+					svr.state.drain(glb.drainTimeout())
+				}
 			}
 		}
 
+		if svr.state != nil {
+			svr.state.setHealth(svr.Unhealth, svr.HealthCounter)
+		}
+
 		if svr.Healthy() {
 			servers = append(servers, svr)
 		}
@@ -194,7 +228,7 @@ func (glb *GeneralLoadBalancer) checkServers() {
 		return
 	}
 
-	glb.healthyServers.Store(newServerGroup(servers))
+	glb.storeHealthyServers(servers)
 	if glb.ss != nil {
 		glb.ss.UpdateServers(servers)
 	}
@@ -203,6 +237,9 @@ func (glb *GeneralLoadBalancer) checkServers() {
 // ChooseServer chooses a server according to the load balancing spec.
 func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 	sg := glb.healthyServers.Load()
+	if lg := glb.locality.Load(); lg != nil {
+		sg = lg.servers
+	}
 	if sg == nil || len(sg.Servers) == 0 {
 		return nil
 	}
@@ -213,7 +250,71 @@ func (glb *GeneralLoadBalancer) ChooseServer(req protocols.Request) *Server {
 		}
 	}
 
-	return glb.lbp.ChooseServer(req, sg)
+	svr := glb.lbp.ChooseServer(req, sg)
+	if glb.spec.SlowStart == nil || svr == nil || svr.state == nil {
+		return svr
+	}
+
+	// a server in slow start is chosen at the probability of its slow
+	// start factor, otherwise, a warm server is chosen instead. This
+	// works with all load balance policies.
+	now := time.Now()
+	if f := svr.state.slowStartFactor(now); f < 1 && rand.Float64() >= f {
+		if warm := glb.getWarmServers(sg, now); len(warm.Servers) > 0 {
+			return glb.lbp.ChooseServer(req, warm)
+		}
+	}
+	return svr
+}
+
+// warmServerGroup is the cached group of servers which are not in slow
+// start, it expires when any server finishes its slow start.
+type warmServerGroup struct {
+	*ServerGroup
+	base   *ServerGroup
+	expire time.Time
+}
+
+func (glb *GeneralLoadBalancer) getWarmServers(sg *ServerGroup, now time.Time) *ServerGroup {
+	if warm := glb.warmServers.Load(); warm != nil && warm.base == sg && now.Before(warm.expire) {
+		return warm.ServerGroup
+	}
+
+	var servers []*Server
+	var expire time.Time
+	for _, svr := range sg.Servers {
+		if svr.state == nil || svr.state.slowStartFactor(now) >= 1 {
+			servers = append(servers, svr)
+			continue
+		}
+		end := time.Unix(0, atomic.LoadInt64(&svr.state.slowStartEnd))
+		if expire.IsZero() || end.Before(expire) {
+			expire = end
+		}
+	}
+
+	warm := &warmServerGroup{ServerGroup: newServerGroup(servers), base: sg, expire: expire}
+	glb.warmServers.Store(warm)
+	return warm.ServerGroup
+}
+
+// LocalityStatus returns the locality status, it returns nil if locality
+// is not enabled.
+func (glb *GeneralLoadBalancer) LocalityStatus() *LocalityStatus {
+	lg := glb.locality.Load()
+	if lg == nil {
+		return nil
+	}
+	status := lg.status
+	return &status
+}
+
+func (glb *GeneralLoadBalancer) drainTimeout() time.Duration {
+	if glb.spec.Draining == nil {
+		return 0
+	}
+	timeout, _ := time.ParseDuration(glb.spec.Draining.Timeout)
+	return timeout
 }
 
 // ReturnServer returns a server to the load balancer.
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"strings"
+)
+
+const (
+	defaultLocalityZoneLabel         = "zone"
+	defaultLocalityMinHealthyPercent = 70
+)
+
+// LocalitySpec is the spec of locality-aware load balancing, requests are
+// routed to the servers in the same zone as the Easegress member, and
+// spill over to servers in all zones when the local healthy capacity
+// drops below the threshold.
+//
+// The zone of the member is the value of its label, and the zone of a
+// server is the value of its tag in the format of 'label=zone', e.g.
+// 'zone=us-east-1a'.
+type LocalitySpec struct {
+	// ZoneLabel is the label of zone, default is 'zone'.
+	ZoneLabel string `json:"zoneLabel" jsonschema:"omitempty"`
+	// MinHealthyPercent is the threshold of the local healthy capacity,
+	// in percent of the average healthy capacity of all zones, requests
+	// spill over to all zones if it is not satisfied, default is 70.
+	MinHealthyPercent int `json:"minHealthyPercent" jsonschema:"omitempty,minimum=1,maximum=100"`
+}
+
+// LocalityStatus is the status of locality-aware load balancing.
+type LocalityStatus struct {
+	Zone                string `json:"zone"`
+	Zones               int    `json:"zones"`
+	HealthyServers      int    `json:"healthyServers"`
+	HealthyLocalServers int    `json:"healthyLocalServers"`
+	SpillOver           bool   `json:"spillOver"`
+}
+
+// localityGroup is the group of servers to route requests to.
+type localityGroup struct {
+	servers *ServerGroup
+	status  LocalityStatus
+}
+
+func (spec *LocalitySpec) zoneLabel() string {
+	if spec.ZoneLabel == "" {
+		return defaultLocalityZoneLabel
+	}
+	return spec.ZoneLabel
+}
+
+func (spec *LocalitySpec) minHealthyPercent() int {
+	if spec.MinHealthyPercent <= 0 {
+		return defaultLocalityMinHealthyPercent
+	}
+	return spec.MinHealthyPercent
+}
+
+// zone returns the zone of the server, which is the value of the tag
+// with the label as prefix.
+func (s *Server) zone(label string) string {
+	prefix := label + "="
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag, prefix) {
+			return tag[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// newLocalityGroup creates the locality group, servers are all servers of
+// the load balancer, and healthy are the healthy ones.
+//
+// The local healthy capacity is compared with the average of all zones
+// instead of the local servers, because unhealthy instances are removed
+// from service registries rather than marked as unhealthy.
+func newLocalityGroup(spec *LocalitySpec, servers, healthy []*Server) *localityGroup {
+	lg := &localityGroup{}
+
+	label := spec.zoneLabel()
+	zones := map[string]struct{}{}
+	for _, svr := range servers {
+		zones[svr.zone(label)] = struct{}{}
+	}
+	lg.status.Zones = len(zones)
+
+	local := make([]*Server, 0, len(healthy))
+	for _, svr := range healthy {
+		if svr.local {
+			local = append(local, svr)
+		}
+	}
+	lg.status.HealthyServers = len(healthy)
+	lg.status.HealthyLocalServers = len(local)
+
+	// len(local) / (len(healthy) / zones) < percent / 100
+	if len(local) == 0 || len(local)*lg.status.Zones*100 < len(healthy)*spec.minHealthyPercent() {
+		lg.status.SpillOver = true
+		lg.servers = newServerGroup(healthy)
+	} else {
+		lg.servers = newServerGroup(local)
+	}
+
+	return lg
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prepareZoneServers prepares count servers for each zone.
+func prepareZoneServers(count int, zones ...string) []*Server {
+	var svrs []*Server
+	for i, zone := range zones {
+		for j := 0; j < count; j++ {
+			svrs = append(svrs, &Server{
+				URL:  fmt.Sprintf("http://192.168.%d.%d", i+1, j+1),
+				Tags: []string{"zone=" + zone},
+			})
+		}
+	}
+	return svrs
+}
+
+func TestServerZone(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := &Server{Tags: []string{"v1", "zone=a", "region=r"}}
+	assert.Equal("a", svr.zone("zone"))
+	assert.Equal("r", svr.zone("region"))
+	assert.Equal("", svr.zone("node"))
+}
+
+func TestLocalityGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &LocalitySpec{}
+	servers := prepareZoneServers(3, "a", "b", "c")
+	for _, svr := range servers {
+		svr.local = svr.zone("zone") == "a"
+	}
+
+	lg := newLocalityGroup(spec, servers, servers)
+	assert.False(lg.status.SpillOver)
+	assert.Equal(3, lg.status.Zones)
+	assert.Equal(3, lg.status.HealthyLocalServers)
+	assert.Len(lg.servers.Servers, 3)
+
+	// one local server down: 2*3 >= 8*0.7
+	healthy := servers[1:]
+	lg = newLocalityGroup(spec, servers, healthy)
+	assert.False(lg.status.SpillOver)
+	assert.Len(lg.servers.Servers, 2)
+
+	// two local servers down: 1*3 < 7*0.7
+	healthy = servers[2:]
+	lg = newLocalityGroup(spec, servers, healthy)
+	assert.True(lg.status.SpillOver)
+	assert.Len(lg.servers.Servers, 7)
+
+	// a lower threshold.
+	lg = newLocalityGroup(&LocalitySpec{MinHealthyPercent: 40}, servers, healthy)
+	assert.False(lg.status.SpillOver)
+	assert.Len(lg.servers.Servers, 1)
+
+	// no local servers.
+	for _, svr := range servers {
+		svr.local = false
+	}
+	lg = newLocalityGroup(spec, servers, servers)
+	assert.True(lg.status.SpillOver)
+	assert.Len(lg.servers.Servers, 9)
+}
+
+func TestLocalityLoadBalancer(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &LoadBalanceSpec{Locality: &LocalitySpec{}}
+	sp := &ServerPoolBase{spImpl: &MockServerPoolImpl{}, localZone: "b"}
+	defer func() {
+		sp.LoadBalancer().Close()
+	}()
+
+	sp.createLoadBalancer(spec, prepareZoneServers(2, "a", "b"))
+	for i := 0; i < 10; i++ {
+		svr := sp.LoadBalancer().ChooseServer(nil)
+		assert.Equal("b", svr.zone("zone"))
+	}
+
+	status := sp.LocalityStatus()
+	assert.Equal(&LocalityStatus{
+		Zone:                "b",
+		Zones:               2,
+		HealthyServers:      4,
+		HealthyLocalServers: 2,
+	}, status)
+
+	// all local servers are removed from the service registry.
+	sp.createLoadBalancer(spec, prepareZoneServers(2, "a"))
+	svr := sp.LoadBalancer().ChooseServer(nil)
+	assert.Equal("a", svr.zone("zone"))
+	assert.True(sp.LocalityStatus().SpillOver)
+
+	// locality is not enabled.
+	sp.createLoadBalancer(&LoadBalanceSpec{}, prepareZoneServers(2, "a"))
+	assert.Nil(sp.LocalityStatus())
+}
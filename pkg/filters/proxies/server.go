@@ -35,6 +35,13 @@ type Server struct {
 	// HealthCounter is used to count the number of successive health checks
 	// result, positive for healthy, negative for unhealthy
 	HealthCounter int `json:"-"`
+
+	// state is the runtime state shared by the servers with the same ID
+	// across load balancers of a server pool.
+	state *serverState
+	// local is whether the server is in the same zone as the Easegress
+	// member, it is only used by locality-aware load balancing.
+	local bool
 }
 
 // String implements the Stringer interface.
@@ -20,8 +20,10 @@ package proxies
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
@@ -41,6 +43,15 @@ type ServerPoolBase struct {
 	done         chan struct{}
 	wg           sync.WaitGroup
 	loadBalancer atomic.Value
+
+	// states are the runtime states of servers, keyed by server ID,
+	// including the draining servers which have been removed.
+	statesMutex sync.Mutex
+	states      map[string]*serverState
+	current     map[string]struct{}
+
+	// localZone is the zone of the Easegress member.
+	localZone string
 }
 
 // ServerPoolBaseSpec is the spec for a base server pool.
@@ -82,6 +93,16 @@ func (spb *ServerPoolBase) Init(spImpl ServerPoolImpl, super *supervisor.Supervi
 	spb.Name = name
 	spb.done = make(chan struct{})
 
+	if lbs := spec.LoadBalance; lbs != nil && lbs.Locality != nil {
+		label := lbs.Locality.zoneLabel()
+		if super != nil {
+			spb.localZone = super.Options().Labels[label]
+		}
+		if spb.localZone == "" {
+			logger.Warnf("%s: label %s of the member is empty, all servers are treated as remote", name, label)
+		}
+	}
+
 	if spec.ServiceRegistry == "" || spec.ServiceName == "" {
 		spb.createLoadBalancer(spec.LoadBalance, spec.Servers)
 		return
@@ -133,16 +154,128 @@ func (spb *ServerPoolBase) createLoadBalancer(spec *LoadBalanceSpec, servers []*
 		spec = &LoadBalanceSpec{}
 	}
 
+	spb.attachStates(spec, servers)
+	if spec.Locality != nil {
+		label := spec.Locality.zoneLabel()
+		for _, svr := range servers {
+			svr.local = spb.localZone != "" && svr.zone(label) == spb.localZone
+		}
+	}
+
 	lb := spb.spImpl.CreateLoadBalancer(spec, servers)
 	if old := spb.loadBalancer.Swap(lb); old != nil {
 		old.(LoadBalancer).Close()
 	}
 }
 
+// attachStates attaches the runtime states to the servers, the states of
+// existing servers are inherited, new servers start slow start, and the
+// removed servers start draining.
+func (spb *ServerPoolBase) attachStates(spec *LoadBalanceSpec, servers []*Server) {
+	spb.statesMutex.Lock()
+	defer spb.statesMutex.Unlock()
+
+	// servers of the first load balancer are not new servers.
+	initial := spb.states == nil
+	if initial {
+		spb.states = make(map[string]*serverState)
+	}
+
+	now := time.Now()
+	current := make(map[string]struct{}, len(servers))
+	for _, svr := range servers {
+		id := svr.ID()
+		current[id] = struct{}{}
+
+		st := spb.states[id]
+		if st == nil {
+			st = newServerState(svr)
+			if !initial {
+				st.startSlowStart(spec.SlowStart, now)
+			}
+			spb.states[id] = st
+		} else {
+			st.undrain()
+			st.mutex.Lock()
+			st.server = svr
+			st.mutex.Unlock()
+			svr.Unhealth, svr.HealthCounter = st.health()
+		}
+		svr.state = st
+	}
+
+	for id, st := range spb.states {
+		if _, ok := current[id]; ok {
+			continue
+		}
+		if spec.Draining == nil {
+			delete(spb.states, id)
+			continue
+		}
+
+		timeout, _ := time.ParseDuration(spec.Draining.Timeout)
+		if st.drain(timeout) {
+			delete(spb.states, id)
+		}
+	}
+
+	spb.current = current
+}
+
+// LocalityStatus returns the status of locality-aware load balancing, it
+// returns nil if locality is not enabled.
+func (spb *ServerPoolBase) LocalityStatus() *LocalityStatus {
+	lb, ok := spb.LoadBalancer().(*GeneralLoadBalancer)
+	if !ok {
+		return nil
+	}
+
+	status := lb.LocalityStatus()
+	if status != nil {
+		status.Zone = spb.localZone
+	}
+	return status
+}
+
+// ServerStatuses returns the statuses of the servers, including the
+// draining servers which have been removed.
+func (spb *ServerPoolBase) ServerStatuses() []*ServerStatus {
+	spb.statesMutex.Lock()
+	defer spb.statesMutex.Unlock()
+
+	now := time.Now()
+	result := make([]*ServerStatus, 0, len(spb.states))
+	for id, st := range spb.states {
+		status := st.status(now)
+		// a removed server is forgotten once it finishes draining.
+		if status.State == ServerStateDraining && status.InFlight == 0 && !spb.inCurrent(id) {
+			delete(spb.states, id)
+			continue
+		}
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
+
+func (spb *ServerPoolBase) inCurrent(id string) bool {
+	_, ok := spb.current[id]
+	return ok
+}
+
 func (spb *ServerPoolBase) useService(spec *ServerPoolBaseSpec, instances map[string]*serviceregistry.ServiceInstanceSpec) {
 	servers := make([]*Server, 0)
 
 	for _, instance := range instances {
+		// draining instances receive no new requests, they are drained
+		// like the removed instances.
+		if instance.Draining {
+			continue
+		}
+
 		// default to true in case of sp.spec.ServerTags is empty
 		match := true
 
@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ServerStateActive means the server receives requests at its full weight.
+	ServerStateActive = "active"
+	// ServerStateSlowStart means the server is warming up, and receives
+	// less requests than its weight.
+	ServerStateSlowStart = "slowStart"
+	// ServerStateDraining means the server receives no new requests, and
+	// waits for in-flight requests to finish.
+	ServerStateDraining = "draining"
+	// ServerStateUnhealthy means the server failed the health check.
+	ServerStateUnhealthy = "unhealthy"
+
+	defaultSlowStartMinWeightPercent = 10
+)
+
+// SlowStartSpec is the spec of slow start, the effective weight of a newly
+// added or recovered server ramps up linearly over the window.
+type SlowStartSpec struct {
+	Window string `json:"window" jsonschema:"required,format=duration"`
+	// MinWeightPercent is the effective weight percent at the beginning
+	// of the window, default is 10.
+	MinWeightPercent int `json:"minWeightPercent" jsonschema:"omitempty,minimum=1,maximum=100"`
+}
+
+// DrainingSpec is the spec of draining, a removed or unhealthy server
+// receives no new requests, and its in-flight requests and connections
+// are terminated if they are not finished within the timeout.
+type DrainingSpec struct {
+	Timeout string `json:"timeout" jsonschema:"required,format=duration"`
+}
+
+// ServerStatus is the runtime status of a server in a server pool.
+type ServerStatus struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	State  string `json:"state"`
+	// WeightPercent is the percent of the effective weight in slow start.
+	WeightPercent int   `json:"weightPercent,omitempty"`
+	InFlight      int64 `json:"inFlight"`
+}
+
+// serverState is the runtime state of a server, it is shared by all
+// generations of load balancers of a server pool, so that it survives
+// the changes of servers.
+type serverState struct {
+	// slow start window in unix nano, they are zero if not in slow start.
+	slowStartBegin int64
+	slowStartEnd   int64
+	minPercent     int64
+
+	mutex         sync.Mutex
+	server        *Server
+	inFlight      int64
+	nextID        uint64
+	cancels       map[uint64]func()
+	draining      bool
+	drainTimer    *time.Timer
+	unhealthy     bool
+	healthCounter int
+}
+
+func newServerState(server *Server) *serverState {
+	return &serverState{
+		server:  server,
+		cancels: make(map[uint64]func()),
+	}
+}
+
+// startSlowStart starts the slow start window from now.
+func (st *serverState) startSlowStart(spec *SlowStartSpec, now time.Time) {
+	if spec == nil {
+		return
+	}
+
+	window, err := time.ParseDuration(spec.Window)
+	if err != nil || window <= 0 {
+		return
+	}
+
+	percent := spec.MinWeightPercent
+	if percent <= 0 {
+		percent = defaultSlowStartMinWeightPercent
+	}
+	atomic.StoreInt64(&st.minPercent, int64(percent))
+	atomic.StoreInt64(&st.slowStartEnd, now.Add(window).UnixNano())
+	atomic.StoreInt64(&st.slowStartBegin, now.UnixNano())
+}
+
+// slowStartFactor returns the factor of the effective weight, which is
+// 1 if the server is not in slow start.
+func (st *serverState) slowStartFactor(now time.Time) float64 {
+	end := atomic.LoadInt64(&st.slowStartEnd)
+	if end == 0 {
+		return 1
+	}
+
+	n := now.UnixNano()
+	if n >= end {
+		return 1
+	}
+
+	begin := atomic.LoadInt64(&st.slowStartBegin)
+	f := float64(n-begin) / float64(end-begin)
+	if min := float64(atomic.LoadInt64(&st.minPercent)) / 100; f < min {
+		f = min
+	}
+	return f
+}
+
+// track tracks an in-flight request or connection.
+func (st *serverState) track(cancel func()) func() {
+	st.mutex.Lock()
+	id := st.nextID
+	st.nextID++
+	st.inFlight++
+	if cancel != nil {
+		st.cancels[id] = cancel
+	}
+	st.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			st.mutex.Lock()
+			defer st.mutex.Unlock()
+			st.inFlight--
+			delete(st.cancels, id)
+			if st.draining && st.inFlight == 0 && st.drainTimer != nil {
+				// all requests finished, no need to evict them.
+				st.drainTimer.Stop()
+				st.drainTimer = nil
+			}
+		})
+	}
+}
+
+// drain starts draining the server, in-flight requests are canceled if
+// they are not finished within the timeout. It returns whether the server
+// is idle, that's, there's no in-flight requests.
+func (st *serverState) drain(timeout time.Duration) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if !st.draining {
+		st.draining = true
+		if st.inFlight > 0 && timeout > 0 {
+			st.drainTimer = time.AfterFunc(timeout, st.evict)
+		}
+	}
+	return st.inFlight == 0
+}
+
+// undrain stops draining the server.
+func (st *serverState) undrain() {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.draining = false
+	if st.drainTimer != nil {
+		st.drainTimer.Stop()
+		st.drainTimer = nil
+	}
+}
+
+// evict cancels all in-flight requests of a draining server.
+func (st *serverState) evict() {
+	st.mutex.Lock()
+	if !st.draining {
+		st.mutex.Unlock()
+		return
+	}
+	cancels := st.cancels
+	st.cancels = make(map[uint64]func())
+	st.drainTimer = nil
+	st.mutex.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// idle returns whether the server is draining and has no in-flight requests.
+func (st *serverState) idle() bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return st.draining && st.inFlight == 0
+}
+
+func (st *serverState) setHealth(unhealthy bool, counter int) {
+	st.mutex.Lock()
+	st.unhealthy, st.healthCounter = unhealthy, counter
+	st.mutex.Unlock()
+}
+
+func (st *serverState) health() (bool, int) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return st.unhealthy, st.healthCounter
+}
+
+func (st *serverState) status(now time.Time) *ServerStatus {
+	st.mutex.Lock()
+	s := &ServerStatus{
+		URL:      st.server.URL,
+		Weight:   st.server.Weight,
+		State:    ServerStateActive,
+		InFlight: st.inFlight,
+	}
+	draining, unhealthy := st.draining, st.unhealthy
+	st.mutex.Unlock()
+
+	if f := st.slowStartFactor(now); f < 1 {
+		s.State = ServerStateSlowStart
+		s.WeightPercent = int(f * 100)
+	}
+	if unhealthy {
+		s.State = ServerStateUnhealthy
+	}
+	if draining {
+		s.State = ServerStateDraining
+	}
+	return s
+}
+
+// Track tracks an in-flight request or connection to the server, cancel
+// is called to terminate the request if the server is being drained and
+// the request is not finished within the draining timeout. The returned
+// function must be called when the request finishes.
+func (s *Server) Track(cancel func()) func() {
+	if s.state == nil {
+		return func() {}
+	}
+	return s.state.track(cancel)
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxies
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	st := newServerState(&Server{URL: "http://192.168.1.1"})
+	now := time.Now()
+	assert.Equal(1.0, st.slowStartFactor(now))
+
+	st.startSlowStart(&SlowStartSpec{Window: "10s"}, now)
+	assert.InDelta(0.1, st.slowStartFactor(now), 0.001)
+	assert.InDelta(0.1, st.slowStartFactor(now.Add(time.Second/2)), 0.001)
+	assert.InDelta(0.5, st.slowStartFactor(now.Add(5*time.Second)), 0.001)
+	assert.Equal(1.0, st.slowStartFactor(now.Add(10*time.Second)))
+
+	st.startSlowStart(&SlowStartSpec{Window: "10s", MinWeightPercent: 50}, now)
+	assert.InDelta(0.5, st.slowStartFactor(now.Add(time.Second)), 0.001)
+
+	status := st.status(now.Add(8 * time.Second))
+	assert.Equal(ServerStateSlowStart, status.State)
+	assert.Equal(80, status.WeightPercent)
+
+	// invalid windows are ignored.
+	st = newServerState(&Server{URL: "http://192.168.1.1"})
+	st.startSlowStart(&SlowStartSpec{Window: "0s"}, now)
+	assert.Equal(1.0, st.slowStartFactor(now))
+}
+
+func TestSlowStartChooseServer(t *testing.T) {
+	assert := assert.New(t)
+	rand.Seed(0)
+
+	for _, policy := range []string{LoadBalancePolicyRoundRobin, LoadBalancePolicyRandom, LoadBalancePolicyWeightedRandom} {
+		servers := []*Server{{URL: "http://192.168.1.1", Weight: 1}, {URL: "http://192.168.1.2", Weight: 1}}
+		spec := &LoadBalanceSpec{Policy: policy, SlowStart: &SlowStartSpec{Window: "1h"}}
+
+		servers[0].state = newServerState(servers[0])
+		servers[1].state = newServerState(servers[1])
+		servers[1].state.startSlowStart(spec.SlowStart, time.Now())
+
+		lb := NewGeneralLoadBalancer(spec, servers)
+		lb.Init(nil, nil, nil)
+
+		counter := map[string]int{}
+		for i := 0; i < 10000; i++ {
+			counter[lb.ChooseServer(nil).URL]++
+		}
+		lb.Close()
+
+		// the server in slow start receives about 10% of its share, the
+		// round robin policy is less precise as rechoosing moves its cursor.
+		cold := counter["http://192.168.1.2"]
+		assert.Greater(cold, 300, policy)
+		assert.Less(cold, 1000, policy)
+	}
+}
+
+func TestServerDraining(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := &Server{URL: "http://192.168.1.1"}
+	svr.state = newServerState(svr)
+
+	canceled := make(chan struct{}, 2)
+	untrack1 := svr.Track(func() { canceled <- struct{}{} })
+	untrack2 := svr.Track(func() { canceled <- struct{}{} })
+	assert.Equal(int64(2), svr.state.status(time.Now()).InFlight)
+
+	assert.False(svr.state.drain(50 * time.Millisecond))
+	assert.Equal(ServerStateDraining, svr.state.status(time.Now()).State)
+
+	// untrack is idempotent.
+	untrack1()
+	untrack1()
+	assert.False(svr.state.idle())
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("request is not canceled after the draining timeout")
+	}
+	assert.Len(canceled, 0)
+
+	untrack2()
+	assert.True(svr.state.idle())
+
+	svr.state.undrain()
+	assert.False(svr.state.idle())
+	assert.Equal(ServerStateActive, svr.state.status(time.Now()).State)
+
+	// servers without state are not tracked.
+	untrack := (&Server{}).Track(nil)
+	untrack()
+}
+
+func TestAttachStates(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &LoadBalanceSpec{
+		SlowStart: &SlowStartSpec{Window: "1h"},
+		Draining:  &DrainingSpec{Timeout: "1h"},
+	}
+	sp := &ServerPoolBase{spImpl: &MockServerPoolImpl{}}
+	defer func() {
+		sp.LoadBalancer().Close()
+	}()
+
+	// servers of the first load balancer are not in slow start.
+	svr1 := &Server{URL: "http://192.168.1.1"}
+	sp.createLoadBalancer(spec, []*Server{svr1})
+	statuses := sp.ServerStatuses()
+	assert.Len(statuses, 1)
+	assert.Equal(ServerStateActive, statuses[0].State)
+
+	untrack := svr1.Track(nil)
+
+	// new servers are in slow start, states of existing servers are
+	// inherited, and removed servers are drained.
+	svr1b := &Server{URL: "http://192.168.1.1"}
+	svr2 := &Server{URL: "http://192.168.1.2"}
+	sp.createLoadBalancer(spec, []*Server{svr1b, svr2})
+	assert.Equal(svr1.state, svr1b.state)
+	assert.Equal(ServerStateSlowStart, svr2.state.status(time.Now()).State)
+
+	sp.createLoadBalancer(spec, []*Server{svr2})
+	statuses = sp.ServerStatuses()
+	assert.Len(statuses, 2)
+	assert.Equal(ServerStateDraining, statuses[0].State)
+	assert.Equal(int64(1), statuses[0].InFlight)
+
+	// a removed server is forgotten after it finishes draining.
+	untrack()
+	statuses = sp.ServerStatuses()
+	assert.Len(statuses, 1)
+	assert.Equal("http://192.168.1.2", statuses[0].URL)
+
+	// removed servers are forgotten immediately without draining.
+	sp.createLoadBalancer(&LoadBalanceSpec{}, []*Server{svr1})
+	statuses = sp.ServerStatuses()
+	assert.Len(statuses, 1)
+	assert.Equal("http://192.168.1.1", statuses[0].URL)
+}
@@ -133,3 +133,58 @@ func getTopicMapFunc(topicMapper *Spec) topicMapFunc {
 	}
 	return f
 }
+
+// ReverseMapFunc maps the topic and headers of a backend message queue
+// message back to the MQTT topic.
+type ReverseMapFunc func(topic string, headers map[string]string) (mqttTopic string, err error)
+
+// NewReverseMapFunc returns the ReverseMapFunc of the topic mapping rules
+// in spec. The MQTT topic is built from the headers at the level indexes
+// of a policy, and levels before the first header come from prefix, like
+// "/" or "nothing". The MQTT topic must be mapped to the same topic by
+// the rules.
+func NewReverseMapFunc(spec *Spec, prefix string) ReverseMapFunc {
+	mapFunc := getTopicMapFunc(spec)
+
+	leadingSlash := strings.HasPrefix(prefix, "/")
+	prefixLevels := strings.Split(strings.Trim(prefix, "/"), "/")
+	if prefixLevels[0] == "" {
+		prefixLevels = nil
+	}
+
+	build := func(p *Policy, headers map[string]string) (string, bool) {
+		levels := append([]string{}, prefixLevels...)
+		for i := len(levels); ; i++ {
+			name, ok := p.Headers[i]
+			if !ok {
+				break
+			}
+			level, ok := headers[name]
+			if !ok {
+				break
+			}
+			levels = append(levels, level)
+		}
+		if len(levels) <= p.TopicIndex {
+			return "", false
+		}
+		mqttTopic := strings.Join(levels, "/")
+		if leadingSlash {
+			mqttTopic = "/" + mqttTopic
+		}
+		return mqttTopic, true
+	}
+
+	return func(topic string, headers map[string]string) (string, error) {
+		for _, p := range spec.Policies {
+			mqttTopic, ok := build(p, headers)
+			if !ok {
+				continue
+			}
+			if t, _, err := mapFunc(mqttTopic); err == nil && t == topic {
+				return mqttTopic, nil
+			}
+		}
+		return "", fmt.Errorf("no policy match topic <%s> with headers <%v>", topic, headers)
+	}
+}
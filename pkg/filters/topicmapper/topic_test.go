@@ -232,3 +232,47 @@ func TestTopicMap2(t *testing.T) {
 		t.Errorf("map func should return err for not match topic")
 	}
 }
+
+func TestReverseMap(t *testing.T) {
+	spec := getDefaultSpec()
+	mapFunc := getTopicMapFunc(spec)
+	reverseFunc := NewReverseMapFunc(spec, "/")
+	for _, mqttTopic := range []string{
+		"/d2s/abc/phone/123/log/error",
+		"/d2s/opq/car/345/raw",
+		"/g2s/gw123/gwInfo234/gwID345/d2s/456/654/123/raw",
+	} {
+		topic, headers, err := mapFunc(mqttTopic)
+		if err != nil {
+			t.Fatalf("map topic <%s> failed: %v", mqttTopic, err)
+		}
+		got, err := reverseFunc(topic, headers)
+		if err != nil || got != mqttTopic {
+			t.Errorf("reverse map of <%s> got <%s>, err <%v>", mqttTopic, got, err)
+		}
+	}
+
+	headers := map[string]string{"d2s": "d2s", "tenant": "abc", "device_type": "phone", "things_id": "123", "event": "log"}
+	if _, err := reverseFunc("to_raw", headers); err == nil {
+		t.Errorf("reverse map should fail for wrong topic")
+	}
+	delete(headers, "event")
+	if _, err := reverseFunc("to_cloud", headers); err == nil {
+		t.Errorf("reverse map should fail for missing topic level")
+	}
+
+	spec.MatchIndex = 1
+	for _, p := range spec.Policies {
+		p.TopicIndex++
+		levels := map[int]string{}
+		for k, v := range p.Headers {
+			levels[k+1] = v
+		}
+		p.Headers = levels
+	}
+	reverseFunc = NewReverseMapFunc(spec, "nothing")
+	got, err := reverseFunc("to_cloud", map[string]string{"d2s": "d2s", "tenant": "abc", "device_type": "phone", "things_id": "123", "event": "status"})
+	if err != nil || got != "nothing/d2s/abc/phone/123/status" {
+		t.Errorf("reverse map with prefix got <%s>, err <%v>", got, err)
+	}
+}
@@ -80,7 +80,8 @@ type (
 
 // Validate verifies that at least one of the validations is defined.
 func (spec Spec) Validate() error {
-	if spec == (Spec{}) {
+	if spec.Headers == nil && spec.JWT == nil && spec.Signature == nil &&
+		spec.OAuth2 == nil && spec.BasicAuth == nil {
 		return fmt.Errorf("none of the validations are defined")
 	}
 	return nil
@@ -0,0 +1,566 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testACMEServer is a Pebble-like ACME server for testing, all
+// authorizations are valid without challenges, certificates are issued by
+// its CA, and it has an OCSP responder.
+type testACMEServer struct {
+	*httptest.Server
+
+	// eabKeys are the HMAC keys of external account bindings, keyed by key
+	// ID, accounts must be bound to one of them if it is not empty, and
+	// every key can only be used once.
+	eabKeys map[string][]byte
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mutex  sync.Mutex
+	serial int64
+	// accounts are the registered accounts, keyed by their public keys.
+	accounts map[string]bool
+	usedEAB  map[string]bool
+	orders   map[string][]string
+	certs    map[string][]byte
+	// issued are the domains of the issued certificates.
+	issued []string
+}
+
+func newTestACMEServer(t *testing.T, eabKeys map[string][]byte) *testACMEServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(der)
+
+	s := &testACMEServer{
+		eabKeys:  eabKeys,
+		caKey:    caKey,
+		caCert:   caCert,
+		serial:   1,
+		accounts: map[string]bool{},
+		usedEAB:  map[string]bool{},
+		orders:   map[string][]string{},
+		certs:    map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *testACMEServer) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	data, _ := codectool.MarshalJSON(v)
+	w.Write(data)
+}
+
+func (s *testACMEServer) writeError(w http.ResponseWriter, code int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	w.Write([]byte(fmt.Sprintf(`{"type": "urn:ietf:params:acme:error:unauthorized", "detail": %q}`, detail)))
+}
+
+func (s *testACMEServer) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+
+	path := r.URL.Path
+	switch {
+	case path == "/dir":
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+			"revokeCert": s.URL + "/revoke",
+			"keyChange":  s.URL + "/key-change",
+		})
+	case path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case path == "/account":
+		s.newAccount(w, r)
+	case path == "/order":
+		s.newOrder(w, r)
+	case strings.HasPrefix(path, "/order/"):
+		id := strings.TrimPrefix(path, "/order/")
+		s.writeJSON(w, http.StatusOK, s.order(id))
+	case strings.HasPrefix(path, "/authz/"):
+		s.mutex.Lock()
+		domain := s.orders[strings.TrimPrefix(path, "/authz/")][0]
+		s.mutex.Unlock()
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":     "valid",
+			"identifier": map[string]string{"type": "dns", "value": domain},
+		})
+	case strings.HasPrefix(path, "/finalize/"):
+		s.finalize(w, r, strings.TrimPrefix(path, "/finalize/"))
+	case strings.HasPrefix(path, "/cert/"):
+		s.mutex.Lock()
+		data := s.certs[strings.TrimPrefix(path, "/cert/")]
+		s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(data)
+	case path == "/ocsp":
+		s.ocsp(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *testACMEServer) newAccount(w http.ResponseWriter, r *http.Request) {
+	var jws struct{ Protected, Payload string }
+	if err := codectool.DecodeJSON(r.Body, &jws); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var header struct {
+		JWK struct{ X, Y string } `json:"jwk"`
+	}
+	protected, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	codectool.UnmarshalJSON(protected, &header)
+	account := header.JWK.X + "." + header.JWK.Y
+
+	var req struct {
+		OnlyReturnExisting bool `json:"onlyReturnExisting"`
+		EAB                *struct {
+			Protected string `json:"protected"`
+			Payload   string `json:"payload"`
+			Signature string `json:"signature"`
+		} `json:"externalAccountBinding"`
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err := codectool.UnmarshalJSON(payload, &req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.accounts[account] {
+		w.Header().Set("Location", s.URL+"/account/1")
+		s.writeJSON(w, http.StatusOK, map[string]interface{}{"status": "valid"})
+		return
+	}
+	if req.OnlyReturnExisting {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type": "urn:ietf:params:acme:error:accountDoesNotExist"}`))
+		return
+	}
+
+	if len(s.eabKeys) > 0 {
+		if req.EAB == nil {
+			s.writeError(w, http.StatusUnauthorized, "external account binding is required")
+			return
+		}
+
+		protected, _ := base64.RawURLEncoding.DecodeString(req.EAB.Protected)
+		var header struct {
+			KID string `json:"kid"`
+		}
+		codectool.UnmarshalJSON(protected, &header)
+
+		key := s.eabKeys[header.KID]
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(req.EAB.Protected + "." + req.EAB.Payload))
+		sig, _ := base64.RawURLEncoding.DecodeString(req.EAB.Signature)
+		if key == nil || !hmac.Equal(sig, mac.Sum(nil)) {
+			s.writeError(w, http.StatusUnauthorized, "invalid external account binding")
+			return
+		}
+		if s.usedEAB[header.KID] {
+			s.writeError(w, http.StatusUnauthorized, "external account binding is used")
+			return
+		}
+		s.usedEAB[header.KID] = true
+	}
+	s.accounts[account] = true
+
+	w.Header().Set("Location", s.URL+"/account/1")
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{"status": "valid"})
+}
+
+func (s *testACMEServer) newOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+	if err := decodePayload(&req, r.Body); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	s.serial++
+	id := fmt.Sprint(s.serial)
+	for _, ident := range req.Identifiers {
+		s.orders[id] = append(s.orders[id], ident.Value)
+	}
+	s.mutex.Unlock()
+
+	w.Header().Set("Location", s.URL+"/order/"+id)
+	s.writeJSON(w, http.StatusCreated, s.order(id))
+}
+
+func (s *testACMEServer) order(id string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	order := map[string]interface{}{
+		"status":         "ready",
+		"authorizations": []string{s.URL + "/authz/" + id},
+		"finalize":       s.URL + "/finalize/" + id,
+	}
+	if _, ok := s.certs[id]; ok {
+		order["status"] = "valid"
+		order["certificate"] = s.URL + "/cert/" + id
+	}
+	return order
+}
+
+func (s *testACMEServer) finalize(w http.ResponseWriter, r *http.Request, id string) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := decodePayload(&req, r.Body); err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	s.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(s.serial),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{s.URL + "/ocsp"},
+	}
+	s.mutex.Unlock()
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...)
+
+	s.mutex.Lock()
+	s.certs[id] = chain
+	s.issued = append(s.issued, csr.DNSNames...)
+	s.mutex.Unlock()
+
+	w.Header().Set("Location", s.URL+"/order/"+id)
+	s.writeJSON(w, http.StatusOK, s.order(id))
+}
+
+func (s *testACMEServer) ocsp(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	resp, err := ocsp.CreateResponse(s.caCert, s.caCert, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   now.Add(24 * time.Hour),
+	}, s.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+func (s *testACMEServer) issuedDomains() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.issued...)
+}
+
+func TestSpecValidateIssuers(t *testing.T) {
+	assert := assert.New(t)
+
+	newSpec := func() *Spec {
+		return &Spec{
+			DirectoryURL: "https://acme.example.com/dir",
+			EnableHTTP01: true,
+			Issuers: []IssuerSpec{{
+				Name:         "zerossl",
+				DirectoryURL: "https://acme.zerossl.com/v2/DV90",
+				ExternalAccountBinding: &EABSpec{
+					KeyID:   "kid",
+					HMACKey: base64.RawURLEncoding.EncodeToString([]byte("secret")),
+				},
+			}},
+			Domains: []DomainSpec{{Name: "www.megaease.com", Issuer: "zerossl"}},
+		}
+	}
+
+	spec := newSpec()
+	assert.NoError(spec.Validate())
+
+	spec = newSpec()
+	spec.Domains[0].Issuer = "unknown"
+	assert.Error(spec.Validate())
+
+	spec = newSpec()
+	spec.Issuers = append(spec.Issuers, spec.Issuers[0])
+	assert.Error(spec.Validate())
+
+	spec = newSpec()
+	spec.Issuers[0].ExternalAccountBinding.HMACKey = "!invalid!"
+	assert.Error(spec.Validate())
+
+	spec = newSpec()
+	spec.Export = &ExportSpec{}
+	assert.Error(spec.Validate())
+
+	// padded base64url keys are also supported.
+	eab := &EABSpec{KeyID: "kid", HMACKey: base64.URLEncoding.EncodeToString([]byte("secret"))}
+	key, err := eab.decodeHMACKey()
+	assert.NoError(err)
+	assert.Equal([]byte("secret"), key)
+}
+
+func TestAutoCertManagerIssuers(t *testing.T) {
+	assert := assert.New(t)
+
+	eabKey := []byte("eab-hmac-key")
+	defaultCA := newTestACMEServer(t, nil)
+	defer defaultCA.Close()
+	eabCA := newTestACMEServer(t, map[string][]byte{"kid-1": eabKey})
+	defer eabCA.Close()
+
+	exportDir := t.TempDir()
+	yamlConfig := fmt.Sprintf(`
+name: autocert
+kind: AutoCertManager
+email: someone@megaease.com
+renewBefore: 720h
+directoryURL: %s/dir
+ocspStapling: true
+issuers:
+  - name: internal
+    directoryURL: %s/dir
+    externalAccountBinding:
+      keyID: kid-1
+      hmacKey: %s
+  - name: badeab
+    directoryURL: %s/dir
+    externalAccountBinding:
+      keyID: kid-1
+      hmacKey: %s
+export:
+  dir: %s
+  customDataKind: certificates
+domains:
+  - name: "a.megaease.com"
+  - name: "b.megaease.com"
+    issuer: internal
+  - name: "c.megaease.com"
+    issuer: badeab
+`, defaultCA.URL, eabCA.URL, base64.RawURLEncoding.EncodeToString(eabKey),
+		eabCA.URL, base64.RawURLEncoding.EncodeToString([]byte("wrong")), exportDir)
+
+	etcdDirName := t.TempDir()
+	cls := cluster.CreateClusterForTest(etcdDirName)
+	defer func() {
+		closeWG := &sync.WaitGroup{}
+		closeWG.Add(1)
+		cls.CloseServer(closeWG)
+		closeWG.Wait()
+	}()
+	supervisor.MustNew(&option.Options{}, cls)
+
+	store := customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+	assert.NoError(store.PutKind(&customdata.Kind{Name: "certificates"}, false))
+
+	spec, err := supervisor.NewSpec(yamlConfig)
+	if !assert.NoError(err) {
+		return
+	}
+
+	acm := &AutoCertManager{}
+	acm.Init(spec)
+	defer func() {
+		acm.Close()
+	}()
+
+	domainStatus := func(name string) CertificateStatus {
+		for _, s := range acm.Status().ObjectStatus.(*Status).Domains {
+			if s.Name == name {
+				return s
+			}
+		}
+		return CertificateStatus{}
+	}
+
+	assert.Eventually(func() bool {
+		return domainStatus("c.megaease.com").RenewFailures > 0
+	}, 20*time.Second, 100*time.Millisecond)
+
+	// certificates are issued by the issuers of the domains.
+	assert.Equal([]string{"a.megaease.com"}, defaultCA.issuedDomains())
+	assert.Equal([]string{"b.megaease.com"}, eabCA.issuedDomains())
+
+	status := domainStatus("b.megaease.com")
+	assert.Equal("internal", status.Issuer)
+	assert.False(status.ExpireTime.IsZero())
+	assert.False(status.LastRenewTime.IsZero())
+	assert.Zero(status.RenewFailures)
+	assert.Empty(status.LastRenewError)
+
+	// the account of the bad issuer is rejected for its invalid binding.
+	status = domainStatus("c.megaease.com")
+	assert.Contains(status.LastRenewError, "invalid external account binding")
+	assert.True(status.ExpireTime.IsZero())
+
+	// OCSP responses are stapled.
+	assert.Eventually(func() bool {
+		cert, err := GetCertificate(helloInfo("a.megaease.com"), false)
+		return err == nil && cert != nil && len(cert.OCSPStaple) > 0
+	}, 5*time.Second, 100*time.Millisecond)
+	cert, _ := GetCertificate(helloInfo("a.megaease.com"), false)
+	resp, err := ocsp.ParseResponse(cert.OCSPStaple, defaultCA.caCert)
+	assert.NoError(err)
+	assert.Equal(ocsp.Good, resp.Status)
+	assert.False(domainStatus("a.megaease.com").OCSPNextUpdate.IsZero())
+
+	// certificates are exported to the directory.
+	assert.Eventually(func() bool {
+		_, err := os.Stat(filepath.Join(exportDir, "b.megaease.com", "cert.pem"))
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+	keyPEM, err := os.ReadFile(filepath.Join(exportDir, "b.megaease.com", "key.pem"))
+	assert.NoError(err)
+	assert.Contains(string(keyPEM), "PRIVATE KEY")
+	certPEM, err := os.ReadFile(filepath.Join(exportDir, "b.megaease.com", "cert.pem"))
+	assert.NoError(err)
+	assert.Equal(2, strings.Count(string(certPEM), "BEGIN CERTIFICATE"))
+
+	// and the custom data.
+	data, err := store.GetData("certificates", "a.megaease.com")
+	assert.NoError(err)
+	if assert.NotNil(data) {
+		assert.Contains(data["certificate"], "BEGIN CERTIFICATE")
+		assert.Contains(data["privateKey"], "PRIVATE KEY")
+		assert.NotEmpty(data["expireTime"])
+	}
+
+	// the account keys are stored, so the next generation uses the
+	// registered accounts instead of registering with the single use
+	// binding again.
+	spec, err = supervisor.NewSpec(yamlConfig + `
+  - name: "d.megaease.com"
+    issuer: internal
+`)
+	if !assert.NoError(err) {
+		return
+	}
+	newACM := &AutoCertManager{}
+	newACM.Inherit(spec, acm)
+	acm = newACM
+	assert.Eventually(func() bool {
+		return len(eabCA.issuedDomains()) == 2
+	}, 20*time.Second, 100*time.Millisecond)
+	assert.ElementsMatch([]string{"b.megaease.com", "d.megaease.com"}, eabCA.issuedDomains())
+	assert.Empty(domainStatus("d.megaease.com").LastRenewError)
+
+	// the account is registered again with the stored key if it's gone.
+	issuer := acm.spec.defaultIssuer()
+	key, err := acm.storage.getAccountKey(issuer)
+	if !assert.NoError(err) || !assert.NotNil(key) {
+		return
+	}
+	defaultCA.mutex.Lock()
+	defaultCA.accounts = map[string]bool{}
+	defaultCA.mutex.Unlock()
+	cl, err := acm.createAcmeClient(issuer)
+	assert.NoError(err)
+	assert.True(key.(*ecdsa.PrivateKey).Equal(cl.Key))
+	defaultCA.mutex.Lock()
+	assert.Len(defaultCA.accounts, 1)
+	defaultCA.mutex.Unlock()
+}
+
+func TestDomainDir(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("www.megaease.com", domainDir("www.megaease.com"))
+	assert.Equal("_wildcard.megaease.com", domainDir("*.megaease.com"))
+}
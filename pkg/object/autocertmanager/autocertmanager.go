@@ -20,9 +20,6 @@ package autocertmanager
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"net/http"
@@ -30,6 +27,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/megaease/easegress/pkg/cluster/customdata"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"golang.org/x/crypto/acme"
@@ -53,6 +51,13 @@ type (
 		spec      *Spec
 		storage   *storage
 		client    *acme.Client
+		// clients are the ACME clients of the named issuers.
+		clients  map[string]*acme.Client
+		metrics  *metrics
+		exporter *exporter
+
+		// certUpdated is notified when a certificate is updated.
+		certUpdated chan struct{}
 
 		stopCtx context.Context
 		cancel  context.CancelFunc
@@ -63,25 +68,38 @@ type (
 
 	// Spec describes AutoCertManager.
 	Spec struct {
-		DirectoryURL    string       `json:"directoryURL" jsonschema:"required,format=url"`
-		Email           string       `json:"email" jsonschema:"required,format=email"`
-		RenewBefore     string       `json:"renewBefore" jsonschema:"required,format=duration"`
-		EnableHTTP01    bool         `json:"enableHTTP01"`
-		EnableTLSALPN01 bool         `json:"enableTLSALPN01"`
-		EnableDNS01     bool         `json:"enableDNS01"`
-		Domains         []DomainSpec `json:"domains" jsonschema:"required"`
+		DirectoryURL           string       `json:"directoryURL" jsonschema:"required,format=url"`
+		Email                  string       `json:"email" jsonschema:"required,format=email"`
+		ExternalAccountBinding *EABSpec     `json:"externalAccountBinding" jsonschema:"omitempty"`
+		Issuers                []IssuerSpec `json:"issuers" jsonschema:"omitempty"`
+		RenewBefore            string       `json:"renewBefore" jsonschema:"required,format=duration"`
+		EnableHTTP01           bool         `json:"enableHTTP01"`
+		EnableTLSALPN01        bool         `json:"enableTLSALPN01"`
+		EnableDNS01            bool         `json:"enableDNS01"`
+		OCSPStapling           bool         `json:"ocspStapling"`
+		Export                 *ExportSpec  `json:"export" jsonschema:"omitempty"`
+		Domains                []DomainSpec `json:"domains" jsonschema:"required"`
 	}
 
 	// DomainSpec is the automated certificate management spec for a domain.
 	DomainSpec struct {
-		Name        string            `json:"name" jsonschema:"required"`
+		Name string `json:"name" jsonschema:"required"`
+		// Issuer is the name of the issuer of the certificate, the
+		// default issuer is used if it is empty.
+		Issuer      string            `json:"issuer" jsonschema:"omitempty"`
 		DNSProvider map[string]string `json:"dnsProvider" jsonschema:"omitempty"`
 	}
 
 	// CertificateStatus is the certificate status of a domain.
 	CertificateStatus struct {
-		Name       string    `json:"name"`
-		ExpireTime time.Time `json:"expireTime"`
+		Name           string    `json:"name"`
+		Issuer         string    `json:"issuer,omitempty"`
+		ExpireTime     time.Time `json:"expireTime"`
+		LastRenewTime  time.Time `json:"lastRenewTime"`
+		LastRenewError string    `json:"lastRenewError,omitempty"`
+		RenewFailures  int       `json:"renewFailures"`
+		OCSPNextUpdate time.Time `json:"ocspNextUpdate"`
+		OCSPError      string    `json:"ocspError,omitempty"`
 	}
 
 	// Status is the status of AutoCertManager.
@@ -100,9 +118,43 @@ func (spec *Spec) Validate() error {
 		return fmt.Errorf("at least one challenge type must be enabled")
 	}
 
+	if spec.ExternalAccountBinding != nil {
+		if err := spec.ExternalAccountBinding.Validate(); err != nil {
+			return err
+		}
+	}
+
+	issuers := map[string]bool{}
+	for i := range spec.Issuers {
+		issuer := &spec.Issuers[i]
+		if issuer.Name == "" {
+			return fmt.Errorf("issuer name is empty")
+		}
+		if issuers[issuer.Name] {
+			return fmt.Errorf("issuer %s is duplicated", issuer.Name)
+		}
+		issuers[issuer.Name] = true
+
+		if issuer.ExternalAccountBinding != nil {
+			if err := issuer.ExternalAccountBinding.Validate(); err != nil {
+				return fmt.Errorf("issuer %s: %v", issuer.Name, err)
+			}
+		}
+	}
+
+	if spec.Export != nil {
+		if err := spec.Export.Validate(); err != nil {
+			return err
+		}
+	}
+
 	for i := range spec.Domains {
 		d := &spec.Domains[i]
 
+		if d.Issuer != "" && !issuers[d.Issuer] {
+			return fmt.Errorf("issuer %s of domain %s not found", d.Issuer, d.Name)
+		}
+
 		// convert to puny code to support Chinese or other unicode domain names.
 		_, err := idna.Lookup.ToASCII(d.Name)
 		if err != nil && d.Name[0] == '*' {
@@ -205,6 +257,14 @@ func (acm *AutoCertManager) findDomain(name string, exactMatch bool) *Domain {
 func (acm *AutoCertManager) reload() {
 	acm.stopCtx, acm.cancel = context.WithCancel(context.Background())
 	acm.storage = newStorage(acm.super.Cluster())
+	acm.metrics = newMetrics(acm.superSpec)
+	acm.certUpdated = make(chan struct{}, 1)
+
+	if acm.spec.Export != nil {
+		cls := acm.super.Cluster()
+		store := customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+		acm.exporter = newExporter(acm.spec.Export, store)
+	}
 
 	acm.renewBefore, _ = time.ParseDuration(acm.spec.RenewBefore)
 
@@ -222,6 +282,7 @@ func (acm *AutoCertManager) reload() {
 		d := &acm.domains[i]
 		d.DomainSpec = spec
 		d.nameInPunyCode = name
+		d.state = &domainState{}
 
 		cert, err := acm.storage.getCert(d.nameInPunyCode)
 		if err != nil {
@@ -234,17 +295,14 @@ func (acm *AutoCertManager) reload() {
 	globalACM.Store(acm)
 	go acm.run()
 	go acm.watchCertificate()
+	go acm.maintain()
 }
 
 // Status returns the status of AutoCertManager.
 func (acm *AutoCertManager) Status() *supervisor.Status {
 	status := &Status{}
 	for i := range acm.domains {
-		d := &acm.domains[i]
-		status.Domains = append(status.Domains, CertificateStatus{
-			Name:       d.Name,
-			ExpireTime: d.certExpireTime(),
-		})
+		status.Domains = append(status.Domains, acm.domains[i].status())
 	}
 	return &supervisor.Status{ObjectStatus: status}
 }
@@ -273,37 +331,102 @@ func (acm *AutoCertManager) renew() bool {
 
 		d := &acm.domains[i]
 		if d.certExpireTime().After(deadline) {
+			acm.exportToCustomData(d)
 			continue
 		}
 
 		logger.Infof("begin renew certificate for domain %s", d.Name)
-		if err := d.renewCert(acm); err == nil {
+		err := d.renewCert(acm)
+		if err == nil {
 			logger.Infof("certificate for domain %s has been renewed", d.Name)
+			acm.metrics.Renewals.WithLabelValues(d.Name, "success").Inc()
+			acm.notifyCertUpdated()
+			acm.exportToCustomData(d)
 		} else {
 			logger.Errorf("failed to renew certificate for domain %s: %v", d.Name, err)
+			acm.metrics.Renewals.WithLabelValues(d.Name, "failure").Inc()
 			allSucc = false
 		}
+
+		failures := d.state.renewed(err, time.Now())
+		acm.metrics.RenewFailures.WithLabelValues(d.Name).Set(float64(failures))
 	}
 
 	return allSucc
 }
 
-func (acm *AutoCertManager) createAcmeClient() error {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		logger.Errorf("failed to generate new account: %v", err)
-		return err
+// exportToCustomData exports the certificate of the domain to the custom
+// data, it is only called by the leader.
+func (acm *AutoCertManager) exportToCustomData(d *Domain) {
+	cert := d.cert()
+	if acm.exporter == nil || cert == nil {
+		return
 	}
+	if err := acm.exporter.exportToCustomData(d.nameInPunyCode, cert); err != nil {
+		logger.Errorf("failed to export certificate of domain %s to custom data: %v", d.Name, err)
+	}
+}
 
-	cl := &acme.Client{Key: key, DirectoryURL: acm.spec.DirectoryURL}
-	acct := &acme.Account{Contact: []string{"mailto:" + acm.spec.Email}}
-	if _, err := cl.Register(acm.stopCtx, acct, acme.AcceptTOS); err != nil {
-		logger.Errorf("failed to register: %v", err)
-		return err
+func (acm *AutoCertManager) notifyCertUpdated() {
+	select {
+	case acm.certUpdated <- struct{}{}:
+	default:
 	}
+}
 
-	acm.client = cl
-	return nil
+// maintain exports the certificates to the directory, refreshes their OCSP
+// staples and updates the metrics, it runs on every member.
+func (acm *AutoCertManager) maintain() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for i := range acm.domains {
+			d := &acm.domains[i]
+			cert := d.cert()
+			if cert == nil {
+				continue
+			}
+
+			acm.metrics.CertExpireTime.WithLabelValues(d.Name).Set(float64(cert.Leaf.NotAfter.Unix()))
+			acm.exportToDir(d, cert)
+			if acm.spec.OCSPStapling {
+				d.refreshOCSP(acm, now)
+			}
+		}
+
+		select {
+		case <-acm.stopCtx.Done():
+			return
+		case <-acm.certUpdated:
+		case <-ticker.C:
+		}
+	}
+}
+
+// exportToDir exports the certificate of the domain to the directory if
+// it is changed since the last export.
+func (acm *AutoCertManager) exportToDir(d *Domain, cert *tls.Certificate) {
+	if acm.exporter == nil {
+		return
+	}
+
+	d.state.mutex.Lock()
+	exported := d.state.exported
+	d.state.mutex.Unlock()
+	if exported != nil && exported.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) == 0 {
+		return
+	}
+
+	if err := acm.exporter.exportToDir(d.nameInPunyCode, cert); err != nil {
+		logger.Errorf("failed to export certificate of domain %s to directory: %v", d.Name, err)
+		return
+	}
+
+	d.state.mutex.Lock()
+	d.state.exported = cert
+	d.state.mutex.Unlock()
 }
 
 func (acm *AutoCertManager) watchCertificate() {
@@ -311,6 +434,7 @@ func (acm *AutoCertManager) watchCertificate() {
 		d := acm.findDomain(name, false)
 		if d != nil {
 			d.updateCert(cert)
+			acm.notifyCertUpdated()
 		}
 	}
 
@@ -318,17 +442,8 @@ func (acm *AutoCertManager) watchCertificate() {
 }
 
 func (acm *AutoCertManager) run() {
-	for {
-		if err := acm.createAcmeClient(); err == nil {
-			break
-		}
-		select {
-		case <-acm.stopCtx.Done():
-			return
-		case <-time.After(30 * time.Second):
-		}
-	}
-
+	// ACME clients are created on demand, because the issuers of the
+	// domains could be different.
 	for {
 		waitDuration := time.Hour
 		if allSucc := acm.renew(); !allSucc {
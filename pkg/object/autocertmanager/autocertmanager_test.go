@@ -889,7 +889,7 @@ func TestDomain(t *testing.T) {
 		closeWG.Add(1)
 		cls.CloseServer(closeWG)
 		closeWG.Wait()
-		d := Domain{nameInPunyCode: "name"}
+		d := Domain{nameInPunyCode: "name", client: fakeAcm.client}
 		if err := d.runHTTP01(fakeAcm, chal); err == nil {
 			t.Errorf("should have failed")
 		}
@@ -908,7 +908,7 @@ func TestDomain(t *testing.T) {
 		closeWG.Add(1)
 		cls.CloseServer(closeWG)
 		closeWG.Wait()
-		d := Domain{nameInPunyCode: "name"}
+		d := Domain{nameInPunyCode: "name", client: fakeAcm.client}
 		if err := d.runTLSALPN01(fakeAcm, auth, chal); err == nil {
 			t.Errorf("should have failed")
 		}
@@ -153,6 +153,16 @@ var dnsProviderCreators = map[string]*dnsProviderCreator{
 		},
 	},
 
+	"rfc2136": {
+		requiredFields: []string{"nameserver"},
+		creatorFn:      newRFC2136Provider,
+	},
+
+	"webhook": {
+		requiredFields: []string{"url"},
+		creatorFn:      newWebhookProvider,
+	},
+
 	"vultr": {
 		requiredFields: []string{"apiToken"},
 		creatorFn: func(d *DomainSpec) (dnsProvider, error) {
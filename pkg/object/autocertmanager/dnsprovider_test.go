@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDNSServer is an authoritative name server of TXT records for testing,
+// which accepts RFC2136 dynamic updates signed by TSIG.
+type testDNSServer struct {
+	server *dns.Server
+	addr   string
+
+	mutex   sync.Mutex
+	records map[string][]string
+}
+
+func newTestDNSServer(t *testing.T, keyName, secret string) *testDNSServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testDNSServer{addr: pc.LocalAddr().String(), records: map[string][]string{}}
+	started := make(chan struct{})
+	s.server = &dns.Server{
+		PacketConn: pc,
+		TsigSecret: map[string]string{keyName: secret},
+		Handler:    dns.HandlerFunc(s.handle),
+		// the default one rejects dynamic updates.
+		MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go s.server.ActivateAndServe()
+	<-started
+	return s
+}
+
+func (s *testDNSServer) close() {
+	s.server.Shutdown()
+}
+
+func (s *testDNSServer) txt(name string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.records[name]...)
+}
+
+func (s *testDNSServer) handle(w dns.ResponseWriter, r *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(r)
+	if tsig := r.IsTsig(); tsig != nil {
+		reply.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	}
+
+	if r.Opcode == dns.OpcodeQuery {
+		q := r.Question[0]
+		for _, v := range s.txt(q.Name) {
+			reply.Answer = append(reply.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{v},
+			})
+		}
+		w.WriteMsg(reply)
+		return
+	}
+
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		reply.Rcode = dns.RcodeRefused
+		w.WriteMsg(reply)
+		return
+	}
+
+	s.mutex.Lock()
+	for _, rr := range r.Ns {
+		hdr := rr.Header()
+		switch hdr.Class {
+		case dns.ClassINET:
+			s.records[hdr.Name] = append(s.records[hdr.Name], rr.(*dns.TXT).Txt[0])
+		case dns.ClassANY:
+			delete(s.records, hdr.Name)
+		case dns.ClassNONE:
+			value := rr.(*dns.TXT).Txt[0]
+			var values []string
+			for _, v := range s.records[hdr.Name] {
+				if v != value {
+					values = append(values, v)
+				}
+			}
+			s.records[hdr.Name] = values
+		}
+	}
+	s.mutex.Unlock()
+
+	w.WriteMsg(reply)
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := base64.StdEncoding.EncodeToString([]byte("tsig-secret"))
+	server := newTestDNSServer(t, "easegress.", secret)
+	defer server.close()
+
+	spec := &DomainSpec{
+		Name: "www.megaease.com",
+		DNSProvider: map[string]string{
+			"name":        "rfc2136",
+			"zone":        "megaease.com",
+			"nameserver":  server.addr,
+			"tsigKeyName": "easegress",
+			"tsigSecret":  secret,
+		},
+	}
+	dp, err := newDNSProvider(spec)
+	assert.NoError(err)
+
+	ctx := context.Background()
+	fqdn := "_acme-challenge.www.megaease.com."
+	record := libdns.Record{Type: "TXT", Name: "_acme-challenge.www"}
+
+	record.Value = "value1"
+	_, err = dp.AppendRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+	record.Value = "value2"
+	_, err = dp.AppendRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+	assert.Equal([]string{"value1", "value2"}, server.txt(fqdn))
+
+	// records are checked by the name server of the provider by default.
+	resolvers := dnsResolvers(spec)
+	assert.True(txtRecordPropagated(ctx, resolvers, fqdn, "value2"))
+	assert.False(txtRecordPropagated(ctx, resolvers, fqdn, "value3"))
+
+	_, err = dp.DeleteRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+	assert.Equal([]string{"value1"}, server.txt(fqdn))
+
+	record.Value = ""
+	_, err = dp.DeleteRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+	assert.Empty(server.txt(fqdn))
+
+	// updates with a wrong key are rejected.
+	spec.DNSProvider["tsigSecret"] = base64.StdEncoding.EncodeToString([]byte("wrong"))
+	dp, err = newDNSProvider(spec)
+	assert.NoError(err)
+	record.Value = "value1"
+	_, err = dp.AppendRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.Error(err)
+	assert.Empty(server.txt(fqdn))
+
+	// invalid specs.
+	spec.DNSProvider["tsigAlgorithm"] = "hmac-unknown"
+	_, err = newDNSProvider(spec)
+	assert.Error(err)
+	delete(spec.DNSProvider, "tsigAlgorithm")
+	delete(spec.DNSProvider, "tsigSecret")
+	_, err = newDNSProvider(spec)
+	assert.Error(err)
+	delete(spec.DNSProvider, "nameserver")
+	_, err = newDNSProvider(spec)
+	assert.Error(err)
+}
+
+func TestDNSResolvers(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &DomainSpec{DNSProvider: map[string]string{"name": "webhook"}}
+	resolvers := dnsResolvers(spec)
+	assert.Equal([]*net.Resolver{net.DefaultResolver}, resolvers)
+
+	spec.DNSProvider["nsAddress"] = "10.0.0.1:53, 10.0.0.2:53"
+	assert.Len(dnsResolvers(spec), 2)
+}
+
+func TestWebhookProvider(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mutex    sync.Mutex
+		requests []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		req := &webhookRequest{}
+		codectool.UnmarshalJSON(body, req)
+
+		mutex.Lock()
+		requests = append(requests, r.URL.Path+" "+req.FQDN+" "+req.Value)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	spec := &DomainSpec{
+		Name: "*.megaease.com",
+		DNSProvider: map[string]string{
+			"name":          "webhook",
+			"zone":          "megaease.com",
+			"url":           server.URL + "/acme/",
+			"authorization": "Bearer token",
+		},
+	}
+	dp, err := newDNSProvider(spec)
+	assert.NoError(err)
+
+	ctx := context.Background()
+	record := libdns.Record{Type: "TXT", Name: "_acme-challenge"}
+
+	// records without value are not cleaned up.
+	_, err = dp.DeleteRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+
+	record.Value = "value"
+	_, err = dp.AppendRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+	_, err = dp.DeleteRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.NoError(err)
+
+	assert.Equal([]string{
+		"/acme/present _acme-challenge.megaease.com. value",
+		"/acme/cleanup _acme-challenge.megaease.com. value",
+	}, requests)
+
+	// failures of the webhook are reported.
+	spec.DNSProvider["authorization"] = "Bearer wrong"
+	dp, err = newDNSProvider(spec)
+	assert.NoError(err)
+	_, err = dp.AppendRecords(ctx, spec.Zone(), []libdns.Record{record})
+	assert.Error(err)
+
+	spec.DNSProvider["url"] = "ftp://localhost"
+	_, err = newDNSProvider(spec)
+	assert.Error(err)
+}
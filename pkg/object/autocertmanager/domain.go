@@ -26,7 +26,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"math/big"
 	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -42,6 +45,34 @@ type Domain struct {
 	certificate    atomic.Value
 	cleanups       []func() error
 	ctx            context.Context
+	client         *acme.Client
+	state          *domainState
+}
+
+// domainState is the state of renewals and OCSP stapling of a domain.
+type domainState struct {
+	mutex sync.Mutex
+
+	lastRenewTime  time.Time
+	lastRenewError string
+	renewFailures  int
+
+	// exported is the certificate last exported to the directory.
+	exported *tls.Certificate
+
+	ocspSerial      *big.Int
+	ocspLastAttempt time.Time
+	ocspThisUpdate  time.Time
+	ocspNextUpdate  time.Time
+	ocspError       string
+}
+
+// issuerName returns the name of the issuer of the domain.
+func (d *Domain) issuerName() string {
+	if d.DomainSpec == nil {
+		return ""
+	}
+	return d.Issuer
 }
 
 // isWildcard returns whether the domain is for a wildcard one
@@ -83,8 +114,58 @@ func (d *Domain) updateCert(cert *tls.Certificate) {
 	}
 }
 
+// setOCSPStaple sets the OCSP staple of the certificate, it does nothing
+// if the certificate has been replaced by another one.
+func (d *Domain) setOCSPStaple(leaf *x509.Certificate, staple []byte) {
+	for {
+		oldCert := d.cert()
+		if oldCert == nil || oldCert.Leaf.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			return
+		}
+		cert := *oldCert
+		cert.OCSPStaple = staple
+		if d.certificate.CompareAndSwap(oldCert, &cert) {
+			return
+		}
+	}
+}
+
+// renewed records the result of a renewal.
+func (ds *domainState) renewed(err error, now time.Time) int {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.lastRenewTime = now
+	if err == nil {
+		ds.lastRenewError = ""
+		ds.renewFailures = 0
+	} else {
+		ds.lastRenewError = err.Error()
+		ds.renewFailures++
+	}
+	return ds.renewFailures
+}
+
+// status returns the status of the domain.
+func (d *Domain) status() CertificateStatus {
+	ds := d.state
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	return CertificateStatus{
+		Name:           d.Name,
+		Issuer:         d.Issuer,
+		ExpireTime:     d.certExpireTime(),
+		LastRenewTime:  ds.lastRenewTime,
+		LastRenewError: ds.lastRenewError,
+		RenewFailures:  ds.renewFailures,
+		OCSPNextUpdate: ds.ocspNextUpdate,
+		OCSPError:      ds.ocspError,
+	}
+}
+
 func (d *Domain) runHTTP01(acm *AutoCertManager, chal *acme.Challenge) error {
-	client := acm.client
+	client := d.client
 
 	path := client.HTTP01ChallengePath(chal.Token)
 	body, err := client.HTTP01ChallengeResponse(chal.Token)
@@ -112,7 +193,7 @@ func (d *Domain) runHTTP01(acm *AutoCertManager, chal *acme.Challenge) error {
 }
 
 func (d *Domain) runTLSALPN01(acm *AutoCertManager, z *acme.Authorization, chal *acme.Challenge) error {
-	client := acm.client
+	client := d.client
 
 	cert, err := client.TLSALPN01ChallengeCert(chal.Token, z.Identifier.Value)
 	if err != nil {
@@ -146,10 +227,43 @@ func (d *Domain) waitDNSRecord(value string) error {
 		name += d.nameInPunyCode
 	}
 
-	r := net.DefaultResolver
-	if addr := d.DNSProvider["nsAddress"]; addr != "" {
-		network := d.DNSProvider["nsNetwork"]
-		r := net.Resolver{PreferGo: true}
+	resolvers := dnsResolvers(d.DomainSpec)
+	for {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		}
+
+		if txtRecordPropagated(d.ctx, resolvers, name, value) {
+			return nil
+		}
+	}
+}
+
+// dnsResolvers returns the resolvers to check the propagation of DNS
+// records, which are the name servers in 'nsAddress' (separated by
+// commas), or the name server of the RFC2136 DNS provider, or the
+// default resolver of the system.
+func dnsResolvers(spec *DomainSpec) []*net.Resolver {
+	var addrs []string
+	for _, addr := range strings.Split(spec.DNSProvider["nsAddress"], ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 && spec.DNSProvider["name"] == "rfc2136" {
+		addrs = append(addrs, spec.DNSProvider["nameserver"])
+	}
+	if len(addrs) == 0 {
+		return []*net.Resolver{net.DefaultResolver}
+	}
+
+	network := spec.DNSProvider["nsNetwork"]
+	resolvers := make([]*net.Resolver, 0, len(addrs))
+	for _, addr := range addrs {
+		addr := addr
+		r := &net.Resolver{PreferGo: true}
 		r.Dial = func(ctx context.Context, n, a string) (net.Conn, error) {
 			d := net.Dialer{Timeout: 10 * time.Second}
 			if network != "" {
@@ -157,27 +271,35 @@ func (d *Domain) waitDNSRecord(value string) error {
 			}
 			return d.DialContext(ctx, n, addr)
 		}
+		resolvers = append(resolvers, r)
 	}
+	return resolvers
+}
 
-	for {
-		select {
-		case <-time.After(5 * time.Second):
-		case <-d.ctx.Done():
-			return d.ctx.Err()
+// txtRecordPropagated returns whether the TXT record is visible from all
+// the resolvers.
+func txtRecordPropagated(ctx context.Context, resolvers []*net.Resolver, name, value string) bool {
+	for _, r := range resolvers {
+		values, err := r.LookupTXT(ctx, name)
+		if err != nil {
+			return false
 		}
-
-		if values, err := r.LookupTXT(d.ctx, name); err == nil {
-			for _, v := range values {
-				if v == value {
-					return nil
-				}
+		found := false
+		for _, v := range values {
+			if v == value {
+				found = true
+				break
 			}
 		}
+		if !found {
+			return false
+		}
 	}
+	return true
 }
 
 func (d *Domain) runDNS01(acm *AutoCertManager, chal *acme.Challenge) error {
-	client := acm.client
+	client := d.client
 
 	value, err := client.DNS01ChallengeRecord(chal.Token)
 	if err != nil {
@@ -210,6 +332,17 @@ func (d *Domain) runDNS01(acm *AutoCertManager, chal *acme.Challenge) error {
 		return err
 	}
 
+	d.cleanups = append(d.cleanups, func() error {
+		// we cannot use d.ctx here as it may be already cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_, err := dp.DeleteRecords(ctx, d.Zone(), []libdns.Record{record})
+		if err != nil {
+			logger.Errorf("DeleteRecords: %v", err)
+		}
+		return err
+	})
+
 	// we need to wait because it takes some time for a new DNS record to become effective
 	err = d.waitDNSRecord(value)
 	if err != nil {
@@ -240,7 +373,7 @@ func newCSR(id acme.AuthzID) ([]byte, crypto.Signer, error) {
 }
 
 func (d *Domain) fulfill(acm *AutoCertManager, u string) error {
-	client := acm.client
+	client := d.client
 
 	z, err := client.GetAuthorization(d.ctx, u)
 	if err != nil {
@@ -293,6 +426,12 @@ func (d *Domain) fulfill(acm *AutoCertManager, u string) error {
 }
 
 func (d *Domain) renewCert(acm *AutoCertManager) error {
+	client, err := acm.acmeClient(d.issuerName())
+	if err != nil {
+		return err
+	}
+	d.client = client
+
 	ctx, cancel := context.WithTimeout(acm.stopCtx, 10*time.Minute)
 	d.ctx = ctx
 
@@ -307,7 +446,6 @@ func (d *Domain) renewCert(acm *AutoCertManager) error {
 		cancel()
 	}()
 
-	client := acm.client
 	ids := acme.DomainIDs(d.nameInPunyCode)
 	order, err := client.AuthorizeOrder(ctx, ids)
 	if err != nil {
@@ -350,6 +488,5 @@ func (d *Domain) renewCert(acm *AutoCertManager) error {
 	}
 
 	d.updateCert(cert)
-	acm.storage.putCert(d.nameInPunyCode, cert)
-	return nil
+	return acm.storage.putCert(d.nameInPunyCode, cert)
 }
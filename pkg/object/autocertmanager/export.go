@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+)
+
+// ExportSpec is the spec to export certificates, so that they could be
+// used by applications other than Easegress.
+type ExportSpec struct {
+	// Dir is the directory to export certificates to, certificates are
+	// exported by every member of the cluster. The files of a domain
+	// are 'cert.pem' (the certificate chain) and 'key.pem' (the private
+	// key) in the sub directory of the domain name, the '*' of wildcard
+	// domains is replaced by '_wildcard'.
+	Dir string `json:"dir" jsonschema:"omitempty"`
+	// CustomDataKind is the kind of custom data to export certificates
+	// to, certificates are exported by the leader of the cluster.
+	CustomDataKind string `json:"customDataKind" jsonschema:"omitempty"`
+}
+
+// exporter exports certificates to the directory and the custom data.
+type exporter struct {
+	spec  *ExportSpec
+	store *customdata.Store
+}
+
+// Validate validates the spec of export.
+func (spec *ExportSpec) Validate() error {
+	if spec.Dir == "" && spec.CustomDataKind == "" {
+		return fmt.Errorf("at least one of dir and customDataKind must be specified")
+	}
+	return nil
+}
+
+func newExporter(spec *ExportSpec, store *customdata.Store) *exporter {
+	return &exporter{spec: spec, store: store}
+}
+
+// splitCertificate returns the PEM encoded certificate chain and private
+// key of the certificate.
+func splitCertificate(cert *tls.Certificate) (chain []byte, key []byte, err error) {
+	data, err := encodeCertificate(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no private key found")
+	}
+	return rest, pem.EncodeToMemory(block), nil
+}
+
+// domainDir returns the directory name of the domain.
+func domainDir(name string) string {
+	if strings.HasPrefix(name, "*") {
+		return "_wildcard" + name[1:]
+	}
+	return name
+}
+
+// writeFile writes the file atomically if its content is changed.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// exportToDir exports the certificate of the domain to the directory.
+func (e *exporter) exportToDir(name string, cert *tls.Certificate) error {
+	if e.spec.Dir == "" {
+		return nil
+	}
+
+	chain, key, err := splitCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(e.spec.Dir, domainDir(name))
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	// write the key first, so that the certificate is never newer than
+	// the key for applications watching the certificate file.
+	if err = writeFile(filepath.Join(dir, "key.pem"), key, 0o600); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "cert.pem"), chain, 0o644)
+}
+
+// exportToCustomData exports the certificate of the domain to the custom
+// data, the data is not updated if it is not changed.
+func (e *exporter) exportToCustomData(name string, cert *tls.Certificate) error {
+	if e.spec.CustomDataKind == "" {
+		return nil
+	}
+
+	kind, err := e.store.GetKind(e.spec.CustomDataKind)
+	if err != nil {
+		return err
+	}
+	if kind == nil {
+		return fmt.Errorf("custom data kind %s not found", e.spec.CustomDataKind)
+	}
+	idField := kind.IDField
+	if idField == "" {
+		idField = "name"
+	}
+
+	expireTime := cert.Leaf.NotAfter.UTC().Format(time.RFC3339)
+	old, err := e.store.GetData(e.spec.CustomDataKind, name)
+	if err != nil {
+		return err
+	}
+	if old != nil && old["expireTime"] == expireTime {
+		return nil
+	}
+
+	chain, key, err := splitCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	data := customdata.Data{
+		"name":        name,
+		"certificate": string(chain),
+		"privateKey":  string(key),
+		"expireTime":  expireTime,
+	}
+	data[idField] = name
+
+	return e.store.BatchUpdateData(e.spec.CustomDataKind, nil, []customdata.Data{data})
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"golang.org/x/crypto/acme"
+)
+
+type (
+	// IssuerSpec is the spec of an ACME issuer, that's, an ACME directory
+	// and the account registered to it.
+	IssuerSpec struct {
+		Name                   string   `json:"name" jsonschema:"required"`
+		DirectoryURL           string   `json:"directoryURL" jsonschema:"required,format=url"`
+		Email                  string   `json:"email,omitempty" jsonschema:"omitempty,format=email"`
+		ExternalAccountBinding *EABSpec `json:"externalAccountBinding" jsonschema:"omitempty"`
+	}
+
+	// EABSpec is the spec of the External Account Binding, which is
+	// required by some ACME servers, e.g. ZeroSSL, to bind the ACME
+	// account to an account of the CA.
+	EABSpec struct {
+		KeyID string `json:"keyID" jsonschema:"required"`
+		// HMACKey is the base64url encoded HMAC key.
+		HMACKey string `json:"hmacKey" jsonschema:"required"`
+	}
+)
+
+// Validate validates the spec of External Account Binding.
+func (spec *EABSpec) Validate() error {
+	if _, err := spec.decodeHMACKey(); err != nil {
+		return fmt.Errorf("invalid HMAC key of external account binding: %v", err)
+	}
+	return nil
+}
+
+// decodeHMACKey decodes the HMAC key, both the padded and the unpadded
+// base64url encodings are supported.
+func (spec *EABSpec) decodeHMACKey() ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(spec.HMACKey)
+	if err != nil {
+		key, err = base64.URLEncoding.DecodeString(spec.HMACKey)
+	}
+	if err == nil && len(key) == 0 {
+		err = fmt.Errorf("empty key")
+	}
+	return key, err
+}
+
+// defaultIssuer returns the issuer built from the top level fields of the
+// spec, its name is empty.
+func (spec *Spec) defaultIssuer() *IssuerSpec {
+	return &IssuerSpec{
+		DirectoryURL:           spec.DirectoryURL,
+		Email:                  spec.Email,
+		ExternalAccountBinding: spec.ExternalAccountBinding,
+	}
+}
+
+// findIssuer returns the issuer with the name, an empty name means the
+// default issuer.
+func (spec *Spec) findIssuer(name string) *IssuerSpec {
+	if name == "" {
+		return spec.defaultIssuer()
+	}
+	for i := range spec.Issuers {
+		if spec.Issuers[i].Name == name {
+			return &spec.Issuers[i]
+		}
+	}
+	return nil
+}
+
+// acmeClient returns the ACME client of the issuer, a new account is
+// registered if the client does not exist. It is only called from the
+// goroutine running the renew loop.
+func (acm *AutoCertManager) acmeClient(name string) (*acme.Client, error) {
+	if name == "" && acm.client != nil {
+		return acm.client, nil
+	}
+	if cl := acm.clients[name]; cl != nil {
+		return cl, nil
+	}
+
+	issuer := acm.spec.findIssuer(name)
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer %q not found", name)
+	}
+
+	cl, err := acm.createAcmeClient(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		acm.client = cl
+	} else {
+		if acm.clients == nil {
+			acm.clients = make(map[string]*acme.Client)
+		}
+		acm.clients[name] = cl
+	}
+	return cl, nil
+}
+
+// accountKey returns the stored account key of the issuer, or generates and
+// stores a new one if there is no stored key. The key is stored before the
+// account is registered, so that a registration with a single use External
+// Account Binding is never lost. It also returns whether the key is a
+// stored one.
+func (acm *AutoCertManager) accountKey(issuer *IssuerSpec) (crypto.Signer, bool, error) {
+	key, err := acm.storage.getAccountKey(issuer)
+	if err != nil {
+		return nil, false, fmt.Errorf("get account key failed: %v", err)
+	}
+	if key != nil {
+		return key, true, nil
+	}
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		logger.Errorf("failed to generate new account: %v", err)
+		return nil, false, err
+	}
+	if err = acm.storage.putAccountKey(issuer, newKey); err != nil {
+		return nil, false, fmt.Errorf("put account key failed: %v", err)
+	}
+	return newKey, false, nil
+}
+
+// createAcmeClient creates the ACME client of the issuer with the stored
+// account key, the account is registered only if the key is new or its
+// account does not exist in the ACME server.
+func (acm *AutoCertManager) createAcmeClient(issuer *IssuerSpec) (*acme.Client, error) {
+	key, stored, err := acm.accountKey(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := &acme.Client{Key: key, DirectoryURL: issuer.DirectoryURL}
+	if stored {
+		_, err := cl.GetReg(acm.stopCtx, "")
+		if err == nil {
+			return cl, nil
+		}
+		if err != acme.ErrNoAccount {
+			logger.Errorf("failed to get account from %s: %v", issuer.DirectoryURL, err)
+			return nil, err
+		}
+		logger.Infof("account of %s does not exist, register it", issuer.DirectoryURL)
+	}
+
+	email := issuer.Email
+	if email == "" {
+		email = acm.spec.Email
+	}
+
+	acct := &acme.Account{Contact: []string{"mailto:" + email}}
+	if eab := issuer.ExternalAccountBinding; eab != nil {
+		hmacKey, err := eab.decodeHMACKey()
+		if err != nil {
+			return nil, err
+		}
+		acct.ExternalAccountBinding = &acme.ExternalAccountBinding{
+			KID: eab.KeyID,
+			Key: hmacKey,
+		}
+	}
+
+	if _, err := cl.Register(acm.stopCtx, acct, acme.AcceptTOS); err != nil {
+		logger.Errorf("failed to register to %s: %v", issuer.DirectoryURL, err)
+		return nil, err
+	}
+
+	return cl, nil
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/prometheushelper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics is the Prometheus metrics of AutoCertManager.
+type metrics struct {
+	CertExpireTime *prometheus.GaugeVec
+	Renewals       *prometheus.CounterVec
+	RenewFailures  *prometheus.GaugeVec
+	OCSPRefreshes  *prometheus.CounterVec
+}
+
+func newMetrics(superSpec *supervisor.Spec) *metrics {
+	opt := superSpec.Super().Options()
+	commonLabels := prometheus.Labels{
+		"clusterName":         opt.ClusterName,
+		"clusterRole":         opt.ClusterRole,
+		"instanceName":        opt.Name,
+		"autoCertManagerName": superSpec.Name(),
+	}
+	labels := []string{"clusterName", "clusterRole", "instanceName", "autoCertManagerName", "domain"}
+
+	return &metrics{
+		CertExpireTime: prometheushelper.NewGauge(
+			"autocertmanager_certificate_expire_time_seconds",
+			"the expire time of the certificate of a domain in unix seconds",
+			labels).MustCurryWith(commonLabels),
+		Renewals: prometheushelper.NewCounter(
+			"autocertmanager_renewals_total",
+			"the total count of certificate renewals of a domain by result",
+			append(labels, "result")).MustCurryWith(commonLabels),
+		RenewFailures: prometheushelper.NewGauge(
+			"autocertmanager_renew_failures",
+			"the count of consecutive certificate renewal failures of a domain",
+			labels).MustCurryWith(commonLabels),
+		OCSPRefreshes: prometheushelper.NewCounter(
+			"autocertmanager_ocsp_refreshes_total",
+			"the total count of OCSP staple refreshes of a domain by result",
+			append(labels, "result")).MustCurryWith(commonLabels),
+	}
+}
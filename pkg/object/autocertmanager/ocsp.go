@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspRetryInterval is the interval to retry fetching OCSP responses
+	// after failures.
+	ocspRetryInterval = 10 * time.Minute
+	ocspTimeout       = 30 * time.Second
+	maxOCSPRespSize   = 1 << 20
+)
+
+// fetchOCSPStaple fetches the OCSP response of the certificate from the
+// OCSP server of its issuer, the certificate chain must contain the
+// issuer certificate.
+func fetchOCSPStaple(ctx context.Context, cert *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	leaf := cert.Leaf
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("no OCSP server in the certificate")
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, nil, fmt.Errorf("no issuer certificate in the certificate chain")
+	}
+
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse issuer certificate failed: %v", err)
+	}
+
+	body, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ocspTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP server returns status code %d", resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPRespSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch parsed.Status {
+	case ocsp.Good:
+		return raw, parsed, nil
+	case ocsp.Revoked:
+		return nil, nil, fmt.Errorf("certificate is revoked at %v", parsed.RevokedAt)
+	default:
+		return nil, nil, fmt.Errorf("certificate status is unknown")
+	}
+}
+
+// needOCSPRefresh returns whether the OCSP staple of the certificate needs
+// to be refreshed, that's, the certificate is changed, or half of the
+// validity period of the OCSP response has elapsed.
+func (ds *domainState) needOCSPRefresh(leaf *x509.Certificate, now time.Time) bool {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	if ds.ocspSerial == nil || ds.ocspSerial.Cmp(leaf.SerialNumber) != 0 {
+		return true
+	}
+	if now.Sub(ds.ocspLastAttempt) < ocspRetryInterval {
+		return false
+	}
+	if ds.ocspError != "" || ds.ocspNextUpdate.IsZero() {
+		return true
+	}
+	return now.After(ds.ocspThisUpdate.Add(ds.ocspNextUpdate.Sub(ds.ocspThisUpdate) / 2))
+}
+
+func (ds *domainState) ocspRefreshed(leaf *x509.Certificate, resp *ocsp.Response, err error, now time.Time) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.ocspSerial = leaf.SerialNumber
+	ds.ocspLastAttempt = now
+	if err != nil {
+		ds.ocspError = err.Error()
+		return
+	}
+	ds.ocspError = ""
+	ds.ocspThisUpdate = resp.ThisUpdate
+	ds.ocspNextUpdate = resp.NextUpdate
+}
+
+// refreshOCSP refreshes the OCSP staple of the certificate of the domain
+// if needed.
+func (d *Domain) refreshOCSP(acm *AutoCertManager, now time.Time) {
+	cert := d.cert()
+	if cert == nil || !d.state.needOCSPRefresh(cert.Leaf, now) {
+		return
+	}
+
+	raw, resp, err := fetchOCSPStaple(acm.stopCtx, cert)
+	d.state.ocspRefreshed(cert.Leaf, resp, err, now)
+	if err != nil {
+		acm.metrics.OCSPRefreshes.WithLabelValues(d.Name, "failure").Inc()
+		return
+	}
+	acm.metrics.OCSPRefreshes.WithLabelValues(d.Name, "success").Inc()
+
+	d.setOCSPStaple(cert.Leaf, raw)
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+const (
+	rfc2136DefaultTTL = 60 * time.Second
+	rfc2136Timeout    = 30 * time.Second
+	rfc2136TSIGFudge  = 300
+)
+
+// rfc2136Provider is a DNS provider which updates records with RFC2136
+// dynamic updates, optionally signed by TSIG (RFC8945). It works with
+// name servers like BIND and PowerDNS.
+type rfc2136Provider struct {
+	// nameserver is the address of the primary name server, must include
+	// the port number.
+	nameserver string
+	// network is the network protocol, 'udp' or 'tcp'.
+	network string
+
+	tsigKeyName   string
+	tsigAlgorithm string
+	tsigSecret    string
+}
+
+var rfc2136Algorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+func newRFC2136Provider(d *DomainSpec) (dnsProvider, error) {
+	p := &rfc2136Provider{
+		nameserver: d.DNSProvider["nameserver"],
+		network:    d.DNSProvider["network"],
+	}
+
+	if p.network == "" {
+		p.network = "udp"
+	} else if p.network != "udp" && p.network != "tcp" {
+		return nil, fmt.Errorf("unsupported network %q of RFC2136 DNS provider", p.network)
+	}
+
+	keyName, secret := d.DNSProvider["tsigKeyName"], d.DNSProvider["tsigSecret"]
+	if (keyName == "") != (secret == "") {
+		return nil, fmt.Errorf("tsigKeyName and tsigSecret of RFC2136 DNS provider must be specified together")
+	}
+	if keyName == "" {
+		return p, nil
+	}
+
+	algorithm := d.DNSProvider["tsigAlgorithm"]
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+	algorithm = rfc2136Algorithms[strings.TrimSuffix(strings.ToLower(algorithm), ".")]
+	if algorithm == "" {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %q of RFC2136 DNS provider", d.DNSProvider["tsigAlgorithm"])
+	}
+
+	p.tsigKeyName = dns.CanonicalName(keyName)
+	p.tsigAlgorithm = algorithm
+	p.tsigSecret = secret
+	return p, nil
+}
+
+func (p *rfc2136Provider) tsigSecrets() map[string]string {
+	if p.tsigKeyName == "" {
+		return nil
+	}
+	return map[string]string{p.tsigKeyName: p.tsigSecret}
+}
+
+func (p *rfc2136Provider) sign(m *dns.Msg) {
+	if p.tsigKeyName != "" {
+		m.SetTsig(p.tsigKeyName, p.tsigAlgorithm, rfc2136TSIGFudge, time.Now().Unix())
+	}
+}
+
+// toRR converts a libdns record to a resource record, an empty value
+// results in a resource record without data, which is used to delete an
+// RRset.
+func toRR(zone string, r libdns.Record) (dns.RR, error) {
+	name := dns.Fqdn(libdns.AbsoluteName(r.Name, zone))
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = rfc2136DefaultTTL
+	}
+
+	rrType, ok := dns.StringToType[strings.ToUpper(r.Type)]
+	if !ok {
+		return nil, fmt.Errorf("unknown record type %q", r.Type)
+	}
+
+	hdr := dns.RR_Header{Name: name, Rrtype: rrType, Class: dns.ClassINET, Ttl: uint32(ttl.Seconds())}
+	if r.Value == "" {
+		return &dns.ANY{Hdr: hdr}, nil
+	}
+	if rrType == dns.TypeTXT {
+		return &dns.TXT{Hdr: hdr, Txt: []string{r.Value}}, nil
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, hdr.Ttl, r.Type, r.Value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid record %s %s: %v", r.Type, r.Value, err)
+	}
+	return rr, nil
+}
+
+// fromRR converts a resource record to a libdns record.
+func fromRR(zone string, rr dns.RR) libdns.Record {
+	hdr := rr.Header()
+	r := libdns.Record{
+		Type: dns.TypeToString[hdr.Rrtype],
+		Name: libdns.RelativeName(strings.TrimSuffix(hdr.Name, "."), strings.TrimSuffix(zone, ".")),
+		TTL:  time.Duration(hdr.Ttl) * time.Second,
+	}
+
+	switch v := rr.(type) {
+	case *dns.TXT:
+		r.Value = strings.Join(v.Txt, "")
+	default:
+		// the data of a record is the string form without the header.
+		r.Value = strings.TrimPrefix(rr.String(), hdr.String())
+	}
+	return r
+}
+
+// update sends a dynamic update message to the name server.
+func (p *rfc2136Provider) update(ctx context.Context, m *dns.Msg) error {
+	p.sign(m)
+
+	ctx, cancel := context.WithTimeout(ctx, rfc2136Timeout)
+	defer cancel()
+
+	c := &dns.Client{Net: p.network, TsigSecret: p.tsigSecrets()}
+	reply, _, err := c.ExchangeContext(ctx, m, p.nameserver)
+	if err != nil {
+		return fmt.Errorf("send DNS update to %s failed: %v", p.nameserver, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("DNS update is rejected by %s: %s", p.nameserver, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
+
+func (p *rfc2136Provider) toRRs(zone string, recs []libdns.Record) ([]dns.RR, error) {
+	rrs := make([]dns.RR, 0, len(recs))
+	for _, r := range recs {
+		rr, err := toRR(zone, r)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// AppendRecords implements libdns.RecordAppender.
+func (p *rfc2136Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := p.toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	m.Insert(rrs)
+	if err = p.update(ctx, m); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// SetRecords implements libdns.RecordSetter, the RRsets of the records
+// are replaced by the records.
+func (p *rfc2136Provider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rrs, err := p.toRRs(zone, recs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+	m.RemoveRRset(rrs)
+	m.Insert(rrs)
+	if err = p.update(ctx, m); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// DeleteRecords implements libdns.RecordDeleter, the whole RRset is
+// deleted if the value of a record is empty.
+func (p *rfc2136Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+
+	for _, r := range recs {
+		rr, err := toRR(zone, r)
+		if err != nil {
+			return nil, err
+		}
+		if r.Value == "" {
+			m.RemoveRRset([]dns.RR{rr})
+		} else {
+			m.Remove([]dns.RR{rr})
+		}
+	}
+
+	if err := p.update(ctx, m); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// GetRecords implements libdns.RecordGetter, it transfers the zone from
+// the name server, so the name server must allow zone transfers (AXFR).
+func (p *rfc2136Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(dns.Fqdn(zone))
+	p.sign(m)
+
+	t := &dns.Transfer{TsigSecret: p.tsigSecrets()}
+	ch, err := t.In(m, p.nameserver)
+	if err != nil {
+		return nil, fmt.Errorf("transfer zone %s from %s failed: %v", zone, p.nameserver, err)
+	}
+
+	var recs []libdns.Record
+	for env := range ch {
+		if env.Error != nil {
+			return nil, fmt.Errorf("transfer zone %s from %s failed: %v", zone, p.nameserver, env.Error)
+		}
+		for _, rr := range env.RR {
+			if rrType := rr.Header().Rrtype; rrType == dns.TypeSOA || rrType == dns.TypeRRSIG {
+				continue
+			}
+			recs = append(recs, fromRR(zone, rr))
+		}
+	}
+	return recs, nil
+}
@@ -27,6 +27,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -40,6 +41,7 @@ const (
 	autoCertManagerCert        = "autocert/cert/%s"
 	autoCertManagerHTTPToken   = "autocert/http/%s/%s"
 	autoCertManagerTLSALPNCert = "autocert/tlsalpn/%s"
+	autoCertManagerAccount     = "autocert/account/%s/%s"
 )
 
 type storage struct {
@@ -58,6 +60,13 @@ func domainTLSALPNCert(name string) string {
 	return fmt.Sprintf(autoCertManagerTLSALPNCert, name)
 }
 
+// issuerAccount returns the key of the account of the issuer, accounts
+// are different for different directories even if the issuer name is the
+// same.
+func issuerAccount(issuer *IssuerSpec) string {
+	return fmt.Sprintf(autoCertManagerAccount, url.PathEscape(issuer.DirectoryURL), url.PathEscape(issuer.Name))
+}
+
 func encodeCertificate(cert *tls.Certificate) ([]byte, error) {
 	// contains PEM-encoded data
 	var buf bytes.Buffer
@@ -289,6 +298,32 @@ func (s *storage) deleteTLSALPNCert(domain string) error {
 	return s.cls.Delete(key)
 }
 
+// getAccountKey returns the private key of the account of the issuer, it
+// returns nil if the key does not exist.
+func (s *storage) getAccountKey(issuer *IssuerSpec) (crypto.Signer, error) {
+	kv, err := s.cls.GetRaw(issuerAccount(issuer))
+	if err != nil {
+		return nil, err
+	}
+	if kv == nil {
+		return nil, nil
+	}
+	block, _ := pem.Decode(kv.Value)
+	if block == nil || !strings.Contains(block.Type, "PRIVATE") {
+		return nil, fmt.Errorf("no private key found")
+	}
+	return parsePrivateKey(block.Bytes)
+}
+
+func (s *storage) putAccountKey(issuer *IssuerSpec, key *ecdsa.PrivateKey) error {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	value := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+	return s.cls.Put(issuerAccount(issuer), string(value))
+}
+
 func (s *storage) watchCertificate(ctx context.Context, onChange func(domain string, cert *tls.Certificate)) {
 	var (
 		syncer cluster.Syncer
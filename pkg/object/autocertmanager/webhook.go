@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/miekg/dns"
+)
+
+const (
+	webhookTimeout     = 30 * time.Second
+	maxWebhookRespSize = 4096
+)
+
+// webhookProvider is a DNS provider which calls an HTTP endpoint to
+// present and clean up TXT records, it is compatible with the 'httpreq'
+// provider of lego: the request body of 'POST <url>/present' and
+// 'POST <url>/cleanup' is like '{"fqdn": "_acme-challenge.example.com.",
+// "value": "..."}'.
+type webhookProvider struct {
+	url           string
+	username      string
+	password      string
+	authorization string
+	client        *http.Client
+}
+
+// webhookRequest is the request body of the webhook.
+type webhookRequest struct {
+	FQDN  string `json:"fqdn"`
+	Value string `json:"value"`
+}
+
+func newWebhookProvider(d *DomainSpec) (dnsProvider, error) {
+	u, err := url.Parse(d.DNSProvider["url"])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q of webhook DNS provider", d.DNSProvider["url"])
+	}
+
+	return &webhookProvider{
+		url:           strings.TrimSuffix(u.String(), "/"),
+		username:      d.DNSProvider["username"],
+		password:      d.DNSProvider["password"],
+		authorization: d.DNSProvider["authorization"],
+		client:        &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+func (p *webhookProvider) call(ctx context.Context, action string, zone string, r libdns.Record) error {
+	if !strings.EqualFold(r.Type, "TXT") {
+		return fmt.Errorf("webhook DNS provider supports TXT records only, but got %s", r.Type)
+	}
+
+	body, err := codectool.MarshalJSON(&webhookRequest{
+		FQDN:  dns.Fqdn(libdns.AbsoluteName(r.Name, zone)),
+		Value: r.Value,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.authorization != "" {
+		req.Header.Set("Authorization", p.authorization)
+	} else if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookRespSize))
+		return fmt.Errorf("webhook %s returns status code %d: %s", action, resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// AppendRecords implements libdns.RecordAppender.
+func (p *webhookProvider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, r := range recs {
+		if err := p.call(ctx, "present", zone, r); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// SetRecords implements libdns.RecordSetter, it is the same as
+// AppendRecords as the webhook has no way to replace records.
+func (p *webhookProvider) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	return p.AppendRecords(ctx, zone, recs)
+}
+
+// DeleteRecords implements libdns.RecordDeleter, records without value
+// are ignored because the webhook cleans up records by value.
+func (p *webhookProvider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	var deleted []libdns.Record
+	for _, r := range recs {
+		if r.Value == "" {
+			continue
+		}
+		if err := p.call(ctx, "cleanup", zone, r); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, r)
+	}
+	return deleted, nil
+}
+
+// GetRecords implements libdns.RecordGetter, it is not supported.
+func (p *webhookProvider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	return nil, fmt.Errorf("webhook DNS provider does not support getting records")
+}
@@ -0,0 +1,495 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dnsserviceregistry provides the DNSServiceRegistry.
+package dnsserviceregistry
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/v"
+)
+
+const (
+	// Category is the category of DNSServiceRegistry.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of DNSServiceRegistry.
+	Kind = "DNSServiceRegistry"
+
+	// RecordTypeA resolves IPv4 addresses of the domain.
+	RecordTypeA = "A"
+	// RecordTypeAAAA resolves IPv6 addresses of the domain.
+	RecordTypeAAAA = "AAAA"
+	// RecordTypeSRV resolves ports, weights and targets of the domain, and
+	// then the addresses of the targets.
+	RecordTypeSRV = "SRV"
+
+	// maxWeight is the maximum weight of servers in proxy pools.
+	maxWeight = 100
+)
+
+func init() {
+	supervisor.Register(&DNSServiceRegistry{})
+}
+
+type (
+	// DNSServiceRegistry is Object DNSServiceRegistry.
+	DNSServiceRegistry struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		serviceRegistry *serviceregistry.ServiceRegistry
+		firstDone       bool
+		instances       map[string]*serviceregistry.ServiceInstanceSpec
+		notify          chan *serviceregistry.RegistryEvent
+
+		resolver *resolver
+		minTTL   time.Duration
+		maxTTL   time.Duration
+
+		// services holds the latest resolved instances of every service,
+		// which are kept until the next successful resolving.
+		servicesMutex sync.RWMutex
+		services      map[string]map[string]*serviceregistry.ServiceInstanceSpec
+
+		statusMutex  sync.Mutex
+		instancesNum map[string]int
+		health       string
+
+		done chan struct{}
+	}
+
+	// Spec describes the DNSServiceRegistry.
+	Spec struct {
+		Nameservers []string       `json:"nameservers" jsonschema:"omitempty"`
+		Network     string         `json:"network" jsonschema:"omitempty,enum=,enum=udp,enum=tcp"`
+		Timeout     string         `json:"timeout" jsonschema:"omitempty,format=duration"`
+		MinTTL      string         `json:"minTTL" jsonschema:"omitempty,format=duration"`
+		MaxTTL      string         `json:"maxTTL" jsonschema:"omitempty,format=duration"`
+		Services    []*ServiceSpec `json:"services" jsonschema:"required"`
+	}
+
+	// ServiceSpec describes a service published in DNS.
+	ServiceSpec struct {
+		Name   string   `json:"name" jsonschema:"required"`
+		Domain string   `json:"domain" jsonschema:"required"`
+		Type   string   `json:"type" jsonschema:"omitempty,enum=,enum=A,enum=AAAA,enum=SRV"`
+		Port   uint16   `json:"port" jsonschema:"omitempty"`
+		IPv6   bool     `json:"ipv6" jsonschema:"omitempty"`
+		Scheme string   `json:"scheme" jsonschema:"omitempty,enum=,enum=http,enum=https"`
+		Tags   []string `json:"tags" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of DNSServiceRegistry.
+	Status struct {
+		Health              string         `json:"health"`
+		ServiceInstancesNum map[string]int `json:"instancesNum"`
+	}
+)
+
+var _ v.Validator = Spec{}
+
+// Validate validates Spec itself.
+func (spec Spec) Validate() error {
+	for _, ns := range spec.Nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			return fmt.Errorf("invalid name server %s: %v", ns, err)
+		}
+	}
+
+	names := map[string]struct{}{}
+	for _, s := range spec.Services {
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("duplicated service %s", s.Name)
+		}
+		names[s.Name] = struct{}{}
+
+		if _, ok := dns.IsDomainName(s.Domain); !ok {
+			return fmt.Errorf("service %s: invalid domain %s", s.Name, s.Domain)
+		}
+		if s.Type != RecordTypeSRV && s.Port == 0 {
+			return fmt.Errorf("service %s: port is required for %s records", s.Name, s.recordType())
+		}
+	}
+
+	return nil
+}
+
+func (s *ServiceSpec) recordType() string {
+	if s.Type == "" {
+		return RecordTypeA
+	}
+	return s.Type
+}
+
+// Category returns the category of DNSServiceRegistry.
+func (d *DNSServiceRegistry) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of DNSServiceRegistry.
+func (d *DNSServiceRegistry) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DNSServiceRegistry.
+func (d *DNSServiceRegistry) DefaultSpec() interface{} {
+	return &Spec{
+		Network: "udp",
+		Timeout: "5s",
+		MinTTL:  "5s",
+		MaxTTL:  "5m",
+	}
+}
+
+// Init initializes DNSServiceRegistry.
+func (d *DNSServiceRegistry) Init(superSpec *supervisor.Spec) {
+	d.superSpec, d.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	d.reload()
+}
+
+// Inherit inherits previous generation of DNSServiceRegistry.
+func (d *DNSServiceRegistry) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	d.Init(superSpec)
+}
+
+func parseDuration(s string, dft time.Duration) time.Duration {
+	if s == "" {
+		return dft
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return dft
+	}
+	return d
+}
+
+func (d *DNSServiceRegistry) reload() {
+	d.serviceRegistry = d.superSpec.Super().MustGetSystemController(serviceregistry.Kind).
+		Instance().(*serviceregistry.ServiceRegistry)
+	d.notify = make(chan *serviceregistry.RegistryEvent, 10)
+	d.firstDone = false
+
+	d.minTTL = parseDuration(d.spec.MinTTL, 5*time.Second)
+	d.maxTTL = parseDuration(d.spec.MaxTTL, 5*time.Minute)
+	if d.maxTTL < d.minTTL {
+		d.maxTTL = d.minTTL
+	}
+
+	d.services = map[string]map[string]*serviceregistry.ServiceInstanceSpec{}
+	d.instancesNum = map[string]int{}
+	d.health = "ready"
+	d.done = make(chan struct{})
+
+	timeout := parseDuration(d.spec.Timeout, 5*time.Second)
+	r, err := newResolver(d.spec.Nameservers, d.spec.Network, timeout)
+	if err != nil {
+		logger.Errorf("%s create resolver failed: %v", d.Name(), err)
+		d.health = err.Error()
+	}
+	d.resolver = r
+
+	d.serviceRegistry.RegisterRegistry(d)
+
+	if r != nil {
+		go d.run()
+	}
+}
+
+// clampTTL clamps the TTL of records to [minTTL, maxTTL].
+func (d *DNSServiceRegistry) clampTTL(ttl uint32) time.Duration {
+	t := time.Duration(ttl) * time.Second
+	if t < d.minTTL {
+		return d.minTTL
+	}
+	if t > d.maxTTL {
+		return d.maxTTL
+	}
+	return t
+}
+
+func (d *DNSServiceRegistry) run() {
+	// every service is refreshed on the TTL of its own records.
+	nextRefresh := make([]time.Time, len(d.spec.Services))
+	failed := make(map[string]struct{})
+
+	for {
+		now := time.Now()
+		changed := false
+		for i, s := range d.spec.Services {
+			if nextRefresh[i].After(now) {
+				continue
+			}
+
+			instances, ttl, err := d.resolve(s)
+			if err != nil {
+				logger.Errorf("%s resolve service %s failed: %v", d.Name(), s.Name, err)
+				// keep the previous instances and retry later.
+				nextRefresh[i] = now.Add(d.minTTL)
+				failed[s.Name] = struct{}{}
+				continue
+			}
+
+			delete(failed, s.Name)
+
+			nextRefresh[i] = now.Add(d.clampTTL(ttl))
+			d.servicesMutex.Lock()
+			d.services[s.Name] = instances
+			d.servicesMutex.Unlock()
+			changed = true
+		}
+
+		if len(failed) > 0 {
+			d.setHealth(fmt.Sprintf("failed to resolve %d services", len(failed)))
+		} else {
+			d.setHealth("ready")
+		}
+
+		if changed || !d.firstDone {
+			d.update()
+		}
+
+		wait := d.maxTTL
+		for _, t := range nextRefresh {
+			if w := time.Until(t); w < wait {
+				wait = w
+			}
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (d *DNSServiceRegistry) setHealth(health string) {
+	d.statusMutex.Lock()
+	d.health = health
+	d.statusMutex.Unlock()
+}
+
+// resolve resolves the instances of the service, and returns them with
+// the TTL of the records.
+func (d *DNSServiceRegistry) resolve(s *ServiceSpec) (map[string]*serviceregistry.ServiceInstanceSpec, uint32, error) {
+	var addrs []*address
+	var ttl uint32
+
+	switch s.recordType() {
+	case RecordTypeSRV:
+		var err error
+		addrs, ttl, err = d.resolver.lookupSRV(s.Domain, s.IPv6)
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		qtype := dns.TypeA
+		if s.recordType() == RecordTypeAAAA {
+			qtype = dns.TypeAAAA
+		}
+		ips, t, err := d.resolver.lookupIP(s.Domain, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		ttl = t
+		for _, ip := range ips {
+			addrs = append(addrs, &address{ip: ip, port: s.Port})
+		}
+	}
+
+	return d.addressesToServiceInstances(s, addrs), ttl, nil
+}
+
+// addressesToServiceInstances converts addresses to service instances,
+// SRV weights are scaled to the range of weights of proxy pools.
+func (d *DNSServiceRegistry) addressesToServiceInstances(s *ServiceSpec, addrs []*address) map[string]*serviceregistry.ServiceInstanceSpec {
+	totalWeight, max := 0, 0
+	for _, addr := range addrs {
+		totalWeight += addr.weight
+		if addr.weight > max {
+			max = addr.weight
+		}
+	}
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for _, addr := range addrs {
+		weight := 0
+		if totalWeight > 0 {
+			// records with weight 0 should have a very small chance to be
+			// selected, but pools require every server to have a weight.
+			weight = addr.weight * maxWeight / max
+			if weight == 0 {
+				weight = 1
+			}
+		}
+
+		var tags []string
+		tags = append(tags, s.Tags...)
+
+		instance := &serviceregistry.ServiceInstanceSpec{
+			RegistryName: d.Name(),
+			ServiceName:  s.Name,
+			InstanceID:   net.JoinHostPort(addr.ip, strconv.Itoa(int(addr.port))),
+			Address:      addr.ip,
+			Port:         addr.port,
+			Scheme:       s.Scheme,
+			Tags:         tags,
+			Weight:       weight,
+		}
+		if err := instance.Validate(); err != nil {
+			logger.Warnf("%+v is invalid: %v", instance, err)
+			continue
+		}
+		instances[instance.Key()] = instance
+	}
+
+	return instances
+}
+
+func (d *DNSServiceRegistry) update() {
+	instances, err := d.ListAllServiceInstances()
+	if err != nil {
+		logger.Errorf("list all service instances failed: %v", err)
+		return
+	}
+
+	instancesNum := make(map[string]int)
+	for _, instance := range instances {
+		instancesNum[instance.ServiceName]++
+	}
+
+	var event *serviceregistry.RegistryEvent
+	if !d.firstDone {
+		d.firstDone = true
+		event = &serviceregistry.RegistryEvent{
+			SourceRegistryName: d.Name(),
+			UseReplace:         true,
+			Replace:            instances,
+		}
+	} else {
+		event = serviceregistry.NewRegistryEventFromDiff(d.Name(), d.instances, instances)
+	}
+
+	if event.Empty() {
+		return
+	}
+
+	d.notify <- event
+	d.instances = instances
+
+	d.statusMutex.Lock()
+	d.instancesNum = instancesNum
+	d.statusMutex.Unlock()
+}
+
+// Status returns status of DNSServiceRegistry.
+func (d *DNSServiceRegistry) Status() *supervisor.Status {
+	s := &Status{}
+
+	d.statusMutex.Lock()
+	s.Health = d.health
+	s.ServiceInstancesNum = d.instancesNum
+	d.statusMutex.Unlock()
+
+	return &supervisor.Status{
+		ObjectStatus: s,
+	}
+}
+
+// Close closes DNSServiceRegistry.
+func (d *DNSServiceRegistry) Close() {
+	d.serviceRegistry.DeregisterRegistry(d.Name())
+
+	close(d.done)
+}
+
+// Name returns name.
+func (d *DNSServiceRegistry) Name() string {
+	return d.superSpec.Name()
+}
+
+// Notify returns notify channel.
+func (d *DNSServiceRegistry) Notify() <-chan *serviceregistry.RegistryEvent {
+	return d.notify
+}
+
+// ApplyServiceInstances applies service instances to the registry.
+func (d *DNSServiceRegistry) ApplyServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	return fmt.Errorf("%s is read-only, service instances are managed by DNS", d.Name())
+}
+
+// DeleteServiceInstances applies service instances to the registry.
+func (d *DNSServiceRegistry) DeleteServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	return fmt.Errorf("%s is read-only, service instances are managed by DNS", d.Name())
+}
+
+// GetServiceInstance get service instance from the registry.
+func (d *DNSServiceRegistry) GetServiceInstance(serviceName, instanceID string) (*serviceregistry.ServiceInstanceSpec, error) {
+	instances, err := d.ListServiceInstances(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if instance.InstanceID == instanceID {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s/%s not found", serviceName, instanceID)
+}
+
+// ListServiceInstances list service instances of one service from the registry.
+func (d *DNSServiceRegistry) ListServiceInstances(serviceName string) (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	d.servicesMutex.RLock()
+	defer d.servicesMutex.RUnlock()
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for key, instance := range d.services[serviceName] {
+		instances[key] = instance
+	}
+
+	return instances, nil
+}
+
+// ListAllServiceInstances list all service instances from the registry.
+func (d *DNSServiceRegistry) ListAllServiceInstances() (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	d.servicesMutex.RLock()
+	defer d.servicesMutex.RUnlock()
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for _, service := range d.services {
+		for key, instance := range service {
+			instances[key] = instance
+		}
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserviceregistry
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+var testZone = []string{
+	"api.example.com. 30 IN A 10.0.0.1",
+	"api.example.com. 60 IN A 10.0.0.2",
+	"api.example.com. 30 IN AAAA ::1",
+	"_http._tcp.example.com. 20 IN SRV 10 60 8080 node1.example.com.",
+	"_http._tcp.example.com. 20 IN SRV 10 30 8081 node2.example.com.",
+	"_http._tcp.example.com. 20 IN SRV 20 10 8082 backup.example.com.",
+	"node1.example.com. 10 IN A 10.0.1.1",
+	"node2.example.com. 40 IN A 10.0.1.2",
+	"backup.example.com. 40 IN A 10.0.1.3",
+}
+
+func startTestServer(t *testing.T) string {
+	var records []dns.RR
+	for _, s := range testZone {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rr)
+	}
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		q := req.Question[0]
+		for _, rr := range records {
+			if rr.Header().Name == q.Name && rr.Header().Rrtype == q.Qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		if len(resp.Answer) == 0 {
+			resp.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(resp)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: mux}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestResolver(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := startTestServer(t)
+	r, err := newResolver([]string{addr}, "udp", time.Second)
+	assert.NoError(err)
+
+	ips, ttl, err := r.lookupIP("api.example.com", dns.TypeA)
+	assert.NoError(err)
+	assert.Equal([]string{"10.0.0.1", "10.0.0.2"}, ips)
+	assert.Equal(uint32(30), ttl)
+
+	ips, _, err = r.lookupIP("api.example.com", dns.TypeAAAA)
+	assert.NoError(err)
+	assert.Equal([]string{"::1"}, ips)
+
+	ips, _, err = r.lookupIP("unknown.example.com", dns.TypeA)
+	assert.NoError(err)
+	assert.Empty(ips)
+
+	addrs, ttl, err := r.lookupSRV("_http._tcp.example.com", false)
+	assert.NoError(err)
+	assert.Equal(uint32(10), ttl)
+	assert.Equal([]*address{
+		{ip: "10.0.1.1", port: 8080, weight: 60, target: "node1.example.com"},
+		{ip: "10.0.1.2", port: 8081, weight: 30, target: "node2.example.com"},
+	}, addrs)
+}
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	superSpec, err := supervisor.NewSpec(`
+kind: DNSServiceRegistry
+name: dns-registry
+services:
+- name: api
+  domain: api.example.com
+  port: 80
+  tags: [v1]
+- name: web
+  domain: _http._tcp.example.com
+  type: SRV
+  scheme: https
+`)
+	assert.NoError(err)
+
+	d := &DNSServiceRegistry{superSpec: superSpec, spec: superSpec.ObjectSpec().(*Spec)}
+	d.minTTL, d.maxTTL = 15*time.Second, time.Minute
+	d.resolver, err = newResolver([]string{startTestServer(t)}, "udp", time.Second)
+	assert.NoError(err)
+
+	instances, ttl, err := d.resolve(d.spec.Services[0])
+	assert.NoError(err)
+	assert.Equal(30*time.Second, d.clampTTL(ttl))
+	assert.Equal(15*time.Second, d.clampTTL(1))
+	assert.Equal(time.Minute, d.clampTTL(3600))
+	assert.Len(instances, 2)
+	instance := instances["dns-registry/api/10.0.0.1:80"]
+	assert.Equal("http://10.0.0.1:80", instance.URL())
+	assert.Equal([]string{"v1"}, instance.Tags)
+	assert.Equal(0, instance.Weight)
+
+	instances, _, err = d.resolve(d.spec.Services[1])
+	assert.NoError(err)
+	assert.Len(instances, 2)
+	assert.Equal(100, instances["dns-registry/web/10.0.1.1:8080"].Weight)
+	assert.Equal(50, instances["dns-registry/web/10.0.1.2:8081"].Weight)
+	assert.Equal("https://10.0.1.2:8081", instances["dns-registry/web/10.0.1.2:8081"].URL())
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := Spec{Services: []*ServiceSpec{{Name: "api", Domain: "api.example.com"}}}
+	assert.Error(spec.Validate())
+
+	spec.Services[0].Type = RecordTypeSRV
+	assert.NoError(spec.Validate())
+
+	spec.Nameservers = []string{"8.8.8.8"}
+	assert.Error(spec.Validate())
+	spec.Nameservers = []string{"8.8.8.8:53"}
+	assert.NoError(spec.Validate())
+
+	spec.Services = append(spec.Services, spec.Services[0])
+	assert.Error(spec.Validate())
+}
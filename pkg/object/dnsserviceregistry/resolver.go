@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsserviceregistry
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+type (
+	// resolver sends DNS queries to the name servers in turn until one
+	// of them answers.
+	resolver struct {
+		client      *dns.Client
+		nameservers []string
+	}
+
+	// address is a resolved address of a service.
+	address struct {
+		ip     string
+		port   uint16
+		weight int
+		target string
+	}
+)
+
+func newResolver(nameservers []string, network string, timeout time.Duration) (*resolver, error) {
+	if len(nameservers) == 0 {
+		cfg, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("read %s failed: %v", resolvConfPath, err)
+		}
+		for _, server := range cfg.Servers {
+			nameservers = append(nameservers, net.JoinHostPort(server, cfg.Port))
+		}
+	}
+
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("no name server")
+	}
+
+	return &resolver{
+		client:      &dns.Client{Net: network, Timeout: timeout},
+		nameservers: nameservers,
+	}, nil
+}
+
+// query sends a query to the name servers, NXDOMAIN is not considered
+// as an error, an empty answer is returned instead.
+func (r *resolver) query(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+
+	var lastErr error
+	for _, server := range r.nameservers {
+		resp, _, err := r.client.Exchange(m, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch resp.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+			return resp, nil
+		default:
+			lastErr = fmt.Errorf("query %s %s from %s: %s", dns.TypeToString[qtype],
+				name, server, dns.RcodeToString[resp.Rcode])
+		}
+	}
+
+	return nil, lastErr
+}
+
+// lookupIP looks up the IP addresses of the name, qtype is dns.TypeA or
+// dns.TypeAAAA. It returns the addresses and the minimum TTL of them.
+func (r *resolver) lookupIP(name string, qtype uint16) ([]string, uint32, error) {
+	resp, err := r.query(name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ips, ttl := ipsFromRecords(resp.Answer, "")
+	return ips, ttl, nil
+}
+
+// lookupSRV looks up the SRV records of the name, and then the IP
+// addresses of the targets. Only records of the lowest priority are
+// used, others are backups according to RFC 2782.
+func (r *resolver) lookupSRV(name string, lookupIPv6 bool) ([]*address, uint32, error) {
+	resp, err := r.query(name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var srvs []*dns.SRV
+	var ttl uint32
+	for _, rr := range resp.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		// a target of '.' means the service is decidedly not available.
+		if srv.Target == "." {
+			continue
+		}
+		if len(srvs) > 0 && srv.Priority > srvs[0].Priority {
+			continue
+		}
+		if len(srvs) > 0 && srv.Priority < srvs[0].Priority {
+			srvs = srvs[:0]
+		}
+		srvs = append(srvs, srv)
+		ttl = minTTL(ttl, srv.Hdr.Ttl)
+	}
+
+	var result []*address
+	for _, srv := range srvs {
+		// use the additional section first to avoid more queries.
+		ips, ipTTL := ipsFromRecords(resp.Extra, srv.Target)
+		if len(ips) == 0 {
+			qtypes := []uint16{dns.TypeA}
+			if lookupIPv6 {
+				qtypes = append(qtypes, dns.TypeAAAA)
+			}
+			for _, qtype := range qtypes {
+				addrs, t, err := r.lookupIP(srv.Target, qtype)
+				if err != nil {
+					return nil, 0, err
+				}
+				ips = append(ips, addrs...)
+				ipTTL = minTTL(ipTTL, t)
+			}
+		}
+
+		ttl = minTTL(ttl, ipTTL)
+		for _, ip := range ips {
+			result = append(result, &address{
+				ip:     ip,
+				port:   srv.Port,
+				weight: int(srv.Weight),
+				target: strings.TrimSuffix(srv.Target, "."),
+			})
+		}
+	}
+
+	return result, ttl, nil
+}
+
+// ipsFromRecords returns the IP addresses in A and AAAA records, and the
+// minimum TTL of them. Only records of the name are returned if name is
+// not empty.
+func ipsFromRecords(records []dns.RR, name string) ([]string, uint32) {
+	var ips []string
+	var ttl uint32
+	for _, rr := range records {
+		if name != "" && !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A.String())
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA.String())
+		default:
+			continue
+		}
+		ttl = minTTL(ttl, rr.Header().Ttl)
+	}
+	return ips, ttl
+}
+
+// minTTL returns the minimum one of the two TTLs, zero means unset.
+func minTTL(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package federationcontroller implements the FederationController, which
+// pushes selected objects of the primary cluster to secondary clusters.
+//
+// The controller runs in every member of the primary cluster, but only the
+// leader pushes objects. The pushed objects are labeled with the source and
+// the hash of their content, so the controller is stateless: it recognizes
+// the objects it pushed, and detects the ones edited locally in secondary
+// clusters by comparing their content with the hash label.
+package federationcontroller
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of FederationController.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of FederationController.
+	Kind = "FederationController"
+
+	defaultSyncInterval = 30 * time.Second
+	defaultTimeout      = 10 * time.Second
+
+	roleLeader  = "leader"
+	roleStandby = "standby"
+
+	regionStateSynced  = "synced"
+	regionStateDrifted = "drifted"
+	regionStateFailed  = "failed"
+
+	objectStateSynced      = "synced"
+	objectStateCreated     = "created"
+	objectStateUpdated     = "updated"
+	objectStateOverwritten = "overwritten"
+	objectStateDeleted     = "deleted"
+	objectStateDrifted     = "drifted"
+	objectStateConflict    = "conflict"
+	objectStateFailed      = "failed"
+)
+
+func init() {
+	supervisor.Register(&FederationController{})
+}
+
+type (
+	// FederationController pushes objects to secondary clusters.
+	FederationController struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		super     *supervisor.Supervisor
+
+		source  string
+		clients map[string]*regionClient
+		status  atomic.Value // *Status
+
+		done chan struct{}
+	}
+
+	// Status is the status of FederationController.
+	Status struct {
+		Role    string          `json:"role"`
+		Regions []*RegionStatus `json:"regions,omitempty"`
+	}
+
+	// RegionStatus is the sync status of a region.
+	RegionStatus struct {
+		Name         string          `json:"name"`
+		Endpoint     string          `json:"endpoint,omitempty"`
+		State        string          `json:"state"`
+		Error        string          `json:"error,omitempty"`
+		LastSyncTime string          `json:"lastSyncTime"`
+		Objects      []*ObjectStatus `json:"objects,omitempty"`
+
+		// RemoteObjects are the statuses of the objects in the region,
+		// the key is the object name. They are synced to the cluster
+		// by the StatusSyncController separately.
+		RemoteObjects map[string]*RemoteObjectStatus `json:"-"`
+	}
+
+	// ObjectStatus is the sync status of an object in a region.
+	ObjectStatus struct {
+		Name  string `json:"name"`
+		Kind  string `json:"kind"`
+		State string `json:"state"`
+		Error string `json:"error,omitempty"`
+	}
+
+	// RemoteObjectStatus is the status of an object in every member of
+	// a region.
+	RemoteObjectStatus struct {
+		Members map[string]interface{} `json:"members"`
+	}
+)
+
+// Category returns the category of FederationController.
+func (fc *FederationController) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of FederationController.
+func (fc *FederationController) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of FederationController.
+func (fc *FederationController) DefaultSpec() interface{} {
+	return &Spec{
+		SyncInterval: defaultSyncInterval.String(),
+		DriftPolicy:  DriftPolicyReport,
+	}
+}
+
+// Init initializes FederationController.
+func (fc *FederationController) Init(superSpec *supervisor.Spec) {
+	fc.superSpec, fc.spec, fc.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	fc.reload()
+}
+
+// Inherit inherits previous generation of FederationController.
+func (fc *FederationController) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	fc.Init(superSpec)
+}
+
+func (fc *FederationController) reload() {
+	fc.source = fmt.Sprintf("%s/%s", fc.super.Options().ClusterName, fc.superSpec.Name())
+	fc.clients = make(map[string]*regionClient, len(fc.spec.Regions))
+	for _, r := range fc.spec.Regions {
+		fc.clients[r.Name] = newRegionClient(r)
+	}
+	fc.status.Store(&Status{Role: roleStandby})
+	fc.done = make(chan struct{})
+
+	go fc.run()
+}
+
+func (fc *FederationController) run() {
+	ticker := time.NewTicker(fc.spec.syncInterval())
+	defer ticker.Stop()
+
+	for {
+		fc.safeSync()
+
+		select {
+		case <-ticker.C:
+		case <-fc.done:
+			return
+		}
+	}
+}
+
+func (fc *FederationController) safeSync() {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("%s: recover from sync, err: %v, stack trace:\n%s\n",
+				fc.superSpec.Name(), err, debug.Stack())
+		}
+	}()
+
+	fc.sync()
+}
+
+func (fc *FederationController) sync() {
+	if !fc.super.Cluster().IsLeader() {
+		fc.status.Store(&Status{Role: roleStandby})
+		return
+	}
+
+	specs, err := fc.selectObjects()
+	if err != nil {
+		logger.Errorf("%s: select objects failed: %v", fc.superSpec.Name(), err)
+		return
+	}
+
+	status := &Status{Role: roleLeader}
+	for _, r := range fc.spec.Regions {
+		status.Regions = append(status.Regions, fc.syncRegion(r, specs))
+	}
+	fc.status.Store(status)
+}
+
+// selectObjects selects the objects to push from the config in the cluster.
+func (fc *FederationController) selectObjects() ([]*supervisor.Spec, error) {
+	kvs, err := fc.super.Cluster().GetPrefix(fc.super.Cluster().Layout().ConfigObjectPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []*supervisor.Spec
+	for _, v := range kvs {
+		spec, err := fc.super.NewSpec(v)
+		if err != nil {
+			logger.Errorf("%s: bad spec(err: %v) from json: %s", fc.superSpec.Name(), err, v)
+			continue
+		}
+		// Never push federation controllers, or the secondary clusters
+		// would push objects too.
+		if spec.Kind() == Kind {
+			continue
+		}
+		if fc.spec.Selector.match(spec.Kind(), spec.Name(), spec.Labels()) {
+			specs = append(specs, spec)
+		}
+	}
+
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name() < specs[j].Name() })
+	return specs, nil
+}
+
+func (fc *FederationController) syncRegion(region *Region, specs []*supervisor.Spec) *RegionStatus {
+	rc := fc.clients[region.Name]
+	rs := &RegionStatus{
+		Name:         region.Name,
+		State:        regionStateSynced,
+		LastSyncTime: time.Now().Format(time.RFC3339),
+	}
+
+	configs, err := rc.listObjects()
+	if err != nil {
+		rs.State, rs.Error = regionStateFailed, err.Error()
+		logger.Errorf("%s: list objects of region %s failed: %v", fc.superSpec.Name(), region.Name, err)
+		return rs
+	}
+	remote := make(map[string]map[string]interface{}, len(configs))
+	for _, c := range configs {
+		if name, ok := c["name"].(string); ok {
+			remote[name] = c
+		}
+	}
+
+	desired := make(map[string]struct{}, len(specs))
+	for _, spec := range specs {
+		desired[spec.Name()] = struct{}{}
+		os := fc.syncObject(rc, spec, remote[spec.Name()])
+		rs.Objects = append(rs.Objects, os)
+	}
+
+	for name, c := range remote {
+		if _, ok := desired[name]; ok || labelOf(c, LabelSource) != fc.source {
+			continue
+		}
+
+		kind, _ := c["kind"].(string)
+		os := &ObjectStatus{Name: name, Kind: kind, State: objectStateDeleted}
+		if err := rc.deleteObject(name); err != nil {
+			os.State, os.Error = objectStateFailed, err.Error()
+		}
+		rs.Objects = append(rs.Objects, os)
+	}
+
+	for _, os := range rs.Objects {
+		switch os.State {
+		case objectStateFailed, objectStateConflict:
+			rs.State = regionStateFailed
+		case objectStateDrifted:
+			if rs.State != regionStateFailed {
+				rs.State = regionStateDrifted
+			}
+		}
+	}
+
+	statuses, err := rc.listStatuses()
+	if err != nil {
+		logger.Errorf("%s: list statuses of region %s failed: %v", fc.superSpec.Name(), region.Name, err)
+	} else {
+		rs.RemoteObjects = groupStatuses(statuses)
+	}
+	rs.Endpoint = rc.endpoint
+
+	return rs
+}
+
+func (fc *FederationController) syncObject(rc *regionClient, spec *supervisor.Spec, current map[string]interface{}) *ObjectStatus {
+	os := &ObjectStatus{Name: spec.Name(), Kind: spec.Kind()}
+
+	fail := func(state string, err error) *ObjectStatus {
+		os.State, os.Error = state, err.Error()
+		logger.Errorf("%s: sync %s to region %s failed: %v", fc.superSpec.Name(), spec.Name(), rc.region.Name, err)
+		return os
+	}
+
+	obj, err := render(fc.super, fc.source, rc.region, spec)
+	if err != nil {
+		return fail(objectStateFailed, err)
+	}
+
+	if current == nil {
+		if err = rc.createObject(obj); err != nil {
+			return fail(objectStateFailed, err)
+		}
+		os.State = objectStateCreated
+		return os
+	}
+
+	if labelOf(current, LabelSource) != fc.source {
+		return fail(objectStateConflict, fmt.Errorf("object exists and is not pushed by %s", fc.source))
+	}
+
+	cur, err := newObject(fc.super, current)
+	if err != nil {
+		return fail(objectStateFailed, err)
+	}
+
+	state := objectStateUpdated
+	if labelOf(current, LabelHash) != cur.hash {
+		if fc.spec.DriftPolicy != DriftPolicyOverwrite {
+			os.State = objectStateDrifted
+			return os
+		}
+		state = objectStateOverwritten
+	} else if cur.hash == obj.hash {
+		os.State = objectStateSynced
+		return os
+	}
+
+	if err = rc.updateObject(obj); err != nil {
+		return fail(objectStateFailed, err)
+	}
+	os.State = state
+	return os
+}
+
+// groupStatuses groups the statuses by object names, the keys of statuses
+// are in format namespace/name/member.
+func groupStatuses(statuses map[string]map[string]interface{}) map[string]*RemoteObjectStatus {
+	result := make(map[string]*RemoteObjectStatus)
+	for key, status := range statuses {
+		first, last := strings.Index(key, "/"), strings.LastIndex(key, "/")
+		if first < 0 || first == last {
+			continue
+		}
+		name, member := key[first+1:last], key[last+1:]
+
+		ros := result[name]
+		if ros == nil {
+			ros = &RemoteObjectStatus{Members: map[string]interface{}{}}
+			result[name] = ros
+		}
+		ros.Members[member] = status
+	}
+	return result
+}
+
+// Status returns the status of FederationController.
+func (fc *FederationController) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: fc.status.Load().(*Status),
+	}
+}
+
+// Close closes FederationController.
+func (fc *FederationController) Close() {
+	close(fc.done)
+}
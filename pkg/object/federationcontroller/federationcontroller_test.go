@@ -0,0 +1,372 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package federationcontroller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/api"
+	"github.com/megaease/easegress/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	mockObject struct{}
+
+	mockObjectSpec struct {
+		Servers []*mockServer `json:"servers,omitempty"`
+	}
+
+	mockServer struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+)
+
+func (m *mockObject) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+func (m *mockObject) Kind() string {
+	return "MockFederationObject"
+}
+
+func (m *mockObject) DefaultSpec() interface{} {
+	return &mockObjectSpec{}
+}
+
+func (m *mockObject) Init(superSpec *supervisor.Spec) {}
+
+func (m *mockObject) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {}
+
+func (m *mockObject) Status() *supervisor.Status {
+	return &supervisor.Status{}
+}
+
+func (m *mockObject) Close() {}
+
+func init() {
+	logger.InitNop()
+	supervisor.Register(&mockObject{})
+}
+
+// fakeRegion fakes the admin API of a secondary cluster.
+type fakeRegion struct {
+	mutex   sync.Mutex
+	objects map[string]map[string]interface{}
+}
+
+func (fr *fakeRegion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, api.APIPrefixV2)
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case path == api.StatusObjectPrefix:
+		statuses := map[string]interface{}{}
+		for name := range fr.objects {
+			statuses["default/"+name+"/member-1"] = map[string]interface{}{"health": "ok"}
+		}
+		w.Write(codectool.MustMarshalJSON(statuses))
+	case path == api.ObjectPrefix && r.Method == http.MethodGet:
+		configs := []map[string]interface{}{}
+		for _, c := range fr.objects {
+			configs = append(configs, c)
+		}
+		w.Write(codectool.MustMarshalJSON(configs))
+	case path == api.ObjectPrefix && r.Method == http.MethodPost,
+		strings.HasPrefix(path, api.ObjectPrefix+"/") && r.Method == http.MethodPut:
+		config := map[string]interface{}{}
+		codectool.MustUnmarshal(body, &config)
+		fr.objects[config["name"].(string)] = config
+	case strings.HasPrefix(path, api.ObjectPrefix+"/") && r.Method == http.MethodDelete:
+		delete(fr.objects, strings.TrimPrefix(path, api.ObjectPrefix+"/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(codectool.MustMarshalJSON(&api.Err{Code: http.StatusNotFound, Message: "not found"}))
+	}
+}
+
+func newTestController(t *testing.T, local map[string]string, yamlConfig string) *FederationController {
+	cls := clustertest.NewMockedCluster()
+	cls.MockedIsLeader = func() bool { return true }
+	cls.MockedGetPrefix = func(prefix string) (map[string]string, error) {
+		return local, nil
+	}
+
+	var mockMap sync.Map
+	super := supervisor.NewMock(&option.Options{ClusterName: "primary"}, cls,
+		mockMap, mockMap, nil, nil, false, nil, nil)
+
+	superSpec, err := super.NewSpec(yamlConfig)
+	assert.Nil(t, err)
+
+	fc := &FederationController{
+		superSpec: superSpec,
+		spec:      superSpec.ObjectSpec().(*Spec),
+		super:     super,
+		source:    "primary/" + superSpec.Name(),
+		clients:   map[string]*regionClient{},
+	}
+	for _, r := range fc.spec.Regions {
+		fc.clients[r.Name] = newRegionClient(r)
+	}
+	fc.status.Store(&Status{Role: roleStandby})
+	return fc
+}
+
+func TestSpec(t *testing.T) {
+	assert := assert.New(t)
+	super := supervisor.NewDefaultMock()
+
+	_, err := super.NewSpec(`
+name: federation
+kind: FederationController
+selector:
+  kinds: [MockFederationObject]
+regions:
+- name: us
+  endpoints: [10.0.0.1:2381]
+`)
+	assert.NotNil(err)
+
+	_, err = super.NewSpec(`
+name: federation
+kind: FederationController
+selector:
+  kinds: [MockFederationObject]
+regions:
+- name: us
+  endpoints: [http://10.0.0.1:2381]
+- name: us
+  endpoints: [http://10.0.0.2:2381]
+`)
+	assert.NotNil(err)
+
+	_, err = super.NewSpec(`
+name: federation
+kind: FederationController
+selector:
+  kinds: [MockFederationObject]
+regions:
+- name: us
+  endpoints: [http://10.0.0.1:2381]
+  overrides:
+  - path: labels.foo
+    value: bar
+`)
+	assert.NotNil(err)
+
+	// the selector is required and can't be empty
+	_, err = super.NewSpec(`
+name: federation
+kind: FederationController
+regions:
+- name: us
+  endpoints: [http://10.0.0.1:2381]
+`)
+	assert.NotNil(err)
+
+	_, err = super.NewSpec(`
+name: federation
+kind: FederationController
+selector: {}
+regions:
+- name: us
+  endpoints: [http://10.0.0.1:2381]
+`)
+	assert.NotNil(err)
+
+	spec, err := super.NewSpec(`
+name: federation
+kind: FederationController
+selector:
+  kinds: [MockFederationObject]
+regions:
+- name: us
+  endpoints: [http://10.0.0.1:2381]
+`)
+	assert.Nil(err)
+	assert.Equal(DriftPolicyReport, spec.ObjectSpec().(*Spec).DriftPolicy)
+}
+
+func TestSelector(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Selector{}
+	assert.False(s.match("Pipeline", "p1", nil))
+
+	s = &Selector{Kinds: []string{"Pipeline"}, Labels: map[string]string{"env": "prod"}}
+	assert.False(s.match("HTTPServer", "s1", map[string]string{"env": "prod"}))
+	assert.False(s.match("Pipeline", "p1", nil))
+	assert.False(s.match("Pipeline", "p1", map[string]string{"env": "test"}))
+	assert.True(s.match("Pipeline", "p1", map[string]string{"env": "prod", "team": "a"}))
+
+	s = &Selector{Names: []string{"p1"}}
+	assert.True(s.match("Pipeline", "p1", nil))
+	assert.False(s.match("Pipeline", "p2", nil))
+}
+
+func TestSetPath(t *testing.T) {
+	assert := assert.New(t)
+
+	config := map[string]interface{}{}
+	codectool.MustUnmarshal([]byte(`
+filters:
+- name: proxy
+  pools:
+  - servers:
+    - url: http://127.0.0.1:8080
+`), &config)
+
+	value := []interface{}{map[string]interface{}{"url": "http://10.0.0.1:8080"}}
+	assert.Nil(setPath(config, strings.Split("filters.proxy.pools.0.servers", "."), value))
+	servers := config["filters"].([]interface{})[0].(map[string]interface{})["pools"].([]interface{})[0].(map[string]interface{})["servers"]
+	assert.Equal(value, servers)
+
+	assert.Nil(setPath(config, []string{"a", "b"}, "c"))
+	assert.Equal("c", config["a"].(map[string]interface{})["b"])
+
+	assert.NotNil(setPath(config, strings.Split("filters.mock.x", "."), 1))
+	assert.NotNil(setPath(config, strings.Split("filters.5.x", "."), 1))
+	assert.NotNil(setPath(config, strings.Split("a.b.c", "."), 1))
+}
+
+func TestSync(t *testing.T) {
+	assert := assert.New(t)
+
+	region := &fakeRegion{objects: map[string]map[string]interface{}{
+		"conflict": {"name": "conflict", "kind": "MockFederationObject"},
+	}}
+	server := httptest.NewServer(region)
+	defer server.Close()
+
+	local := map[string]string{
+		"/config/objects/demo": `{"name": "demo", "kind": "MockFederationObject", "labels": {"federated": "true"},
+			"servers": [{"name": "s1", "url": "http://127.0.0.1:8080"}]}`,
+		"/config/objects/conflict": `{"name": "conflict", "kind": "MockFederationObject", "labels": {"federated": "true"}}`,
+		"/config/objects/local":    `{"name": "local", "kind": "MockFederationObject"}`,
+	}
+
+	fc := newTestController(t, local, `
+name: federation
+kind: FederationController
+selector:
+  labels:
+    federated: "true"
+regions:
+- name: us
+  endpoints: [http://127.0.0.1:1, `+server.URL+`]
+  overrides:
+  - object: demo
+    path: servers.s1.url
+    value: http://10.0.0.1:8080
+`)
+
+	findObject := func(rs *RegionStatus, name string) *ObjectStatus {
+		for _, os := range rs.Objects {
+			if os.Name == name {
+				return os
+			}
+		}
+		return nil
+	}
+
+	// Create.
+	fc.sync()
+	status := fc.Status().ObjectStatus.(*Status)
+	assert.Equal(roleLeader, status.Role)
+	rs := status.Regions[0]
+	assert.Equal(server.URL, rs.Endpoint)
+	assert.Equal(regionStateFailed, rs.State)
+	assert.Equal(objectStateCreated, findObject(rs, "demo").State)
+	assert.Equal(objectStateConflict, findObject(rs, "conflict").State)
+	assert.Nil(findObject(rs, "local"))
+	assert.Contains(rs.RemoteObjects, "demo")
+	assert.Contains(rs.RemoteObjects["demo"].Members, "member-1")
+
+	demo := region.objects["demo"]
+	assert.Equal("primary/federation", labelOf(demo, LabelSource))
+	assert.NotEmpty(labelOf(demo, LabelHash))
+	assert.Equal("http://10.0.0.1:8080", demo["servers"].([]interface{})[0].(map[string]interface{})["url"])
+
+	// Synced.
+	delete(region.objects, "conflict")
+	fc.sync()
+	rs = fc.Status().ObjectStatus.(*Status).Regions[0]
+	assert.Equal(regionStateSynced, rs.State)
+	assert.Equal(objectStateSynced, findObject(rs, "demo").State)
+	assert.Equal(objectStateCreated, findObject(rs, "conflict").State)
+
+	// Drift is reported.
+	region.objects["demo"]["servers"] = []interface{}{}
+	fc.sync()
+	rs = fc.Status().ObjectStatus.(*Status).Regions[0]
+	assert.Equal(regionStateDrifted, rs.State)
+	assert.Equal(objectStateDrifted, findObject(rs, "demo").State)
+	assert.Empty(region.objects["demo"]["servers"])
+
+	// Drift is overwritten.
+	fc.spec.DriftPolicy = DriftPolicyOverwrite
+	fc.sync()
+	rs = fc.Status().ObjectStatus.(*Status).Regions[0]
+	assert.Equal(objectStateOverwritten, findObject(rs, "demo").State)
+	assert.NotEmpty(region.objects["demo"]["servers"])
+
+	// Update and delete.
+	local["/config/objects/demo"] = `{"name": "demo", "kind": "MockFederationObject", "labels": {"federated": "true"},
+		"servers": [{"name": "s1", "url": "http://127.0.0.1:9090"}, {"name": "s2", "url": "http://127.0.0.2:9090"}]}`
+	delete(local, "/config/objects/conflict")
+	fc.sync()
+	rs = fc.Status().ObjectStatus.(*Status).Regions[0]
+	assert.Equal(objectStateUpdated, findObject(rs, "demo").State)
+	assert.Equal(objectStateDeleted, findObject(rs, "conflict").State)
+	assert.Len(region.objects["demo"]["servers"], 2)
+	assert.NotContains(region.objects, "conflict")
+
+	// Standby.
+	fc.super.Cluster().(*clustertest.MockedCluster).MockedIsLeader = func() bool { return false }
+	fc.sync()
+	assert.Equal(roleStandby, fc.Status().ObjectStatus.(*Status).Role)
+}
+
+func TestGroupStatuses(t *testing.T) {
+	assert := assert.New(t)
+
+	result := groupStatuses(map[string]map[string]interface{}{
+		"default/demo/m1":           {"a": 1},
+		"default/demo/m2":           {"a": 2},
+		"eg-traffic-default/srv/m1": {"b": 1},
+		"bad":                       {},
+		"bad/key":                   {},
+	})
+	assert.Len(result, 2)
+	assert.Len(result["demo"].Members, 2)
+	assert.Len(result["srv"].Members, 1)
+}
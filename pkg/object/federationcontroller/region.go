@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package federationcontroller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/api"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+// regionClient calls the admin API of a region.
+type regionClient struct {
+	region   *Region
+	client   *http.Client
+	endpoint string
+}
+
+func newRegionClient(region *Region) *regionClient {
+	return &regionClient{
+		region: region,
+		client: &http.Client{
+			Timeout: region.timeout(),
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: region.InsecureSkipVerify},
+			},
+		},
+	}
+}
+
+func (rc *regionClient) do(method, path string, body []byte) ([]byte, error) {
+	// Prefer the endpoint succeeded last time.
+	endpoints := rc.region.Endpoints
+	if rc.endpoint != "" {
+		endpoints = append([]string{rc.endpoint}, endpoints...)
+	}
+
+	var lastErr error
+	for _, ep := range endpoints {
+		respBody, err := rc.doEndpoint(ep, method, path, body)
+		if err == nil {
+			rc.endpoint = ep
+			return respBody, nil
+		}
+		if _, ok := err.(*apiError); ok {
+			rc.endpoint = ep
+			return nil, err
+		}
+		lastErr = err
+	}
+
+	rc.endpoint = ""
+	return nil, lastErr
+}
+
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.message)
+}
+
+func (rc *regionClient) doEndpoint(endpoint, method, path string, body []byte) ([]byte, error) {
+	url := strings.TrimSuffix(endpoint, "/") + api.APIPrefixV2 + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range rc.region.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &api.Err{}
+		if codectool.UnmarshalJSON(respBody, apiErr) == nil && apiErr.Message != "" {
+			return nil, &apiError{code: resp.StatusCode, message: apiErr.Message}
+		}
+		return nil, &apiError{code: resp.StatusCode, message: string(respBody)}
+	}
+	return respBody, nil
+}
+
+func (rc *regionClient) listObjects() ([]map[string]interface{}, error) {
+	body, err := rc.do(http.MethodGet, api.ObjectPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []map[string]interface{}
+	if err = codectool.UnmarshalJSON(body, &configs); err != nil {
+		return nil, fmt.Errorf("unmarshal objects failed: %v", err)
+	}
+	return configs, nil
+}
+
+func (rc *regionClient) createObject(obj *object) error {
+	_, err := rc.do(http.MethodPost, api.ObjectPrefix, codectool.MustMarshalJSON(obj.config))
+	return err
+}
+
+func (rc *regionClient) updateObject(obj *object) error {
+	_, err := rc.do(http.MethodPut, api.ObjectPrefix+"/"+obj.name, codectool.MustMarshalJSON(obj.config))
+	return err
+}
+
+func (rc *regionClient) deleteObject(name string) error {
+	_, err := rc.do(http.MethodDelete, api.ObjectPrefix+"/"+name, nil)
+	return err
+}
+
+// listStatuses returns the statuses of the objects in the region, the
+// key is in format namespace/name/member.
+func (rc *regionClient) listStatuses() (map[string]map[string]interface{}, error) {
+	body, err := rc.do(http.MethodGet, api.StatusObjectPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]map[string]interface{}{}
+	if err = codectool.UnmarshalJSON(body, &statuses); err != nil {
+		return nil, fmt.Errorf("unmarshal statuses failed: %v", err)
+	}
+	return statuses, nil
+}
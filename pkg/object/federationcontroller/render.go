@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package federationcontroller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// LabelSource is the label of the pushed objects, its value is the
+	// cluster name and the controller name of the primary.
+	LabelSource = "federation.easegress.megaease.com/source"
+	// LabelHash is the label of the pushed objects, its value is the
+	// hash of the object when it was pushed, which is used to detect
+	// local changes in the secondary cluster.
+	LabelHash = "federation.easegress.megaease.com/hash"
+)
+
+// object is an object pushed to or existing in a region.
+type object struct {
+	name   string
+	kind   string
+	config map[string]interface{}
+	// hash is the hash of the object content, excluding LabelHash.
+	hash string
+}
+
+func copyConfig(config map[string]interface{}) (map[string]interface{}, error) {
+	buff, err := codectool.MarshalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = codectool.UnmarshalJSON(buff, &m)
+	return m, err
+}
+
+func labelsOf(config map[string]interface{}) map[string]interface{} {
+	labels, _ := config["labels"].(map[string]interface{})
+	if labels == nil {
+		labels = map[string]interface{}{}
+		config["labels"] = labels
+	}
+	return labels
+}
+
+// labelOf returns the value of the label of the config.
+func labelOf(config map[string]interface{}, key string) string {
+	labels, _ := config["labels"].(map[string]interface{})
+	value, _ := labels[key].(string)
+	return value
+}
+
+// newObject normalizes the config by the supervisor, so that the default
+// values are filled in and the hash is stable.
+func newObject(super *supervisor.Supervisor, config map[string]interface{}) (*object, error) {
+	config, err := copyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	delete(labelsOf(config), LabelHash)
+
+	buff, err := codectool.MarshalJSON(config)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := super.NewSpec(string(buff))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(spec.JSONConfig()))
+	return &object{
+		name:   spec.Name(),
+		kind:   spec.Kind(),
+		config: spec.RawSpec(),
+		hash:   hex.EncodeToString(sum[:8]),
+	}, nil
+}
+
+// render renders the object to push to the region, the overrides of the
+// region are applied and the labels are set.
+func render(super *supervisor.Supervisor, source string, region *Region, spec *supervisor.Spec) (*object, error) {
+	config, err := copyConfig(spec.RawSpec())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range region.Overrides {
+		if !o.match(spec.Kind(), spec.Name()) {
+			continue
+		}
+		if err := setPath(config, strings.Split(o.Path, "."), o.Value); err != nil {
+			return nil, fmt.Errorf("override %s failed: %v", o.Path, err)
+		}
+	}
+	labelsOf(config)[LabelSource] = source
+
+	obj, err := newObject(super, config)
+	if err != nil {
+		return nil, err
+	}
+	labelsOf(obj.config)[LabelHash] = obj.hash
+	return obj, nil
+}
+
+// setPath sets the value at the path of node.
+func setPath(node interface{}, path []string, value interface{}) error {
+	seg := path[0]
+	last := len(path) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if last {
+			n[seg] = value
+			return nil
+		}
+		child, exists := n[seg]
+		if !exists || child == nil {
+			child = map[string]interface{}{}
+			n[seg] = child
+		}
+		return setPath(child, path[1:], value)
+
+	case []interface{}:
+		index := indexOf(n, seg)
+		if index < 0 {
+			return fmt.Errorf("element %s not found", seg)
+		}
+		if last {
+			n[index] = value
+			return nil
+		}
+		return setPath(n[index], path[1:], value)
+
+	default:
+		return fmt.Errorf("%s is not in an object or array", seg)
+	}
+}
+
+// indexOf returns the index of the element in the array, seg is either
+// an index or the value of the name field of the element.
+func indexOf(array []interface{}, seg string) int {
+	if i, err := strconv.Atoi(seg); err == nil {
+		if i < 0 || i >= len(array) {
+			return -1
+		}
+		return i
+	}
+
+	for i, elem := range array {
+		if m, ok := elem.(map[string]interface{}); ok && m["name"] == seg {
+			return i
+		}
+	}
+	return -1
+}
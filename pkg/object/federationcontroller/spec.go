@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package federationcontroller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// DriftPolicyReport reports the drifted objects and leaves them alone.
+	DriftPolicyReport = "Report"
+	// DriftPolicyOverwrite overwrites the drifted objects.
+	DriftPolicyOverwrite = "Overwrite"
+)
+
+type (
+	// Spec describes the FederationController.
+	Spec struct {
+		SyncInterval string    `json:"syncInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		Selector     *Selector `json:"selector" jsonschema:"required"`
+		DriftPolicy  string    `json:"driftPolicy,omitempty" jsonschema:"omitempty,enum=,enum=Report,enum=Overwrite"`
+		Regions      []*Region `json:"regions" jsonschema:"required,minItems=1"`
+	}
+
+	// Selector selects the objects to push, an object is selected if it
+	// matches all the non-empty fields. At least one field is required.
+	Selector struct {
+		Kinds  []string          `json:"kinds,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Names  []string          `json:"names,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Labels map[string]string `json:"labels,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Region is a secondary cluster.
+	Region struct {
+		Name string `json:"name" jsonschema:"required,format=urlname"`
+		// Endpoints are the admin API addresses of the members of the
+		// secondary cluster, e.g. http://10.0.0.1:2381. They are tried
+		// in order until one of them succeeds.
+		Endpoints          []string          `json:"endpoints" jsonschema:"required,minItems=1"`
+		InsecureSkipVerify bool              `json:"insecureSkipVerify,omitempty" jsonschema:"omitempty"`
+		Headers            map[string]string `json:"headers,omitempty" jsonschema:"omitempty"`
+		Timeout            string            `json:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+		Overrides          []*Override       `json:"overrides,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Override sets the value at the path of the objects pushed to a region.
+	//
+	// The path is dot separated, an element of an array is selected by its
+	// index or by the value of its name field, for example, the path of the
+	// servers of the first pool of the proxy filter in a pipeline could be
+	// filters.proxy.pools.0.servers.
+	Override struct {
+		Kind   string      `json:"kind,omitempty" jsonschema:"omitempty"`
+		Object string      `json:"object,omitempty" jsonschema:"omitempty"`
+		Path   string      `json:"path" jsonschema:"required"`
+		Value  interface{} `json:"value"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if spec.Selector == nil || spec.Selector.empty() {
+		return fmt.Errorf("selector should have kinds, names or labels")
+	}
+
+	regions := map[string]struct{}{}
+	for _, r := range spec.Regions {
+		if _, exists := regions[r.Name]; exists {
+			return fmt.Errorf("duplicated region %s", r.Name)
+		}
+		regions[r.Name] = struct{}{}
+	}
+	return nil
+}
+
+// Validate validates the Region.
+func (r *Region) Validate() error {
+	for _, ep := range r.Endpoints {
+		if !strings.HasPrefix(ep, "http://") && !strings.HasPrefix(ep, "https://") {
+			return fmt.Errorf("endpoint %s should start with http:// or https://", ep)
+		}
+	}
+	return nil
+}
+
+// Validate validates the Override.
+func (o *Override) Validate() error {
+	segs := strings.Split(o.Path, ".")
+	for _, seg := range segs {
+		if seg == "" {
+			return fmt.Errorf("invalid path %s", o.Path)
+		}
+	}
+	switch segs[0] {
+	case "name", "kind", "version", "labels":
+		return fmt.Errorf("metadata %s can't be overridden", segs[0])
+	}
+	return nil
+}
+
+func (spec *Spec) syncInterval() time.Duration {
+	d, err := time.ParseDuration(spec.SyncInterval)
+	if err != nil || d <= 0 {
+		return defaultSyncInterval
+	}
+	return d
+}
+
+func (r *Region) timeout() time.Duration {
+	d, err := time.ParseDuration(r.Timeout)
+	if err != nil || d <= 0 {
+		return defaultTimeout
+	}
+	return d
+}
+
+func (s *Selector) empty() bool {
+	return len(s.Kinds) == 0 && len(s.Names) == 0 && len(s.Labels) == 0
+}
+
+// match returns whether the object is selected, an empty selector selects
+// nothing.
+func (s *Selector) match(kind, name string, labels map[string]string) bool {
+	if s.empty() {
+		return false
+	}
+	if len(s.Kinds) > 0 && !stringtool.StrInSlice(kind, s.Kinds) {
+		return false
+	}
+	if len(s.Names) > 0 && !stringtool.StrInSlice(name, s.Names) {
+		return false
+	}
+	for k, v := range s.Labels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *Override) match(kind, name string) bool {
+	if o.Kind != "" && o.Kind != kind {
+		return false
+	}
+	if o.Object != "" && o.Object != name {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,583 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/function/spec"
+)
+
+const (
+	localDefaultContainerRuntime = "docker"
+	localDefaultIdleTimeout      = 5 * time.Minute
+	localDefaultStartTimeout     = 30 * time.Second
+
+	// localDefaultMaxReplicas is the max replicas of a function if its
+	// maxReplica is zero.
+	localDefaultMaxReplicas = 10
+	localScaleInterval      = time.Second
+	// localScaleDownDelay is the duration to wait after the last scaling
+	// before scaling down a function.
+	localScaleDownDelay = 30 * time.Second
+	// localRPSWindow is the count of scale intervals to calculate the RPS.
+	localRPSWindow = 10
+	// localMaxStartBackoff is the max backoff duration to start instances
+	// after failures.
+	localMaxStartBackoff = time.Minute
+)
+
+type (
+	// localProvider is the FaaSProvider which runs functions as local
+	// processes or OCI containers. It has a gateway which routes requests
+	// to the instances of functions by the Host header, starts instances
+	// on cold starts and scales functions by their load.
+	localProvider struct {
+		name         string
+		spec         *spec.Local
+		runtime      string
+		idleTimeout  time.Duration
+		startTimeout time.Duration
+
+		mutex     sync.Mutex
+		functions map[string]*localFunction
+
+		server *http.Server
+		addr   string
+		done   chan struct{}
+	}
+
+	// localFunction is a function of the local provider.
+	localFunction struct {
+		provider *localProvider
+
+		inFlight    atomic.Int64
+		requests    atomic.Int64
+		lastRequest atomic.Int64
+
+		mutex     sync.Mutex
+		spec      *spec.Spec
+		runner    runner
+		instances []*localInstance
+		// readyCh is closed and replaced when an instance becomes ready.
+		readyCh chan struct{}
+		deleted bool
+
+		rpsWindow [localRPSWindow]int64
+		windowIdx int
+		lastScale time.Time
+
+		everReady     bool
+		lastError     string
+		startFailures int
+		nextStart     time.Time
+	}
+)
+
+func newLocalProvider(name string, localSpec *spec.Local) *localProvider {
+	if localSpec == nil {
+		localSpec = &spec.Local{}
+	}
+	return &localProvider{
+		name:      name,
+		spec:      localSpec,
+		functions: map[string]*localFunction{},
+		done:      make(chan struct{}),
+	}
+}
+
+func parseDuration(s string, dflt time.Duration) (time.Duration, error) {
+	if s == "" {
+		return dflt, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Init starts the gateway and the scaler of the local provider.
+func (lp *localProvider) Init() error {
+	var err error
+
+	lp.runtime = lp.spec.ContainerRuntime
+	if lp.runtime == "" {
+		lp.runtime = localDefaultContainerRuntime
+	}
+	if lp.idleTimeout, err = parseDuration(lp.spec.IdleTimeout, localDefaultIdleTimeout); err != nil {
+		logger.Errorf("BUG: parse idle timeout: %s failed: %v", lp.spec.IdleTimeout, err)
+		return err
+	}
+	if lp.startTimeout, err = parseDuration(lp.spec.StartTimeout, localDefaultStartTimeout); err != nil {
+		logger.Errorf("BUG: parse start timeout: %s failed: %v", lp.spec.StartTimeout, err)
+		return err
+	}
+
+	if len(lp.spec.AllowedImages) > 0 {
+		removeContainers(lp.runtime, lp.name)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		logger.Errorf("local faas provider listen failed: %v", err)
+		return err
+	}
+	lp.addr = l.Addr().String()
+	lp.server = &http.Server{Handler: lp}
+	go lp.server.Serve(l)
+
+	go lp.scale()
+	return nil
+}
+
+// Endpoint returns the address of the gateway, the gateway recognizes
+// functions by their names.
+func (lp *localProvider) Endpoint(name string) (string, string) {
+	return "http://" + lp.addr, name
+}
+
+func (lp *localProvider) getFunction(name string) *localFunction {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+	return lp.functions[name]
+}
+
+func (lp *localProvider) newRunner(funcSpec *spec.Spec) (runner, error) {
+	switch funcSpec.AutoScaleType {
+	case spec.AutoScaleMetricConcurrency, spec.AutoScaleMetricRPS:
+	default:
+		return nil, fmt.Errorf("autoscale type %s is not supported by local FaaS provider", funcSpec.AutoScaleType)
+	}
+	if v, err := strconv.ParseFloat(funcSpec.AutoScaleValue, 64); err != nil || v <= 0 {
+		return nil, fmt.Errorf("invalid autoscale value: %s", funcSpec.AutoScaleValue)
+	}
+
+	if funcSpec.Image != "" {
+		return newContainerRunner(lp.runtime, lp.name, lp.spec, funcSpec)
+	}
+	return newProcessRunner(lp.spec, funcSpec)
+}
+
+// Create creates the function, its instances are started by the scaler.
+func (lp *localProvider) Create(funcSpec *spec.Spec) error {
+	r, err := lp.newRunner(funcSpec)
+	if err != nil {
+		return err
+	}
+
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+
+	if _, ok := lp.functions[funcSpec.Name]; ok {
+		return fmt.Errorf("function %s already exists", funcSpec.Name)
+	}
+	lp.functions[funcSpec.Name] = &localFunction{
+		provider: lp,
+		spec:     funcSpec,
+		runner:   r,
+		readyCh:  make(chan struct{}),
+	}
+	return nil
+}
+
+// Update updates the function, all its instances are replaced.
+func (lp *localProvider) Update(funcSpec *spec.Spec) error {
+	r, err := lp.newRunner(funcSpec)
+	if err != nil {
+		return err
+	}
+
+	f := lp.getFunction(funcSpec.Name)
+	if f == nil {
+		return lp.Create(funcSpec)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.stopInstances()
+	f.spec, f.runner = funcSpec, r
+	f.everReady, f.lastError = false, ""
+	f.startFailures, f.nextStart = 0, time.Time{}
+	return nil
+}
+
+// Delete deletes the function and stops all its instances.
+func (lp *localProvider) Delete(name string) error {
+	lp.mutex.Lock()
+	f := lp.functions[name]
+	delete(lp.functions, name)
+	lp.mutex.Unlock()
+
+	if f == nil {
+		return nil
+	}
+
+	f.mutex.Lock()
+	f.deleted = true
+	instances := f.instances
+	f.instances = nil
+	close(f.readyCh)
+	f.mutex.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *localInstance) {
+			defer wg.Done()
+			inst.stop()
+		}(inst)
+	}
+	wg.Wait()
+	return nil
+}
+
+// GetStatus returns the status of the function.
+func (lp *localProvider) GetStatus(name string) (*spec.Status, error) {
+	f := lp.getFunction(name)
+	if f == nil {
+		return nil, ErrFunctionNotFound
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ready := 0
+	for _, inst := range f.instances {
+		if inst.ready {
+			ready++
+		}
+	}
+
+	status := &spec.Status{
+		Name: name,
+		ExtData: map[string]string{
+			"runtime":       f.runner.kind(),
+			"replicas":      strconv.Itoa(len(f.instances)),
+			"readyReplicas": strconv.Itoa(ready),
+		},
+	}
+
+	switch {
+	case ready > 0:
+		status.Event = spec.ReadyEvent
+	case f.startFailures > 0:
+		status.Event = spec.ErrorEvent
+		status.ExtData["error"] = f.lastError
+	case f.everReady || f.spec.MinReplica == 0:
+		// requests start instances on cold starts.
+		status.Event = spec.ReadyEvent
+	default:
+		status.Event = spec.PendingEvent
+	}
+	return status, nil
+}
+
+// Close stops the gateway, the scaler and all instances.
+func (lp *localProvider) Close() {
+	close(lp.done)
+	if lp.server != nil {
+		lp.server.Close()
+	}
+
+	lp.mutex.Lock()
+	names := make([]string, 0, len(lp.functions))
+	for name := range lp.functions {
+		names = append(names, name)
+	}
+	lp.mutex.Unlock()
+
+	for _, name := range names {
+		lp.Delete(name)
+	}
+}
+
+// ServeHTTP routes requests to the instances of functions.
+func (lp *localProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.Host
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+
+	f := lp.getFunction(name)
+	if f == nil {
+		http.Error(w, fmt.Sprintf("function %s not found", name), http.StatusNotFound)
+		return
+	}
+	f.serve(w, r)
+}
+
+func (lp *localProvider) scale() {
+	ticker := time.NewTicker(localScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lp.done:
+			return
+		case now := <-ticker.C:
+			lp.mutex.Lock()
+			functions := make([]*localFunction, 0, len(lp.functions))
+			for _, f := range lp.functions {
+				functions = append(functions, f)
+			}
+			lp.mutex.Unlock()
+
+			for _, f := range functions {
+				f.scale(now)
+			}
+		}
+	}
+}
+
+func (f *localFunction) serve(w http.ResponseWriter, r *http.Request) {
+	f.inFlight.Add(1)
+	f.requests.Add(1)
+	f.lastRequest.Store(time.Now().UnixNano())
+	defer func() {
+		f.inFlight.Add(-1)
+		f.lastRequest.Store(time.Now().UnixNano())
+	}()
+
+	ctx, cancel := context.WithTimeout(r.Context(), f.provider.startTimeout)
+	inst, err := f.pick(ctx)
+	cancel()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("function %s is not available: %v", f.spec.Name, err), http.StatusServiceUnavailable)
+		return
+	}
+
+	inst.inFlight.Add(1)
+	defer inst.inFlight.Add(-1)
+	inst.proxy.ServeHTTP(w, r)
+}
+
+// pick picks the ready instance with the least in-flight requests, it
+// starts an instance and waits for it if there are no instances.
+func (f *localFunction) pick(ctx context.Context) (*localInstance, error) {
+	for {
+		f.mutex.Lock()
+		if f.deleted {
+			f.mutex.Unlock()
+			return nil, fmt.Errorf("function is deleted")
+		}
+
+		var picked *localInstance
+		for _, inst := range f.instances {
+			if inst.ready && (picked == nil || inst.inFlight.Load() < picked.inFlight.Load()) {
+				picked = inst
+			}
+		}
+		if picked != nil {
+			f.mutex.Unlock()
+			return picked, nil
+		}
+
+		var wait <-chan time.Time
+		if len(f.instances) == 0 {
+			if d := time.Until(f.nextStart); d > 0 {
+				wait = time.After(d)
+			} else {
+				f.startInstance()
+			}
+		}
+		readyCh := f.readyCh
+		f.mutex.Unlock()
+
+		select {
+		case <-readyCh:
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// desiredReplicas returns the desired count of replicas by the load,
+// the caller must hold the lock.
+func (f *localFunction) desiredReplicas(now time.Time) int {
+	target, _ := strconv.ParseFloat(f.spec.AutoScaleValue, 64)
+
+	var load float64
+	inFlight := f.inFlight.Load()
+	if f.spec.AutoScaleType == spec.AutoScaleMetricRPS {
+		var requests int64
+		for _, n := range f.rpsWindow {
+			requests += n
+		}
+		load = float64(requests) / (localRPSWindow * localScaleInterval).Seconds()
+	} else {
+		load = float64(inFlight)
+	}
+
+	desired := int(math.Ceil(load / target))
+	if desired == 0 {
+		// keep one instance until the function is idle.
+		lastRequest := time.Unix(0, f.lastRequest.Load())
+		if inFlight > 0 || now.Sub(lastRequest) < f.provider.idleTimeout {
+			desired = 1
+		}
+	}
+	if desired == 0 && f.startFailures > 0 {
+		// retry with backoff, so that the function could recover.
+		desired = 1
+	}
+
+	maxReplicas := f.spec.MaxReplica
+	if maxReplicas == 0 {
+		maxReplicas = localDefaultMaxReplicas
+	}
+	if desired > maxReplicas {
+		desired = maxReplicas
+	}
+	if desired < f.spec.MinReplica {
+		desired = f.spec.MinReplica
+	}
+	return desired
+}
+
+// scale scales the function by its load.
+func (f *localFunction) scale(now time.Time) {
+	requests := f.requests.Swap(0)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.deleted {
+		return
+	}
+
+	f.rpsWindow[f.windowIdx%localRPSWindow] = requests
+	f.windowIdx++
+
+	desired := f.desiredReplicas(now)
+	current := len(f.instances)
+
+	switch {
+	case desired > current:
+		if now.Before(f.nextStart) {
+			return
+		}
+		for i := current; i < desired; i++ {
+			f.startInstance()
+		}
+		f.lastScale = now
+	case desired < current:
+		delay := localScaleDownDelay
+		if f.provider.idleTimeout < delay {
+			delay = f.provider.idleTimeout
+		}
+		if now.Sub(f.lastScale) < delay {
+			return
+		}
+		// stop the newest idle instances first.
+		for i := len(f.instances) - 1; i >= 0 && len(f.instances) > desired; i-- {
+			inst := f.instances[i]
+			if inst.ready && inst.inFlight.Load() == 0 {
+				f.removeInstance(inst)
+				go inst.stop()
+			}
+		}
+		f.lastScale = now
+	}
+}
+
+// startInstance starts a new instance, the caller must hold the lock.
+func (f *localFunction) startInstance() {
+	inst := &localInstance{function: f, runner: f.runner}
+	f.instances = append(f.instances, inst)
+	go inst.start(f.provider.startTimeout)
+}
+
+// removeInstance removes the instance, the caller must hold the lock.
+func (f *localFunction) removeInstance(inst *localInstance) bool {
+	for i, v := range f.instances {
+		if v == inst {
+			f.instances = append(f.instances[:i], f.instances[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// stopInstances stops all instances, the caller must hold the lock.
+func (f *localFunction) stopInstances() {
+	for _, inst := range f.instances {
+		go inst.stop()
+	}
+	f.instances = nil
+}
+
+// instanceReady is called when the instance becomes ready.
+func (f *localFunction) instanceReady(inst *localInstance) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.hasInstance(inst) {
+		return false
+	}
+
+	inst.ready = true
+	f.everReady = true
+	f.lastError = ""
+	f.startFailures = 0
+	f.nextStart = time.Time{}
+
+	close(f.readyCh)
+	f.readyCh = make(chan struct{})
+	return true
+}
+
+// instanceFailed is called when the instance fails to start or exits.
+func (f *localFunction) instanceFailed(inst *localInstance, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.removeInstance(inst) {
+		// the instance is stopped by the provider.
+		return
+	}
+
+	logger.Errorf("instance of function %s failed: %v", f.spec.Name, err)
+	f.lastError = err.Error()
+	if inst.ready {
+		return
+	}
+
+	f.startFailures++
+	backoff := time.Second << (f.startFailures - 1)
+	if backoff > localMaxStartBackoff || backoff <= 0 {
+		backoff = localMaxStartBackoff
+	}
+	f.nextStart = time.Now().Add(backoff)
+}
+
+func (f *localFunction) hasInstance(inst *localInstance) bool {
+	for _, v := range f.instances {
+		if v == inst {
+			return true
+		}
+	}
+	return false
+}
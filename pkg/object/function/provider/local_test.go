@@ -0,0 +1,365 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/function/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testFunctionEnv  = "EG_FAAS_TEST_FUNCTION"
+	testChildPortEnv = "EG_FAAS_TEST_CHILD_PORT"
+)
+
+// TestMain runs the test binary as a function if the environment variable
+// is set, so that the tests don't depend on external programs.
+func TestMain(m *testing.M) {
+	switch os.Getenv(testFunctionEnv) {
+	case "serve":
+		runTestFunction()
+	case "spawn":
+		// starts a child which serves on another port, like functions
+		// wrapped by shells.
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = []string{testFunctionEnv + "=serve", "PORT=" + os.Getenv(testChildPortEnv)}
+		if err := cmd.Start(); err != nil {
+			os.Exit(1)
+		}
+		runTestFunction()
+	case "fail":
+		fmt.Println("boom")
+		os.Exit(1)
+	default:
+		logger.InitNop()
+		os.Exit(m.Run())
+	}
+}
+
+func runTestFunction() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello from %d", os.Getpid())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/env", func(w http.ResponseWriter, r *http.Request) {
+		dir, _ := os.Getwd()
+		fmt.Fprintln(w, dir)
+		for _, env := range os.Environ() {
+			fmt.Fprintln(w, env)
+		}
+	})
+	mux.HandleFunc("/exit", func(w http.ResponseWriter, r *http.Request) {
+		os.Exit(1)
+	})
+	http.ListenAndServe("127.0.0.1:"+os.Getenv("PORT"), mux)
+}
+
+func newTestLocalProvider(t *testing.T) *localProvider {
+	lp := newLocalProvider("faas", &spec.Local{
+		IdleTimeout:     "1s",
+		StartTimeout:    "10s",
+		AllowedCommands: []string{testExecutable(t)},
+		AllowedImages:   []string{"hello:*"},
+		WorkDir:         t.TempDir(),
+	})
+	if err := lp.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return lp
+}
+
+func testExecutable(t *testing.T) string {
+	file, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newTestFunctionSpec(name, mode string) *spec.Spec {
+	return &spec.Spec{
+		Name:           name,
+		Command:        []string{os.Args[0]},
+		Env:            map[string]string{testFunctionEnv: mode},
+		AutoScaleType:  spec.AutoScaleMetricConcurrency,
+		AutoScaleValue: "1",
+		HealthCheck:    &spec.HealthCheck{Path: "/healthz", Interval: "100ms"},
+	}
+}
+
+func callFunction(lp *localProvider, name, path string) (int, string) {
+	gateway, host := lp.Endpoint(name)
+	req, _ := http.NewRequest(http.MethodGet, gateway+path, nil)
+	req.Host = host
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func readyReplicas(lp *localProvider, name string) int {
+	status, err := lp.GetStatus(name)
+	if err != nil {
+		return -1
+	}
+	n, _ := strconv.Atoi(status.ExtData["readyReplicas"])
+	return n
+}
+
+func TestLocalProviderColdStart(t *testing.T) {
+	assert := assert.New(t)
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	assert.NoError(lp.Create(newTestFunctionSpec("hello", "serve")))
+	assert.Error(lp.Create(newTestFunctionSpec("hello", "serve")))
+
+	// functions scaled to zero are ready.
+	status, err := lp.GetStatus("hello")
+	assert.NoError(err)
+	assert.Equal(spec.ReadyEvent, status.Event)
+	assert.Equal("0", status.ExtData["replicas"])
+	assert.Equal("process", status.ExtData["runtime"])
+
+	// the first request starts an instance.
+	code, body := callFunction(lp, "hello", "/")
+	assert.Equal(http.StatusOK, code)
+	assert.Contains(body, "hello from")
+	assert.Equal(1, readyReplicas(lp, "hello"))
+
+	// and the function is scaled to zero after idle.
+	assert.Eventually(func() bool {
+		status, _ := lp.GetStatus("hello")
+		return status.ExtData["replicas"] == "0"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	code, _ = callFunction(lp, "unknown", "/")
+	assert.Equal(http.StatusNotFound, code)
+
+	assert.NoError(lp.Delete("hello"))
+	_, err = lp.GetStatus("hello")
+	assert.Equal(ErrFunctionNotFound, err)
+	code, _ = callFunction(lp, "hello", "/")
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestLocalProviderEnvironment(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("EG_FAAS_TEST_SECRET", "secret")
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	assert.NoError(lp.Create(newTestFunctionSpec("hello", "serve")))
+	defer lp.Delete("hello")
+
+	// processes run in the working directory, and only get the port, the
+	// environment of the function and the PATH.
+	code, body := callFunction(lp, "hello", "/env")
+	assert.Equal(http.StatusOK, code)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	dir, _ := filepath.EvalSymlinks(lp.spec.WorkDir)
+	assert.Equal(dir, lines[0])
+	f := lp.getFunction("hello")
+	f.mutex.Lock()
+	port := f.instances[0].port
+	f.mutex.Unlock()
+	assert.ElementsMatch([]string{
+		"PATH=" + localProcessPath,
+		testFunctionEnv + "=serve",
+		"PORT=" + strconv.Itoa(port),
+	}, lines[1:])
+}
+
+func TestLocalProviderStopChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+	assert := assert.New(t)
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	port, err := freePort()
+	assert.NoError(err)
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	funcSpec := newTestFunctionSpec("hello", "spawn")
+	funcSpec.Env[testChildPortEnv] = strconv.Itoa(port)
+	assert.NoError(lp.Create(funcSpec))
+
+	code, _ := callFunction(lp, "hello", "/")
+	assert.Equal(http.StatusOK, code)
+	assert.Eventually(func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// the child is stopped with the instance.
+	assert.NoError(lp.Delete("hello"))
+	assert.Eventually(func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestLocalProviderMinReplicas(t *testing.T) {
+	assert := assert.New(t)
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	funcSpec := newTestFunctionSpec("hello", "serve")
+	funcSpec.MinReplica, funcSpec.MaxReplica = 2, 3
+	assert.NoError(lp.Create(funcSpec))
+
+	status, _ := lp.GetStatus("hello")
+	assert.Equal(spec.PendingEvent, status.Event)
+
+	assert.Eventually(func() bool {
+		return readyReplicas(lp, "hello") == 2
+	}, 10*time.Second, 100*time.Millisecond)
+	status, _ = lp.GetStatus("hello")
+	assert.Equal(spec.ReadyEvent, status.Event)
+
+	// exited instances are replaced.
+	callFunction(lp, "hello", "/exit")
+	assert.Eventually(func() bool {
+		return readyReplicas(lp, "hello") == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Eventually(func() bool {
+		return readyReplicas(lp, "hello") == 2
+	}, 10*time.Second, 100*time.Millisecond)
+	status, _ = lp.GetStatus("hello")
+	assert.Equal(spec.ReadyEvent, status.Event)
+}
+
+func TestLocalProviderFailure(t *testing.T) {
+	assert := assert.New(t)
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	funcSpec := newTestFunctionSpec("hello", "fail")
+	funcSpec.MinReplica, funcSpec.MaxReplica = 1, 1
+	assert.NoError(lp.Create(funcSpec))
+
+	assert.Eventually(func() bool {
+		status, _ := lp.GetStatus("hello")
+		return status.Event == spec.ErrorEvent
+	}, 10*time.Second, 100*time.Millisecond)
+	status, _ := lp.GetStatus("hello")
+	assert.Contains(status.ExtData["error"], "boom")
+
+	// the function recovers after it is updated.
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	funcSpec.MinReplica, funcSpec.MaxReplica = 1, 1
+	assert.NoError(lp.Update(funcSpec))
+	assert.Eventually(func() bool {
+		status, _ := lp.GetStatus("hello")
+		return status.Event == spec.ReadyEvent && status.ExtData["readyReplicas"] == "1"
+	}, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestLocalProviderInvalidSpec(t *testing.T) {
+	assert := assert.New(t)
+	lp := newTestLocalProvider(t)
+	defer lp.Close()
+
+	funcSpec := newTestFunctionSpec("hello", "serve")
+	funcSpec.AutoScaleType = spec.AutoScaleMetricCPU
+	assert.Error(lp.Create(funcSpec))
+
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	funcSpec.AutoScaleValue = "0"
+	assert.Error(lp.Create(funcSpec))
+
+	// commands out of the allowed ones are rejected
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	funcSpec.Command = []string{"/bin/sh", "-c", "id"}
+	assert.Error(lp.Create(funcSpec))
+	funcSpec.Command = []string{"../../../../../../../../bin/sh"}
+	assert.Error(lp.Create(funcSpec))
+	funcSpec.Command = []string{"no-such-command-of-easegress"}
+	assert.Error(lp.Create(funcSpec))
+
+	// relative commands are not searched in the PATH of Easegress
+	lp.spec.AllowedCommands = []string{"*", "/*/*", "/*/*/*"}
+	lp.spec.WorkDir = ""
+	funcSpec.Command = []string{"sh"}
+	assert.Error(lp.Create(funcSpec))
+
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	funcSpec.Image = "evil:latest"
+	assert.Error(lp.Create(funcSpec))
+
+	lp.spec.AllowedCommands = nil
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	assert.Error(lp.Create(funcSpec))
+
+	lp.runtime = "no-such-runtime-of-easegress"
+	funcSpec = newTestFunctionSpec("hello", "serve")
+	funcSpec.Image = "hello:latest"
+	assert.Error(lp.Create(funcSpec))
+}
+
+func TestContainerRunner(t *testing.T) {
+	assert := assert.New(t)
+
+	funcSpec := newTestFunctionSpec("hello/world", "serve")
+	funcSpec.Image = "hello:latest"
+	funcSpec.Command = []string{"serve", "--verbose"}
+	funcSpec.LimitCPU = "500m"
+	funcSpec.LimitMemory = "64Mi"
+
+	// any existing program works as the runtime as it is not run.
+	r, err := newContainerRunner(os.Args[0], "faas", &spec.Local{AllowedImages: []string{"hello:*"}}, funcSpec)
+	assert.NoError(err)
+
+	cr := r.(*containerRunner)
+	assert.Equal("easegress-faas-faas-hello-world", cr.namePrefix)
+	assert.Equal(localDefaultContainerPort, cr.containerPort)
+	assert.Equal([]string{
+		"--label", "easegress-faas=faas",
+		"-e", "PORT=8080",
+		"-e", testFunctionEnv + "=serve",
+		"--cpus", "0.5",
+		"--memory", "67108864",
+	}, cr.flags)
+}
@@ -0,0 +1,518 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package provider
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	k8sresource "k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/function/spec"
+)
+
+const (
+	// localDefaultContainerPort is the port functions listen on in
+	// containers, which is the same as Knative.
+	localDefaultContainerPort = 8080
+	localStopTimeout          = 5 * time.Second
+	localReadyProbeInterval   = 100 * time.Millisecond
+	localMaxOutputSize        = 4096
+	// localContainerLabel is the label key of containers, its value is the
+	// name of the controller.
+	localContainerLabel = "easegress-faas"
+
+	localDefaultProbeInterval    = 5 * time.Second
+	localDefaultProbeTimeout     = time.Second
+	localDefaultFailureThreshold = 3
+
+	// localProcessPath is the PATH of processes, which don't inherit the
+	// environment of Easegress.
+	localProcessPath = "/usr/local/bin:/usr/bin:/bin"
+)
+
+type (
+	// runner runs instances of a function.
+	runner interface {
+		kind() string
+		// run starts an instance which listens on the port.
+		run(port int) (*instanceHandle, error)
+	}
+
+	// instanceHandle is the handle of a running instance.
+	instanceHandle struct {
+		stop   func()
+		exited <-chan struct{}
+		// output returns the recent output of the instance.
+		output func() string
+	}
+
+	processRunner struct {
+		path string
+		args []string
+		dir  string
+		env  []string
+	}
+
+	containerRunner struct {
+		runtime       string
+		namePrefix    string
+		image         string
+		command       []string
+		containerPort int
+		flags         []string
+	}
+
+	// localInstance is an instance of a function.
+	localInstance struct {
+		function *localFunction
+		runner   runner
+		proxy    *httputil.ReverseProxy
+		port     int
+		inFlight atomic.Int64
+		// ready is protected by the mutex of the function.
+		ready bool
+
+		mutex   sync.Mutex
+		handle  *instanceHandle
+		stopped bool
+	}
+
+	// tailBuffer keeps the tail of the output of processes.
+	tailBuffer struct {
+		mutex sync.Mutex
+		buf   []byte
+	}
+)
+
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.buf = append(tb.buf, p...)
+	if len(tb.buf) > localMaxOutputSize {
+		tb.buf = tb.buf[len(tb.buf)-localMaxOutputSize:]
+	}
+	return len(p), nil
+}
+
+func (tb *tailBuffer) String() string {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	return string(tb.buf)
+}
+
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for _, k := range sortedKeys(env) {
+		list = append(list, k+"="+env[k])
+	}
+	return list
+}
+
+// allowed returns whether the name matches any of the glob patterns.
+func allowed(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newProcessRunner(localSpec *spec.Local, funcSpec *spec.Spec) (runner, error) {
+	if len(funcSpec.Command) == 0 {
+		return nil, fmt.Errorf("command is required to run function %s as a local process", funcSpec.Name)
+	}
+	if len(localSpec.AllowedCommands) == 0 {
+		return nil, fmt.Errorf("function %s can't run as a local process: no allowed commands", funcSpec.Name)
+	}
+
+	// relative commands are in the working directory, they are never
+	// searched in the PATH of Easegress. The path is cleaned so that it
+	// can't escape from the allowed ones.
+	command := funcSpec.Command[0]
+	if !filepath.IsAbs(command) {
+		if !filepath.IsAbs(localSpec.WorkDir) {
+			return nil, fmt.Errorf("command %s of function %s must be absolute: no working directory", command, funcSpec.Name)
+		}
+		command = filepath.Join(localSpec.WorkDir, command)
+	}
+	command = filepath.Clean(command)
+	if !allowed(localSpec.AllowedCommands, command) {
+		return nil, fmt.Errorf("command %s of function %s is not allowed", command, funcSpec.Name)
+	}
+	// LookPath only checks the file as the command is absolute.
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("command of function %s not found: %v", funcSpec.Name, err)
+	}
+
+	return &processRunner{
+		path: command,
+		args: funcSpec.Command[1:],
+		dir:  localSpec.WorkDir,
+		env:  envList(funcSpec.Env),
+	}, nil
+}
+
+func (pr *processRunner) kind() string {
+	return "process"
+}
+
+func (pr *processRunner) run(port int) (*instanceHandle, error) {
+	cmd := exec.Command(pr.path, pr.args...)
+	cmd.Dir = pr.dir
+	cmd.Env = append([]string{"PATH=" + localProcessPath}, pr.env...)
+	cmd.Env = append(cmd.Env, "PORT="+strconv.Itoa(port))
+	// the process runs in its own process group, so that the processes
+	// it starts are stopped with it.
+	setProcessGroup(cmd)
+
+	output := &tailBuffer{}
+	cmd.Stdout, cmd.Stderr = output, output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	stop := func() {
+		signalProcessGroup(cmd.Process, syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(localStopTimeout):
+		}
+		// kill the rest of the group, which may still be alive after the
+		// process exits.
+		signalProcessGroup(cmd.Process, syscall.SIGKILL)
+	}
+
+	return &instanceHandle{stop: stop, exited: exited, output: output.String}, nil
+}
+
+// containerName returns a valid container name by replacing invalid
+// characters.
+func containerName(parts ...string) string {
+	name := []byte(strings.Join(parts, "-"))
+	for i, c := range name {
+		valid := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+		if !valid {
+			name[i] = '-'
+		}
+	}
+	return string(name)
+}
+
+func newContainerRunner(runtime, controllerName string, localSpec *spec.Local, funcSpec *spec.Spec) (runner, error) {
+	if !allowed(localSpec.AllowedImages, funcSpec.Image) {
+		return nil, fmt.Errorf("image %s of function %s is not allowed", funcSpec.Image, funcSpec.Name)
+	}
+	if _, err := exec.LookPath(runtime); err != nil {
+		return nil, fmt.Errorf("container runtime %s not found: %v", runtime, err)
+	}
+
+	cr := &containerRunner{
+		runtime:       runtime,
+		namePrefix:    containerName("easegress-faas", controllerName, funcSpec.Name),
+		image:         funcSpec.Image,
+		command:       funcSpec.Command,
+		containerPort: funcSpec.Port,
+	}
+	if cr.containerPort == 0 {
+		cr.containerPort = localDefaultContainerPort
+	}
+
+	cr.flags = append(cr.flags, "--label", containerLabel(controllerName))
+	cr.flags = append(cr.flags, "-e", "PORT="+strconv.Itoa(cr.containerPort))
+	for _, env := range envList(funcSpec.Env) {
+		cr.flags = append(cr.flags, "-e", env)
+	}
+	if funcSpec.LimitCPU != "" {
+		q := k8sresource.MustParse(funcSpec.LimitCPU)
+		cr.flags = append(cr.flags, "--cpus", strconv.FormatFloat(q.AsApproximateFloat64(), 'f', -1, 64))
+	}
+	if funcSpec.LimitMemory != "" {
+		q := k8sresource.MustParse(funcSpec.LimitMemory)
+		cr.flags = append(cr.flags, "--memory", strconv.FormatInt(q.Value(), 10))
+	}
+
+	return cr, nil
+}
+
+func (cr *containerRunner) kind() string {
+	return "container"
+}
+
+func (cr *containerRunner) run(port int) (*instanceHandle, error) {
+	args := []string{
+		"run", "-d", "--rm",
+		"--name", cr.namePrefix + "-" + strconv.Itoa(port),
+		"-p", fmt.Sprintf("127.0.0.1:%d:%d", port, cr.containerPort),
+	}
+	args = append(args, cr.flags...)
+	args = append(args, cr.image)
+	args = append(args, cr.command...)
+
+	out, err := exec.Command(cr.runtime, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("run container failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// the container ID is the last line of the output.
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	id := strings.TrimSpace(lines[len(lines)-1])
+
+	exited := make(chan struct{})
+	go func() {
+		exec.Command(cr.runtime, "wait", id).Run()
+		close(exited)
+	}()
+
+	stop := func() {
+		exec.Command(cr.runtime, "rm", "-f", id).Run()
+	}
+	output := func() string {
+		out, _ := exec.Command(cr.runtime, "logs", "--tail", "20", id).CombinedOutput()
+		return string(out)
+	}
+
+	return &instanceHandle{stop: stop, exited: exited, output: output}, nil
+}
+
+// containerLabel returns the label of the containers of the provider.
+func containerLabel(controllerName string) string {
+	return localContainerLabel + "=" + controllerName
+}
+
+// removeContainers removes the containers left by the provider, e.g. when
+// Easegress crashed before stopping them.
+func removeContainers(runtime, controllerName string) {
+	if _, err := exec.LookPath(runtime); err != nil {
+		return
+	}
+
+	out, err := exec.Command(runtime, "ps", "-aq", "--filter", "label="+containerLabel(controllerName)).Output()
+	if err != nil {
+		logger.Errorf("list containers of faas provider %s failed: %v", controllerName, err)
+		return
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return
+	}
+
+	logger.Infof("remove %d containers left by faas provider %s", len(ids), controllerName)
+	args := append([]string{"rm", "-f"}, ids...)
+	if out, err := exec.Command(runtime, args...).CombinedOutput(); err != nil {
+		logger.Errorf("remove containers of faas provider %s failed: %v: %s", controllerName, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// freePort returns a free TCP port of the loopback interface.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (inst *localInstance) start(timeout time.Duration) {
+	f := inst.function
+
+	port, err := freePort()
+	if err != nil {
+		f.instanceFailed(inst, err)
+		return
+	}
+
+	handle, err := inst.runner.run(port)
+	if err != nil {
+		f.instanceFailed(inst, err)
+		return
+	}
+
+	inst.mutex.Lock()
+	inst.handle = handle
+	stopped := inst.stopped
+	inst.mutex.Unlock()
+	if stopped {
+		handle.stop()
+		return
+	}
+
+	inst.port = port
+	inst.proxy = httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+	})
+	inst.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Warnf("proxy request to function instance on port %d failed: %v", port, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	if err = inst.waitReady(timeout); err != nil {
+		f.instanceFailed(inst, err)
+		inst.stop()
+		return
+	}
+
+	if !f.instanceReady(inst) {
+		inst.stop()
+		return
+	}
+	inst.watch()
+}
+
+// probe probes the instance by the health check of the function.
+func (inst *localInstance) probe(hc *spec.HealthCheck, timeout time.Duration) error {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(inst.port))
+	if hc == nil || hc.Path == "" {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + addr + hc.Path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("health check returns status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (inst *localInstance) probeSpec() (*spec.HealthCheck, time.Duration, time.Duration, int) {
+	inst.function.mutex.Lock()
+	hc := inst.function.spec.HealthCheck
+	inst.function.mutex.Unlock()
+
+	interval, timeout, threshold := localDefaultProbeInterval, localDefaultProbeTimeout, localDefaultFailureThreshold
+	if hc != nil {
+		// the durations are validated by the spec.
+		interval, _ = parseDuration(hc.Interval, interval)
+		timeout, _ = parseDuration(hc.Timeout, timeout)
+		if hc.FailureThreshold > 0 {
+			threshold = hc.FailureThreshold
+		}
+	}
+	return hc, interval, timeout, threshold
+}
+
+// waitReady waits until the instance passes the health check.
+func (inst *localInstance) waitReady(timeout time.Duration) error {
+	hc, _, probeTimeout, _ := inst.probeSpec()
+	deadline := time.After(timeout)
+
+	for {
+		err := inst.probe(hc, probeTimeout)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-inst.handle.exited:
+			return fmt.Errorf("instance exited before ready: %s", strings.TrimSpace(inst.handle.output()))
+		case <-deadline:
+			return fmt.Errorf("instance is not ready in %v: %v", timeout, err)
+		case <-time.After(localReadyProbeInterval):
+		}
+	}
+}
+
+// watch watches the instance until it exits or fails the health check.
+func (inst *localInstance) watch() {
+	hc, interval, timeout, threshold := inst.probeSpec()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-inst.handle.exited:
+			if !inst.isStopped() {
+				inst.function.instanceFailed(inst, fmt.Errorf("instance exited: %s", strings.TrimSpace(inst.handle.output())))
+				inst.stop()
+			}
+			return
+		case <-ticker.C:
+		}
+
+		if inst.isStopped() {
+			return
+		}
+
+		err := inst.probe(hc, timeout)
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures >= threshold {
+			inst.function.instanceFailed(inst, fmt.Errorf("health check failed: %v", err))
+			inst.stop()
+			return
+		}
+	}
+}
+
+func (inst *localInstance) isStopped() bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return inst.stopped
+}
+
+// stop stops the instance.
+func (inst *localInstance) stop() {
+	inst.mutex.Lock()
+	if inst.stopped {
+		inst.mutex.Unlock()
+		return
+	}
+	inst.stopped = true
+	handle := inst.handle
+	inst.mutex.Unlock()
+
+	if handle != nil {
+		handle.stop()
+	}
+}
@@ -21,6 +21,7 @@ package provider
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -45,18 +46,35 @@ type (
 		Create(funcSpec *spec.Spec) error
 		Delete(name string) error
 		Update(funcSpec *spec.Spec) error
+		// Endpoint returns the URL of the network layer of the provider,
+		// and the host by which the network layer recognizes the function.
+		Endpoint(name string) (url string, host string)
+		Close()
 	}
 
 	// knativeClient is client for communicating with Knative type FaaSProvider
 	knativeClient struct {
 		superSpec     *supervisor.Spec
+		spec          *spec.Knative
 		serviceClient clientservingv1.KnServingClient
 		namespace     string
 		timeout       time.Duration
 	}
 )
 
+const (
+	knativeDefaultNamespace = "default"
+	knativeDefaultTimeout   = 2 * time.Second
+)
+
+// ErrFunctionNotFound is the error returned by FaaSProvider when the
+// function is not provisioned.
+var ErrFunctionNotFound = fmt.Errorf("function not found")
+
 func (kc *knativeClient) Create(funcSpec *spec.Spec) error {
+	if funcSpec.Image == "" {
+		return fmt.Errorf("image is required by knative FaaS provider")
+	}
 	return kc.createService(funcSpec)
 }
 
@@ -108,10 +126,24 @@ func (kc *knativeClient) Delete(name string) error {
 	return kc.deleteService(name)
 }
 
-// NewProvider returns FaaSProvider client. It only supports Knative now.
+func (kc *knativeClient) Endpoint(name string) (string, string) {
+	// let Knative's gateway recognize the function by Host field
+	return kc.spec.NetworkLayerURL, name + "." + kc.namespace + "." + kc.spec.HostSuffix
+}
+
+func (kc *knativeClient) Close() {
+}
+
+// NewProvider returns FaaSProvider client according to the spec.
 func NewProvider(superSpec *supervisor.Spec) FaaSProvider {
+	admin := superSpec.ObjectSpec().(*spec.Admin)
+	if admin.Provider == spec.ProviderLocal {
+		return newLocalProvider(superSpec.Name(), admin.Local)
+	}
+
 	return &knativeClient{
 		superSpec: superSpec,
+		spec:      admin.Knative,
 	}
 }
 
@@ -120,20 +152,25 @@ func (kc *knativeClient) Init() error {
 	var err error
 	param := &commands.KnParams{}
 	param.Initialize()
-	spec := kc.superSpec.ObjectSpec().(*spec.Admin)
 
-	kc.namespace = spec.Knative.Namespace
+	kc.namespace = kc.spec.Namespace
+	if kc.namespace == "" {
+		kc.namespace = knativeDefaultNamespace
+	}
 	kc.serviceClient, err = param.NewServingClient(kc.namespace)
 	if err != nil {
 		logger.Errorf("knative new serving client failed: %v", err)
 		return err
 	}
 
-	kc.timeout, err = time.ParseDuration(spec.Knative.Timeout)
-	if err != nil {
-		logger.Errorf("BUG: parse knative timeout interval: %s failed: %v",
-			spec.Knative.Timeout, err)
-		return err
+	kc.timeout = knativeDefaultTimeout
+	if kc.spec.Timeout != "" {
+		kc.timeout, err = time.ParseDuration(kc.spec.Timeout)
+		if err != nil {
+			logger.Errorf("BUG: parse knative timeout interval: %s failed: %v",
+				kc.spec.Timeout, err)
+			return err
+		}
 	}
 	return nil
 }
@@ -176,21 +213,39 @@ func container(funcSpec *spec.Spec) corev1.Container {
 			ContainerPort: int32(funcSpec.Port),
 		}}
 	}
+
+	container.Command = funcSpec.Command
+	for _, k := range sortedKeys(funcSpec.Env) {
+		container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: funcSpec.Env[k]})
+	}
 	return container
 }
 
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // requirement builds requirement according to resource's limitation and requested
 func requirement(funcSpec *spec.Spec) corev1.ResourceRequirements {
 	rr := corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			corev1.ResourceMemory: k8sresource.MustParse(funcSpec.LimitMemory),
-			corev1.ResourceCPU:    k8sresource.MustParse(funcSpec.LimitCPU),
-		},
-		Requests: corev1.ResourceList{
-			corev1.ResourceCPU:    k8sresource.MustParse(funcSpec.RequestCPU),
-			corev1.ResourceMemory: k8sresource.MustParse(funcSpec.RequestMemory),
-		},
+		Limits:   corev1.ResourceList{},
+		Requests: corev1.ResourceList{},
+	}
+
+	set := func(list corev1.ResourceList, name corev1.ResourceName, value string) {
+		if value != "" {
+			list[name] = k8sresource.MustParse(value)
+		}
 	}
+	set(rr.Limits, corev1.ResourceMemory, funcSpec.LimitMemory)
+	set(rr.Limits, corev1.ResourceCPU, funcSpec.LimitCPU)
+	set(rr.Requests, corev1.ResourceCPU, funcSpec.RequestCPU)
+	set(rr.Requests, corev1.ResourceMemory, funcSpec.RequestMemory)
 	return rr
 }
 
@@ -19,6 +19,8 @@ package spec
 
 import (
 	"fmt"
+	"path"
+
 	"github.com/megaease/easegress/pkg/filters/builder"
 	k8sresource "k8s.io/apimachinery/pkg/api/resource"
 
@@ -35,6 +37,9 @@ const (
 
 	// ProviderKnative is the FaaS provider Knative.
 	ProviderKnative = "knative"
+	// ProviderLocal is the FaaS provider which runs functions as local
+	// processes or OCI containers on the Easegress host.
+	ProviderLocal = "local"
 )
 
 type (
@@ -49,9 +54,11 @@ type (
 		// HTTPServer is the HTTP traffic gate for accepting ingress traffic.
 		HTTPServer *httpserver.Spec `json:"httpServer" jsonschema:"required"`
 
-		// Currently we only supports knative type faas provider, so this filed should be
-		// "required" right now.
-		Knative *Knative `json:"knative" jsonschema:"required"`
+		// Knative is required by the knative type faas provider.
+		Knative *Knative `json:"knative" jsonschema:"omitempty"`
+
+		// Local is the spec of the local type faas provider.
+		Local *Local `json:"local" jsonschema:"omitempty"`
 	}
 
 	// Function contains the FaaSFunction's spec ,runtime status with a build-in fsm.
@@ -64,7 +71,7 @@ type (
 	// Spec is the spec of FaaSFunction.
 	Spec struct {
 		Name           string `json:"name" jsonschema:"required"`
-		Image          string `json:"image" jsonschema:"required"`
+		Image          string `json:"image" jsonschema:"omitempty"`
 		Port           int    `json:"port" jsonschema:"omitempty"`
 		AutoScaleType  string `json:"autoScaleType" jsonschema:"required"`
 		AutoScaleValue string `json:"autoScaleValue" jsonschema:"required"`
@@ -75,9 +82,29 @@ type (
 		RequestCPU     string `json:"requestCPU" jsonschema:"omitempty"`
 		RequestMemory  string `json:"requestMemory" jsonschema:"omitempty"`
 
+		// Command is the command to override the entrypoint of the image,
+		// the local type faas provider runs the function as a local
+		// process with it if the image is empty.
+		Command []string `json:"command" jsonschema:"omitempty"`
+		// Env is the environment variables of the function.
+		Env map[string]string `json:"env" jsonschema:"omitempty"`
+		// HealthCheck is the health probe of the function instances, only
+		// supported by the local type faas provider.
+		HealthCheck *HealthCheck `json:"healthCheck" jsonschema:"omitempty"`
+
 		RequestAdaptor *builder.RequestAdaptorSpec `json:"requestAdaptor" jsonschema:"required"`
 	}
 
+	// HealthCheck is the health probe of function instances.
+	HealthCheck struct {
+		// Path is the HTTP path to probe, instances are probed by TCP
+		// connections if it is empty.
+		Path             string `json:"path" jsonschema:"omitempty"`
+		Interval         string `json:"interval" jsonschema:"omitempty,format=duration"`
+		Timeout          string `json:"timeout" jsonschema:"omitempty,format=duration"`
+		FailureThreshold int    `json:"failureThreshold" jsonschema:"omitempty,minimum=1"`
+	}
+
 	// Status is the status of faas function.
 	Status struct {
 		Name    string            `json:"name" jsonschema:"required"`
@@ -94,8 +121,68 @@ type (
 		Namespace string `json:"namespace" jsonschema:"omitempty"`
 		Timeout   string `json:"timeout" jsonschema:"omitempty,format=duration"`
 	}
+
+	// Local is the faas provider which runs functions as local processes
+	// or OCI containers.
+	Local struct {
+		// ContainerRuntime is the CLI to run OCI images, e.g. docker, podman.
+		ContainerRuntime string `json:"containerRuntime" jsonschema:"omitempty"`
+		// IdleTimeout is the duration without requests before scaling a
+		// function to zero.
+		IdleTimeout string `json:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		// StartTimeout is the timeout for an instance to become ready,
+		// requests wait for at most this duration on cold starts.
+		StartTimeout string `json:"startTimeout" jsonschema:"omitempty,format=duration"`
+		// AllowedCommands are glob patterns of the absolute paths of the
+		// executables functions may run, e.g. /opt/functions/*, functions
+		// can't run as processes if it's empty.
+		AllowedCommands []string `json:"allowedCommands" jsonschema:"omitempty"`
+		// AllowedImages are glob patterns of the images functions may run,
+		// e.g. registry.example.com/functions/*, functions can't run as
+		// containers if it's empty.
+		AllowedImages []string `json:"allowedImages" jsonschema:"omitempty"`
+		// WorkDir is the working directory of processes, and relative
+		// commands are in it. It's required if AllowedCommands is set.
+		WorkDir string `json:"workDir" jsonschema:"omitempty"`
+	}
 )
 
+// Validate validates the spec of FaaSController.
+func (admin *Admin) Validate() error {
+	switch admin.Provider {
+	case ProviderKnative:
+		if admin.Knative == nil {
+			return fmt.Errorf("knative is required by FaaS provider: %s", admin.Provider)
+		}
+	case ProviderLocal:
+		// the local provider works with the default spec.
+	default:
+		return fmt.Errorf("unknown FaaS provider: %s", admin.Provider)
+	}
+	return nil
+}
+
+// Validate validates the spec of the local FaaS provider.
+func (local *Local) Validate() error {
+	for _, p := range local.AllowedCommands {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("allowed command %s is not an absolute path", p)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid allowed command %s: %v", p, err)
+		}
+	}
+	for _, p := range local.AllowedImages {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid allowed image %s: %v", p, err)
+		}
+	}
+	if len(local.AllowedCommands) > 0 && !path.IsAbs(local.WorkDir) {
+		return fmt.Errorf("workDir must be an absolute path if allowedCommands is set")
+	}
+	return nil
+}
+
 // Validate valid FaaSFunction's spec.
 func (spec *Spec) Validate() error {
 	if spec.MinReplica > spec.MaxReplica {
@@ -104,11 +191,15 @@ func (spec *Spec) Validate() error {
 
 	switch spec.AutoScaleType {
 	case AutoScaleMetricCPU, AutoScaleMetricConcurrency, AutoScaleMetricRPS:
-		//
+		// the local provider works with the default spec.
 	default:
 		return fmt.Errorf("unknown autoscale type: %s", spec.AutoScaleType)
 	}
 
+	if spec.Image == "" && len(spec.Command) == 0 {
+		return fmt.Errorf("one of image and command must be specified")
+	}
+
 	checkK8s := func() (errMsg error) {
 		defer func() {
 			if err := recover(); err != nil {
@@ -117,10 +208,11 @@ func (spec *Spec) Validate() error {
 		}()
 
 		// check if k8s resource valid or note
-		k8sresource.MustParse(spec.LimitMemory)
-		k8sresource.MustParse(spec.LimitCPU)
-		k8sresource.MustParse(spec.RequestCPU)
-		k8sresource.MustParse(spec.RequestMemory)
+		for _, v := range []string{spec.LimitMemory, spec.LimitCPU, spec.RequestCPU, spec.RequestMemory} {
+			if v != "" {
+				k8sresource.MustParse(v)
+			}
+		}
 		return
 	}
 
@@ -129,6 +129,75 @@ func TestInValidSpecAutoScaleType(t *testing.T) {
 	fmt.Println("err is ", err)
 }
 
+func TestLocalSpec(t *testing.T) {
+	spec := Spec{
+		Name:           "demo",
+		Command:        []string{"./hello", "--verbose"},
+		AutoScaleType:  AutoScaleMetricConcurrency,
+		AutoScaleValue: "10",
+		MaxReplica:     2,
+	}
+
+	if err := spec.Validate(); err != nil {
+		t.Errorf("test failed spec should be valid, err: %v", err)
+	}
+
+	spec.Command = nil
+	if err := spec.Validate(); err == nil {
+		t.Errorf("test failed spec without image and command should not be valid")
+	}
+}
+
+func TestAdminValidate(t *testing.T) {
+	admin := &Admin{Provider: ProviderKnative}
+	if err := admin.Validate(); err == nil {
+		t.Errorf("test failed knative provider without knative spec should not be valid")
+	}
+
+	admin.Knative = &Knative{}
+	if err := admin.Validate(); err != nil {
+		t.Errorf("test failed admin should be valid, err: %v", err)
+	}
+
+	admin = &Admin{Provider: ProviderLocal}
+	if err := admin.Validate(); err != nil {
+		t.Errorf("test failed admin should be valid, err: %v", err)
+	}
+
+	admin.Provider = "unknown"
+	if err := admin.Validate(); err == nil {
+		t.Errorf("test failed unknown provider should not be valid")
+	}
+}
+
+func TestLocalValidate(t *testing.T) {
+	local := &Local{
+		AllowedCommands: []string{"/opt/functions/*"},
+		AllowedImages:   []string{"registry.example.com/functions/*"},
+		WorkDir:         "/var/lib/functions",
+	}
+	if err := local.Validate(); err != nil {
+		t.Errorf("test failed local should be valid, err: %v", err)
+	}
+
+	local.WorkDir = ""
+	if err := local.Validate(); err == nil {
+		t.Errorf("test failed local without work dir should not be valid")
+	}
+
+	local.WorkDir = "/var/lib/functions"
+	local.AllowedCommands = []string{"functions/*"}
+	if err := local.Validate(); err == nil {
+		t.Errorf("test failed relative allowed command should not be valid")
+	}
+
+	local.AllowedCommands = nil
+	local.AllowedImages = []string{"[invalid"}
+	if err := local.Validate(); err == nil {
+		t.Errorf("test failed invalid allowed image should not be valid")
+	}
+}
+
 func TestNext(t *testing.T) {
 	fsm, _ := InitFSM(InitState())
 
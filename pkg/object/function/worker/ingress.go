@@ -24,6 +24,7 @@ import (
 	"github.com/megaease/easegress/pkg/filters/builder"
 	proxy "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/function/provider"
 	"github.com/megaease/easegress/pkg/object/function/spec"
 	"github.com/megaease/easegress/pkg/object/httpserver"
 	"github.com/megaease/easegress/pkg/object/httpserver/routers"
@@ -39,10 +40,7 @@ type (
 	// ingressServer manages one/many ingress pipelines and one HTTPServer
 	ingressServer struct {
 		superSpec *supervisor.Spec
-
-		faasNetworkLayerURL string
-		faasHostSuffix      string
-		faasNamespace       string
+		provider  provider.FaaSProvider
 
 		namespace string
 		mutex     sync.RWMutex
@@ -67,7 +65,7 @@ type (
 )
 
 // newIngressServer creates an initialized ingress server
-func newIngressServer(superSpec *supervisor.Spec, controllerName string) *ingressServer {
+func newIngressServer(superSpec *supervisor.Spec, controllerName string, faasProvider provider.FaaSProvider) *ingressServer {
 	entity, exists := superSpec.Super().GetSystemController(trafficcontroller.Kind)
 
 	if !exists {
@@ -82,6 +80,7 @@ func newIngressServer(superSpec *supervisor.Spec, controllerName string) *ingres
 		pipelines:  make(map[string]struct{}),
 		httpServer: nil,
 		superSpec:  superSpec,
+		provider:   faasProvider,
 		mutex:      sync.RWMutex{},
 		namespace:  fmt.Sprintf("%s/%s", superSpec.Name(), "ingress"),
 		tc:         tc,
@@ -132,7 +131,7 @@ func (b *pipelineSpecBuilder) jsonConfig() string {
 	return string(buff)
 }
 
-func (b *pipelineSpecBuilder) appendReqAdaptor(funcSpec *spec.Spec, faasNamespace, faasHostSuffix string) *pipelineSpecBuilder {
+func (b *pipelineSpecBuilder) appendReqAdaptor(funcSpec *spec.Spec, host string) *pipelineSpecBuilder {
 	adaptorName := "requestAdaptor"
 	b.Flow = append(b.Flow, pipeline.FlowNode{FilterName: adaptorName})
 
@@ -144,7 +143,7 @@ func (b *pipelineSpecBuilder) appendReqAdaptor(funcSpec *spec.Spec, faasNamespac
 		"header": funcSpec.RequestAdaptor.Header,
 
 		// let faas Provider's gateway recognized this function by Host field
-		"host": funcSpec.Name + "." + faasNamespace + "." + faasHostSuffix,
+		"host": host,
 	})
 
 	return b
@@ -187,10 +186,6 @@ func (ings *ingressServer) Init() error {
 	}
 	spec := ings.superSpec.ObjectSpec().(*spec.Admin)
 
-	ings.faasNetworkLayerURL = spec.Knative.NetworkLayerURL
-	ings.faasHostSuffix = spec.Knative.HostSuffix
-	ings.faasNamespace = spec.Knative.Namespace
-
 	builder := newHTTPServerSpecBuilder(ings.superSpec.Name())
 	builder.buildWithOutRules(spec.HTTPServer)
 	superSpec, err := supervisor.NewSpec(builder.jsonConfig())
@@ -279,8 +274,9 @@ func (ings *ingressServer) remove(pipeline string) error {
 // Put puts pipeline named by faas function's name with a requestAdaptor and proxy
 func (ings *ingressServer) Put(funcSpec *spec.Spec) error {
 	builder := newPipelineSpecBuilder(funcSpec.Name)
-	builder.appendReqAdaptor(funcSpec, ings.faasNamespace, ings.faasHostSuffix)
-	builder.appendProxy(ings.faasNetworkLayerURL)
+	url, host := ings.provider.Endpoint(funcSpec.Name)
+	builder.appendReqAdaptor(funcSpec, host)
+	builder.appendProxy(url)
 
 	jsonConfig := builder.jsonConfig()
 	superSpec, err := supervisor.NewSpec(jsonConfig)
@@ -55,7 +55,7 @@ type (
 func NewWorker(superSpec *supervisor.Spec) *Worker {
 	store := storage.NewStorage(superSpec.Name(), superSpec.Super().Cluster())
 	faasProvider := provider.NewProvider(superSpec)
-	ingress := newIngressServer(superSpec, superSpec.Name())
+	ingress := newIngressServer(superSpec, superSpec.Name(), faasProvider)
 	adm := superSpec.ObjectSpec().(*spec.Admin)
 
 	w := &Worker{
@@ -123,6 +123,14 @@ func (worker *Worker) updateStatus() {
 
 		// get function provision status inside faas provider
 		providerStatus, err := worker.provider.GetStatus(function.Spec.Name)
+		if err == provider.ErrFunctionNotFound {
+			// the local FaaSProvider loses its functions when Easegress
+			// restarts, so provision them again.
+			if err = worker.provider.Create(function.Spec); err != nil {
+				logger.Errorf("provision function: %s again failed: %v", function.Spec.Name, err)
+			}
+			continue
+		}
 		if err != nil {
 			continue
 		}
@@ -191,4 +199,5 @@ func (worker *Worker) Close() {
 
 	close(worker.done)
 	worker.ingress.Close()
+	worker.provider.Close()
 }
@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gatewaycontroller
+
+import (
+	stdcontext "context"
+	"fmt"
+	"time"
+
+	apicorev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	gwapisv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gwapisv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+	gwclientset "sigs.k8s.io/gateway-api/pkg/client/clientset/versioned"
+	gwinformers "sigs.k8s.io/gateway-api/pkg/client/informers/externalversions"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	resyncPeriod = 10 * time.Minute
+)
+
+type k8sClient struct {
+	namespaces []string
+	kubeClient kubernetes.Interface
+	gwClient   gwclientset.Interface
+
+	// clusterFactory and clusterGWFactory are for cluster scoped
+	// resources, the others are for namespaced resources, keyed by
+	// namespace, the key is metav1.NamespaceAll if all namespaces are
+	// watched.
+	clusterFactory   informers.SharedInformerFactory
+	clusterGWFactory gwinformers.SharedInformerFactory
+	factories        map[string]informers.SharedInformerFactory
+	gwFactories      map[string]gwinformers.SharedInformerFactory
+
+	eventCh chan interface{}
+}
+
+func isResourceChanged(oldObj, newObj interface{}) bool {
+	if oldObj == nil || newObj == nil {
+		return true
+	}
+
+	o, n := oldObj.(metav1.Object), newObj.(metav1.Object)
+	if o.GetResourceVersion() == n.GetResourceVersion() {
+		return false
+	}
+
+	// status updates of gateway resources are written by the controller
+	// itself, ignore them to avoid translating again and again.
+	switch oldObj.(type) {
+	case *gwapisv1beta1.GatewayClass, *gwapisv1beta1.Gateway,
+		*gwapisv1beta1.HTTPRoute, *gwapisv1alpha2.GRPCRoute:
+		return o.GetGeneration() != n.GetGeneration() ||
+			!labels.Equals(o.GetLabels(), n.GetLabels())
+	}
+
+	return true
+}
+
+// OnAdd is called on Resource Add Events.
+func (c *k8sClient) OnAdd(obj interface{}) {
+	// if there's an event already in the channel, discard this one,
+	// this is fine because GatewayController always reload everything
+	// when receiving an event. Same for OnUpdate & OnDelete
+	select {
+	case c.eventCh <- obj:
+	default:
+	}
+}
+
+// OnUpdate is called on Resource Update Events.
+func (c *k8sClient) OnUpdate(oldObj, newObj interface{}) {
+	if !isResourceChanged(oldObj, newObj) {
+		return
+	}
+
+	select {
+	case c.eventCh <- newObj:
+	default:
+	}
+}
+
+// OnDelete is called on Resource Delete Events.
+func (c *k8sClient) OnDelete(obj interface{}) {
+	select {
+	case c.eventCh <- obj:
+	default:
+	}
+}
+
+func newK8sClient(masterURL string, kubeConfig string) (*k8sClient, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
+	if err != nil {
+		logger.Errorf("error building kubeconfig: %s", err.Error())
+		return nil, err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		logger.Errorf("error building kubernetes clientset: %s", err.Error())
+		return nil, err
+	}
+
+	gwClient, err := gwclientset.NewForConfig(cfg)
+	if err != nil {
+		logger.Errorf("error building gateway api clientset: %s", err.Error())
+		return nil, err
+	}
+
+	return newK8sClientForClientsets(kubeClient, gwClient), nil
+}
+
+func newK8sClientForClientsets(kubeClient kubernetes.Interface, gwClient gwclientset.Interface) *k8sClient {
+	return &k8sClient{
+		kubeClient: kubeClient,
+		gwClient:   gwClient,
+		eventCh:    make(chan interface{}, 1),
+	}
+}
+
+func (c *k8sClient) event() <-chan interface{} {
+	return c.eventCh
+}
+
+func (c *k8sClient) watch(namespaces []string) (chan struct{}, error) {
+	stopCh := make(chan struct{})
+
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	c.namespaces = namespaces
+
+	notHelm := func(opts *metav1.ListOptions) {
+		opts.LabelSelector = "owner!=helm"
+	}
+
+	var watched []cache.SharedIndexInformer
+
+	c.clusterFactory = informers.NewSharedInformerFactory(c.kubeClient, resyncPeriod)
+	watched = append(watched, c.clusterFactory.Core().V1().Namespaces().Informer())
+
+	c.clusterGWFactory = gwinformers.NewSharedInformerFactory(c.gwClient, resyncPeriod)
+	watched = append(watched, c.clusterGWFactory.Gateway().V1beta1().GatewayClasses().Informer())
+
+	c.factories = map[string]informers.SharedInformerFactory{}
+	c.gwFactories = map[string]gwinformers.SharedInformerFactory{}
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, resyncPeriod, informers.WithNamespace(ns))
+		watched = append(watched,
+			factory.Core().V1().Services().Informer(),
+			factory.Core().V1().Endpoints().Informer(),
+		)
+		// secrets are filtered, so they need a separate factory
+		secretFactory := informers.NewSharedInformerFactoryWithOptions(c.kubeClient, resyncPeriod,
+			informers.WithNamespace(ns), informers.WithTweakListOptions(notHelm))
+		watched = append(watched, secretFactory.Core().V1().Secrets().Informer())
+		c.factories[ns] = factory
+		c.factories[secretKey(ns)] = secretFactory
+
+		gwFactory := gwinformers.NewSharedInformerFactoryWithOptions(c.gwClient, resyncPeriod, gwinformers.WithNamespace(ns))
+		watched = append(watched,
+			gwFactory.Gateway().V1beta1().Gateways().Informer(),
+			gwFactory.Gateway().V1beta1().HTTPRoutes().Informer(),
+			gwFactory.Gateway().V1beta1().ReferenceGrants().Informer(),
+			gwFactory.Gateway().V1alpha2().GRPCRoutes().Informer(),
+		)
+		c.gwFactories[ns] = gwFactory
+	}
+
+	for _, informer := range watched {
+		informer.AddEventHandler(c)
+	}
+
+	c.clusterFactory.Start(stopCh)
+	c.clusterGWFactory.Start(stopCh)
+	for _, f := range c.factories {
+		f.Start(stopCh)
+	}
+	for _, f := range c.gwFactories {
+		f.Start(stopCh)
+	}
+
+	for _, informer := range watched {
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			close(stopCh)
+			return nil, fmt.Errorf("timed out waiting for controller caches to sync")
+		}
+	}
+
+	return stopCh, nil
+}
+
+// secretKey returns the key of the secret informer factory of namespace ns.
+func secretKey(ns string) string {
+	return "secret:" + ns
+}
+
+// factoryKey returns the key of the informer factory of namespace ns, it
+// returns false if the namespace is not watched.
+func (c *k8sClient) factoryKey(ns string) (string, bool) {
+	if len(c.namespaces) == 1 && c.namespaces[0] == metav1.NamespaceAll {
+		return metav1.NamespaceAll, true
+	}
+	for _, n := range c.namespaces {
+		if n == ns {
+			return ns, true
+		}
+	}
+	return "", false
+}
+
+func (c *k8sClient) getNamespace(name string) (*apicorev1.Namespace, error) {
+	ns, err := c.clusterFactory.Core().V1().Namespaces().Lister().Get(name)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return ns, err
+}
+
+func (c *k8sClient) getService(namespace, name string) (*apicorev1.Service, error) {
+	key, ok := c.factoryKey(namespace)
+	if !ok {
+		return nil, nil
+	}
+	service, err := c.factories[key].Core().V1().Services().Lister().Services(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return service, err
+}
+
+func (c *k8sClient) getEndpoints(namespace, name string) (*apicorev1.Endpoints, error) {
+	key, ok := c.factoryKey(namespace)
+	if !ok {
+		return nil, nil
+	}
+	endpoints, err := c.factories[key].Core().V1().Endpoints().Lister().Endpoints(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return endpoints, err
+}
+
+func (c *k8sClient) getSecret(namespace, name string) (*apicorev1.Secret, error) {
+	key, ok := c.factoryKey(namespace)
+	if !ok {
+		return nil, nil
+	}
+	secret, err := c.factories[secretKey(key)].Core().V1().Secrets().Lister().Secrets(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return secret, err
+}
+
+func (c *k8sClient) getGateway(namespace, name string) (*gwapisv1beta1.Gateway, error) {
+	key, ok := c.factoryKey(namespace)
+	if !ok {
+		return nil, nil
+	}
+	gateway, err := c.gwFactories[key].Gateway().V1beta1().Gateways().Lister().Gateways(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+	return gateway, err
+}
+
+func (c *k8sClient) getGatewayClasses(controllerName string) []*gwapisv1beta1.GatewayClass {
+	var result []*gwapisv1beta1.GatewayClass
+
+	list, err := c.clusterGWFactory.Gateway().V1beta1().GatewayClasses().Lister().List(labels.Everything())
+	if err != nil {
+		logger.Errorf("failed to get gateway classes: %v", err)
+		return nil
+	}
+
+	for _, gc := range list {
+		if string(gc.Spec.ControllerName) == controllerName {
+			result = append(result, gc)
+		}
+	}
+
+	return result
+}
+
+func (c *k8sClient) getGateways() []*gwapisv1beta1.Gateway {
+	var result []*gwapisv1beta1.Gateway
+	for ns, f := range c.gwFactories {
+		list, err := f.Gateway().V1beta1().Gateways().Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorf("failed to get gateways from namespace %s: %v", ns, err)
+			continue
+		}
+		result = append(result, list...)
+	}
+	return result
+}
+
+func (c *k8sClient) getHTTPRoutes() []*gwapisv1beta1.HTTPRoute {
+	var result []*gwapisv1beta1.HTTPRoute
+	for ns, f := range c.gwFactories {
+		list, err := f.Gateway().V1beta1().HTTPRoutes().Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorf("failed to get http routes from namespace %s: %v", ns, err)
+			continue
+		}
+		result = append(result, list...)
+	}
+	return result
+}
+
+func (c *k8sClient) getGRPCRoutes() []*gwapisv1alpha2.GRPCRoute {
+	var result []*gwapisv1alpha2.GRPCRoute
+	for ns, f := range c.gwFactories {
+		list, err := f.Gateway().V1alpha2().GRPCRoutes().Lister().List(labels.Everything())
+		if err != nil {
+			logger.Errorf("failed to get grpc routes from namespace %s: %v", ns, err)
+			continue
+		}
+		result = append(result, list...)
+	}
+	return result
+}
+
+func (c *k8sClient) getReferenceGrants(namespace string) []*gwapisv1beta1.ReferenceGrant {
+	key, ok := c.factoryKey(namespace)
+	if !ok {
+		return nil
+	}
+	list, err := c.gwFactories[key].Gateway().V1beta1().ReferenceGrants().Lister().ReferenceGrants(namespace).List(labels.Everything())
+	if err != nil {
+		logger.Errorf("failed to get reference grants from namespace %s: %v", namespace, err)
+		return nil
+	}
+	return list
+}
+
+func (c *k8sClient) updateGatewayClassStatus(gc *gwapisv1beta1.GatewayClass) error {
+	_, err := c.gwClient.GatewayV1beta1().GatewayClasses().UpdateStatus(stdcontext.Background(), gc, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *k8sClient) updateGatewayStatus(gateway *gwapisv1beta1.Gateway) error {
+	_, err := c.gwClient.GatewayV1beta1().Gateways(gateway.Namespace).UpdateStatus(stdcontext.Background(), gateway, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *k8sClient) updateHTTPRouteStatus(route *gwapisv1beta1.HTTPRoute) error {
+	_, err := c.gwClient.GatewayV1beta1().HTTPRoutes(route.Namespace).UpdateStatus(stdcontext.Background(), route, metav1.UpdateOptions{})
+	return err
+}
+
+func (c *k8sClient) updateGRPCRouteStatus(route *gwapisv1alpha2.GRPCRoute) error {
+	_, err := c.gwClient.GatewayV1alpha2().GRPCRoutes(route.Namespace).UpdateStatus(stdcontext.Background(), route, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gatewaycontroller
+
+import (
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	gwapisv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// mergeConditions sets the last transition time of conditions, it is
+// kept if the status of the condition is not changed.
+func mergeConditions(old []metav1.Condition, conditions []metav1.Condition) []metav1.Condition {
+	now := metav1.Now()
+	result := make([]metav1.Condition, 0, len(conditions))
+	for _, c := range conditions {
+		c.LastTransitionTime = now
+		if o := meta.FindStatusCondition(old, c.Type); o != nil && o.Status == c.Status {
+			c.LastTransitionTime = o.LastTransitionTime
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func statusEqual(a, b interface{}) bool {
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+// mergeRouteStatus replaces the parent statuses of controllerName in old
+// with parents, it returns nil if the status is not changed.
+func mergeRouteStatus(old *gwapisv1beta1.RouteStatus, parents []gwapisv1beta1.RouteParentStatus, controllerName string) *gwapisv1beta1.RouteStatus {
+	status := &gwapisv1beta1.RouteStatus{}
+	for _, p := range old.Parents {
+		if string(p.ControllerName) != controllerName {
+			status.Parents = append(status.Parents, p)
+		}
+	}
+
+	for _, p := range parents {
+		var conds []metav1.Condition
+		for _, o := range old.Parents {
+			if string(o.ControllerName) == controllerName && statusEqual(o.ParentRef, p.ParentRef) {
+				conds = o.Conditions
+				break
+			}
+		}
+		p.Conditions = mergeConditions(conds, p.Conditions)
+		status.Parents = append(status.Parents, p)
+	}
+
+	if len(status.Parents) == 0 && len(old.Parents) == 0 {
+		return nil
+	}
+	if statusEqual(old, status) {
+		return nil
+	}
+	return status
+}
+
+// updateStatus writes the changed status back to kubernetes.
+func (st *specTranslator) updateStatus() {
+	for _, gc := range st.gatewayClassStatuses {
+		if err := st.k8sClient.updateGatewayClassStatus(gc); err != nil {
+			logger.Errorf("failed to update status of gateway class %s: %v", gc.Name, err)
+		}
+	}
+
+	for _, gw := range st.gatewayStatuses {
+		if err := st.k8sClient.updateGatewayStatus(gw); err != nil {
+			logger.Errorf("failed to update status of gateway %s/%s: %v", gw.Namespace, gw.Name, err)
+		}
+	}
+
+	for _, route := range st.httpRouteStatuses {
+		if err := st.k8sClient.updateHTTPRouteStatus(route); err != nil {
+			logger.Errorf("failed to update status of http route %s/%s: %v", route.Namespace, route.Name, err)
+		}
+	}
+
+	for _, route := range st.grpcRouteStatuses {
+		if err := st.k8sClient.updateGRPCRouteStatus(route); err != nil {
+			logger.Errorf("failed to update status of grpc route %s/%s: %v", route.Namespace, route.Name, err)
+		}
+	}
+}
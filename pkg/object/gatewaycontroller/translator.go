@@ -0,0 +1,1451 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gatewaycontroller
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	apicorev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	gwapisv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gwapisv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+
+	"github.com/megaease/easegress/pkg/filters/builder"
+	"github.com/megaease/easegress/pkg/filters/proxies"
+	proxy "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/httpserver/routers"
+	"github.com/megaease/easegress/pkg/object/pipeline"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	gatewayGroup = gwapisv1beta1.GroupName
+
+	kindGateway   = "Gateway"
+	kindHTTPRoute = "HTTPRoute"
+	kindGRPCRoute = "GRPCRoute"
+	kindService   = "Service"
+	kindSecret    = "Secret"
+)
+
+// the precedence of the path kinds, a smaller one is matched first.
+const (
+	pathKindExact = iota
+	pathKindRegexp
+	pathKindPrefix
+)
+
+type (
+	// specTranslator translates Gateway API resources to Easegress http
+	// server specs and pipeline specs, and computes the status of the
+	// Gateway API resources.
+	specTranslator struct {
+		k8sClient      *k8sClient
+		controllerName string
+
+		httpServers map[string]*supervisor.Spec
+		pipelines   map[string]*supervisor.Spec
+
+		servers   map[gwapisv1beta1.PortNumber]*httpServerSpecBuilder
+		protocols map[gwapisv1beta1.PortNumber]gwapisv1beta1.ProtocolType
+		gateways  map[string]*gatewayInfo
+
+		gatewayClassStatuses []*gwapisv1beta1.GatewayClass
+		gatewayStatuses      []*gwapisv1beta1.Gateway
+		httpRouteStatuses    []*gwapisv1beta1.HTTPRoute
+		grpcRouteStatuses    []*gwapisv1alpha2.GRPCRoute
+	}
+
+	gatewayInfo struct {
+		gateway   *gwapisv1beta1.Gateway
+		listeners []*listenerInfo
+	}
+
+	listenerInfo struct {
+		gateway  *gwapisv1beta1.Gateway
+		listener *gwapisv1beta1.Listener
+		kinds    []gwapisv1beta1.RouteGroupKind
+		server   *httpServerSpecBuilder
+
+		accepted       bool
+		valid          bool
+		attachedRoutes int32
+		conditions     []metav1.Condition
+	}
+
+	// routeInfo is the common part of HTTPRoute and GRPCRoute.
+	routeInfo struct {
+		kind       string
+		namespace  string
+		name       string
+		generation int64
+		hostnames  []gwapisv1beta1.Hostname
+		parentRefs []gwapisv1beta1.ParentReference
+		rules      []*routeRule
+
+		// unsupported is the error of the first unsupported feature of the
+		// route, the route is not accepted if it's not nil.
+		unsupported error
+		// unresolved is the reason and error of the first reference which
+		// can not be resolved.
+		unresolvedReason string
+		unresolved       error
+	}
+
+	// routeRule is the common part of HTTPRouteRule and GRPCRouteRule.
+	routeRule struct {
+		paths       []*pathEntry
+		reqHeader   *gwapisv1beta1.HTTPHeaderFilter
+		respHeader  *gwapisv1beta1.HTTPHeaderFilter
+		redirect    *gwapisv1beta1.HTTPRequestRedirectFilter
+		rewriteHost string
+		mirror      *gwapisv1beta1.BackendObjectReference
+		backends    []gwapisv1beta1.BackendRef
+	}
+
+	pathEntry struct {
+		path   routers.Path
+		kind   int
+		length int
+		// prefix is the original prefix of a prefix path entry.
+		prefix string
+	}
+
+	// routeAttachment is a listener a route attached to, and the
+	// hostnames of the route on the listener.
+	routeAttachment struct {
+		listener  *listenerInfo
+		hostnames []string
+	}
+
+	pipelineSpecBuilder struct {
+		Kind          string `json:"kind"`
+		Name          string `json:"name"`
+		pipeline.Spec `json:",inline"`
+	}
+
+	httpServerSpecBuilder struct {
+		Kind            string `json:"kind"`
+		Name            string `json:"name"`
+		httpserver.Spec `json:",inline"`
+
+		rules map[string]*routers.Rule
+	}
+
+	weightedServers struct {
+		weight int32
+		urls   []string
+	}
+)
+
+func newPipelineSpecBuilder(name string) *pipelineSpecBuilder {
+	return &pipelineSpecBuilder{
+		Kind: pipeline.Kind,
+		Name: name,
+		Spec: pipeline.Spec{},
+	}
+}
+
+func (b *pipelineSpecBuilder) addFilter(spec map[string]interface{}) {
+	b.Flow = append(b.Flow, pipeline.FlowNode{FilterName: spec["name"].(string)})
+	b.Filters = append(b.Filters, spec)
+}
+
+func (b *pipelineSpecBuilder) addRequestAdaptor(host string, header *httpheader.AdaptSpec) {
+	b.addFilter(map[string]interface{}{
+		"kind":   builder.RequestAdaptorKind,
+		"name":   "requestAdaptor",
+		"host":   host,
+		"header": header,
+	})
+}
+
+func (b *pipelineSpecBuilder) addResponseAdaptor(header *httpheader.AdaptSpec) {
+	b.addFilter(map[string]interface{}{
+		"kind":   builder.ResponseAdaptorKind,
+		"name":   "responseAdaptor",
+		"header": header,
+	})
+}
+
+func (b *pipelineSpecBuilder) addResponseBuilder(template string) {
+	b.addFilter(map[string]interface{}{
+		"kind":     builder.ResponseBuilderKind,
+		"name":     "responseBuilder",
+		"template": template,
+	})
+}
+
+func (b *pipelineSpecBuilder) addProxy(backends []*weightedServers, mirror []string) {
+	pool := &proxy.ServerPoolSpec{
+		BaseServerPoolSpec: proxy.BaseServerPoolSpec{
+			LoadBalance: &proxy.LoadBalanceSpec{},
+		},
+		ServerMaxBodySize: -1,
+	}
+
+	if len(backends) == 1 {
+		for _, url := range backends[0].urls {
+			pool.Servers = append(pool.Servers, &proxy.Server{URL: url})
+		}
+	} else {
+		// the weight of a backend is shared by its servers, and the
+		// weights of the servers are scaled to (0, 100].
+		max := 0.0
+		for _, b := range backends {
+			max = math.Max(max, float64(b.weight)/float64(len(b.urls)))
+		}
+		for _, b := range backends {
+			w := float64(b.weight) / float64(len(b.urls)) / max * 100
+			for _, url := range b.urls {
+				pool.Servers = append(pool.Servers, &proxy.Server{
+					URL:    url,
+					Weight: int(math.Max(1, math.Round(w))),
+				})
+			}
+		}
+		pool.LoadBalance.Policy = proxies.LoadBalancePolicyWeightedRandom
+	}
+
+	spec := map[string]interface{}{
+		"kind":  proxy.Kind,
+		"name":  "proxy",
+		"pools": []*proxy.ServerPoolSpec{pool},
+	}
+
+	if len(mirror) > 0 {
+		mirrorPool := &proxy.ServerPoolSpec{
+			BaseServerPoolSpec: proxy.BaseServerPoolSpec{
+				LoadBalance: &proxy.LoadBalanceSpec{},
+			},
+			Filter: &proxy.RequestMatcherSpec{},
+		}
+		mirrorPool.Filter.Policy = "random"
+		mirrorPool.Filter.Permil = 1000
+		for _, url := range mirror {
+			mirrorPool.Servers = append(mirrorPool.Servers, &proxy.Server{URL: url})
+		}
+		spec["mirrorPool"] = mirrorPool
+	}
+
+	b.addFilter(spec)
+}
+
+func (b *pipelineSpecBuilder) jsonConfig() string {
+	buff, err := codectool.MarshalJSON(b)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", b, err)
+	}
+	return string(buff)
+}
+
+func newHTTPServerSpecBuilder(port gwapisv1beta1.PortNumber, https bool) *httpServerSpecBuilder {
+	return &httpServerSpecBuilder{
+		Kind: httpserver.Kind,
+		Name: fmt.Sprintf("http-server-gateway-%d", port),
+		Spec: httpserver.Spec{
+			Port:             uint16(port),
+			HTTPS:            https,
+			KeepAlive:        true,
+			KeepAliveTimeout: "60s",
+			MaxConnections:   10240,
+		},
+		rules: map[string]*routers.Rule{},
+	}
+}
+
+// addPaths adds paths to the rule of host, the paths are copied as the
+// router modifies them.
+func (b *httpServerSpecBuilder) addPaths(host string, paths []*pathEntry) {
+	r := b.rules[host]
+	if r == nil {
+		r = &routers.Rule{}
+		if isWildcard(host) {
+			r.HostRegexp = "^.+" + regexp.QuoteMeta(host[1:]) + "$"
+		} else {
+			r.Host = host
+		}
+		b.rules[host] = r
+	}
+
+	for _, p := range paths {
+		path := p.path
+		r.Paths = append(r.Paths, &path)
+	}
+}
+
+func (b *httpServerSpecBuilder) jsonConfig() string {
+	// sort rules by host
+	// * precise hosts first(in alphabetical order)
+	// * wildcard hosts next(longer first)
+	// * empty host last
+	hosts := make([]string, 0, len(b.rules))
+	for host := range b.rules {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		h1, h2 := hosts[i], hosts[j]
+		if h1 == "" || h2 == "" {
+			return h2 == ""
+		}
+		if w1, w2 := isWildcard(h1), isWildcard(h2); w1 != w2 {
+			return w2
+		} else if w1 && len(h1) != len(h2) {
+			return len(h1) > len(h2)
+		}
+		return h1 < h2
+	})
+
+	b.Rules = nil
+	for _, host := range hosts {
+		b.Rules = append(b.Rules, b.rules[host])
+	}
+
+	buff, err := codectool.MarshalJSON(b)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", b, err)
+	}
+	return string(buff)
+}
+
+func newSpecTranslator(k8sClient *k8sClient, controllerName string) *specTranslator {
+	return &specTranslator{
+		k8sClient:      k8sClient,
+		controllerName: controllerName,
+		httpServers:    map[string]*supervisor.Spec{},
+		pipelines:      map[string]*supervisor.Spec{},
+		servers:        map[gwapisv1beta1.PortNumber]*httpServerSpecBuilder{},
+		protocols:      map[gwapisv1beta1.PortNumber]gwapisv1beta1.ProtocolType{},
+		gateways:       map[string]*gatewayInfo{},
+	}
+}
+
+func (st *specTranslator) httpServerSpecs() map[string]*supervisor.Spec {
+	return st.httpServers
+}
+
+func (st *specTranslator) pipelineSpecs() map[string]*supervisor.Spec {
+	return st.pipelines
+}
+
+func isWildcard(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
+
+// intersectHostname returns the more specific one of hostnames a and b if
+// they match each other, either of them can be a wildcard hostname.
+func intersectHostname(a, b string) (string, bool) {
+	switch {
+	case a == b:
+		return a, true
+	case isWildcard(a) && strings.HasSuffix(b, a[1:]):
+		return b, true
+	case isWildcard(b) && strings.HasSuffix(a, b[1:]):
+		return a, true
+	}
+	return "", false
+}
+
+// routeHostnames returns the hostnames of a route on a listener, an empty
+// hostname means any host. It returns false if there's no intersection.
+func routeHostnames(listener *gwapisv1beta1.Hostname, route []gwapisv1beta1.Hostname) ([]string, bool) {
+	l := ""
+	if listener != nil {
+		l = string(*listener)
+	}
+	if len(route) == 0 {
+		return []string{l}, true
+	}
+
+	var result []string
+	seen := map[string]bool{}
+	for _, h := range route {
+		host := string(h)
+		if l != "" {
+			var ok bool
+			if host, ok = intersectHostname(l, host); !ok {
+				continue
+			}
+		}
+		if !seen[host] {
+			seen[host] = true
+			result = append(result, host)
+		}
+	}
+	return result, len(result) > 0
+}
+
+func newCondition(typ string, ok bool, reason string, message string, generation int64) metav1.Condition {
+	status := metav1.ConditionTrue
+	if !ok {
+		status = metav1.ConditionFalse
+	}
+	return metav1.Condition{
+		Type:               typ,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	}
+}
+
+func objectKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// sortObjects sorts objects by creation time, and then by namespace and
+// name, the older one takes precedence when there are conflicts.
+func sortObjects[T metav1.Object](objs []T) {
+	sort.Slice(objs, func(i, j int) bool {
+		o1, o2 := objs[i], objs[j]
+		t1, t2 := o1.GetCreationTimestamp(), o2.GetCreationTimestamp()
+		if !t1.Equal(&t2) {
+			return t1.Before(&t2)
+		}
+		return objectKey(o1.GetNamespace(), o1.GetName()) < objectKey(o2.GetNamespace(), o2.GetName())
+	})
+}
+
+// referenceGranted checks if there's a ReferenceGrant in the namespace of
+// the target which allows the reference.
+func (st *specTranslator) referenceGranted(fromKind, fromNamespace, toKind, toNamespace, toName string) bool {
+	for _, rg := range st.k8sClient.getReferenceGrants(toNamespace) {
+		from := false
+		for _, f := range rg.Spec.From {
+			if string(f.Group) == gatewayGroup && string(f.Kind) == fromKind && string(f.Namespace) == fromNamespace {
+				from = true
+				break
+			}
+		}
+		if !from {
+			continue
+		}
+
+		for _, t := range rg.Spec.To {
+			if t.Group == "" && string(t.Kind) == toKind && (t.Name == nil || string(*t.Name) == toName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getCertificateBlocks(secret *apicorev1.Secret, namespace, secretName string) (string, string, error) {
+	crt, ok := secret.Data["tls.crt"]
+	if !ok || len(crt) == 0 {
+		err := fmt.Errorf("'tls.crt' is missing or empty in secret %s/%s", namespace, secretName)
+		return "", "", err
+	}
+
+	key, ok := secret.Data["tls.key"]
+	if !ok || len(key) == 0 {
+		err := fmt.Errorf("'tls.key' is missing or empty in secret %s/%s", namespace, secretName)
+		return "", "", err
+	}
+
+	return string(crt), string(key), nil
+}
+
+// resolveCertificates returns the certificates and keys of a HTTPS
+// listener, keyed by namespace/name of the secrets.
+func (st *specTranslator) resolveCertificates(gw *gwapisv1beta1.Gateway, l *gwapisv1beta1.Listener) (map[string]string, map[string]string, gwapisv1beta1.ListenerConditionReason, error) {
+	if l.TLS == nil || len(l.TLS.CertificateRefs) == 0 {
+		return nil, nil, gwapisv1beta1.ListenerReasonInvalidCertificateRef, fmt.Errorf("no certificate refs")
+	}
+	if l.TLS.Mode != nil && *l.TLS.Mode != gwapisv1beta1.TLSModeTerminate {
+		return nil, nil, gwapisv1beta1.ListenerReasonInvalidCertificateRef, fmt.Errorf("tls mode %s is not supported", *l.TLS.Mode)
+	}
+
+	certs, keys := map[string]string{}, map[string]string{}
+	for _, ref := range l.TLS.CertificateRefs {
+		if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != kindSecret) {
+			return nil, nil, gwapisv1beta1.ListenerReasonInvalidCertificateRef, fmt.Errorf("certificate ref %s is not a secret", ref.Name)
+		}
+
+		ns := gw.Namespace
+		if ref.Namespace != nil {
+			ns = string(*ref.Namespace)
+		}
+		name := string(ref.Name)
+		if ns != gw.Namespace && !st.referenceGranted(kindGateway, gw.Namespace, kindSecret, ns, name) {
+			return nil, nil, gwapisv1beta1.ListenerReasonRefNotPermitted, fmt.Errorf("reference to secret %s/%s is not permitted", ns, name)
+		}
+
+		secret, err := st.k8sClient.getSecret(ns, name)
+		if err == nil && secret == nil {
+			err = fmt.Errorf("secret %s/%s does not exist", ns, name)
+		}
+		if err != nil {
+			return nil, nil, gwapisv1beta1.ListenerReasonInvalidCertificateRef, err
+		}
+
+		cert, key, err := getCertificateBlocks(secret, ns, name)
+		if err != nil {
+			return nil, nil, gwapisv1beta1.ListenerReasonInvalidCertificateRef, err
+		}
+		certs[objectKey(ns, name)] = cert
+		keys[objectKey(ns, name)] = key
+	}
+
+	return certs, keys, "", nil
+}
+
+func (st *specTranslator) translateListener(gw *gwapisv1beta1.Gateway, l *gwapisv1beta1.Listener) *listenerInfo {
+	li := &listenerInfo{gateway: gw, listener: l}
+	gen := gw.Generation
+
+	accepted := newCondition(string(gwapisv1beta1.ListenerConditionAccepted), true,
+		string(gwapisv1beta1.ListenerReasonAccepted), "listener is accepted", gen)
+	resolved := newCondition(string(gwapisv1beta1.ListenerConditionResolvedRefs), true,
+		string(gwapisv1beta1.ListenerReasonResolvedRefs), "all references are resolved", gen)
+	conflicted := newCondition(string(gwapisv1beta1.ListenerConditionConflicted), false,
+		string(gwapisv1beta1.ListenerReasonNoConflicts), "listener has no conflicts", gen)
+
+	// supported route kinds
+	if l.AllowedRoutes == nil || len(l.AllowedRoutes.Kinds) == 0 {
+		group := gwapisv1beta1.Group(gatewayGroup)
+		li.kinds = []gwapisv1beta1.RouteGroupKind{
+			{Group: &group, Kind: kindHTTPRoute},
+			{Group: &group, Kind: kindGRPCRoute},
+		}
+	} else {
+		for _, k := range l.AllowedRoutes.Kinds {
+			if (k.Group == nil || *k.Group == gatewayGroup) && (k.Kind == kindHTTPRoute || k.Kind == kindGRPCRoute) {
+				li.kinds = append(li.kinds, k)
+				continue
+			}
+			resolved = newCondition(string(gwapisv1beta1.ListenerConditionResolvedRefs), false,
+				string(gwapisv1beta1.ListenerReasonInvalidRouteKinds), fmt.Sprintf("route kind %s is not supported", k.Kind), gen)
+		}
+	}
+
+	var certs, keys map[string]string
+	switch l.Protocol {
+	case gwapisv1beta1.HTTPProtocolType:
+		li.accepted = true
+	case gwapisv1beta1.HTTPSProtocolType:
+		li.accepted = true
+		var (
+			reason gwapisv1beta1.ListenerConditionReason
+			err    error
+		)
+		certs, keys, reason, err = st.resolveCertificates(gw, l)
+		if err != nil {
+			resolved = newCondition(string(gwapisv1beta1.ListenerConditionResolvedRefs), false, string(reason), err.Error(), gen)
+		}
+	default:
+		accepted = newCondition(string(gwapisv1beta1.ListenerConditionAccepted), false,
+			string(gwapisv1beta1.ListenerReasonUnsupportedProtocol), fmt.Sprintf("protocol %s is not supported", l.Protocol), gen)
+	}
+
+	if li.accepted {
+		if p, ok := st.protocols[l.Port]; !ok {
+			st.protocols[l.Port] = l.Protocol
+		} else if p != l.Protocol {
+			li.accepted = false
+			conflicted = newCondition(string(gwapisv1beta1.ListenerConditionConflicted), true,
+				string(gwapisv1beta1.ListenerReasonProtocolConflict), fmt.Sprintf("port %d is used by protocol %s", l.Port, p), gen)
+		}
+	}
+
+	li.valid = li.accepted && resolved.Status == metav1.ConditionTrue
+	programmed := newCondition(string(gwapisv1beta1.ListenerConditionProgrammed), li.valid,
+		string(gwapisv1beta1.ListenerReasonProgrammed), "listener is programmed", gen)
+	if !li.valid {
+		programmed.Reason = string(gwapisv1beta1.ListenerReasonInvalid)
+		programmed.Message = "listener is invalid"
+	}
+	li.conditions = []metav1.Condition{accepted, resolved, conflicted, programmed}
+
+	if !li.valid {
+		return li
+	}
+
+	server := st.servers[l.Port]
+	if server == nil {
+		server = newHTTPServerSpecBuilder(l.Port, l.Protocol == gwapisv1beta1.HTTPSProtocolType)
+		st.servers[l.Port] = server
+	}
+	if len(certs) > 0 {
+		if server.Certs == nil {
+			server.Certs, server.Keys = map[string]string{}, map[string]string{}
+		}
+		for k, v := range certs {
+			server.Certs[k], server.Keys[k] = v, keys[k]
+		}
+	}
+	li.server = server
+
+	return li
+}
+
+func (st *specTranslator) translateGateways() {
+	classes := map[string]bool{}
+	for _, gc := range st.k8sClient.getGatewayClasses(st.controllerName) {
+		classes[gc.Name] = true
+
+		status := gc.Status.DeepCopy()
+		status.Conditions = mergeConditions(gc.Status.Conditions, []metav1.Condition{
+			newCondition(string(gwapisv1beta1.GatewayClassConditionStatusAccepted), true,
+				string(gwapisv1beta1.GatewayClassReasonAccepted), "gateway class is accepted", gc.Generation),
+		})
+		if !statusEqual(&gc.Status, status) {
+			gc = gc.DeepCopy()
+			gc.Status = *status
+			st.gatewayClassStatuses = append(st.gatewayClassStatuses, gc)
+		}
+	}
+
+	var gateways []*gwapisv1beta1.Gateway
+	for _, gw := range st.k8sClient.getGateways() {
+		if classes[string(gw.Spec.GatewayClassName)] {
+			gateways = append(gateways, gw)
+		}
+	}
+	sortObjects(gateways)
+
+	for _, gw := range gateways {
+		info := &gatewayInfo{gateway: gw}
+		for i := range gw.Spec.Listeners {
+			info.listeners = append(info.listeners, st.translateListener(gw, &gw.Spec.Listeners[i]))
+		}
+		st.gateways[objectKey(gw.Namespace, gw.Name)] = info
+	}
+}
+
+// allowsRoute checks whether the listener allows routes of kind in
+// namespace.
+func (li *listenerInfo) allowsRoute(kind, namespace string, k8sClient *k8sClient) bool {
+	allowed := false
+	for _, k := range li.kinds {
+		if string(k.Kind) == kind {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	from := gwapisv1beta1.NamespacesFromSame
+	var rn *gwapisv1beta1.RouteNamespaces
+	if li.listener.AllowedRoutes != nil {
+		rn = li.listener.AllowedRoutes.Namespaces
+	}
+	if rn != nil && rn.From != nil {
+		from = *rn.From
+	}
+
+	switch from {
+	case gwapisv1beta1.NamespacesFromAll:
+		return true
+	case gwapisv1beta1.NamespacesFromSelector:
+		if rn.Selector == nil {
+			return false
+		}
+		selector, err := metav1.LabelSelectorAsSelector(rn.Selector)
+		if err != nil {
+			logger.Errorf("invalid namespace selector of gateway %s/%s: %v", li.gateway.Namespace, li.gateway.Name, err)
+			return false
+		}
+		ns, err := k8sClient.getNamespace(namespace)
+		if err != nil || ns == nil {
+			return false
+		}
+		return selector.Matches(labels.Set(ns.Labels))
+	default:
+		return namespace == li.gateway.Namespace
+	}
+}
+
+// attachRoute attaches the route to the listeners referenced by ref, it
+// returns false if ref is not a gateway managed by this controller.
+func (st *specTranslator) attachRoute(r *routeInfo, ref gwapisv1beta1.ParentReference) ([]*routeAttachment, metav1.Condition, bool) {
+	if (ref.Group != nil && *ref.Group != gatewayGroup) || (ref.Kind != nil && *ref.Kind != kindGateway) {
+		return nil, metav1.Condition{}, false
+	}
+	ns := r.namespace
+	if ref.Namespace != nil {
+		ns = string(*ref.Namespace)
+	}
+	gw := st.gateways[objectKey(ns, string(ref.Name))]
+	if gw == nil {
+		return nil, metav1.Condition{}, false
+	}
+
+	typ := string(gwapisv1beta1.RouteConditionAccepted)
+	if r.unsupported != nil {
+		return nil, newCondition(typ, false, string(gwapisv1beta1.RouteReasonUnsupportedValue), r.unsupported.Error(), r.generation), true
+	}
+
+	var (
+		result             []*routeAttachment
+		notAllowed, noHost bool
+	)
+	for _, li := range gw.listeners {
+		l := li.listener
+		if ref.SectionName != nil && *ref.SectionName != l.Name {
+			continue
+		}
+		if ref.Port != nil && *ref.Port != l.Port {
+			continue
+		}
+		if !li.valid {
+			continue
+		}
+		if !li.allowsRoute(r.kind, r.namespace, st.k8sClient) {
+			notAllowed = true
+			continue
+		}
+		hostnames, ok := routeHostnames(l.Hostname, r.hostnames)
+		if !ok {
+			noHost = true
+			continue
+		}
+		result = append(result, &routeAttachment{listener: li, hostnames: hostnames})
+	}
+
+	switch {
+	case len(result) > 0:
+		return result, newCondition(typ, true, string(gwapisv1beta1.RouteReasonAccepted), "route is accepted", r.generation), true
+	case notAllowed:
+		return nil, newCondition(typ, false, string(gwapisv1beta1.RouteReasonNotAllowedByListeners), "route is not allowed by listeners", r.generation), true
+	case noHost:
+		return nil, newCondition(typ, false, string(gwapisv1beta1.RouteReasonNoMatchingListenerHostname), "no matching listener hostname", r.generation), true
+	default:
+		return nil, newCondition(typ, false, string(gwapisv1beta1.RouteReasonNoMatchingParent), "no matching listener", r.generation), true
+	}
+}
+
+// resolveBackend returns the server URLs of a backend reference.
+func (st *specTranslator) resolveBackend(r *routeInfo, ref *gwapisv1beta1.BackendObjectReference) ([]string, error) {
+	fail := func(reason gwapisv1beta1.RouteConditionReason, err error) ([]string, error) {
+		if r.unresolved == nil {
+			r.unresolvedReason, r.unresolved = string(reason), err
+		}
+		return nil, err
+	}
+
+	if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != kindService) {
+		return fail(gwapisv1beta1.RouteReasonInvalidKind, fmt.Errorf("backend %s is not a service", ref.Name))
+	}
+
+	ns := r.namespace
+	if ref.Namespace != nil {
+		ns = string(*ref.Namespace)
+	}
+	name := string(ref.Name)
+	if ns != r.namespace && !st.referenceGranted(r.kind, r.namespace, kindService, ns, name) {
+		return fail(gwapisv1beta1.RouteReasonRefNotPermitted, fmt.Errorf("reference to service %s/%s is not permitted", ns, name))
+	}
+	if ref.Port == nil {
+		return fail(gwapisv1beta1.RouteReasonBackendNotFound, fmt.Errorf("port of service %s/%s is not specified", ns, name))
+	}
+
+	urls, err := st.getEndpoints(ns, name, int32(*ref.Port))
+	if err != nil {
+		return fail(gwapisv1beta1.RouteReasonBackendNotFound, err)
+	}
+	return urls, nil
+}
+
+func (st *specTranslator) getEndpoints(namespace, name string, port int32) ([]string, error) {
+	svc, err := st.k8sClient.getService(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if svc == nil {
+		return nil, fmt.Errorf("service %s/%s does not exist", namespace, name)
+	}
+
+	var svcPort *apicorev1.ServicePort
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Port == port {
+			svcPort = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil, fmt.Errorf("port %d not found in service %s/%s", port, namespace, name)
+	}
+	protocol := "http"
+	if svcPort.Port == 443 || (svcPort.AppProtocol != nil && *svcPort.AppProtocol == "https") {
+		protocol = "https"
+	}
+
+	var result []string
+	if svc.Spec.Type == apicorev1.ServiceTypeExternalName {
+		hostPort := net.JoinHostPort(svc.Spec.ExternalName, strconv.Itoa(int(svcPort.Port)))
+		result = append(result, fmt.Sprintf("%s://%s", protocol, hostPort))
+		return result, nil
+	}
+
+	endpoints, err := st.k8sClient.getEndpoints(namespace, name)
+	if err != nil || endpoints == nil {
+		return nil, err
+	}
+
+	for _, subset := range endpoints.Subsets {
+		var port int32
+		for _, p := range subset.Ports {
+			if svcPort.Name == p.Name {
+				port = p.Port
+			}
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, addr := range subset.Addresses {
+			hostPort := net.JoinHostPort(addr.IP, strconv.Itoa(int(port)))
+			result = append(result, fmt.Sprintf("%s://%s", protocol, hostPort))
+		}
+	}
+
+	return result, nil
+}
+
+func headerAdaptSpec(f *gwapisv1beta1.HTTPHeaderFilter) *httpheader.AdaptSpec {
+	spec := &httpheader.AdaptSpec{Del: f.Remove}
+	if len(f.Set) > 0 {
+		spec.Set = map[string]string{}
+		for _, h := range f.Set {
+			spec.Set[string(h.Name)] = h.Value
+		}
+	}
+	if len(f.Add) > 0 {
+		spec.Add = map[string]string{}
+		for _, h := range f.Add {
+			spec.Add[string(h.Name)] = h.Value
+		}
+	}
+	return spec
+}
+
+// escapeReplacement escapes s to be used literally as the replacement of
+// regexp.ReplaceAllString.
+func escapeReplacement(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// prefixRewrite returns the regexp and the replacement to replace the
+// path prefixes with replacement. Prefixes are matched by path elements,
+// that's, prefix "/foo" matches "/foo" and "/foo/bar" but not "/foobar".
+func prefixRewrite(prefixes []string, replacement string) (string, string) {
+	quoted := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		quoted = append(quoted, regexp.QuoteMeta(strings.TrimSuffix(p, "/")))
+	}
+	// longer prefixes first
+	sort.Slice(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+
+	alternation := strings.Join(quoted, "|")
+	if len(quoted) > 1 {
+		alternation = "(?:" + alternation + ")"
+	}
+
+	replacement = strings.TrimSuffix(replacement, "/")
+	if replacement == "" {
+		return "^" + alternation + "(?:/(.*))?$", "/${1}"
+	}
+	return "^" + alternation + "(/.*)?$", escapeReplacement(replacement) + "${1}"
+}
+
+// newHTTPPathEntry translates a HTTPRouteMatch to a path entry, rewrite
+// is the path modifier of the URLRewrite filter.
+func newHTTPPathEntry(m *gwapisv1beta1.HTTPRouteMatch, rewrite *gwapisv1beta1.HTTPPathModifier) (*pathEntry, error) {
+	typ, value := gwapisv1beta1.PathMatchPathPrefix, "/"
+	if m.Path != nil {
+		if m.Path.Type != nil {
+			typ = *m.Path.Type
+		}
+		if m.Path.Value != nil {
+			value = *m.Path.Value
+		}
+	}
+
+	var (
+		full   *string
+		prefix *string
+	)
+	if rewrite != nil {
+		full, prefix = rewrite.ReplaceFullPath, rewrite.ReplacePrefixMatch
+	}
+
+	e := &pathEntry{}
+	p := &e.path
+	switch typ {
+	case gwapisv1beta1.PathMatchExact:
+		e.kind, e.length = pathKindExact, len(value)
+		switch {
+		case full != nil:
+			p.PathRegexp, p.RewriteTarget = "^"+regexp.QuoteMeta(value)+"$", escapeReplacement(*full)
+		case prefix != nil:
+			p.PathRegexp, p.RewriteTarget = "^"+regexp.QuoteMeta(value)+"$", escapeReplacement(*prefix)
+		default:
+			p.Path = value
+		}
+
+	case gwapisv1beta1.PathMatchPathPrefix:
+		e.kind, e.length, e.prefix = pathKindPrefix, len(strings.TrimSuffix(value, "/")), value
+		re, _ := prefixRewrite([]string{value}, "/")
+		switch {
+		case full != nil:
+			p.PathRegexp, p.RewriteTarget = re, escapeReplacement(*full)
+		case prefix != nil:
+			p.PathRegexp, p.RewriteTarget = prefixRewrite([]string{value}, *prefix)
+		case e.length == 0:
+			p.PathPrefix = "/"
+		default:
+			p.PathRegexp = re
+		}
+
+	case gwapisv1beta1.PathMatchRegularExpression:
+		e.kind, e.length = pathKindRegexp, len(value)
+		p.PathRegexp = "^(?:" + value + ")$"
+		if _, err := regexp.Compile(p.PathRegexp); err != nil {
+			return nil, fmt.Errorf("invalid path regexp %s: %v", value, err)
+		}
+		switch {
+		case full != nil:
+			p.RewriteTarget = escapeReplacement(*full)
+		case prefix != nil:
+			return nil, fmt.Errorf("ReplacePrefixMatch is not supported with RegularExpression path match")
+		}
+
+	default:
+		return nil, fmt.Errorf("path match type %s is not supported", typ)
+	}
+
+	if m.Method != nil {
+		p.Methods = []string{string(*m.Method)}
+	}
+
+	for _, h := range m.Headers {
+		header := &routers.Header{Key: string(h.Name)}
+		if h.Type != nil && *h.Type == gwapisv1beta1.HeaderMatchRegularExpression {
+			header.Regexp = "^(?:" + h.Value + ")$"
+		} else {
+			header.Values = []string{h.Value}
+		}
+		p.Headers = append(p.Headers, header)
+	}
+	p.MatchAllHeader = true
+
+	for _, q := range m.QueryParams {
+		query := &routers.Query{Key: q.Name}
+		if q.Type != nil && *q.Type == gwapisv1beta1.QueryParamMatchRegularExpression {
+			query.Regexp = "^(?:" + q.Value + ")$"
+		} else {
+			query.Values = []string{q.Value}
+		}
+		p.Queries = append(p.Queries, query)
+	}
+	p.MatchAllQuery = true
+
+	p.ClientMaxBodySize = -1
+	return e, nil
+}
+
+// newGRPCPathEntry translates a GRPCRouteMatch to a path entry, gRPC
+// requests are POST requests to path /service/method.
+func newGRPCPathEntry(m *gwapisv1alpha2.GRPCRouteMatch) *pathEntry {
+	e := &pathEntry{}
+	p := &e.path
+
+	switch {
+	case m.Method == nil || (m.Method.Service == nil && m.Method.Method == nil):
+		e.kind, e.length, e.prefix = pathKindPrefix, 0, "/"
+		p.PathPrefix = "/"
+
+	case m.Method.Type != nil && *m.Method.Type == gwapisv1alpha2.GRPCMethodMatchRegularExpression:
+		service, method := "[^/]+", "[^/]+"
+		if m.Method.Service != nil {
+			service = *m.Method.Service
+		}
+		if m.Method.Method != nil {
+			method = *m.Method.Method
+		}
+		e.kind, e.length = pathKindRegexp, len(service)+len(method)
+		p.PathRegexp = "^/(?:" + service + ")/(?:" + method + ")$"
+
+	case m.Method.Service == nil:
+		e.kind, e.length = pathKindRegexp, len(*m.Method.Method)
+		p.PathRegexp = "^/[^/]+/" + regexp.QuoteMeta(*m.Method.Method) + "$"
+
+	case m.Method.Method == nil:
+		p.PathPrefix = "/" + *m.Method.Service + "/"
+		e.kind, e.length, e.prefix = pathKindPrefix, len(p.PathPrefix), p.PathPrefix
+
+	default:
+		e.kind, e.length = pathKindExact, len(*m.Method.Service)+len(*m.Method.Method)+2
+		p.Path = "/" + *m.Method.Service + "/" + *m.Method.Method
+	}
+
+	p.Methods = []string{"POST"}
+	for _, h := range m.Headers {
+		header := &routers.Header{Key: string(h.Name)}
+		if h.Type != nil && *h.Type == gwapisv1beta1.HeaderMatchRegularExpression {
+			header.Regexp = "^(?:" + h.Value + ")$"
+		} else {
+			header.Values = []string{h.Value}
+		}
+		p.Headers = append(p.Headers, header)
+	}
+	p.MatchAllHeader = true
+	p.ClientMaxBodySize = -1
+	return e
+}
+
+// lessPathEntry reports whether path entry a takes precedence over b:
+// exact paths first, then regexps, then longer prefixes, then the entries
+// with a method, more headers and more queries.
+func lessPathEntry(a, b *pathEntry) bool {
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	if a.kind == pathKindPrefix && a.length != b.length {
+		return a.length > b.length
+	}
+	if m1, m2 := len(a.path.Methods) > 0, len(b.path.Methods) > 0; m1 != m2 {
+		return m1
+	}
+	if len(a.path.Headers) != len(b.path.Headers) {
+		return len(a.path.Headers) > len(b.path.Headers)
+	}
+	return len(a.path.Queries) > len(b.path.Queries)
+}
+
+func (r *routeInfo) convertHTTPRoute(route *gwapisv1beta1.HTTPRoute) {
+	r.kind, r.namespace, r.name = kindHTTPRoute, route.Namespace, route.Name
+	r.generation = route.Generation
+	r.hostnames = route.Spec.Hostnames
+	r.parentRefs = route.Spec.ParentRefs
+
+	for i := range route.Spec.Rules {
+		rule := &route.Spec.Rules[i]
+		rr := &routeRule{}
+
+		var rewrite *gwapisv1beta1.HTTPPathModifier
+		for j := range rule.Filters {
+			f := &rule.Filters[j]
+			switch f.Type {
+			case gwapisv1beta1.HTTPRouteFilterRequestHeaderModifier:
+				rr.reqHeader = f.RequestHeaderModifier
+			case gwapisv1beta1.HTTPRouteFilterResponseHeaderModifier:
+				rr.respHeader = f.ResponseHeaderModifier
+			case gwapisv1beta1.HTTPRouteFilterRequestRedirect:
+				rr.redirect = f.RequestRedirect
+			case gwapisv1beta1.HTTPRouteFilterURLRewrite:
+				if f.URLRewrite != nil {
+					if f.URLRewrite.Hostname != nil {
+						rr.rewriteHost = string(*f.URLRewrite.Hostname)
+					}
+					rewrite = f.URLRewrite.Path
+				}
+			case gwapisv1beta1.HTTPRouteFilterRequestMirror:
+				if f.RequestMirror != nil {
+					rr.mirror = &f.RequestMirror.BackendRef
+				}
+			default:
+				r.unsupported = fmt.Errorf("filter %s is not supported", f.Type)
+			}
+		}
+
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []gwapisv1beta1.HTTPRouteMatch{{}}
+		}
+		for j := range matches {
+			e, err := newHTTPPathEntry(&matches[j], rewrite)
+			if err != nil {
+				r.unsupported = err
+				continue
+			}
+			rr.paths = append(rr.paths, e)
+		}
+
+		for _, b := range rule.BackendRefs {
+			rr.backends = append(rr.backends, b.BackendRef)
+		}
+		r.rules = append(r.rules, rr)
+	}
+}
+
+func (r *routeInfo) convertGRPCRoute(route *gwapisv1alpha2.GRPCRoute) {
+	r.kind, r.namespace, r.name = kindGRPCRoute, route.Namespace, route.Name
+	r.generation = route.Generation
+	r.hostnames = route.Spec.Hostnames
+	r.parentRefs = route.Spec.ParentRefs
+
+	for i := range route.Spec.Rules {
+		rule := &route.Spec.Rules[i]
+		rr := &routeRule{}
+
+		for j := range rule.Filters {
+			f := &rule.Filters[j]
+			switch f.Type {
+			case gwapisv1alpha2.GRPCRouteFilterRequestHeaderModifier:
+				rr.reqHeader = f.RequestHeaderModifier
+			case gwapisv1alpha2.GRPCRouteFilterResponseHeaderModifier:
+				rr.respHeader = f.ResponseHeaderModifier
+			case gwapisv1alpha2.GRPCRouteFilterRequestMirror:
+				if f.RequestMirror != nil {
+					rr.mirror = &f.RequestMirror.BackendRef
+				}
+			default:
+				r.unsupported = fmt.Errorf("filter %s is not supported", f.Type)
+			}
+		}
+
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []gwapisv1alpha2.GRPCRouteMatch{{}}
+		}
+		for j := range matches {
+			rr.paths = append(rr.paths, newGRPCPathEntry(&matches[j]))
+		}
+
+		for _, b := range rule.BackendRefs {
+			rr.backends = append(rr.backends, b.BackendRef)
+		}
+		r.rules = append(r.rules, rr)
+	}
+}
+
+// redirectTemplate returns the template of the ResponseBuilder filter
+// which builds the redirect response. The location is built from the
+// request at runtime for the parts not specified by the filter.
+func redirectTemplate(f *gwapisv1beta1.HTTPRequestRedirectFilter, paths []*pathEntry) string {
+	var sb strings.Builder
+	sb.WriteString("{{- $req := .requests.DEFAULT -}}\n")
+
+	if f.Scheme != nil {
+		fmt.Fprintf(&sb, "{{- $scheme := %q -}}\n", *f.Scheme)
+	} else {
+		sb.WriteString("{{- $scheme := \"http\" -}}{{- if $req.TLS -}}{{- $scheme = \"https\" -}}{{- end -}}\n")
+	}
+
+	if f.Hostname != nil {
+		fmt.Fprintf(&sb, "{{- $host := %q -}}\n", *f.Hostname)
+	} else {
+		sb.WriteString("{{- $host := regexReplaceAll \":[0-9]+$\" $req.Host \"\" -}}\n")
+	}
+
+	// the port is the well-known port of the scheme if the scheme is
+	// specified, otherwise it is the port of the request.
+	switch {
+	case f.Port != nil:
+		fmt.Fprintf(&sb, "{{- $port := \"%d\" -}}\n", *f.Port)
+	case f.Scheme != nil:
+		sb.WriteString("{{- $port := \"\" -}}\n")
+	default:
+		sb.WriteString("{{- $port := regexFind \":[0-9]+$\" $req.Host | trimPrefix \":\" -}}\n")
+	}
+
+	path := "{{- $path := $req.URL.Path -}}\n"
+	if f.Path != nil {
+		switch {
+		case f.Path.ReplaceFullPath != nil:
+			path = fmt.Sprintf("{{- $path := %q -}}\n", *f.Path.ReplaceFullPath)
+		case f.Path.ReplacePrefixMatch != nil:
+			var prefixes []string
+			for _, p := range paths {
+				if p.kind == pathKindPrefix {
+					prefixes = append(prefixes, p.prefix)
+				}
+			}
+			if len(prefixes) == 0 {
+				path = fmt.Sprintf("{{- $path := %q -}}\n", *f.Path.ReplacePrefixMatch)
+			} else {
+				re, repl := prefixRewrite(prefixes, *f.Path.ReplacePrefixMatch)
+				path = fmt.Sprintf("{{- $path := regexReplaceAll %q $req.URL.Path %q -}}\n", re, repl)
+			}
+		}
+	}
+	sb.WriteString(path)
+
+	code := 302
+	if f.StatusCode != nil {
+		code = *f.StatusCode
+	}
+
+	sb.WriteString(`{{- $loc := printf "%s://%s" $scheme $host -}}
+{{- if and $port (not (or (and (eq $scheme "http") (eq $port "80")) (and (eq $scheme "https") (eq $port "443")))) -}}
+{{- $loc = printf "%s:%s" $loc $port -}}
+{{- end -}}
+{{- $loc = printf "%s%s" $loc $path -}}
+{{- if $req.URL.RawQuery -}}{{- $loc = printf "%s?%s" $loc $req.URL.RawQuery -}}{{- end -}}
+`)
+	fmt.Fprintf(&sb, "statusCode: %d\nheaders:\n  Location: [{{ $loc | toJson }}]\n", code)
+	return sb.String()
+}
+
+func (st *specTranslator) translateRule(r *routeInfo, rr *routeRule, name string) (*supervisor.Spec, error) {
+	b := newPipelineSpecBuilder(name)
+
+	if rr.reqHeader != nil || rr.rewriteHost != "" {
+		var header *httpheader.AdaptSpec
+		if rr.reqHeader != nil {
+			header = headerAdaptSpec(rr.reqHeader)
+		}
+		b.addRequestAdaptor(rr.rewriteHost, header)
+	}
+
+	var backends []*weightedServers
+	for i := range rr.backends {
+		ref := &rr.backends[i]
+		urls, err := st.resolveBackend(r, &ref.BackendObjectReference)
+		if err != nil {
+			logger.Errorf("failed to resolve backend of %s %s/%s: %v", r.kind, r.namespace, r.name, err)
+			continue
+		}
+		weight := int32(1)
+		if ref.Weight != nil {
+			weight = *ref.Weight
+		}
+		if weight > 0 && len(urls) > 0 {
+			backends = append(backends, &weightedServers{weight: weight, urls: urls})
+		}
+	}
+
+	var mirror []string
+	if rr.mirror != nil {
+		urls, err := st.resolveBackend(r, rr.mirror)
+		if err != nil {
+			logger.Errorf("failed to resolve mirror backend of %s %s/%s: %v", r.kind, r.namespace, r.name, err)
+		}
+		mirror = urls
+	}
+
+	switch {
+	case rr.redirect != nil:
+		b.addResponseBuilder(redirectTemplate(rr.redirect, rr.paths))
+	case len(backends) == 0:
+		// requests to invalid backends receive 500 status code
+		b.addResponseBuilder("statusCode: 500\n")
+	default:
+		b.addProxy(backends, mirror)
+	}
+
+	if rr.respHeader != nil {
+		b.addResponseAdaptor(headerAdaptSpec(rr.respHeader))
+	}
+
+	return supervisor.NewSpec(b.jsonConfig())
+}
+
+// translateRoute translates a route and returns the new status of its
+// parents managed by this controller.
+func (st *specTranslator) translateRoute(r *routeInfo, entries map[*httpServerSpecBuilder]map[string][]*pathEntry) []gwapisv1beta1.RouteParentStatus {
+	var (
+		attachments []*routeAttachment
+		parents     []gwapisv1beta1.RouteParentStatus
+		accepted    []metav1.Condition
+	)
+	for _, ref := range r.parentRefs {
+		a, cond, ours := st.attachRoute(r, ref)
+		if !ours {
+			continue
+		}
+		attachments = append(attachments, a...)
+		parents = append(parents, gwapisv1beta1.RouteParentStatus{
+			ParentRef:      ref,
+			ControllerName: gwapisv1beta1.GatewayController(st.controllerName),
+		})
+		accepted = append(accepted, cond)
+	}
+
+	if len(attachments) > 0 {
+		var paths []*pathEntry
+		prefix := strings.ToLower(r.kind)
+		for i, rr := range r.rules {
+			name := fmt.Sprintf("%s-%s-%s-%d", prefix, r.namespace, r.name, i)
+			spec, err := st.translateRule(r, rr, name)
+			if err != nil {
+				logger.Errorf("failed to generate pipeline spec: %v", err)
+				continue
+			}
+			st.pipelines[name] = spec
+			for _, e := range rr.paths {
+				e.path.Backend = name
+			}
+			paths = append(paths, rr.paths...)
+		}
+
+		// a route is counted once by a listener and added once to a host
+		// of a server even if it's attached multiple times.
+		counted := map[*listenerInfo]bool{}
+		added := map[*httpServerSpecBuilder]map[string]bool{}
+		for _, a := range attachments {
+			if !counted[a.listener] {
+				counted[a.listener] = true
+				a.listener.attachedRoutes++
+			}
+			server := a.listener.server
+			if entries[server] == nil {
+				entries[server] = map[string][]*pathEntry{}
+			}
+			if added[server] == nil {
+				added[server] = map[string]bool{}
+			}
+			for _, host := range a.hostnames {
+				if !added[server][host] {
+					added[server][host] = true
+					entries[server][host] = append(entries[server][host], paths...)
+				}
+			}
+		}
+	} else {
+		// resolve the references for the status only
+		for _, rr := range r.rules {
+			for i := range rr.backends {
+				st.resolveBackend(r, &rr.backends[i].BackendObjectReference)
+			}
+		}
+	}
+
+	resolved := newCondition(string(gwapisv1beta1.RouteConditionResolvedRefs), true,
+		string(gwapisv1beta1.RouteReasonResolvedRefs), "all references are resolved", r.generation)
+	if r.unresolved != nil {
+		resolved = newCondition(string(gwapisv1beta1.RouteConditionResolvedRefs), false,
+			r.unresolvedReason, r.unresolved.Error(), r.generation)
+	}
+	for i := range parents {
+		parents[i].Conditions = []metav1.Condition{accepted[i], resolved}
+	}
+
+	return parents
+}
+
+func (st *specTranslator) translateRoutes() {
+	entries := map[*httpServerSpecBuilder]map[string][]*pathEntry{}
+
+	httpRoutes := st.k8sClient.getHTTPRoutes()
+	sortObjects(httpRoutes)
+	for _, route := range httpRoutes {
+		r := &routeInfo{}
+		r.convertHTTPRoute(route)
+		parents := st.translateRoute(r, entries)
+		if status := mergeRouteStatus(&route.Status.RouteStatus, parents, st.controllerName); status != nil {
+			route = route.DeepCopy()
+			route.Status.RouteStatus = *status
+			st.httpRouteStatuses = append(st.httpRouteStatuses, route)
+		}
+	}
+
+	grpcRoutes := st.k8sClient.getGRPCRoutes()
+	sortObjects(grpcRoutes)
+	for _, route := range grpcRoutes {
+		r := &routeInfo{}
+		r.convertGRPCRoute(route)
+		parents := st.translateRoute(r, entries)
+		if status := mergeRouteStatus(&route.Status.RouteStatus, parents, st.controllerName); status != nil {
+			route = route.DeepCopy()
+			route.Status.RouteStatus = *status
+			st.grpcRouteStatuses = append(st.grpcRouteStatuses, route)
+		}
+	}
+
+	for server, hosts := range entries {
+		for host, paths := range hosts {
+			sort.SliceStable(paths, func(i, j int) bool {
+				return lessPathEntry(paths[i], paths[j])
+			})
+			server.addPaths(host, paths)
+		}
+	}
+}
+
+func (st *specTranslator) translateGatewayStatus() {
+	for _, info := range st.gateways {
+		gw := info.gateway
+		status := gw.Status.DeepCopy()
+
+		accepted, programmed := false, false
+		status.Listeners = nil
+		for _, li := range info.listeners {
+			accepted = accepted || li.accepted
+			programmed = programmed || li.valid
+
+			var old []metav1.Condition
+			for _, ls := range gw.Status.Listeners {
+				if ls.Name == li.listener.Name {
+					old = ls.Conditions
+				}
+			}
+			status.Listeners = append(status.Listeners, gwapisv1beta1.ListenerStatus{
+				Name:           li.listener.Name,
+				SupportedKinds: li.kinds,
+				AttachedRoutes: li.attachedRoutes,
+				Conditions:     mergeConditions(old, li.conditions),
+			})
+		}
+
+		conds := []metav1.Condition{
+			newCondition(string(gwapisv1beta1.GatewayConditionAccepted), true,
+				string(gwapisv1beta1.GatewayReasonAccepted), "gateway is accepted", gw.Generation),
+			newCondition(string(gwapisv1beta1.GatewayConditionProgrammed), true,
+				string(gwapisv1beta1.GatewayReasonProgrammed), "gateway is programmed", gw.Generation),
+		}
+		if !accepted {
+			conds[0] = newCondition(string(gwapisv1beta1.GatewayConditionAccepted), false,
+				string(gwapisv1beta1.GatewayReasonListenersNotValid), "no valid listeners", gw.Generation)
+		}
+		if !programmed {
+			conds[1] = newCondition(string(gwapisv1beta1.GatewayConditionProgrammed), false,
+				string(gwapisv1beta1.GatewayReasonInvalid), "no valid listeners", gw.Generation)
+		}
+		status.Conditions = mergeConditions(gw.Status.Conditions, conds)
+
+		if !statusEqual(&gw.Status, status) {
+			gw = gw.DeepCopy()
+			gw.Status = *status
+			st.gatewayStatuses = append(st.gatewayStatuses, gw)
+		}
+	}
+}
+
+func (st *specTranslator) translate() error {
+	st.translateGateways()
+	st.translateRoutes()
+	st.translateGatewayStatus()
+
+	for _, b := range st.servers {
+		spec, err := supervisor.NewSpec(b.jsonConfig())
+		if err != nil {
+			return err
+		}
+		st.httpServers[spec.Name()] = spec
+	}
+
+	return nil
+}
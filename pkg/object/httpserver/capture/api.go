@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/megaease/easegress/pkg/api"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/util/har"
+	"github.com/megaease/easegress/pkg/v"
+)
+
+const (
+	// CapturesURL is the path of the captures of an HTTPServer.
+	CapturesURL = "/httpservers/%s/captures"
+	// CaptureURL is the path of a capture of an HTTPServer.
+	CaptureURL = "/httpservers/%s/captures/%s"
+	// CaptureStopURL is the path to stop a capture of an HTTPServer.
+	CaptureStopURL = "/httpservers/%s/captures/%s/stop"
+	// ReplayURL is the path to replay a request against a pipeline.
+	ReplayURL = "/httpservers/%s/replay/%s"
+)
+
+func (m *Manager) apiGroupName() string {
+	return fmt.Sprintf("httpserver_capture_%s", m.name)
+}
+
+// RegisterAPIs registers the admin APIs of the HTTPServer named name.
+func (m *Manager) RegisterAPIs(name string) {
+	m.name = name
+	captureURL := fmt.Sprintf(CaptureURL, name, "{id}")
+
+	group := &api.Group{
+		Group: m.apiGroupName(),
+		Entries: []*api.Entry{
+			{Path: fmt.Sprintf(CapturesURL, name), Method: http.MethodPost, Handler: m.createCapture},
+			{Path: fmt.Sprintf(CapturesURL, name), Method: http.MethodGet, Handler: m.listCaptures},
+			{Path: captureURL, Method: http.MethodGet, Handler: m.getCapture},
+			{Path: captureURL, Method: http.MethodDelete, Handler: m.deleteCapture},
+			{Path: fmt.Sprintf(CaptureStopURL, name, "{id}"), Method: http.MethodPut, Handler: m.stopCapture},
+			{Path: fmt.Sprintf(ReplayURL, name, "{pipeline}"), Method: http.MethodPost, Handler: m.replay},
+		},
+	}
+
+	api.RegisterAPIs(group)
+}
+
+// UnregisterAPIs unregisters the admin APIs.
+func (m *Manager) UnregisterAPIs() {
+	api.UnregisterAPIs(m.apiGroupName())
+}
+
+func (m *Manager) createCapture(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return
+	}
+
+	spec := &Spec{}
+	if err = codectool.Unmarshal(body, spec); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal to json failed: %v", err))
+		return
+	}
+	if vr := v.Validate(spec); !vr.Valid() {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("validate failed: \n%s", vr.Error()))
+		return
+	}
+
+	c, err := m.Create(spec)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%s", r.URL.Path, c.id))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(codectool.MustMarshalJSON(c.Info()))
+}
+
+func (m *Manager) listCaptures(w http.ResponseWriter, r *http.Request) {
+	infos := m.List()
+	sort.Slice(infos, func(i, j int) bool {
+		if len(infos[i].ID) != len(infos[j].ID) {
+			return len(infos[i].ID) < len(infos[j].ID)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	api.WriteBody(w, r, infos)
+}
+
+func (m *Manager) getCapture(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, ok := m.Get(id)
+	if !ok {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("capture %s not found", id))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-capture-%s.har", m.name, id))
+	api.WriteBody(w, r, c.HAR())
+}
+
+func (m *Manager) stopCapture(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, ok := m.Get(id)
+	if !ok {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("capture %s not found", id))
+		return
+	}
+	c.Stop()
+}
+
+func (m *Manager) deleteCapture(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !m.Delete(id) {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("capture %s not found", id))
+	}
+}
+
+// replay sends the HAR request in the body to the pipeline, and returns
+// the response of the pipeline in HAR format.
+func (m *Manager) replay(w http.ResponseWriter, r *http.Request) {
+	pipeline := chi.URLParam(r, "pipeline")
+
+	req := &har.Request{}
+	if err := codectool.DecodeJSON(r.Body, req); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid har request: %v", err))
+		return
+	}
+
+	resp, err := m.Replay(pipeline, req)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	api.WriteBody(w, r, resp)
+}
+
+// Replay sends the request to the pipeline and returns its response.
+func (m *Manager) Replay(pipeline string, req *har.Request) (*har.Response, error) {
+	mapper := m.getMapper()
+	if mapper == nil {
+		return nil, fmt.Errorf("pipeline %s not found", pipeline)
+	}
+	handler, ok := mapper.GetHandler(pipeline)
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s not found", pipeline)
+	}
+
+	stdr, err := req.StdRequest("")
+	if err != nil {
+		return nil, err
+	}
+	httpreq, _ := httpprot.NewRequest(stdr)
+	if err = httpreq.FetchPayload(0); err != nil {
+		return nil, fmt.Errorf("read body of request failed: %v", err)
+	}
+
+	ctx := context.New(tracing.NoopSpan)
+	defer ctx.Finish()
+	ctx.SetRequest(context.DefaultNamespace, httpreq)
+	handler.Handle(ctx)
+
+	resp, _ := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+	if resp == nil {
+		return nil, fmt.Errorf("pipeline %s returned no HTTP response", pipeline)
+	}
+
+	return har.NewResponse(&http.Response{
+		StatusCode: resp.StatusCode(),
+		Proto:      resp.Std().Proto,
+		Header:     resp.HTTPHeader(),
+		Body:       io.NopCloser(resp.GetPayload()),
+	}, nil)
+}
@@ -0,0 +1,443 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package capture implements the traffic capture of HTTPServer.
+//
+// Captures are created through the admin API of a member and only record
+// the traffic of the HTTPServer in that member. The captured requests and
+// responses are kept in memory in HAR format until the capture is deleted.
+package capture
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/har"
+	"github.com/megaease/easegress/pkg/version"
+)
+
+const (
+	// maxCaptures is the max number of captures of an HTTPServer,
+	// including the stopped ones.
+	maxCaptures = 16
+
+	stateRunning = "running"
+	stateStopped = "stopped"
+)
+
+type (
+	// Manager manages the captures of an HTTPServer.
+	Manager struct {
+		name      string
+		getMapper func() context.MuxMapper
+
+		mutex    sync.RWMutex
+		captures map[string]*Capture
+		nextID   uint64
+		// running is the number of running captures, it is checked for
+		// every request, so it is an atomic counter.
+		running int32
+	}
+
+	// Capture records the requests and responses matching its spec.
+	Capture struct {
+		id      string
+		spec    *Spec
+		redact  map[string]struct{}
+		startAt time.Time
+		timer   *time.Timer
+		onStop  func()
+
+		mutex   sync.Mutex
+		stopped bool
+		stopAt  time.Time
+		entries []*har.Entry
+		// pending is the number of tapped requests not finished yet.
+		pending int
+	}
+
+	// Info is the information of a capture.
+	Info struct {
+		ID        string `json:"id"`
+		Spec      *Spec  `json:"spec"`
+		State     string `json:"state"`
+		StartedAt string `json:"startedAt"`
+		StoppedAt string `json:"stoppedAt,omitempty"`
+		Count     int    `json:"count"`
+	}
+
+	// Tap is a request being captured by one or more captures.
+	Tap struct {
+		captures []*Capture
+		startAt  time.Time
+		backend  string
+
+		method  string
+		url     string
+		proto   string
+		header  http.Header
+		body    []byte
+		bodyLen int64
+		stream  bool
+
+		statusCode int
+		respHeader http.Header
+		respBody   []byte
+		respLen    int64
+		respStream bool
+	}
+)
+
+// NewManager creates a Manager, getMapper returns the mux mapper to look
+// up the pipelines when replaying requests.
+func NewManager(getMapper func() context.MuxMapper) *Manager {
+	return &Manager{
+		getMapper: getMapper,
+		captures:  make(map[string]*Capture),
+	}
+}
+
+// Running returns whether there are running captures.
+func (m *Manager) Running() bool {
+	return atomic.LoadInt32(&m.running) > 0
+}
+
+// Create creates and starts a capture.
+func (m *Manager) Create(spec *Spec) (*Capture, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	spec.init()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.captures) >= maxCaptures {
+		return nil, fmt.Errorf("too many captures, delete some of them first")
+	}
+
+	m.nextID++
+	c := &Capture{
+		id:      fmt.Sprintf("%d", m.nextID),
+		spec:    spec,
+		redact:  spec.redactHeaders(),
+		startAt: time.Now(),
+		onStop:  func() { atomic.AddInt32(&m.running, -1) },
+	}
+	// NOTE: The lock makes sure Stop always sees the timer.
+	c.mutex.Lock()
+	c.timer = time.AfterFunc(spec.duration(), c.Stop)
+	c.mutex.Unlock()
+
+	m.captures[c.id] = c
+	atomic.AddInt32(&m.running, 1)
+	return c, nil
+}
+
+// Get gets a capture by id.
+func (m *Manager) Get(id string) (*Capture, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	c, ok := m.captures[id]
+	return c, ok
+}
+
+// List lists the information of all captures.
+func (m *Manager) List() []*Info {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	infos := make([]*Info, 0, len(m.captures))
+	for _, c := range m.captures {
+		infos = append(infos, c.Info())
+	}
+	return infos
+}
+
+// Delete stops and deletes a capture.
+func (m *Manager) Delete(id string) bool {
+	m.mutex.Lock()
+	c, ok := m.captures[id]
+	delete(m.captures, id)
+	m.mutex.Unlock()
+
+	if ok {
+		c.Stop()
+	}
+	return ok
+}
+
+// Close stops all captures.
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	captures := m.captures
+	m.captures = make(map[string]*Capture)
+	m.mutex.Unlock()
+
+	for _, c := range captures {
+		c.Stop()
+	}
+}
+
+// Tap starts to capture the request if it matches any running capture,
+// it returns nil otherwise. The caller should check Running first to
+// avoid the cost of locking for every request.
+//
+// It must be called before the request is modified by the route and
+// pipelines, so the original request is captured.
+func (m *Manager) Tap(req *httpprot.Request, backend string) *Tap {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var captures []*Capture
+	for _, c := range m.captures {
+		if c.match(req, backend) && c.acquire() {
+			captures = append(captures, c)
+		}
+	}
+	if len(captures) == 0 {
+		return nil
+	}
+
+	stdr := req.Std()
+	header := stdr.Header.Clone()
+	header.Set("Host", stdr.Host)
+
+	return &Tap{
+		captures: captures,
+		startAt:  time.Now(),
+		backend:  backend,
+		method:   stdr.Method,
+		url:      req.Scheme() + "://" + stdr.Host + stdr.URL.RequestURI(),
+		proto:    stdr.Proto,
+		header:   header,
+	}
+}
+
+func (c *Capture) match(req *httpprot.Request, backend string) bool {
+	spec := c.spec
+	if len(spec.Backends) > 0 && !containsString(spec.Backends, backend) {
+		return false
+	}
+	if spec.PathPrefix != "" && !strings.HasPrefix(req.Path(), spec.PathPrefix) {
+		return false
+	}
+	if len(spec.Methods) > 0 && !containsString(spec.Methods, req.Method()) {
+		return false
+	}
+	for k, m := range spec.Headers {
+		if !m.MatchAny(req.HTTPHeader().Values(k)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire reserves a slot for a tapped request, so that the capture
+// never records more than MaxCount entries.
+func (c *Capture) acquire() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stopped || len(c.entries)+c.pending >= c.spec.MaxCount {
+		return false
+	}
+	c.pending++
+	return true
+}
+
+func (c *Capture) record(entry *har.Entry) {
+	c.mutex.Lock()
+	c.pending--
+	if !c.stopped {
+		c.entries = append(c.entries, entry)
+	}
+	full := len(c.entries) >= c.spec.MaxCount
+	c.mutex.Unlock()
+
+	if full {
+		c.Stop()
+	}
+}
+
+// Stop stops the capture, the captured entries are kept.
+func (c *Capture) Stop() {
+	c.mutex.Lock()
+	if c.stopped {
+		c.mutex.Unlock()
+		return
+	}
+	c.stopped = true
+	c.stopAt = time.Now()
+	timer := c.timer
+	c.mutex.Unlock()
+
+	if timer != nil {
+		timer.Stop()
+	}
+	c.onStop()
+}
+
+// Info returns the information of the capture.
+func (c *Capture) Info() *Info {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	info := &Info{
+		ID:        c.id,
+		Spec:      c.spec,
+		State:     stateRunning,
+		StartedAt: c.startAt.Format(time.RFC3339),
+		Count:     len(c.entries),
+	}
+	if c.stopped {
+		info.State = stateStopped
+		info.StoppedAt = c.stopAt.Format(time.RFC3339)
+	}
+	return info
+}
+
+// HAR returns the captured entries in HAR format.
+func (c *Capture) HAR() *har.HAR {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	h := har.New("Easegress", version.RELEASE)
+	h.Log.Entries = append(h.Log.Entries, c.entries...)
+	h.Log.Comment = fmt.Sprintf("capture %s started at %s", c.id, c.startAt.Format(time.RFC3339))
+	return h
+}
+
+// SetRequestBody saves the request body, it should be called after the
+// payload of the request is fetched.
+func (t *Tap) SetRequestBody(req *httpprot.Request) {
+	if req.IsStream() {
+		t.stream = true
+		return
+	}
+	t.body = req.RawPayload()
+	t.bodyLen = int64(len(t.body))
+}
+
+// SetResponse saves the response sent to the client, resp could be nil
+// if the connection was hijacked.
+func (t *Tap) SetResponse(statusCode int, header http.Header, resp *httpprot.Response) {
+	t.statusCode = statusCode
+	t.respHeader = header
+	if resp == nil {
+		return
+	}
+	if resp.IsStream() {
+		t.respStream = true
+		return
+	}
+	t.respBody = resp.RawPayload()
+	t.respLen = int64(len(t.respBody))
+}
+
+// Finish records the request and response to the captures.
+func (t *Tap) Finish(statusCode int) {
+	if t.statusCode == 0 {
+		t.statusCode = statusCode
+	}
+	duration := float64(time.Since(t.startAt).Microseconds()) / 1000
+
+	for _, c := range t.captures {
+		c.record(t.entry(c, duration))
+	}
+}
+
+func (t *Tap) entry(c *Capture, duration float64) *har.Entry {
+	req := &har.Request{
+		Method:      t.method,
+		URL:         t.url,
+		HTTPVersion: t.proto,
+		Cookies:     []*har.NameValue{},
+		Headers:     har.NameValues(t.header, c.redact),
+		QueryString: []*har.NameValue{},
+		HeadersSize: -1,
+		BodySize:    t.bodyLen,
+	}
+	if u, err := url.Parse(t.url); err == nil {
+		req.QueryString = har.NameValues(http.Header(u.Query()), nil)
+	}
+	if t.stream {
+		req.BodySize = -1
+		req.Comment = "body is not captured because it is a stream"
+	} else if len(t.body) > 0 {
+		body, truncated := truncate(t.body, c.spec.MaxBodySize)
+		text, encoding := har.EncodeBody(body)
+		req.PostData = &har.PostData{
+			MimeType:  t.header.Get("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: truncated,
+		}
+	}
+
+	resp := &har.Response{
+		Status:      t.statusCode,
+		StatusText:  http.StatusText(t.statusCode),
+		HTTPVersion: t.proto,
+		Cookies:     []*har.NameValue{},
+		Headers:     har.NameValues(t.respHeader, c.redact),
+		Content:     &har.Content{Size: t.respLen, MimeType: t.respHeader.Get("Content-Type")},
+		HeadersSize: -1,
+		BodySize:    t.respLen,
+	}
+	if t.respStream {
+		resp.BodySize = -1
+		resp.Comment = "body is not captured because it is a stream"
+	} else {
+		body, truncated := truncate(t.respBody, c.spec.MaxBodySize)
+		resp.Content.Text, resp.Content.Encoding = har.EncodeBody(body)
+		resp.Content.Truncated = truncated
+	}
+
+	return &har.Entry{
+		StartedDateTime: t.startAt.Format(time.RFC3339Nano),
+		Time:            duration,
+		Request:         req,
+		Response:        resp,
+		Timings:         &har.Timings{Send: -1, Wait: duration, Receive: -1},
+		Backend:         t.backend,
+	}
+}
+
+func truncate(body []byte, maxSize int64) ([]byte, bool) {
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return body[:maxSize], true
+	}
+	return body, false
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/har"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newRequest(t *testing.T, method, url, body string) *httpprot.Request {
+	stdr, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	stdr.Header.Set("Authorization", "secret")
+	stdr.Header.Set("X-Debug", "true")
+	req, _ := httpprot.NewRequest(stdr)
+	assert.Nil(t, req.FetchPayload(0))
+	return req
+}
+
+func serve(m *Manager, req *httpprot.Request, backend string) {
+	tap := m.Tap(req, backend)
+	if tap == nil {
+		return
+	}
+	tap.SetRequestBody(req)
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(http.StatusCreated)
+	resp.HTTPHeader().Set("Set-Cookie", "a=b")
+	resp.SetPayload([]byte("0123456789"))
+	tap.SetResponse(resp.StatusCode(), resp.HTTPHeader(), resp)
+	tap.Finish(resp.StatusCode())
+}
+
+func TestCapture(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewManager(func() context.MuxMapper { return nil })
+	assert.False(m.Running())
+
+	_, err := m.Create(&Spec{Duration: "2h"})
+	assert.NotNil(err)
+
+	c, err := m.Create(&Spec{
+		Backends:    []string{"pipeline-a"},
+		PathPrefix:  "/api",
+		Headers:     map[string]*stringtool.StringMatcher{"X-Debug": {Exact: "true"}},
+		MaxCount:    2,
+		MaxBodySize: 4,
+	})
+	assert.Nil(err)
+	assert.True(m.Running())
+
+	// not matched
+	serve(m, newRequest(t, http.MethodGet, "http://example.com/api/users", ""), "pipeline-b")
+	serve(m, newRequest(t, http.MethodGet, "http://example.com/web", ""), "pipeline-a")
+	assert.Equal(0, c.Info().Count)
+
+	serve(m, newRequest(t, http.MethodPost, "http://example.com/api/users?id=1", "hello"), "pipeline-a")
+	serve(m, newRequest(t, http.MethodGet, "http://example.com/api/users", ""), "pipeline-a")
+	serve(m, newRequest(t, http.MethodGet, "http://example.com/api/users", ""), "pipeline-a")
+
+	// stopped after reaching the max count
+	info := c.Info()
+	assert.Equal(2, info.Count)
+	assert.Equal(stateStopped, info.State)
+	assert.False(m.Running())
+
+	h := c.HAR()
+	assert.Len(h.Log.Entries, 2)
+	entry := h.Log.Entries[0]
+	assert.Equal("pipeline-a", entry.Backend)
+	assert.Equal("http://example.com/api/users?id=1", entry.Request.URL)
+	assert.Equal("1", har.Header(entry.Request.QueryString).Get("id"))
+
+	header := har.Header(entry.Request.Headers)
+	assert.Equal("[REDACTED]", header.Get("Authorization"))
+	assert.Equal("true", header.Get("X-Debug"))
+	assert.Equal("example.com", header.Get("Host"))
+	assert.Equal("hell", entry.Request.PostData.Text)
+	assert.True(entry.Request.PostData.Truncated)
+
+	assert.Equal(http.StatusCreated, entry.Response.Status)
+	assert.Equal("[REDACTED]", har.Header(entry.Response.Headers).Get("Set-Cookie"))
+	assert.Equal("0123", entry.Response.Content.Text)
+	assert.Equal(int64(10), entry.Response.Content.Size)
+
+	assert.Len(m.List(), 1)
+	assert.True(m.Delete(c.id))
+	assert.False(m.Delete(c.id))
+	assert.Len(m.List(), 0)
+}
+
+func TestCaptureDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewManager(func() context.MuxMapper { return nil })
+	c, err := m.Create(&Spec{Duration: "10ms"})
+	assert.Nil(err)
+	assert.True(m.Running())
+
+	assert.Eventually(func() bool { return !m.Running() }, time.Second, 10*time.Millisecond)
+	assert.Equal(stateStopped, c.Info().State)
+
+	serve(m, newRequest(t, http.MethodGet, "http://example.com/", ""), "pipeline")
+	assert.Equal(0, c.Info().Count)
+
+	m.Close()
+	assert.Len(m.List(), 0)
+}
+
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	mapper := &contexttest.MockedMuxMapper{
+		MockedGetHandler: func(name string) (context.Handler, bool) {
+			if name != "pipeline" {
+				return nil, false
+			}
+			return &contexttest.MockedHandler{
+				MockedHandle: func(ctx *context.Context) string {
+					req := ctx.GetInputRequest().(*httpprot.Request)
+					resp, _ := httpprot.NewResponse(nil)
+					resp.SetPayload(append([]byte("echo "), req.RawPayload()...))
+					ctx.SetOutputResponse(resp)
+					return ""
+				},
+			}, true
+		},
+	}
+	m := NewManager(func() context.MuxMapper { return mapper })
+
+	req := &har.Request{
+		Method:   http.MethodPost,
+		URL:      "http://example.com/echo",
+		PostData: &har.PostData{Text: "hello"},
+	}
+
+	resp, err := m.Replay("pipeline", req)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.Status)
+	assert.Equal("echo hello", resp.Content.Text)
+
+	_, err = m.Replay("unknown", req)
+	assert.NotNil(err)
+}
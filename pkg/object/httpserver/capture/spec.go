@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package capture
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	defaultDuration    = 5 * time.Minute
+	maxDuration        = time.Hour
+	defaultMaxCount    = 100
+	maxMaxCount        = 10000
+	defaultMaxBodySize = 64 * 1024
+	maxMaxBodySize     = 4 * 1024 * 1024
+)
+
+// defaultRedactHeaders are the headers always redacted.
+var defaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+// Spec describes a capture, a request is captured only if it matches
+// all the non-empty predicates.
+type Spec struct {
+	// Backends are the names of the pipelines matched by the routes.
+	Backends   []string                             `json:"backends,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+	PathPrefix string                               `json:"pathPrefix,omitempty" jsonschema:"omitempty,pattern=^/"`
+	Methods    []string                             `json:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	Headers    map[string]*stringtool.StringMatcher `json:"headers,omitempty" jsonschema:"omitempty"`
+
+	// Duration and MaxCount bound the capture, it stops once any of
+	// them is reached.
+	Duration string `json:"duration,omitempty" jsonschema:"omitempty,format=duration"`
+	MaxCount int    `json:"maxCount,omitempty" jsonschema:"omitempty,minimum=1"`
+	// MaxBodySize is the max size of the captured request and response
+	// bodies, larger bodies are truncated.
+	MaxBodySize int64 `json:"maxBodySize,omitempty" jsonschema:"omitempty,minimum=0"`
+
+	// RedactHeaders are redacted in addition to the default ones:
+	// Authorization, Proxy-Authorization, Cookie, Set-Cookie and X-Api-Key.
+	RedactHeaders []string `json:"redactHeaders,omitempty" jsonschema:"omitempty"`
+}
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if spec.Duration != "" {
+		d, err := time.ParseDuration(spec.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration %s: %v", spec.Duration, err)
+		}
+		if d <= 0 || d > maxDuration {
+			return fmt.Errorf("duration should be in (0, %s]", maxDuration)
+		}
+	}
+
+	if spec.MaxCount > maxMaxCount {
+		return fmt.Errorf("maxCount should not be greater than %d", maxMaxCount)
+	}
+	if spec.MaxBodySize > maxMaxBodySize {
+		return fmt.Errorf("maxBodySize should not be greater than %d", maxMaxBodySize)
+	}
+
+	for k, m := range spec.Headers {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("header %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func (spec *Spec) init() {
+	if spec.Duration == "" {
+		spec.Duration = defaultDuration.String()
+	}
+	if spec.MaxCount == 0 {
+		spec.MaxCount = defaultMaxCount
+	}
+	if spec.MaxBodySize == 0 {
+		spec.MaxBodySize = defaultMaxBodySize
+	}
+	for _, m := range spec.Headers {
+		m.Init()
+	}
+}
+
+func (spec *Spec) duration() time.Duration {
+	d, _ := time.ParseDuration(spec.Duration)
+	return d
+}
+
+func (spec *Spec) redactHeaders() map[string]struct{} {
+	redact := make(map[string]struct{}, len(defaultRedactHeaders)+len(spec.RedactHeaders))
+	for _, h := range defaultRedactHeaders {
+		redact[h] = struct{}{}
+	}
+	for _, h := range spec.RedactHeaders {
+		redact[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	return redact
+}
@@ -29,6 +29,7 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/megaease/easegress/pkg/object/httpserver/capture"
 	"github.com/megaease/easegress/pkg/object/httpserver/routers"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -56,6 +57,7 @@ type (
 	mux struct {
 		httpStat *httpstat.HTTPStat
 		topN     *httpstat.TopN
+		captures *capture.Manager
 
 		inst atomic.Value // *muxInstance
 	}
@@ -65,6 +67,7 @@ type (
 		spec               *Spec
 		httpStat           *httpstat.HTTPStat
 		topN               *httpstat.TopN
+		captures           *capture.Manager
 		metrics            *metrics
 		accessLogFormatter *accessLogFormatter
 
@@ -135,12 +138,17 @@ func newMux(httpStat *httpstat.HTTPStat, topN *httpstat.TopN,
 		topN:     topN,
 	}
 
+	m.captures = capture.NewManager(func() context.MuxMapper {
+		return m.inst.Load().(*muxInstance).muxMapper
+	})
+
 	m.inst.Store(&muxInstance{
 		spec:      &Spec{},
 		tracer:    tracing.NoopTracer,
 		muxMapper: mapper,
 		httpStat:  httpStat,
 		topN:      topN,
+		captures:  m.captures,
 		metrics:   metrics,
 	})
 
@@ -180,6 +188,7 @@ func (m *mux) reload(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
 		muxMapper:          muxMapper,
 		httpStat:           m.httpStat,
 		topN:               m.topN,
+		captures:           m.captures,
 		metrics:            oldInst.metrics,
 		ipFilter:           ipfilter.New(spec.IPFilter),
 		tracer:             tracer,
@@ -268,12 +277,17 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	routeCtx := routers.NewContext(req)
 	route := mi.search(routeCtx)
 	var respHeader http.Header
+	var tap *capture.Tap
 
 	defer func() {
 		metric, _ := ctx.GetData("HTTP_METRIC").(*httpstat.Metric)
 
 		if metric == nil {
 			statusCode, respSize, header := mi.sendResponse(ctx, stdw)
+			if tap != nil {
+				resp, _ := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+				tap.SetResponse(statusCode, header, resp)
+			}
 			ctx.Finish()
 
 			// Drain off the body if it has not been, so that we can get the
@@ -291,6 +305,9 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		}
 
 		metric.Duration = fasttime.Since(startAt)
+		if tap != nil {
+			tap.Finish(metric.StatusCode)
+		}
 		topN.Stat(metric)
 		mi.httpStat.Stat(metric)
 		if route.code == 0 {
@@ -335,6 +352,12 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	}
 	logger.Debugf("%s: the matched backend(Pipeline) for [%s %s] is %q", mi.superSpec.Name(), req.Method(), req.RequestURI, backend)
 
+	// Tap the request before the rewrite, so that the original request
+	// is captured.
+	if mi.captures.Running() {
+		tap = mi.captures.Tap(req, backend)
+	}
+
 	route.route.Rewrite(routeCtx)
 	if mi.spec.XForwardedFor {
 		appendXForwardedFor(req)
@@ -355,6 +378,9 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		buildFailureResponse(ctx, http.StatusBadRequest)
 		return
 	}
+	if tap != nil {
+		tap.SetRequestBody(req)
+	}
 
 	// global filter
 	globalFilter := mi.getGlobalFilter()
@@ -447,6 +473,7 @@ func (mi *muxInstance) close() {
 }
 
 func (m *mux) close() {
+	m.captures.Close()
 	m.inst.Load().(*muxInstance).close()
 }
 
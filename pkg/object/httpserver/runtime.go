@@ -117,6 +117,7 @@ func newRuntime(superSpec *supervisor.Spec, muxMapper context.MuxMapper) *runtim
 
 	r.metrics = r.newMetrics(r.superSpec.Name())
 	r.mux = newMux(r.httpStat, r.topN, r.metrics, muxMapper)
+	r.mux.captures.RegisterAPIs(superSpec.Name())
 	r.setState(stateNil)
 	r.setError(errNil)
 
@@ -396,6 +397,7 @@ func (r *runtime) handleEventReload(e *eventReload) {
 func (r *runtime) handleEventClose(e *eventClose) {
 	r.setState(stateClosed)
 	r.closeServer()
+	r.mux.captures.UnregisterAPIs()
 	r.mux.close()
 	close(e.done)
 }
@@ -0,0 +1,522 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ingresscontroller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/filters/proxies"
+	proxy "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
+	apinetv1 "k8s.io/api/networking/v1"
+)
+
+const annotationPrefix = "easegress.ingress.kubernetes.io/"
+
+// annotations supported by the IngressController, please keep them in
+// sync with the document.
+const (
+	annotationWebSocket              = annotationPrefix + "websocket"
+	annotationWebSocketDefaultOrigin = annotationPrefix + "websocket-default-origin"
+	annotationRewriteTarget          = annotationPrefix + "rewrite-target"
+
+	annotationRateLimitRPS     = annotationPrefix + "rate-limit-rps"
+	annotationRateLimitTimeout = annotationPrefix + "rate-limit-timeout"
+
+	annotationEnableCORS           = annotationPrefix + "enable-cors"
+	annotationCORSAllowOrigins     = annotationPrefix + "cors-allow-origins"
+	annotationCORSAllowMethods     = annotationPrefix + "cors-allow-methods"
+	annotationCORSAllowHeaders     = annotationPrefix + "cors-allow-headers"
+	annotationCORSExposeHeaders    = annotationPrefix + "cors-expose-headers"
+	annotationCORSAllowCredentials = annotationPrefix + "cors-allow-credentials"
+	annotationCORSMaxAge           = annotationPrefix + "cors-max-age"
+
+	annotationAuthType            = annotationPrefix + "auth-type"
+	annotationAuthBasicUserFile   = annotationPrefix + "auth-basic-user-file"
+	annotationAuthBasicEtcdPrefix = annotationPrefix + "auth-basic-etcd-prefix"
+	annotationAuthJWTAlgorithm    = annotationPrefix + "auth-jwt-algorithm"
+	annotationAuthJWTSecret       = annotationPrefix + "auth-jwt-secret"
+	annotationAuthJWTCookieName   = annotationPrefix + "auth-jwt-cookie-name"
+
+	annotationProxyTimeout              = annotationPrefix + "proxy-timeout"
+	annotationRetryMaxAttempts          = annotationPrefix + "retry-max-attempts"
+	annotationRetryWaitDuration         = annotationPrefix + "retry-wait-duration"
+	annotationCircuitBreakerFailureRate = annotationPrefix + "circuit-breaker-failure-rate"
+	annotationCircuitBreakerWindowSize  = annotationPrefix + "circuit-breaker-window-size"
+	annotationCircuitBreakerOpenTime    = annotationPrefix + "circuit-breaker-open-duration"
+
+	annotationLoadBalance           = annotationPrefix + "load-balance"
+	annotationLoadBalanceHashHeader = annotationPrefix + "load-balance-hash-header"
+
+	annotationCanaryService     = annotationPrefix + "canary-service"
+	annotationCanaryWeight      = annotationPrefix + "canary-weight"
+	annotationCanaryHeader      = annotationPrefix + "canary-header"
+	annotationCanaryHeaderValue = annotationPrefix + "canary-header-value"
+)
+
+var knownAnnotations = map[string]bool{
+	annotationWebSocket:                 true,
+	annotationWebSocketDefaultOrigin:    true,
+	annotationRewriteTarget:             true,
+	annotationRateLimitRPS:              true,
+	annotationRateLimitTimeout:          true,
+	annotationEnableCORS:                true,
+	annotationCORSAllowOrigins:          true,
+	annotationCORSAllowMethods:          true,
+	annotationCORSAllowHeaders:          true,
+	annotationCORSExposeHeaders:         true,
+	annotationCORSAllowCredentials:      true,
+	annotationCORSMaxAge:                true,
+	annotationAuthType:                  true,
+	annotationAuthBasicUserFile:         true,
+	annotationAuthBasicEtcdPrefix:       true,
+	annotationAuthJWTAlgorithm:          true,
+	annotationAuthJWTSecret:             true,
+	annotationAuthJWTCookieName:         true,
+	annotationProxyTimeout:              true,
+	annotationRetryMaxAttempts:          true,
+	annotationRetryWaitDuration:         true,
+	annotationCircuitBreakerFailureRate: true,
+	annotationCircuitBreakerWindowSize:  true,
+	annotationCircuitBreakerOpenTime:    true,
+	annotationLoadBalance:               true,
+	annotationLoadBalanceHashHeader:     true,
+	annotationCanaryService:             true,
+	annotationCanaryWeight:              true,
+	annotationCanaryHeader:              true,
+	annotationCanaryHeaderValue:         true,
+}
+
+type (
+	// ingressAnnotations is the parsed result of the annotations of an
+	// ingress, a nil field means the feature is not enabled.
+	ingressAnnotations struct {
+		webSocket              bool
+		webSocketDefaultOrigin string
+		rewriteTarget          string
+
+		rateLimit      *rateLimitAnnotation
+		cors           *corsAnnotation
+		basicAuth      *basicAuthAnnotation
+		jwtAuth        *jwtAuthAnnotation
+		timeout        string
+		retry          *retryAnnotation
+		circuitBreaker *circuitBreakerAnnotation
+		loadBalance    *proxy.LoadBalanceSpec
+		canary         *canaryAnnotation
+	}
+
+	rateLimitAnnotation struct {
+		rps     int
+		timeout string
+	}
+
+	corsAnnotation struct {
+		allowOrigins     []string
+		allowMethods     []string
+		allowHeaders     []string
+		exposeHeaders    []string
+		allowCredentials bool
+		maxAge           int
+	}
+
+	basicAuthAnnotation struct {
+		userFile   string
+		etcdPrefix string
+	}
+
+	jwtAuthAnnotation struct {
+		algorithm  string
+		secret     string
+		cookieName string
+	}
+
+	retryAnnotation struct {
+		maxAttempts  int
+		waitDuration string
+	}
+
+	circuitBreakerAnnotation struct {
+		failureRate  int
+		windowSize   int
+		openDuration string
+	}
+
+	canaryAnnotation struct {
+		service     *apinetv1.IngressServiceBackend
+		permil      uint32
+		header      string
+		headerValue string
+	}
+
+	// annotationParser parses annotations and collects the errors.
+	annotationParser struct {
+		annotations map[string]string
+		errs        []error
+	}
+)
+
+func (p *annotationParser) errorf(key string, format string, args ...interface{}) {
+	err := fmt.Errorf("annotation %s: %s", key, fmt.Sprintf(format, args...))
+	p.errs = append(p.errs, err)
+}
+
+func (p *annotationParser) has(key string) bool {
+	_, ok := p.annotations[key]
+	return ok
+}
+
+func (p *annotationParser) getString(key string) string {
+	return strings.TrimSpace(p.annotations[key])
+}
+
+func (p *annotationParser) getBool(key string) bool {
+	v := p.getString(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.errorf(key, "invalid boolean value %q", v)
+		return false
+	}
+	return b
+}
+
+// getInt returns the value of key as an integer, ok is false if the key
+// does not exist or its value is invalid.
+func (p *annotationParser) getInt(key string, min, max int) (int, bool) {
+	v := p.getString(key)
+	if v == "" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		p.errorf(key, "invalid integer value %q", v)
+		return 0, false
+	}
+	if i < min || i > max {
+		p.errorf(key, "value %d is out of range [%d, %d]", i, min, max)
+		return 0, false
+	}
+	return i, true
+}
+
+// getDuration returns the value of key if it is a valid positive
+// duration, otherwise an empty string.
+func (p *annotationParser) getDuration(key string) string {
+	v := p.getString(key)
+	if v == "" {
+		return ""
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		p.errorf(key, "invalid duration %q", v)
+		return ""
+	}
+	return v
+}
+
+func (p *annotationParser) getList(key string) []string {
+	var result []string
+	for _, s := range strings.Split(p.annotations[key], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// requires reports the keys which are specified without the key they
+// depend on.
+func (p *annotationParser) requires(key string, dependents ...string) {
+	for _, d := range dependents {
+		if p.has(d) {
+			p.errorf(d, "%s is required", key)
+		}
+	}
+}
+
+func (p *annotationParser) parseRateLimit() *rateLimitAnnotation {
+	rps, ok := p.getInt(annotationRateLimitRPS, 1, 1<<20)
+	if !ok {
+		p.requires(annotationRateLimitRPS, annotationRateLimitTimeout)
+		return nil
+	}
+	return &rateLimitAnnotation{
+		rps:     rps,
+		timeout: p.getDuration(annotationRateLimitTimeout),
+	}
+}
+
+func (p *annotationParser) parseCORS() *corsAnnotation {
+	if !p.getBool(annotationEnableCORS) {
+		p.requires(annotationEnableCORS, annotationCORSAllowOrigins, annotationCORSAllowMethods,
+			annotationCORSAllowHeaders, annotationCORSExposeHeaders, annotationCORSAllowCredentials,
+			annotationCORSMaxAge)
+		return nil
+	}
+
+	cors := &corsAnnotation{
+		allowOrigins:     p.getList(annotationCORSAllowOrigins),
+		allowHeaders:     p.getList(annotationCORSAllowHeaders),
+		exposeHeaders:    p.getList(annotationCORSExposeHeaders),
+		allowCredentials: p.getBool(annotationCORSAllowCredentials),
+	}
+	if len(cors.allowOrigins) == 0 {
+		cors.allowOrigins = []string{"*"}
+	}
+	for _, m := range p.getList(annotationCORSAllowMethods) {
+		m = strings.ToUpper(m)
+		if !isHTTPMethod(m) {
+			p.errorf(annotationCORSAllowMethods, "invalid method %q", m)
+			continue
+		}
+		cors.allowMethods = append(cors.allowMethods, m)
+	}
+	cors.maxAge, _ = p.getInt(annotationCORSMaxAge, 0, 1<<30)
+	return cors
+}
+
+func isHTTPMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (p *annotationParser) parseAuth() (*basicAuthAnnotation, *jwtAuthAnnotation) {
+	basicKeys := []string{annotationAuthBasicUserFile, annotationAuthBasicEtcdPrefix}
+	jwtKeys := []string{annotationAuthJWTAlgorithm, annotationAuthJWTSecret, annotationAuthJWTCookieName}
+
+	switch typ := p.getString(annotationAuthType); typ {
+	case "":
+		p.requires(annotationAuthType, append(basicKeys, jwtKeys...)...)
+		return nil, nil
+
+	case "basic":
+		p.requires(annotationAuthType+": jwt", jwtKeys...)
+		auth := &basicAuthAnnotation{
+			userFile:   p.getString(annotationAuthBasicUserFile),
+			etcdPrefix: p.getString(annotationAuthBasicEtcdPrefix),
+		}
+		if (auth.userFile == "") == (auth.etcdPrefix == "") {
+			p.errorf(annotationAuthType, "one and only one of %s and %s is required",
+				annotationAuthBasicUserFile, annotationAuthBasicEtcdPrefix)
+			return nil, nil
+		}
+		return auth, nil
+
+	case "jwt":
+		p.requires(annotationAuthType+": basic", basicKeys...)
+		auth := &jwtAuthAnnotation{
+			algorithm:  p.getString(annotationAuthJWTAlgorithm),
+			secret:     p.getString(annotationAuthJWTSecret),
+			cookieName: p.getString(annotationAuthJWTCookieName),
+		}
+		if auth.algorithm == "" {
+			auth.algorithm = "HS256"
+		}
+		switch auth.algorithm {
+		case "HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA":
+		default:
+			p.errorf(annotationAuthJWTAlgorithm, "unsupported algorithm %q", auth.algorithm)
+			return nil, nil
+		}
+		if auth.secret == "" {
+			p.errorf(annotationAuthType, "%s is required", annotationAuthJWTSecret)
+			return nil, nil
+		}
+		return nil, auth
+
+	default:
+		p.errorf(annotationAuthType, "unsupported auth type %q, must be basic or jwt", typ)
+		return nil, nil
+	}
+}
+
+func (p *annotationParser) parseRetry() *retryAnnotation {
+	n, ok := p.getInt(annotationRetryMaxAttempts, 1, 100)
+	if !ok {
+		p.requires(annotationRetryMaxAttempts, annotationRetryWaitDuration)
+		return nil
+	}
+	return &retryAnnotation{
+		maxAttempts:  n,
+		waitDuration: p.getDuration(annotationRetryWaitDuration),
+	}
+}
+
+func (p *annotationParser) parseCircuitBreaker() *circuitBreakerAnnotation {
+	rate, ok := p.getInt(annotationCircuitBreakerFailureRate, 1, 100)
+	if !ok {
+		p.requires(annotationCircuitBreakerFailureRate, annotationCircuitBreakerWindowSize,
+			annotationCircuitBreakerOpenTime)
+		return nil
+	}
+	cb := &circuitBreakerAnnotation{
+		failureRate:  rate,
+		openDuration: p.getDuration(annotationCircuitBreakerOpenTime),
+	}
+	cb.windowSize, _ = p.getInt(annotationCircuitBreakerWindowSize, 1, 1<<20)
+	return cb
+}
+
+func (p *annotationParser) parseLoadBalance() *proxy.LoadBalanceSpec {
+	policy := p.getString(annotationLoadBalance)
+	switch policy {
+	case "":
+		p.requires(annotationLoadBalance, annotationLoadBalanceHashHeader)
+		return nil
+	case proxies.LoadBalancePolicyRoundRobin, proxies.LoadBalancePolicyRandom,
+		proxies.LoadBalancePolicyWeightedRandom, proxies.LoadBalancePolicyIPHash:
+		p.requires(annotationLoadBalance+": "+proxies.LoadBalancePolicyHeaderHash, annotationLoadBalanceHashHeader)
+		return &proxy.LoadBalanceSpec{Policy: policy}
+	case proxies.LoadBalancePolicyHeaderHash:
+		key := p.getString(annotationLoadBalanceHashHeader)
+		if key == "" {
+			p.errorf(annotationLoadBalance, "%s is required", annotationLoadBalanceHashHeader)
+			return nil
+		}
+		return &proxy.LoadBalanceSpec{Policy: policy, HeaderHashKey: key}
+	default:
+		p.errorf(annotationLoadBalance, "unsupported policy %q", policy)
+		return nil
+	}
+}
+
+// parseServiceBackend parses a service backend in format 'name:port',
+// the port could be a number or a name.
+func parseServiceBackend(s string) (*apinetv1.IngressServiceBackend, error) {
+	name, port, ok := strings.Cut(s, ":")
+	if !ok || name == "" || port == "" {
+		return nil, fmt.Errorf("invalid service %q, must be in format 'name:port'", s)
+	}
+
+	backend := &apinetv1.IngressServiceBackend{Name: name}
+	if n, err := strconv.Atoi(port); err == nil {
+		if n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port %d", n)
+		}
+		backend.Port.Number = int32(n)
+	} else {
+		backend.Port.Name = port
+	}
+	return backend, nil
+}
+
+func (p *annotationParser) parseCanary() *canaryAnnotation {
+	s := p.getString(annotationCanaryService)
+	if s == "" {
+		p.requires(annotationCanaryService, annotationCanaryWeight, annotationCanaryHeader,
+			annotationCanaryHeaderValue)
+		return nil
+	}
+
+	service, err := parseServiceBackend(s)
+	if err != nil {
+		p.errorf(annotationCanaryService, "%v", err)
+		return nil
+	}
+
+	canary := &canaryAnnotation{
+		service:     service,
+		header:      p.getString(annotationCanaryHeader),
+		headerValue: p.getString(annotationCanaryHeaderValue),
+	}
+	if canary.header == "" {
+		p.requires(annotationCanaryHeader, annotationCanaryHeaderValue)
+	}
+
+	weight, hasWeight := p.getInt(annotationCanaryWeight, 0, 100)
+	switch {
+	case hasWeight && canary.header != "":
+		p.errorf(annotationCanaryService, "%s and %s are mutually exclusive",
+			annotationCanaryWeight, annotationCanaryHeader)
+		return nil
+	case canary.header != "":
+		return canary
+	case !hasWeight:
+		p.errorf(annotationCanaryService, "one of %s and %s is required",
+			annotationCanaryWeight, annotationCanaryHeader)
+		return nil
+	case weight == 0:
+		// the canary is disabled
+		return nil
+	}
+
+	canary.permil = uint32(weight) * 10
+	return canary
+}
+
+// parseAnnotations parses the annotations of an ingress, invalid
+// annotations are ignored and reported in the returned errors.
+func parseAnnotations(ingress *apinetv1.Ingress) (*ingressAnnotations, []error) {
+	p := &annotationParser{annotations: ingress.Annotations}
+
+	var unknown []string
+	for k := range ingress.Annotations {
+		if strings.HasPrefix(k, annotationPrefix) && !knownAnnotations[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		p.errorf(k, "unknown annotation")
+	}
+
+	a := &ingressAnnotations{
+		webSocket:              p.getBool(annotationWebSocket),
+		webSocketDefaultOrigin: p.getString(annotationWebSocketDefaultOrigin),
+		rewriteTarget:          p.getString(annotationRewriteTarget),
+		rateLimit:              p.parseRateLimit(),
+		cors:                   p.parseCORS(),
+		timeout:                p.getDuration(annotationProxyTimeout),
+		retry:                  p.parseRetry(),
+		circuitBreaker:         p.parseCircuitBreaker(),
+		loadBalance:            p.parseLoadBalance(),
+		canary:                 p.parseCanary(),
+	}
+	a.basicAuth, a.jwtAuth = p.parseAuth()
+
+	if a.webSocket {
+		if a.timeout != "" || a.retry != nil || a.circuitBreaker != nil || a.canary != nil {
+			p.errorf(annotationWebSocket, "timeout, retry, circuit breaker and canary are not supported by websocket")
+			a.timeout, a.retry, a.circuitBreaker, a.canary = "", nil, nil, nil
+		}
+	} else if p.has(annotationWebSocketDefaultOrigin) {
+		p.errorf(annotationWebSocketDefaultOrigin, "%s is required", annotationWebSocket)
+	}
+
+	return a, p.errs
+}
+
+// customized returns whether the pipeline of the ingress is different from
+// the plain one, that's, the pipeline could not be shared with other
+// ingresses.
+func (a *ingressAnnotations) customized() bool {
+	return a.rateLimit != nil || a.cors != nil || a.basicAuth != nil || a.jwtAuth != nil ||
+		a.timeout != "" || a.retry != nil || a.circuitBreaker != nil ||
+		a.loadBalance != nil || a.canary != nil
+}
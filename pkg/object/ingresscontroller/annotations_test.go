@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ingresscontroller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apinetv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	proxy "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/pkg/filters/validator"
+)
+
+func parseTestAnnotations(annotations map[string]string) (*ingressAnnotations, []error) {
+	ingress := &apinetv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+	}
+	return parseAnnotations(ingress)
+}
+
+func TestParseAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	a, errs := parseTestAnnotations(map[string]string{
+		"kubernetes.io/ingress.class": "easegress",
+		annotationRewriteTarget:       "/",
+	})
+	assert.Empty(errs)
+	assert.False(a.customized())
+	assert.Equal("/", a.rewriteTarget)
+
+	a, errs = parseTestAnnotations(map[string]string{
+		annotationRateLimitRPS:              "100",
+		annotationRateLimitTimeout:          "100ms",
+		annotationEnableCORS:                "true",
+		annotationCORSAllowMethods:          "get, POST",
+		annotationCORSAllowCredentials:      "true",
+		annotationCORSMaxAge:                "3600",
+		annotationAuthType:                  "jwt",
+		annotationAuthJWTSecret:             "jwt-secret",
+		annotationProxyTimeout:              "5s",
+		annotationRetryMaxAttempts:          "3",
+		annotationRetryWaitDuration:         "200ms",
+		annotationCircuitBreakerFailureRate: "50",
+		annotationLoadBalance:               "headerHash",
+		annotationLoadBalanceHashHeader:     "X-User",
+		annotationCanaryService:             "canary:8080",
+		annotationCanaryWeight:              "20",
+	})
+	assert.Empty(errs)
+	assert.True(a.customized())
+	assert.Equal(&rateLimitAnnotation{rps: 100, timeout: "100ms"}, a.rateLimit)
+	assert.Equal(&corsAnnotation{
+		allowOrigins:     []string{"*"},
+		allowMethods:     []string{"GET", "POST"},
+		allowCredentials: true,
+		maxAge:           3600,
+	}, a.cors)
+	assert.Nil(a.basicAuth)
+	assert.Equal(&jwtAuthAnnotation{algorithm: "HS256", secret: "jwt-secret"}, a.jwtAuth)
+	assert.Equal("5s", a.timeout)
+	assert.Equal(&retryAnnotation{maxAttempts: 3, waitDuration: "200ms"}, a.retry)
+	assert.Equal(&circuitBreakerAnnotation{failureRate: 50}, a.circuitBreaker)
+	assert.Equal(&proxy.LoadBalanceSpec{Policy: "headerHash", HeaderHashKey: "X-User"}, a.loadBalance)
+	assert.Equal("canary", a.canary.service.Name)
+	assert.Equal(int32(8080), a.canary.service.Port.Number)
+	assert.Equal(uint32(200), a.canary.permil)
+
+	a, errs = parseTestAnnotations(map[string]string{
+		annotationAuthType:          "basic",
+		annotationAuthBasicUserFile: "/etc/htpasswd",
+		annotationCanaryService:     "canary:http",
+		annotationCanaryHeader:      "X-Canary",
+	})
+	assert.Empty(errs)
+	assert.Equal(&basicAuthAnnotation{userFile: "/etc/htpasswd"}, a.basicAuth)
+	assert.Equal("http", a.canary.service.Port.Name)
+	spec := newCanaryMatcherSpec(a.canary)
+	assert.Equal("general", spec.Policy)
+	assert.Equal(".+", spec.Headers["X-Canary"].RegEx)
+}
+
+func TestParseInvalidAnnotations(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		annotations map[string]string
+		numErrors   int
+	}{
+		{map[string]string{annotationPrefix + "unknown": "1"}, 1},
+		{map[string]string{annotationRateLimitRPS: "abc"}, 1},
+		{map[string]string{annotationRateLimitRPS: "0"}, 1},
+		{map[string]string{annotationRateLimitTimeout: "1s"}, 1},
+		{map[string]string{annotationEnableCORS: "yes"}, 1},
+		{map[string]string{annotationEnableCORS: "true", annotationCORSAllowMethods: "GET,FOO"}, 1},
+		{map[string]string{annotationAuthType: "oauth"}, 1},
+		{map[string]string{annotationAuthType: "basic"}, 1},
+		{map[string]string{annotationAuthType: "jwt"}, 1},
+		{map[string]string{annotationAuthType: "jwt", annotationAuthJWTSecret: "s", annotationAuthJWTAlgorithm: "none"}, 1},
+		{map[string]string{annotationProxyTimeout: "-1s"}, 1},
+		{map[string]string{annotationCircuitBreakerFailureRate: "101"}, 1},
+		{map[string]string{annotationLoadBalance: "leastConn"}, 1},
+		{map[string]string{annotationLoadBalance: "headerHash"}, 1},
+		{map[string]string{annotationCanaryService: "canary"}, 1},
+		{map[string]string{annotationCanaryService: "canary:80"}, 1},
+		{map[string]string{annotationCanaryService: "canary:80", annotationCanaryWeight: "10", annotationCanaryHeader: "X-Canary"}, 1},
+		{map[string]string{annotationCanaryWeight: "10"}, 1},
+		{map[string]string{annotationWebSocket: "true", annotationRetryMaxAttempts: "3"}, 1},
+		{map[string]string{annotationWebSocketDefaultOrigin: "http://example.com"}, 1},
+	}
+
+	for _, c := range cases {
+		_, errs := parseTestAnnotations(c.annotations)
+		assert.Len(errs, c.numErrors, "%v: %v", c.annotations, errs)
+	}
+
+	// invalid annotations are ignored, valid ones still work.
+	a, errs := parseTestAnnotations(map[string]string{
+		annotationRateLimitRPS: "abc",
+		annotationProxyTimeout: "3s",
+	})
+	assert.Len(errs, 1)
+	assert.Nil(a.rateLimit)
+	assert.Equal("3s", a.timeout)
+
+	a, _ = parseTestAnnotations(map[string]string{
+		annotationCanaryService: "canary:80",
+		annotationCanaryWeight:  "0",
+	})
+	assert.Nil(a.canary)
+}
+
+func TestBasicAuthValidatorSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	st := newSpecTranslator(nil, defaultIngressClass, "", nil)
+	spec, err := st.basicAuthValidatorSpec(&basicAuthAnnotation{etcdPrefix: "/users"})
+	assert.NoError(err)
+	assert.Equal(&validator.BasicAuthValidatorSpec{Mode: "ETCD", EtcdPrefix: "/users"}, spec)
+
+	// user files are not allowed without the directory
+	_, err = st.basicAuthValidatorSpec(&basicAuthAnnotation{userFile: "htpasswd"})
+	assert.Error(err)
+
+	st = newSpecTranslator(nil, defaultIngressClass, "/etc/easegress/htpasswd", nil)
+	spec, err = st.basicAuthValidatorSpec(&basicAuthAnnotation{userFile: "team-a/./htpasswd"})
+	assert.NoError(err)
+	assert.Equal(&validator.BasicAuthValidatorSpec{Mode: "FILE", UserFile: "/etc/easegress/htpasswd/team-a/htpasswd"}, spec)
+
+	// user files out of the directory are rejected
+	for _, file := range []string{"/etc/shadow", "..", "../shadow", "team-a/../../shadow"} {
+		_, err = st.basicAuthValidatorSpec(&basicAuthAnnotation{userFile: file})
+		assert.Error(err, file)
+	}
+
+	assert.NoError((&Spec{BasicAuthDir: "/etc/easegress/htpasswd"}).Validate())
+	assert.Error((&Spec{BasicAuthDir: "htpasswd"}).Validate())
+}
@@ -20,6 +20,7 @@ package ingresscontroller
 
 import (
 	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 	"github.com/megaease/easegress/pkg/object/httpserver"
 	"github.com/megaease/easegress/pkg/object/trafficcontroller"
 	"github.com/megaease/easegress/pkg/supervisor"
+	apinetv1 "k8s.io/api/networking/v1"
 )
 
 const (
@@ -50,6 +52,9 @@ type (
 		tc        *trafficcontroller.TrafficController
 		namespace string
 		k8sClient *k8sClient
+		// warnings are the warnings which have been reported as events,
+		// they are not reported again until the ingress is updated.
+		warnings map[string]struct{}
 
 		stopCh chan struct{}
 		wg     sync.WaitGroup
@@ -62,6 +67,10 @@ type (
 		MasterURL    string           `json:"masterURL" jsonschema:"omitempty"`
 		Namespaces   []string         `json:"namespaces" jsonschema:"omitempty"`
 		IngressClass string           `json:"ingressClass" jsonschema:"omitempty"`
+		// BasicAuthDir is the directory of the user files of basic auth,
+		// the auth-basic-user-file annotation is a relative path in it.
+		// User files are not allowed if it's empty.
+		BasicAuthDir string `json:"basicAuthDir" jsonschema:"omitempty"`
 	}
 )
 
@@ -91,6 +100,14 @@ func (ic *IngressController) DefaultSpec() interface{} {
 	}
 }
 
+// Validate validates the spec of IngressController.
+func (spec *Spec) Validate() error {
+	if spec.BasicAuthDir != "" && !filepath.IsAbs(spec.BasicAuthDir) {
+		return fmt.Errorf("basicAuthDir %s should be an absolute path", spec.BasicAuthDir)
+	}
+	return nil
+}
+
 // Init initializes IngressController.
 func (ic *IngressController) Init(superSpec *supervisor.Spec) {
 	ic.superSpec = superSpec
@@ -115,6 +132,7 @@ func (ic *IngressController) reload() {
 	}
 
 	ic.namespace = fmt.Sprintf("%s/%s", ic.superSpec.Name(), "ingresscontroller")
+	ic.warnings = map[string]struct{}{}
 	ic.stopCh = make(chan struct{})
 
 	ic.wg.Add(1)
@@ -193,12 +211,15 @@ func (ic *IngressController) Status() *supervisor.Status {
 func (ic *IngressController) Close() {
 	close(ic.stopCh)
 	ic.wg.Wait()
+	if ic.k8sClient != nil {
+		ic.k8sClient.close()
+	}
 	ic.tc.Clean(ic.namespace)
 }
 
 func (ic *IngressController) translate() error {
 	logger.Debugf("begin translate kubernetes ingress to easegress configuration")
-	st := newSpecTranslator(ic.k8sClient, ic.spec.IngressClass, ic.spec.HTTPServer)
+	st := newSpecTranslator(ic.k8sClient, ic.spec.IngressClass, ic.spec.BasicAuthDir, ic.spec.HTTPServer)
 	err := st.translate()
 	if err != nil {
 		logger.Errorf("failed to translate kubernetes ingress: %v", err)
@@ -229,5 +250,30 @@ func (ic *IngressController) translate() error {
 		}
 	}
 
+	ic.reportWarnings(st.warnings)
 	return nil
 }
+
+// reportWarnings reports the warnings of ingresses as kubernetes events,
+// a warning is only reported once for a version of an ingress.
+func (ic *IngressController) reportWarnings(warnings map[*apinetv1.Ingress][]error) {
+	// every member translates the ingresses, only the leader reports the
+	// warnings, so that they are not duplicated. The reported warnings are
+	// reset, so that they're reported by a new leader.
+	if !ic.super.Cluster().IsLeader() {
+		ic.warnings = map[string]struct{}{}
+		return
+	}
+
+	reported := map[string]struct{}{}
+	for ingress, errs := range warnings {
+		for _, err := range errs {
+			key := fmt.Sprintf("%s/%s/%s", ingress.UID, ingress.ResourceVersion, err.Error())
+			reported[key] = struct{}{}
+			if _, ok := ic.warnings[key]; !ok {
+				ic.k8sClient.warn(ingress, "InvalidAnnotation", err.Error())
+			}
+		}
+	}
+	ic.warnings = reported
+}
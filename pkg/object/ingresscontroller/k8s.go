@@ -30,8 +30,11 @@ import (
 	"k8s.io/client-go/informers/internalinterfaces"
 	networkingv1 "k8s.io/client-go/informers/networking/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
 	apicorev1 "k8s.io/api/core/v1"
 	apinetv1 "k8s.io/api/networking/v1"
@@ -114,6 +117,9 @@ type k8sClient struct {
 	clientset       *kubernetes.Clientset
 	informerFactory informers.SharedInformerFactory
 	eventCh         chan interface{}
+
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
 }
 
 // OnAdd is called on Resource Add Events.
@@ -166,12 +172,31 @@ func newK8sClient(masterURL string, kubeConfig string) (*k8sClient, error) {
 		return nil, err
 	}
 
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, apicorev1.EventSource{
+		Component: "easegress-ingress-controller",
+	})
+
 	return &k8sClient{
-		clientset: clientset,
-		eventCh:   make(chan interface{}, 1),
+		clientset:   clientset,
+		eventCh:     make(chan interface{}, 1),
+		broadcaster: broadcaster,
+		recorder:    recorder,
 	}, nil
 }
 
+func (c *k8sClient) close() {
+	c.broadcaster.Shutdown()
+}
+
+// warn records a warning event for ingress.
+func (c *k8sClient) warn(ingress *apinetv1.Ingress, reason, message string) {
+	c.recorder.Event(ingress, apicorev1.EventTypeWarning, reason, message)
+}
+
 func checkKubernetesVersion(cfg *rest.Config) (err error) {
 	cli, err := discovery.NewDiscoveryClientForConfig(cfg)
 	if err != nil {
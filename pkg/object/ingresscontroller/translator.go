@@ -18,20 +18,28 @@
 package ingresscontroller
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/megaease/easegress/pkg/object/httpserver/routers"
 
+	"github.com/megaease/easegress/pkg/filters/corsadaptor"
 	proxy "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/pkg/filters/ratelimiter"
+	"github.com/megaease/easegress/pkg/filters/validator"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httpserver"
 	"github.com/megaease/easegress/pkg/object/pipeline"
+	"github.com/megaease/easegress/pkg/resilience"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/urlrule"
 	apicorev1 "k8s.io/api/core/v1"
 	apinetv1 "k8s.io/api/networking/v1"
 )
@@ -49,6 +57,11 @@ type (
 		pipelines    map[string]*supervisor.Spec
 		httpSvrCfg   *httpserver.Spec
 		ingressClass string
+		// basicAuthDir is the directory of the user files of basic auth.
+		basicAuthDir string
+		// warnings are the problems of ingresses which should be
+		// reported to users as kubernetes events.
+		warnings map[*apinetv1.Ingress][]error
 	}
 
 	pipelineSpecBuilder struct {
@@ -72,12 +85,104 @@ func newPipelineSpecBuilder(name string) *pipelineSpecBuilder {
 	}
 }
 
-func (b *pipelineSpecBuilder) addProxy(endpoints []string) {
-	const name = "proxy"
+func (b *pipelineSpecBuilder) addFilter(spec map[string]interface{}) {
+	b.Flow = append(b.Flow, pipeline.FlowNode{FilterName: spec["name"].(string)})
+	b.Filters = append(b.Filters, spec)
+}
+
+func (b *pipelineSpecBuilder) addCORSAdaptor(cors *corsAnnotation) {
+	b.addFilter(map[string]interface{}{
+		"kind":             corsadaptor.Kind,
+		"name":             "cors",
+		"allowedOrigins":   cors.allowOrigins,
+		"allowedMethods":   cors.allowMethods,
+		"allowedHeaders":   cors.allowHeaders,
+		"exposedHeaders":   cors.exposeHeaders,
+		"allowCredentials": cors.allowCredentials,
+		"maxAge":           cors.maxAge,
+	})
+}
+
+func (b *pipelineSpecBuilder) addBasicAuthValidator(spec *validator.BasicAuthValidatorSpec) {
+	b.addFilter(map[string]interface{}{
+		"kind":      validator.Kind,
+		"name":      "validator",
+		"basicAuth": spec,
+	})
+}
+
+func (b *pipelineSpecBuilder) addJWTValidator(spec *validator.JWTValidatorSpec) {
+	b.addFilter(map[string]interface{}{
+		"kind": validator.Kind,
+		"name": "validator",
+		"jwt":  spec,
+	})
+}
+
+func (b *pipelineSpecBuilder) addRateLimiter(rl *rateLimitAnnotation) {
+	const policyName = "default"
+
+	b.addFilter(map[string]interface{}{
+		"kind": ratelimiter.Kind,
+		"name": "rateLimiter",
+		"policies": []*ratelimiter.Policy{{
+			Name:               policyName,
+			TimeoutDuration:    rl.timeout,
+			LimitRefreshPeriod: "1s",
+			LimitForPeriod:     rl.rps,
+		}},
+		"defaultPolicyRef": policyName,
+		"urls": []*ratelimiter.URLRule{{
+			URLRule: urlrule.URLRule{URL: stringtool.StringMatcher{Prefix: "/"}},
+		}},
+	})
+}
+
+// addResilience adds the resilience policies to the pipeline and returns
+// the names of the retry policy and the circuit breaker policy.
+func (b *pipelineSpecBuilder) addResilience(retry *retryAnnotation, cb *circuitBreakerAnnotation) (string, string) {
+	var retryName, cbName string
+
+	if retry != nil {
+		retryName = "retry"
+		policy := map[string]interface{}{
+			"kind":        resilience.RetryKind.Name,
+			"name":        retryName,
+			"maxAttempts": retry.maxAttempts,
+		}
+		if retry.waitDuration != "" {
+			policy["waitDuration"] = retry.waitDuration
+		}
+		b.Resilience = append(b.Resilience, policy)
+	}
+
+	if cb != nil {
+		cbName = "circuitBreaker"
+		policy := map[string]interface{}{
+			"kind":                 resilience.CircuitBreakerKind.Name,
+			"name":                 cbName,
+			"failureRateThreshold": cb.failureRate,
+		}
+		if cb.windowSize > 0 {
+			policy["slidingWindowSize"] = cb.windowSize
+		}
+		if cb.openDuration != "" {
+			policy["waitDurationInOpenState"] = cb.openDuration
+		}
+		b.Resilience = append(b.Resilience, policy)
+	}
+
+	return retryName, cbName
+}
+
+func newServerPoolSpec(endpoints []string, lb *proxy.LoadBalanceSpec) *proxy.ServerPoolSpec {
+	if lb == nil {
+		lb = &proxy.LoadBalanceSpec{}
+	}
 
 	pool := &proxy.ServerPoolSpec{
 		BaseServerPoolSpec: proxy.BaseServerPoolSpec{
-			LoadBalance: &proxy.LoadBalanceSpec{},
+			LoadBalance: lb,
 		},
 		ServerMaxBodySize: -1,
 	}
@@ -86,20 +191,44 @@ func (b *pipelineSpecBuilder) addProxy(endpoints []string) {
 		pool.Servers = append(pool.Servers, &proxy.Server{URL: ep})
 	}
 
-	b.Flow = append(b.Flow, pipeline.FlowNode{FilterName: name})
-	b.Filters = append(b.Filters, map[string]interface{}{
+	return pool
+}
+
+// newCanaryMatcherSpec creates the request matcher which selects the
+// requests to the canary pool.
+func newCanaryMatcherSpec(canary *canaryAnnotation) *proxy.RequestMatcherSpec {
+	spec := &proxy.RequestMatcherSpec{}
+	if canary.header == "" {
+		spec.Policy = "random"
+		spec.Permil = canary.permil
+		return spec
+	}
+
+	m := &stringtool.StringMatcher{Exact: canary.headerValue}
+	if canary.headerValue == "" {
+		m = &stringtool.StringMatcher{RegEx: ".+"}
+	}
+	spec.Policy = "general"
+	spec.Headers = map[string]*stringtool.StringMatcher{canary.header: m}
+	return spec
+}
+
+func (b *pipelineSpecBuilder) addProxy(pools []*proxy.ServerPoolSpec) {
+	b.addFilter(map[string]interface{}{
 		"kind":  proxy.Kind,
-		"name":  name,
-		"pools": []*proxy.ServerPoolSpec{pool},
+		"name":  "proxy",
+		"pools": pools,
 	})
 }
 
-func (b *pipelineSpecBuilder) addWebSocketProxy(endpoints []string, defaultOrigin string) {
-	const name = "websocketproxy"
+func (b *pipelineSpecBuilder) addWebSocketProxy(endpoints []string, lb *proxy.LoadBalanceSpec, defaultOrigin string) {
+	if lb == nil {
+		lb = &proxy.LoadBalanceSpec{}
+	}
 
 	pool := &proxy.WebSocketServerPoolSpec{
 		BaseServerPoolSpec: proxy.BaseServerPoolSpec{
-			LoadBalance: &proxy.LoadBalanceSpec{},
+			LoadBalance: lb,
 		},
 	}
 
@@ -107,10 +236,9 @@ func (b *pipelineSpecBuilder) addWebSocketProxy(endpoints []string, defaultOrigi
 		pool.Servers = append(pool.Servers, &proxy.Server{URL: ep})
 	}
 
-	b.Flow = append(b.Flow, pipeline.FlowNode{FilterName: name})
-	b.Filters = append(b.Filters, map[string]interface{}{
+	b.addFilter(map[string]interface{}{
 		"kind":          proxy.WebSocketProxyKind,
-		"name":          name,
+		"name":          "websocketproxy",
 		"defaultOrigin": defaultOrigin,
 		"pools":         []*proxy.WebSocketServerPoolSpec{pool},
 	})
@@ -146,12 +274,14 @@ func (b *httpServerSpecBuilder) jsonConfig() string {
 	return string(buff)
 }
 
-func newSpecTranslator(k8sClient *k8sClient, ingressClass string, httpSvrCfg *httpserver.Spec) *specTranslator {
+func newSpecTranslator(k8sClient *k8sClient, ingressClass, basicAuthDir string, httpSvrCfg *httpserver.Spec) *specTranslator {
 	return &specTranslator{
 		k8sClient:    k8sClient,
 		httpSvrCfg:   httpSvrCfg,
 		ingressClass: ingressClass,
+		basicAuthDir: basicAuthDir,
 		pipelines:    map[string]*supervisor.Spec{},
+		warnings:     map[*apinetv1.Ingress][]error{},
 	}
 }
 
@@ -163,6 +293,11 @@ func (st *specTranslator) pipelineSpecs() map[string]*supervisor.Spec {
 	return st.pipelines
 }
 
+func (st *specTranslator) warn(ingress *apinetv1.Ingress, err error) {
+	logger.Warnf("ingress %s/%s: %v", ingress.Namespace, ingress.Name, err)
+	st.warnings[ingress] = append(st.warnings[ingress], err)
+}
+
 func (st *specTranslator) getEndpoints(namespace string, service *apinetv1.IngressServiceBackend) ([]string, error) {
 	svc, err := st.k8sClient.getService(namespace, service.Name)
 	if err != nil {
@@ -224,47 +359,118 @@ func (st *specTranslator) getEndpoints(namespace string, service *apinetv1.Ingre
 	return result, nil
 }
 
-func supportWebSocket(ingress *apinetv1.Ingress) bool {
-	v := ingress.Annotations["easegress.ingress.kubernetes.io/websocket"]
-	if len(v) == 0 {
-		return false
+// basicAuthValidatorSpec creates the spec of the basic auth validator, the
+// user file must be in the basic auth directory.
+func (st *specTranslator) basicAuthValidatorSpec(auth *basicAuthAnnotation) (*validator.BasicAuthValidatorSpec, error) {
+	if auth.etcdPrefix != "" {
+		return &validator.BasicAuthValidatorSpec{Mode: "ETCD", EtcdPrefix: auth.etcdPrefix}, nil
+	}
+
+	if st.basicAuthDir == "" {
+		return nil, fmt.Errorf("user files are not allowed: basicAuthDir of the controller is empty")
 	}
-	b, _ := strconv.ParseBool(v)
-	return b
+	file := filepath.Clean(auth.userFile)
+	if filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("user file %s should be a relative path in %s", auth.userFile, st.basicAuthDir)
+	}
+	return &validator.BasicAuthValidatorSpec{Mode: "FILE", UserFile: filepath.Join(st.basicAuthDir, file)}, nil
 }
 
-func (st *specTranslator) serviceToPipeline(ingress *apinetv1.Ingress, service *apinetv1.IngressServiceBackend) (*supervisor.Spec, error) {
-	if service == nil || len(service.Name) == 0 {
-		err := fmt.Errorf("invalid service name, ingress backend is object ref")
-		logger.Errorf("%v", err)
+// jwtValidatorSpec creates the spec of the JWT validator, the secret or
+// the public key is loaded from the kubernetes secret.
+func (st *specTranslator) jwtValidatorSpec(namespace string, auth *jwtAuthAnnotation) (*validator.JWTValidatorSpec, error) {
+	secret, err := st.k8sClient.getSecret(namespace, auth.secret)
+	if err != nil {
 		return nil, err
 	}
-
-	port := service.Port.Name
-	if len(port) == 0 {
-		port = strconv.Itoa(int(service.Port.Number))
+	if secret == nil {
+		return nil, fmt.Errorf("secret %s/%s does not exist", namespace, auth.secret)
 	}
-	pipelineName := fmt.Sprintf("pipeline-%s-%s-%s", ingress.Namespace, service.Name, port)
-	ws := supportWebSocket(ingress)
-	if ws {
-		pipelineName += "-ws"
+
+	spec := &validator.JWTValidatorSpec{
+		Algorithm:  auth.algorithm,
+		CookieName: auth.cookieName,
 	}
-	if st.pipelines[pipelineName] != nil {
-		return st.pipelines[pipelineName], nil
+	if strings.HasPrefix(auth.algorithm, "HS") {
+		key := secret.Data["secret"]
+		if len(key) == 0 {
+			return nil, fmt.Errorf("'secret' is missing or empty in secret %s/%s", namespace, auth.secret)
+		}
+		spec.Secret = hex.EncodeToString(key)
+	} else {
+		key := secret.Data["publicKey"]
+		if len(key) == 0 {
+			return nil, fmt.Errorf("'publicKey' is missing or empty in secret %s/%s", namespace, auth.secret)
+		}
+		spec.PublicKey = hex.EncodeToString(key)
 	}
 
+	return spec, nil
+}
+
+// buildPipeline builds the pipeline which proxies requests to service
+// according to the annotations of the ingress.
+func (st *specTranslator) buildPipeline(name string, ingress *apinetv1.Ingress, a *ingressAnnotations, service *apinetv1.IngressServiceBackend) (*supervisor.Spec, error) {
 	endpoints, err := st.getEndpoints(ingress.Namespace, service)
 	if err != nil {
 		logger.Errorf("failed to get service endpoints: %v", err)
 		return nil, err
 	}
 
-	builder := newPipelineSpecBuilder(pipelineName)
-	if ws {
-		defaultOrigin := ingress.Annotations["easegress.ingress.kubernetes.io/websocket-default-origin"]
-		builder.addWebSocketProxy(endpoints, defaultOrigin)
+	builder := newPipelineSpecBuilder(name)
+
+	// CORS preflight requests should not be validated or limited, so
+	// the CORSAdaptor is the first filter.
+	if a.cors != nil {
+		builder.addCORSAdaptor(a.cors)
+	}
+
+	if a.basicAuth != nil {
+		spec, err := st.basicAuthValidatorSpec(a.basicAuth)
+		if err != nil {
+			// the service must not be exposed without authentication
+			st.warn(ingress, fmt.Errorf("annotation %s: %v", annotationAuthBasicUserFile, err))
+			return nil, err
+		}
+		builder.addBasicAuthValidator(spec)
+	} else if a.jwtAuth != nil {
+		spec, err := st.jwtValidatorSpec(ingress.Namespace, a.jwtAuth)
+		if err != nil {
+			// the service must not be exposed without authentication
+			st.warn(ingress, fmt.Errorf("annotation %s: %v", annotationAuthJWTSecret, err))
+			return nil, err
+		}
+		builder.addJWTValidator(spec)
+	}
+
+	if a.rateLimit != nil {
+		builder.addRateLimiter(a.rateLimit)
+	}
+
+	if a.webSocket {
+		builder.addWebSocketProxy(endpoints, a.loadBalance, a.webSocketDefaultOrigin)
 	} else {
-		builder.addProxy(endpoints)
+		retry, cb := builder.addResilience(a.retry, a.circuitBreaker)
+
+		var pools []*proxy.ServerPoolSpec
+		if a.canary != nil {
+			canaryEndpoints, err := st.getEndpoints(ingress.Namespace, a.canary.service)
+			if err != nil {
+				st.warn(ingress, fmt.Errorf("annotation %s: %v", annotationCanaryService, err))
+			} else {
+				pool := newServerPoolSpec(canaryEndpoints, a.loadBalance)
+				pool.Filter = newCanaryMatcherSpec(a.canary)
+				pools = append(pools, pool)
+			}
+		}
+		pools = append(pools, newServerPoolSpec(endpoints, a.loadBalance))
+
+		for _, pool := range pools {
+			pool.Timeout = a.timeout
+			pool.RetryPolicy = retry
+			pool.CircuitBreakerPolicy = cb
+		}
+		builder.addProxy(pools)
 	}
 
 	spec, err := supervisor.NewSpec(builder.jsonConfig())
@@ -273,28 +479,51 @@ func (st *specTranslator) serviceToPipeline(ingress *apinetv1.Ingress, service *
 		return nil, err
 	}
 
+	return spec, nil
+}
+
+func (st *specTranslator) serviceToPipeline(ingress *apinetv1.Ingress, a *ingressAnnotations, service *apinetv1.IngressServiceBackend) (*supervisor.Spec, error) {
+	if service == nil || len(service.Name) == 0 {
+		err := fmt.Errorf("invalid service name, ingress backend is object ref")
+		logger.Errorf("%v", err)
+		return nil, err
+	}
+
+	port := service.Port.Name
+	if len(port) == 0 {
+		port = strconv.Itoa(int(service.Port.Number))
+	}
+
+	// pipelines with customized filters are not shared between ingresses
+	pipelineName := fmt.Sprintf("pipeline-%s-%s-%s", ingress.Namespace, service.Name, port)
+	if a.customized() {
+		pipelineName = fmt.Sprintf("pipeline-%s-%s-%s-%s", ingress.Namespace, ingress.Name, service.Name, port)
+	}
+	if a.webSocket {
+		pipelineName += "-ws"
+	}
+	if st.pipelines[pipelineName] != nil {
+		return st.pipelines[pipelineName], nil
+	}
+
+	spec, err := st.buildPipeline(pipelineName, ingress, a, service)
+	if err != nil {
+		return nil, err
+	}
+
 	st.pipelines[pipelineName] = spec
 	return spec, err
 }
 
-func (st *specTranslator) translateDefaultPipeline(ingress *apinetv1.Ingress) error {
+func (st *specTranslator) translateDefaultPipeline(ingress *apinetv1.Ingress, a *ingressAnnotations) error {
 	if st.pipelines[defaultPipelineName] != nil {
 		err := fmt.Errorf("the default pipeline has already been created")
 		logger.Errorf("%v", err)
 		return err
 	}
 
-	endpoints, err := st.getEndpoints(ingress.Namespace, ingress.Spec.DefaultBackend.Service)
+	spec, err := st.buildPipeline(defaultPipelineName, ingress, a, ingress.Spec.DefaultBackend.Service)
 	if err != nil {
-		logger.Errorf("failed to get service endpoints: %v", err)
-		return err
-	}
-
-	builder := newPipelineSpecBuilder(defaultPipelineName)
-	builder.addProxy(endpoints)
-	spec, err := supervisor.NewSpec(builder.jsonConfig())
-	if err != nil {
-		logger.Errorf("failed to generate pipeline spec: %v", err)
 		return err
 	}
 
@@ -355,7 +584,7 @@ func (st *specTranslator) translateTLSConfig(b *httpServerSpecBuilder, ingresses
 	return nil
 }
 
-func (st *specTranslator) translateIngressRules(b *httpServerSpecBuilder, ingress *apinetv1.Ingress) {
+func (st *specTranslator) translateIngressRules(b *httpServerSpecBuilder, ingress *apinetv1.Ingress, a *ingressAnnotations) {
 	for _, rule := range ingress.Spec.Rules {
 		if rule.HTTP == nil {
 			continue
@@ -363,7 +592,7 @@ func (st *specTranslator) translateIngressRules(b *httpServerSpecBuilder, ingres
 
 		r := &routers.Rule{}
 		for _, path := range rule.HTTP.Paths {
-			pipeline, err := st.serviceToPipeline(ingress, path.Backend.Service)
+			pipeline, err := st.serviceToPipeline(ingress, a, path.Backend.Service)
 			if err != nil {
 				continue
 			}
@@ -375,7 +604,7 @@ func (st *specTranslator) translateIngressRules(b *httpServerSpecBuilder, ingres
 				p.PathPrefix = path.Path
 			}
 
-			p.RewriteTarget = ingress.Annotations["easegress.ingress.kubernetes.io/rewrite-target"]
+			p.RewriteTarget = a.rewriteTarget
 			p.ClientMaxBodySize = -1
 
 			r.Paths = append(r.Paths, &p)
@@ -423,10 +652,15 @@ func (st *specTranslator) translate() error {
 	}
 
 	for _, ingress := range ingresses {
+		a, errs := parseAnnotations(ingress)
+		for _, err := range errs {
+			st.warn(ingress, err)
+		}
+
 		if ingress.Spec.DefaultBackend != nil {
-			st.translateDefaultPipeline(ingress)
+			st.translateDefaultPipeline(ingress, a)
 		}
-		st.translateIngressRules(b, ingress)
+		st.translateIngressRules(b, ingress, a)
 	}
 
 	// sort rules by host
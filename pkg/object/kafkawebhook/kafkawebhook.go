@@ -0,0 +1,378 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kafkawebhook implements the KafkaWebhook, which consumes Kafka
+// topics and delivers the records to a pipeline as HTTP requests.
+package kafkawebhook
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/resilience"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/kafkaconsumer"
+)
+
+const (
+	// Category is the category of KafkaWebhook.
+	Category = supervisor.CategoryTrafficGate
+
+	// Kind is the kind of KafkaWebhook.
+	Kind = "KafkaWebhook"
+
+	headerTopic     = "X-Kafka-Topic"
+	headerPartition = "X-Kafka-Partition"
+	headerOffset    = "X-Kafka-Offset"
+	headerKey       = "X-Kafka-Key"
+	headerError     = "X-Kafka-Webhook-Error"
+)
+
+var (
+	newConsumerGroup = sarama.NewConsumerGroup
+	newSyncProducer  = sarama.NewSyncProducer
+)
+
+var _ supervisor.TrafficGate = (*KafkaWebhook)(nil)
+
+func init() {
+	supervisor.Register(&KafkaWebhook{})
+}
+
+type (
+	// KafkaWebhook consumes Kafka topics in a consumer group, and delivers
+	// every record to a pipeline as an HTTP request. The offset of a record
+	// is committed after it's delivered or sent to the dead letter topic,
+	// so records are delivered at least once.
+	KafkaWebhook struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		muxMapper context.MuxMapper
+
+		deadLetter   sarama.SyncProducer
+		retryWrapper resilience.Wrapper
+		cancel       stdcontext.CancelFunc
+		done         chan struct{}
+
+		// statistics, they're accessed atomically
+		received     uint64
+		delivered    uint64
+		failed       uint64
+		deadLettered uint64
+		throughput   metrics.Meter
+
+		lagMutex sync.Mutex
+		lag      map[string]int64
+	}
+
+	// Status is the status of KafkaWebhook.
+	Status struct {
+		Received     uint64 `json:"received"`
+		Delivered    uint64 `json:"delivered"`
+		Failed       uint64 `json:"failed"`
+		DeadLettered uint64 `json:"deadLettered"`
+		// Throughput is the records delivered per second, in the moving
+		// average of 1 minute.
+		Throughput float64 `json:"throughput"`
+		// Lag is the lag of the claimed partitions, the key is in the
+		// format of topic/partition.
+		Lag      map[string]int64 `json:"lag"`
+		TotalLag int64            `json:"totalLag"`
+	}
+)
+
+// Category returns the category of KafkaWebhook.
+func (kw *KafkaWebhook) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of KafkaWebhook.
+func (kw *KafkaWebhook) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of KafkaWebhook.
+func (kw *KafkaWebhook) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Status returns the status of KafkaWebhook.
+func (kw *KafkaWebhook) Status() *supervisor.Status {
+	s := &Status{
+		Received:     atomic.LoadUint64(&kw.received),
+		Delivered:    atomic.LoadUint64(&kw.delivered),
+		Failed:       atomic.LoadUint64(&kw.failed),
+		DeadLettered: atomic.LoadUint64(&kw.deadLettered),
+		Lag:          map[string]int64{},
+	}
+	if kw.throughput != nil {
+		s.Throughput = kw.throughput.Rate1()
+	}
+
+	kw.lagMutex.Lock()
+	for k, v := range kw.lag {
+		s.Lag[k] = v
+		s.TotalLag += v
+	}
+	kw.lagMutex.Unlock()
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Init initializes KafkaWebhook.
+func (kw *KafkaWebhook) Init(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
+	kw.superSpec, kw.spec, kw.muxMapper = superSpec, superSpec.ObjectSpec().(*Spec), muxMapper
+	kw.reload()
+}
+
+// Inherit inherits previous generation of KafkaWebhook.
+func (kw *KafkaWebhook) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper context.MuxMapper) {
+	previousGeneration.Close()
+	kw.Init(superSpec, muxMapper)
+}
+
+func (kw *KafkaWebhook) reload() {
+	spec := kw.spec
+	kw.lag = map[string]int64{}
+	kw.done = make(chan struct{})
+	kw.throughput = metrics.NewMeter()
+
+	policies := map[string]resilience.Policy{}
+	for _, r := range spec.Resilience {
+		// the spec is validated, so no error here
+		policy, _ := resilience.NewPolicy(r)
+		policies[policy.Name()] = policy
+	}
+	if policy, _ := spec.retryPolicy(policies); policy != nil {
+		kw.retryWrapper = policy.CreateWrapper()
+	}
+
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	kw.cancel = cancel
+	go kw.run(ctx)
+}
+
+func (kw *KafkaWebhook) newConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.ClientID = kw.superSpec.Name()
+	config.Version = sarama.V1_0_0_0
+	config.Consumer.Return.Errors = true
+	if kw.spec.InitialOffset == "oldest" {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	// the dead letter producer is synchronous, so that the offset is
+	// committed after the record is acknowledged.
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Return.Successes = true
+	return config
+}
+
+// connectDeadLetter creates the dead letter producer, it retries until
+// the producer is created or ctx is done, and returns false if ctx is done.
+func (kw *KafkaWebhook) connectDeadLetter(ctx stdcontext.Context, config *sarama.Config) bool {
+	for {
+		producer, err := newSyncProducer(kw.spec.Backend, config)
+		if err == nil {
+			kw.deadLetter = producer
+			return true
+		}
+		logger.Errorf("%s: start sarama producer with address %v failed: %v", kw.superSpec.Name(), kw.spec.Backend, err)
+		if !kafkaconsumer.Wait(ctx) {
+			return false
+		}
+	}
+}
+
+func (kw *KafkaWebhook) run(ctx stdcontext.Context) {
+	defer close(kw.done)
+	config := kw.newConfig()
+	if kw.spec.DeadLetterTopic != "" && !kw.connectDeadLetter(ctx, config) {
+		return
+	}
+
+	c := &kafkaconsumer.Consumer{
+		Name:          kw.superSpec.Name(),
+		Brokers:       kw.spec.Backend,
+		GroupID:       kw.spec.GroupID,
+		Topics:        kw.spec.Topics,
+		Config:        config,
+		NewGroup:      newConsumerGroup,
+		HandleMessage: kw.handleMessage,
+		SetLag:        kw.setLag,
+	}
+	c.Run(ctx)
+}
+
+// Close closes KafkaWebhook.
+func (kw *KafkaWebhook) Close() {
+	if kw.cancel != nil {
+		kw.cancel()
+		<-kw.done
+	}
+	if kw.deadLetter != nil {
+		if err := kw.deadLetter.Close(); err != nil {
+			logger.Errorf("%s: close kafka producer failed: %v", kw.superSpec.Name(), err)
+		}
+	}
+	if kw.throughput != nil {
+		kw.throughput.Stop()
+	}
+}
+
+func (kw *KafkaWebhook) setLag(claim sarama.ConsumerGroupClaim, lag int64) {
+	key := claim.Topic() + "/" + strconv.Itoa(int(claim.Partition()))
+	kw.lagMutex.Lock()
+	if lag < 0 {
+		delete(kw.lag, key)
+	} else {
+		kw.lag[key] = lag
+	}
+	kw.lagMutex.Unlock()
+}
+
+// newRequest builds the HTTP request of msg.
+func (kw *KafkaWebhook) newRequest(msg *sarama.ConsumerMessage) *httpprot.Request {
+	method, path, host := http.MethodPost, "/", kw.superSpec.Name()
+	var headers map[string]string
+	if r := kw.spec.Request; r != nil {
+		if r.Method != "" {
+			method = r.Method
+		}
+		if r.Path != "" {
+			path = r.Path
+		}
+		if r.Host != "" {
+			host = r.Host
+		}
+		headers = r.Headers
+	}
+
+	// http.NewRequest never fails for a valid method and path.
+	stdr, _ := http.NewRequest(method, path, nil)
+	stdr.Host = host
+	for k, v := range headers {
+		stdr.Header.Set(k, v)
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			stdr.Header.Add(string(h.Key), string(h.Value))
+		}
+	}
+	stdr.Header.Set(headerTopic, msg.Topic)
+	stdr.Header.Set(headerPartition, strconv.Itoa(int(msg.Partition)))
+	stdr.Header.Set(headerOffset, strconv.FormatInt(msg.Offset, 10))
+	if msg.Key != nil {
+		stdr.Header.Set(headerKey, string(msg.Key))
+	}
+
+	// httpprot.NewRequest never returns an error.
+	req, _ := httpprot.NewRequest(stdr)
+	req.SetPayload(msg.Value)
+	return req
+}
+
+// deliver handles msg by the pipeline, it succeeds if the status code of
+// the response is 2xx.
+func (kw *KafkaWebhook) deliver(msg *sarama.ConsumerMessage) error {
+	handler, ok := kw.muxMapper.GetHandler(kw.spec.Pipeline)
+	if !ok {
+		return fmt.Errorf("pipeline %s not found", kw.spec.Pipeline)
+	}
+
+	ctx := context.New(tracing.NoopSpan)
+	defer ctx.Finish()
+	ctx.SetRequest(context.DefaultNamespace, kw.newRequest(msg))
+
+	result := handler.Handle(ctx)
+	resp, _ := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response)
+	if resp == nil {
+		return fmt.Errorf("no response from pipeline %s, result: %q", kw.spec.Pipeline, result)
+	}
+	if code := resp.StatusCode(); code < 200 || code >= 300 {
+		return fmt.Errorf("pipeline %s responded status code %d, result: %q", kw.spec.Pipeline, code, result)
+	}
+	return nil
+}
+
+// handleMessage delivers msg with the retry policy, and sends it to the
+// dead letter topic if all attempts failed. It returns false if ctx is
+// done before msg is handled, and the offset of msg should not be marked.
+func (kw *KafkaWebhook) handleMessage(ctx stdcontext.Context, msg *sarama.ConsumerMessage) bool {
+	atomic.AddUint64(&kw.received, 1)
+
+	handler := func(stdcontext.Context) error {
+		return kw.deliver(msg)
+	}
+	if kw.retryWrapper != nil {
+		handler = kw.retryWrapper.Wrap(handler)
+	}
+	err := handler(ctx)
+	if err == nil {
+		atomic.AddUint64(&kw.delivered, 1)
+		kw.throughput.Mark(1)
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	atomic.AddUint64(&kw.failed, 1)
+	logger.Errorf("%s: deliver record %s/%d/%d failed: %v", kw.superSpec.Name(), msg.Topic, msg.Partition, msg.Offset, err)
+	if kw.deadLetter == nil {
+		return true
+	}
+
+	dl := &sarama.ProducerMessage{
+		Topic: kw.spec.DeadLetterTopic,
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(headerTopic), Value: []byte(msg.Topic)},
+			{Key: []byte(headerPartition), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+			{Key: []byte(headerOffset), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+			{Key: []byte(headerError), Value: []byte(err.Error())},
+		},
+	}
+	if msg.Key != nil {
+		dl.Key = sarama.ByteEncoder(msg.Key)
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			dl.Headers = append(dl.Headers, *h)
+		}
+	}
+	for {
+		_, _, err := kw.deadLetter.SendMessage(dl)
+		if err == nil {
+			atomic.AddUint64(&kw.deadLettered, 1)
+			return true
+		}
+		logger.Errorf("%s: send record to dead letter topic %s failed: %v", kw.superSpec.Name(), kw.spec.DeadLetterTopic, err)
+		if !kafkaconsumer.Wait(ctx) {
+			return false
+		}
+	}
+}
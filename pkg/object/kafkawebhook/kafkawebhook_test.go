@@ -0,0 +1,344 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkawebhook
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+type mockConsumerGroup struct {
+	sarama.ConsumerGroup
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+	marked   chan *sarama.ConsumerMessage
+}
+
+func (m *mockConsumerGroup) Consume(ctx stdcontext.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	session := &mockSession{ctx: ctx, marked: m.marked}
+	claim := &mockClaim{messages: m.messages}
+	return handler.ConsumeClaim(session, claim)
+}
+
+func (m *mockConsumerGroup) Errors() <-chan error {
+	return m.errors
+}
+
+func (m *mockConsumerGroup) Close() error {
+	close(m.errors)
+	return nil
+}
+
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	ctx    stdcontext.Context
+	marked chan *sarama.ConsumerMessage
+}
+
+func (m *mockSession) Context() stdcontext.Context {
+	return m.ctx
+}
+
+func (m *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	m.marked <- msg
+}
+
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (m *mockClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return m.messages
+}
+
+func (m *mockClaim) Topic() string {
+	return "events"
+}
+
+func (m *mockClaim) Partition() int32 {
+	return 0
+}
+
+func (m *mockClaim) HighWaterMarkOffset() int64 {
+	return 10
+}
+
+type mockProducer struct {
+	sarama.SyncProducer
+	messages chan *sarama.ProducerMessage
+}
+
+func (m *mockProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	m.messages <- msg
+	return 0, 0, nil
+}
+
+func (m *mockProducer) Close() error {
+	return nil
+}
+
+// mockPipeline responds the status codes in order, and records the
+// requests.
+type mockPipeline struct {
+	sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (m *mockPipeline) Handle(ctx *context.Context) string {
+	m.Lock()
+	defer m.Unlock()
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	body, _ := io.ReadAll(req.GetPayload())
+	m.requests = append(m.requests, req.Std())
+	m.bodies = append(m.bodies, string(body))
+
+	code := http.StatusOK
+	if len(m.codes) > 0 {
+		code, m.codes = m.codes[0], m.codes[1:]
+	}
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetStatusCode(code)
+	ctx.SetResponse(context.DefaultNamespace, resp)
+	return ""
+}
+
+type mockMuxMapper struct {
+	pipeline *mockPipeline
+}
+
+func (m *mockMuxMapper) GetHandler(name string) (context.Handler, bool) {
+	if name != "webhook-pipeline" {
+		return nil, false
+	}
+	return m.pipeline, true
+}
+
+func newKafkaWebhook(t *testing.T, yamlStr string, pipeline *mockPipeline) *KafkaWebhook {
+	superSpec, err := supervisor.NewDefaultMock().NewSpec(yamlStr)
+	require.Nil(t, err)
+	kw := &KafkaWebhook{}
+	kw.Init(superSpec, &mockMuxMapper{pipeline: pipeline})
+	return kw
+}
+
+func TestKafkaWebhook(t *testing.T) {
+	assert := assert.New(t)
+
+	group := &mockConsumerGroup{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+		marked:   make(chan *sarama.ConsumerMessage, 10),
+	}
+	producer := &mockProducer{messages: make(chan *sarama.ProducerMessage, 10)}
+	defer func() {
+		newConsumerGroup = sarama.NewConsumerGroup
+		newSyncProducer = sarama.NewSyncProducer
+	}()
+	newConsumerGroup = func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		assert.Equal("webhook", groupID)
+		assert.Equal(sarama.OffsetOldest, config.Consumer.Offsets.Initial)
+		return group, nil
+	}
+	newSyncProducer = func(addrs []string, config *sarama.Config) (sarama.SyncProducer, error) {
+		assert.True(config.Producer.Return.Successes)
+		return producer, nil
+	}
+
+	yamlStr := `
+name: kafka-webhook
+kind: KafkaWebhook
+backend: [localhost:9092]
+groupID: webhook
+topics: [events]
+initialOffset: oldest
+pipeline: webhook-pipeline
+request:
+  method: PUT
+  path: /hooks
+  headers:
+    Content-Type: application/json
+resilience:
+- name: retry
+  kind: Retry
+  maxAttempts: 2
+  waitDuration: 10ms
+retryPolicy: retry
+deadLetterTopic: events-dlq
+`
+	// the first record is retried and delivered, the second record is
+	// sent to the dead letter topic after 2 attempts.
+	pipeline := &mockPipeline{codes: []int{http.StatusBadGateway, http.StatusOK, 500, 500}}
+	kw := newKafkaWebhook(t, yamlStr, pipeline)
+	defer kw.Close()
+
+	group.messages <- &sarama.ConsumerMessage{
+		Topic:     "events",
+		Offset:    1,
+		Key:       []byte("key"),
+		Value:     []byte(`{"id":1}`),
+		Headers:   []*sarama.RecordHeader{{Key: []byte("X-Tenant"), Value: []byte("abc")}},
+		Timestamp: time.Now(),
+	}
+	select {
+	case msg := <-group.marked:
+		assert.Equal(int64(1), msg.Offset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not marked")
+	}
+
+	group.messages <- &sarama.ConsumerMessage{Topic: "events", Offset: 2, Value: []byte(`{"id":2}`)}
+	select {
+	case msg := <-producer.messages:
+		assert.Equal("events-dlq", msg.Topic)
+		value, _ := msg.Value.Encode()
+		assert.Equal(`{"id":2}`, string(value))
+		headers := map[string]string{}
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		assert.Equal("2", headers[headerOffset])
+		assert.Contains(headers[headerError], "500")
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not sent to dead letter topic")
+	}
+	select {
+	case msg := <-group.marked:
+		assert.Equal(int64(2), msg.Offset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not marked")
+	}
+
+	pipeline.Lock()
+	require.Len(t, pipeline.requests, 4)
+	req := pipeline.requests[0]
+	assert.Equal(http.MethodPut, req.Method)
+	assert.Equal("/hooks", req.URL.Path)
+	assert.Equal("kafka-webhook", req.Host)
+	assert.Equal("application/json", req.Header.Get("Content-Type"))
+	assert.Equal("abc", req.Header.Get("X-Tenant"))
+	assert.Equal("events", req.Header.Get(headerTopic))
+	assert.Equal("1", req.Header.Get(headerOffset))
+	assert.Equal("key", req.Header.Get(headerKey))
+	assert.Equal(`{"id":1}`, pipeline.bodies[0])
+	pipeline.Unlock()
+
+	status := kw.Status().ObjectStatus.(*Status)
+	assert.Equal(uint64(2), status.Received)
+	assert.Equal(uint64(1), status.Delivered)
+	assert.Equal(uint64(1), status.Failed)
+	assert.Equal(uint64(1), status.DeadLettered)
+	// the lag is updated after the offset is marked
+	assert.Eventually(func() bool {
+		status := kw.Status().ObjectStatus.(*Status)
+		return status.TotalLag == 7 && status.Lag["events/0"] == 7
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestKafkaWebhookRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	group := &mockConsumerGroup{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+		marked:   make(chan *sarama.ConsumerMessage, 10),
+	}
+	defer func() {
+		newConsumerGroup = sarama.NewConsumerGroup
+	}()
+	var mutex sync.Mutex
+	attempts := 0
+	newConsumerGroup = func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// the first attempt fails as if kafka is unreachable
+		attempts++
+		if attempts == 1 {
+			return nil, fmt.Errorf("kafka unreachable")
+		}
+		return group, nil
+	}
+
+	yamlStr := `
+name: kafka-webhook
+kind: KafkaWebhook
+backend: [localhost:9092]
+groupID: webhook
+topics: [events]
+pipeline: webhook-pipeline
+`
+	pipeline := &mockPipeline{}
+	kw := newKafkaWebhook(t, yamlStr, pipeline)
+	defer kw.Close()
+
+	group.messages <- &sarama.ConsumerMessage{Topic: "events", Offset: 1, Value: []byte(`{"id":1}`)}
+	select {
+	case msg := <-group.marked:
+		assert.Equal(int64(1), msg.Offset)
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not marked")
+	}
+	mutex.Lock()
+	assert.Equal(2, attempts)
+	mutex.Unlock()
+
+	// the object is closed while kafka is still unreachable
+	newConsumerGroup = func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+		return nil, fmt.Errorf("kafka unreachable")
+	}
+	unreachable := newKafkaWebhook(t, yamlStr, pipeline)
+	unreachable.Close()
+
+	// Close is safe on an object which isn't initialized
+	(&KafkaWebhook{}).Close()
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+	spec := &Spec{RetryPolicy: "retry"}
+	assert.NotNil(spec.Validate())
+
+	spec.Resilience = []map[string]interface{}{{
+		"name": "retry",
+		"kind": "CircuitBreaker",
+	}}
+	assert.NotNil(spec.Validate())
+
+	spec.Resilience[0]["kind"] = "Retry"
+	assert.Nil(spec.Validate())
+}
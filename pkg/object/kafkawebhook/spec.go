@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkawebhook
+
+import (
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/resilience"
+)
+
+type (
+	// Spec describes the KafkaWebhook.
+	Spec struct {
+		Backend []string `json:"backend" jsonschema:"required,uniqueItems=true"`
+		GroupID string   `json:"groupID" jsonschema:"required"`
+		Topics  []string `json:"topics" jsonschema:"required,minItems=1"`
+		// InitialOffset is the offset to start from when the group has no
+		// committed offset, oldest or newest, default is newest.
+		InitialOffset string `json:"initialOffset,omitempty" jsonschema:"omitempty,enum=,enum=oldest,enum=newest"`
+
+		// Pipeline handles the HTTP requests built from the records.
+		Pipeline string   `json:"pipeline" jsonschema:"required"`
+		Request  *Request `json:"request,omitempty" jsonschema:"omitempty"`
+
+		Resilience []map[string]interface{} `json:"resilience,omitempty" jsonschema:"omitempty"`
+		// RetryPolicy is the name of the retry policy in resilience, a
+		// record is delivered only once if it's empty.
+		RetryPolicy string `json:"retryPolicy,omitempty" jsonschema:"omitempty"`
+		// DeadLetterTopic is the topic to send the records which fail after
+		// all attempts, they're skipped if it's empty.
+		DeadLetterTopic string `json:"deadLetterTopic,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Request is how to build the HTTP request from a record, the value of
+	// the record is the body, and the headers of the record are copied to
+	// the request headers.
+	Request struct {
+		// Method is the method of the request, default is POST.
+		Method string `json:"method,omitempty" jsonschema:"omitempty,format=httpmethod"`
+		// Path is the path of the request, default is /.
+		Path string `json:"path,omitempty" jsonschema:"omitempty,pattern=^/"`
+		// Host is the host of the request, default is the name of the
+		// KafkaWebhook.
+		Host    string            `json:"host,omitempty" jsonschema:"omitempty"`
+		Headers map[string]string `json:"headers,omitempty" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	policies := map[string]resilience.Policy{}
+	for _, r := range spec.Resilience {
+		policy, err := resilience.NewPolicy(r)
+		if err != nil {
+			return err
+		}
+		policies[policy.Name()] = policy
+	}
+	if _, err := spec.retryPolicy(policies); err != nil {
+		return err
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy of spec in policies, it returns nil
+// if no retry policy is specified.
+func (spec *Spec) retryPolicy(policies map[string]resilience.Policy) (*resilience.RetryPolicy, error) {
+	name := spec.RetryPolicy
+	if name == "" {
+		return nil, nil
+	}
+	p := policies[name]
+	if p == nil {
+		return nil, fmt.Errorf("retry policy %s not found", name)
+	}
+	policy, ok := p.(*resilience.RetryPolicy)
+	if !ok {
+		return nil, fmt.Errorf("policy %s is not a retry policy", name)
+	}
+	return policy, nil
+}
@@ -0,0 +1,507 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kubernetesserviceregistry provides KubernetesServiceRegistry.
+package kubernetesserviceregistry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apidiscoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of KubernetesServiceRegistry.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of KubernetesServiceRegistry.
+	Kind = "KubernetesServiceRegistry"
+
+	resyncPeriod = 10 * time.Minute
+
+	// TagZone is the prefix of the zone tag of service instances.
+	TagZone = "zone="
+	// TagNode is the prefix of the node tag of service instances.
+	TagNode = "node="
+)
+
+func init() {
+	supervisor.Register(&KubernetesServiceRegistry{})
+}
+
+type (
+	// KubernetesServiceRegistry is Object KubernetesServiceRegistry.
+	KubernetesServiceRegistry struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		serviceRegistry *serviceregistry.ServiceRegistry
+		firstDone       bool
+		instances       map[string]*serviceregistry.ServiceInstanceSpec
+		notify          chan *serviceregistry.RegistryEvent
+
+		listersMutex sync.RWMutex
+		listers      []cache.GenericLister
+
+		eventCh chan struct{}
+
+		statusMutex  sync.Mutex
+		instancesNum map[string]int
+		health       string
+
+		done chan struct{}
+	}
+
+	// Spec describes the KubernetesServiceRegistry.
+	Spec struct {
+		KubeConfig string   `json:"kubeConfig" jsonschema:"omitempty"`
+		MasterURL  string   `json:"masterURL" jsonschema:"omitempty"`
+		Namespaces []string `json:"namespaces" jsonschema:"omitempty"`
+		// ZoneWeights are the weights of service instances in the zones,
+		// instances in other zones get weight 1. Service instances have
+		// no weight if it is empty.
+		ZoneWeights map[string]int `json:"zoneWeights" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of KubernetesServiceRegistry.
+	Status struct {
+		Health              string         `json:"health"`
+		ServiceInstancesNum map[string]int `json:"instancesNum"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	for zone, w := range spec.ZoneWeights {
+		if w < 1 || w > 100 {
+			return fmt.Errorf("weight of zone %s must be in [1, 100]", zone)
+		}
+	}
+	return nil
+}
+
+// Category returns the category of KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Init(superSpec *supervisor.Spec) {
+	k.superSpec, k.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	k.reload()
+}
+
+// Inherit inherits previous generation of KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	k.Init(superSpec)
+}
+
+func (k *KubernetesServiceRegistry) reload() {
+	k.serviceRegistry = k.superSpec.Super().MustGetSystemController(serviceregistry.Kind).
+		Instance().(*serviceregistry.ServiceRegistry)
+	k.notify = make(chan *serviceregistry.RegistryEvent, 10)
+	k.firstDone = false
+
+	k.instancesNum = map[string]int{}
+	k.health = "connecting"
+	k.eventCh = make(chan struct{}, 1)
+	k.done = make(chan struct{})
+
+	k.serviceRegistry.RegisterRegistry(k)
+
+	go k.run()
+}
+
+func (k *KubernetesServiceRegistry) setHealth(health string) {
+	k.statusMutex.Lock()
+	k.health = health
+	k.statusMutex.Unlock()
+}
+
+func (k *KubernetesServiceRegistry) newClient() (kubernetes.Interface, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags(k.spec.MasterURL, k.spec.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+// OnAdd is called on EndpointSlice Add Events.
+func (k *KubernetesServiceRegistry) OnAdd(obj interface{}) {
+	k.onEvent()
+}
+
+// OnUpdate is called on EndpointSlice Update Events.
+func (k *KubernetesServiceRegistry) OnUpdate(oldObj, newObj interface{}) {
+	if oldObj.(metav1.Object).GetResourceVersion() == newObj.(metav1.Object).GetResourceVersion() {
+		return
+	}
+	k.onEvent()
+}
+
+// OnDelete is called on EndpointSlice Delete Events.
+func (k *KubernetesServiceRegistry) OnDelete(obj interface{}) {
+	k.onEvent()
+}
+
+func (k *KubernetesServiceRegistry) onEvent() {
+	// it is fine to discard the event if there's already one in the
+	// channel, because all instances are reloaded on an event.
+	select {
+	case k.eventCh <- struct{}{}:
+	default:
+	}
+}
+
+// watch starts the informers of EndpointSlices.
+func (k *KubernetesServiceRegistry) watch(client kubernetes.Interface, stopCh chan struct{}) error {
+	namespaces := k.spec.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var listers []cache.GenericLister
+	var synced []cache.InformerSynced
+	for _, ns := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, resyncPeriod, informers.WithNamespace(ns))
+		informer := factory.Discovery().V1().EndpointSlices().Informer()
+		informer.AddEventHandler(k)
+		factory.Start(stopCh)
+
+		listers = append(listers, cache.NewGenericLister(informer.GetIndexer(), apidiscoveryv1.Resource("endpointslices")))
+		synced = append(synced, informer.HasSynced)
+	}
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return fmt.Errorf("timed out waiting for caches to sync")
+	}
+
+	k.listersMutex.Lock()
+	k.listers = listers
+	k.listersMutex.Unlock()
+	return nil
+}
+
+func (k *KubernetesServiceRegistry) run() {
+	var client kubernetes.Interface
+	for {
+		var err error
+		client, err = k.newClient()
+		if err == nil {
+			break
+		}
+		logger.Errorf("%s create kubernetes client failed: %v", k.Name(), err)
+		k.setHealth(err.Error())
+
+		select {
+		case <-k.done:
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+
+	// the informers are stopped when the registry is closed, and they
+	// retry by themselves, so watch only fails on closing.
+	if err := k.watch(client, k.done); err != nil {
+		logger.Errorf("%s watch endpoint slices failed: %v", k.Name(), err)
+		return
+	}
+	k.setHealth("ready")
+
+	k.update()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-k.eventCh:
+			k.update()
+		}
+	}
+}
+
+func (k *KubernetesServiceRegistry) update() {
+	instances, err := k.ListAllServiceInstances()
+	if err != nil {
+		logger.Errorf("list all service instances failed: %v", err)
+		return
+	}
+
+	instancesNum := make(map[string]int)
+	for _, instance := range instances {
+		instancesNum[instance.ServiceName]++
+	}
+
+	var event *serviceregistry.RegistryEvent
+	if !k.firstDone {
+		k.firstDone = true
+		event = &serviceregistry.RegistryEvent{
+			SourceRegistryName: k.Name(),
+			UseReplace:         true,
+			Replace:            instances,
+		}
+	} else {
+		event = serviceregistry.NewRegistryEventFromDiff(k.Name(), k.instances, instances)
+	}
+
+	if event.Empty() {
+		return
+	}
+
+	k.notify <- event
+	k.instances = instances
+
+	k.statusMutex.Lock()
+	k.instancesNum = instancesNum
+	k.statusMutex.Unlock()
+}
+
+// Status returns status of KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Status() *supervisor.Status {
+	s := &Status{}
+
+	k.statusMutex.Lock()
+	s.Health = k.health
+	s.ServiceInstancesNum = k.instancesNum
+	k.statusMutex.Unlock()
+
+	return &supervisor.Status{
+		ObjectStatus: s,
+	}
+}
+
+// Close closes KubernetesServiceRegistry.
+func (k *KubernetesServiceRegistry) Close() {
+	k.serviceRegistry.DeregisterRegistry(k.Name())
+
+	close(k.done)
+}
+
+// Name returns name.
+func (k *KubernetesServiceRegistry) Name() string {
+	return k.superSpec.Name()
+}
+
+// Notify returns notify channel.
+func (k *KubernetesServiceRegistry) Notify() <-chan *serviceregistry.RegistryEvent {
+	return k.notify
+}
+
+// ApplyServiceInstances applies service instances to the registry.
+func (k *KubernetesServiceRegistry) ApplyServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	return fmt.Errorf("%s is read-only, service instances are managed by kubernetes", k.Name())
+}
+
+// DeleteServiceInstances applies service instances to the registry.
+func (k *KubernetesServiceRegistry) DeleteServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	return fmt.Errorf("%s is read-only, service instances are managed by kubernetes", k.Name())
+}
+
+// GetServiceInstance get service instance from the registry.
+func (k *KubernetesServiceRegistry) GetServiceInstance(serviceName, instanceID string) (*serviceregistry.ServiceInstanceSpec, error) {
+	instances, err := k.ListServiceInstances(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if instance.InstanceID == instanceID {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s/%s not found", serviceName, instanceID)
+}
+
+// ListServiceInstances list service instances of one service from the registry.
+func (k *KubernetesServiceRegistry) ListServiceInstances(serviceName string) (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	all, err := k.ListAllServiceInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for key, instance := range all {
+		if instance.ServiceName == serviceName {
+			instances[key] = instance
+		}
+	}
+
+	return instances, nil
+}
+
+// ListAllServiceInstances list all service instances from the registry.
+func (k *KubernetesServiceRegistry) ListAllServiceInstances() (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	k.listersMutex.RLock()
+	listers := k.listers
+	k.listersMutex.RUnlock()
+
+	if listers == nil {
+		return nil, fmt.Errorf("%s is not connected to kubernetes", k.Name())
+	}
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for _, lister := range listers {
+		objs, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, fmt.Errorf("%s list endpoint slices failed: %v", k.Name(), err)
+		}
+
+		for _, obj := range objs {
+			slice := obj.(*apidiscoveryv1.EndpointSlice)
+			for _, instance := range endpointSliceToServiceInstances(k.Name(), slice, k.spec.ZoneWeights) {
+				if err := instance.Validate(); err != nil {
+					logger.Warnf("%+v is invalid: %v", instance, err)
+					continue
+				}
+				instances[instance.Key()] = instance
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// ServiceName returns the name of the service in Easegress, which is
+// '{service}.{namespace}' for unnamed ports, and
+// '{service}.{namespace}:{port name}' for named ports.
+func ServiceName(namespace, service, portName string) string {
+	name := service + "." + namespace
+	if portName != "" {
+		name += ":" + portName
+	}
+	return name
+}
+
+// isSystemLabel returns whether the label is set by kubernetes.
+func isSystemLabel(key string) bool {
+	return strings.Contains(key, "kubernetes.io/")
+}
+
+// endpointSliceToServiceInstances converts an EndpointSlice to service
+// instances, there's an instance for every port of every ready endpoint.
+func endpointSliceToServiceInstances(registryName string, slice *apidiscoveryv1.EndpointSlice, zoneWeights map[string]int) []*serviceregistry.ServiceInstanceSpec {
+	service := slice.Labels[apidiscoveryv1.LabelServiceName]
+	if service == "" {
+		return nil
+	}
+
+	switch slice.AddressType {
+	case apidiscoveryv1.AddressTypeIPv4, apidiscoveryv1.AddressTypeIPv6:
+	default:
+		return nil
+	}
+
+	// labels of the service are copied to the slice
+	var labelTags []string
+	for k, v := range slice.Labels {
+		if !isSystemLabel(k) {
+			labelTags = append(labelTags, k+"="+v)
+		}
+	}
+	sort.Strings(labelTags)
+
+	var result []*serviceregistry.ServiceInstanceSpec
+	for _, ep := range slice.Endpoints {
+		// nil means unknown, and should be interpreted as ready
+		if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+			continue
+		}
+		if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+			continue
+		}
+		if len(ep.Addresses) == 0 {
+			continue
+		}
+
+		id := ep.Addresses[0]
+		if ep.TargetRef != nil && ep.TargetRef.Name != "" {
+			id = ep.TargetRef.Name
+		}
+
+		var tags []string
+		weight := 0
+		if ep.Zone != nil && *ep.Zone != "" {
+			tags = append(tags, TagZone+*ep.Zone)
+		}
+		if ep.NodeName != nil && *ep.NodeName != "" {
+			tags = append(tags, TagNode+*ep.NodeName)
+		}
+		tags = append(tags, labelTags...)
+		if len(zoneWeights) > 0 {
+			weight = 1
+			if ep.Zone != nil && zoneWeights[*ep.Zone] > 0 {
+				weight = zoneWeights[*ep.Zone]
+			}
+		}
+
+		for _, port := range slice.Ports {
+			if port.Port == nil {
+				continue
+			}
+			if port.Protocol != nil && *port.Protocol != "TCP" {
+				continue
+			}
+
+			var name string
+			if port.Name != nil {
+				name = *port.Name
+			}
+			scheme := "http"
+			if *port.Port == 443 || (port.AppProtocol != nil && *port.AppProtocol == "https") {
+				scheme = "https"
+			}
+
+			result = append(result, &serviceregistry.ServiceInstanceSpec{
+				RegistryName: registryName,
+				ServiceName:  ServiceName(slice.Namespace, service, name),
+				InstanceID:   fmt.Sprintf("%s:%d", id, *port.Port),
+				Address:      ep.Addresses[0],
+				Port:         uint16(*port.Port),
+				Scheme:       scheme,
+				Tags:         tags,
+				Weight:       weight,
+			})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetesserviceregistry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apicorev1 "k8s.io/api/core/v1"
+	apidiscoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEndpointSliceToServiceInstances(t *testing.T) {
+	assert := assert.New(t)
+
+	ready, notReady := true, false
+	zoneA, zoneB, node := "zone-a", "zone-b", "node-1"
+	http, https := "http", "https"
+	port80, port8443, port53 := int32(80), int32(8443), int32(53)
+	tcp, udp := apicorev1.ProtocolTCP, apicorev1.ProtocolUDP
+
+	slice := &apidiscoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "order-abcde",
+			Labels: map[string]string{
+				apidiscoveryv1.LabelServiceName: "order",
+				apidiscoveryv1.LabelManagedBy:   "endpointslice-controller.k8s.io",
+				"app":                           "order",
+			},
+		},
+		AddressType: apidiscoveryv1.AddressTypeIPv4,
+		Endpoints: []apidiscoveryv1.Endpoint{
+			{
+				Addresses:  []string{"10.0.0.1"},
+				Conditions: apidiscoveryv1.EndpointConditions{Ready: &ready},
+				TargetRef:  &apicorev1.ObjectReference{Kind: "Pod", Name: "order-1"},
+				NodeName:   &node,
+				Zone:       &zoneA,
+			},
+			{
+				Addresses: []string{"10.0.0.2"},
+				Zone:      &zoneB,
+			},
+			{
+				Addresses:  []string{"10.0.0.3"},
+				Conditions: apidiscoveryv1.EndpointConditions{Ready: &notReady},
+			},
+			{
+				Addresses:  []string{"10.0.0.4"},
+				Conditions: apidiscoveryv1.EndpointConditions{Terminating: &ready},
+			},
+		},
+		Ports: []apidiscoveryv1.EndpointPort{
+			{Name: &http, Port: &port80, Protocol: &tcp},
+			{Name: &https, Port: &port8443, Protocol: &tcp, AppProtocol: &https},
+			{Port: &port53, Protocol: &udp},
+		},
+	}
+
+	instances := endpointSliceToServiceInstances("k8s", slice, nil)
+	assert.Len(instances, 4)
+
+	first := instances[0]
+	assert.Equal("k8s", first.RegistryName)
+	assert.Equal("order.default:http", first.ServiceName)
+	assert.Equal("order-1:80", first.InstanceID)
+	assert.Equal("10.0.0.1", first.Address)
+	assert.Equal(uint16(80), first.Port)
+	assert.Equal("http", first.Scheme)
+	assert.Equal([]string{"zone=zone-a", "node=node-1", "app=order"}, first.Tags)
+	assert.Equal(0, first.Weight)
+	assert.NoError(first.Validate())
+
+	assert.Equal("order.default:https", instances[1].ServiceName)
+	assert.Equal("https", instances[1].Scheme)
+
+	assert.Equal("10.0.0.2:80", instances[2].InstanceID)
+	assert.Equal([]string{"zone=zone-b", "app=order"}, instances[2].Tags)
+
+	instances = endpointSliceToServiceInstances("k8s", slice, map[string]int{zoneA: 10})
+	assert.Equal(10, instances[0].Weight)
+	assert.Equal(1, instances[2].Weight)
+
+	delete(slice.Labels, apidiscoveryv1.LabelServiceName)
+	assert.Empty(endpointSliceToServiceInstances("k8s", slice, nil))
+}
+
+func TestServiceName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("order.default", ServiceName("default", "order", ""))
+	assert.Equal("order.default:grpc", ServiceName("default", "order", "grpc"))
+}
@@ -32,11 +32,11 @@ import (
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/google/uuid"
 	"github.com/megaease/easegress/pkg/api"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/mqttprot"
-	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/codectool"
 	"github.com/openzipkin/zipkin-go/model"
 	"github.com/openzipkin/zipkin-go/propagation/b3"
@@ -51,6 +51,7 @@ type (
 		spec   *Spec
 
 		listener  net.Listener
+		wsServer  *http.Server
 		clients   map[string]*Client
 		tlsCfg    *tls.Config
 		pipelines map[PacketType]string
@@ -58,8 +59,12 @@ type (
 
 		sessMgr           *SessionManager
 		topicMgr          TopicManager
+		retainMgr         *retainManager
+		offlineQueue      *offlineQueue
 		sessionCacheMgr   SessionCacheManager
+		sharedSelector    *sharedSelector
 		connectionLimiter *Limiter
+		metrics           *brokerMetrics
 		memberURL         func(string, string) (map[string]string, error)
 
 		// done is the channel for shutdowning this proxy.
@@ -73,7 +78,16 @@ type (
 		QoS         int    `json:"qos"`
 		Payload     string `json:"payload"`
 		Base64      bool   `json:"base64"`
+		Retain      bool   `json:"retain"`
 		Distributed bool   `json:"distributed"`
+		// Properties is the MQTT 5 properties of the message
+		Properties *mqttprot.Properties `json:"properties,omitempty"`
+		// SharedSubscribers is the chosen members of shared subscriptions
+		// on the eg receiving the message, it's used in broker mode only.
+		SharedSubscribers []string `json:"sharedSubscribers,omitempty"`
+		// Publisher is the client id of the publisher of the message, it's
+		// used to choose members of shared subscriptions by hash strategy.
+		Publisher string `json:"publisher,omitempty"`
 	}
 
 	// HTTPSessions is json data used for session related operations, like get all sessions and delete some sessions
@@ -83,8 +97,24 @@ type (
 
 	// HTTPSession is json data used for session related operations, like get all sessions and delete some sessions
 	HTTPSession struct {
-		SessionID string `json:"sessionID"`
-		Topic     string `json:"topic"`
+		SessionID    string   `json:"sessionID"`
+		Topic        string   `json:"topic"`
+		SharedGroups []string `json:"sharedGroups,omitempty"`
+		// OfflineQueueDepth is the number of messages queued for the
+		// offline session
+		OfflineQueueDepth int `json:"offlineQueueDepth,omitempty"`
+	}
+
+	// HTTPRetainedMessages is json data used for retained message related operations, like list and clear retained messages
+	HTTPRetainedMessages struct {
+		Messages []*HTTPRetainedMessage `json:"messages"`
+	}
+
+	// HTTPRetainedMessage is json data of a retained message, the payload is encoded in base64
+	HTTPRetainedMessage struct {
+		Topic   string `json:"topic"`
+		QoS     int    `json:"qos"`
+		Payload string `json:"payload,omitempty"`
 	}
 )
 
@@ -124,6 +154,7 @@ func newBroker(spec *Spec, store storage, muxMapper context.MuxMapper, memberURL
 		memberURL: memberURL,
 		done:      make(chan struct{}),
 		muxMapper: muxMapper,
+		metrics:   newBrokerMetrics(spec),
 	}
 	pipelines, err := getPipelineMap(spec)
 	if err != nil {
@@ -136,19 +167,45 @@ func newBroker(spec *Spec, store storage, muxMapper context.MuxMapper, memberURL
 		logger.SpanErrorf(nil, "mqtt broker set listener failed: %v", err)
 		return nil
 	}
+	err = broker.setWebSocketListener()
+	if err != nil {
+		logger.SpanErrorf(nil, "mqtt broker set websocket listener failed: %v", err)
+		broker.listener.Close()
+		return nil
+	}
+	broker.offlineQueue, err = newOfflineQueue(spec, store)
+	if err != nil {
+		logger.SpanErrorf(nil, "mqtt broker create offline queue failed: %v", err)
+		broker.listener.Close()
+		if broker.wsServer != nil {
+			broker.wsServer.Close()
+		}
+		return nil
+	}
 
 	if spec.TopicCacheSize <= 0 {
 		spec.TopicCacheSize = 100000
 	}
 	broker.topicMgr = newTopicManager(spec)
 	broker.sessMgr = newSessionManager(broker, store)
+	broker.retainMgr = newRetainManager(store)
+	broker.sharedSelector = newSharedSelector(spec.SharedSubscriptionStrategy)
 	broker.connectionLimiter = newLimiter(spec.ConnectionLimit)
 	go broker.run()
+	if spec.SysInterval != "" {
+		interval, err := time.ParseDuration(spec.SysInterval)
+		if err != nil || interval <= 0 {
+			logger.SpanErrorf(nil, "invalid sysInterval %s, $SYS topics are disabled", spec.SysInterval)
+		} else {
+			go broker.runSys(interval)
+		}
+	}
 
 	if spec.BrokerMode {
 		broker.sessionCacheMgr = newSessionCacheManager(spec, broker.topicMgr)
 	}
 	broker.connectWatcher()
+	registerBroker(broker)
 	return broker
 }
 
@@ -220,6 +277,7 @@ func (b *Broker) connectWatcher() {
 	for _, c := range clients {
 		c.close()
 	}
+	b.restoreOffline(sessions)
 
 	// start watch when finish connect and update
 	go b.watch(ch, cancelFunc)
@@ -319,6 +377,8 @@ func (b *Broker) handleNewSessionInCluster(clientID string, v *string, sessionIn
 		// just ignore it.
 		return
 	}
+	// the queued messages are drained by the new broker
+	b.dropOffline(clientID)
 
 	// The new session was established on the different broker,
 	// we should disconnect the connection in the current broker,
@@ -332,6 +392,11 @@ func (b *Broker) handleNewSessionInCluster(clientID string, v *string, sessionIn
 }
 
 func (b *Broker) deleteSession(clientID string) {
+	if b.offlineQueue != nil && b.offlineQueue.session(clientID) != nil {
+		b.dropOffline(clientID)
+		b.offlineQueue.clear(clientID)
+	}
+
 	b.Lock()
 	defer b.Unlock()
 	if c, ok := b.clients[clientID]; ok {
@@ -362,6 +427,7 @@ func (b *Broker) checkConnectPermission(connect *packets.ConnectPacket) bool {
 	size := connect.RemainingLength + 8
 	permitted := b.connectionLimiter.acquirePermission(size)
 	if !permitted {
+		b.metrics.dropped(dropReasonConnectionLimit)
 		return permitted
 	}
 	// check here to do early stop for connection. Later we will check it again to make sure
@@ -371,18 +437,28 @@ func (b *Broker) checkConnectPermission(connect *packets.ConnectPacket) bool {
 		connNum := len(b.clients)
 		b.Unlock()
 		if connNum >= b.spec.MaxAllowedConnection {
+			b.metrics.dropped(dropReasonMaxAllowedConnection)
 			return false
 		}
 	}
 	return true
 }
 
-func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.Conn) (*Client, *packets.ConnackPacket, bool) {
+// writeConnack writes CONNACK to the connection in the protocol version of CONNECT.
+func writeConnack(conn net.Conn, connect *packets.ConnectPacket, connack *packets.ConnackPacket, props *mqttprot.Properties) error {
+	var ext *mqttprot.Extension
+	if props != nil {
+		ext = &mqttprot.Extension{Properties: props}
+	}
+	return mqttprot.WritePacket(conn, connack, ext, protocolVersion(connect))
+}
+
+func (b *Broker) connectionValidation(connect *packets.ConnectPacket, ext *mqttprot.Extension, conn net.Conn) (*Client, *packets.ConnackPacket, bool) {
 	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
 	connack.SessionPresent = connect.CleanSession
-	connack.ReturnCode = connect.Validate()
+	connack.ReturnCode = mqttprot.ValidateConnect(connect)
 	if connack.ReturnCode != packets.Accepted {
-		err := connack.Write(conn)
+		err := writeConnack(conn, connect, connack, nil)
 		logger.SpanErrorf(nil, "invalid connection %v, write connack failed: %s", connack.ReturnCode, err)
 		return nil, nil, false
 	}
@@ -390,7 +466,7 @@ func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.C
 	if !b.checkConnectPermission(connect) {
 		logger.SpanDebugf(nil, "client %v not get connect permission from rate limiter", connect.ClientIdentifier)
 		connack.ReturnCode = packets.ErrRefusedServerUnavailable
-		err := connack.Write(conn)
+		err := writeConnack(conn, connect, connack, nil)
 		if err != nil {
 			logger.SpanErrorf(nil, "connack back to client %s failed: %s", connect.ClientIdentifier, err)
 		}
@@ -398,6 +474,7 @@ func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.C
 	}
 
 	client := newClient(connect, b, conn, b.spec.ClientPublishLimit)
+	client.setConnectExtension(ext)
 	// check auth
 	authFail := false
 
@@ -408,7 +485,7 @@ func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.C
 			logger.SpanErrorf(nil, "get pipeline %v failed", authPipeline)
 			authFail = true
 		} else {
-			ctx := newContext(connect, client)
+			ctx := client.newContext(connect, ext)
 			pipe.Handle(ctx)
 			res := ctx.GetResponse(context.DefaultNamespace).(*mqttprot.Response)
 			if res.Disconnect() {
@@ -419,7 +496,7 @@ func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.C
 	}
 	if authFail {
 		connack.ReturnCode = packets.ErrRefusedNotAuthorised
-		err := connack.Write(conn)
+		err := writeConnack(conn, connect, connack, nil)
 		if err != nil {
 			logger.SpanErrorf(nil, "connack back to client %s failed: %s", connect.ClientIdentifier, err)
 		}
@@ -429,9 +506,28 @@ func (b *Broker) connectionValidation(connect *packets.ConnectPacket, conn net.C
 	return client, connack, true
 }
 
+// connackProperties returns the properties of CONNACK for MQTT 5 client.
+func connackProperties(client *Client, assignedID bool) *mqttprot.Properties {
+	if client.version != mqttprot.Version5 {
+		return nil
+	}
+	aliasMaximum := topicAliasMaximum
+	unavailable, available := byte(0), byte(1)
+	props := &mqttprot.Properties{
+		TopicAliasMaximum:               &aliasMaximum,
+		SubscriptionIdentifierAvailable: &unavailable,
+		SharedSubscriptionAvailable:     &available,
+	}
+	if assignedID {
+		props.AssignedClientIdentifier = client.info.cid
+	}
+	return props
+}
+
 func (b *Broker) handleConn(conn net.Conn) {
+	conn = b.metrics.countConn(conn)
 	defer conn.Close()
-	packet, err := packets.ReadPacket(conn)
+	packet, ext, err := mqttprot.ReadPacket(conn, 0)
 	if err != nil {
 		logger.SpanErrorf(nil, "read connect packet failed: %s", err)
 		return
@@ -441,9 +537,16 @@ func (b *Broker) handleConn(conn net.Conn) {
 		logger.SpanErrorf(nil, "first packet received %s that was not Connect", packet.String())
 		return
 	}
+	// MQTT 5 client could connect with empty client id, and the broker
+	// assigns one to it.
+	assignedID := false
+	if protocolVersion(connect) == mqttprot.Version5 && connect.ClientIdentifier == "" {
+		connect.ClientIdentifier = "easegress-" + uuid.NewString()
+		assignedID = true
+	}
 	logger.SpanDebugf(nil, "connection from client %s", connect.ClientIdentifier)
 
-	client, connack, valid := b.connectionValidation(connect, conn)
+	client, connack, valid := b.connectionValidation(connect, ext, conn)
 	if !valid {
 		return
 	}
@@ -456,9 +559,10 @@ func (b *Broker) handleConn(conn net.Conn) {
 
 	} else if b.spec.MaxAllowedConnection > 0 {
 		if len(b.clients) >= b.spec.MaxAllowedConnection {
+			b.metrics.dropped(dropReasonMaxAllowedConnection)
 			logger.SpanDebugf(nil, "client %v not get connect permission from rate limiter", connect.ClientIdentifier)
 			connack.ReturnCode = packets.ErrRefusedServerUnavailable
-			err = connack.Write(conn)
+			err = writeConnack(conn, connect, connack, nil)
 			if err != nil {
 				logger.SpanErrorf(nil, "connack back to client %s failed: %s", connect.ClientIdentifier, err)
 			}
@@ -466,11 +570,27 @@ func (b *Broker) handleConn(conn net.Conn) {
 			return
 		}
 	}
-	b.clients[client.info.cid] = client
 	b.Unlock()
 
 	b.setSession(client, connect)
-	err = connack.Write(conn)
+
+	// the client is added after its session is set, because other
+	// goroutines access the sessions of clients in b.clients.
+	b.Lock()
+	if b.clients == nil {
+		// broker is closed.
+		b.Unlock()
+		return
+	}
+	if oldClient, ok := b.clients[cid]; ok {
+		go oldClient.close()
+	}
+	client.draining = b.offlineQueue != nil
+	b.clients[cid] = client
+	b.metrics.connected(len(b.clients))
+	b.Unlock()
+
+	err = writeConnack(conn, connect, connack, connackProperties(client, assignedID))
 	if err != nil {
 		logger.SpanErrorf(nil, "send connack to client %s failed: %s", connect.ClientIdentifier, err)
 		return
@@ -485,6 +605,9 @@ func (b *Broker) handleConn(conn net.Conn) {
 		}
 	}
 	go client.writeLoop()
+	client.session.resendAll(client)
+	b.drainOffline(client)
+	client.releaseHeld()
 	client.readLoop()
 }
 
@@ -492,6 +615,14 @@ func (b *Broker) setSession(client *Client, connect *packets.ConnectPacket) {
 	// when clean session is false, previous session exist and previous session not clean session,
 	// then we use previous session, otherwise use new session
 	prevSess := b.sessMgr.get(connect.ClientIdentifier)
+	existed := prevSess != nil
+	// the expired session in store is overwritten by the new session, don't
+	// delete it, otherwise the watcher closes the new connection.
+	if prevSess != nil && prevSess.expired(time.Now()) {
+		logger.SpanDebugf(nil, "session of client %v expired", connect.ClientIdentifier)
+		b.sessMgr.delLocal(connect.ClientIdentifier)
+		prevSess = nil
+	}
 	if !connect.CleanSession && (prevSess != nil) && !prevSess.cleanSession() {
 		client.session = prevSess
 	} else {
@@ -499,27 +630,38 @@ func (b *Broker) setSession(client *Client, connect *packets.ConnectPacket) {
 			prevSess.close()
 		}
 		client.session = b.sessMgr.newSessionFromConn(connect)
+		// messages queued for the previous session are discarded
+		if b.offlineQueue != nil && existed {
+			b.dropOffline(connect.ClientIdentifier)
+			b.offlineQueue.clear(connect.ClientIdentifier)
+		}
+	}
+	if client.version == mqttprot.Version5 {
+		client.session.setExpiry(client.sessionExpiry)
 	}
 }
 
-func (b *Broker) requestTransfer(span *model.SpanContext, egName, name string, data HTTPJsonData, header http.Header) {
+// requestTransfer transfers data to other egs, shared is the chosen members
+// of shared subscriptions grouped by eg name.
+func (b *Broker) requestTransfer(span *model.SpanContext, egName, name string, data HTTPJsonData, header http.Header, shared map[string][]string) {
 	urls, err := b.memberURL(egName, name)
 	if err != nil {
 		logger.SpanErrorf(span, "eg %v find urls for other egs failed:%v", b.egName, err)
 		return
 	}
-	jsonData, err := codectool.MarshalJSON(data)
-	if err != nil {
-		logger.SpanErrorf(span, "json data marshal failed: %v", err)
-		return
-	}
-	for _, url := range urls {
+	for eg, url := range urls {
+		data.SharedSubscribers = shared[eg]
+		jsonData, err := codectool.MarshalJSON(data)
+		if err != nil {
+			logger.SpanErrorf(span, "json data marshal failed: %v", err)
+			return
+		}
 		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
-		req.Header = header.Clone()
 		if err != nil {
 			logger.SpanErrorf(span, "make new request failed: %v", err)
 			continue
 		}
+		req.Header = header.Clone()
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			logger.SpanErrorf(span, "http client send msg failed:%v", err)
@@ -530,7 +672,33 @@ func (b *Broker) requestTransfer(span *model.SpanContext, egName, name string, d
 	logger.SpanDebugf(span, "eg %v http transfer data %v to %v", b.egName, data, urls)
 }
 
-func (b *Broker) sendMsgToClient(span *model.SpanContext, topic string, payload []byte, qos byte) {
+// chooseSharedMembers chooses one member of every shared subscription group,
+// and returns the subscriber ids of chosen members grouped by eg name.
+func (b *Broker) chooseSharedMembers(groups map[string][]*sharedMember, key string) map[string][]string {
+	res := make(map[string][]string)
+	for group, members := range groups {
+		m := b.sharedSelector.choose(group, members, key)
+		egName := b.egName
+		if b.spec.BrokerMode {
+			egName = b.sessionCacheMgr.getEGName(m.clientID)
+		}
+		res[egName] = append(res[egName], m.id)
+	}
+	return res
+}
+
+// subscriberClientID returns the client id of the subscriber id in topic manager.
+func subscriberClientID(id string) string {
+	if _, clientID, ok := parseSharedMemberID(id); ok {
+		return clientID
+	}
+	return id
+}
+
+// sendMsgToClient sends message to local subscribers. In broker mode, the members
+// of shared subscriptions are chosen by the eg receiving the message first and
+// passed by shared, otherwise every eg chooses members from its own clients.
+func (b *Broker) sendMsgToClient(span *model.SpanContext, topic string, payload []byte, qos byte, props *mqttprot.Properties, publisher string, shared []string) {
 	subscribers, _ := b.topicMgr.findSubscribers(topic)
 	logger.SpanDebugf(span, "eg %v send topic %v to client %v", b.egName, topic, subscribers)
 	if subscribers == nil {
@@ -538,10 +706,18 @@ func (b *Broker) sendMsgToClient(span *model.SpanContext, topic string, payload
 		return
 	}
 
-	for clientID, subQoS := range subscribers {
-		if subQoS < qos {
-			continue
+	clients, groups := splitShared(subscribers)
+	if !b.spec.BrokerMode {
+		shared = b.chooseSharedMembers(groups, publisher)[b.egName]
+	}
+	for _, id := range shared {
+		if subQoS, ok := subscribers[id]; ok {
+			clients[id] = subQoS
 		}
+	}
+
+	for id, subQoS := range clients {
+		clientID := subscriberClientID(id)
 		if b.spec.BrokerMode {
 			egName := b.sessionCacheMgr.getEGName(clientID)
 			if egName != b.egName {
@@ -551,63 +727,87 @@ func (b *Broker) sendMsgToClient(span *model.SpanContext, topic string, payload
 		client := b.getClient(clientID)
 		if client == nil {
 			logger.SpanDebugf(span, "client %v not on broker %v in eg %v", clientID, b.name, b.egName)
+			b.publishOffline(span, clientID, topic, payload, deliveryQoS(qos, subQoS), props)
 		} else {
-			client.session.publish(span, topic, payload, qos)
+			client.publishLive(span, topic, payload, deliveryQoS(qos, subQoS), props)
 		}
 	}
 }
 
+// deliveryQoS returns the QoS to deliver message to subscriber, which is
+// the minimum of the QoS of publish and subscription.
+func deliveryQoS(pubQoS, subQoS byte) byte {
+	if subQoS < pubQoS {
+		return subQoS
+	}
+	return pubQoS
+}
+
 // splitSubscribers split subscribers to local and remote on broker mode and return
-// client ids of local subscribers and eg names of remote subscribers
-func (b *Broker) splitSubscribers(publish *packets.PublishPacket) ([]string, map[string]struct{}) {
-	egNames := make(map[string]struct{})
-	clients := []string{}
+// subscriber ids with qos of local subscribers and eg names of remote subscribers
+// with chosen members of shared subscriptions on them. key is used to choose
+// members of shared subscriptions.
+func (b *Broker) splitSubscribers(publish *packets.PublishPacket, key string) (map[string]byte, map[string][]string) {
+	egNames := make(map[string][]string)
+	local := make(map[string]byte)
 
 	subscribers, _ := b.topicMgr.findSubscribers(publish.TopicName)
-	for clientID, subQos := range subscribers {
-		if subQos < publish.Qos {
+	clients, groups := splitShared(subscribers)
+	for clientID, subQos := range clients {
+		egName := b.sessionCacheMgr.getEGName(clientID)
+		if egName != b.egName {
+			if _, ok := egNames[egName]; !ok {
+				egNames[egName] = nil
+			}
 			continue
 		}
-		egName := b.sessionCacheMgr.getEGName(clientID)
+		local[clientID] = subQos
+	}
+	for egName, ids := range b.chooseSharedMembers(groups, key) {
 		if egName != b.egName {
-			egNames[egName] = struct{}{}
+			egNames[egName] = append(egNames[egName], ids...)
 			continue
 		}
-		clients = append(clients, clientID)
+		for _, id := range ids {
+			local[id] = subscribers[id]
+		}
 	}
-	return clients, egNames
+	return local, egNames
 }
 
-func (b *Broker) sendMsgToLocalClient(span *model.SpanContext, publish *packets.PublishPacket, clients []string) {
-	for _, clientID := range clients {
+func (b *Broker) sendMsgToLocalClient(span *model.SpanContext, publish *packets.PublishPacket, props *mqttprot.Properties, subscribers map[string]byte) {
+	for id, subQos := range subscribers {
+		clientID := subscriberClientID(id)
 		client := b.getClient(clientID)
 		if client == nil {
 			logger.SpanDebugf(span, "client %v not on broker %v in eg %v", clientID, b.name, b.egName)
+			b.publishOffline(span, clientID, publish.TopicName, publish.Payload, deliveryQoS(publish.Qos, subQos), props)
 		} else {
-			client.session.publish(span, publish.TopicName, publish.Payload, publish.Qos)
+			client.publishLive(span, publish.TopicName, publish.Payload, deliveryQoS(publish.Qos, subQos), props)
 		}
 	}
 }
 
-func (b *Broker) requestTransferToCertainInstances(span *model.SpanContext, publish *packets.PublishPacket, remoteEgs map[string]struct{}) {
+func (b *Broker) requestTransferToCertainInstances(span *model.SpanContext, publish *packets.PublishPacket, props *mqttprot.Properties, remoteEgs map[string][]string) {
 	data := &HTTPJsonData{}
-	data.init(publish)
+	data.init(publish, props)
 	urls, err := b.memberURL(b.egName, b.name)
 	if err != nil {
 		logger.SpanErrorf(span, "eg %v find urls for other egs failed: %v", b.egName, err)
 		return
 	}
-	jsonData, err := codectool.MarshalJSON(data)
-	if err != nil {
-		logger.SpanErrorf(span, "json data marshal failed: %v", err)
-		return
-	}
-	for egName := range remoteEgs {
+	for egName, shared := range remoteEgs {
 		url, ok := urls[egName]
 		if !ok {
 			logger.SpanErrorf(span, "eg %s not find url for eg %s", b.egName, egName)
 			continue
 		}
+		data.SharedSubscribers = shared
+		jsonData, err := codectool.MarshalJSON(data)
+		if err != nil {
+			logger.SpanErrorf(span, "json data marshal failed: %v", err)
+			return
+		}
 		req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
 		if err != nil {
 			logger.SpanErrorf(span, "make new request failed: %v", err)
@@ -628,21 +828,21 @@ func (b *Broker) requestTransferToCertainInstances(span *model.SpanContext, publ
 
 }
 
-func (b *Broker) processBrokerModePublish(clientID string, publish *packets.PublishPacket) {
+func (b *Broker) processBrokerModePublish(clientID string, publish *packets.PublishPacket, props *mqttprot.Properties) {
 	if !b.spec.BrokerMode {
 		return
 	}
-	localClients, remoteEgs := b.splitSubscribers(publish)
+	localClients, remoteEgs := b.splitSubscribers(publish, clientID)
 	if len(localClients) == 0 && len(remoteEgs) == 0 {
 		return
 	}
 
 	span := generateNewSpanContext(clientID, publish.TopicName)
 	if len(localClients) > 0 {
-		b.sendMsgToLocalClient(span, publish, localClients)
+		b.sendMsgToLocalClient(span, publish, props, localClients)
 	}
 	if len(remoteEgs) > 0 {
-		b.requestTransferToCertainInstances(span, publish, remoteEgs)
+		b.requestTransferToCertainInstances(span, publish, props, remoteEgs)
 	}
 }
 
@@ -660,6 +860,7 @@ func (b *Broker) removeClient(clientID string) {
 	if val, ok := b.clients[clientID]; ok {
 		if val.disconnected() {
 			delete(b.clients, clientID)
+			b.metrics.disconnected(len(b.clients))
 		}
 	}
 	b.Unlock()
@@ -693,12 +894,41 @@ func (b *Broker) httpTopicsPublishHandler(w http.ResponseWriter, r *http.Request
 
 	span, _ := b3.ExtractHTTP(r)()
 	logger.SpanDebugf(span, "http endpoint received json data: %v", data)
+	err = b.publishBackendMessage(span, data, payload, r.Header.Clone())
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+	}
+}
+
+// publishBackendMessage publishes the message from backend to subscribers,
+// it's transferred to other egs if the message is not distributed yet.
+func (b *Broker) publishBackendMessage(span *model.SpanContext, data HTTPJsonData, payload []byte, header http.Header) error {
+	if data.Retain && !data.Distributed {
+		publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		publish.TopicName = data.Topic
+		publish.Qos = byte(data.QoS)
+		publish.Payload = payload
+		publish.Retain = true
+		if err := b.retainMgr.retain(publish, data.Properties); err != nil {
+			return fmt.Errorf("retain message failed: %v", err)
+		}
+	}
+	// in broker mode, the eg receiving the message from backend chooses the
+	// members of shared subscriptions for all egs.
+	shared := data.SharedSubscribers
 	if !data.Distributed {
+		var chosen map[string][]string
+		if b.spec.BrokerMode {
+			subscribers, _ := b.topicMgr.findSubscribers(data.Topic)
+			_, groups := splitShared(subscribers)
+			chosen = b.chooseSharedMembers(groups, data.Publisher)
+		}
+		shared = chosen[b.egName]
 		data.Distributed = true
-		headers := r.Header.Clone()
-		b.requestTransfer(span, b.egName, b.name, data, headers)
+		b.requestTransfer(span, b.egName, b.name, data, header, chosen)
 	}
-	go b.sendMsgToClient(span, data.Topic, payload, byte(data.QoS))
+	go b.sendMsgToClient(span, data.Topic, payload, byte(data.QoS), data.Properties, data.Publisher, shared)
+	return nil
 }
 
 func (b *Broker) mqttAPIPrefix(path string) string {
@@ -773,6 +1003,10 @@ func (b *Broker) queryAllSessions(allSession map[string]string, query bool, page
 		return res
 	}
 
+	var depths map[string]int
+	if b.offlineQueue != nil {
+		depths = b.offlineQueue.queueDepths()
+	}
 	index := 0
 	start := page*pageSize - pageSize
 	end := page * pageSize
@@ -784,9 +1018,11 @@ func (b *Broker) queryAllSessions(allSession map[string]string, query bool, page
 			for k := range session.info.Topics {
 				if strings.Contains(k, topic) {
 					httpSession := &HTTPSession{
-						SessionID: session.info.ClientID,
-						Topic:     k,
+						SessionID:    session.info.ClientID,
+						Topic:        k,
+						SharedGroups: session.info.sharedGroups(),
 					}
+					httpSession.OfflineQueueDepth = depths[session.info.ClientID]
 					res.Sessions = append(res.Sessions, httpSession)
 					break
 				}
@@ -822,6 +1058,56 @@ func (b *Broker) httpDeleteSessionHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+func (b *Broker) httpGetRetainedHandler(w http.ResponseWriter, r *http.Request) {
+	span, _ := b3.ExtractHTTP(r)()
+	filter := r.URL.Query().Get("q")
+	if filter == "" {
+		filter = "#"
+	}
+	logger.SpanDebugf(span, "http endpoint receive request to get retained messages of %v", filter)
+	if _, ok := splitTopic(filter); !ok {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid topic filter %v", filter))
+		return
+	}
+
+	res := &HTTPRetainedMessages{Messages: []*HTTPRetainedMessage{}}
+	for _, msg := range b.retainMgr.find(filter) {
+		res.Messages = append(res.Messages, &HTTPRetainedMessage{
+			Topic:   msg.Topic,
+			QoS:     msg.QoS,
+			Payload: msg.B64Payload,
+		})
+	}
+
+	jsonData, err := codectool.MarshalJSON(res)
+	if err != nil {
+		logger.SpanErrorf(span, "retained messages json marshal failed, %v", err)
+		api.HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("retained messages json marshal failed, %v", err))
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (b *Broker) httpDeleteRetainedHandler(w http.ResponseWriter, r *http.Request) {
+	var data HTTPRetainedMessages
+	err := codectool.DecodeJSON(r.Body, &data)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("invalid json data from request body"))
+		return
+	}
+
+	span, _ := b3.ExtractHTTP(r)()
+	logger.SpanDebugf(span, "http endpoint received delete retained messages data: %v", data)
+	// the topic could be a topic filter, to clear retained messages of all matched topics
+	for _, m := range data.Messages {
+		for _, msg := range b.retainMgr.find(m.Topic) {
+			if err := b.retainMgr.delete(msg.Topic); err != nil {
+				logger.SpanErrorf(span, "delete retained message %v failed, %v", msg.Topic, err)
+			}
+		}
+	}
+}
+
 func (b *Broker) currentClients() map[string]struct{} {
 	ans := make(map[string]struct{})
 	b.Lock()
@@ -839,6 +1125,8 @@ func (b *Broker) registerAPIs() {
 			{Path: b.mqttAPIPrefix(mqttAPITopicPublishPrefix), Method: http.MethodPost, Handler: b.httpTopicsPublishHandler},
 			{Path: b.mqttAPIPrefix(mqttAPISessionQueryPrefix), Method: http.MethodGet, Handler: b.httpGetAllSessionHandler},
 			{Path: b.mqttAPIPrefix(mqttAPISessionDeletePrefix), Method: http.MethodDelete, Handler: b.httpDeleteSessionHandler},
+			{Path: b.mqttAPIPrefix(mqttAPIRetainedPrefix), Method: http.MethodGet, Handler: b.httpGetRetainedHandler},
+			{Path: b.mqttAPIPrefix(mqttAPIRetainedPrefix), Method: http.MethodDelete, Handler: b.httpDeleteRetainedHandler},
 		},
 	}
 
@@ -857,8 +1145,16 @@ func (b *Broker) closed() bool {
 func (b *Broker) close() {
 	b.setClose()
 	close(b.done)
+	unregisterBroker(b)
 	b.listener.Close()
+	if b.wsServer != nil {
+		b.wsServer.Close()
+	}
 	b.sessMgr.close()
+	b.retainMgr.close()
+	if b.offlineQueue != nil {
+		b.offlineQueue.close()
+	}
 	b.topicMgr.close()
 	if b.spec.BrokerMode {
 		b.sessionCacheMgr.close()
@@ -872,15 +1168,6 @@ func (b *Broker) close() {
 	b.clients = nil
 }
 
-func newContext(packet packets.ControlPacket, client mqttprot.Client) *context.Context {
-	ctx := context.New(tracing.NoopSpan)
-	req := mqttprot.NewRequest(packet, client)
-	ctx.SetRequest(context.DefaultNamespace, req)
-	resp := mqttprot.NewResponse()
-	ctx.SetResponse(context.DefaultNamespace, resp)
-	return ctx
-}
-
 func generateNewSpanContext(clientID string, topic string) *model.SpanContext {
 	span := &model.SpanContext{}
 	span.TraceID.High = rand.Uint64()
@@ -890,10 +1177,56 @@ func generateNewSpanContext(clientID string, topic string) *model.SpanContext {
 	return span
 }
 
-func (d *HTTPJsonData) init(packet *packets.PublishPacket) {
+func (d *HTTPJsonData) init(packet *packets.PublishPacket, props *mqttprot.Properties) {
 	d.Topic = packet.TopicName
 	d.QoS = int(packet.Qos)
 	d.Payload = base64.StdEncoding.EncodeToString(packet.Payload)
 	d.Base64 = true
 	d.Distributed = true
+	d.Properties = props
+}
+
+// brokers maps name of MQTTProxy to its broker, it's used by filters to
+// serve WebSocket connections and publish messages from backends.
+var brokers = struct {
+	sync.RWMutex
+	m map[string]*Broker
+}{m: make(map[string]*Broker)}
+
+func registerBroker(b *Broker) {
+	brokers.Lock()
+	defer brokers.Unlock()
+	brokers.m[b.name] = b
+}
+
+func unregisterBroker(b *Broker) {
+	brokers.Lock()
+	defer brokers.Unlock()
+	// the broker may have been replaced by a new generation
+	if brokers.m[b.name] == b {
+		delete(brokers.m, b.name)
+	}
+}
+
+// PublishMessage publishes the message from backend to subscribers of the
+// MQTTProxy of the name in the whole cluster, like the publish API.
+func PublishMessage(name string, topic string, payload []byte, qos byte, retain bool, props *mqttprot.Properties) error {
+	brokers.RLock()
+	b := brokers.m[name]
+	brokers.RUnlock()
+	if b == nil || b.closed() {
+		return fmt.Errorf("MQTTProxy %s not found", name)
+	}
+	if qos > QoS2 {
+		return fmt.Errorf("invalid qos %d", qos)
+	}
+	data := HTTPJsonData{
+		Topic:      topic,
+		QoS:        int(qos),
+		Payload:    base64.StdEncoding.EncodeToString(payload),
+		Base64:     true,
+		Retain:     retain,
+		Properties: props,
+	}
+	return b.publishBackendMessage(nil, data, payload, nil)
 }
@@ -72,49 +72,67 @@ func (mgr *cachedTopicManager) findSubscribers(topic string) (map[string]byte, e
 }
 
 func (mgr *cachedTopicManager) subscribe(topics []string, qoss []byte, clientID string) error {
-	allLevels, err := mgr.levelCache.getAll(topics)
+	subscribers, err := groupBySubscriber(topics, qoss, clientID)
 	if err != nil {
 		return err
 	}
+	for id, st := range subscribers {
+		allLevels, err := mgr.levelCache.getAll(st.topics)
+		if err != nil {
+			return err
+		}
 
-	mgr.writeCh <- &topicOp{
-		opType: subscribe,
-		subscribeOp: &subscribeOp{
-			allLevels: allLevels,
-			qoss:      qoss,
-			clientID:  clientID,
-		},
+		mgr.writeCh <- &topicOp{
+			opType: subscribe,
+			subscribeOp: &subscribeOp{
+				allLevels: allLevels,
+				qoss:      st.qoss,
+				clientID:  id,
+			},
+		}
 	}
 	return nil
 }
 
 func (mgr *cachedTopicManager) unsubscribe(topics []string, clientID string) error {
-	allLevels, err := mgr.levelCache.getAll(topics)
+	subscribers, err := groupBySubscriber(topics, nil, clientID)
 	if err != nil {
 		return err
 	}
+	for id, st := range subscribers {
+		allLevels, err := mgr.levelCache.getAll(st.topics)
+		if err != nil {
+			return err
+		}
 
-	mgr.writeCh <- &topicOp{
-		opType: unsubscribe,
-		unsubscribeOp: &unsubscribeOp{
-			allLevels: allLevels,
-			clientID:  clientID,
-		},
+		mgr.writeCh <- &topicOp{
+			opType: unsubscribe,
+			unsubscribeOp: &unsubscribeOp{
+				allLevels: allLevels,
+				clientID:  id,
+			},
+		}
 	}
 	return nil
 }
 
 func (mgr *cachedTopicManager) disconnect(topics []string, clientID string) error {
-	allLevels, err := mgr.levelCache.getAll(topics)
+	subscribers, err := groupBySubscriber(topics, nil, clientID)
 	if err != nil {
 		return err
 	}
-	mgr.writeCh <- &topicOp{
-		opType: disconnect,
-		disconnectOp: &disconnectOp{
-			allLevels: allLevels,
-			clientID:  clientID,
-		},
+	for id, st := range subscribers {
+		allLevels, err := mgr.levelCache.getAll(st.topics)
+		if err != nil {
+			return err
+		}
+		mgr.writeCh <- &topicOp{
+			opType: disconnect,
+			disconnectOp: &disconnectOp{
+				allLevels: allLevels,
+				clientID:  id,
+			},
+		}
 	}
 	return nil
 }
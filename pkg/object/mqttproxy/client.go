@@ -18,9 +18,13 @@
 package mqttproxy
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -29,6 +33,8 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"golang.org/x/net/websocket"
 )
 
 const (
@@ -43,32 +49,52 @@ const (
 	QoS1 byte = 1
 	// QoS2 for "Exactly once"
 	QoS2 byte = 2
+
+	// topicAliasMaximum is the maximum topic alias accepted from MQTT 5 client.
+	topicAliasMaximum uint16 = 1024
+	// defaultReceiveMaximum is the Receive Maximum of MQTT 5 client if it's absent.
+	defaultReceiveMaximum uint16 = 65535
+	// reasonDisconnectWithWill is the reason code of DISCONNECT that asks
+	// the broker to publish the will message.
+	reasonDisconnectWithWill byte = 0x04
+	// subackFailure is the return code of SUBACK for a denied topic of
+	// MQTT 3.1.1 client.
+	subackFailure byte = 0x80
 )
 
-type processFn func(*Client, packets.ControlPacket)
-type processFnWithErr func(*Client, packets.ControlPacket) error
+type processFn func(*Client, packets.ControlPacket, *mqttprot.Extension)
+type processFnWithErr func(*Client, packets.ControlPacket, *mqttprot.Extension) error
 
 var processPacketMap = map[string]processFnWithErr{
 	"*packets.ConnectPacket":     errorWrapper("double connect"),
 	"*packets.ConnackPacket":     errorWrapper("client should not send connack"),
-	"*packets.PubrecPacket":      errorWrapper("qos2 not support now"),
-	"*packets.PubrelPacket":      errorWrapper("qos2 not support now"),
-	"*packets.PubcompPacket":     errorWrapper("qos2 not support now"),
 	"*packets.SubackPacket":      errorWrapper("broker not subscribe"),
 	"*packets.UnsubackPacket":    errorWrapper("broker not unsubscribe"),
 	"*packets.PingrespPacket":    errorWrapper("broker not ping"),
-	"*packets.SubscribePacket":   pipelineWrapper(processSubscribe, Subscribe),
+	"*packets.SubscribePacket":   subscribeWrapper,
 	"*packets.UnsubscribePacket": pipelineWrapper(processUnsubscribe, Unsubscribe),
 	"*packets.PingreqPacket":     nilErrWrapper(processPingreq),
 	"*packets.PubackPacket":      nilErrWrapper(processPuback),
-	"*packets.PublishPacket": func(c *Client, packet packets.ControlPacket) error {
+	"*packets.PubrecPacket":      nilErrWrapper(processPubrec),
+	"*packets.PubrelPacket":      nilErrWrapper(processPubrel),
+	"*packets.PubcompPacket":     nilErrWrapper(processPubcomp),
+	"*packets.PublishPacket": func(c *Client, packet packets.ControlPacket, ext *mqttprot.Extension) error {
 		publish := packet.(*packets.PublishPacket)
 		logger.SpanDebugf(nil, "client %s process publish %v", c.info.cid, publish.TopicName)
 		if !c.checkPublishLimit(publish) {
+			c.broker.metrics.dropped(dropReasonClientPublishLimit)
 			logger.SpanErrorf(nil, "client %v publish limiter drop packet %v", c.info.cid, publish.TopicName)
 			return nil
 		}
-		return pipelineWrapper(processPublish, Publish)(c, packet)
+		c.broker.metrics.published(publish.TopicName, len(publish.Payload))
+		if publish.Qos == QoS2 && c.session.hasReceived(publish.MessageID) {
+			// QoS 2 message received before but not released, only acknowledge
+			// it again and not deliver it twice.
+			logger.SpanDebugf(nil, "client %s resend qos2 publish %v", c.info.cid, publish.MessageID)
+			c.writePacket(newPubrec(publish.MessageID))
+			return nil
+		}
+		return pipelineWrapper(processPublish, Publish)(c, packet, ext)
 	},
 }
 
@@ -80,6 +106,7 @@ type (
 		password  string
 		keepalive uint16
 		will      *packets.PublishPacket
+		willProps *mqttprot.Properties
 	}
 
 	// Client represents a MQTT client connection in Broker
@@ -96,12 +123,35 @@ type (
 		writeCh    chan packets.ControlPacket
 		done       chan struct{}
 
+		// version is the protocol version of the connection, the following
+		// fields are only used by MQTT 5 client.
+		version           byte
+		sessionExpiry     uint32
+		receiveMaximum    uint16
+		maximumPacketSize uint32
+		// topicAliases maps topic aliases of incoming PUBLISH to topic names,
+		// it's only accessed by readLoop.
+		topicAliases map[uint16]string
+
 		// kv map is used for pipeline to share messages among filters during whole connection
 		kvMap sync.Map
+
+		// draining is set until the offline queue of the client is
+		// drained, live messages are held during that time, so that they
+		// don't overtake the queued messages.
+		heldMutex sync.Mutex
+		draining  bool
+		held      []func()
+	}
+
+	// extPacket is a packet to write to MQTT 5 client with its extension.
+	extPacket struct {
+		packets.ControlPacket
+		ext *mqttprot.Extension
 	}
 )
 
-var _ mqttprot.Client = (*Client)(nil)
+var _ mqttprot.TLSClient = (*Client)(nil)
 
 // ClientID return client id of Client
 func (c *Client) ClientID() string {
@@ -128,6 +178,20 @@ func (c *Client) UserName() string {
 	return c.info.username
 }
 
+// PeerCertificates return the verified certificates presented by Client,
+// it's nil if Client is not connected with TLS or presents no certificate.
+func (c *Client) PeerCertificates() []*x509.Certificate {
+	switch conn := unwrapConn(c.conn).(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().PeerCertificates
+	case *websocket.Conn:
+		if r := conn.Request(); r != nil && r.TLS != nil {
+			return r.TLS.PeerCertificates
+		}
+	}
+	return nil
+}
+
 func newClient(connect *packets.ConnectPacket, broker *Broker, conn net.Conn, limitSpec *RateLimit) *Client {
 	var will *packets.PublishPacket
 	if connect.WillFlag {
@@ -154,14 +218,96 @@ func newClient(connect *packets.ConnectPacket, broker *Broker, conn net.Conn, li
 		writeCh:      make(chan packets.ControlPacket, 50),
 		done:         make(chan struct{}),
 		publishLimit: newLimiter(limitSpec),
+		version:      protocolVersion(connect),
 	}
 	return client
 }
 
+// protocolVersion returns the protocol version of the connection.
+func protocolVersion(connect *packets.ConnectPacket) byte {
+	if connect.ProtocolVersion == mqttprot.Version5 {
+		return mqttprot.Version5
+	}
+	return mqttprot.Version311
+}
+
+// setConnectExtension sets the MQTT 5 states of the connection from the
+// extension of CONNECT packet.
+func (c *Client) setConnectExtension(ext *mqttprot.Extension) {
+	if c.version != mqttprot.Version5 {
+		return
+	}
+	c.receiveMaximum = defaultReceiveMaximum
+	c.topicAliases = make(map[uint16]string)
+	if ext == nil {
+		return
+	}
+	c.info.willProps = ext.WillProperties
+	props := ext.Properties
+	if props == nil {
+		return
+	}
+	if props.SessionExpiryInterval != nil {
+		c.sessionExpiry = *props.SessionExpiryInterval
+	}
+	if props.ReceiveMaximum != nil && *props.ReceiveMaximum > 0 {
+		c.receiveMaximum = *props.ReceiveMaximum
+	}
+	if props.MaximumPacketSize != nil {
+		c.maximumPacketSize = *props.MaximumPacketSize
+	}
+}
+
+// resolveTopicAlias sets the topic name of the PUBLISH packet with topic
+// alias, and records the alias if the topic name is present.
+func (c *Client) resolveTopicAlias(publish *packets.PublishPacket, ext *mqttprot.Extension) error {
+	props := ext.GetProperties()
+	if props == nil || props.TopicAlias == nil {
+		return nil
+	}
+	alias := *props.TopicAlias
+	if alias == 0 || alias > topicAliasMaximum {
+		return fmt.Errorf("invalid topic alias %d", alias)
+	}
+	if publish.TopicName != "" {
+		c.topicAliases[alias] = publish.TopicName
+		return nil
+	}
+	topic, ok := c.topicAliases[alias]
+	if !ok {
+		return fmt.Errorf("unknown topic alias %d", alias)
+	}
+	publish.TopicName = topic
+	return nil
+}
+
+// packetWithProperties attaches the properties to the packet if the client
+// uses MQTT 5.
+func (c *Client) packetWithProperties(p packets.ControlPacket, props *mqttprot.Properties) packets.ControlPacket {
+	if c.version != mqttprot.Version5 || props == nil {
+		return p
+	}
+	return &extPacket{ControlPacket: p, ext: &mqttprot.Extension{Properties: props}}
+}
+
+// fitPacketSize returns false if the packet exceeds the Maximum Packet Size
+// of the MQTT 5 client.
+func (c *Client) fitPacketSize(p packets.ControlPacket) bool {
+	if c.version != mqttprot.Version5 || c.maximumPacketSize == 0 {
+		return true
+	}
+	var ext *mqttprot.Extension
+	if ep, ok := p.(*extPacket); ok {
+		p, ext = ep.ControlPacket, ep.ext
+	}
+	buf, err := mqttprot.EncodePacket(p, ext)
+	return err == nil && len(buf) <= int(c.maximumPacketSize)
+}
+
 func (c *Client) readLoop() {
 	defer func() {
 		if c.info.will != nil {
-			c.runPipeline(c.info.will, Publish)
+			c.publishWill()
 		}
 		c.closeAndDelSession()
 		c.broker.removeClient(c.info.cid)
@@ -182,16 +328,22 @@ func (c *Client) readLoop() {
 		}
 
 		logger.SpanDebugf(nil, "client %s readLoop read packet", c.info.cid)
-		packet, err := packets.ReadPacket(c.conn)
+		packet, ext, err := mqttprot.ReadPacket(c.conn, c.version)
 		if err != nil {
 			logger.SpanErrorf(nil, "client %s read packet failed: %v", c.info.cid, err)
 			return
 		}
 		if _, ok := packet.(*packets.DisconnectPacket); ok {
-			c.info.will = nil
+			c.processDisconnect(ext)
 			return
 		}
-		err = c.processPacket(packet)
+		if publish, ok := packet.(*packets.PublishPacket); ok && c.version == mqttprot.Version5 {
+			if err := c.resolveTopicAlias(publish, ext); err != nil {
+				logger.SpanErrorf(nil, "client %s publish failed: %v", c.info.cid, err)
+				return
+			}
+		}
+		err = c.processPacketWithExtension(packet, ext)
 		if err != nil {
 			logger.SpanErrorf(nil, "client %s process packet failed: %v", c.info.cid, err)
 			return
@@ -199,13 +351,37 @@ func (c *Client) readLoop() {
 	}
 }
 
+// processDisconnect processes the DISCONNECT packet, MQTT 5 client may
+// update the session expiry interval or ask to publish the will message.
+func (c *Client) processDisconnect(ext *mqttprot.Extension) {
+	if ext == nil || ext.ReasonCode != reasonDisconnectWithWill {
+		c.info.will = nil
+	}
+	props := ext.GetProperties()
+	if props == nil || props.SessionExpiryInterval == nil {
+		return
+	}
+	// it's a protocol error to set a non-zero interval if it was zero
+	// in CONNECT, ignore it and keep the session clean.
+	if c.sessionExpiry == 0 && *props.SessionExpiryInterval != 0 {
+		logger.SpanErrorf(nil, "client %s set session expiry interval in disconnect, but it's zero in connect", c.info.cid)
+		return
+	}
+	c.sessionExpiry = *props.SessionExpiryInterval
+	c.session.setExpiry(c.sessionExpiry)
+}
+
 func (c *Client) processPacket(packet packets.ControlPacket) error {
+	return c.processPacketWithExtension(packet, nil)
+}
+
+func (c *Client) processPacketWithExtension(packet packets.ControlPacket, ext *mqttprot.Extension) error {
 	packetType := reflect.TypeOf(packet).String()
 	fn, ok := processPacketMap[packetType]
 	if !ok {
 		return errors.New("unknown packet")
 	}
-	return fn(c, packet)
+	return fn(c, packet, ext)
 }
 
 func (c *Client) checkPublishLimit(publish *packets.PublishPacket) bool {
@@ -215,29 +391,40 @@ func (c *Client) checkPublishLimit(publish *packets.PublishPacket) bool {
 
 // runPipeline will run MQTT pipeline by using packet.
 // it will return an error if MQTT pipline set MQTTContext to Disconnect or Drop.
-func (c *Client) runPipeline(packet packets.ControlPacket, packetType PacketType) error {
+// The returned extension contains the properties set by filters.
+func (c *Client) runPipeline(packet packets.ControlPacket, ext *mqttprot.Extension, packetType PacketType) (*mqttprot.Extension, *mqttprot.Response, error) {
 	pipelineName, ok := c.broker.pipelines[packetType]
 	if !ok {
-		return nil
+		return ext, nil, nil
 	}
 
 	pipe, ok := c.broker.muxMapper.GetHandler(pipelineName)
 	if !ok {
 		logger.SpanErrorf(nil, "get pipeline %v failed", pipelineName)
-		return nil
+		return ext, nil, nil
 	}
 
-	ctx := newContext(packet, c)
+	ctx := c.newContext(packet, ext)
 	pipe.Handle(ctx)
+	req := ctx.GetRequest(context.DefaultNamespace).(*mqttprot.Request)
 	resp := ctx.GetResponse(context.DefaultNamespace).(*mqttprot.Response)
 	if resp.Disconnect() {
 		c.close()
-		return errors.New("pipeline set disconnect")
+		return nil, nil, errors.New("pipeline set disconnect")
 	}
 	if resp.Drop() {
-		return errors.New("pipeline set drop")
+		return nil, nil, errors.New("pipeline set drop")
 	}
-	return nil
+	return req.Extension(), resp, nil
+}
+
+func (c *Client) newContext(packet packets.ControlPacket, ext *mqttprot.Extension) *context.Context {
+	ctx := context.New(tracing.NoopSpan)
+	req := mqttprot.NewRequestWithExtension(packet, ext, c.version, c)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	resp := mqttprot.NewResponse()
+	ctx.SetResponse(context.DefaultNamespace, resp)
+	return ctx
 }
 
 func (c *Client) writePacket(packet packets.ControlPacket) {
@@ -248,10 +435,16 @@ func (c *Client) writeLoop() {
 	for {
 		select {
 		case p := <-c.writeCh:
-			err := p.Write(c.conn)
+			var ext *mqttprot.Extension
+			if ep, ok := p.(*extPacket); ok {
+				p, ext = ep.ControlPacket, ep.ext
+			}
+			err := mqttprot.WritePacket(c.conn, p, ext, c.version)
 			if err != nil {
 				logger.SpanErrorf(nil, "write packet %v to client %s failed: %s", p.String(), c.info.cid, err)
 				c.closeAndDelSession()
+			} else if _, ok := p.(*packets.PublishPacket); ok {
+				c.broker.metrics.delivered()
 			}
 		case <-c.done:
 			return
@@ -281,7 +474,7 @@ func (c *Client) close() {
 		logger.SpanErrorf(nil, "get pipeline %v failed", pipelineName)
 	} else {
 		disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
-		ctx := newContext(disconnect, c)
+		ctx := c.newContext(disconnect, nil)
 		pipe.Handle(ctx)
 	}
 }
@@ -296,44 +489,89 @@ func (c *Client) closeAndDelSession() {
 	// global store, otherwise it means that the device reconnect to
 	// the another broker, the current broker just disconnect connection.
 	// and clean local session information.
+	if !c.session.cleanSession() {
+		c.session.disconnect(time.Now())
+	}
 	deleted := c.broker.sessMgr.delLocal(c.info.cid)
 	if c.session.cleanSession() && deleted {
 		c.broker.sessMgr.delDB(c.info.cid)
 	}
 
-	topics, _, _ := c.session.allSubscribes()
-	c.broker.topicMgr.unsubscribe(topics, c.info.cid)
+	// subscriptions of persistent session are kept to queue messages
+	// when offline queue is enabled
+	if !c.broker.keepOffline(c) {
+		topics, _, _ := c.session.allSubscribes()
+		c.broker.topicMgr.unsubscribe(topics, c.info.cid)
+	}
 	c.close()
 }
 
 func errorWrapper(errMsg string) processFnWithErr {
-	return func(c *Client, p packets.ControlPacket) error {
+	return func(c *Client, p packets.ControlPacket, ext *mqttprot.Extension) error {
 		return errors.New(errMsg)
 	}
 }
 
 func nilErrWrapper(fn processFn) processFnWithErr {
-	return func(c *Client, p packets.ControlPacket) error {
-		fn(c, p)
+	return func(c *Client, p packets.ControlPacket, ext *mqttprot.Extension) error {
+		fn(c, p, ext)
 		return nil
 	}
 }
 
 func pipelineWrapper(fn processFn, packetType PacketType) processFnWithErr {
-	return func(c *Client, p packets.ControlPacket) error {
-		err := c.runPipeline(p, packetType)
+	return func(c *Client, p packets.ControlPacket, ext *mqttprot.Extension) error {
+		ext, _, err := c.runPipeline(p, ext, packetType)
 		if err != nil {
 			logger.SpanDebugf(nil, "client process pipeline failed, %v", c.info.cid, err)
 			return nil
 		}
-		fn(c, p)
+		fn(c, p, ext)
 		return nil
 	}
 }
 
-func processPublish(c *Client, packet packets.ControlPacket) {
+// subscribeWrapper runs the subscribe pipeline, the topics denied by the
+// pipeline are not subscribed and get failure codes in SUBACK.
+func subscribeWrapper(c *Client, p packets.ControlPacket, ext *mqttprot.Extension) error {
+	ext, resp, err := c.runPipeline(p, ext, Subscribe)
+	if err != nil {
+		logger.SpanDebugf(nil, "client %s process pipeline failed, %v", c.info.cid, err)
+		return nil
+	}
+	processSubscribe(c, p, ext, resp)
+	return nil
+}
+
+// publishWill publishes the will message like a normal publish packet
+// from the client, which goes through the publish pipeline, updates the
+// retained message, and is delivered to subscribers in the whole cluster
+// in broker mode.
+func (c *Client) publishWill() {
+	will := c.info.will
+	var ext *mqttprot.Extension
+	if c.info.willProps != nil {
+		ext = &mqttprot.Extension{Properties: c.info.willProps}
+	}
+	ext, _, err := c.runPipeline(will, ext, Publish)
+	if err != nil {
+		logger.SpanDebugf(nil, "client %v will message dropped by pipeline, %v", c.info.cid, err)
+		return
+	}
+	props := ext.GetProperties()
+	if err := c.broker.retainMgr.retain(will, props); err != nil {
+		logger.SpanErrorf(nil, "client %v retain will message failed: %v", c.info.cid, err)
+	}
+	c.broker.processBrokerModePublish(c.info.cid, will, props)
+}
+
+func processPublish(c *Client, packet packets.ControlPacket, ext *mqttprot.Extension) {
 	publish := packet.(*packets.PublishPacket)
-	go c.broker.processBrokerModePublish(c.info.cid, publish)
+	props := ext.GetProperties()
+	if err := c.broker.retainMgr.retain(publish, props); err != nil {
+		logger.SpanErrorf(nil, "client %v retain message of %v failed: %v", c.info.cid, publish.TopicName, err)
+	}
+	go c.broker.processBrokerModePublish(c.info.cid, publish, props)
 	switch publish.Qos {
 	case QoS0:
 		// do nothing
@@ -342,40 +580,140 @@ func processPublish(c *Client, packet packets.ControlPacket) {
 		puback.MessageID = publish.MessageID
 		c.writePacket(puback)
 	case QoS2:
-		// not support yet
+		c.session.receive(publish.MessageID)
+		c.writePacket(newPubrec(publish.MessageID))
 	}
 }
 
-func processPuback(c *Client, packet packets.ControlPacket) {
+func newPubrec(id uint16) *packets.PubrecPacket {
+	pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	pubrec.MessageID = id
+	return pubrec
+}
+
+func processPuback(c *Client, packet packets.ControlPacket, _ *mqttprot.Extension) {
 	puback := packet.(*packets.PubackPacket)
 	c.session.puback(puback)
 }
 
-func processSubscribe(c *Client, p packets.ControlPacket) {
+func processPubrec(c *Client, packet packets.ControlPacket, _ *mqttprot.Extension) {
+	pubrec := packet.(*packets.PubrecPacket)
+	c.session.pubrec(pubrec)
+
+	// always send PUBREL, the client may lose the previous one
+	pubrel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	pubrel.MessageID = pubrec.MessageID
+	c.writePacket(pubrel)
+}
+
+func processPubrel(c *Client, packet packets.ControlPacket, _ *mqttprot.Extension) {
+	pubrel := packet.(*packets.PubrelPacket)
+	c.session.release(pubrel.MessageID)
+
+	pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	pubcomp.MessageID = pubrel.MessageID
+	c.writePacket(pubcomp)
+}
+
+func processPubcomp(c *Client, packet packets.ControlPacket, _ *mqttprot.Extension) {
+	pubcomp := packet.(*packets.PubcompPacket)
+	c.session.pubcomp(pubcomp)
+}
+
+func processSubscribe(c *Client, p packets.ControlPacket, ext *mqttprot.Extension, resp *mqttprot.Response) {
 	packet := p.(*packets.SubscribePacket)
 	logger.SpanDebugf(nil, "client %s subscribe %v with qos %v", c.info.cid, packet.Topics, packet.Qoss)
 
-	err := c.broker.topicMgr.subscribe(packet.Topics, packet.Qoss, c.info.cid)
-	if err != nil {
-		logger.SpanErrorf(nil, "client %v subscribe %v failed: %v", c.info.cid, packet.Topics, err)
-		return
+	denied := func(i int) bool {
+		return resp != nil && resp.TopicDenied(i)
+	}
+
+	// topics subscribed before, used by retain handling of MQTT 5
+	subscribed := map[string]struct{}{}
+	topics, _, _ := c.session.allSubscribes()
+	for _, t := range topics {
+		subscribed[t] = struct{}{}
 	}
-	c.session.subscribe(packet.Topics, packet.Qoss)
 
 	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
 	suback.MessageID = packet.MessageID
 	suback.ReturnCodes = make([]byte, len(packet.Topics))
-	for i := range packet.Topics {
-		suback.ReturnCodes[i] = packet.Qos
+	reasonCodes := make([]byte, len(packet.Topics))
+	topics, qoss := make([]string, 0, len(packet.Topics)), make([]byte, 0, len(packet.Topics))
+	for i, topic := range packet.Topics {
+		if denied(i) {
+			suback.ReturnCodes[i] = subackFailure
+			reasonCodes[i] = mqttprot.ReasonNotAuthorized
+			continue
+		}
+		suback.ReturnCodes[i] = packet.Qoss[i]
+		reasonCodes[i] = packet.Qoss[i]
+		topics = append(topics, topic)
+		qoss = append(qoss, packet.Qoss[i])
+	}
+	if len(topics) < len(packet.Topics) {
+		logger.SpanDebugf(nil, "client %s subscribe %d of %d topics denied by pipeline", c.info.cid, len(packet.Topics)-len(topics), len(packet.Topics))
+	}
+
+	if len(topics) > 0 {
+		err := c.broker.topicMgr.subscribe(topics, qoss, c.info.cid)
+		if err != nil {
+			logger.SpanErrorf(nil, "client %v subscribe %v failed: %v", c.info.cid, topics, err)
+			return
+		}
+		c.session.subscribe(topics, qoss)
+	}
+
+	if c.version == mqttprot.Version5 && len(topics) < len(packet.Topics) {
+		c.writePacket(&extPacket{ControlPacket: suback, ext: &mqttprot.Extension{ReasonCodes: reasonCodes}})
+	} else {
+		c.writePacket(suback)
+	}
+
+	// send retained messages of the new subscriptions
+	for i, topic := range packet.Topics {
+		if denied(i) {
+			continue
+		}
+		// retained messages are not sent for shared subscriptions
+		if strings.HasPrefix(topic, sharePrefix) {
+			continue
+		}
+		if ext != nil && i < len(ext.SubscriptionOptions) {
+			// 1 for sending retained messages only if the subscription
+			// doesn't exist, 2 for not sending retained messages.
+			switch ext.SubscriptionOptions[i].RetainHandling {
+			case 1:
+				if _, ok := subscribed[topic]; ok {
+					continue
+				}
+			case 2:
+				continue
+			}
+		}
+		for _, msg := range c.broker.retainMgr.find(topic) {
+			c.session.publishRetained(nil, msg, deliveryQoS(byte(msg.QoS), packet.Qoss[i]))
+		}
 	}
-	c.writePacket(suback)
 }
 
-func processUnsubscribe(c *Client, p packets.ControlPacket) {
+func processUnsubscribe(c *Client, p packets.ControlPacket, _ *mqttprot.Extension) {
 	packet := p.(*packets.UnsubscribePacket)
 
 	logger.SpanDebugf(nil, "client %s processUnsubscribe %v", c.info.cid, packet.Topics)
 
+	subscribed := map[string]struct{}{}
+	topics, _, _ := c.session.allSubscribes()
+	for _, t := range topics {
+		subscribed[t] = struct{}{}
+	}
+	reasonCodes := make([]byte, len(packet.Topics))
+	for i, t := range packet.Topics {
+		if _, ok := subscribed[t]; !ok {
+			reasonCodes[i] = mqttprot.ReasonNoSubscriptionExisted
+		}
+	}
+
 	err := c.broker.topicMgr.unsubscribe(packet.Topics, c.info.cid)
 	if err != nil {
 		logger.SpanErrorf(nil, "client %v unsubscribe %v failed: %v", c.info.cid, packet.Topics, err)
@@ -384,10 +722,14 @@ func processUnsubscribe(c *Client, p packets.ControlPacket) {
 
 	unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
 	unsuback.MessageID = packet.MessageID
+	if c.version == mqttprot.Version5 {
+		c.writePacket(&extPacket{ControlPacket: unsuback, ext: &mqttprot.Extension{ReasonCodes: reasonCodes}})
+		return
+	}
 	c.writePacket(unsuback)
 }
 
-func processPingreq(c *Client, packet packets.ControlPacket) {
+func processPingreq(c *Client, packet packets.ControlPacket, _ *mqttprot.Extension) {
 	resp := packets.NewControlPacket(packets.Pingresp).(*packets.PingrespPacket)
 	c.writePacket(resp)
 }
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/prometheushelper"
+	"github.com/megaease/easegress/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	sysTopicPrefix = "$SYS/broker/"
+
+	dropReasonClientPublishLimit = "clientPublishLimit"
+	dropReasonConnectionLimit    = "connectionLimit"
+	// dropReasonMaxAllowedConnection is for connections refused because
+	// of maxAllowedConnection
+	dropReasonMaxAllowedConnection = "maxAllowedConnection"
+)
+
+type (
+	// brokerMetrics is the Prometheus metrics of MQTTProxy, and the
+	// statistics published to $SYS topics.
+	brokerMetrics struct {
+		// topicLevels is the number of leading levels of topic prefix
+		// of per topic metrics, 0 disables them.
+		topicLevels int
+		startAt     time.Time
+
+		// statistics since start, they're accessed atomically
+		bytesReceived    uint64
+		bytesSent        uint64
+		messagesReceived uint64
+		messagesSent     uint64
+		messagesDropped  uint64
+
+		Connections      prometheus.Gauge
+		ConnectionsTotal prometheus.Counter
+		Publishes        prometheus.Counter
+		Deliveries       prometheus.Counter
+		Dropped          *prometheus.CounterVec
+		BytesReceived    prometheus.Counter
+		BytesSent        prometheus.Counter
+		TopicMessages    *prometheus.CounterVec
+		TopicBytes       *prometheus.CounterVec
+	}
+
+	// countingConn counts the bytes read from and written to a connection.
+	countingConn struct {
+		net.Conn
+		metrics *brokerMetrics
+	}
+
+	// sysStats is a snapshot of the statistics for $SYS topics.
+	sysStats struct {
+		at               time.Time
+		bytesReceived    uint64
+		bytesSent        uint64
+		messagesReceived uint64
+		messagesSent     uint64
+	}
+)
+
+func newBrokerMetrics(spec *Spec) *brokerMetrics {
+	labels := prometheus.Labels{
+		"instanceName":  spec.EGName,
+		"mqttProxyName": spec.Name,
+	}
+	labelNames := []string{"instanceName", "mqttProxyName"}
+	m := &brokerMetrics{
+		topicLevels: spec.TopicMetricsLevels,
+		startAt:     time.Now(),
+		Connections: prometheushelper.NewGauge(
+			"mqttproxy_connections",
+			"the number of connected clients",
+			labelNames).With(labels),
+		ConnectionsTotal: prometheushelper.NewCounter(
+			"mqttproxy_connections_total",
+			"the total count of accepted connections",
+			labelNames).With(labels),
+		Publishes: prometheushelper.NewCounter(
+			"mqttproxy_publishes_total",
+			"the total count of messages published by clients",
+			labelNames).With(labels),
+		Deliveries: prometheushelper.NewCounter(
+			"mqttproxy_deliveries_total",
+			"the total count of messages delivered to clients",
+			labelNames).With(labels),
+		Dropped: prometheushelper.NewCounter(
+			"mqttproxy_dropped_total",
+			"the total count of messages and connections dropped by rate limits",
+			append(labelNames, "reason")).MustCurryWith(labels),
+		BytesReceived: prometheushelper.NewCounter(
+			"mqttproxy_received_bytes_total",
+			"the total bytes received from clients",
+			labelNames).With(labels),
+		BytesSent: prometheushelper.NewCounter(
+			"mqttproxy_sent_bytes_total",
+			"the total bytes sent to clients",
+			labelNames).With(labels),
+	}
+	if m.topicLevels > 0 {
+		m.TopicMessages = prometheushelper.NewCounter(
+			"mqttproxy_topic_publishes_total",
+			"the total count of messages published by clients per topic prefix",
+			append(labelNames, "topicPrefix")).MustCurryWith(labels)
+		m.TopicBytes = prometheushelper.NewCounter(
+			"mqttproxy_topic_publish_bytes_total",
+			"the total payload bytes published by clients per topic prefix",
+			append(labelNames, "topicPrefix")).MustCurryWith(labels)
+	}
+	return m
+}
+
+// topicPrefix returns the leading levels of topic.
+func (m *brokerMetrics) topicPrefix(topic string) string {
+	levels := strings.SplitN(topic, "/", m.topicLevels+1)
+	if len(levels) > m.topicLevels {
+		levels = levels[:m.topicLevels]
+	}
+	return strings.Join(levels, "/")
+}
+
+func (m *brokerMetrics) connected(n int) {
+	m.ConnectionsTotal.Inc()
+	m.Connections.Set(float64(n))
+}
+
+func (m *brokerMetrics) disconnected(n int) {
+	m.Connections.Set(float64(n))
+}
+
+// published records a message published by a client.
+func (m *brokerMetrics) published(topic string, size int) {
+	atomic.AddUint64(&m.messagesReceived, 1)
+	m.Publishes.Inc()
+	if m.topicLevels > 0 {
+		prefix := m.topicPrefix(topic)
+		m.TopicMessages.WithLabelValues(prefix).Inc()
+		m.TopicBytes.WithLabelValues(prefix).Add(float64(size))
+	}
+}
+
+// delivered records a message sent to a client.
+func (m *brokerMetrics) delivered() {
+	atomic.AddUint64(&m.messagesSent, 1)
+	m.Deliveries.Inc()
+}
+
+// dropped records a message or connection dropped for reason.
+func (m *brokerMetrics) dropped(reason string) {
+	atomic.AddUint64(&m.messagesDropped, 1)
+	m.Dropped.WithLabelValues(reason).Inc()
+}
+
+func (m *brokerMetrics) snapshot(now time.Time) *sysStats {
+	return &sysStats{
+		at:               now,
+		bytesReceived:    atomic.LoadUint64(&m.bytesReceived),
+		bytesSent:        atomic.LoadUint64(&m.bytesSent),
+		messagesReceived: atomic.LoadUint64(&m.messagesReceived),
+		messagesSent:     atomic.LoadUint64(&m.messagesSent),
+	}
+}
+
+func (m *brokerMetrics) countConn(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, metrics: m}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&c.metrics.bytesReceived, uint64(n))
+		c.metrics.BytesReceived.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&c.metrics.bytesSent, uint64(n))
+		c.metrics.BytesSent.Add(float64(n))
+	}
+	return n, err
+}
+
+// unwrapConn returns the connection wrapped by countingConn.
+func unwrapConn(conn net.Conn) net.Conn {
+	if c, ok := conn.(*countingConn); ok {
+		return c.Conn
+	}
+	return conn
+}
+
+// runSys publishes the statistics to $SYS topics periodically.
+func (b *Broker) runSys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := b.metrics.snapshot(time.Now())
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			stats := b.metrics.snapshot(now)
+			b.publishSys(last, stats)
+			last = stats
+		}
+	}
+}
+
+// publishSys publishes the statistics of this instance to the local
+// subscribers of $SYS topics. Rates are per second during the interval
+// between last and current.
+func (b *Broker) publishSys(last, current *sysStats) {
+	seconds := current.at.Sub(last.at).Seconds()
+	rate := func(cur, prev uint64) string {
+		if seconds <= 0 {
+			return "0"
+		}
+		return strconv.FormatFloat(float64(cur-prev)/seconds, 'f', 2, 64)
+	}
+	count := func(n uint64) string {
+		return strconv.FormatUint(n, 10)
+	}
+
+	b.RLock()
+	clients := make([]*Client, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.RUnlock()
+	subscriptions := 0
+	for _, c := range clients {
+		topics, _, _ := c.session.allSubscribes()
+		subscriptions += len(topics)
+	}
+
+	values := map[string]string{
+		"version":                     version.Short,
+		"uptime":                      strconv.FormatInt(int64(current.at.Sub(b.metrics.startAt).Seconds()), 10),
+		"clients/connected":           strconv.Itoa(len(clients)),
+		"messages/received":           count(current.messagesReceived),
+		"messages/sent":               count(current.messagesSent),
+		"messages/dropped":            count(atomic.LoadUint64(&b.metrics.messagesDropped)),
+		"bytes/received":              count(current.bytesReceived),
+		"bytes/sent":                  count(current.bytesSent),
+		"load/messages/received":      rate(current.messagesReceived, last.messagesReceived),
+		"load/messages/sent":          rate(current.messagesSent, last.messagesSent),
+		"load/bytes/received":         rate(current.bytesReceived, last.bytesReceived),
+		"load/bytes/sent":             rate(current.bytesSent, last.bytesSent),
+		"retained messages/count":     strconv.Itoa(b.retainMgr.count()),
+		"subscriptions/count":         strconv.Itoa(subscriptions),
+		"clients/maximum_connections": strconv.Itoa(b.spec.MaxAllowedConnection),
+	}
+	for name, value := range values {
+		b.publishToLocalSubscribers(sysTopicPrefix+name, []byte(value))
+	}
+}
+
+// publishToLocalSubscribers publishes the QoS 0 message to the subscribers
+// connected to this instance only.
+func (b *Broker) publishToLocalSubscribers(topic string, payload []byte) {
+	subscribers, err := b.topicMgr.findSubscribers(topic)
+	if err != nil {
+		logger.SpanErrorf(nil, "find subscribers of %s failed: %v", topic, err)
+		return
+	}
+	for id := range subscribers {
+		if client := b.getClient(subscriberClientID(id)); client != nil {
+			client.session.publish(nil, topic, payload, QoS0)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicPrefix(t *testing.T) {
+	assert := assert.New(t)
+	m := &brokerMetrics{topicLevels: 2}
+	assert.Equal("a/b", m.topicPrefix("a/b/c/d"))
+	assert.Equal("a/b", m.topicPrefix("a/b"))
+	assert.Equal("a", m.topicPrefix("a"))
+	assert.Equal("/a", m.topicPrefix("/a/b"))
+}
+
+func TestBrokerMetrics(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.SysInterval = "100ms"
+	spec.TopicMetricsLevels = 1
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+	metrics := broker.metrics
+
+	ch := make(chan CheckMsg, 100)
+	client := getDefaultMQTTClient(t, "metrics", true)
+	defer client.Disconnect(200)
+	token := client.Subscribe("metrics/#", QoS0, getMQTTSubscribeHandler(ch))
+	token.Wait()
+	assert.Nil(token.Error())
+
+	received := atomic.LoadUint64(&metrics.messagesReceived)
+	token = client.Publish("devices/1", QoS0, false, []byte("hello"))
+	token.Wait()
+	assert.Nil(token.Error())
+	assert.Eventually(func() bool {
+		return atomic.LoadUint64(&metrics.messagesReceived) == received+1
+	}, 3*time.Second, 50*time.Millisecond)
+
+	sent := atomic.LoadUint64(&metrics.messagesSent)
+	broker.sendMsgToClient(nil, "metrics/1", []byte("world"), QoS0, nil, "", nil)
+	select {
+	case msg := <-ch:
+		assert.Equal(CheckMsg{topic: "metrics/1", payload: "world", qos: 0}, msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not received")
+	}
+	assert.Eventually(func() bool {
+		return atomic.LoadUint64(&metrics.messagesSent) == sent+1
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.NotZero(atomic.LoadUint64(&metrics.bytesReceived))
+	assert.NotZero(atomic.LoadUint64(&metrics.bytesSent))
+
+	// $SYS topics are published periodically
+	token = client.Subscribe("$SYS/broker/clients/connected", QoS0, getMQTTSubscribeHandler(ch))
+	token.Wait()
+	assert.Nil(token.Error())
+	select {
+	case msg := <-ch:
+		assert.Equal(CheckMsg{topic: "$SYS/broker/clients/connected", payload: "1", qos: 0}, msg)
+	case <-time.After(3 * time.Second):
+		t.Fatal("$SYS message not received")
+	}
+}
+
+func TestConnectionLimitMetrics(t *testing.T) {
+	spec := getDefaultSpec()
+	spec.MaxAllowedConnection = 1
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+
+	client := getDefaultMQTTClient(t, "limit1", true)
+	defer client.Disconnect(200)
+	dropped := atomic.LoadUint64(&broker.metrics.messagesDropped)
+
+	refused := getUnConnectClient("limit2", "test", "test", true)
+	token := refused.Connect()
+	token.Wait()
+	assert.NotNil(t, token.Error())
+	// paho retries with MQTT 3.1 when MQTT 3.1.1 is refused
+	assert.Greater(t, atomic.LoadUint64(&broker.metrics.messagesDropped), dropped)
+}
@@ -381,3 +381,34 @@ func (m *MockMQTTFilter) Spec() filters.Spec {
 }
 
 func (m *MockMQTTFilter) Close() {}
+
+// mockSubscribeACL denies subscribing topics starting with private/.
+type mockSubscribeACL struct{}
+
+func (m *mockSubscribeACL) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*mqttprot.Request)
+	resp := ctx.GetOutputResponse().(*mqttprot.Response)
+	for i, topic := range req.SubscribePacket().Topics {
+		if strings.HasPrefix(topic, "private/") {
+			resp.DenyTopic(i)
+		}
+	}
+	return ""
+}
+
+func getSubscribeACLBroker() *Broker {
+	spec := getDefaultSpec()
+	spec.Rules = append(spec.Rules, &Rule{
+		When:     &When{PacketType: Subscribe},
+		Pipeline: "subscribe-pipeline",
+	})
+	mapper := &mockMuxMapper{
+		MockFunc: func(name string) (context.Handler, bool) {
+			if name == "subscribe-pipeline" {
+				return &mockSubscribeACL{}, true
+			}
+			return nil, false
+		},
+	}
+	return getBrokerFromSpec(spec, mapper)
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mqtt5Client is a minimal MQTT 5 client for testing.
+type mqtt5Client struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func newMQTT5Client(t *testing.T, clientID string, cleanStart bool, props *mqttprot.Properties) (*mqtt5Client, *mqttprot.Extension) {
+	conn, err := net.Dial("tcp", "localhost:1883")
+	require.Nil(t, err)
+	c := &mqtt5Client{t: t, conn: conn}
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = clientID
+	connect.CleanSession = cleanStart
+	connect.Keepalive = 30
+	c.write(connect, &mqttprot.Extension{Properties: props})
+
+	p, ext := c.read()
+	connack, ok := p.(*packets.ConnackPacket)
+	require.True(t, ok)
+	require.Equal(t, mqttprot.ReasonSuccess, connack.ReturnCode)
+	return c, ext
+}
+
+func (c *mqtt5Client) write(p packets.ControlPacket, ext *mqttprot.Extension) {
+	require.Nil(c.t, mqttprot.WritePacket(c.conn, p, ext, mqttprot.Version5))
+}
+
+func (c *mqtt5Client) read() (packets.ControlPacket, *mqttprot.Extension) {
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, ext, err := mqttprot.ReadPacket(c.conn, mqttprot.Version5)
+	require.Nil(c.t, err)
+	return p, ext
+}
+
+// noPacket returns true if no packet is received in a short time.
+func (c *mqtt5Client) noPacket() bool {
+	c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := mqttprot.ReadPacket(c.conn, mqttprot.Version5)
+	return err != nil
+}
+
+func (c *mqtt5Client) subscribe(topic string, qos byte) {
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{topic}
+	subscribe.Qoss = []byte{qos}
+	c.write(subscribe, nil)
+	p, _ := c.read()
+	suback, ok := p.(*packets.SubackPacket)
+	require.True(c.t, ok)
+	require.Equal(c.t, []byte{qos}, suback.ReturnCodes)
+}
+
+func (c *mqtt5Client) disconnect(props *mqttprot.Properties) {
+	disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	c.write(disconnect, &mqttprot.Extension{Properties: props})
+	c.conn.Close()
+}
+
+func TestMQTT5Connect(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	client, ext := newMQTT5Client(t, "", true, nil)
+	defer client.conn.Close()
+	props := ext.Properties
+	assert.Equal(topicAliasMaximum, *props.TopicAliasMaximum)
+	assert.NotEmpty(props.AssignedClientIdentifier)
+	assert.Eventually(func() bool {
+		return broker.getClient(props.AssignedClientIdentifier) != nil
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// unsubscribe not subscribed topic
+	unsubscribe := packets.NewControlPacket(packets.Unsubscribe).(*packets.UnsubscribePacket)
+	unsubscribe.MessageID = 2
+	unsubscribe.Topics = []string{"not/subscribed"}
+	client.write(unsubscribe, nil)
+	p, ext := client.read()
+	assert.Equal(uint16(2), p.(*packets.UnsubackPacket).MessageID)
+	assert.Equal([]byte{mqttprot.ReasonNoSubscriptionExisted}, ext.ReasonCodes)
+}
+
+func TestMQTT5Publish(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	client, _ := newMQTT5Client(t, "publisher5", true, nil)
+	defer client.conn.Close()
+
+	// topic alias is set by the first publish and used by the second one
+	expiry, alias := uint32(60), uint16(1)
+	props := &mqttprot.Properties{
+		MessageExpiryInterval: &expiry,
+		TopicAlias:            &alias,
+		ResponseTopic:         "device/1/response",
+		CorrelationData:       []byte("request-1"),
+	}
+	props.AddUserProperty("trace", "abc")
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "device/1/request"
+	publish.Qos = QoS1
+	publish.MessageID = 1
+	publish.Payload = []byte("first")
+	client.write(publish, &mqttprot.Extension{Properties: props})
+	p, _ := client.read()
+	assert.Equal(uint16(1), p.(*packets.PubackPacket).MessageID)
+
+	publish.TopicName = ""
+	publish.MessageID = 2
+	publish.Retain = true
+	publish.Payload = []byte("second")
+	client.write(publish, &mqttprot.Extension{Properties: props})
+	p, _ = client.read()
+	assert.Equal(uint16(2), p.(*packets.PubackPacket).MessageID)
+
+	msgs := broker.retainMgr.find("device/1/request")
+	require.Len(t, msgs, 1)
+	msg := msgs[0]
+	assert.Equal(newMsg("", []byte("second"), QoS1).B64Payload, msg.B64Payload)
+	assert.Nil(msg.Properties.TopicAlias)
+	assert.Equal(props.ResponseTopic, msg.Properties.ResponseTopic)
+	assert.Equal(props.CorrelationData, msg.Properties.CorrelationData)
+	value, _ := msg.Properties.GetUserProperty("trace")
+	assert.Equal("abc", value)
+	assert.InDelta(time.Now().Unix()+60, msg.ExpireAt, 2)
+
+	// unknown topic alias closes the connection
+	unknown := uint16(2)
+	publish.Retain = false
+	publish.MessageID = 3
+	client.write(publish, &mqttprot.Extension{Properties: &mqttprot.Properties{TopicAlias: &unknown}})
+	client.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := mqttprot.ReadPacket(client.conn, mqttprot.Version5)
+	assert.NotNil(err)
+}
+
+func TestMQTT5Subscribe(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	receiveMaximum := uint16(1)
+	client, _ := newMQTT5Client(t, "subscriber5", true, &mqttprot.Properties{ReceiveMaximum: &receiveMaximum})
+	defer client.conn.Close()
+	client.subscribe("device/+/response", QoS1)
+
+	props := &mqttprot.Properties{CorrelationData: []byte("request-1")}
+	props.AddUserProperty("trace", "abc")
+	broker.sendMsgToClient(nil, "device/1/response", []byte("first"), QoS1, props, "", nil)
+	broker.sendMsgToClient(nil, "device/1/response", []byte("second"), QoS1, nil, "", nil)
+
+	p, ext := client.read()
+	first := p.(*packets.PublishPacket)
+	assert.Equal("first", string(first.Payload))
+	assert.Equal(props, ext.Properties)
+
+	// the second message waits for the quota of Receive Maximum
+	assert.True(client.noPacket())
+	puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	puback.MessageID = first.MessageID
+	client.write(puback, nil)
+
+	p, ext = client.read()
+	second := p.(*packets.PublishPacket)
+	assert.Equal("second", string(second.Payload))
+	assert.False(second.Dup)
+	assert.Equal(&mqttprot.Properties{}, ext.Properties)
+
+	// expired message is not delivered
+	expiry := uint32(0)
+	broker.sendMsgToClient(nil, "device/1/response", []byte("expired"), QoS0, &mqttprot.Properties{MessageExpiryInterval: &expiry}, "", nil)
+	assert.True(client.noPacket())
+}
+
+func TestMQTT5SessionExpiry(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	expiry := uint32(3600)
+	client, _ := newMQTT5Client(t, "expiry5", true, &mqttprot.Properties{SessionExpiryInterval: &expiry})
+	client.subscribe("a/b", QoS1)
+	client.disconnect(nil)
+
+	// session is kept with expiry time after disconnect
+	info := &SessionInfo{}
+	assert.Eventually(func() bool {
+		if broker.getClient("expiry5") != nil {
+			return false
+		}
+		str, err := broker.sessMgr.store.get(sessionStoreKey("expiry5"))
+		if err != nil || str == nil {
+			return false
+		}
+		return codectool.UnmarshalJSON([]byte(*str), info) == nil && info.ExpireAt > 0
+	}, 3*time.Second, 50*time.Millisecond)
+	assert.Equal(map[string]int{"a/b": 1}, info.Topics)
+	assert.InDelta(time.Now().Unix()+3600, info.ExpireAt, 2)
+
+	// session is resumed before it expires
+	client, _ = newMQTT5Client(t, "expiry5", false, &mqttprot.Properties{SessionExpiryInterval: &expiry})
+	topics, _, _ := broker.getClient("expiry5").session.allSubscribes()
+	assert.Equal([]string{"a/b"}, topics)
+	client.disconnect(nil)
+
+	// expired session is discarded
+	assert.Eventually(func() bool {
+		return broker.getClient("expiry5") == nil && broker.sessMgr.get("expiry5") != nil
+	}, 3*time.Second, 50*time.Millisecond)
+	broker.sessMgr.delLocal("expiry5")
+	info.ExpireAt = time.Now().Unix() - 1
+	broker.sessMgr.store.put(sessionStoreKey("expiry5"), string(codectool.MustMarshalJSON(info)))
+	client, _ = newMQTT5Client(t, "expiry5", false, &mqttprot.Properties{SessionExpiryInterval: &expiry})
+	defer client.conn.Close()
+	topics, _, _ = broker.getClient("expiry5").session.allSubscribes()
+	assert.Empty(topics)
+}
+
+func TestMQTT5SubscribeDenied(t *testing.T) {
+	assert := assert.New(t)
+	broker := getSubscribeACLBroker()
+	defer broker.close()
+
+	client, _ := newMQTT5Client(t, "denied5", true, nil)
+	defer client.conn.Close()
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"public/a", "private/a"}
+	subscribe.Qoss = []byte{QoS1, QoS1}
+	client.write(subscribe, nil)
+	p, ext := client.read()
+	assert.Equal(uint16(1), p.(*packets.SubackPacket).MessageID)
+	assert.Equal([]byte{QoS1, mqttprot.ReasonNotAuthorized}, ext.ReasonCodes)
+
+	broker.sendMsgToClient(nil, "private/a", []byte("private"), QoS0, nil, "", nil)
+	assert.True(client.noPacket())
+	broker.sendMsgToClient(nil, "public/a", []byte("public"), QoS0, nil, "", nil)
+	p, _ = client.read()
+	assert.Equal("public", string(p.(*packets.PublishPacket).Payload))
+}
@@ -20,6 +20,7 @@ package mqttproxy
 import (
 	"bytes"
 	stdcontext "context"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -282,9 +283,136 @@ func TestPublish(t *testing.T) {
 			t.Errorf("get wrong publish")
 		}
 	}
+
+	for i := 0; i < 5; i++ {
+		topic := "go-mqtt/sample"
+		text := fmt.Sprintf("qos2 msg #%d!", i)
+		token := client.Publish(topic, 2, false, text)
+		token.Wait()
+		if token.Error() != nil {
+			t.Errorf("should support qos2")
+		}
+		p := backend.get()
+		if p.TopicName != topic || string(p.Payload) != text {
+			t.Errorf("get wrong publish")
+		}
+	}
 	client.Disconnect(200)
 }
 
+func TestQoS2Delivery(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	ch := make(chan CheckMsg, 10)
+	client := getMQTTClient(t, "qos2", "test", "test", true)
+	defer client.Disconnect(200)
+	if token := client.Subscribe("qos2", 2, getMQTTSubscribeHandler(ch)); token.Wait() && token.Error() != nil {
+		t.Errorf("subscribe qos2 error %s", token.Error())
+	}
+	if token := client.Subscribe("qos1", 1, getMQTTSubscribeHandler(ch)); token.Wait() && token.Error() != nil {
+		t.Errorf("subscribe qos1 error %s", token.Error())
+	}
+
+	// qos2 message is delivered exactly once with qos2
+	broker.sendMsgToClient(nil, "qos2", []byte("exactly once"), QoS2, nil, "", nil)
+	msg := <-ch
+	assert.Equal(CheckMsg{topic: "qos2", payload: "exactly once", qos: 2}, msg)
+
+	// qos2 message is downgraded to the qos of subscription
+	broker.sendMsgToClient(nil, "qos1", []byte("at least once"), QoS2, nil, "", nil)
+	msg = <-ch
+	assert.Equal(CheckMsg{topic: "qos1", payload: "at least once", qos: 1}, msg)
+
+	// all handshakes are completed
+	sess := broker.sessMgr.get("qos2")
+	assert.Eventually(func() bool {
+		sess.Lock()
+		defer sess.Unlock()
+		return len(sess.pending) == 0
+	}, 3*time.Second, 50*time.Millisecond)
+
+	select {
+	case msg := <-ch:
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSessionInflight(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	svcConn, clientConn := net.Pipe()
+	defer svcConn.Close()
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = "inflight"
+	client := newClient(connect, broker, clientConn, nil)
+	client.session = &Session{}
+	client.session.init(broker.sessMgr, broker, connect)
+	defer client.session.close()
+	broker.Lock()
+	broker.clients["inflight"] = client
+	broker.Unlock()
+
+	// outbound qos2 publish waits for pubrec
+	client.session.publish(nil, "topic", []byte("outbound"), QoS2)
+	publish := (<-client.writeCh).(*packets.PublishPacket)
+	assert.Equal(QoS2, publish.Qos)
+	assert.NotEqual(uint16(0), publish.MessageID)
+
+	pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	pubrec.MessageID = publish.MessageID
+	assert.Nil(client.processPacket(pubrec))
+	pubrel := (<-client.writeCh).(*packets.PubrelPacket)
+	assert.Equal(publish.MessageID, pubrel.MessageID)
+
+	// inbound qos2 publish waits for pubrel
+	inbound := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	inbound.Qos = QoS2
+	inbound.MessageID = 100
+	inbound.TopicName = "topic"
+	assert.Nil(client.processPacket(inbound))
+	assert.Equal(uint16(100), (<-client.writeCh).(*packets.PubrecPacket).MessageID)
+	assert.True(client.session.hasReceived(100))
+
+	// in-flight state survives moving session to another member
+	str, err := client.session.encode()
+	assert.Nil(err)
+	sess := broker.sessMgr.newSessionFromJSON(&str)
+	defer sess.close()
+	assert.Equal(publish.MessageID, sess.nextID)
+	assert.Equal([]uint16{publish.MessageID}, sess.pendingQueue)
+	assert.True(sess.pending[publish.MessageID].Released)
+	assert.True(sess.hasReceived(100))
+	assert.Nil(sess.info.Pending)
+
+	sess.resendAll(client)
+	pubrel = (<-client.writeCh).(*packets.PubrelPacket)
+	assert.Equal(publish.MessageID, pubrel.MessageID)
+
+	// duplicated inbound publish is acknowledged but not delivered again
+	inbound.Dup = true
+	client.session = sess
+	assert.Nil(client.processPacket(inbound))
+	assert.Equal(uint16(100), (<-client.writeCh).(*packets.PubrecPacket).MessageID)
+
+	release := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	release.MessageID = 100
+	assert.Nil(client.processPacket(release))
+	assert.Equal(uint16(100), (<-client.writeCh).(*packets.PubcompPacket).MessageID)
+	assert.False(sess.hasReceived(100))
+
+	pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	pubcomp.MessageID = publish.MessageID
+	assert.Nil(client.processPacket(pubcomp))
+	assert.Empty(sess.pending)
+}
+
 func TestSubUnsub(t *testing.T) {
 	mapper := &mockMuxMapper{}
 	broker := getDefaultBroker(mapper)
@@ -617,7 +745,7 @@ func TestSendMsgBack(t *testing.T) {
 			for j := 0; j < msgNum; j++ {
 				topic := r.ClientID()
 				text := fmt.Sprintf("sub %d", j)
-				broker.sendMsgToClient(nil, topic, []byte(text), QoS1)
+				broker.sendMsgToClient(nil, topic, []byte(text), QoS1, nil, "", nil)
 			}
 		}(clients[i])
 	}
@@ -1193,6 +1321,20 @@ func TestTLSConfig(t *testing.T) {
 	if err != nil {
 		t.Errorf("should return nil for correct cert and key pair err:<%v>", err)
 	}
+
+	spec.ClientCA = "fakeCA"
+	_, err = spec.tlsConfig()
+	if err == nil {
+		t.Errorf("no valid client CA, should return error")
+	}
+
+	spec.ClientCA = certPem
+	cfg, err := spec.tlsConfig()
+	if err != nil {
+		t.Errorf("should return nil for correct client CA err:<%v>", err)
+	} else if cfg.ClientCAs == nil || cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("client certificate should be verified if given")
+	}
 }
 
 func TestSessMgr(t *testing.T) {
@@ -1252,12 +1394,6 @@ func TestClient(t *testing.T) {
 		t.Errorf("client should not send connack")
 	}
 
-	pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
-	err = client.processPacket(pubrec)
-	if err == nil {
-		t.Errorf("qos2 not support now")
-	}
-
 	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
 	err = client.processPacket(suback)
 	if err == nil {
@@ -2102,7 +2238,7 @@ func TestBrokerModeWatch(t *testing.T) {
 	publish.TopicName = topicOnEg2
 	publish.Payload = []byte("hello")
 	publish.Qos = 1
-	broker.processBrokerModePublish("clientOnEg1", publish)
+	broker.processBrokerModePublish("clientOnEg1", publish, nil)
 
 	var res *httpRes
 	select {
@@ -2120,3 +2256,53 @@ func TestBrokerModeWatch(t *testing.T) {
 	assert.True(data.Distributed)
 	assert.Equal(1, data.QoS)
 }
+
+func TestSubscribeDenied(t *testing.T) {
+	assert := assert.New(t)
+	broker := getSubscribeACLBroker()
+	defer broker.close()
+
+	client := getDefaultMQTTClient(t, "denied", true)
+	defer client.Disconnect(200)
+
+	token := client.SubscribeMultiple(map[string]byte{"public/a": QoS1, "private/a": QoS1}, nil)
+	assert.True(token.WaitTimeout(3 * time.Second))
+	result := token.(*paho.SubscribeToken).Result()
+	assert.Equal(QoS1, result["public/a"])
+	assert.Equal(subackFailure, result["private/a"])
+
+	assert.Eventually(func() bool {
+		c := broker.getClient("denied")
+		if c == nil {
+			return false
+		}
+		topics, _, _ := c.session.allSubscribes()
+		return len(topics) == 1 && topics[0] == "public/a"
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestPublishMessage(t *testing.T) {
+	assert := assert.New(t)
+	broker := getDefaultBroker(&mockMuxMapper{})
+	defer broker.close()
+
+	client := getDefaultMQTTClient(t, "backend-subscriber", true)
+	defer client.Disconnect(200)
+	ch := make(chan paho.Message, 1)
+	token := client.Subscribe("backend/+", QoS1, func(c paho.Client, m paho.Message) {
+		ch <- m
+	})
+	assert.True(token.WaitTimeout(3 * time.Second))
+
+	assert.NotNil(PublishMessage("not-exist", "backend/a", []byte("payload"), QoS1, false, nil))
+	assert.NotNil(PublishMessage("test", "backend/a", []byte("payload"), 3, false, nil))
+	assert.Nil(PublishMessage("test", "backend/a", []byte("payload"), QoS1, true, nil))
+	select {
+	case m := <-ch:
+		assert.Equal("backend/a", m.Topic())
+		assert.Equal("payload", string(m.Payload()))
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not received")
+	}
+	assert.Len(broker.retainMgr.find("backend/a"), 1)
+}
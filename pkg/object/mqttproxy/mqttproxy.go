@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path/filepath"
 
 	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/context"
@@ -51,6 +52,11 @@ type (
 		spec      *Spec
 		broker    *Broker
 	}
+
+	// Status is the status of MQTTProxy.
+	Status struct {
+		OfflineQueue *OfflineQueueStatus `json:"offlineQueue,omitempty"`
+	}
 )
 
 // Category returns the category of MQTTProxy.
@@ -70,7 +76,11 @@ func (mp *MQTTProxy) DefaultSpec() interface{} {
 
 // Status returns the Status of MQTTProxy.
 func (mp *MQTTProxy) Status() *supervisor.Status {
-	return &supervisor.Status{}
+	s := &Status{}
+	if mp.broker != nil && mp.broker.offlineQueue != nil {
+		s.OfflineQueue = mp.broker.offlineQueue.status()
+	}
+	return &supervisor.Status{ObjectStatus: s}
 }
 
 func updatePort(urlStr string, hostWithPort string) (string, error) {
@@ -140,6 +150,9 @@ func (mp *MQTTProxy) Init(superSpec *supervisor.Spec, muxMapper context.MuxMappe
 	spec := superSpec.ObjectSpec().(*Spec)
 	spec.Name = superSpec.Name()
 	spec.EGName = superSpec.Super().Options().Name
+	if q := spec.OfflineQueue; q != nil && q.Storage == OfflineStorageLocal && q.Path == "" {
+		q.Path = filepath.Join(superSpec.Super().Options().AbsDataDir, "mqttproxy", spec.Name+"-offline.db")
+	}
 	mp.superSpec, mp.spec = superSpec, spec
 
 	store := newStorage(superSpec.Super().Cluster())
@@ -40,20 +40,32 @@ func (mgr *noCacheTopicManager) findSubscribers(topic string) (map[string]byte,
 }
 
 func (mgr *noCacheTopicManager) subscribe(topics []string, qoss []byte, clientID string) error {
-	allLevels, err := mgr.topicCache.getAll(topics)
+	subscribers, err := groupBySubscriber(topics, qoss, clientID)
 	if err != nil {
 		return err
 	}
-	mgr.topicMgr.subscribe(allLevels, qoss, clientID)
+	for id, st := range subscribers {
+		allLevels, err := mgr.topicCache.getAll(st.topics)
+		if err != nil {
+			return err
+		}
+		mgr.topicMgr.subscribe(allLevels, st.qoss, id)
+	}
 	return nil
 }
 
 func (mgr *noCacheTopicManager) unsubscribe(topics []string, clientID string) error {
-	allLevels, err := mgr.topicCache.getAll(topics)
+	subscribers, err := groupBySubscriber(topics, nil, clientID)
 	if err != nil {
 		return err
 	}
-	mgr.topicMgr.unsubscribe(allLevels, clientID)
+	for id, st := range subscribers {
+		allLevels, err := mgr.topicCache.getAll(st.topics)
+		if err != nil {
+			return err
+		}
+		mgr.topicMgr.unsubscribe(allLevels, id)
+	}
 	return nil
 }
 
@@ -0,0 +1,764 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/util/prometheushelper"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// OfflineStorageCluster stores offline messages in the cluster.
+	OfflineStorageCluster = "cluster"
+	// OfflineStorageLocal stores offline messages in local disk.
+	OfflineStorageLocal = "local"
+
+	defaultOfflineQueueMaxSize = 1000
+	defaultOfflineQueueTTL     = 24 * time.Hour
+)
+
+var offlineBucket = []byte("offlineQueue")
+
+type (
+	// OfflineQueueSpec describes the offline message queue of persistent
+	// sessions.
+	OfflineQueueSpec struct {
+		// Storage is cluster or local, default is cluster
+		Storage string `json:"storage,omitempty" jsonschema:"omitempty,enum=,enum=cluster,enum=local"`
+		// Path is the file of local storage, default is in data dir
+		Path    string `json:"path,omitempty" jsonschema:"omitempty"`
+		MaxSize int    `json:"maxSize,omitempty" jsonschema:"omitempty,minimum=1"`
+		TTL     string `json:"ttl,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// offlineStore is the storage backend of offline messages, every
+	// message is stored under its own key ordered by sequence number.
+	offlineStore interface {
+		list(clientID string) ([]*offlineEntry, error)
+		append(clientID string, seq uint64, msg *Message) error
+		remove(clientID string, seqs []uint64) error
+		clear(clientID string) error
+		close() error
+	}
+
+	offlineEntry struct {
+		seq uint64
+		msg *Message
+	}
+
+	clusterOfflineStore struct {
+		store storage
+	}
+
+	localOfflineStore struct {
+		db *bolt.DB
+	}
+
+	// clientQueue indexes the queued messages of a client, it serializes
+	// the store operations of the client without blocking other clients.
+	clientQueue struct {
+		sync.Mutex
+		loaded bool
+		// removed is set when the queue is dropped from offlineQueue.
+		removed bool
+		entries []clientQueueEntry
+	}
+
+	clientQueueEntry struct {
+		seq      uint64
+		expireAt int64
+	}
+
+	// offlineQueue queues QoS 1 and QoS 2 messages for persistent sessions
+	// whose clients are offline, messages are drained in order when the
+	// clients reconnect.
+	offlineQueue struct {
+		sync.Mutex
+		store   offlineStore
+		maxSize int
+		ttl     time.Duration
+		lastSeq uint64
+
+		// sessions is the offline persistent sessions on this broker, their
+		// subscriptions are kept in topic manager to queue messages.
+		sessions map[string]*SessionInfo
+		queues   map[string]*clientQueue
+		// depth is the number of queued messages of offline sessions
+		depth   map[string]int
+		total   int
+		dropped uint64
+		metrics *offlineMetrics
+	}
+
+	offlineMetrics struct {
+		Messages prometheus.Gauge
+		Sessions prometheus.Gauge
+		Dropped  prometheus.Counter
+	}
+
+	// OfflineQueueStatus is the status of offline queue.
+	OfflineQueueStatus struct {
+		Sessions int    `json:"sessions"`
+		Messages int    `json:"messages"`
+		Dropped  uint64 `json:"dropped"`
+	}
+)
+
+func offlineQueueStoreKey(clientID string) string {
+	return fmt.Sprintf(offlineQueuePrefix, url.PathEscape(clientID)) + "/"
+}
+
+func offlineMessageKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func (cs *clusterOfflineStore) list(clientID string) ([]*offlineEntry, error) {
+	prefix := offlineQueueStoreKey(clientID)
+	kvs, err := cs.store.getPrefix(prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*offlineEntry, 0, len(kvs))
+	for k, v := range kvs {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(k, prefix), 10, 64)
+		if err != nil {
+			logger.Warnf("ignored invalid offline message key %s", k)
+			continue
+		}
+		msg := &Message{}
+		if err := codectool.UnmarshalJSON([]byte(v), msg); err != nil {
+			logger.Warnf("ignored decode offline message %s failed: %v", k, err)
+			continue
+		}
+		entries = append(entries, &offlineEntry{seq: seq, msg: msg})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	return entries, nil
+}
+
+func (cs *clusterOfflineStore) append(clientID string, seq uint64, msg *Message) error {
+	b, err := codectool.MarshalJSON(msg)
+	if err != nil {
+		return err
+	}
+	return cs.store.put(offlineQueueStoreKey(clientID)+offlineMessageKey(seq), string(b))
+}
+
+func (cs *clusterOfflineStore) remove(clientID string, seqs []uint64) error {
+	prefix := offlineQueueStoreKey(clientID)
+	for _, seq := range seqs {
+		if err := cs.store.delete(prefix + offlineMessageKey(seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cs *clusterOfflineStore) clear(clientID string) error {
+	return cs.store.deletePrefix(offlineQueueStoreKey(clientID))
+}
+
+func (cs *clusterOfflineStore) close() error {
+	return nil
+}
+
+func newLocalOfflineStore(path string) (*localOfflineStore, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(offlineBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &localOfflineStore{db: db}, nil
+}
+
+// localMessageKey returns the big endian sequence, so that the keys of the
+// client bucket are iterated in order.
+func localMessageKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func (ls *localOfflineStore) list(clientID string) ([]*offlineEntry, error) {
+	var entries []*offlineEntry
+	err := ls.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(offlineBucket).Bucket([]byte(clientID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			msg := &Message{}
+			if err := codectool.UnmarshalJSON(v, msg); err != nil {
+				logger.Warnf("ignored decode offline message of %s failed: %v", clientID, err)
+				return nil
+			}
+			entries = append(entries, &offlineEntry{seq: binary.BigEndian.Uint64(k), msg: msg})
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (ls *localOfflineStore) append(clientID string, seq uint64, msg *Message) error {
+	b, err := codectool.MarshalJSON(msg)
+	if err != nil {
+		return err
+	}
+	return ls.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(offlineBucket).CreateBucketIfNotExists([]byte(clientID))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(localMessageKey(seq), b)
+	})
+}
+
+func (ls *localOfflineStore) remove(clientID string, seqs []uint64) error {
+	return ls.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(offlineBucket).Bucket([]byte(clientID))
+		if bucket == nil {
+			return nil
+		}
+		for _, seq := range seqs {
+			if err := bucket.Delete(localMessageKey(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ls *localOfflineStore) clear(clientID string) error {
+	return ls.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(offlineBucket).DeleteBucket([]byte(clientID))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (ls *localOfflineStore) close() error {
+	return ls.db.Close()
+}
+
+func newOfflineMetrics(spec *Spec) *offlineMetrics {
+	labels := prometheus.Labels{
+		"instanceName":  spec.EGName,
+		"mqttProxyName": spec.Name,
+	}
+	labelNames := []string{"instanceName", "mqttProxyName"}
+	return &offlineMetrics{
+		Messages: prometheushelper.NewGauge(
+			"mqttproxy_offline_queue_messages",
+			"the number of messages queued for offline sessions",
+			labelNames).With(labels),
+		Sessions: prometheushelper.NewGauge(
+			"mqttproxy_offline_queue_sessions",
+			"the number of offline sessions queueing messages",
+			labelNames).With(labels),
+		Dropped: prometheushelper.NewCounter(
+			"mqttproxy_offline_queue_dropped_messages",
+			"the total count of queued messages dropped for overflow or expiry",
+			labelNames).With(labels),
+	}
+}
+
+// newOfflineQueue returns nil if the offline queue is not enabled.
+func newOfflineQueue(spec *Spec, store storage) (*offlineQueue, error) {
+	qs := spec.OfflineQueue
+	if qs == nil {
+		return nil, nil
+	}
+
+	q := &offlineQueue{
+		maxSize:  qs.MaxSize,
+		ttl:      defaultOfflineQueueTTL,
+		sessions: make(map[string]*SessionInfo),
+		queues:   make(map[string]*clientQueue),
+		depth:    make(map[string]int),
+		metrics:  newOfflineMetrics(spec),
+	}
+	if q.maxSize <= 0 {
+		q.maxSize = defaultOfflineQueueMaxSize
+	}
+	if qs.TTL != "" {
+		ttl, err := time.ParseDuration(qs.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl of offline queue %s: %v", qs.TTL, err)
+		}
+		q.ttl = ttl
+	}
+
+	switch qs.Storage {
+	case "", OfflineStorageCluster:
+		q.store = &clusterOfflineStore{store: store}
+	case OfflineStorageLocal:
+		if qs.Path == "" {
+			return nil, fmt.Errorf("path of local offline queue storage is empty")
+		}
+		ls, err := newLocalOfflineStore(qs.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open local offline queue storage %s failed: %v", qs.Path, err)
+		}
+		q.store = ls
+	default:
+		return nil, fmt.Errorf("unknown offline queue storage %s", qs.Storage)
+	}
+	return q, nil
+}
+
+func (q *offlineQueue) close() {
+	if err := q.store.close(); err != nil {
+		logger.Errorf("close offline queue storage failed: %v", err)
+	}
+}
+
+// nextSeq returns an increasing sequence number of queued messages, it's
+// based on the current time to keep the order across restarts.
+func (q *offlineQueue) nextSeq() uint64 {
+	for {
+		last := atomic.LoadUint64(&q.lastSeq)
+		seq := uint64(time.Now().UnixNano())
+		if seq <= last {
+			seq = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&q.lastSeq, last, seq) {
+			return seq
+		}
+	}
+}
+
+// lockClient returns the locked queue of the client.
+func (q *offlineQueue) lockClient(clientID string) *clientQueue {
+	for {
+		q.Lock()
+		cq := q.queues[clientID]
+		if cq == nil {
+			cq = &clientQueue{}
+			q.queues[clientID] = cq
+		}
+		q.Unlock()
+
+		cq.Lock()
+		if !cq.removed {
+			return cq
+		}
+		cq.Unlock()
+	}
+}
+
+// removeClient drops the queue of the client, the caller must hold the lock
+// of the client queue.
+func (q *offlineQueue) removeClient(clientID string, cq *clientQueue) {
+	cq.removed = true
+	q.Lock()
+	defer q.Unlock()
+	if q.queues[clientID] == cq {
+		delete(q.queues, clientID)
+	}
+	q.setDepth(clientID, 0)
+}
+
+// drop records dropped messages, the caller must hold the lock.
+func (q *offlineQueue) drop(n int) {
+	if n == 0 {
+		return
+	}
+	q.dropped += uint64(n)
+	q.metrics.Dropped.Add(float64(n))
+}
+
+// setDepth updates the depth of the session, the caller must hold the lock.
+func (q *offlineQueue) setDepth(clientID string, depth int) {
+	q.total += depth - q.depth[clientID]
+	if depth == 0 {
+		delete(q.depth, clientID)
+	} else {
+		q.depth[clientID] = depth
+	}
+	q.metrics.Messages.Set(float64(q.total))
+}
+
+// loadClient loads the index of the stored messages of the client, the
+// caller must hold the lock of the client queue.
+func (q *offlineQueue) loadClient(clientID string, cq *clientQueue) error {
+	if cq.loaded {
+		return nil
+	}
+	entries, err := q.store.list(clientID)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		cq.entries = append(cq.entries, clientQueueEntry{seq: e.seq, expireAt: e.msg.ExpireAt})
+	}
+	cq.loaded = true
+	return nil
+}
+
+// load loads the stored messages of the client to count them in the
+// depths, it's used for the offline sessions restored on start.
+func (q *offlineQueue) load(clientID string) error {
+	cq := q.lockClient(clientID)
+	defer cq.Unlock()
+	if err := q.loadClient(clientID, cq); err != nil {
+		return err
+	}
+	q.Lock()
+	q.setDepth(clientID, len(cq.entries))
+	q.Unlock()
+	return nil
+}
+
+// enqueue appends the message to the queue of the client, the oldest
+// messages are dropped when the queue is full.
+func (q *offlineQueue) enqueue(clientID string, msg *Message) error {
+	now := time.Now()
+	if msg.expired(now) {
+		q.Lock()
+		q.drop(1)
+		q.Unlock()
+		return nil
+	}
+	expireAt := now.Add(q.ttl).Unix()
+	if msg.ExpireAt == 0 || msg.ExpireAt > expireAt {
+		msg.ExpireAt = expireAt
+	}
+
+	cq := q.lockClient(clientID)
+	defer cq.Unlock()
+	if err := q.loadClient(clientID, cq); err != nil {
+		return err
+	}
+
+	seq := q.nextSeq()
+	if err := q.store.append(clientID, seq, msg); err != nil {
+		return err
+	}
+	cq.entries = append(cq.entries, clientQueueEntry{seq: seq, expireAt: msg.ExpireAt})
+
+	var removed []uint64
+	entries := cq.entries[:0]
+	for _, e := range cq.entries {
+		if e.expireAt > 0 && now.Unix() >= e.expireAt {
+			removed = append(removed, e.seq)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) > q.maxSize {
+		for _, e := range entries[:len(entries)-q.maxSize] {
+			removed = append(removed, e.seq)
+		}
+		entries = append(entries[:0], entries[len(entries)-q.maxSize:]...)
+	}
+	cq.entries = entries
+
+	var err error
+	if len(removed) > 0 {
+		err = q.store.remove(clientID, removed)
+	}
+
+	q.Lock()
+	q.drop(len(removed))
+	q.setDepth(clientID, len(cq.entries))
+	q.Unlock()
+	return err
+}
+
+// drain removes and returns unexpired messages of the client in order.
+func (q *offlineQueue) drain(clientID string) ([]*Message, error) {
+	cq := q.lockClient(clientID)
+	defer cq.Unlock()
+
+	entries, err := q.store.list(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		if err = q.store.clear(clientID); err != nil {
+			return nil, err
+		}
+	}
+	q.removeClient(clientID, cq)
+
+	now := time.Now()
+	msgs, expired := make([]*Message, 0, len(entries)), 0
+	for _, e := range entries {
+		if e.msg.expired(now) {
+			expired++
+			continue
+		}
+		msgs = append(msgs, e.msg)
+	}
+	q.Lock()
+	q.drop(expired)
+	q.Unlock()
+	return msgs, nil
+}
+
+func (q *offlineQueue) clear(clientID string) {
+	cq := q.lockClient(clientID)
+	defer cq.Unlock()
+	if err := q.store.clear(clientID); err != nil {
+		logger.Errorf("clear offline queue of %s failed: %v", clientID, err)
+	}
+	q.removeClient(clientID, cq)
+}
+
+// queueDepths returns the number of queued messages of the offline
+// sessions of the broker, they're counted when messages are queued, so
+// the storage is not scanned.
+func (q *offlineQueue) queueDepths() map[string]int {
+	q.Lock()
+	defer q.Unlock()
+	depths := make(map[string]int, len(q.depth))
+	for k, v := range q.depth {
+		depths[k] = v
+	}
+	return depths
+}
+
+func (q *offlineQueue) track(info *SessionInfo) {
+	q.Lock()
+	defer q.Unlock()
+	q.sessions[info.ClientID] = info
+	q.metrics.Sessions.Set(float64(len(q.sessions)))
+}
+
+func (q *offlineQueue) untrack(clientID string) *SessionInfo {
+	q.Lock()
+	defer q.Unlock()
+	info, ok := q.sessions[clientID]
+	if !ok {
+		return nil
+	}
+	delete(q.sessions, clientID)
+	q.metrics.Sessions.Set(float64(len(q.sessions)))
+	return info
+}
+
+func (q *offlineQueue) session(clientID string) *SessionInfo {
+	q.Lock()
+	defer q.Unlock()
+	return q.sessions[clientID]
+}
+
+func (q *offlineQueue) status() *OfflineQueueStatus {
+	q.Lock()
+	defer q.Unlock()
+	s := &OfflineQueueStatus{
+		Sessions: len(q.sessions),
+		Dropped:  q.dropped,
+	}
+	for _, d := range q.depth {
+		s.Messages += d
+	}
+	return s
+}
+
+// keepOffline keeps the subscriptions of the persistent session after its
+// client disconnects, so that messages are queued for it. It returns false
+// if the subscriptions should be removed.
+func (b *Broker) keepOffline(c *Client) bool {
+	if b.offlineQueue == nil || b.closed() || c.session.cleanSession() {
+		return false
+	}
+	// the client is taken over by a new connection
+	if current := b.getClient(c.info.cid); current != nil && current != c {
+		return false
+	}
+
+	s := c.session
+	s.Lock()
+	info := &SessionInfo{
+		ClientID: s.info.ClientID,
+		Topics:   make(map[string]int, len(s.info.Topics)),
+		ExpireAt: s.info.ExpireAt,
+	}
+	for k, v := range s.info.Topics {
+		info.Topics[k] = v
+	}
+	s.Unlock()
+	b.offlineQueue.track(info)
+	return true
+}
+
+// dropOffline removes the kept subscriptions of the offline session.
+func (b *Broker) dropOffline(clientID string) {
+	if b.offlineQueue == nil {
+		return
+	}
+	info := b.offlineQueue.untrack(clientID)
+	if info == nil {
+		return
+	}
+	topics := make([]string, 0, len(info.Topics))
+	for t := range info.Topics {
+		topics = append(topics, t)
+	}
+	b.topicMgr.unsubscribe(topics, clientID)
+}
+
+// publishOffline queues the message for the offline session of the client.
+func (b *Broker) publishOffline(span *model.SpanContext, clientID, topic string, payload []byte, qos byte, props *mqttprot.Properties) {
+	if b.offlineQueue == nil || qos == QoS0 {
+		return
+	}
+	info := b.offlineQueue.session(clientID)
+	if info == nil {
+		return
+	}
+	now := time.Now()
+	if info.ExpireAt > 0 && now.Unix() >= info.ExpireAt {
+		logger.SpanDebugf(span, "offline session of client %v expired", clientID)
+		b.dropOffline(clientID)
+		b.offlineQueue.clear(clientID)
+		return
+	}
+
+	msg := newMsg(topic, payload, qos)
+	msg.setProperties(props, now)
+	err := b.offlineQueue.enqueue(clientID, msg)
+	if err != nil {
+		logger.SpanErrorf(span, "queue message of %v for offline client %v failed: %v", topic, clientID, err)
+	}
+}
+
+// publishLive publishes the live message to the client, the message is held
+// if the offline queue of the client is being drained.
+func (c *Client) publishLive(span *model.SpanContext, topic string, payload []byte, qos byte, props *mqttprot.Properties) {
+	c.heldMutex.Lock()
+	if c.draining {
+		c.held = append(c.held, func() {
+			c.session.publishWithProperties(span, topic, payload, qos, props)
+		})
+		c.heldMutex.Unlock()
+		return
+	}
+	c.heldMutex.Unlock()
+	c.session.publishWithProperties(span, topic, payload, qos, props)
+}
+
+// releaseHeld publishes the messages held during draining in order, and
+// stops holding live messages.
+func (c *Client) releaseHeld() {
+	for {
+		c.heldMutex.Lock()
+		held := c.held
+		c.held = nil
+		if len(held) == 0 {
+			c.draining = false
+			c.heldMutex.Unlock()
+			return
+		}
+		c.heldMutex.Unlock()
+
+		for _, publish := range held {
+			publish()
+		}
+	}
+}
+
+// drainOffline sends the queued messages to the reconnected client in order,
+// live messages to the client are held until releaseHeld is called.
+func (b *Broker) drainOffline(client *Client) {
+	if b.offlineQueue == nil {
+		return
+	}
+	b.offlineQueue.untrack(client.info.cid)
+	msgs, err := b.offlineQueue.drain(client.info.cid)
+	if err != nil {
+		logger.SpanErrorf(nil, "drain offline queue of client %v failed: %v", client.info.cid, err)
+		return
+	}
+	for _, msg := range msgs {
+		payload, err := base64.StdEncoding.DecodeString(msg.B64Payload)
+		if err != nil {
+			logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
+			continue
+		}
+		client.session.doPublish(nil, msg, payload)
+	}
+}
+
+// restoreOffline restores the kept subscriptions of offline persistent
+// sessions of the broker from the stored sessions, it's used when the
+// broker starts.
+func (b *Broker) restoreOffline(sessions map[string]string) {
+	if b.offlineQueue == nil {
+		return
+	}
+	for k, v := range sessions {
+		clientID := strings.TrimPrefix(k, sessionStoreKey(""))
+		if b.getClient(clientID) != nil || b.offlineQueue.session(clientID) != nil {
+			continue
+		}
+		info := &SessionInfo{}
+		if err := codectool.UnmarshalJSON([]byte(v), info); err != nil {
+			logger.Warnf("ignored decode session info %s failed: %s", v, err)
+			continue
+		}
+		if info.EGName != b.egName || info.Name != b.name || info.CleanFlag {
+			continue
+		}
+		topics, qoss := make([]string, 0, len(info.Topics)), make([]byte, 0, len(info.Topics))
+		for t, qos := range info.Topics {
+			topics = append(topics, t)
+			qoss = append(qoss, byte(qos))
+		}
+		if err := b.topicMgr.subscribe(topics, qoss, clientID); err != nil {
+			logger.Errorf("restore subscriptions of offline client %v failed: %v", clientID, err)
+			continue
+		}
+		b.offlineQueue.track(&SessionInfo{ClientID: clientID, Topics: info.Topics, ExpireAt: info.ExpireAt})
+		if err := b.offlineQueue.load(clientID); err != nil {
+			logger.Errorf("load offline queue of client %v failed: %v", clientID, err)
+		}
+	}
+}
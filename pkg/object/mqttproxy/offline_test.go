@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOfflineQueue(t *testing.T, spec *Spec) {
+	assert := assert.New(t)
+	q, err := newOfflineQueue(spec, newStorage(nil))
+	require.Nil(t, err)
+	defer q.close()
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(q.enqueue("client", newMsg("topic", []byte(strconv.Itoa(i)), QoS1)))
+	}
+	// the oldest messages are dropped when the queue is full
+	assert.Equal(3, q.queueDepths()["client"])
+	assert.Equal(&OfflineQueueStatus{Messages: 3, Dropped: 2}, q.status())
+
+	// expired message is dropped
+	expired := newMsg("topic", []byte("expired"), QoS1)
+	expired.ExpireAt = time.Now().Unix() - 1
+	assert.Nil(q.enqueue("client", expired))
+
+	msgs, err := q.drain("client")
+	assert.Nil(err)
+	var payloads []string
+	for _, msg := range msgs {
+		payloads = append(payloads, msg.B64Payload)
+		assert.InDelta(time.Now().Add(time.Hour).Unix(), msg.ExpireAt, 2)
+	}
+	assert.Equal([]string{"Mg==", "Mw==", "NA=="}, payloads)
+	assert.Equal(0, q.queueDepths()["client"])
+	assert.Equal(&OfflineQueueStatus{Dropped: 3}, q.status())
+
+	msgs, err = q.drain("client")
+	assert.Nil(err)
+	assert.Empty(msgs)
+
+	assert.Nil(q.enqueue("client", newMsg("topic", []byte("clear"), QoS1)))
+	q.clear("client")
+	assert.Equal(0, q.queueDepths()["client"])
+}
+
+func TestOfflineQueue(t *testing.T) {
+	spec := getDefaultSpec()
+	spec.OfflineQueue = &OfflineQueueSpec{MaxSize: 3, TTL: "1h"}
+	t.Run("cluster", func(t *testing.T) {
+		testOfflineQueue(t, spec)
+	})
+
+	spec.OfflineQueue.Storage = OfflineStorageLocal
+	spec.OfflineQueue.Path = filepath.Join(t.TempDir(), "offline.db")
+	t.Run("local", func(t *testing.T) {
+		testOfflineQueue(t, spec)
+	})
+
+	spec.OfflineQueue.Path = ""
+	_, err := newOfflineQueue(spec, newStorage(nil))
+	assert.NotNil(t, err)
+	spec.OfflineQueue = &OfflineQueueSpec{TTL: "invalid"}
+	_, err = newOfflineQueue(spec, newStorage(nil))
+	assert.NotNil(t, err)
+}
+
+func TestOfflineQueueKeys(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.OfflineQueue = &OfflineQueueSpec{MaxSize: 2}
+	store := newStorage(nil)
+	q, err := newOfflineQueue(spec, store)
+	require.Nil(t, err)
+	defer q.close()
+
+	// every message is stored under its own key of the client
+	for i := 0; i < 3; i++ {
+		assert.Nil(q.enqueue("a/b", newMsg("topic", []byte(strconv.Itoa(i)), QoS1)))
+	}
+	assert.Nil(q.enqueue("c", newMsg("topic", []byte("c"), QoS1)))
+	kvs, err := store.getPrefix(offlineQueueStoreKey("a/b"), false)
+	assert.Nil(err)
+	assert.Len(kvs, 2)
+	assert.Equal(map[string]int{"a/b": 2, "c": 1}, q.queueDepths())
+
+	// a new queue loads the stored messages
+	q2, err := newOfflineQueue(spec, store)
+	require.Nil(t, err)
+	assert.Nil(q2.enqueue("a/b", newMsg("topic", []byte("3"), QoS1)))
+	msgs, err := q2.drain("a/b")
+	assert.Nil(err)
+	require.Len(t, msgs, 2)
+	assert.Equal("Mg==", msgs[0].B64Payload)
+	assert.Equal("Mw==", msgs[1].B64Payload)
+	kvs, err = store.getPrefix(offlineQueueStoreKey("a/b"), false)
+	assert.Nil(err)
+	assert.Empty(kvs)
+
+	// the depths are counted without scanning the storage, the stored
+	// messages are counted after they're loaded.
+	assert.Empty(q2.queueDepths())
+	assert.Nil(q2.load("c"))
+	assert.Equal(map[string]int{"c": 1}, q2.queueDepths())
+}
+
+func TestOfflineQueueHeldMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	// live messages are held while draining the offline queue
+	c := &Client{draining: true}
+	c.publishLive(nil, "topic", []byte("live"), QoS1, nil)
+	assert.Len(c.held, 1)
+
+	// held messages are published in order, including the ones held
+	// during releasing.
+	var order []int
+	c.held = []func(){
+		func() {
+			order = append(order, 1)
+			c.heldMutex.Lock()
+			c.held = append(c.held, func() { order = append(order, 3) })
+			c.heldMutex.Unlock()
+		},
+		func() { order = append(order, 2) },
+	}
+	c.releaseHeld()
+	assert.Equal([]int{1, 2, 3}, order)
+	assert.False(c.draining)
+	assert.Empty(c.held)
+}
+
+func getPersistentClient(t *testing.T, clientID string, cleanSession bool, ch chan CheckMsg) paho.Client {
+	opts := paho.NewClientOptions().AddBroker("tcp://0.0.0.0:1883").SetClientID(clientID).
+		SetUsername("test").SetPassword("test").SetCleanSession(cleanSession).
+		SetDefaultPublishHandler(getMQTTSubscribeHandler(ch))
+	c := paho.NewClient(opts)
+	token := c.Connect()
+	token.Wait()
+	require.Nil(t, token.Error())
+	return c
+}
+
+func TestOfflineQueueDelivery(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.OfflineQueue = &OfflineQueueSpec{MaxSize: 10}
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+
+	ch := make(chan CheckMsg, 10)
+	client := getPersistentClient(t, "offline", false, ch)
+	token := client.Subscribe("offline/+", QoS1, nil)
+	token.Wait()
+	assert.Nil(token.Error())
+	client.Disconnect(200)
+	assert.Eventually(func() bool {
+		return broker.getClient("offline") == nil
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// QoS 1 messages are queued, QoS 0 message is not
+	for i := 0; i < 3; i++ {
+		broker.sendMsgToClient(nil, "offline/1", []byte(strconv.Itoa(i)), QoS1, nil, "", nil)
+	}
+	broker.sendMsgToClient(nil, "offline/1", []byte("qos0"), QoS0, nil, "", nil)
+	assert.Equal(3, broker.offlineQueue.queueDepths()["offline"])
+	assert.Equal(&OfflineQueueStatus{Sessions: 1, Messages: 3}, broker.offlineQueue.status())
+
+	allSession, err := broker.sessMgr.store.getPrefix(sessionStoreKey(""), false)
+	assert.Nil(err)
+	sessions := broker.queryAllSessions(allSession, true, 1, 10, "offline")
+	require.Len(t, sessions.Sessions, 1)
+	assert.Equal(3, sessions.Sessions[0].OfflineQueueDepth)
+
+	// queued messages are drained in order on reconnect
+	client = getPersistentClient(t, "offline", false, ch)
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-ch:
+			assert.Equal(CheckMsg{topic: "offline/1", payload: strconv.Itoa(i), qos: 1}, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatal("queued message not received")
+		}
+	}
+	assert.Equal(0, broker.offlineQueue.queueDepths()["offline"])
+	assert.Equal(&OfflineQueueStatus{}, broker.offlineQueue.status())
+	client.Disconnect(200)
+	assert.Eventually(func() bool {
+		return broker.getClient("offline") == nil
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// clean session discards queued messages and subscriptions
+	broker.sendMsgToClient(nil, "offline/1", []byte("discarded"), QoS1, nil, "", nil)
+	assert.Equal(1, broker.offlineQueue.queueDepths()["offline"])
+	client = getPersistentClient(t, "offline", true, ch)
+	defer client.Disconnect(200)
+	assert.Equal(0, broker.offlineQueue.queueDepths()["offline"])
+	subscribers, _ := broker.topicMgr.findSubscribers("offline/1")
+	assert.Empty(subscribers)
+	select {
+	case msg := <-ch:
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestOfflineQueueRestore(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.OfflineQueue = &OfflineQueueSpec{}
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+
+	sessions := map[string]string{
+		sessionStoreKey("persistent"): `{"egName":"test","name":"test","clientID":"persistent","topics":{"a/b":1}}`,
+		sessionStoreKey("clean"):      `{"egName":"test","name":"test","clientID":"clean","topics":{"a/b":1},"cleanFlag":true}`,
+		sessionStoreKey("other"):      `{"egName":"test1","name":"test","clientID":"other","topics":{"a/b":1}}`,
+	}
+	broker.restoreOffline(sessions)
+	subscribers, _ := broker.topicMgr.findSubscribers("a/b")
+	assert.Equal(map[string]byte{"persistent": 1}, subscribers)
+
+	broker.sendMsgToClient(nil, "a/b", []byte("queued"), QoS1, nil, "", nil)
+	assert.Equal(1, broker.offlineQueue.queueDepths()["persistent"])
+
+	// deleted session drops the queue
+	broker.deleteSession("persistent")
+	assert.Equal(0, broker.offlineQueue.queueDepths()["persistent"])
+	subscribers, _ = broker.topicMgr.findSubscribers("a/b")
+	assert.Empty(subscribers)
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+// retainManager manages the retained messages. The messages are put into
+// the store, so they are shared by all members of the cluster, and every
+// member keeps a copy of them in memory by watching the store. The topics
+// of the messages are indexed by a levelTopicManager, so that wildcard
+// subscriptions can find their retained messages quickly.
+type retainManager struct {
+	sync.RWMutex
+	store    storage
+	index    *levelTopicManager
+	messages map[string]*Message
+	done     chan struct{}
+}
+
+func newRetainManager(store storage) *retainManager {
+	mgr := &retainManager{
+		store:    store,
+		index:    newLevelTopicManager(),
+		messages: make(map[string]*Message),
+		done:     make(chan struct{}),
+	}
+	mgr.connectWatcher()
+	return mgr
+}
+
+func (mgr *retainManager) closed() bool {
+	select {
+	case <-mgr.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mgr *retainManager) connectWatcher() {
+	var ch <-chan map[string]*string
+	var cancelFunc func()
+	var err error
+	for {
+		if mgr.closed() {
+			return
+		}
+
+		ch, cancelFunc, err = mgr.store.watch(retainStoreKey(""))
+		if err == nil {
+			break
+		}
+		logger.SpanErrorf(nil, "get watcher for retained messages failed, %v", err)
+		time.Sleep(10 * time.Second)
+	}
+
+	kvs, err := mgr.store.getPrefix(retainStoreKey(""), false)
+	if err != nil {
+		logger.SpanErrorf(nil, "get all retained messages failed, %v", err)
+	} else {
+		mgr.sync(kvs)
+	}
+
+	go mgr.watch(ch, cancelFunc)
+}
+
+func (mgr *retainManager) watch(ch <-chan map[string]*string, closeFunc func()) {
+	defer closeFunc()
+	for {
+		select {
+		case <-mgr.done:
+			return
+		case m := <-ch:
+			if m == nil {
+				go mgr.connectWatcher()
+				return
+			}
+			for k, v := range m {
+				topic := strings.TrimPrefix(k, retainStoreKey(""))
+				if v == nil {
+					mgr.remove(topic)
+					continue
+				}
+				msg := &Message{}
+				if err := codectool.UnmarshalJSON([]byte(*v), msg); err != nil {
+					logger.Warnf("ignored decode retained message %s failed: %s", *v, err)
+					continue
+				}
+				mgr.update(msg)
+			}
+		}
+	}
+}
+
+// sync replaces all retained messages in memory by the ones in store.
+func (mgr *retainManager) sync(kvs map[string]string) {
+	index := newLevelTopicManager()
+	messages := make(map[string]*Message, len(kvs))
+	for k, v := range kvs {
+		msg := &Message{}
+		if err := codectool.UnmarshalJSON([]byte(v), msg); err != nil {
+			logger.Warnf("ignored decode retained message %s of %s failed: %s", v, k, err)
+			continue
+		}
+		levels, ok := splitTopic(msg.Topic)
+		if !ok {
+			continue
+		}
+		index.insert(levels, byte(msg.QoS), msg.Topic)
+		messages[msg.Topic] = msg
+	}
+
+	mgr.Lock()
+	mgr.index, mgr.messages = index, messages
+	mgr.Unlock()
+}
+
+func (mgr *retainManager) update(msg *Message) {
+	levels, ok := splitTopic(msg.Topic)
+	if !ok {
+		return
+	}
+	mgr.Lock()
+	mgr.index.subscribe([][]string{levels}, []byte{byte(msg.QoS)}, msg.Topic)
+	mgr.messages[msg.Topic] = msg
+	mgr.Unlock()
+}
+
+func (mgr *retainManager) remove(topic string) {
+	levels, ok := splitTopic(topic)
+	if !ok {
+		return
+	}
+	mgr.Lock()
+	mgr.index.unsubscribe([][]string{levels}, topic)
+	delete(mgr.messages, topic)
+	mgr.Unlock()
+}
+
+// count returns the number of retained messages.
+func (mgr *retainManager) count() int {
+	mgr.RLock()
+	defer mgr.RUnlock()
+	return len(mgr.messages)
+}
+
+// retain stores the message if the retain flag of the publish packet is set,
+// a message with empty payload clears the retained message of the topic.
+// props is the MQTT 5 properties of the message, it could be nil.
+func (mgr *retainManager) retain(publish *packets.PublishPacket, props *mqttprot.Properties) error {
+	if !publish.Retain {
+		return nil
+	}
+	if strings.ContainsAny(publish.TopicName, "+#") {
+		return fmt.Errorf("invalid topic name %s for retained message", publish.TopicName)
+	}
+	if _, ok := splitTopic(publish.TopicName); !ok {
+		return fmt.Errorf("invalid topic name %s for retained message", publish.TopicName)
+	}
+
+	if len(publish.Payload) == 0 {
+		return mgr.delete(publish.TopicName)
+	}
+
+	msg := newMsg(publish.TopicName, publish.Payload, publish.Qos)
+	msg.Retain = true
+	msg.setProperties(props, time.Now())
+	buf, err := codectool.MarshalJSON(msg)
+	if err != nil {
+		return err
+	}
+	mgr.update(msg)
+	return mgr.store.put(retainStoreKey(publish.TopicName), string(buf))
+}
+
+// delete clears the retained message of the topic.
+func (mgr *retainManager) delete(topic string) error {
+	mgr.remove(topic)
+	return mgr.store.delete(retainStoreKey(topic))
+}
+
+// find returns the retained messages whose topic matches the topic filter,
+// the messages are sorted by topic, expired messages are not returned.
+func (mgr *retainManager) find(filter string) []*Message {
+	levels, ok := splitTopic(filter)
+	if !ok {
+		return nil
+	}
+
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	now := time.Now()
+	topics := mgr.index.findMatched(levels)
+	msgs := make([]*Message, 0, len(topics))
+	for topic := range topics {
+		if msg, ok := mgr.messages[topic]; ok && !msg.expired(now) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Topic < msgs[j].Topic })
+	return msgs
+}
+
+func (mgr *retainManager) close() {
+	close(mgr.done)
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindMatched(t *testing.T) {
+	assert := assert.New(t)
+
+	mgr := newLevelTopicManager()
+	for _, topic := range []string{"a", "a/b", "a/b/c", "a/c/c", "b/b/c", "$SYS/a"} {
+		levels, _ := splitTopic(topic)
+		mgr.subscribe([][]string{levels}, []byte{1}, topic)
+	}
+
+	find := func(filter string) []string {
+		levels, _ := splitTopic(filter)
+		topics := []string{}
+		for topic := range mgr.findMatched(levels) {
+			topics = append(topics, topic)
+		}
+		return topics
+	}
+	assert.ElementsMatch([]string{"a"}, find("a"))
+	assert.ElementsMatch([]string{"a", "a/b", "a/b/c", "a/c/c"}, find("a/#"))
+	assert.ElementsMatch([]string{"a/b/c", "a/c/c"}, find("a/+/c"))
+	assert.ElementsMatch([]string{"a/b/c", "b/b/c"}, find("+/b/+"))
+	assert.ElementsMatch([]string{"a", "a/b", "a/b/c", "a/c/c", "b/b/c"}, find("#"))
+	assert.ElementsMatch([]string{"$SYS/a"}, find("$SYS/#"))
+	assert.ElementsMatch([]string{}, find("+/a"))
+	assert.ElementsMatch([]string{}, find("c/#"))
+}
+
+func TestRetainedMessage(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	publisher := getMQTTClient(t, "publisher", "test", "test", true)
+	defer publisher.Disconnect(200)
+	token := publisher.Publish("device/1/status", 1, true, "online")
+	token.Wait()
+	assert.Nil(token.Error())
+	token = publisher.Publish("device/2/status", 0, true, "offline")
+	token.Wait()
+	assert.Nil(token.Error())
+	token = publisher.Publish("device/3/status", 1, false, "not retained")
+	token.Wait()
+	assert.Nil(token.Error())
+
+	assert.Eventually(func() bool {
+		return len(broker.retainMgr.find("#")) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// new subscriber receives the last known value of topics
+	ch := make(chan CheckMsg, 10)
+	subscriber := getMQTTClient(t, "subscriber", "test", "test", true)
+	defer subscriber.Disconnect(200)
+	token = subscriber.Subscribe("device/+/status", 1, getMQTTSubscribeHandler(ch))
+	token.Wait()
+	assert.Nil(token.Error())
+
+	got := []CheckMsg{<-ch, <-ch}
+	assert.ElementsMatch([]CheckMsg{
+		{topic: "device/1/status", payload: "online", qos: 1},
+		{topic: "device/2/status", payload: "offline", qos: 0},
+	}, got)
+
+	// empty payload clears the retained message
+	token = publisher.Publish("device/1/status", 1, true, "")
+	token.Wait()
+	assert.Nil(token.Error())
+	assert.Eventually(func() bool {
+		return len(broker.retainMgr.find("#")) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+	_, err := broker.sessMgr.store.get(retainStoreKey("device/1/status"))
+	assert.NotNil(err)
+}
+
+func TestRetainedMessageInCluster(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	// retained message stored by another member
+	msg := newMsg("room/1/temperature", []byte("25"), QoS1)
+	msg.Retain = true
+	broker.sessMgr.store.put(retainStoreKey(msg.Topic), string(codectool.MustMarshalJSON(msg)))
+	assert.Eventually(func() bool {
+		msgs := broker.retainMgr.find("room/#")
+		return len(msgs) == 1 && msgs[0].B64Payload == msg.B64Payload
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// list retained messages by http api
+	req := httptest.NewRequest(http.MethodGet, "/retained?q=room/%2B/temperature", nil)
+	w := httptest.NewRecorder()
+	broker.httpGetRetainedHandler(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	res := &HTTPRetainedMessages{}
+	assert.Nil(codectool.Unmarshal(w.Body.Bytes(), res))
+	assert.Equal([]*HTTPRetainedMessage{{Topic: msg.Topic, QoS: 1, Payload: msg.B64Payload}}, res.Messages)
+
+	// clear retained messages by http api
+	body := codectool.MustMarshalJSON(&HTTPRetainedMessages{
+		Messages: []*HTTPRetainedMessage{{Topic: "room/#"}},
+	})
+	req = httptest.NewRequest(http.MethodDelete, "/retained", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	broker.httpDeleteRetainedHandler(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Empty(broker.retainMgr.find("#"))
+	_, err := broker.sessMgr.store.get(retainStoreKey(msg.Topic))
+	assert.NotNil(err)
+
+	// retain message by http publish api
+	data := codectool.MustMarshalJSON(&HTTPJsonData{Topic: "room/2/temperature", QoS: 1, Payload: "26", Retain: true})
+	req = httptest.NewRequest(http.MethodPost, "/publish", bytes.NewReader(data))
+	w = httptest.NewRecorder()
+	broker.httpTopicsPublishHandler(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	msgs := broker.retainMgr.find("room/2/temperature")
+	assert.Len(msgs, 1)
+}
+
+func TestRetainedWill(t *testing.T) {
+	assert := assert.New(t)
+	mapper := &mockMuxMapper{}
+	broker := getDefaultBroker(mapper)
+	defer broker.close()
+
+	svcConn, clientConn := net.Pipe()
+	defer svcConn.Close()
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = "will"
+	connect.WillFlag = true
+	connect.WillRetain = true
+	connect.WillQos = 1
+	connect.WillTopic = "device/will/status"
+	connect.WillMessage = []byte("gone")
+
+	client := newClient(connect, broker, clientConn, nil)
+	client.publishWill()
+
+	msgs := broker.retainMgr.find("device/will/status")
+	assert.Len(msgs, 1)
+	assert.Equal(newMsg("device/will/status", []byte("gone"), QoS1).B64Payload, msgs[0].B64Payload)
+}
@@ -19,12 +19,15 @@ package mqttproxy
 
 import (
 	"encoding/base64"
+	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
 	"github.com/megaease/easegress/pkg/util/codectool"
 
 	"github.com/openzipkin/zipkin-go/model"
@@ -41,6 +44,17 @@ type (
 		Topics    map[string]int `json:"topics"`
 		ClientID  string         `json:"clientID"`
 		CleanFlag bool           `json:"cleanFlag"`
+
+		// session expiry of MQTT 5 client, ExpireAt is the unix time when
+		// the session expires after the client disconnects
+		ExpiryInterval uint32 `json:"expiryInterval,omitempty"`
+		ExpireAt       int64  `json:"expireAt,omitempty"`
+
+		// in-flight state of QoS 1 and QoS 2 messages, keep it in store
+		// so that the delivery survives reconnects on other members
+		NextID   uint16     `json:"nextID,omitempty"`
+		Pending  []*Message `json:"pending,omitempty"`
+		Received []uint16   `json:"received,omitempty"`
 	}
 
 	// Session includes the information about the connect between client and broker,
@@ -54,16 +68,30 @@ type (
 		pending      map[uint16]*Message
 		pendingQueue []uint16
 		nextID       uint16
-		// retry Qos1 packet
+		// ids of QoS 2 messages received from client but not released
+		received map[uint16]struct{}
+		// retry Qos1 and Qos2 packet
 		retryInterval time.Duration
 		refreshStore  atomic.Value
 	}
 
 	// Message is the message send from broker to client
 	Message struct {
+		ID         uint16 `json:"id,omitempty"`
 		Topic      string `json:"topic"`
 		B64Payload string `json:"b64Payload"`
 		QoS        int    `json:"qos"`
+		Retain     bool   `json:"retain,omitempty"`
+		// Released is true when the PUBREC of QoS 2 message is received
+		// and PUBREL is sent to client.
+		Released bool `json:"released,omitempty"`
+		// Queued is true when the message waits for the quota of Receive
+		// Maximum of MQTT 5 client.
+		Queued bool `json:"queued,omitempty"`
+		// Properties is the MQTT 5 properties of the message, ExpireAt is
+		// the unix time when the message expires.
+		Properties *mqttprot.Properties `json:"properties,omitempty"`
+		ExpireAt   int64                `json:"expireAt,omitempty"`
 	}
 )
 
@@ -76,6 +104,42 @@ func newMsg(topic string, payload []byte, qos byte) *Message {
 	return m
 }
 
+// setProperties sets the MQTT 5 properties of the message. The topic alias
+// and subscription identifiers belong to connections, so they are removed,
+// and the message expiry interval is converted to the expiry time.
+func (m *Message) setProperties(props *mqttprot.Properties, now time.Time) {
+	if props == nil {
+		return
+	}
+	m.Properties = props.Clone()
+	m.Properties.TopicAlias = nil
+	m.Properties.SubscriptionIdentifiers = nil
+	if props.MessageExpiryInterval != nil {
+		m.ExpireAt = now.Unix() + int64(*props.MessageExpiryInterval)
+	}
+}
+
+func (m *Message) expired(now time.Time) bool {
+	return m.ExpireAt > 0 && now.Unix() >= m.ExpireAt
+}
+
+// outgoingProperties returns the properties sent to subscribers, in which
+// the message expiry interval is the remaining lifetime of the message.
+func (m *Message) outgoingProperties(now time.Time) *mqttprot.Properties {
+	if m.Properties == nil {
+		return nil
+	}
+	props := m.Properties.Clone()
+	if m.ExpireAt > 0 {
+		remaining := uint32(0)
+		if m.ExpireAt > now.Unix() {
+			remaining = uint32(m.ExpireAt - now.Unix())
+		}
+		props.MessageExpiryInterval = &remaining
+	}
+	return props
+}
+
 func (s *Session) store() {
 	if swapped := s.refreshStore.CompareAndSwap(true, false); !swapped {
 		return
@@ -113,7 +177,25 @@ func (s *Session) store() {
 }
 
 func (s *Session) encode() (string, error) {
-	b, err := codectool.MarshalJSON(s.info)
+	info := *s.info
+	info.NextID = s.nextID
+	info.Pending, info.Received = nil, nil
+	seen := map[uint16]struct{}{}
+	for _, id := range s.pendingQueue {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		if msg, ok := s.pending[id]; ok {
+			seen[id] = struct{}{}
+			info.Pending = append(info.Pending, msg)
+		}
+	}
+	for id := range s.received {
+		info.Received = append(info.Received, id)
+	}
+	sort.Slice(info.Received, func(i, j int) bool { return info.Received[i] < info.Received[j] })
+
+	b, err := codectool.MarshalJSON(&info)
 	if err != nil {
 		return "", err
 	}
@@ -127,12 +209,29 @@ func (s *Session) decode(str string) error {
 	return codectool.UnmarshalJSON([]byte(str), s.info)
 }
 
+// restoreInflight restores the in-flight messages from the decoded session info.
+func (s *Session) restoreInflight() {
+	s.nextID = s.info.NextID
+	s.pending = make(map[uint16]*Message)
+	s.pendingQueue = []uint16{}
+	for _, msg := range s.info.Pending {
+		s.pending[msg.ID] = msg
+		s.pendingQueue = append(s.pendingQueue, msg.ID)
+	}
+	s.received = make(map[uint16]struct{})
+	for _, id := range s.info.Received {
+		s.received[id] = struct{}{}
+	}
+	s.info.NextID, s.info.Pending, s.info.Received = 0, nil, nil
+}
+
 func (s *Session) init(sm *SessionManager, b *Broker, connect *packets.ConnectPacket) error {
 	s.broker = b
 	s.storeCh = sm.storeCh
 	s.done = make(chan struct{})
 	s.pending = make(map[uint16]*Message)
 	s.pendingQueue = []uint16{}
+	s.received = make(map[uint16]struct{})
 	s.retryInterval = time.Second * time.Duration(b.spec.RetryInterval)
 
 	s.info = &SessionInfo{}
@@ -144,6 +243,35 @@ func (s *Session) init(sm *SessionManager, b *Broker, connect *packets.ConnectPa
 	return nil
 }
 
+// setExpiry sets the session expiry interval of MQTT 5 client, the session
+// is cleaned after the client disconnects when the interval is zero.
+func (s *Session) setExpiry(interval uint32) {
+	s.Lock()
+	s.info.ExpiryInterval = interval
+	s.info.CleanFlag = interval == 0
+	s.info.ExpireAt = 0
+	s.refreshStore.Store(true)
+	s.Unlock()
+}
+
+// disconnect records the expiry time of the session when the client
+// disconnects and stores the session.
+func (s *Session) disconnect(now time.Time) {
+	s.Lock()
+	if s.info.ExpiryInterval > 0 && s.info.ExpiryInterval != math.MaxUint32 {
+		s.info.ExpireAt = now.Unix() + int64(s.info.ExpiryInterval)
+	}
+	s.refreshStore.Store(true)
+	s.Unlock()
+	s.store()
+}
+
+func (s *Session) expired(now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.info.ExpireAt > 0 && now.Unix() >= s.info.ExpireAt
+}
+
 func (s *Session) updateEGName(egName, name string) {
 	s.Lock()
 	s.info.EGName = egName
@@ -195,43 +323,189 @@ func (s *Session) getPacketFromMsg(topic string, payload []byte, qos byte) *pack
 	p.Qos = qos
 	p.TopicName = topic
 	p.Payload = payload
-	p.MessageID = s.nextID
-	// the overflow is okay here
-	// the session will give unique id from 0 to 65535 and do this again and again
-	s.nextID++
+	if qos != QoS0 {
+		p.MessageID = s.getNextID()
+	}
 	return p
 }
 
+// getNextID returns a non-zero id which is not used by in-flight messages.
+func (s *Session) getNextID() uint16 {
+	for i := 0; i < math.MaxUint16; i++ {
+		// the overflow is okay here
+		// the session will give unique id from 1 to 65535 and do this again and again
+		s.nextID++
+		if s.nextID == 0 {
+			continue
+		}
+		if _, ok := s.pending[s.nextID]; !ok {
+			break
+		}
+	}
+	return s.nextID
+}
+
 func (s *Session) publish(span *model.SpanContext, topic string, payload []byte, qos byte) {
+	s.publishWithProperties(span, topic, payload, qos, nil)
+}
+
+// publishWithProperties publishes the message with MQTT 5 properties, the
+// properties are not sent to MQTT 3.1.1 client.
+func (s *Session) publishWithProperties(span *model.SpanContext, topic string, payload []byte, qos byte, props *mqttprot.Properties) {
+	msg := newMsg(topic, payload, qos)
+	msg.setProperties(props, time.Now())
+	s.doPublish(span, msg, payload)
+}
+
+// publishRetained publishes the retained message to client with the retain flag set,
+// it's used when client subscribes a topic.
+func (s *Session) publishRetained(span *model.SpanContext, msg *Message, qos byte) {
+	payload, err := base64.StdEncoding.DecodeString(msg.B64Payload)
+	if err != nil {
+		logger.SpanErrorf(span, "base64 decode error for Message B64Payload %s", err)
+		return
+	}
+	m := *msg
+	m.QoS = int(qos)
+	m.Retain = true
+	s.doPublish(span, &m, payload)
+}
+
+func (s *Session) doPublish(span *model.SpanContext, msg *Message, payload []byte) {
 	client := s.broker.getClient(s.info.ClientID)
 	if client == nil {
 		logger.SpanErrorf(span, "client %s is offline in eg %v", s.info.ClientID, s.broker.egName)
 		return
 	}
 
+	now := time.Now()
+	if msg.expired(now) {
+		logger.SpanDebugf(span, "session %v drop expired message of %v", s.info.ClientID, msg.Topic)
+		return
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
-	logger.SpanDebugf(span, "session %v publish %v", s.info.ClientID, topic)
-	p := s.getPacketFromMsg(topic, payload, qos)
+	logger.SpanDebugf(span, "session %v publish %v", s.info.ClientID, msg.Topic)
+	qos := byte(msg.QoS)
+	p := s.getPacketFromMsg(msg.Topic, payload, qos)
+	p.Retain = msg.Retain
+	packet := client.packetWithProperties(p, msg.outgoingProperties(now))
+	if !client.fitPacketSize(packet) {
+		logger.SpanErrorf(span, "session %v drop message of %v exceeding maximum packet size", s.info.ClientID, msg.Topic)
+		return
+	}
 	if qos == QoS0 {
 		select {
-		case client.writeCh <- p:
+		case client.writeCh <- packet:
 		default:
 		}
-	} else if qos == QoS1 {
-		msg := newMsg(topic, payload, qos)
-		s.pending[p.MessageID] = msg
-		s.pendingQueue = append(s.pendingQueue, p.MessageID)
-		client.writePacket(p)
-	} else {
-		logger.SpanErrorf(span, "publish message with qos=2 is not supported currently")
+		return
+	}
+
+	msg.ID = p.MessageID
+	msg.Queued = client.receiveMaximum > 0 && s.inflight() >= int(client.receiveMaximum)
+	s.pending[p.MessageID] = msg
+	s.pendingQueue = append(s.pendingQueue, p.MessageID)
+	s.refreshStore.Store(true)
+	if !msg.Queued {
+		client.writePacket(packet)
+	}
+}
+
+// inflight returns the number of QoS 1 and QoS 2 messages sent to client
+// but not acknowledged.
+func (s *Session) inflight() int {
+	n := 0
+	for _, msg := range s.pending {
+		if !msg.Queued {
+			n++
+		}
+	}
+	return n
+}
+
+// sendQueued sends the queued messages to client as long as the quota of
+// Receive Maximum allows.
+func (s *Session) sendQueued(client *Client) {
+	if client == nil {
+		return
+	}
+	inflight := s.inflight()
+	for _, idx := range s.pendingQueue {
+		if client.receiveMaximum > 0 && inflight >= int(client.receiveMaximum) {
+			return
+		}
+		msg, ok := s.pending[idx]
+		if !ok || !msg.Queued {
+			continue
+		}
+		msg.Queued = false
+		s.refreshStore.Store(true)
+		if p := s.msgPacket(client, msg, false); p != nil {
+			client.writePacket(p)
+			inflight++
+		}
 	}
 }
 
 func (s *Session) puback(p *packets.PubackPacket) {
 	s.Lock()
-	delete(s.pending, p.MessageID)
+	if msg, ok := s.pending[p.MessageID]; ok && msg.QoS == int(QoS1) {
+		delete(s.pending, p.MessageID)
+		s.refreshStore.Store(true)
+		s.sendQueued(s.broker.getClient(s.info.ClientID))
+	}
+	s.Unlock()
+}
+
+// pubrec marks the QoS 2 message as released, the caller should send
+// PUBREL to client.
+func (s *Session) pubrec(p *packets.PubrecPacket) {
+	s.Lock()
+	if msg, ok := s.pending[p.MessageID]; ok && msg.QoS == int(QoS2) && !msg.Released {
+		msg.Released = true
+		s.refreshStore.Store(true)
+	}
+	s.Unlock()
+}
+
+// pubcomp completes the delivery of the QoS 2 message.
+func (s *Session) pubcomp(p *packets.PubcompPacket) {
+	s.Lock()
+	if msg, ok := s.pending[p.MessageID]; ok && msg.QoS == int(QoS2) {
+		delete(s.pending, p.MessageID)
+		s.refreshStore.Store(true)
+		s.sendQueued(s.broker.getClient(s.info.ClientID))
+	}
+	s.Unlock()
+}
+
+// hasReceived returns true if the QoS 2 message is received from client
+// and not released yet.
+func (s *Session) hasReceived(id uint16) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.received[id]
+	return ok
+}
+
+// receive records the id of QoS 2 message received from client.
+func (s *Session) receive(id uint16) {
+	s.Lock()
+	s.received[id] = struct{}{}
+	s.refreshStore.Store(true)
+	s.Unlock()
+}
+
+// release releases the id of QoS 2 message received from client.
+func (s *Session) release(id uint16) {
+	s.Lock()
+	if _, ok := s.received[id]; ok {
+		delete(s.received, id)
+		s.refreshStore.Store(true)
+	}
 	s.Unlock()
 }
 
@@ -243,11 +517,49 @@ func (s *Session) close() {
 	close(s.done)
 }
 
+// msgPacket returns the packet to send for in-flight message, which is
+// PUBREL for released QoS 2 message and PUBLISH for others. It returns nil
+// if the message can't be sent.
+func (s *Session) msgPacket(client *Client, msg *Message, dup bool) packets.ControlPacket {
+	if msg.Released {
+		p := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+		p.MessageID = msg.ID
+		return p
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(msg.B64Payload)
+	if err != nil {
+		logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
+		return nil
+	}
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.Qos = byte(msg.QoS)
+	p.TopicName = msg.Topic
+	p.Payload = payload
+	p.MessageID = msg.ID
+	p.Retain = msg.Retain
+	p.Dup = dup
+	return client.packetWithProperties(p, msg.outgoingProperties(time.Now()))
+}
+
+// dropExpired removes the expired messages which are not sent or not
+// received by client yet, released QoS 2 messages are kept since the
+// client has received them.
+func (s *Session) dropExpired(now time.Time) {
+	for idx, msg := range s.pending {
+		if !msg.Released && msg.expired(now) {
+			delete(s.pending, idx)
+			s.refreshStore.Store(true)
+		}
+	}
+}
+
 func (s *Session) doResend() {
 	client := s.broker.getClient(s.info.ClientID)
 	s.Lock()
 	defer s.Unlock()
 
+	s.dropExpired(time.Now())
 	if len(s.pending) == 0 {
 		s.pendingQueue = []uint16{}
 		return
@@ -256,26 +568,48 @@ func (s *Session) doResend() {
 		if val, ok := s.pending[idx]; ok {
 			// find first msg need to resend
 			s.pendingQueue = s.pendingQueue[i:]
-			p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
-			p.Qos = byte(val.QoS)
-			p.TopicName = val.Topic
-			payload, err := base64.StdEncoding.DecodeString(val.B64Payload)
-			if err != nil {
-				logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
+			if val.Queued {
 				return
 			}
-			p.Payload = payload
-			p.MessageID = idx
-			if client != nil {
-				client.writePacket(p)
-			} else {
+			if client == nil {
 				logger.SpanDebugf(nil, "session %v do resend but client is nil", s.info.ClientID)
+				return
+			}
+			if p := s.msgPacket(client, val, true); p != nil {
+				client.writePacket(p)
 			}
 			return
 		}
 	}
 }
 
+// resendAll resends all in-flight messages in order, it's used when
+// client reconnects with previous session.
+func (s *Session) resendAll(client *Client) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.dropExpired(time.Now())
+	queue := []uint16{}
+	seen := map[uint16]struct{}{}
+	for _, idx := range s.pendingQueue {
+		val, ok := s.pending[idx]
+		if _, dup := seen[idx]; !ok || dup {
+			continue
+		}
+		seen[idx] = struct{}{}
+		queue = append(queue, idx)
+		if val.Queued {
+			continue
+		}
+		if p := s.msgPacket(client, val, true); p != nil {
+			client.writePacket(p)
+		}
+	}
+	s.pendingQueue = queue
+	s.sendQueued(client)
+}
+
 // backgroundSessionTask process two tasks peroidly:
 // - task 1: sync the session information to global etcd store
 // - task 2: resend the packet to the subscriber with QoS 1 or 2
@@ -19,6 +19,7 @@ package mqttproxy
 
 import (
 	"sync"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/logger"
@@ -84,14 +85,14 @@ func (sm *SessionManager) newSessionFromJSON(str *string) *Session {
 	sess.broker = sm.broker
 	sess.storeCh = sm.storeCh
 	sess.done = make(chan struct{})
-	sess.pending = make(map[uint16]*Message)
-	sess.pendingQueue = []uint16{}
+	sess.retryInterval = time.Second * time.Duration(sm.broker.spec.RetryInterval)
 
 	sess.info = &SessionInfo{}
 	err := sess.decode(*str)
 	if err != nil {
 		return nil
 	}
+	sess.restoreInflight()
 	go sess.backgroundSessionTask()
 	return sess
 }
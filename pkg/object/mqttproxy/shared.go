@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	sharePrefix = "$share/"
+
+	// sharedMemberSep separates the shared subscription and the client id
+	// in the subscriber id of a group member. Topics never contain U+0000.
+	sharedMemberSep = "\x00"
+
+	// SharedRoundRobin delivers messages to members of a group in turn.
+	SharedRoundRobin = "roundRobin"
+	// SharedHash delivers messages from the same publisher to the same
+	// member of a group, messages without publisher are delivered in turn.
+	SharedHash = "hash"
+)
+
+type (
+	// sharedMember is a member of shared subscription group that matches
+	// a topic.
+	sharedMember struct {
+		id       string
+		clientID string
+		qos      byte
+	}
+
+	// sharedSelector chooses one member of every shared subscription group.
+	sharedSelector struct {
+		strategy string
+		// counters maps shared subscription to *uint64 for round-robin
+		counters sync.Map
+	}
+
+	// subscriberTopics is the topics subscribed by a subscriber id.
+	subscriberTopics struct {
+		topics []string
+		qoss   []byte
+	}
+)
+
+// parseSharedTopic parses shared subscription "$share/{group}/{filter}",
+// ok is false if the topic is not a shared subscription.
+func parseSharedTopic(topic string) (group, filter string, ok bool, err error) {
+	if !strings.HasPrefix(topic, sharePrefix) {
+		return "", "", false, nil
+	}
+	group, filter, found := strings.Cut(strings.TrimPrefix(topic, sharePrefix), "/")
+	if !found || group == "" || filter == "" || strings.ContainsAny(group, "+#") {
+		return "", "", true, fmt.Errorf("invalid shared subscription %s", topic)
+	}
+	return group, filter, true, nil
+}
+
+// sharedMemberID returns the subscriber id of the client in topic manager
+// for the shared subscription.
+func sharedMemberID(topic, clientID string) string {
+	return topic + sharedMemberSep + clientID
+}
+
+// parseSharedMemberID returns the shared subscription and client id of the
+// subscriber id, ok is false if it's not a member of shared subscription.
+func parseSharedMemberID(id string) (topic, clientID string, ok bool) {
+	return strings.Cut(id, sharedMemberSep)
+}
+
+// groupBySubscriber groups topics by subscriber id, a shared subscription
+// subscribes its topic filter with the member id of the client. qoss is nil
+// for unsubscribing.
+func groupBySubscriber(topics []string, qoss []byte, clientID string) (map[string]*subscriberTopics, error) {
+	res := make(map[string]*subscriberTopics)
+	add := func(id, topic string, i int) {
+		st, ok := res[id]
+		if !ok {
+			st = &subscriberTopics{}
+			res[id] = st
+		}
+		st.topics = append(st.topics, topic)
+		if qoss != nil {
+			st.qoss = append(st.qoss, qoss[i])
+		}
+	}
+
+	for i, topic := range topics {
+		_, filter, ok, err := parseSharedTopic(topic)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			add(sharedMemberID(topic, clientID), filter, i)
+		} else {
+			add(clientID, topic, i)
+		}
+	}
+	return res, nil
+}
+
+// splitShared splits subscribers into normal subscribers and members of
+// shared subscription groups.
+func splitShared(subscribers map[string]byte) (map[string]byte, map[string][]*sharedMember) {
+	clients := make(map[string]byte)
+	groups := make(map[string][]*sharedMember)
+	for id, qos := range subscribers {
+		topic, clientID, ok := parseSharedMemberID(id)
+		if !ok {
+			clients[id] = qos
+			continue
+		}
+		groups[topic] = append(groups[topic], &sharedMember{id: id, clientID: clientID, qos: qos})
+	}
+	return clients, groups
+}
+
+func newSharedSelector(strategy string) *sharedSelector {
+	if strategy == "" {
+		strategy = SharedRoundRobin
+	}
+	return &sharedSelector{strategy: strategy}
+}
+
+// choose chooses a member of the group, key is the client id of the
+// publisher used by hash strategy.
+func (s *sharedSelector) choose(group string, members []*sharedMember, key string) *sharedMember {
+	sort.Slice(members, func(i, j int) bool { return members[i].clientID < members[j].clientID })
+
+	var index uint64
+	switch {
+	case s.strategy == SharedHash && key != "":
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		index = h.Sum64()
+	default:
+		counter, _ := s.counters.LoadOrStore(group, new(uint64))
+		index = atomic.AddUint64(counter.(*uint64), 1) - 1
+	}
+	return members[index%uint64(len(members))]
+}
+
+// sharedGroups returns the shared subscriptions of the session, sorted.
+func (info *SessionInfo) sharedGroups() []string {
+	var groups []string
+	for topic := range info.Topics {
+		if strings.HasPrefix(topic, sharePrefix) {
+			groups = append(groups, topic)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
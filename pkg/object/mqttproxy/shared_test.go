@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSharedTopic(t *testing.T) {
+	assert := assert.New(t)
+
+	group, filter, ok, err := parseSharedTopic("$share/g1/a/+/c")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal("g1", group)
+	assert.Equal("a/+/c", filter)
+
+	_, _, ok, err = parseSharedTopic("a/b")
+	assert.Nil(err)
+	assert.False(ok)
+
+	for _, topic := range []string{"$share/g1", "$share//a", "$share/g1/", "$share/g+/a"} {
+		_, _, ok, err = parseSharedTopic(topic)
+		assert.True(ok)
+		assert.NotNil(err, topic)
+	}
+
+	res, err := groupBySubscriber([]string{"a/b", "$share/g1/a/b", "c"}, []byte{0, 1, 2}, "client")
+	assert.Nil(err)
+	memberID := sharedMemberID("$share/g1/a/b", "client")
+	assert.Equal(map[string]*subscriberTopics{
+		"client": {topics: []string{"a/b", "c"}, qoss: []byte{0, 2}},
+		memberID: {topics: []string{"a/b"}, qoss: []byte{1}},
+	}, res)
+
+	topic, clientID, ok := parseSharedMemberID(memberID)
+	assert.True(ok)
+	assert.Equal("$share/g1/a/b", topic)
+	assert.Equal("client", clientID)
+	assert.Equal("client", subscriberClientID(memberID))
+	assert.Equal("client", subscriberClientID("client"))
+
+	_, err = groupBySubscriber([]string{"$share/g1"}, []byte{0}, "client")
+	assert.NotNil(err)
+
+	info := &SessionInfo{Topics: map[string]int{"a": 0, "$share/g2/a": 0, "$share/g1/a": 0}}
+	assert.Equal([]string{"$share/g1/a", "$share/g2/a"}, info.sharedGroups())
+}
+
+func TestSharedSelector(t *testing.T) {
+	assert := assert.New(t)
+
+	members := func() []*sharedMember {
+		return []*sharedMember{
+			{id: "c", clientID: "c"},
+			{id: "a", clientID: "a"},
+			{id: "b", clientID: "b"},
+		}
+	}
+
+	s := newSharedSelector("")
+	assert.Equal(SharedRoundRobin, s.strategy)
+	var chosen []string
+	for i := 0; i < 6; i++ {
+		chosen = append(chosen, s.choose("g1", members(), "").clientID)
+	}
+	assert.Equal([]string{"a", "b", "c", "a", "b", "c"}, chosen)
+	// counters are kept per group
+	assert.Equal("a", s.choose("g2", members(), "").clientID)
+
+	s = newSharedSelector(SharedHash)
+	first := s.choose("g1", members(), "publisher1").clientID
+	for i := 0; i < 10; i++ {
+		assert.Equal(first, s.choose("g1", members(), "publisher1").clientID)
+	}
+	// messages without publisher are delivered in turn
+	chosen = nil
+	for i := 0; i < 3; i++ {
+		chosen = append(chosen, s.choose("g1", members(), "").clientID)
+	}
+	assert.Equal([]string{"a", "b", "c"}, chosen)
+}
+
+func TestSharedSubscription(t *testing.T) {
+	assert := assert.New(t)
+	broker := getDefaultBroker(&mockMuxMapper{})
+	defer broker.close()
+
+	ch := make(chan CheckMsg, 100)
+	var members []string
+	for i := 0; i < 2; i++ {
+		clientID := "shared" + strconv.Itoa(i)
+		client := getDefaultMQTTClient(t, clientID, true)
+		defer client.Disconnect(200)
+		token := client.Subscribe("$share/g1/shared/+", 1, getMQTTSubscribeHandler(ch))
+		token.Wait()
+		assert.Nil(token.Error())
+		members = append(members, clientID)
+	}
+	normalCh := make(chan CheckMsg, 100)
+	normal := getDefaultMQTTClient(t, "normal", true)
+	defer normal.Disconnect(200)
+	token := normal.Subscribe("shared/+", 1, getMQTTSubscribeHandler(normalCh))
+	token.Wait()
+	assert.Nil(token.Error())
+
+	// wait for the clients to be registered after CONNACK, so that the
+	// broker isn't closed during the handshake.
+	assert.Eventually(func() bool {
+		for _, id := range append(members, "normal") {
+			if broker.getClient(id) == nil {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+
+	subscribers, err := broker.topicMgr.findSubscribers("shared/1")
+	assert.Nil(err)
+	assert.Len(subscribers, 3)
+
+	// every message is delivered to only one member of the group
+	for i := 0; i < 10; i++ {
+		broker.sendMsgToClient(nil, "shared/1", []byte(strconv.Itoa(i)), QoS1, nil, "", nil)
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case msg := <-ch:
+			assert.Equal("shared/1", msg.topic)
+		case <-time.After(3 * time.Second):
+			t.Fatal("shared subscription message not received")
+		}
+		msg := <-normalCh
+		assert.Equal("shared/1", msg.topic)
+	}
+	select {
+	case msg := <-ch:
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// shared group is visible in session query
+	var sessions *HTTPSessions
+	assert.Eventually(func() bool {
+		allSession, err := broker.sessMgr.store.getPrefix(sessionStoreKey(""), false)
+		if err != nil {
+			return false
+		}
+		sessions = broker.queryAllSessions(allSession, true, 1, 10, "$share/g1")
+		return len(sessions.Sessions) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+	for _, s := range sessions.Sessions {
+		assert.Contains(members, s.SessionID)
+		assert.Equal([]string{"$share/g1/shared/+"}, s.SharedGroups)
+	}
+
+	// unsubscribed member leaves the group
+	c := broker.getClient(members[0])
+	processUnsubscribe(c, &packets.UnsubscribePacket{Topics: []string{"$share/g1/shared/+"}}, nil)
+	subscribers, err = broker.topicMgr.findSubscribers("shared/1")
+	assert.Nil(err)
+	assert.Equal(map[string]byte{
+		"normal": 1,
+		sharedMemberID("$share/g1/shared/+", members[1]): 1,
+	}, subscribers)
+}
+
+func TestSharedSubscriptionBrokerMode(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.BrokerMode = true
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+
+	// members on local and remote egs
+	broker.topicMgr.subscribe([]string{"$share/g1/a"}, []byte{1}, "local")
+	broker.sessionCacheMgr.update(map[string]*SessionInfo{
+		"local":  {EGName: "test", ClientID: "local", Topics: map[string]int{"$share/g1/a": 1}},
+		"remote": {EGName: "test1", ClientID: "remote", Topics: map[string]int{"$share/g1/a": 1}},
+	})
+	assert.Eventually(func() bool {
+		subscribers, _ := broker.topicMgr.findSubscribers("a")
+		return len(subscribers) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "a"
+	localCount, remoteCount := 0, 0
+	for i := 0; i < 10; i++ {
+		local, remote := broker.splitSubscribers(publish, "publisher")
+		assert.Equal(1, len(local)+len(remote))
+		if len(local) > 0 {
+			assert.Equal(map[string]byte{sharedMemberID("$share/g1/a", "local"): 1}, local)
+			localCount++
+		} else {
+			assert.Equal(map[string][]string{"test1": {sharedMemberID("$share/g1/a", "remote")}}, remote)
+			remoteCount++
+		}
+	}
+	assert.Equal(5, localCount)
+	assert.Equal(5, remoteCount)
+}
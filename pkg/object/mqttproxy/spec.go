@@ -19,13 +19,17 @@ package mqttproxy
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 )
 
 const (
 	sessionPrefix              = "/mqtt/sessionMgr/clientID/%s"
 	topicPrefix                = "/mqtt/topicMgr/topic/%s"
+	retainPrefix               = "/mqtt/retainMgr/topic/%s"
+	offlineQueuePrefix         = "/mqtt/offlineQueue/clientID/%s"
 	mqttAPITopicPublishPrefix  = "/mqttproxy/%s/topics/publish"
+	mqttAPIRetainedPrefix      = "/mqttproxy/%s/retained"
 	mqttAPISessionQueryPrefix  = "/mqttproxy/%s/session/query"
 	mqttAPISessionDeletePrefix = "/mqttproxy/%s/sessions"
 )
@@ -61,17 +65,33 @@ var pipelinePacketTypes = map[PacketType]struct{}{
 type (
 	// Spec describes the MQTTProxy.
 	Spec struct {
-		EGName               string        `json:"-"`
-		Name                 string        `json:"-"`
-		Port                 uint16        `json:"port" jsonschema:"required"`
-		UseTLS               bool          `json:"useTLS" jsonschema:"omitempty"`
-		Certificate          []Certificate `json:"certificate" jsonschema:"omitempty"`
-		TopicCacheSize       int           `json:"topicCacheSize" jsonschema:"omitempty"`
-		MaxAllowedConnection int           `json:"maxAllowedConnection" jsonschema:"omitempty"`
-		ConnectionLimit      *RateLimit    `json:"connectionLimit" jsonschema:"omitempty"`
-		ClientPublishLimit   *RateLimit    `json:"clientPublishLimit" jsonschema:"omitempty"`
-		Rules                []*Rule       `json:"rules" jsonschema:"omitempty"`
-		BrokerMode           bool          `json:"brokerMode" jsonschema:"omitempty"`
+		EGName      string        `json:"-"`
+		Name        string        `json:"-"`
+		Port        uint16        `json:"port" jsonschema:"required"`
+		UseTLS      bool          `json:"useTLS" jsonschema:"omitempty"`
+		Certificate []Certificate `json:"certificate" jsonschema:"omitempty"`
+		// ClientCA is the PEM encoded CA certificates to verify the client
+		// certificates, client certificates are not requested if it's empty.
+		ClientCA             string     `json:"clientCA,omitempty" jsonschema:"omitempty"`
+		TopicCacheSize       int        `json:"topicCacheSize" jsonschema:"omitempty"`
+		MaxAllowedConnection int        `json:"maxAllowedConnection" jsonschema:"omitempty"`
+		ConnectionLimit      *RateLimit `json:"connectionLimit" jsonschema:"omitempty"`
+		ClientPublishLimit   *RateLimit `json:"clientPublishLimit" jsonschema:"omitempty"`
+		Rules                []*Rule    `json:"rules" jsonschema:"omitempty"`
+		BrokerMode           bool       `json:"brokerMode" jsonschema:"omitempty"`
+		// SharedSubscriptionStrategy is the strategy to choose a member of
+		// shared subscription group, roundRobin or hash, default is roundRobin
+		SharedSubscriptionStrategy string `json:"sharedSubscriptionStrategy,omitempty" jsonschema:"omitempty,enum=,enum=roundRobin,enum=hash"`
+		// WebSocket enables MQTT over WebSocket
+		WebSocket *WebSocketSpec `json:"webSocket,omitempty" jsonschema:"omitempty"`
+		// OfflineQueue queues messages for offline persistent sessions
+		OfflineQueue *OfflineQueueSpec `json:"offlineQueue,omitempty" jsonschema:"omitempty"`
+		// SysInterval is the interval to publish broker statistics to
+		// $SYS topics, $SYS topics are disabled if it's empty
+		SysInterval string `json:"sysInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		// TopicMetricsLevels is the number of leading topic levels used as
+		// the prefix of per topic metrics, 0 disables per topic metrics
+		TopicMetricsLevels int `json:"topicMetricsLevels,omitempty" jsonschema:"omitempty,minimum=0"`
 		// unit is second, default is 30s
 		RetryInterval int `yaml:"retryInterval" jsonschema:"omitempty"`
 	}
@@ -97,6 +117,15 @@ type (
 		TimePeriod  int `json:"timePeriod" jsonschema:"omitempty"`
 	}
 
+	// WebSocketSpec describes the WebSocket listener of MQTTProxy. Port 0
+	// means no listener of its own, and WebSocket connections are accepted
+	// only through HTTPServer routes with the MQTTWebSocket filter.
+	WebSocketSpec struct {
+		Port   uint16 `json:"port" jsonschema:"omitempty"`
+		Path   string `json:"path" jsonschema:"omitempty"`
+		UseTLS bool   `json:"useTLS" jsonschema:"omitempty"`
+	}
+
 	// Certificate describes TLS certifications.
 	Certificate struct {
 		Name string `json:"name" jsonschema:"required"`
@@ -119,9 +148,24 @@ func (spec *Spec) tlsConfig() (*tls.Config, error) {
 		return nil, fmt.Errorf("none valid certs and secret")
 	}
 
-	return &tls.Config{Certificates: certificates}, nil
+	cfg := &tls.Config{Certificates: certificates}
+	if spec.ClientCA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(spec.ClientCA)) {
+			return nil, fmt.Errorf("none valid client CA certs")
+		}
+		// whether a client certificate is mandatory is decided by the
+		// authentication filters of the connect pipeline.
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
 }
 
 func sessionStoreKey(clientID string) string {
 	return fmt.Sprintf(sessionPrefix, clientID)
 }
+
+func retainStoreKey(topic string) string {
+	return fmt.Sprintf(retainPrefix, topic)
+}
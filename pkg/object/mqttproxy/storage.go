@@ -32,13 +32,14 @@ type (
 		getPrefix(prefix string, keysOnly bool) (map[string]string, error)
 		put(key, value string) error
 		delete(key string) error
+		deletePrefix(prefix string) error
 		watch(prefix string) (<-chan map[string]*string, func(), error)
 	}
 
 	mockStorage struct {
 		mu        sync.RWMutex
 		store     map[string]string
-		watchCh   chan map[string]*string
+		watchChs  map[string]chan map[string]*string
 		watchFlag int32
 	}
 
@@ -57,8 +58,8 @@ func newStorage(cls cluster.Cluster) storage {
 		}
 	}
 	return &mockStorage{
-		store:   make(map[string]string),
-		watchCh: make(chan map[string]*string),
+		store:    make(map[string]string),
+		watchChs: make(map[string]chan map[string]*string),
 	}
 }
 
@@ -90,13 +91,7 @@ func (m *mockStorage) getPrefix(prefix string, keysOnly bool) (map[string]string
 func (m *mockStorage) put(key, value string) error {
 	m.mu.Lock()
 	m.store[key] = value
-	if m.watched() {
-		go func() {
-			res := make(map[string]*string)
-			res[key] = &value
-			m.watchCh <- res
-		}()
-	}
+	m.notify(key, &value)
 	m.mu.Unlock()
 	return nil
 }
@@ -104,24 +99,49 @@ func (m *mockStorage) put(key, value string) error {
 func (m *mockStorage) delete(key string) error {
 	m.mu.Lock()
 	delete(m.store, key)
-	if m.watched() {
-		go func() {
-			ans := make(map[string]*string)
-			ans[key] = nil
-			m.watchCh <- ans
-		}()
+	m.notify(key, nil)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *mockStorage) deletePrefix(prefix string) error {
+	m.mu.Lock()
+	for k := range m.store {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.store, k)
+			m.notify(k, nil)
+		}
 	}
 	m.mu.Unlock()
 	return nil
 }
 
+// notify sends the event to watchers of the key, the caller must hold the lock.
+func (m *mockStorage) notify(key string, value *string) {
+	for prefix, ch := range m.watchChs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		go func(ch chan map[string]*string) {
+			ch <- map[string]*string{key: value}
+		}(ch)
+	}
+}
+
 func (m *mockStorage) watched() bool {
 	return atomic.LoadInt32(&m.watchFlag) != 0
 }
 
 func (m *mockStorage) watch(prefix string) (<-chan map[string]*string, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	atomic.StoreInt32(&m.watchFlag, 1)
-	return m.watchCh, func() {}, nil
+	ch, ok := m.watchChs[prefix]
+	if !ok {
+		ch = make(chan map[string]*string)
+		m.watchChs[prefix] = ch
+	}
+	return ch, func() {}, nil
 }
 
 func (cs *clusterStorage) get(key string) (*string, error) {
@@ -143,6 +163,10 @@ func (cs *clusterStorage) delete(key string) error {
 	return cs.cls.Delete(key)
 }
 
+func (cs *clusterStorage) deletePrefix(prefix string) error {
+	return cs.cls.DeletePrefix(prefix)
+}
+
 func (cs *clusterStorage) watch(prefix string) (<-chan map[string]*string, func(), error) {
 	watcher, err := cs.cls.Watcher()
 	if err != nil {
@@ -110,6 +110,51 @@ func (mgr *levelTopicManager) findSubscribers(levels []string) map[string]byte {
 	return output
 }
 
+// findMatched is the reverse of findSubscribers, it regards the inserted topics as
+// topic names and finds all of them that match the given topic filter. For example,
+// filter "loc/+/event" will find topics "loc/device/event" and "loc/gateway/event".
+// Wildcards at the first level don't match topics beginning with "$".
+func (mgr *levelTopicManager) findMatched(filter []string) map[string]byte {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	output := make(map[string]byte)
+
+	currentLevelNodes := []*topicNode{mgr.root}
+	for i, filterLevel := range filter {
+		if filterLevel == "#" {
+			for _, node := range currentLevelNodes {
+				node.addAllClients(output, i == 0)
+			}
+			return output
+		}
+
+		nextLevelNodes := []*topicNode{}
+		for _, node := range currentLevelNodes {
+			if filterLevel != "+" {
+				if nextNode, ok := node.nodes[filterLevel]; ok {
+					nextLevelNodes = append(nextLevelNodes, nextNode)
+				}
+				continue
+			}
+			for nodeLevel, nextNode := range node.nodes {
+				if i == 0 && strings.HasPrefix(nodeLevel, "$") {
+					continue
+				}
+				nextLevelNodes = append(nextLevelNodes, nextNode)
+			}
+		}
+		currentLevelNodes = nextLevelNodes
+		if len(currentLevelNodes) == 0 {
+			return output
+		}
+	}
+	for _, n := range currentLevelNodes {
+		n.addClients(output)
+	}
+	return output
+}
+
 func (mgr *levelTopicManager) insert(levels []string, qos byte, clientID string) {
 	node := mgr.root
 	var nextNode *topicNode
@@ -169,6 +214,18 @@ func (node *topicNode) addClients(ans map[string]byte) {
 	}
 }
 
+// addAllClients adds clients of the node and all its descendants, skipSys is used
+// to skip the child nodes beginning with "$".
+func (node *topicNode) addAllClients(ans map[string]byte, skipSys bool) {
+	node.addClients(ans)
+	for level, child := range node.nodes {
+		if skipSys && strings.HasPrefix(level, "$") {
+			continue
+		}
+		child.addAllClients(ans, false)
+	}
+}
+
 type topicLevelCache struct {
 	data *lru.Cache
 }
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// mqttSubprotocol is the WebSocket subprotocol of MQTT.
+	mqttSubprotocol      = "mqtt"
+	defaultWebSocketPath = "/mqtt"
+)
+
+// ServeWebSocket serves the MQTT over WebSocket request with the MQTTProxy
+// of the name, clientIP is the IP address of the client, the one of
+// r.RemoteAddr is used if it's empty. It returns false if the MQTTProxy is
+// not found, and an error if the request failed to upgrade to WebSocket,
+// the failure response has been sent to the client in that case.
+func ServeWebSocket(name string, w http.ResponseWriter, r *http.Request, clientIP string) (bool, error) {
+	brokers.RLock()
+	b := brokers.m[name]
+	brokers.RUnlock()
+	if b == nil || b.closed() {
+		return false, nil
+	}
+	return true, b.serveWebSocket(w, r, clientIP)
+}
+
+// webSocketConn is the MQTT over WebSocket connection, RemoteAddr of
+// websocket.Conn returns the origin of the request rather than the address
+// of the client.
+type webSocketConn struct {
+	*websocket.Conn
+	remoteAddr net.Addr
+}
+
+// RemoteAddr returns the address of the client.
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// clientAddr returns the address of the client of r, the IP address is
+// replaced by ip if it's not empty.
+func clientAddr(r *http.Request, ip string) net.Addr {
+	host, port, _ := net.SplitHostPort(r.RemoteAddr)
+	if ip != "" && ip != host {
+		return &net.TCPAddr{IP: net.ParseIP(ip)}
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+func (spec *WebSocketSpec) path() string {
+	if spec.Path == "" {
+		return defaultWebSocketPath
+	}
+	return spec.Path
+}
+
+// webSocketHandshake accepts the request only if it offers the mqtt
+// subprotocol, which is required by the MQTT specification.
+func webSocketHandshake(config *websocket.Config, r *http.Request) error {
+	for _, p := range config.Protocol {
+		if p == mqttSubprotocol {
+			config.Protocol = []string{mqttSubprotocol}
+			return nil
+		}
+	}
+	return fmt.Errorf("websocket subprotocol %s is required", mqttSubprotocol)
+}
+
+// serveWebSocket serves the MQTT over WebSocket request, connections are
+// handled in the same way as TCP connections. It returns after the
+// connection is closed, or an error if the handshake failed.
+func (b *Broker) serveWebSocket(w http.ResponseWriter, r *http.Request, clientIP string) error {
+	upgraded := false
+	addr := clientAddr(r, clientIP)
+	websocket.Server{
+		Handshake: webSocketHandshake,
+		Handler: func(conn *websocket.Conn) {
+			upgraded = true
+			// MQTT packets must be sent in binary frames
+			conn.PayloadType = websocket.BinaryFrame
+			b.handleConn(&webSocketConn{Conn: conn, remoteAddr: addr})
+		},
+	}.ServeHTTP(w, r)
+
+	// the handler is not called if the handshake failed, and websocket
+	// has responded with 400 Bad Request.
+	if !upgraded {
+		return fmt.Errorf("upgrade to websocket failed")
+	}
+	return nil
+}
+
+func (b *Broker) setWebSocketListener() error {
+	spec := b.spec.WebSocket
+	if spec == nil || spec.Port == 0 {
+		return nil
+	}
+
+	addr := fmt.Sprintf(":%d", spec.Port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("gen mqtt websocket listener with addr %s failed: %v", addr, err)
+	}
+	if spec.UseTLS {
+		cfg, err := b.spec.tlsConfig()
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("invalid tls config for mqtt proxy websocket: %v", err)
+		}
+		l = tls.NewListener(l, cfg)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(spec.path(), func(w http.ResponseWriter, r *http.Request) {
+		b.serveWebSocket(w, r, "")
+	})
+	b.wsServer = &http.Server{Handler: mux}
+	go func() {
+		err := b.wsServer.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			logger.SpanErrorf(nil, "mqtt websocket server of %s stopped: %v", b.name, err)
+		}
+	}()
+	return nil
+}
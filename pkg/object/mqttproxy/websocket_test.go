@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func getWebSocketClient(t *testing.T, url, clientID string) paho.Client {
+	opts := paho.NewClientOptions().AddBroker(url).SetClientID(clientID).SetUsername("test").SetPassword("test")
+	c := paho.NewClient(opts)
+	token := c.Connect()
+	token.Wait()
+	require.Nil(t, token.Error())
+	return c
+}
+
+func TestWebSocket(t *testing.T) {
+	assert := assert.New(t)
+	spec := getDefaultSpec()
+	spec.WebSocket = &WebSocketSpec{Port: 8083}
+	broker := getBrokerFromSpec(spec, &mockMuxMapper{})
+	defer broker.close()
+
+	// WebSocket and TCP clients share the same broker
+	ch := make(chan CheckMsg, 10)
+	wsClient := getWebSocketClient(t, "ws://localhost:8083/mqtt", "ws")
+	defer wsClient.Disconnect(200)
+	token := wsClient.Subscribe("ws/topic", 1, getMQTTSubscribeHandler(ch))
+	token.Wait()
+	assert.Nil(token.Error())
+
+	tcpClient := getDefaultMQTTClient(t, "tcp", true)
+	defer tcpClient.Disconnect(200)
+	wsConn := broker.getClient("ws").conn
+	assert.Equal("127.0.0.1", wsConn.RemoteAddr().(*net.TCPAddr).IP.String())
+	assert.NotNil(broker.getClient("tcp"))
+	broker.sendMsgToClient(nil, "ws/topic", []byte("over websocket"), QoS1, nil, "", nil)
+	msg := <-ch
+	assert.Equal(CheckMsg{topic: "ws/topic", payload: "over websocket", qos: 1}, msg)
+
+	// mqtt subprotocol is required
+	config, err := websocket.NewConfig("ws://localhost:8083/mqtt", "http://localhost")
+	require.Nil(t, err)
+	_, err = websocket.DialConfig(config)
+	assert.NotNil(err)
+	config.Protocol = []string{"mqtt"}
+	conn, err := websocket.DialConfig(config)
+	assert.Nil(err)
+	conn.Close()
+}
+
+func TestServeWebSocket(t *testing.T) {
+	assert := assert.New(t)
+	broker := getDefaultBroker(&mockMuxMapper{})
+
+	errCh := make(chan error, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found, err := ServeWebSocket("test", w, r, r.Header.Get("X-Real-Ip"))
+		if !found {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		errCh <- err
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	opts := paho.NewClientOptions().AddBroker(url).SetClientID("ws").SetUsername("test").SetPassword("test")
+	opts.SetHTTPHeaders(http.Header{"X-Real-Ip": []string{"10.0.0.1"}})
+	client := paho.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	require.Nil(t, token.Error())
+	c := broker.getClient("ws")
+	require.NotNil(t, c)
+	// the client IP is passed to the broker
+	assert.Equal("10.0.0.1:0", c.conn.RemoteAddr().String())
+	client.Disconnect(200)
+	assert.Nil(<-errCh)
+
+	// failed handshake is reported
+	config, err := websocket.NewConfig(url, "http://localhost")
+	require.Nil(t, err)
+	_, err = websocket.DialConfig(config)
+	assert.NotNil(err)
+	assert.NotNil(<-errCh)
+
+	// closed broker is unregistered
+	broker.close()
+	resp, err := http.Get(srv.URL)
+	assert.Nil(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+}
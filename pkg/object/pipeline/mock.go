@@ -17,9 +17,37 @@
 
 package pipeline
 
-import "github.com/megaease/easegress/pkg/filters"
+import (
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+)
 
 // MockGetFilter is used to get filter from pipeline for testing.
 func MockGetFilter(p *Pipeline, name string) filters.Filter {
 	return p.filters[name]
 }
+
+// MockHandle handles the request like Handle, but returns the statistics of
+// the filters too, it is used to check the flow of the pipeline in tests.
+func MockHandle(p *Pipeline, ctx *context.Context) (string, []FilterStat) {
+	if len(p.spec.Data) > 0 {
+		ctx.SetData("PIPELINE", p.spec.Data)
+	}
+
+	stats := make([]FilterStat, 0, len(p.flow))
+	result, stats, _ := p.doHandle(ctx, p.flow, stats)
+	return result, stats
+}
+
+// MockReplaceFilter replaces the filter of the name with f for testing and
+// returns the previous one, the caller is responsible for closing it.
+func MockReplaceFilter(p *Pipeline, name string, f filters.Filter) filters.Filter {
+	prev := p.filters[name]
+	p.filters[name] = f
+	for i := range p.flow {
+		if p.flow[i].FilterName == name {
+			p.flow[i].filter = f
+		}
+	}
+	return prev
+}
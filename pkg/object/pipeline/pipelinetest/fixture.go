@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipelinetest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// ProtocolHTTP is the protocol of HTTP requests, it is the default one.
+	ProtocolHTTP = "http"
+	// ProtocolGRPC is the protocol of gRPC requests.
+	ProtocolGRPC = "grpc"
+	// ProtocolMQTT is the protocol of MQTT requests.
+	ProtocolMQTT = "mqtt"
+)
+
+type (
+	// Fixture is a set of cases to test pipelines.
+	Fixture struct {
+		Cases []*Case `json:"cases"`
+	}
+
+	// Case feeds one request to a pipeline and checks the outcome.
+	Case struct {
+		Name string `json:"name"`
+		// Pipeline is the name of the pipeline to test, it could be empty
+		// if there is only one pipeline.
+		Pipeline string `json:"pipeline"`
+		// Protocol is one of http, grpc and mqtt, default to http.
+		Protocol string `json:"protocol"`
+
+		// Request is the HTTP request, it is built by the request builder
+		// of the HTTP protocol, so the fields are the same as the ones of
+		// RequestBuilder: method, url, headers, body and formData.
+		Request map[string]interface{} `json:"request"`
+		GRPC    *GRPCRequest           `json:"grpc"`
+		MQTT    *MQTTRequest           `json:"mqtt"`
+
+		Stubs  []*Stub `json:"stubs"`
+		Expect *Expect `json:"expect"`
+	}
+
+	// GRPCRequest describes a gRPC request.
+	GRPCRequest struct {
+		FullMethod string              `json:"fullMethod"`
+		Headers    map[string][]string `json:"headers"`
+		RealIP     string              `json:"realIP"`
+	}
+
+	// MQTTRequest describes an MQTT packet and the client sending it.
+	MQTTRequest struct {
+		ClientID string `json:"clientID"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		// PacketType is one of connect, publish, subscribe, unsubscribe
+		// and disconnect, default to publish.
+		PacketType string   `json:"packetType"`
+		Topic      string   `json:"topic"`
+		Topics     []string `json:"topics"`
+		QoS        byte     `json:"qos"`
+		Payload    string   `json:"payload"`
+	}
+
+	// Stub replaces the behavior of a filter.
+	//
+	// For the Proxy filter, the stub replaces the servers only, so the pool
+	// selection, load balancing and response handling work as usual. Stubs
+	// of the same Proxy are matched in order by Server and Path, a request
+	// matching no stub fails with a connection error.
+	//
+	// For other filters, including other proxies like GRPCProxy and
+	// WebSocketProxy, the whole filter is replaced, it returns Result and
+	// sets Response as the output response if it is not nil.
+	Stub struct {
+		Filter   string        `json:"filter"`
+		Server   string        `json:"server"`
+		Path     string        `json:"path"`
+		Error    string        `json:"error"`
+		Result   string        `json:"result"`
+		Response *StubResponse `json:"response"`
+	}
+
+	// StubResponse is the canned response of a stub.
+	StubResponse struct {
+		// StatusCode is the HTTP status code, or the gRPC status code
+		// for gRPC requests.
+		StatusCode int                 `json:"statusCode"`
+		Message    string              `json:"message"`
+		Headers    map[string][]string `json:"headers"`
+		Body       string              `json:"body"`
+	}
+
+	// Expect is the expected outcome of a case, empty fields are not checked.
+	Expect struct {
+		Result *string `json:"result"`
+		// Path is the names (or aliases) of the executed filters in order.
+		Path          []string          `json:"path"`
+		FilterResults map[string]string `json:"filterResults"`
+
+		StatusCode     int               `json:"statusCode"`
+		Headers        map[string]string `json:"headers"`
+		Body           *string           `json:"body"`
+		BodyContains   string            `json:"bodyContains"`
+		RequestHeaders map[string]string `json:"requestHeaders"`
+
+		// Drop and Disconnect are for MQTT requests.
+		Drop       *bool `json:"drop"`
+		Disconnect *bool `json:"disconnect"`
+	}
+)
+
+// LoadFixture loads the fixture from YAML or JSON data.
+func LoadFixture(data []byte) (*Fixture, error) {
+	f := &Fixture{}
+	if err := codectool.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("unmarshal fixture failed: %v", err)
+	}
+
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Validate validates the fixture.
+func (f *Fixture) Validate() error {
+	if len(f.Cases) == 0 {
+		return fmt.Errorf("no cases in fixture")
+	}
+
+	for i, c := range f.Cases {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case-%d", i)
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the case.
+func (c *Case) Validate() error {
+	c.Protocol = strings.ToLower(c.Protocol)
+
+	switch c.Protocol {
+	case "", ProtocolHTTP:
+		c.Protocol = ProtocolHTTP
+	case ProtocolGRPC:
+		if c.GRPC == nil {
+			return fmt.Errorf("grpc request is required")
+		}
+	case ProtocolMQTT:
+		if c.MQTT == nil {
+			return fmt.Errorf("mqtt request is required")
+		}
+	default:
+		return fmt.Errorf("unsupported protocol %s", c.Protocol)
+	}
+
+	for _, s := range c.Stubs {
+		if s.Filter == "" {
+			return fmt.Errorf("filter of stub is required")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,524 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pipelinetest runs pipelines offline against recorded fixtures,
+// without a running cluster or real backends.
+package pipelinetest
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
+	"github.com/megaease/easegress/pkg/object/pipeline"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/protocols"
+	"github.com/megaease/easegress/pkg/protocols/grpcprot"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/protocols/mqttprot"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+type (
+	// Harness runs cases against pipelines offline.
+	Harness struct {
+		pipelines map[string]*supervisor.Spec
+	}
+
+	// CaseResult is the outcome of a case.
+	CaseResult struct {
+		Name     string
+		Result   string
+		Stats    []pipeline.FilterStat
+		Failures []string
+	}
+
+	stubFilter struct {
+		filters.Filter
+		stub *Stub
+	}
+
+	stubRoundTripper struct {
+		stubs []*Stub
+	}
+)
+
+// New creates a Harness from the specs of pipelines in YAML or JSON.
+//
+// The pipelines are created with a mocked supervisor, so filters depending
+// on other objects, like the Proxy using a service registry, are not supported.
+func New(specs ...string) (*Harness, error) {
+	h := &Harness{pipelines: map[string]*supervisor.Spec{}}
+
+	super := supervisor.NewMock(option.New(), nil, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+	for _, s := range specs {
+		spec, err := super.NewSpec(s)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Kind() != pipeline.Kind {
+			return nil, fmt.Errorf("%s: want kind %s, got %s", spec.Name(), pipeline.Kind, spec.Kind())
+		}
+		h.pipelines[spec.Name()] = spec
+	}
+
+	if len(h.pipelines) == 0 {
+		return nil, fmt.Errorf("no pipelines")
+	}
+	return h, nil
+}
+
+// Passed returns whether the case passed.
+func (cr *CaseResult) Passed() bool {
+	return len(cr.Failures) == 0
+}
+
+// Path returns the executed filters and their results.
+func (cr *CaseResult) Path() string {
+	var sb strings.Builder
+	for i := range cr.Stats {
+		if i > 0 {
+			sb.WriteString("->")
+		}
+		stat := &cr.Stats[i]
+		sb.WriteString(stat.Name)
+		if stat.Result != "" {
+			sb.WriteString("(" + stat.Result + ")")
+		}
+	}
+	return sb.String()
+}
+
+func (cr *CaseResult) failf(format string, args ...interface{}) {
+	cr.Failures = append(cr.Failures, fmt.Sprintf(format, args...))
+}
+
+// RunFixture runs all cases of the fixture.
+func (h *Harness) RunFixture(f *Fixture) []*CaseResult {
+	results := make([]*CaseResult, 0, len(f.Cases))
+	for _, c := range f.Cases {
+		results = append(results, h.Run(c))
+	}
+	return results
+}
+
+// Run runs the case against a newly created pipeline, so the cases don't
+// affect each other.
+func (h *Harness) Run(c *Case) (cr *CaseResult) {
+	cr = &CaseResult{Name: c.Name}
+
+	spec, err := h.getPipeline(c.Pipeline)
+	if err != nil {
+		cr.failf("%v", err)
+		return cr
+	}
+
+	p, err := newPipeline(spec)
+	if err != nil {
+		cr.failf("create pipeline %s failed: %v", spec.Name(), err)
+		return cr
+	}
+	defer p.Close()
+
+	if err := applyStubs(p, c.Stubs); err != nil {
+		cr.failf("%v", err)
+		return cr
+	}
+
+	ctx := context.New(tracing.NoopSpan)
+	defer ctx.Finish()
+
+	if err := setRequest(ctx, c); err != nil {
+		cr.failf("build request failed: %v", err)
+		return cr
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				cr.failf("pipeline panicked: %v", r)
+			}
+		}()
+		cr.Result, cr.Stats = pipeline.MockHandle(p, ctx)
+	}()
+
+	if c.Expect != nil {
+		c.Expect.check(cr, ctx)
+	}
+	return cr
+}
+
+func (h *Harness) getPipeline(name string) (*supervisor.Spec, error) {
+	if name != "" {
+		spec := h.pipelines[name]
+		if spec == nil {
+			return nil, fmt.Errorf("pipeline %s not found", name)
+		}
+		return spec, nil
+	}
+
+	if len(h.pipelines) != 1 {
+		return nil, fmt.Errorf("pipeline is required since there are %d pipelines", len(h.pipelines))
+	}
+	for _, spec := range h.pipelines {
+		return spec, nil
+	}
+	return nil, nil
+}
+
+func newPipeline(spec *supervisor.Spec) (p *pipeline.Pipeline, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	p = &pipeline.Pipeline{}
+	p.Init(spec, nil)
+	return p, nil
+}
+
+func applyStubs(p *pipeline.Pipeline, stubs []*Stub) error {
+	proxyStubs := map[string][]*Stub{}
+
+	for _, stub := range stubs {
+		f := pipeline.MockGetFilter(p, stub.Filter)
+		if f == nil {
+			return fmt.Errorf("stub: filter %s not found", stub.Filter)
+		}
+
+		if _, ok := f.(*httpproxy.Proxy); ok {
+			proxyStubs[stub.Filter] = append(proxyStubs[stub.Filter], stub)
+			continue
+		}
+
+		if _, ok := f.(*stubFilter); ok {
+			return fmt.Errorf("stub: filter %s is stubbed more than once", stub.Filter)
+		}
+		if stub.Result != "" && !stringtool.StrInSlice(stub.Result, f.Kind().Results) {
+			return fmt.Errorf("stub: result %s is not in %v", stub.Result, f.Kind().Results)
+		}
+
+		prev := pipeline.MockReplaceFilter(p, stub.Filter, &stubFilter{Filter: f, stub: stub})
+		prev.Close()
+	}
+
+	for name, stubs := range proxyStubs {
+		proxy := pipeline.MockGetFilter(p, name).(*httpproxy.Proxy)
+		httpproxy.MockSetRoundTripper(proxy, &stubRoundTripper{stubs: stubs})
+	}
+
+	return nil
+}
+
+func setRequest(ctx *context.Context, c *Case) error {
+	switch c.Protocol {
+	case ProtocolGRPC:
+		req := grpcprot.NewRequestWithContext(stdcontext.Background())
+		req.SetFullMethod(c.GRPC.FullMethod)
+		for k, vs := range c.GRPC.Headers {
+			req.RawHeader().RawAdd(k, vs...)
+		}
+		if c.GRPC.RealIP != "" {
+			req.SetRealIP(c.GRPC.RealIP)
+		}
+		ctx.SetInputRequest(req)
+		return nil
+
+	case ProtocolMQTT:
+		packet, err := c.MQTT.packet()
+		if err != nil {
+			return err
+		}
+		client := &mqttprot.MockClient{
+			MockClientID: c.MQTT.ClientID,
+			MockUserName: c.MQTT.Username,
+		}
+		ctx.SetInputRequest(mqttprot.NewRequest(packet, client))
+		ctx.SetInputResponse(mqttprot.NewResponse())
+		return nil
+
+	default:
+		p := protocols.Get(ProtocolHTTP)
+		ri := p.NewRequestInfo()
+		if c.Request != nil {
+			buff, err := codectool.MarshalJSON(c.Request)
+			if err != nil {
+				return err
+			}
+			if err = codectool.Unmarshal(buff, ri); err != nil {
+				return err
+			}
+		}
+		req, err := p.BuildRequest(ri)
+		if err != nil {
+			return err
+		}
+		ctx.SetInputRequest(req)
+		return nil
+	}
+}
+
+func (mr *MQTTRequest) packet() (packets.ControlPacket, error) {
+	switch strings.ToLower(mr.PacketType) {
+	case "connect":
+		p := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+		p.ProtocolName, p.ProtocolVersion = "MQTT", 4
+		p.ClientIdentifier = mr.ClientID
+		p.Username, p.UsernameFlag = mr.Username, mr.Username != ""
+		p.Password, p.PasswordFlag = []byte(mr.Password), mr.Password != ""
+		return p, nil
+	case "", "publish":
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.TopicName, p.Qos = mr.Topic, mr.QoS
+		p.Payload = []byte(mr.Payload)
+		return p, nil
+	case "subscribe":
+		p := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+		p.Topics = mr.Topics
+		for range mr.Topics {
+			p.Qoss = append(p.Qoss, mr.QoS)
+		}
+		return p, nil
+	case "unsubscribe":
+		p := packets.NewControlPacket(packets.Unsubscribe).(*packets.UnsubscribePacket)
+		p.Topics = mr.Topics
+		return p, nil
+	case "disconnect":
+		return packets.NewControlPacket(packets.Disconnect), nil
+	default:
+		return nil, fmt.Errorf("unsupported packet type %s", mr.PacketType)
+	}
+}
+
+// Handle returns the canned result and sets the canned response.
+func (f *stubFilter) Handle(ctx *context.Context) string {
+	resp := f.stub.Response
+	if resp == nil {
+		return f.stub.Result
+	}
+
+	switch ctx.GetInputRequest().(type) {
+	case *grpcprot.Request:
+		r := grpcprot.NewResponse()
+		for k, vs := range resp.Headers {
+			r.RawHeader().RawAdd(k, vs...)
+		}
+		r.SetStatus(status.New(codes.Code(resp.StatusCode), resp.Message))
+		ctx.SetOutputResponse(r)
+	case *mqttprot.Request:
+		r := mqttprot.NewResponse()
+		r.SetPayload([]byte(resp.Body))
+		ctx.SetOutputResponse(r)
+	default:
+		p := protocols.Get(ProtocolHTTP)
+		ri := p.NewResponseInfo()
+		codectool.MustUnmarshal(codectool.MustMarshalJSON(resp), ri)
+		r, err := p.BuildResponse(ri)
+		if err != nil {
+			panic(fmt.Errorf("stub %s: %v", f.stub.Filter, err))
+		}
+		ctx.SetOutputResponse(r)
+	}
+
+	return f.stub.Result
+}
+
+// Close does nothing since the stubbed filter has been closed.
+func (f *stubFilter) Close() {}
+
+// RoundTrip returns the response of the first matched stub.
+func (rt *stubRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	server := req.URL.Scheme + "://" + req.URL.Host
+	for _, stub := range rt.stubs {
+		if stub.Server != "" && !strings.HasPrefix(server, strings.TrimSuffix(stub.Server, "/")) {
+			continue
+		}
+		if stub.Path != "" && !strings.HasPrefix(req.URL.Path, stub.Path) {
+			continue
+		}
+
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+		}
+		if stub.Error != "" {
+			return nil, fmt.Errorf("%s", stub.Error)
+		}
+
+		resp := stub.Response
+		if resp == nil {
+			resp = &StubResponse{}
+		}
+		statusCode := resp.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		header := http.Header{}
+		for k, vs := range resp.Headers {
+			for _, v := range vs {
+				header.Add(k, v)
+			}
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			StatusCode:    statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(resp.Body)),
+			ContentLength: int64(len(resp.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no stub matches server %s path %s", server, req.URL.Path)
+}
+
+func (e *Expect) check(cr *CaseResult, ctx *context.Context) {
+	if e.Result != nil && *e.Result != cr.Result {
+		cr.failf("result: want %q, got %q", *e.Result, cr.Result)
+	}
+
+	if e.Path != nil {
+		path := make([]string, 0, len(cr.Stats))
+		for _, stat := range cr.Stats {
+			path = append(path, stat.Name)
+		}
+		if !reflect.DeepEqual(e.Path, path) {
+			cr.failf("path: want %v, got %v", e.Path, path)
+		}
+	}
+
+	for name, want := range e.FilterResults {
+		found := false
+		for _, stat := range cr.Stats {
+			if stat.Name != name {
+				continue
+			}
+			found = true
+			if stat.Result != want {
+				cr.failf("result of filter %s: want %q, got %q", name, want, stat.Result)
+			}
+		}
+		if !found {
+			cr.failf("result of filter %s: filter not executed", name)
+		}
+	}
+
+	for k, want := range e.RequestHeaders {
+		req := ctx.GetInputRequest()
+		if got := headerValue(req.Header(), k); got != want {
+			cr.failf("request header %s: want %q, got %q", k, want, got)
+		}
+	}
+
+	e.checkResponse(cr, ctx.GetOutputResponse())
+}
+
+func (e *Expect) checkResponse(cr *CaseResult, resp protocols.Response) {
+	needResponse := e.StatusCode != 0 || len(e.Headers) > 0 || e.Body != nil ||
+		e.BodyContains != "" || e.Drop != nil || e.Disconnect != nil
+	if !needResponse {
+		return
+	}
+	if resp == nil {
+		cr.failf("response: want a response, got nil")
+		return
+	}
+
+	if e.StatusCode != 0 {
+		sc, ok := resp.(interface{ StatusCode() int })
+		if !ok {
+			cr.failf("status code: %T has no status code", resp)
+		} else if got := sc.StatusCode(); got != e.StatusCode {
+			cr.failf("status code: want %d, got %d", e.StatusCode, got)
+		}
+	}
+
+	for k, want := range e.Headers {
+		if got := headerValue(resp.Header(), k); got != want {
+			cr.failf("response header %s: want %q, got %q", k, want, got)
+		}
+	}
+
+	if e.Body != nil || e.BodyContains != "" {
+		body := readBody(resp)
+		if e.Body != nil && *e.Body != body {
+			cr.failf("body: want %q, got %q", *e.Body, body)
+		}
+		if e.BodyContains != "" && !strings.Contains(body, e.BodyContains) {
+			cr.failf("body: want containing %q, got %q", e.BodyContains, body)
+		}
+	}
+
+	if e.Drop != nil || e.Disconnect != nil {
+		r, ok := resp.(*mqttprot.Response)
+		if !ok {
+			cr.failf("drop/disconnect: want an MQTT response, got %T", resp)
+			return
+		}
+		if e.Drop != nil && *e.Drop != r.Drop() {
+			cr.failf("drop: want %v, got %v", *e.Drop, r.Drop())
+		}
+		if e.Disconnect != nil && *e.Disconnect != r.Disconnect() {
+			cr.failf("disconnect: want %v, got %v", *e.Disconnect, r.Disconnect())
+		}
+	}
+}
+
+func readBody(resp protocols.Response) string {
+	if !resp.IsStream() {
+		return string(resp.RawPayload())
+	}
+	body, _ := io.ReadAll(resp.GetPayload())
+	return string(body)
+}
+
+func headerValue(h protocols.Header, key string) string {
+	switch h := h.(type) {
+	case nil:
+		return ""
+	case *httpprot.Header:
+		return h.Header.Get(key)
+	case *grpcprot.Header:
+		return h.GetFirst(key)
+	default:
+		v := h.Get(key)
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
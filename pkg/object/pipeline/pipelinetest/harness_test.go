@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pipelinetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/megaease/easegress/pkg/filters/builder"
+	_ "github.com/megaease/easegress/pkg/filters/validator"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+const testPipeline = `
+name: pipeline-demo
+kind: Pipeline
+flow:
+- filter: validator
+  jumpIf: { invalid: END }
+- filter: requestAdaptor
+- filter: proxy
+filters:
+- name: validator
+  kind: Validator
+  headers:
+    X-Id:
+      regexp: "^[0-9]+$"
+- name: requestAdaptor
+  kind: RequestAdaptor
+  header:
+    set:
+      X-Adapted: "true"
+- name: proxy
+  kind: Proxy
+  pools:
+  - servers:
+    - url: http://127.0.0.1:9095
+  - filter:
+      headers:
+        X-Canary:
+          exact: "true"
+    servers:
+    - url: http://127.0.0.1:9096
+`
+
+const testFixture = `
+cases:
+- name: main pool
+  request:
+    method: GET
+    url: /users
+    headers:
+      X-Id: ["1"]
+  stubs:
+  - filter: proxy
+    server: http://127.0.0.1:9095
+    response:
+      statusCode: 200
+      headers:
+        Content-Type: ["text/plain"]
+      body: main
+  expect:
+    result: ""
+    path: [validator, requestAdaptor, proxy]
+    statusCode: 200
+    headers:
+      Content-Type: text/plain
+    body: main
+    requestHeaders:
+      X-Adapted: "true"
+- name: canary pool
+  request:
+    url: /users
+    headers:
+      X-Id: ["1"]
+      X-Canary: ["true"]
+  stubs:
+  - filter: proxy
+    server: http://127.0.0.1:9095
+    response: { statusCode: 200, body: main }
+  - filter: proxy
+    server: http://127.0.0.1:9096
+    response: { statusCode: 201, body: canary }
+  expect:
+    statusCode: 201
+    body: canary
+- name: invalid request
+  request:
+    url: /users
+    headers:
+      X-Id: ["abc"]
+  expect:
+    result: invalid
+    path: [validator]
+    statusCode: 400
+- name: server down
+  request:
+    url: /users
+    headers:
+      X-Id: ["1"]
+  stubs:
+  - filter: proxy
+    error: connection refused
+  expect:
+    filterResults:
+      proxy: serverError
+    statusCode: 503
+- name: stubbed validator
+  request:
+    url: /users
+  stubs:
+  - filter: validator
+    result: invalid
+    response: { statusCode: 403, body: forbidden }
+  expect:
+    result: invalid
+    path: [validator]
+    statusCode: 403
+    bodyContains: forbid
+`
+
+func TestHarness(t *testing.T) {
+	assert := assert.New(t)
+
+	h, err := New(testPipeline)
+	assert.Nil(err)
+
+	f, err := LoadFixture([]byte(testFixture))
+	assert.Nil(err)
+
+	for _, cr := range h.RunFixture(f) {
+		assert.True(cr.Passed(), "%s: %v, path: %s", cr.Name, cr.Failures, cr.Path())
+	}
+
+	// expectation mismatch is reported
+	c := f.Cases[0]
+	c.Expect.Body = new(string)
+	cr := h.Run(c)
+	assert.False(cr.Passed())
+	assert.Len(cr.Failures, 1)
+
+	// unknown stub filter
+	cr = h.Run(&Case{Name: "bad stub", Protocol: ProtocolHTTP, Stubs: []*Stub{{Filter: "none"}}})
+	assert.False(cr.Passed())
+}
+
+func TestHarnessMQTT(t *testing.T) {
+	assert := assert.New(t)
+
+	h, err := New(`
+name: mqtt-pipeline
+kind: Pipeline
+filters:
+- name: validator
+  kind: Validator
+  headers:
+    X-Id:
+      regexp: "^[0-9]+$"
+`)
+	assert.Nil(err)
+
+	f, err := LoadFixture([]byte(`
+cases:
+- protocol: mqtt
+  mqtt:
+    clientID: device-1
+    topic: a/b
+    payload: hello
+  stubs:
+  - filter: validator
+  expect:
+    result: ""
+    path: [validator]
+    drop: false
+`))
+	assert.Nil(err)
+
+	cr := h.Run(f.Cases[0])
+	assert.True(cr.Passed(), "%v", cr.Failures)
+}
+
+func TestLoadFixture(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadFixture([]byte(`cases: []`))
+	assert.NotNil(err)
+
+	_, err = LoadFixture([]byte(`
+cases:
+- protocol: grpc
+`))
+	assert.NotNil(err)
+
+	_, err = LoadFixture([]byte(`
+cases:
+- protocol: unknown
+`))
+	assert.NotNil(err)
+
+	f, err := LoadFixture([]byte(`
+cases:
+- request: { url: /a }
+`))
+	assert.Nil(err)
+	assert.Equal(ProtocolHTTP, f.Cases[0].Protocol)
+	assert.Equal("case-0", f.Cases[0].Name)
+}
@@ -0,0 +1,457 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package schedulecontroller implements the ScheduleController, which
+// executes the schedules stored in the cluster.
+package schedulecontroller
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster/schedule"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// Category is the category of ScheduleController.
+	Category = supervisor.CategorySystemController
+
+	// Kind is the kind of ScheduleController.
+	Kind = "ScheduleController"
+
+	checkInterval = time.Second
+)
+
+// errObjectChanged means the object was changed by others after the spec
+// of the schedule was applied.
+var errObjectChanged = errors.New("object changed after applied")
+
+type (
+	// ScheduleController is a system controller to execute schedules.
+	// It runs in every member, but only the leader executes schedules,
+	// and all the states are stored in the cluster, so that no schedule
+	// is lost when the leader changes.
+	ScheduleController struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		super     *supervisor.Supervisor
+
+		store  *schedule.Store
+		status atomic.Value // *Status
+
+		done chan struct{}
+	}
+
+	// Spec describes ScheduleController.
+	Spec struct{}
+
+	// Status is the status of ScheduleController.
+	Status struct {
+		Leader    bool `json:"leader"`
+		Schedules int  `json:"schedules"`
+		Active    int  `json:"active"`
+	}
+)
+
+func init() {
+	supervisor.Register(&ScheduleController{})
+}
+
+// Category returns the category of ScheduleController.
+func (sc *ScheduleController) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of ScheduleController.
+func (sc *ScheduleController) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ScheduleController.
+func (sc *ScheduleController) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes ScheduleController.
+func (sc *ScheduleController) Init(superSpec *supervisor.Spec) {
+	sc.superSpec, sc.spec, sc.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	sc.reload()
+}
+
+// Inherit inherits previous generation of ScheduleController.
+func (sc *ScheduleController) Inherit(spec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	sc.Init(spec)
+}
+
+func (sc *ScheduleController) reload() {
+	sc.store = schedule.NewStore(sc.super.Cluster())
+	sc.status.Store(&Status{})
+	sc.done = make(chan struct{})
+
+	go sc.run()
+}
+
+func (sc *ScheduleController) run() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			sc.safeCheck(now)
+		case <-sc.done:
+			return
+		}
+	}
+}
+
+func (sc *ScheduleController) safeCheck(now time.Time) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Errorf("%s: recover from check, err: %v, stack trace:\n%s\n",
+				sc.superSpec.Name(), err, debug.Stack())
+		}
+	}()
+
+	sc.check(now)
+}
+
+func (sc *ScheduleController) check(now time.Time) {
+	if !sc.super.Cluster().IsLeader() {
+		sc.status.Store(&Status{})
+		return
+	}
+
+	schedules, err := sc.store.ListSchedules()
+	if err != nil {
+		logger.Errorf("list schedules failed: %v", err)
+		return
+	}
+	states, err := sc.store.ListStates()
+	if err != nil {
+		logger.Errorf("list schedule states failed: %v", err)
+		return
+	}
+
+	status := &Status{Leader: true, Schedules: len(schedules)}
+	for name, s := range schedules {
+		state := states[name]
+		if state == nil {
+			state = &schedule.State{Phase: schedule.PhasePending}
+		}
+
+		newState, stored := sc.reconcile(name, s, state, now)
+		if newState.Phase == schedule.PhaseActive {
+			status.Active++
+		}
+		if stored || *newState == *state {
+			continue
+		}
+		if err := sc.store.PutState(name, newState); err != nil {
+			logger.Errorf("put state of schedule %s failed: %v", name, err)
+		}
+	}
+
+	// The object of a deleted schedule is reverted if it's active.
+	for name, state := range states {
+		if _, exists := schedules[name]; exists {
+			continue
+		}
+		if state.Phase == schedule.PhaseActive {
+			// the state is deleted with the reverted object.
+			switch err := sc.revert(name, state, nil); err {
+			case nil:
+				logger.Infof("deleted schedule %s reverted", name)
+				continue
+			case errObjectChanged:
+				logger.Warnf("deleted schedule %s: %s was changed after applied, not reverted", name, state.Object)
+			default:
+				logger.Errorf("revert deleted schedule %s failed: %v", name, err)
+				continue
+			}
+		}
+		if err := sc.store.DeleteState(name); err != nil {
+			logger.Errorf("delete state of schedule %s failed: %v", name, err)
+		}
+	}
+
+	sc.status.Store(status)
+}
+
+// reconcile returns the new state of the schedule at now, and whether the
+// new state is already stored with the applied or reverted object.
+func (sc *ScheduleController) reconcile(name string, s *schedule.Schedule, state *schedule.State, now time.Time) (*schedule.State, bool) {
+	newState := *state
+	generation := s.Generation()
+
+	transit := func(phase string) {
+		newState.Phase = phase
+		newState.Generation = generation
+		newState.LastTransitionTime = now.Format(time.RFC3339)
+		newState.Error, newState.Conflict = "", ""
+	}
+	fail := func(format string, args ...interface{}) (*schedule.State, bool) {
+		err := fmt.Sprintf(format, args...)
+		if err != state.Error {
+			logger.Errorf("schedule %s: %s", s.Name, err)
+		}
+		newState.Error = err
+		return &newState, false
+	}
+
+	start, end, ok := s.Window(now)
+
+	switch state.Phase {
+	case schedule.PhaseActive:
+		windowStart, _ := time.Parse(time.RFC3339, state.WindowStart)
+		windowEnd, _ := time.Parse(time.RFC3339, state.WindowEnd)
+		if state.WindowEnd == "" {
+			windowEnd = time.Time{}
+		}
+
+		// The window ends, or the window or the object is changed by
+		// updating the schedule.
+		if !schedule.InWindow(now, windowStart, windowEnd) || !ok ||
+			!start.Equal(windowStart) || s.Object() != state.Object {
+			saved := newState
+			transit(schedule.PhasePending)
+			newState.Object, newState.WindowStart, newState.WindowEnd = "", "", ""
+			newState.Applied, newState.Original = "", ""
+
+			switch err := sc.revert(name, state, &newState); err {
+			case nil:
+				logger.Infof("schedule %s: %s reverted", s.Name, state.Object)
+				return &newState, true
+			case errObjectChanged:
+				logger.Warnf("schedule %s: %s was changed after applied, not reverted", s.Name, state.Object)
+				newState.Conflict = fmt.Sprintf("%s was changed after applied at %s, not reverted",
+					state.Object, state.LastTransitionTime)
+				return &newState, false
+			default:
+				newState = saved
+				return fail("revert %s failed: %v", state.Object, err)
+			}
+		}
+
+		// The spec is changed by updating the schedule.
+		if generation != state.Generation {
+			saved := newState
+			applied, err := sc.apply(name, s, func(original, applied string) *schedule.State {
+				newState.Generation, newState.Applied, newState.Error = generation, applied, ""
+				return &newState
+			})
+			if err != nil {
+				newState = saved
+				return fail("apply %s failed: %v", s.Object(), err)
+			}
+			return applied, true
+		}
+		return &newState, false
+
+	case schedule.PhaseCompleted:
+		if generation == state.Generation {
+			return &newState, false
+		}
+		// The schedule is updated, so restart it.
+		transit(schedule.PhasePending)
+		return sc.reconcile(name, s, &newState, now)
+
+	default:
+		if !ok {
+			transit(schedule.PhaseCompleted)
+			return &newState, false
+		}
+		if !schedule.InWindow(now, start, end) {
+			if state.Generation != generation {
+				newState.Generation = generation
+			}
+			return &newState, false
+		}
+
+		saved := newState
+		applied, err := sc.apply(name, s, func(original, applied string) *schedule.State {
+			// A window without end never reverts the object.
+			if end.IsZero() {
+				transit(schedule.PhaseCompleted)
+				return &newState
+			}
+
+			transit(schedule.PhaseActive)
+			newState.Object = s.Object()
+			newState.WindowStart = start.Format(time.RFC3339)
+			newState.WindowEnd = end.Format(time.RFC3339)
+			newState.Applied, newState.Original = applied, original
+			return &newState
+		})
+		if err != nil {
+			newState = saved
+			return fail("apply %s failed: %v", s.Object(), err)
+		}
+		logger.Infof("schedule %s: %s applied", s.Name, s.Object())
+		return applied, true
+	}
+}
+
+// lockConfig locks the config of objects with the same cluster mutex as
+// the admin API, and returns the function to unlock it.
+func (sc *ScheduleController) lockConfig() (func(), error) {
+	cls := sc.super.Cluster()
+	mutex, err := cls.Mutex(cls.Layout().ConfigLock())
+	if err != nil {
+		return nil, err
+	}
+	if err = mutex.Lock(); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := mutex.Unlock(); err != nil {
+			logger.Errorf("unlock %s failed: %v", cls.Layout().ConfigLock(), err)
+		}
+	}, nil
+}
+
+// putConfig puts or deletes (value is nil) the object config and the state
+// of the schedule, and upgrades the config version in the same transaction,
+// so that the state always records whether the object is applied. The
+// caller must hold the config lock.
+func (sc *ScheduleController) putConfig(object string, value *string, name string, state *schedule.State) error {
+	cls := sc.super.Cluster()
+	versionKey := cls.Layout().ConfigVersion()
+	current, err := cls.Get(versionKey)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	if current != nil {
+		version, err = strconv.ParseInt(*current, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse version %s to int failed: %v", *current, err)
+		}
+	}
+	newVersion := strconv.FormatInt(version+1, 10)
+
+	var stateValue *string
+	if state != nil {
+		buff, err := codectool.MarshalJSON(state)
+		if err != nil {
+			return err
+		}
+		stateValue = new(string)
+		*stateValue = string(buff)
+	}
+
+	return cls.PutAndDelete(map[string]*string{
+		cls.Layout().ConfigObjectKey(object): value,
+		cls.Layout().ScheduleStateKey(name):  stateValue,
+		versionKey:                           &newVersion,
+	})
+}
+
+// apply applies the spec of the schedule to the object, and stores the
+// state returned by newState in the same transaction. newState is called
+// with the original spec of the object, which is empty if the object
+// doesn't exist, and the applied spec. It returns the stored state.
+func (sc *ScheduleController) apply(name string, s *schedule.Schedule,
+	newState func(original, applied string) *schedule.State) (*schedule.State, error) {
+	buff, err := codectool.MarshalJSON(s.Spec)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := sc.super.NewSpec(string(buff))
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := sc.lockConfig()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	original := ""
+	value, err := sc.super.Cluster().Get(sc.super.Cluster().Layout().ConfigObjectKey(spec.Name()))
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		current, err := sc.super.NewSpec(*value)
+		if err != nil {
+			return nil, err
+		}
+		if current.Kind() != spec.Kind() {
+			return nil, fmt.Errorf("different kinds: %s, %s", current.Kind(), spec.Kind())
+		}
+		original = *value
+	}
+
+	applied := spec.JSONConfig()
+	state := newState(original, applied)
+	if err = sc.putConfig(spec.Name(), &applied, name, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// revert reverts the object to the original spec, or deletes it if it
+// didn't exist before the schedule was activated, and stores newState of
+// the schedule in the same transaction, the state is deleted if newState
+// is nil. It returns errObjectChanged without reverting or storing the
+// state if the object is not the applied one.
+func (sc *ScheduleController) revert(name string, state, newState *schedule.State) error {
+	unlock, err := sc.lockConfig()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	value, err := sc.super.Cluster().Get(sc.super.Cluster().Layout().ConfigObjectKey(state.Object))
+	if err != nil {
+		return err
+	}
+	if value == nil && state.Original == "" {
+		return sc.putConfig(state.Object, nil, name, newState)
+	}
+	if value == nil || *value != state.Applied {
+		return errObjectChanged
+	}
+
+	if state.Original == "" {
+		return sc.putConfig(state.Object, nil, name, newState)
+	}
+	return sc.putConfig(state.Object, &state.Original, name, newState)
+}
+
+// Status returns the status of ScheduleController.
+func (sc *ScheduleController) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: sc.status.Load().(*Status),
+	}
+}
+
+// Close closes ScheduleController.
+func (sc *ScheduleController) Close() {
+	close(sc.done)
+}
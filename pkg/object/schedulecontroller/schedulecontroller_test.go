@@ -0,0 +1,372 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedulecontroller
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/pkg/cluster/schedule"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/codectool"
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	mockObject struct{}
+
+	mockObjectSpec struct {
+		Weight int `json:"weight,omitempty"`
+	}
+
+	mockMutex struct {
+		sync.Mutex
+	}
+)
+
+func (m *mockMutex) Lock() error {
+	m.Mutex.Lock()
+	return nil
+}
+
+func (m *mockMutex) Unlock() error {
+	m.Mutex.Unlock()
+	return nil
+}
+
+func (m *mockObject) Category() supervisor.ObjectCategory {
+	return supervisor.CategoryBusinessController
+}
+
+func (m *mockObject) Kind() string {
+	return "MockScheduleObject"
+}
+
+func (m *mockObject) DefaultSpec() interface{} {
+	return &mockObjectSpec{}
+}
+
+func (m *mockObject) Init(superSpec *supervisor.Spec) {}
+
+func (m *mockObject) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {}
+
+func (m *mockObject) Status() *supervisor.Status {
+	return &supervisor.Status{}
+}
+
+func (m *mockObject) Close() {}
+
+func init() {
+	logger.InitNop()
+	supervisor.Register(&mockObject{})
+}
+
+// newTestController creates a controller with an in-memory cluster.
+func newTestController(kvs map[string]string) *ScheduleController {
+	var mutex sync.Mutex
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedIsLeader = func() bool { return true }
+	cls.MockedLayout = func() *cluster.Layout { return &cluster.Layout{} }
+	cls.MockedGet = func(key string) (*string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if v, ok := kvs[key]; ok {
+			return &v, nil
+		}
+		return nil, nil
+	}
+	cls.MockedGetPrefix = func(prefix string) (map[string]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		result := map[string]string{}
+		for k, v := range kvs {
+			if strings.HasPrefix(k, prefix) {
+				result[k] = v
+			}
+		}
+		return result, nil
+	}
+	cls.MockedPut = func(key, value string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		kvs[key] = value
+		return nil
+	}
+	cls.MockedDelete = func(key string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(kvs, key)
+		return nil
+	}
+	cls.MockedPutAndDelete = func(m map[string]*string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for k, v := range m {
+			if v == nil {
+				delete(kvs, k)
+			} else {
+				kvs[k] = *v
+			}
+		}
+		return nil
+	}
+	configLock := &mockMutex{}
+	cls.MockedMutex = func(name string) (cluster.Mutex, error) {
+		return configLock, nil
+	}
+
+	var mockMap sync.Map
+	super := supervisor.NewMock(nil, cls, mockMap, mockMap, nil, nil, false, nil, nil)
+
+	sc := &ScheduleController{
+		super: super,
+		store: schedule.NewStore(cls),
+	}
+	sc.status.Store(&Status{})
+	return sc
+}
+
+func weightOf(t *testing.T, kvs map[string]string, name string) int {
+	value, ok := kvs["/config/objects/"+name]
+	if !ok {
+		return -1
+	}
+	spec := &mockObjectSpec{}
+	assert.Nil(t, codectool.UnmarshalJSON([]byte(value), spec))
+	return spec.Weight
+}
+
+func mustParse(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestOneOffSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{
+		"/config/objects/demo": `{"name": "demo", "kind": "MockScheduleObject", "weight": 1}`,
+	}
+	sc := newTestController(kvs)
+
+	sched := &schedule.Schedule{
+		Name:  "maintenance",
+		Spec:  map[string]interface{}{"name": "demo", "kind": "MockScheduleObject", "weight": 2},
+		Start: "2022-11-25T02:00:00Z",
+		End:   "2022-11-25T04:00:00Z",
+	}
+	assert.Nil(sc.store.PutSchedule(sched))
+
+	sc.check(mustParse("2022-11-25T01:00:00Z"))
+	assert.Equal(1, weightOf(t, kvs, "demo"))
+	state, _ := sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhasePending, state.Phase)
+
+	sc.check(mustParse("2022-11-25T02:00:00Z"))
+	assert.Equal(2, weightOf(t, kvs, "demo"))
+	assert.Equal("1", kvs["/config/version"])
+	state, _ = sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhaseActive, state.Phase)
+	assert.Equal("2022-11-25T04:00:00Z", state.WindowEnd)
+	assert.Equal(1, sc.Status().ObjectStatus.(*Status).Active)
+
+	// A new leader takes over the active schedule, and the spec of the
+	// schedule is updated in the window.
+	sc = newTestController(kvs)
+	sched.Spec["weight"] = 3
+	assert.Nil(sc.store.PutSchedule(sched))
+	sc.check(mustParse("2022-11-25T03:00:00Z"))
+	assert.Equal(3, weightOf(t, kvs, "demo"))
+
+	sc.check(mustParse("2022-11-25T04:00:00Z"))
+	assert.Equal(1, weightOf(t, kvs, "demo"))
+	sc.check(mustParse("2022-11-25T04:00:01Z"))
+	state, _ = sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhaseCompleted, state.Phase)
+}
+
+func TestCronSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{}
+	sc := newTestController(kvs)
+
+	// The object doesn't exist before the window, so it's deleted at the
+	// end of the window.
+	sched := &schedule.Schedule{
+		Name:     "nightly",
+		Spec:     map[string]interface{}{"name": "mock", "kind": "MockScheduleObject", "weight": 5},
+		Cron:     "0 2 * * *",
+		Duration: "1h",
+	}
+	assert.Nil(sc.store.PutSchedule(sched))
+
+	for day := 25; day <= 26; day++ {
+		date := fmt.Sprintf("2022-11-%d", day)
+		sc.check(mustParse(date + "T02:30:00Z"))
+		assert.Equal(5, weightOf(t, kvs, "mock"))
+		sc.check(mustParse(date + "T03:00:00Z"))
+		assert.Equal(-1, weightOf(t, kvs, "mock"))
+		state, _ := sc.store.GetState("nightly")
+		assert.Equal(schedule.PhasePending, state.Phase)
+	}
+
+	// Deleting an active schedule reverts the object.
+	sc.check(mustParse("2022-11-27T02:30:00Z"))
+	assert.Equal(5, weightOf(t, kvs, "mock"))
+	assert.Nil(sc.store.DeleteSchedule("nightly"))
+	sc.check(mustParse("2022-11-27T02:31:00Z"))
+	assert.Equal(-1, weightOf(t, kvs, "mock"))
+	state, _ := sc.store.GetState("nightly")
+	assert.Nil(state)
+}
+
+func TestScheduleFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{}
+	sc := newTestController(kvs)
+
+	sched := &schedule.Schedule{
+		Name:  "bad",
+		Spec:  map[string]interface{}{"name": "mock", "kind": "UnknownKind"},
+		Start: "2022-11-25T02:00:00Z",
+	}
+	assert.Nil(sc.store.PutSchedule(sched))
+
+	sc.check(mustParse("2022-11-25T02:00:00Z"))
+	state, _ := sc.store.GetState("bad")
+	assert.Equal(schedule.PhasePending, state.Phase)
+	assert.NotEmpty(state.Error)
+
+	// A window without end is applied once and never reverted.
+	sched.Spec["kind"] = "MockScheduleObject"
+	assert.Nil(sc.store.PutSchedule(sched))
+	sc.check(mustParse("2022-11-25T02:00:01Z"))
+	state, _ = sc.store.GetState("bad")
+	assert.Equal(schedule.PhaseCompleted, state.Phase)
+	assert.Empty(state.Error)
+	assert.Equal(0, weightOf(t, kvs, "mock"))
+	sc.check(mustParse("2030-01-01T00:00:00Z"))
+	assert.Equal(0, weightOf(t, kvs, "mock"))
+}
+
+func TestScheduleConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{
+		"/config/objects/demo": `{"name": "demo", "kind": "MockScheduleObject", "weight": 1}`,
+	}
+	sc := newTestController(kvs)
+
+	sched := &schedule.Schedule{
+		Name:     "nightly",
+		Spec:     map[string]interface{}{"name": "demo", "kind": "MockScheduleObject", "weight": 2},
+		Cron:     "0 2 * * *",
+		Duration: "1h",
+	}
+	assert.Nil(sc.store.PutSchedule(sched))
+
+	// The object is updated by others in the window, so it's not reverted.
+	sc.check(mustParse("2022-11-25T02:00:00Z"))
+	assert.Equal(2, weightOf(t, kvs, "demo"))
+	kvs["/config/objects/demo"] = `{"name": "demo", "kind": "MockScheduleObject", "weight": 3}`
+	sc.check(mustParse("2022-11-25T03:00:00Z"))
+	assert.Equal(3, weightOf(t, kvs, "demo"))
+	state, _ := sc.store.GetState("nightly")
+	assert.Equal(schedule.PhasePending, state.Phase)
+	assert.NotEmpty(state.Conflict)
+	assert.Empty(state.Error)
+
+	// The conflict is cleared by the next window.
+	sc.check(mustParse("2022-11-26T02:00:00Z"))
+	assert.Equal(2, weightOf(t, kvs, "demo"))
+	state, _ = sc.store.GetState("nightly")
+	assert.Equal(schedule.PhaseActive, state.Phase)
+	assert.Empty(state.Conflict)
+
+	// The object deleted by others is not recreated.
+	delete(kvs, "/config/objects/demo")
+	sc.check(mustParse("2022-11-26T03:00:00Z"))
+	assert.Equal(-1, weightOf(t, kvs, "demo"))
+	state, _ = sc.store.GetState("nightly")
+	assert.NotEmpty(state.Conflict)
+}
+
+func TestScheduleStateStoredWithObject(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{
+		"/config/objects/demo": `{"name": "demo", "kind": "MockScheduleObject", "weight": 1}`,
+	}
+	sc := newTestController(kvs)
+	cls := sc.super.Cluster().(*clustertest.MockedCluster)
+
+	sched := &schedule.Schedule{
+		Name:  "maintenance",
+		Spec:  map[string]interface{}{"name": "demo", "kind": "MockScheduleObject", "weight": 2},
+		Start: "2022-11-25T02:00:00Z",
+		End:   "2022-11-25T04:00:00Z",
+	}
+	assert.Nil(sc.store.PutSchedule(sched))
+
+	// The transaction fails, so neither the object nor the state is
+	// changed, and the error is recorded.
+	putAndDelete := cls.MockedPutAndDelete
+	cls.MockedPutAndDelete = func(m map[string]*string) error {
+		return fmt.Errorf("etcd unavailable")
+	}
+	sc.check(mustParse("2022-11-25T02:00:00Z"))
+	assert.Equal(1, weightOf(t, kvs, "demo"))
+	state, _ := sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhasePending, state.Phase)
+	assert.Empty(state.Original)
+	assert.NotEmpty(state.Error)
+
+	// The state is stored with the applied object even if other writes
+	// fail, so the next check doesn't apply it again.
+	cls.MockedPutAndDelete = putAndDelete
+	cls.MockedPut = func(key, value string) error {
+		return fmt.Errorf("etcd unavailable")
+	}
+	sc.check(mustParse("2022-11-25T02:00:01Z"))
+	assert.Equal(2, weightOf(t, kvs, "demo"))
+	state, _ = sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhaseActive, state.Phase)
+	assert.Empty(state.Error)
+	sc.check(mustParse("2022-11-25T02:00:02Z"))
+	state, _ = sc.store.GetState("maintenance")
+	assert.Equal(`{"name": "demo", "kind": "MockScheduleObject", "weight": 1}`, state.Original)
+
+	// The object is reverted with the state.
+	sc.check(mustParse("2022-11-25T04:00:00Z"))
+	assert.Equal(1, weightOf(t, kvs, "demo"))
+	state, _ = sc.store.GetState("maintenance")
+	assert.Equal(schedule.PhasePending, state.Phase)
+	assert.Empty(state.Conflict)
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package serviceregistry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/megaease/easegress/pkg/api"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	apiGroupName = "service_registry_admin"
+
+	// APIPrefix is the URL prefix of the admin APIs of service instances.
+	APIPrefix = "/serviceregistries/{registry}/instances"
+)
+
+func (sr *ServiceRegistry) registerAPIs() {
+	group := &api.Group{
+		Group: apiGroupName,
+		Entries: []*api.Entry{
+			{Path: APIPrefix, Method: http.MethodGet, Handler: sr.listInstances},
+			{Path: APIPrefix, Method: http.MethodPost, Handler: sr.applyInstances},
+			{Path: APIPrefix + "/{service}/{instance}", Method: http.MethodGet, Handler: sr.getInstance},
+			{Path: APIPrefix + "/{service}/{instance}", Method: http.MethodDelete, Handler: sr.deleteInstance},
+		},
+	}
+
+	api.RegisterAPIs(group)
+}
+
+func (sr *ServiceRegistry) unregisterAPIs() {
+	api.UnregisterAPIs(apiGroupName)
+}
+
+// sortedInstances returns the instances sorted by their keys.
+func sortedInstances(instances map[string]*ServiceInstanceSpec) []*ServiceInstanceSpec {
+	result := make([]*ServiceInstanceSpec, 0, len(instances))
+	for _, instance := range instances {
+		result = append(result, instance)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key() < result[j].Key()
+	})
+	return result
+}
+
+// listInstances lists service instances of the registry, only instances
+// of the service are listed if query parameter 'service' is not empty.
+func (sr *ServiceRegistry) listInstances(w http.ResponseWriter, r *http.Request) {
+	registryName := chi.URLParam(r, "registry")
+	serviceName := r.URL.Query().Get("service")
+
+	var instances map[string]*ServiceInstanceSpec
+	var err error
+	if serviceName == "" {
+		instances, err = sr.ListAllServiceInstances(registryName)
+	} else {
+		instances, err = sr.ListServiceInstances(registryName, serviceName)
+	}
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	api.WriteBody(w, r, sortedInstances(instances))
+}
+
+func (sr *ServiceRegistry) getInstance(w http.ResponseWriter, r *http.Request) {
+	registryName := chi.URLParam(r, "registry")
+	serviceName := chi.URLParam(r, "service")
+	instanceID := chi.URLParam(r, "instance")
+
+	instance, err := sr.GetServiceInstance(registryName, serviceName, instanceID)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	api.WriteBody(w, r, instance)
+}
+
+// applyInstances registers or updates service instances in the registry,
+// the body is a list of service instances.
+func (sr *ServiceRegistry) applyInstances(w http.ResponseWriter, r *http.Request) {
+	registryName := chi.URLParam(r, "registry")
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("read body failed: %v", err))
+		return
+	}
+
+	var list []*ServiceInstanceSpec
+	if err = codectool.Unmarshal(body, &list); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("unmarshal body failed: %v", err))
+		return
+	}
+
+	instances := make(map[string]*ServiceInstanceSpec, len(list))
+	for _, instance := range list {
+		instance.RegistryName = registryName
+		if err = instance.Validate(); err != nil {
+			api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("%+v is invalid: %v", instance, err))
+			return
+		}
+		instances[instance.Key()] = instance
+	}
+
+	if err = sr.ApplyServiceInstances(registryName, instances); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+}
+
+// deleteInstance deregisters a service instance from the registry.
+func (sr *ServiceRegistry) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	registryName := chi.URLParam(r, "registry")
+	serviceName := chi.URLParam(r, "service")
+	instanceID := chi.URLParam(r, "instance")
+
+	instance, err := sr.GetServiceInstance(registryName, serviceName, instanceID)
+	if err != nil {
+		api.HandleAPIError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	instances := map[string]*ServiceInstanceSpec{instance.Key(): instance}
+	if err = sr.DeleteServiceInstances(registryName, instances); err != nil {
+		api.HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+}
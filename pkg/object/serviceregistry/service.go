@@ -19,7 +19,9 @@ package serviceregistry
 
 import (
 	"fmt"
+	"net"
 	"reflect"
+	"strconv"
 )
 
 type (
@@ -43,6 +45,8 @@ type (
 		Tags []string `json:"tags"`
 		// Weight is optional.
 		Weight int `json:"weight"`
+		// Draining is optional, a draining instance receives no new requests.
+		Draining bool `json:"draining,omitempty"`
 	}
 )
 
@@ -102,7 +106,7 @@ func (s *ServiceInstanceSpec) URL() string {
 		scheme = "http"
 	}
 
-	return fmt.Sprintf("%s://%s:%d", scheme, s.Address, s.Port)
+	return scheme + "://" + net.JoinHostPort(s.Address, strconv.Itoa(int(s.Port)))
 }
 
 // NewRegistryEventFromDiff creates a registry event from diff old and new specs.
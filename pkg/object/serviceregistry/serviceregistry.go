@@ -373,6 +373,8 @@ func (sr *ServiceRegistry) Inherit(superSpec *supervisor.Spec, previousGeneratio
 func (sr *ServiceRegistry) reload() {
 	sr.registryBuckets = make(map[string]*registryBucket)
 	sr.done = make(chan struct{})
+
+	sr.registerAPIs()
 }
 
 // Status returns status of ServiceRegistry.
@@ -384,5 +386,6 @@ func (sr *ServiceRegistry) Status() *supervisor.Status {
 
 // Close closes ServiceRegistry.
 func (sr *ServiceRegistry) Close() {
+	sr.unregisterAPIs()
 	close(sr.done)
 }
@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package staticserviceregistry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+var errReadOnlyFile = fmt.Errorf("instances of the file can't be changed by Easegress, change the file on every member instead")
+
+type (
+	// InstanceSpec is the spec of a service instance in the file or the
+	// custom data.
+	InstanceSpec struct {
+		ServiceName string   `json:"serviceName" jsonschema:"required"`
+		InstanceID  string   `json:"instanceID" jsonschema:"required"`
+		Address     string   `json:"address" jsonschema:"required"`
+		Port        uint16   `json:"port" jsonschema:"required"`
+		Scheme      string   `json:"scheme,omitempty" jsonschema:"omitempty"`
+		Tags        []string `json:"tags,omitempty" jsonschema:"omitempty"`
+		Weight      int      `json:"weight,omitempty" jsonschema:"omitempty"`
+		Draining    bool     `json:"draining,omitempty" jsonschema:"omitempty"`
+	}
+
+	// source is where the service instances come from.
+	source interface {
+		// watch calls onChange with all instances when they are changed,
+		// it returns after the source is closed.
+		watch(onChange func([]*InstanceSpec))
+		// apply adds or updates the instances.
+		apply(instances []*InstanceSpec) error
+		// delete deletes the instances.
+		delete(instances []*InstanceSpec) error
+		close()
+	}
+
+	// fileSource loads instances from a YAML or JSON file, the file is a
+	// list of instances. It's read-only.
+	fileSource struct {
+		path         string
+		syncInterval time.Duration
+		done         chan struct{}
+	}
+
+	// customDataSource loads instances from the data of a custom data kind.
+	customDataSource struct {
+		store  *customdata.Store
+		kind   string
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+)
+
+func (s *InstanceSpec) key() string {
+	return s.ServiceName + "/" + s.InstanceID
+}
+
+func newFileSource(path string, syncInterval time.Duration) *fileSource {
+	return &fileSource{
+		path:         path,
+		syncInterval: syncInterval,
+		done:         make(chan struct{}),
+	}
+}
+
+// load loads the instances from the file, a nonexistent file is an empty
+// list, so that the file could be created later.
+func (fs *fileSource) load() ([]*InstanceSpec, []byte, error) {
+	data, err := os.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var instances []*InstanceSpec
+	if err = codectool.Unmarshal(data, &instances); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal %s failed: %v", fs.path, err)
+	}
+	return instances, data, nil
+}
+
+func (fs *fileSource) watch(onChange func([]*InstanceSpec)) {
+	// watch the directory instead of the file, because the file may be
+	// replaced by renaming, e.g. by editors or the ConfigMap volumes of
+	// Kubernetes. The ticker is a fallback in case of missing events.
+	var events <-chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(fs.path))
+	}
+	if err != nil {
+		logger.Errorf("watch %s failed, fallback to polling: %v", fs.path, err)
+	} else {
+		defer watcher.Close()
+		events = watcher.Events
+	}
+
+	ticker := time.NewTicker(fs.syncInterval)
+	defer ticker.Stop()
+
+	var lastData []byte
+	first := true
+	for {
+		instances, data, err := fs.load()
+		if err != nil {
+			logger.Errorf("load %s failed: %v", fs.path, err)
+		} else if first || !bytes.Equal(data, lastData) {
+			first, lastData = false, data
+			onChange(instances)
+		}
+
+		select {
+		case <-fs.done:
+			return
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// apply rejects the instances, because the file is local to every member
+// of the cluster, it must be managed outside Easegress on every member.
+func (fs *fileSource) apply(instances []*InstanceSpec) error {
+	return errReadOnlyFile
+}
+
+// delete rejects the instances for the same reason as apply.
+func (fs *fileSource) delete(instances []*InstanceSpec) error {
+	return errReadOnlyFile
+}
+
+func (fs *fileSource) close() {
+	close(fs.done)
+}
+
+func newCustomDataSource(store *customdata.Store, kind string) *customDataSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &customDataSource{
+		store:  store,
+		kind:   kind,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func dataToInstance(data customdata.Data) (*InstanceSpec, error) {
+	instance := &InstanceSpec{}
+	buff, err := codectool.MarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = codectool.UnmarshalJSON(buff, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (cs *customDataSource) watch(onChange func([]*InstanceSpec)) {
+	handler := func(list []customdata.Data) {
+		instances := make([]*InstanceSpec, 0, len(list))
+		for _, data := range list {
+			instance, err := dataToInstance(data)
+			if err != nil {
+				logger.Errorf("convert custom data %v of kind %s failed: %v", data, cs.kind, err)
+				continue
+			}
+			instances = append(instances, instance)
+		}
+		onChange(instances)
+	}
+
+	for {
+		err := cs.store.Watch(cs.ctx, cs.kind, handler)
+		if err == nil {
+			return
+		}
+
+		logger.Errorf("watch custom data kind %s failed: %v", cs.kind, err)
+		select {
+		case <-cs.ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// idField returns the ID field of the custom data kind.
+func (cs *customDataSource) idField() (string, error) {
+	kind, err := cs.store.GetKind(cs.kind)
+	if err != nil {
+		return "", err
+	}
+	if kind == nil {
+		return "", fmt.Errorf("custom data kind %s not found", cs.kind)
+	}
+	if kind.IDField == "" {
+		return "name", nil
+	}
+	return kind.IDField, nil
+}
+
+func (cs *customDataSource) apply(instances []*InstanceSpec) error {
+	idField, err := cs.idField()
+	if err != nil {
+		return err
+	}
+
+	list := make([]customdata.Data, 0, len(instances))
+	for _, instance := range instances {
+		data, err := codectool.StructToMap(instance)
+		if err != nil {
+			return err
+		}
+		// the ID is generated from the instance if the ID field is not
+		// a field of instances.
+		if id, _ := data[idField].(string); id == "" {
+			data[idField] = instance.ServiceName + "-" + instance.InstanceID
+		}
+		list = append(list, data)
+	}
+
+	return cs.store.BatchUpdateData(cs.kind, nil, list)
+}
+
+func (cs *customDataSource) delete(instances []*InstanceSpec) error {
+	idField, err := cs.idField()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]struct{}, len(instances))
+	for _, instance := range instances {
+		keys[instance.key()] = struct{}{}
+	}
+
+	list, err := cs.store.ListData(cs.kind)
+	if err != nil {
+		return err
+	}
+
+	var del []string
+	for _, data := range list {
+		instance, err := dataToInstance(data)
+		if err != nil {
+			continue
+		}
+		if _, ok := keys[instance.key()]; ok {
+			id, _ := data[idField].(string)
+			del = append(del, id)
+		}
+	}
+
+	if len(del) == 0 {
+		return nil
+	}
+	return cs.store.BatchUpdateData(cs.kind, del, nil)
+}
+
+func (cs *customDataSource) close() {
+	cs.cancel()
+}
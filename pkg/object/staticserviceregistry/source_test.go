@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package staticserviceregistry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSource(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "instances.yaml")
+	err := os.WriteFile(path, []byte(`
+- serviceName: order
+  instanceID: order-1
+  address: 10.0.0.1
+  port: 8080
+  tags: [v1]
+  weight: 10
+`), 0o644)
+	assert.NoError(err)
+
+	fs := newFileSource(path, time.Hour)
+	defer fs.close()
+
+	ch := make(chan []*InstanceSpec, 10)
+	go fs.watch(func(instances []*InstanceSpec) {
+		ch <- instances
+	})
+
+	next := func() []*InstanceSpec {
+		select {
+		case instances := <-ch:
+			return instances
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for instances")
+			return nil
+		}
+	}
+
+	instances := next()
+	assert.Equal([]*InstanceSpec{{
+		ServiceName: "order",
+		InstanceID:  "order-1",
+		Address:     "10.0.0.1",
+		Port:        8080,
+		Tags:        []string{"v1"},
+		Weight:      10,
+	}}, instances)
+
+	// the file is read-only, it's changed outside Easegress.
+	err = fs.apply([]*InstanceSpec{
+		{ServiceName: "order", InstanceID: "order-2", Address: "10.0.0.2", Port: 8080, Weight: 10},
+	})
+	assert.Equal(errReadOnlyFile, err)
+	err = fs.delete([]*InstanceSpec{{ServiceName: "order", InstanceID: "order-1"}})
+	assert.Equal(errReadOnlyFile, err)
+
+	err = os.WriteFile(path, []byte(`
+- serviceName: order
+  instanceID: order-2
+  address: 10.0.0.2
+  port: 8080
+  draining: true
+`), 0o644)
+	assert.NoError(err)
+
+	instances = next()
+	assert.Len(instances, 1)
+	assert.Equal("order-2", instances[0].InstanceID)
+	assert.True(instances[0].Draining)
+
+	// nonexistent files are empty lists.
+	instances, _, err = newFileSource(path+".none", time.Hour).load()
+	assert.NoError(err)
+	assert.Empty(instances)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error(Spec{}.Validate())
+	assert.Error(Spec{File: "a.yaml", CustomDataKind: "instances"}.Validate())
+	assert.NoError(Spec{File: "a.yaml"}.Validate())
+	assert.NoError(Spec{CustomDataKind: "instances"}.Validate())
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package staticserviceregistry provides the StaticServiceRegistry.
+package staticserviceregistry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster/customdata"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/serviceregistry"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/v"
+)
+
+const (
+	// Category is the category of StaticServiceRegistry.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of StaticServiceRegistry.
+	Kind = "StaticServiceRegistry"
+)
+
+func init() {
+	supervisor.Register(&StaticServiceRegistry{})
+}
+
+type (
+	// StaticServiceRegistry is Object StaticServiceRegistry.
+	StaticServiceRegistry struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		serviceRegistry *serviceregistry.ServiceRegistry
+		firstDone       bool
+		instances       map[string]*serviceregistry.ServiceInstanceSpec
+		notify          chan *serviceregistry.RegistryEvent
+
+		source source
+
+		// current holds the instances loaded from the source.
+		currentMutex sync.RWMutex
+		current      map[string]*serviceregistry.ServiceInstanceSpec
+
+		statusMutex  sync.Mutex
+		instancesNum map[string]int
+
+		done chan struct{}
+	}
+
+	// Spec describes the StaticServiceRegistry.
+	Spec struct {
+		File           string `json:"file" jsonschema:"omitempty"`
+		CustomDataKind string `json:"customDataKind" jsonschema:"omitempty"`
+		SyncInterval   string `json:"syncInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	// Status is the status of StaticServiceRegistry.
+	Status struct {
+		Health              string         `json:"health"`
+		ServiceInstancesNum map[string]int `json:"instancesNum"`
+	}
+)
+
+var _ v.Validator = Spec{}
+
+// Validate validates Spec itself.
+func (spec Spec) Validate() error {
+	if (spec.File == "") == (spec.CustomDataKind == "") {
+		return fmt.Errorf("one and only one of file and customDataKind must be specified")
+	}
+
+	return nil
+}
+
+// Category returns the category of StaticServiceRegistry.
+func (s *StaticServiceRegistry) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of StaticServiceRegistry.
+func (s *StaticServiceRegistry) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of StaticServiceRegistry.
+func (s *StaticServiceRegistry) DefaultSpec() interface{} {
+	return &Spec{
+		SyncInterval: "10s",
+	}
+}
+
+// Init initializes StaticServiceRegistry.
+func (s *StaticServiceRegistry) Init(superSpec *supervisor.Spec) {
+	s.superSpec, s.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of StaticServiceRegistry.
+func (s *StaticServiceRegistry) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	s.Init(superSpec)
+}
+
+func (s *StaticServiceRegistry) reload() {
+	s.serviceRegistry = s.superSpec.Super().MustGetSystemController(serviceregistry.Kind).
+		Instance().(*serviceregistry.ServiceRegistry)
+	s.notify = make(chan *serviceregistry.RegistryEvent, 10)
+	s.firstDone = false
+
+	s.current = map[string]*serviceregistry.ServiceInstanceSpec{}
+	s.instancesNum = map[string]int{}
+	s.done = make(chan struct{})
+
+	if s.spec.File != "" {
+		syncInterval, err := time.ParseDuration(s.spec.SyncInterval)
+		if err != nil || syncInterval <= 0 {
+			syncInterval = 10 * time.Second
+		}
+		s.source = newFileSource(s.spec.File, syncInterval)
+	} else {
+		cls := s.superSpec.Super().Cluster()
+		store := customdata.NewStore(cls, cls.Layout().CustomDataKindPrefix(), cls.Layout().CustomDataPrefix())
+		s.source = newCustomDataSource(store, s.spec.CustomDataKind)
+	}
+
+	s.serviceRegistry.RegisterRegistry(s)
+
+	go s.source.watch(s.onChange)
+}
+
+// onChange is called by the source with all instances.
+func (s *StaticServiceRegistry) onChange(specs []*InstanceSpec) {
+	current := make(map[string]*serviceregistry.ServiceInstanceSpec, len(specs))
+	for _, spec := range specs {
+		instance := s.toServiceInstance(spec)
+		if err := instance.Validate(); err != nil {
+			logger.Errorf("%s: %+v is invalid: %v", s.Name(), spec, err)
+			continue
+		}
+		current[instance.Key()] = instance
+	}
+
+	s.currentMutex.Lock()
+	s.current = current
+	s.currentMutex.Unlock()
+
+	s.update()
+}
+
+func (s *StaticServiceRegistry) toServiceInstance(spec *InstanceSpec) *serviceregistry.ServiceInstanceSpec {
+	return &serviceregistry.ServiceInstanceSpec{
+		RegistryName: s.Name(),
+		ServiceName:  spec.ServiceName,
+		InstanceID:   spec.InstanceID,
+		Address:      spec.Address,
+		Port:         spec.Port,
+		Scheme:       spec.Scheme,
+		Tags:         spec.Tags,
+		Weight:       spec.Weight,
+		Draining:     spec.Draining,
+	}
+}
+
+func toInstanceSpecs(instances map[string]*serviceregistry.ServiceInstanceSpec) []*InstanceSpec {
+	specs := make([]*InstanceSpec, 0, len(instances))
+	for _, instance := range instances {
+		specs = append(specs, &InstanceSpec{
+			ServiceName: instance.ServiceName,
+			InstanceID:  instance.InstanceID,
+			Address:     instance.Address,
+			Port:        instance.Port,
+			Scheme:      instance.Scheme,
+			Tags:        instance.Tags,
+			Weight:      instance.Weight,
+			Draining:    instance.Draining,
+		})
+	}
+	return specs
+}
+
+func sortedByKey(instances map[string]*InstanceSpec) []*InstanceSpec {
+	keys := make([]string, 0, len(instances))
+	for key := range instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*InstanceSpec, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, instances[key])
+	}
+	return result
+}
+
+func (s *StaticServiceRegistry) update() {
+	instances, err := s.ListAllServiceInstances()
+	if err != nil {
+		logger.Errorf("list all service instances failed: %v", err)
+		return
+	}
+
+	instancesNum := make(map[string]int)
+	for _, instance := range instances {
+		instancesNum[instance.ServiceName]++
+	}
+
+	var event *serviceregistry.RegistryEvent
+	if !s.firstDone {
+		s.firstDone = true
+		event = &serviceregistry.RegistryEvent{
+			SourceRegistryName: s.Name(),
+			UseReplace:         true,
+			Replace:            instances,
+		}
+	} else {
+		event = serviceregistry.NewRegistryEventFromDiff(s.Name(), s.instances, instances)
+	}
+
+	if event.Empty() {
+		return
+	}
+
+	s.notify <- event
+	s.instances = instances
+
+	s.statusMutex.Lock()
+	s.instancesNum = instancesNum
+	s.statusMutex.Unlock()
+}
+
+// Status returns status of StaticServiceRegistry.
+func (s *StaticServiceRegistry) Status() *supervisor.Status {
+	st := &Status{Health: "ready"}
+
+	s.statusMutex.Lock()
+	st.ServiceInstancesNum = s.instancesNum
+	s.statusMutex.Unlock()
+
+	return &supervisor.Status{
+		ObjectStatus: st,
+	}
+}
+
+// Close closes StaticServiceRegistry.
+func (s *StaticServiceRegistry) Close() {
+	s.serviceRegistry.DeregisterRegistry(s.Name())
+
+	s.source.close()
+	close(s.done)
+}
+
+// Name returns name.
+func (s *StaticServiceRegistry) Name() string {
+	return s.superSpec.Name()
+}
+
+// Notify returns notify channel.
+func (s *StaticServiceRegistry) Notify() <-chan *serviceregistry.RegistryEvent {
+	return s.notify
+}
+
+// ApplyServiceInstances applies service instances to the registry.
+func (s *StaticServiceRegistry) ApplyServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	for _, instance := range instances {
+		err := instance.Validate()
+		if err != nil {
+			return fmt.Errorf("%+v is invalid: %v", instance, err)
+		}
+	}
+
+	return s.source.apply(toInstanceSpecs(instances))
+}
+
+// DeleteServiceInstances applies service instances to the registry.
+func (s *StaticServiceRegistry) DeleteServiceInstances(instances map[string]*serviceregistry.ServiceInstanceSpec) error {
+	return s.source.delete(toInstanceSpecs(instances))
+}
+
+// GetServiceInstance get service instance from the registry.
+func (s *StaticServiceRegistry) GetServiceInstance(serviceName, instanceID string) (*serviceregistry.ServiceInstanceSpec, error) {
+	instances, err := s.ListServiceInstances(serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instance := range instances {
+		if instance.InstanceID == instanceID {
+			return instance, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s/%s not found", serviceName, instanceID)
+}
+
+// ListServiceInstances list service instances of one service from the registry.
+func (s *StaticServiceRegistry) ListServiceInstances(serviceName string) (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	s.currentMutex.RLock()
+	defer s.currentMutex.RUnlock()
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec)
+	for key, instance := range s.current {
+		if instance.ServiceName == serviceName {
+			instances[key] = instance
+		}
+	}
+
+	return instances, nil
+}
+
+// ListAllServiceInstances list all service instances from the registry.
+func (s *StaticServiceRegistry) ListAllServiceInstances() (map[string]*serviceregistry.ServiceInstanceSpec, error) {
+	s.currentMutex.RLock()
+	defer s.currentMutex.RUnlock()
+
+	instances := make(map[string]*serviceregistry.ServiceInstanceSpec, len(s.current))
+	for key, instance := range s.current {
+		instances[key] = instance
+	}
+
+	return instances, nil
+}
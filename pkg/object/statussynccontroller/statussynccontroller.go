@@ -27,6 +27,7 @@ import (
 	"github.com/megaease/easegress/pkg/util/codectool"
 	"github.com/megaease/easegress/pkg/util/timetool"
 
+	"github.com/megaease/easegress/pkg/object/federationcontroller"
 	"github.com/megaease/easegress/pkg/object/trafficcontroller"
 )
 
@@ -216,6 +217,19 @@ func (ssc *StatusSyncController) handleStatus(unixTimestamp int64) {
 					statusUnits[su.id()] = su
 				}
 			}
+		case *federationcontroller.Status:
+			su := newStatusUnit(namespace, objectName, unixTimestamp, objectStatus)
+			statusUnits[su.id()] = su
+			// The statuses of the objects in secondary clusters are
+			// synced in the namespaces of their regions, so that the
+			// primary has a global view.
+			for _, regionStatus := range objectStatus.Regions {
+				regionNamespace := cluster.FederationNamespace(regionStatus.Name)
+				for name, remoteStatus := range regionStatus.RemoteObjects {
+					su := newStatusUnit(regionNamespace, name, unixTimestamp, remoteStatus)
+					statusUnits[su.id()] = su
+				}
+			}
 		default:
 			su := newStatusUnit(namespace, objectName, unixTimestamp, status.ObjectStatus)
 			statusUnits[su.id()] = su
@@ -0,0 +1,636 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttprot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+const (
+	// Version311 is the protocol level of MQTT 3.1.1.
+	Version311 byte = 4
+	// Version5 is the protocol level of MQTT 5.0.
+	Version5 byte = 5
+
+	// auth is the packet type of MQTT 5 AUTH packet, which is not in MQTT 3.1.1.
+	auth byte = 15
+)
+
+// Reason codes of MQTT 5.
+const (
+	ReasonSuccess                    byte = 0x00
+	ReasonNoSubscriptionExisted      byte = 0x11
+	ReasonUnspecifiedError           byte = 0x80
+	ReasonMalformedPacket            byte = 0x81
+	ReasonProtocolError              byte = 0x82
+	ReasonImplementationSpecific     byte = 0x83
+	ReasonUnsupportedProtocolVersion byte = 0x84
+	ReasonClientIdentifierNotValid   byte = 0x85
+	ReasonBadUserNameOrPassword      byte = 0x86
+	ReasonNotAuthorized              byte = 0x87
+	ReasonServerUnavailable          byte = 0x88
+	ReasonServerShuttingDown         byte = 0x8B
+	ReasonSessionTakenOver           byte = 0x8E
+	ReasonTopicFilterInvalid         byte = 0x8F
+	ReasonTopicNameInvalid           byte = 0x90
+	ReasonReceiveMaximumExceeded     byte = 0x93
+	ReasonTopicAliasInvalid          byte = 0x94
+	ReasonPacketTooLarge             byte = 0x95
+	ReasonQuotaExceeded              byte = 0x97
+)
+
+var errMalformed = errors.New("malformed packet")
+
+type (
+	// Extension is the part of a MQTT 5 packet which doesn't exist in the
+	// corresponding MQTT 3.1.1 packet. It's nil for MQTT 3.1.1 packets.
+	Extension struct {
+		Properties *Properties
+		// WillProperties is the properties of the will message in CONNECT.
+		WillProperties *Properties
+		// ReasonCode is the reason code of CONNACK, PUBACK, PUBREC, PUBREL,
+		// PUBCOMP and DISCONNECT. The return code of CONNACK is converted to
+		// the reason code if it's zero.
+		ReasonCode byte
+		// ReasonCodes is the reason codes of SUBACK and UNSUBACK. The return
+		// codes of SUBACK is used if it's empty.
+		ReasonCodes []byte
+		// SubscriptionOptions is the options of topics in SUBSCRIBE except
+		// the QoS, which is kept in the SubscribePacket.
+		SubscriptionOptions []SubscriptionOptions
+	}
+
+	// SubscriptionOptions is the MQTT 5 options of a subscription.
+	SubscriptionOptions struct {
+		NoLocal           bool `json:"noLocal,omitempty"`
+		RetainAsPublished bool `json:"retainAsPublished,omitempty"`
+		RetainHandling    byte `json:"retainHandling,omitempty"`
+	}
+)
+
+// GetProperties returns the properties of the packet, it returns nil if
+// the extension is nil.
+func (e *Extension) GetProperties() *Properties {
+	if e == nil {
+		return nil
+	}
+	return e.Properties
+}
+
+// ValidateConnect validates the CONNECT packet of both MQTT 3.1.1 and
+// MQTT 5, and returns the return code of CONNACK.
+func ValidateConnect(connect *packets.ConnectPacket) byte {
+	if connect.ProtocolName == "MQTT" && connect.ProtocolVersion == Version5 {
+		c := *connect
+		c.ProtocolVersion = Version311
+		// MQTT 5 allows empty client id with clean start is false, the
+		// server assigns an id to the client.
+		c.CleanSession = true
+		return c.Validate()
+	}
+	return connect.Validate()
+}
+
+// ConnackReasonCode converts the MQTT 3.1.1 return code of CONNACK to the
+// MQTT 5 reason code.
+func ConnackReasonCode(returnCode byte) byte {
+	switch returnCode {
+	case packets.Accepted:
+		return ReasonSuccess
+	case packets.ErrRefusedBadProtocolVersion:
+		return ReasonUnsupportedProtocolVersion
+	case packets.ErrRefusedIDRejected:
+		return ReasonClientIdentifierNotValid
+	case packets.ErrRefusedServerUnavailable:
+		return ReasonServerUnavailable
+	case packets.ErrRefusedBadUsernameOrPassword:
+		return ReasonBadUserNameOrPassword
+	case packets.ErrRefusedNotAuthorised:
+		return ReasonNotAuthorized
+	case packets.ErrProtocolViolation:
+		return ReasonProtocolError
+	default:
+		return ReasonUnspecifiedError
+	}
+}
+
+// ReadPacket reads a packet of the protocol version from r. The version
+// of CONNECT packet is detected from the packet itself, so it's fine to
+// read it with version 0. The extension is nil for MQTT 3.1.1 packets.
+func ReadPacket(r io.Reader, version byte) (packets.ControlPacket, *Extension, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, nil, err
+	}
+	fh := packets.FixedHeader{
+		MessageType: b[0] >> 4,
+		Dup:         (b[0]>>3)&0x01 > 0,
+		Qos:         (b[0] >> 1) & 0x03,
+		Retain:      b[0]&0x01 > 0,
+	}
+
+	length, multiplier := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, nil, errMalformed
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, nil, err
+		}
+		length |= int(b[0]&127) << multiplier
+		if b[0]&128 == 0 {
+			break
+		}
+		multiplier += 7
+	}
+	fh.RemainingLength = length
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	if fh.MessageType == packets.Connect {
+		d := &decoder{buf: body}
+		d.string()
+		version = d.byte()
+	}
+	if version != Version5 {
+		cp, err := packets.NewControlPacketWithHeader(fh)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cp, nil, cp.Unpack(bytes.NewBuffer(body))
+	}
+
+	cp, ext, err := decodePacket(fh, &decoder{buf: body})
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode %s failed: %v", packetName(fh.MessageType), err)
+	}
+	return cp, ext, nil
+}
+
+// WritePacket writes the packet with extension to w in the protocol version,
+// the extension is ignored for MQTT 3.1.1.
+func WritePacket(w io.Writer, cp packets.ControlPacket, ext *Extension, version byte) error {
+	if version != Version5 {
+		return cp.Write(w)
+	}
+	buf, err := EncodePacket(cp, ext)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// EncodePacket encodes the packet with extension in MQTT 5.
+func EncodePacket(cp packets.ControlPacket, ext *Extension) ([]byte, error) {
+	if ext == nil {
+		ext = &Extension{}
+	}
+
+	var body bytes.Buffer
+	var flags byte
+	var msgType byte
+
+	switch p := cp.(type) {
+	case *packets.ConnectPacket:
+		msgType = packets.Connect
+		body.Write(encodeBinary([]byte("MQTT")))
+		body.WriteByte(Version5)
+		var connectFlags byte
+		if p.CleanSession {
+			connectFlags |= 0x02
+		}
+		if p.WillFlag {
+			connectFlags |= 0x04 | p.WillQos<<3
+			if p.WillRetain {
+				connectFlags |= 0x20
+			}
+		}
+		if p.PasswordFlag {
+			connectFlags |= 0x40
+		}
+		if p.UsernameFlag {
+			connectFlags |= 0x80
+		}
+		body.WriteByte(connectFlags)
+		body.Write(encodeUint16(p.Keepalive))
+		body.Write(ext.Properties.encode())
+		body.Write(encodeBinary([]byte(p.ClientIdentifier)))
+		if p.WillFlag {
+			body.Write(ext.WillProperties.encode())
+			body.Write(encodeBinary([]byte(p.WillTopic)))
+			body.Write(encodeBinary(p.WillMessage))
+		}
+		if p.UsernameFlag {
+			body.Write(encodeBinary([]byte(p.Username)))
+		}
+		if p.PasswordFlag {
+			body.Write(encodeBinary(p.Password))
+		}
+
+	case *packets.ConnackPacket:
+		msgType = packets.Connack
+		if p.SessionPresent {
+			body.WriteByte(0x01)
+		} else {
+			body.WriteByte(0x00)
+		}
+		reasonCode := ext.ReasonCode
+		if reasonCode == ReasonSuccess {
+			reasonCode = ConnackReasonCode(p.ReturnCode)
+		}
+		body.WriteByte(reasonCode)
+		body.Write(ext.Properties.encode())
+
+	case *packets.PublishPacket:
+		msgType = packets.Publish
+		flags = p.Qos << 1
+		if p.Dup {
+			flags |= 0x08
+		}
+		if p.Retain {
+			flags |= 0x01
+		}
+		body.Write(encodeBinary([]byte(p.TopicName)))
+		if p.Qos > 0 {
+			body.Write(encodeUint16(p.MessageID))
+		}
+		body.Write(ext.Properties.encode())
+		body.Write(p.Payload)
+
+	case *packets.PubackPacket:
+		msgType = packets.Puback
+		encodeAck(&body, p.MessageID, ext)
+	case *packets.PubrecPacket:
+		msgType = packets.Pubrec
+		encodeAck(&body, p.MessageID, ext)
+	case *packets.PubrelPacket:
+		msgType, flags = packets.Pubrel, 0x02
+		encodeAck(&body, p.MessageID, ext)
+	case *packets.PubcompPacket:
+		msgType = packets.Pubcomp
+		encodeAck(&body, p.MessageID, ext)
+
+	case *packets.SubscribePacket:
+		msgType, flags = packets.Subscribe, 0x02
+		body.Write(encodeUint16(p.MessageID))
+		body.Write(ext.Properties.encode())
+		for i, topic := range p.Topics {
+			body.Write(encodeBinary([]byte(topic)))
+			options := p.Qoss[i]
+			if i < len(ext.SubscriptionOptions) {
+				o := ext.SubscriptionOptions[i]
+				if o.NoLocal {
+					options |= 0x04
+				}
+				if o.RetainAsPublished {
+					options |= 0x08
+				}
+				options |= o.RetainHandling << 4
+			}
+			body.WriteByte(options)
+		}
+
+	case *packets.SubackPacket:
+		msgType = packets.Suback
+		body.Write(encodeUint16(p.MessageID))
+		body.Write(ext.Properties.encode())
+		if len(ext.ReasonCodes) > 0 {
+			body.Write(ext.ReasonCodes)
+		} else {
+			body.Write(p.ReturnCodes)
+		}
+
+	case *packets.UnsubscribePacket:
+		msgType, flags = packets.Unsubscribe, 0x02
+		body.Write(encodeUint16(p.MessageID))
+		body.Write(ext.Properties.encode())
+		for _, topic := range p.Topics {
+			body.Write(encodeBinary([]byte(topic)))
+		}
+
+	case *packets.UnsubackPacket:
+		msgType = packets.Unsuback
+		body.Write(encodeUint16(p.MessageID))
+		body.Write(ext.Properties.encode())
+		body.Write(ext.ReasonCodes)
+
+	case *packets.PingreqPacket:
+		msgType = packets.Pingreq
+	case *packets.PingrespPacket:
+		msgType = packets.Pingresp
+
+	case *packets.DisconnectPacket:
+		msgType = packets.Disconnect
+		if ext.ReasonCode != ReasonSuccess || ext.Properties != nil {
+			body.WriteByte(ext.ReasonCode)
+			body.Write(ext.Properties.encode())
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported packet %T", cp)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(msgType<<4 | flags)
+	buf.Write(encodeVarint(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func encodeAck(body *bytes.Buffer, id uint16, ext *Extension) {
+	body.Write(encodeUint16(id))
+	if ext.ReasonCode == ReasonSuccess && ext.Properties == nil {
+		return
+	}
+	body.WriteByte(ext.ReasonCode)
+	body.Write(ext.Properties.encode())
+}
+
+func decodePacket(fh packets.FixedHeader, d *decoder) (packets.ControlPacket, *Extension, error) {
+	ext := &Extension{}
+
+	// optionalReason decodes the optional reason code and properties of
+	// acknowledgements and DISCONNECT.
+	optionalReason := func() {
+		if len(d.buf) > 0 {
+			ext.ReasonCode = d.byte()
+		}
+		if len(d.buf) > 0 {
+			ext.Properties = decodeProperties(d)
+		}
+	}
+
+	var cp packets.ControlPacket
+	switch fh.MessageType {
+	case packets.Connect:
+		p := &packets.ConnectPacket{FixedHeader: fh}
+		p.ProtocolName = d.string()
+		p.ProtocolVersion = d.byte()
+		connectFlags := d.byte()
+		p.ReservedBit = connectFlags & 0x01
+		p.CleanSession = connectFlags&0x02 > 0
+		p.WillFlag = connectFlags&0x04 > 0
+		p.WillQos = (connectFlags >> 3) & 0x03
+		p.WillRetain = connectFlags&0x20 > 0
+		p.PasswordFlag = connectFlags&0x40 > 0
+		p.UsernameFlag = connectFlags&0x80 > 0
+		p.Keepalive = d.uint16()
+		ext.Properties = decodeProperties(d)
+		p.ClientIdentifier = d.string()
+		if p.WillFlag {
+			ext.WillProperties = decodeProperties(d)
+			p.WillTopic = d.string()
+			p.WillMessage = d.binary()
+		}
+		if p.UsernameFlag {
+			p.Username = d.string()
+		}
+		if p.PasswordFlag {
+			p.Password = d.binary()
+		}
+		cp = p
+
+	case packets.Connack:
+		p := &packets.ConnackPacket{FixedHeader: fh}
+		p.SessionPresent = d.byte()&0x01 > 0
+		ext.ReasonCode = d.byte()
+		p.ReturnCode = ext.ReasonCode
+		ext.Properties = decodeProperties(d)
+		cp = p
+
+	case packets.Publish:
+		p := &packets.PublishPacket{FixedHeader: fh}
+		p.TopicName = d.string()
+		if fh.Qos > 0 {
+			p.MessageID = d.uint16()
+		}
+		ext.Properties = decodeProperties(d)
+		p.Payload = d.rest()
+		cp = p
+
+	case packets.Puback:
+		p := &packets.PubackPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		optionalReason()
+		cp = p
+	case packets.Pubrec:
+		p := &packets.PubrecPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		optionalReason()
+		cp = p
+	case packets.Pubrel:
+		p := &packets.PubrelPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		optionalReason()
+		cp = p
+	case packets.Pubcomp:
+		p := &packets.PubcompPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		optionalReason()
+		cp = p
+
+	case packets.Subscribe:
+		p := &packets.SubscribePacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		ext.Properties = decodeProperties(d)
+		for len(d.buf) > 0 && d.err == nil {
+			p.Topics = append(p.Topics, d.string())
+			options := d.byte()
+			p.Qoss = append(p.Qoss, options&0x03)
+			ext.SubscriptionOptions = append(ext.SubscriptionOptions, SubscriptionOptions{
+				NoLocal:           options&0x04 > 0,
+				RetainAsPublished: options&0x08 > 0,
+				RetainHandling:    (options >> 4) & 0x03,
+			})
+		}
+		cp = p
+
+	case packets.Suback:
+		p := &packets.SubackPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		ext.Properties = decodeProperties(d)
+		p.ReturnCodes = d.rest()
+		ext.ReasonCodes = p.ReturnCodes
+		cp = p
+
+	case packets.Unsubscribe:
+		p := &packets.UnsubscribePacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		ext.Properties = decodeProperties(d)
+		for len(d.buf) > 0 && d.err == nil {
+			p.Topics = append(p.Topics, d.string())
+		}
+		cp = p
+
+	case packets.Unsuback:
+		p := &packets.UnsubackPacket{FixedHeader: fh}
+		p.MessageID = d.uint16()
+		ext.Properties = decodeProperties(d)
+		ext.ReasonCodes = d.rest()
+		cp = p
+
+	case packets.Pingreq:
+		cp = &packets.PingreqPacket{FixedHeader: fh}
+	case packets.Pingresp:
+		cp = &packets.PingrespPacket{FixedHeader: fh}
+
+	case packets.Disconnect:
+		p := &packets.DisconnectPacket{FixedHeader: fh}
+		optionalReason()
+		cp = p
+
+	case auth:
+		return nil, nil, fmt.Errorf("enhanced authentication is not supported")
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported packet type 0x%x", fh.MessageType)
+	}
+
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return cp, ext, nil
+}
+
+func packetName(msgType byte) string {
+	if msgType == auth {
+		return "AUTH"
+	}
+	if name, ok := packets.PacketNames[msgType]; ok {
+		return name
+	}
+	return fmt.Sprintf("packet type 0x%x", msgType)
+}
+
+// decoder decodes the fields of a packet, the first error is kept and
+// all following decoding returns zero value.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.buf) {
+		d.err = errMalformed
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) rest() []byte {
+	return d.next(len(d.buf))
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) varint() uint32 {
+	var value uint32
+	for i := 0; i < 4; i++ {
+		b := d.next(1)
+		if b == nil {
+			return 0
+		}
+		value |= uint32(b[0]&127) << (7 * i)
+		if b[0]&128 == 0 {
+			return value
+		}
+	}
+	d.err = errMalformed
+	return 0
+}
+
+func (d *decoder) binary() []byte {
+	n := d.uint16()
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func encodeUint16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func encodeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func encodeBinary(v []byte) []byte {
+	return append(encodeUint16(uint16(len(v))), v...)
+}
+
+func encodeVarint(v int) []byte {
+	var b []byte
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if v == 0 {
+			return b
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttprot
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+)
+
+func roundTrip(t *testing.T, cp packets.ControlPacket, ext *Extension, version byte) (packets.ControlPacket, *Extension) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WritePacket(buf, cp, ext, version))
+	got, gotExt, err := ReadPacket(buf, version)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, buf.Len())
+	return got, gotExt
+}
+
+func TestConnect(t *testing.T) {
+	assert := assert.New(t)
+
+	expiry, receiveMaximum := uint32(3600), uint16(10)
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = "client"
+	connect.CleanSession = true
+	connect.Keepalive = 30
+	connect.WillFlag = true
+	connect.WillQos = 1
+	connect.WillRetain = true
+	connect.WillTopic = "will"
+	connect.WillMessage = []byte("gone")
+	connect.UsernameFlag = true
+	connect.Username = "user"
+	connect.PasswordFlag = true
+	connect.Password = []byte("pass")
+	ext := &Extension{
+		Properties: &Properties{
+			SessionExpiryInterval: &expiry,
+			ReceiveMaximum:        &receiveMaximum,
+			UserProperties:        []UserProperty{{Key: "k", Value: "v"}},
+		},
+		WillProperties: &Properties{ContentType: "text/plain"},
+	}
+
+	cp, gotExt := roundTrip(t, connect, ext, Version5)
+	got := cp.(*packets.ConnectPacket)
+	assert.Equal(Version5, got.ProtocolVersion)
+	assert.Equal("MQTT", got.ProtocolName)
+	assert.Equal(connect.ClientIdentifier, got.ClientIdentifier)
+	assert.True(got.CleanSession)
+	assert.Equal(connect.Keepalive, got.Keepalive)
+	assert.Equal(connect.WillQos, got.WillQos)
+	assert.True(got.WillRetain)
+	assert.Equal(connect.WillTopic, got.WillTopic)
+	assert.Equal(connect.WillMessage, got.WillMessage)
+	assert.Equal(connect.Username, got.Username)
+	assert.Equal(connect.Password, got.Password)
+	assert.Equal(ext, gotExt)
+	assert.Equal(byte(packets.Accepted), ValidateConnect(got))
+
+	// empty client id is valid for MQTT 5
+	got.ClientIdentifier = ""
+	got.CleanSession = false
+	assert.Equal(byte(packets.Accepted), ValidateConnect(got))
+
+	// version of CONNECT is detected from the packet
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = Version311
+	buf := &bytes.Buffer{}
+	assert.Nil(connect.Write(buf))
+	cp, gotExt, err := ReadPacket(buf, Version5)
+	assert.Nil(err)
+	assert.Nil(gotExt)
+	assert.Equal(Version311, cp.(*packets.ConnectPacket).ProtocolVersion)
+	assert.Equal("user", cp.(*packets.ConnectPacket).Username)
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	expiry, alias := uint32(60), uint16(3)
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "a/b"
+	publish.Qos = 2
+	publish.Retain = true
+	publish.Dup = true
+	publish.MessageID = 7
+	publish.Payload = []byte("payload")
+	ext := &Extension{
+		Properties: &Properties{
+			MessageExpiryInterval: &expiry,
+			TopicAlias:            &alias,
+			ResponseTopic:         "reply",
+			CorrelationData:       []byte{1, 2, 3},
+			UserProperties:        []UserProperty{{Key: "a", Value: "1"}, {Key: "a", Value: "2"}},
+		},
+	}
+
+	cp, gotExt := roundTrip(t, publish, ext, Version5)
+	got := cp.(*packets.PublishPacket)
+	assert.Equal(publish.TopicName, got.TopicName)
+	assert.Equal(publish.Qos, got.Qos)
+	assert.True(got.Retain)
+	assert.True(got.Dup)
+	assert.Equal(publish.MessageID, got.MessageID)
+	assert.Equal(publish.Payload, got.Payload)
+	assert.Equal(ext, gotExt)
+	v, ok := gotExt.Properties.GetUserProperty("a")
+	assert.True(ok)
+	assert.Equal("1", v)
+
+	// properties are not written for MQTT 3.1.1
+	cp, gotExt = roundTrip(t, publish, ext, Version311)
+	assert.Nil(gotExt)
+	assert.Equal(publish.Payload, cp.(*packets.PublishPacket).Payload)
+}
+
+func TestAcknowledgements(t *testing.T) {
+	assert := assert.New(t)
+
+	puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	puback.MessageID = 1
+	cp, ext := roundTrip(t, puback, nil, Version5)
+	assert.Equal(uint16(1), cp.(*packets.PubackPacket).MessageID)
+	assert.Equal(&Extension{}, ext)
+
+	pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	pubrec.MessageID = 2
+	reason := &Extension{ReasonCode: ReasonQuotaExceeded, Properties: &Properties{ReasonString: "quota"}}
+	cp, ext = roundTrip(t, pubrec, reason, Version5)
+	assert.Equal(uint16(2), cp.(*packets.PubrecPacket).MessageID)
+	assert.Equal(reason, ext)
+
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = packets.ErrRefusedNotAuthorised
+	cp, ext = roundTrip(t, connack, nil, Version5)
+	assert.Equal(ReasonNotAuthorized, cp.(*packets.ConnackPacket).ReturnCode)
+	assert.Equal(ReasonNotAuthorized, ext.ReasonCode)
+
+	unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+	unsuback.MessageID = 3
+	reasons := &Extension{Properties: &Properties{}, ReasonCodes: []byte{ReasonSuccess, ReasonNoSubscriptionExisted}}
+	cp, ext = roundTrip(t, unsuback, reasons, Version5)
+	assert.Equal(uint16(3), cp.(*packets.UnsubackPacket).MessageID)
+	assert.Equal(reasons, ext)
+
+	disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	cp, ext = roundTrip(t, disconnect, nil, Version5)
+	assert.IsType(&packets.DisconnectPacket{}, cp)
+	assert.Equal(&Extension{}, ext)
+}
+
+func TestSubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 5
+	subscribe.Topics = []string{"a/+", "b/#"}
+	subscribe.Qoss = []byte{1, 2}
+	ext := &Extension{
+		Properties: &Properties{},
+		SubscriptionOptions: []SubscriptionOptions{
+			{NoLocal: true},
+			{RetainAsPublished: true, RetainHandling: 2},
+		},
+	}
+	cp, gotExt := roundTrip(t, subscribe, ext, Version5)
+	got := cp.(*packets.SubscribePacket)
+	assert.Equal(subscribe.MessageID, got.MessageID)
+	assert.Equal(subscribe.Topics, got.Topics)
+	assert.Equal(subscribe.Qoss, got.Qoss)
+	assert.Equal(ext, gotExt)
+}
+
+func TestMalformedPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	// PUBLISH with truncated properties
+	_, _, err := ReadPacket(bytes.NewReader([]byte{0x30, 0x05, 0x00, 0x01, 'a', 0x05, 0x01}), Version5)
+	assert.NotNil(err)
+
+	// unknown property
+	_, _, err = ReadPacket(bytes.NewReader([]byte{0x30, 0x05, 0x00, 0x01, 'a', 0x01, 0x7f}), Version5)
+	assert.NotNil(err)
+
+	// AUTH is not supported
+	_, _, err = ReadPacket(bytes.NewReader([]byte{0xf0, 0x00}), Version5)
+	assert.NotNil(err)
+}
@@ -17,16 +17,20 @@
 
 package mqttprot
 
-import "sync"
+import (
+	"crypto/x509"
+	"sync"
+)
 
 // MockClient is mock client for MQTT protocol
 type MockClient struct {
 	MockClientID string
 	MockUserName string
 	MockKVMap    sync.Map
+	MockCerts    []*x509.Certificate
 }
 
-var _ Client = (*MockClient)(nil)
+var _ TLSClient = (*MockClient)(nil)
 
 // ClientID return client id of MockClient
 func (m *MockClient) ClientID() string {
@@ -52,3 +56,8 @@ func (m *MockClient) Store(key interface{}, value interface{}) {
 func (m *MockClient) Delete(key interface{}) {
 	m.MockKVMap.Delete(key)
 }
+
+// PeerCertificates return certificates of MockClient
+func (m *MockClient) PeerCertificates() []*x509.Certificate {
+	return m.MockCerts
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mqttprot
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Identifiers of MQTT 5 properties.
+const (
+	propPayloadFormat                   byte = 0x01
+	propMessageExpiryInterval           byte = 0x02
+	propContentType                     byte = 0x03
+	propResponseTopic                   byte = 0x08
+	propCorrelationData                 byte = 0x09
+	propSubscriptionIdentifier          byte = 0x0B
+	propSessionExpiryInterval           byte = 0x11
+	propAssignedClientIdentifier        byte = 0x12
+	propServerKeepAlive                 byte = 0x13
+	propAuthenticationMethod            byte = 0x15
+	propAuthenticationData              byte = 0x16
+	propRequestProblemInformation       byte = 0x17
+	propWillDelayInterval               byte = 0x18
+	propRequestResponseInformation      byte = 0x19
+	propResponseInformation             byte = 0x1A
+	propServerReference                 byte = 0x1C
+	propReasonString                    byte = 0x1F
+	propReceiveMaximum                  byte = 0x21
+	propTopicAliasMaximum               byte = 0x22
+	propTopicAlias                      byte = 0x23
+	propMaximumQoS                      byte = 0x24
+	propRetainAvailable                 byte = 0x25
+	propUserProperty                    byte = 0x26
+	propMaximumPacketSize               byte = 0x27
+	propWildcardSubscriptionAvailable   byte = 0x28
+	propSubscriptionIdentifierAvailable byte = 0x29
+	propSharedSubscriptionAvailable     byte = 0x2A
+)
+
+type (
+	// Properties is the properties of MQTT 5 packets, a nil field means
+	// the property is absent.
+	Properties struct {
+		PayloadFormat                   *byte          `json:"payloadFormat,omitempty"`
+		MessageExpiryInterval           *uint32        `json:"messageExpiryInterval,omitempty"`
+		ContentType                     string         `json:"contentType,omitempty"`
+		ResponseTopic                   string         `json:"responseTopic,omitempty"`
+		CorrelationData                 []byte         `json:"correlationData,omitempty"`
+		SubscriptionIdentifiers         []uint32       `json:"subscriptionIdentifiers,omitempty"`
+		SessionExpiryInterval           *uint32        `json:"sessionExpiryInterval,omitempty"`
+		AssignedClientIdentifier        string         `json:"assignedClientIdentifier,omitempty"`
+		ServerKeepAlive                 *uint16        `json:"serverKeepAlive,omitempty"`
+		AuthenticationMethod            string         `json:"authenticationMethod,omitempty"`
+		AuthenticationData              []byte         `json:"authenticationData,omitempty"`
+		RequestProblemInformation       *byte          `json:"requestProblemInformation,omitempty"`
+		WillDelayInterval               *uint32        `json:"willDelayInterval,omitempty"`
+		RequestResponseInformation      *byte          `json:"requestResponseInformation,omitempty"`
+		ResponseInformation             string         `json:"responseInformation,omitempty"`
+		ServerReference                 string         `json:"serverReference,omitempty"`
+		ReasonString                    string         `json:"reasonString,omitempty"`
+		ReceiveMaximum                  *uint16        `json:"receiveMaximum,omitempty"`
+		TopicAliasMaximum               *uint16        `json:"topicAliasMaximum,omitempty"`
+		TopicAlias                      *uint16        `json:"topicAlias,omitempty"`
+		MaximumQoS                      *byte          `json:"maximumQoS,omitempty"`
+		RetainAvailable                 *byte          `json:"retainAvailable,omitempty"`
+		UserProperties                  []UserProperty `json:"userProperties,omitempty"`
+		MaximumPacketSize               *uint32        `json:"maximumPacketSize,omitempty"`
+		WildcardSubscriptionAvailable   *byte          `json:"wildcardSubscriptionAvailable,omitempty"`
+		SubscriptionIdentifierAvailable *byte          `json:"subscriptionIdentifierAvailable,omitempty"`
+		SharedSubscriptionAvailable     *byte          `json:"sharedSubscriptionAvailable,omitempty"`
+	}
+
+	// UserProperty is a name-value pair of MQTT 5 user property, the same
+	// name is allowed to appear more than once.
+	UserProperty struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+)
+
+// GetUserProperty returns the value of the first user property with the key.
+func (p *Properties) GetUserProperty(key string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, up := range p.UserProperties {
+		if up.Key == key {
+			return up.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetUserProperties returns all user properties, it returns nil if p is nil.
+func (p *Properties) GetUserProperties() []UserProperty {
+	if p == nil {
+		return nil
+	}
+	return p.UserProperties
+}
+
+// AddUserProperty appends a user property.
+func (p *Properties) AddUserProperty(key, value string) {
+	p.UserProperties = append(p.UserProperties, UserProperty{Key: key, Value: value})
+}
+
+// Clone returns a deep copy of the properties.
+func (p *Properties) Clone() *Properties {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.CorrelationData = append([]byte(nil), p.CorrelationData...)
+	c.AuthenticationData = append([]byte(nil), p.AuthenticationData...)
+	c.SubscriptionIdentifiers = append([]uint32(nil), p.SubscriptionIdentifiers...)
+	c.UserProperties = append([]UserProperty(nil), p.UserProperties...)
+	return &c
+}
+
+func (p *Properties) encode() []byte {
+	var buf bytes.Buffer
+	if p == nil {
+		buf.Write(encodeVarint(0))
+		return buf.Bytes()
+	}
+
+	var b bytes.Buffer
+	putByte := func(id byte, v *byte) {
+		if v != nil {
+			b.WriteByte(id)
+			b.WriteByte(*v)
+		}
+	}
+	putUint16 := func(id byte, v *uint16) {
+		if v != nil {
+			b.WriteByte(id)
+			b.Write(encodeUint16(*v))
+		}
+	}
+	putUint32 := func(id byte, v *uint32) {
+		if v != nil {
+			b.WriteByte(id)
+			b.Write(encodeUint32(*v))
+		}
+	}
+	putString := func(id byte, v string) {
+		if v != "" {
+			b.WriteByte(id)
+			b.Write(encodeBinary([]byte(v)))
+		}
+	}
+	putBinary := func(id byte, v []byte) {
+		if len(v) != 0 {
+			b.WriteByte(id)
+			b.Write(encodeBinary(v))
+		}
+	}
+
+	putByte(propPayloadFormat, p.PayloadFormat)
+	putUint32(propMessageExpiryInterval, p.MessageExpiryInterval)
+	putString(propContentType, p.ContentType)
+	putString(propResponseTopic, p.ResponseTopic)
+	putBinary(propCorrelationData, p.CorrelationData)
+	for _, id := range p.SubscriptionIdentifiers {
+		b.WriteByte(propSubscriptionIdentifier)
+		b.Write(encodeVarint(int(id)))
+	}
+	putUint32(propSessionExpiryInterval, p.SessionExpiryInterval)
+	putString(propAssignedClientIdentifier, p.AssignedClientIdentifier)
+	putUint16(propServerKeepAlive, p.ServerKeepAlive)
+	putString(propAuthenticationMethod, p.AuthenticationMethod)
+	putBinary(propAuthenticationData, p.AuthenticationData)
+	putByte(propRequestProblemInformation, p.RequestProblemInformation)
+	putUint32(propWillDelayInterval, p.WillDelayInterval)
+	putByte(propRequestResponseInformation, p.RequestResponseInformation)
+	putString(propResponseInformation, p.ResponseInformation)
+	putString(propServerReference, p.ServerReference)
+	putString(propReasonString, p.ReasonString)
+	putUint16(propReceiveMaximum, p.ReceiveMaximum)
+	putUint16(propTopicAliasMaximum, p.TopicAliasMaximum)
+	putUint16(propTopicAlias, p.TopicAlias)
+	putByte(propMaximumQoS, p.MaximumQoS)
+	putByte(propRetainAvailable, p.RetainAvailable)
+	for _, up := range p.UserProperties {
+		b.WriteByte(propUserProperty)
+		b.Write(encodeBinary([]byte(up.Key)))
+		b.Write(encodeBinary([]byte(up.Value)))
+	}
+	putUint32(propMaximumPacketSize, p.MaximumPacketSize)
+	putByte(propWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable)
+	putByte(propSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable)
+	putByte(propSharedSubscriptionAvailable, p.SharedSubscriptionAvailable)
+
+	buf.Write(encodeVarint(b.Len()))
+	buf.Write(b.Bytes())
+	return buf.Bytes()
+}
+
+func decodeProperties(d *decoder) *Properties {
+	length := d.varint()
+	if d.err != nil {
+		return nil
+	}
+	pd := &decoder{buf: d.next(int(length))}
+	if d.err != nil {
+		return nil
+	}
+
+	p := &Properties{}
+	getByte := func() *byte { v := pd.byte(); return &v }
+	getUint16 := func() *uint16 { v := pd.uint16(); return &v }
+	getUint32 := func() *uint32 { v := pd.uint32(); return &v }
+	for len(pd.buf) > 0 && pd.err == nil {
+		switch id := pd.byte(); id {
+		case propPayloadFormat:
+			p.PayloadFormat = getByte()
+		case propMessageExpiryInterval:
+			p.MessageExpiryInterval = getUint32()
+		case propContentType:
+			p.ContentType = pd.string()
+		case propResponseTopic:
+			p.ResponseTopic = pd.string()
+		case propCorrelationData:
+			p.CorrelationData = pd.binary()
+		case propSubscriptionIdentifier:
+			p.SubscriptionIdentifiers = append(p.SubscriptionIdentifiers, pd.varint())
+		case propSessionExpiryInterval:
+			p.SessionExpiryInterval = getUint32()
+		case propAssignedClientIdentifier:
+			p.AssignedClientIdentifier = pd.string()
+		case propServerKeepAlive:
+			p.ServerKeepAlive = getUint16()
+		case propAuthenticationMethod:
+			p.AuthenticationMethod = pd.string()
+		case propAuthenticationData:
+			p.AuthenticationData = pd.binary()
+		case propRequestProblemInformation:
+			p.RequestProblemInformation = getByte()
+		case propWillDelayInterval:
+			p.WillDelayInterval = getUint32()
+		case propRequestResponseInformation:
+			p.RequestResponseInformation = getByte()
+		case propResponseInformation:
+			p.ResponseInformation = pd.string()
+		case propServerReference:
+			p.ServerReference = pd.string()
+		case propReasonString:
+			p.ReasonString = pd.string()
+		case propReceiveMaximum:
+			p.ReceiveMaximum = getUint16()
+		case propTopicAliasMaximum:
+			p.TopicAliasMaximum = getUint16()
+		case propTopicAlias:
+			p.TopicAlias = getUint16()
+		case propMaximumQoS:
+			p.MaximumQoS = getByte()
+		case propRetainAvailable:
+			p.RetainAvailable = getByte()
+		case propUserProperty:
+			key := pd.string()
+			p.AddUserProperty(key, pd.string())
+		case propMaximumPacketSize:
+			p.MaximumPacketSize = getUint32()
+		case propWildcardSubscriptionAvailable:
+			p.WildcardSubscriptionAvailable = getByte()
+		case propSubscriptionIdentifierAvailable:
+			p.SubscriptionIdentifierAvailable = getByte()
+		case propSharedSubscriptionAvailable:
+			p.SharedSubscriptionAvailable = getByte()
+		default:
+			pd.err = fmt.Errorf("unknown property 0x%x", id)
+		}
+	}
+	if pd.err != nil {
+		d.err = pd.err
+		return nil
+	}
+	return p
+}
@@ -19,6 +19,7 @@ package mqttprot
 
 import (
 	"bytes"
+	"crypto/x509"
 	"io"
 
 	"github.com/megaease/easegress/pkg/protocols"
@@ -33,6 +34,8 @@ type (
 		packet     packets.ControlPacket
 		packetType PacketType
 		payload    []byte
+		version    byte
+		ext        *Extension
 	}
 
 	// Client contains MQTT client info that send this packet
@@ -44,6 +47,13 @@ type (
 		Delete(key interface{})
 	}
 
+	// TLSClient is a Client connected with TLS, it returns the verified
+	// certificates presented by the client.
+	TLSClient interface {
+		Client
+		PeerCertificates() []*x509.Certificate
+	}
+
 	// PacketType contains supported MQTT packet type
 	PacketType int
 )
@@ -72,9 +82,17 @@ var _ protocols.Request = (*Request)(nil)
 
 // NewRequest create new MQTT Request
 func NewRequest(packet packets.ControlPacket, client Client) *Request {
+	return NewRequestWithExtension(packet, nil, Version311, client)
+}
+
+// NewRequestWithExtension create new MQTT Request of the protocol version,
+// ext is the MQTT 5 extension of the packet and is nil for MQTT 3.1.1.
+func NewRequestWithExtension(packet packets.ControlPacket, ext *Extension, version byte, client Client) *Request {
 	req := &Request{
-		client: client,
-		packet: packet,
+		client:  client,
+		packet:  packet,
+		version: version,
+		ext:     ext,
 	}
 	switch p := packet.(type) {
 	case *packets.ConnectPacket:
@@ -134,6 +152,42 @@ func (r *Request) UnsubscribePacket() *packets.UnsubscribePacket {
 	return r.packet.(*packets.UnsubscribePacket)
 }
 
+// ProtocolVersion return the MQTT protocol version of the request,
+// Version311 or Version5.
+func (r *Request) ProtocolVersion() byte {
+	return r.version
+}
+
+// Extension return the MQTT 5 extension of the packet, it is nil for
+// MQTT 3.1.1 requests.
+func (r *Request) Extension() *Extension {
+	return r.ext
+}
+
+// Properties return the MQTT 5 properties of the packet, it is nil for
+// MQTT 3.1.1 requests or packets without properties.
+func (r *Request) Properties() *Properties {
+	return r.ext.GetProperties()
+}
+
+// WillProperties return the MQTT 5 will properties of connect packet.
+func (r *Request) WillProperties() *Properties {
+	if r.ext == nil {
+		return nil
+	}
+	return r.ext.WillProperties
+}
+
+// SetProperties set the MQTT 5 properties of the packet. The properties
+// are kept for MQTT 3.1.1 requests too, but they are not sent to clients
+// of MQTT 3.1.1.
+func (r *Request) SetProperties(props *Properties) {
+	if r.ext == nil {
+		r.ext = &Extension{}
+	}
+	r.ext.Properties = props
+}
+
 // Header return MQTT request header
 func (r *Request) Header() protocols.Header {
 	// TODO: what header to return?
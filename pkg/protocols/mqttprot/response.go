@@ -27,9 +27,10 @@ import (
 type (
 	// Response contains MQTT response.
 	Response struct {
-		drop       bool
-		disconnect bool
-		payload    []byte
+		drop         bool
+		disconnect   bool
+		payload      []byte
+		deniedTopics map[int]struct{}
 	}
 )
 
@@ -72,6 +73,23 @@ func (r *Response) Disconnect() bool {
 	return r.disconnect
 }
 
+// DenyTopic denies the topic of index i in the subscribe packet in context.
+// MQTTProxy does not subscribe the denied topics, and returns failure codes
+// for them in SUBACK.
+func (r *Response) DenyTopic(i int) {
+	if r.deniedTopics == nil {
+		r.deniedTopics = make(map[int]struct{})
+	}
+	r.deniedTopics[i] = struct{}{}
+}
+
+// TopicDenied returns true if the topic of index i in the subscribe packet
+// in context is denied.
+func (r *Response) TopicDenied(i int) bool {
+	_, ok := r.deniedTopics[i]
+	return ok
+}
+
 // Header return MQTT response header
 func (r *Response) Header() protocols.Header {
 	// TODO: what header to return?
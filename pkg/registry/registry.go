@@ -20,45 +20,31 @@ package registry
 
 import (
 	// Filters
-	_ "github.com/megaease/easegress/pkg/filters/builder"
-	_ "github.com/megaease/easegress/pkg/filters/certextractor"
-	_ "github.com/megaease/easegress/pkg/filters/connectcontrol"
-	_ "github.com/megaease/easegress/pkg/filters/corsadaptor"
-	_ "github.com/megaease/easegress/pkg/filters/fallback"
-	_ "github.com/megaease/easegress/pkg/filters/headerlookup"
-	_ "github.com/megaease/easegress/pkg/filters/headertojson"
-	_ "github.com/megaease/easegress/pkg/filters/kafka"
-	_ "github.com/megaease/easegress/pkg/filters/kafkabackend"
-	_ "github.com/megaease/easegress/pkg/filters/meshadaptor"
-	_ "github.com/megaease/easegress/pkg/filters/mock"
-	_ "github.com/megaease/easegress/pkg/filters/mqttclientauth"
-	_ "github.com/megaease/easegress/pkg/filters/oidcadaptor"
-	_ "github.com/megaease/easegress/pkg/filters/opafilter"
-	_ "github.com/megaease/easegress/pkg/filters/proxies/grpcproxy"
-	_ "github.com/megaease/easegress/pkg/filters/proxies/httpproxy"
-	_ "github.com/megaease/easegress/pkg/filters/ratelimiter"
-	_ "github.com/megaease/easegress/pkg/filters/redirector"
-	_ "github.com/megaease/easegress/pkg/filters/remotefilter"
-	_ "github.com/megaease/easegress/pkg/filters/topicmapper"
-	_ "github.com/megaease/easegress/pkg/filters/validator"
-	_ "github.com/megaease/easegress/pkg/filters/wasmhost"
+	_ "github.com/megaease/easegress/pkg/registry/filters"
 
 	// Objects
 	_ "github.com/megaease/easegress/pkg/object/autocertmanager"
 	_ "github.com/megaease/easegress/pkg/object/consulserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/dnsserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/easemonitormetrics"
 	_ "github.com/megaease/easegress/pkg/object/etcdserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/eurekaserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/federationcontroller"
 	_ "github.com/megaease/easegress/pkg/object/function"
+	_ "github.com/megaease/easegress/pkg/object/gatewaycontroller"
 	_ "github.com/megaease/easegress/pkg/object/globalfilter"
 	_ "github.com/megaease/easegress/pkg/object/grpcserver"
 	_ "github.com/megaease/easegress/pkg/object/httpserver"
 	_ "github.com/megaease/easegress/pkg/object/ingresscontroller"
+	_ "github.com/megaease/easegress/pkg/object/kafkawebhook"
+	_ "github.com/megaease/easegress/pkg/object/kubernetesserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/meshcontroller"
 	_ "github.com/megaease/easegress/pkg/object/mqttproxy"
 	_ "github.com/megaease/easegress/pkg/object/nacosserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/pipeline"
 	_ "github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/schedulecontroller"
+	_ "github.com/megaease/easegress/pkg/object/staticserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/trafficcontroller"
 	_ "github.com/megaease/easegress/pkg/object/zookeeperserviceregistry"
 
@@ -44,6 +44,8 @@ type (
 		Name    string `json:"name" jsonschema:"required,format=urlname"`
 		Kind    string `json:"kind" jsonschema:"required"`
 		Version string `json:"version" jsonschema:"required"`
+		// Labels are used to select objects, e.g. by the FederationController.
+		Labels map[string]string `json:"labels,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -142,6 +144,9 @@ func (s *Spec) Kind() string { return s.meta.Kind }
 // Version returns version.
 func (s *Spec) Version() string { return s.meta.Version }
 
+// Labels returns labels.
+func (s *Spec) Labels() map[string]string { return s.meta.Labels }
+
 // JSONConfig returns the config in json format.
 func (s *Spec) JSONConfig() string {
 	return s.jsonConfig
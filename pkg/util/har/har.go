@@ -0,0 +1,361 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package har implements the HTTP Archive (HAR) 1.2 format and the
+// comparison of HTTP responses recorded in it.
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/megaease/easegress/pkg/util/codectool"
+)
+
+const (
+	// Version is the version of the HAR format.
+	Version = "1.2"
+
+	// EncodingBase64 is the encoding of binary bodies.
+	EncodingBase64 = "base64"
+)
+
+type (
+	// HAR is the root of an HTTP Archive.
+	HAR struct {
+		Log *Log `json:"log"`
+	}
+
+	// Log is the log of an HTTP Archive.
+	Log struct {
+		Version string   `json:"version"`
+		Creator *Creator `json:"creator"`
+		Entries []*Entry `json:"entries"`
+		Comment string   `json:"comment,omitempty"`
+	}
+
+	// Creator is the creator of the HTTP Archive.
+	Creator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	// Entry is an exchanged request and response.
+	Entry struct {
+		StartedDateTime string    `json:"startedDateTime"`
+		Time            float64   `json:"time"`
+		Request         *Request  `json:"request"`
+		Response        *Response `json:"response"`
+		Cache           struct{}  `json:"cache"`
+		Timings         *Timings  `json:"timings"`
+		Comment         string    `json:"comment,omitempty"`
+
+		// Backend is the pipeline handled the request, it is a custom
+		// field, so it is prefixed with an underscore.
+		Backend string `json:"_backend,omitempty"`
+	}
+
+	// NameValue is a name value pair, used by headers, queries and etc.
+	NameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	// Request is the request of an entry.
+	Request struct {
+		Method      string       `json:"method"`
+		URL         string       `json:"url"`
+		HTTPVersion string       `json:"httpVersion"`
+		Cookies     []*NameValue `json:"cookies"`
+		Headers     []*NameValue `json:"headers"`
+		QueryString []*NameValue `json:"queryString"`
+		PostData    *PostData    `json:"postData,omitempty"`
+		HeadersSize int64        `json:"headersSize"`
+		BodySize    int64        `json:"bodySize"`
+		Comment     string       `json:"comment,omitempty"`
+	}
+
+	// PostData is the body of a request.
+	PostData struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		// Encoding is a custom field, the same as the one of Content.
+		Encoding string `json:"_encoding,omitempty"`
+		// Truncated is a custom field, it is true if the text is only
+		// the beginning of the body.
+		Truncated bool `json:"_truncated,omitempty"`
+	}
+
+	// Response is the response of an entry.
+	Response struct {
+		Status      int          `json:"status"`
+		StatusText  string       `json:"statusText"`
+		HTTPVersion string       `json:"httpVersion"`
+		Cookies     []*NameValue `json:"cookies"`
+		Headers     []*NameValue `json:"headers"`
+		Content     *Content     `json:"content"`
+		RedirectURL string       `json:"redirectURL"`
+		HeadersSize int64        `json:"headersSize"`
+		BodySize    int64        `json:"bodySize"`
+		Comment     string       `json:"comment,omitempty"`
+	}
+
+	// Content is the body of a response.
+	Content struct {
+		Size     int64  `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding,omitempty"`
+		// Truncated is a custom field, the same as the one of PostData.
+		Truncated bool `json:"_truncated,omitempty"`
+	}
+
+	// Timings is the timings of an entry, -1 means not available.
+	Timings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+
+	// CompareOptions is the options to compare responses.
+	CompareOptions struct {
+		// Headers are the headers to compare, others are ignored.
+		Headers []string
+		// IgnoreBody skips the comparison of bodies.
+		IgnoreBody bool
+	}
+)
+
+// New creates an HTTP Archive.
+func New(creator, version string) *HAR {
+	return &HAR{
+		Log: &Log{
+			Version: Version,
+			Creator: &Creator{Name: creator, Version: version},
+			Entries: []*Entry{},
+		},
+	}
+}
+
+// Load loads an HTTP Archive from JSON or YAML data.
+func Load(data []byte) (*HAR, error) {
+	h := &HAR{}
+	if err := codectool.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("unmarshal har failed: %v", err)
+	}
+	if h.Log == nil {
+		return nil, fmt.Errorf("log of har is empty")
+	}
+	return h, nil
+}
+
+// NameValues converts headers to name value pairs, the names are sorted,
+// and values in redact are replaced by [REDACTED].
+func NameValues(header http.Header, redact map[string]struct{}) []*NameValue {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nvs := make([]*NameValue, 0, len(header))
+	for _, k := range keys {
+		_, redacted := redact[http.CanonicalHeaderKey(k)]
+		for _, v := range header[k] {
+			if redacted {
+				v = "[REDACTED]"
+			}
+			nvs = append(nvs, &NameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+// Header converts name value pairs to header.
+func Header(nvs []*NameValue) http.Header {
+	header := http.Header{}
+	for _, nv := range nvs {
+		header.Add(nv.Name, nv.Value)
+	}
+	return header
+}
+
+// EncodeBody encodes the body to text, binary bodies are encoded in base64.
+func EncodeBody(body []byte) (text string, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), EncodingBase64
+}
+
+func decodeBody(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(text)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+// Body returns the decoded body of the request.
+func (r *Request) Body() ([]byte, error) {
+	if r.PostData == nil {
+		return nil, nil
+	}
+	return decodeBody(r.PostData.Text, r.PostData.Encoding)
+}
+
+// Body returns the decoded body of the response.
+func (r *Response) Body() ([]byte, error) {
+	if r.Content == nil {
+		return nil, nil
+	}
+	return decodeBody(r.Content.Text, r.Content.Encoding)
+}
+
+// StdRequest builds a standard HTTP request from the request. If target is
+// not empty, the scheme and host of the request URL are replaced by it.
+func (r *Request) StdRequest(target string) (*http.Request, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %v", r.URL, err)
+	}
+
+	if target != "" {
+		t, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s: %v", target, err)
+		}
+		u.Scheme, u.Host = t.Scheme, t.Host
+		u.Path = strings.TrimSuffix(t.Path, "/") + u.Path
+	}
+
+	body, err := r.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	stdr, err := http.NewRequest(r.Method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nv := range r.Headers {
+		if strings.EqualFold(nv.Name, "Host") {
+			stdr.Host = nv.Value
+			continue
+		}
+		stdr.Header.Add(nv.Name, nv.Value)
+	}
+	return stdr, nil
+}
+
+// NewResponse converts a standard HTTP response to a HAR response.
+func NewResponse(resp *http.Response, redact map[string]struct{}) (*Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+
+	text, encoding := EncodeBody(body)
+	return &Response{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []*NameValue{},
+		Headers:     NameValues(resp.Header, redact),
+		Content: &Content{
+			Size:     int64(len(body)),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		},
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}, nil
+}
+
+// Compare compares the actual response with the expected one, and returns
+// the mismatches. JSON bodies are compared semantically.
+func Compare(expected, actual *Response, opts *CompareOptions) []string {
+	if opts == nil {
+		opts = &CompareOptions{}
+	}
+
+	var mismatches []string
+	if expected.Status != actual.Status {
+		mismatches = append(mismatches, fmt.Sprintf("status: expected %d, got %d", expected.Status, actual.Status))
+	}
+
+	eh, ah := Header(expected.Headers), Header(actual.Headers)
+	for _, k := range opts.Headers {
+		ev, av := strings.Join(eh.Values(k), ","), strings.Join(ah.Values(k), ",")
+		if ev != av {
+			mismatches = append(mismatches, fmt.Sprintf("header %s: expected %q, got %q", k, ev, av))
+		}
+	}
+
+	if opts.IgnoreBody {
+		return mismatches
+	}
+
+	eb, err := expected.Body()
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("body: decode expected body failed: %v", err))
+	}
+	ab, err := actual.Body()
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("body: decode actual body failed: %v", err))
+	}
+	if expected.Content != nil && expected.Content.Truncated && len(ab) > len(eb) {
+		ab = ab[:len(eb)]
+	}
+	if !equalBody(eb, ab) {
+		mismatches = append(mismatches, fmt.Sprintf("body: expected %d bytes, got %d bytes, content differs", len(eb), len(ab)))
+	}
+
+	return mismatches
+}
+
+func equalBody(expected, actual []byte) bool {
+	if bytes.Equal(expected, actual) {
+		return true
+	}
+
+	var ej, aj interface{}
+	if codectool.UnmarshalJSON(expected, &ej) != nil {
+		return false
+	}
+	if codectool.UnmarshalJSON(actual, &aj) != nil {
+		return false
+	}
+	return reflect.DeepEqual(ej, aj)
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package har
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBody(t *testing.T) {
+	assert := assert.New(t)
+
+	text, encoding := EncodeBody([]byte("hello"))
+	assert.Equal("hello", text)
+	assert.Equal("", encoding)
+
+	binary := []byte{0xff, 0xfe, 0x00}
+	text, encoding = EncodeBody(binary)
+	assert.Equal(EncodingBase64, encoding)
+
+	resp := &Response{Content: &Content{Text: text, Encoding: encoding}}
+	body, err := resp.Body()
+	assert.Nil(err)
+	assert.Equal(binary, body)
+
+	resp.Content.Encoding = "gzip"
+	_, err = resp.Body()
+	assert.NotNil(err)
+}
+
+func TestStdRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	req := &Request{
+		Method: http.MethodPost,
+		URL:    "http://example.com/api/users?id=1",
+		Headers: []*NameValue{
+			{Name: "Host", Value: "example.com"},
+			{Name: "X-Id", Value: "1"},
+		},
+		PostData: &PostData{Text: "hello"},
+	}
+
+	stdr, err := req.StdRequest("https://127.0.0.1:10080/prefix/")
+	assert.Nil(err)
+	assert.Equal("https://127.0.0.1:10080/prefix/api/users?id=1", stdr.URL.String())
+	assert.Equal("example.com", stdr.Host)
+	assert.Equal("1", stdr.Header.Get("X-Id"))
+	body, _ := io.ReadAll(stdr.Body)
+	assert.Equal("hello", string(body))
+
+	req.URL = ":bad"
+	_, err = req.StdRequest("")
+	assert.NotNil(err)
+}
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := &Response{
+		Status:  200,
+		Headers: []*NameValue{{Name: "Content-Type", Value: "application/json"}},
+		Content: &Content{Text: `{"a": 1, "b": [1, 2]}`},
+	}
+	actual := &Response{
+		Status:  200,
+		Headers: []*NameValue{{Name: "Content-Type", Value: "application/json"}},
+		Content: &Content{Text: `{"b":[1,2],"a":1}`},
+	}
+	opts := &CompareOptions{Headers: []string{"Content-Type"}}
+	assert.Empty(Compare(expected, actual, opts))
+
+	actual.Status = 500
+	actual.Headers = nil
+	actual.Content.Text = "error"
+	assert.Len(Compare(expected, actual, opts), 3)
+	assert.Len(Compare(expected, actual, &CompareOptions{IgnoreBody: true}), 1)
+
+	// truncated body only compares the beginning
+	expected = &Response{Status: 200, Content: &Content{Text: "0123", Truncated: true}}
+	actual = &Response{Status: 200, Content: &Content{Text: "0123456789"}}
+	assert.Empty(Compare(expected, actual, nil))
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Load([]byte(`{}`))
+	assert.NotNil(err)
+
+	h, err := Load([]byte(`
+log:
+  version: "1.2"
+  entries:
+  - request: {method: GET, url: "http://example.com/"}
+    response: {status: 200}
+`))
+	assert.Nil(err)
+	assert.Len(h.Log.Entries, 1)
+	assert.Equal(200, h.Log.Entries[0].Response.Status)
+}
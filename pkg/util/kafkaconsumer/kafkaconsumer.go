@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package kafkaconsumer consumes Kafka topics in a consumer group.
+package kafkaconsumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// RetryInterval is the interval to retry creating the consumer group and
+// consuming the topics.
+const RetryInterval = time.Second
+
+type (
+	// NewGroupFunc creates a consumer group, it's sarama.NewConsumerGroup
+	// except in tests.
+	NewGroupFunc func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error)
+
+	// Consumer consumes topics in a consumer group, and marks the offset
+	// of a message after it's handled, so messages are handled at least
+	// once.
+	Consumer struct {
+		// Name is the name of the owner, it's used in logs.
+		Name    string
+		Brokers []string
+		GroupID string
+		Topics  []string
+		Config  *sarama.Config
+
+		// NewGroup creates the consumer group, it defaults to
+		// sarama.NewConsumerGroup.
+		NewGroup NewGroupFunc
+
+		// HandleMessage handles msg, it returns false if ctx is done
+		// before msg is handled, and the offset of msg is not marked.
+		HandleMessage func(ctx context.Context, msg *sarama.ConsumerMessage) bool
+
+		// SetLag is called with the lag of claim after a message is
+		// marked, and with -1 when the claim is released. It's optional.
+		SetLag func(claim sarama.ConsumerGroupClaim, lag int64)
+	}
+)
+
+var _ sarama.ConsumerGroupHandler = (*Consumer)(nil)
+
+// Run creates the consumer group and consumes the topics until ctx is
+// done, the consumer group is closed before it returns. It retries if the
+// consumer group can't be created, for example, Kafka is unreachable.
+func (c *Consumer) Run(ctx context.Context) {
+	newGroup := c.NewGroup
+	if newGroup == nil {
+		newGroup = sarama.NewConsumerGroup
+	}
+
+	var group sarama.ConsumerGroup
+	for {
+		var err error
+		group, err = newGroup(c.Brokers, c.GroupID, c.Config)
+		if err == nil {
+			break
+		}
+		logger.Errorf("%s: start sarama consumer group with address %v failed: %v", c.Name, c.Brokers, err)
+		if !Wait(ctx) {
+			return
+		}
+	}
+
+	defer func() {
+		if err := group.Close(); err != nil {
+			logger.Errorf("%s: close kafka consumer group failed: %v", c.Name, err)
+		}
+	}()
+	go func() {
+		for err := range group.Errors() {
+			logger.Errorf("%s: sarama consumer group %s failed: %v", c.Name, c.GroupID, err)
+		}
+	}()
+
+	for {
+		// Consume returns when the group rebalances, and it's called
+		// again to join the group.
+		err := group.Consume(ctx, c.Topics, c)
+		if err != nil {
+			logger.Errorf("%s: consume kafka topics %v failed: %v", c.Name, c.Topics, err)
+		}
+		if !Wait(ctx) {
+			return
+		}
+	}
+}
+
+// Wait waits for RetryInterval, it returns false if ctx is done before
+// that.
+func Wait(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(RetryInterval):
+		return true
+	}
+}
+
+// Setup is run at the beginning of a new session.
+func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session.
+func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim handles the messages of the claim one by one.
+func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if c.SetLag != nil {
+		// the partition may be claimed by another member after rebalance.
+		defer c.SetLag(claim, -1)
+	}
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !c.HandleMessage(session.Context(), msg) {
+				return nil
+			}
+			session.MarkMessage(msg, "")
+			if c.SetLag != nil {
+				c.SetLag(claim, claim.HighWaterMarkOffset()-msg.Offset-1)
+			}
+		}
+	}
+}
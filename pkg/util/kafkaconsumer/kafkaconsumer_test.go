@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafkaconsumer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+type mockConsumerGroup struct {
+	sarama.ConsumerGroup
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+	closed   chan struct{}
+}
+
+func (m *mockConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	session := &mockSession{ctx: ctx}
+	claim := &mockClaim{messages: m.messages}
+	return handler.ConsumeClaim(session, claim)
+}
+
+func (m *mockConsumerGroup) Errors() <-chan error {
+	return m.errors
+}
+
+func (m *mockConsumerGroup) Close() error {
+	close(m.errors)
+	close(m.closed)
+	return nil
+}
+
+type mockSession struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+}
+
+func (m *mockSession) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {}
+
+type mockClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (m *mockClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return m.messages
+}
+
+func (m *mockClaim) HighWaterMarkOffset() int64 {
+	return 10
+}
+
+func TestConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	group := &mockConsumerGroup{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+	attempts := 0
+	handled := make(chan int64, 10)
+	lags := make(chan int64, 10)
+	c := &Consumer{
+		Name:    "test",
+		GroupID: "group",
+		Topics:  []string{"topic"},
+		NewGroup: func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+			// the first attempt fails and is retried
+			attempts++
+			if attempts == 1 {
+				return nil, fmt.Errorf("kafka unreachable")
+			}
+			return group, nil
+		},
+		HandleMessage: func(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+			handled <- msg.Offset
+			return true
+		},
+		SetLag: func(claim sarama.ConsumerGroupClaim, lag int64) {
+			lags <- lag
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	group.errors <- fmt.Errorf("consumer group error")
+	group.messages <- &sarama.ConsumerMessage{Offset: 3}
+	assert.Equal(int64(3), <-handled)
+	assert.Equal(int64(6), <-lags)
+	assert.Equal(2, attempts)
+
+	// the group is closed when ctx is done, and the claim is released
+	cancel()
+	<-done
+	<-group.closed
+	assert.Equal(int64(-1), <-lags)
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &Consumer{
+		NewGroup: func(addrs []string, groupID string, config *sarama.Config) (sarama.ConsumerGroup, error) {
+			return nil, fmt.Errorf("kafka unreachable")
+		},
+	}
+	// Run returns if ctx is done before the group is created
+	c.Run(ctx)
+	assert.False(t, Wait(ctx))
+}